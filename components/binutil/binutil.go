@@ -8,6 +8,7 @@ import (
 	"os"
 
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
@@ -28,9 +29,44 @@ func SetupPprofServer(ip string, port int) {
 	}()
 }
 
-func SetupGWLog(logLevel string, logFile string, logStderr bool) {
+func SetupMetricsServer(ip string, port int) {
+	if port == 0 {
+		// metrics are only available on pprof server
+		gwlog.Info("metrics server not enabled")
+		return
+	}
+
+	metricsHost := fmt.Sprintf("%s:%d", ip, port)
+	gwlog.Info("metrics server listening on http://%s/metrics ...", metricsHost)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.ServeHTTP)
+	go func() {
+		err := http.ListenAndServe(metricsHost, mux)
+		gwlog.Error("metrics server quited: %s", err)
+	}()
+}
+
+// Set the log level of gwlog, which can be changed at runtime
+func SetLogLevel(logLevel string) {
 	gwlog.Info("Set log level to %s", logLevel)
 	gwlog.SetLevel(gwlog.StringToLevel(logLevel))
+}
+
+// Set levels of log modules like "aoi=warn,kvdb=error", which can be changed at runtime
+func SetLogModuleLevels(logModuleLevels string) {
+	levels, err := gwlog.ParseModuleLevels(logModuleLevels)
+	if err != nil {
+		gwlog.Error("Invalid log module levels %s: %s", logModuleLevels, err)
+		return
+	}
+	gwlog.Info("Set log module levels to %v", levels)
+	gwlog.SetModuleLevels(levels)
+}
+
+func SetupGWLog(logLevel string, logFormat string, logFile string, logStderr bool) {
+	SetLogLevel(logLevel)
+	gwlog.SetFormat(logFormat)
 
 	outputWriters := make([]io.Writer, 0, 2)
 	if logFile != "" {
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/admin"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+)
+
+const (
+	_QUERY_TIMEOUT  = consts.ADMIN_REQUEST_TIMEOUT + time.Second
+	_ACTION_TIMEOUT = consts.CLUSTER_SAVE_POINT_TIMEOUT + consts.ADMIN_REQUEST_TIMEOUT + time.Second // cluster save points take long
+)
+
+var (
+	queryClient  = &http.Client{Timeout: _QUERY_TIMEOUT}
+	actionClient = &http.Client{Timeout: _ACTION_TIMEOUT}
+)
+
+// Admin API of a process in the cluster
+type adminAPI struct {
+	addr  string // empty if admin is not enabled
+	token string
+}
+
+func newAdminAPI(ip string, port int, token string) adminAPI {
+	if port == 0 {
+		return adminAPI{}
+	}
+	if ip == "" || ip == "0.0.0.0" {
+		ip = config.DEFAULT_LOCALHOST_IP
+	}
+	return adminAPI{addr: fmt.Sprintf("%s:%d", ip, port), token: token}
+}
+
+func (api adminAPI) get(path string, query url.Values, result interface{}) error {
+	return api.request(queryClient, http.MethodGet, path, query, result)
+}
+
+func (api adminAPI) post(path string, query url.Values, result interface{}) error {
+	return api.request(actionClient, http.MethodPost, path, query, result)
+}
+
+func (api adminAPI) request(client *http.Client, method string, path string, query url.Values, result interface{}) error {
+	if api.addr == "" {
+		return errors.New("admin is not enabled")
+	}
+
+	u := url.URL{Scheme: "http", Host: api.addr, Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(admin.ADMIN_TOKEN_HEADER, api.token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return errors.Errorf("%s %s: %s %s", method, path, resp.Status, errResp.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+func gameAdminAPI(gameid uint16) (adminAPI, error) {
+	gameConfig := config.GetGame(gameid)
+	if gameConfig == nil {
+		return adminAPI{}, errors.Errorf("game%d is not configured", gameid)
+	}
+	return newAdminAPI(gameConfig.AdminIp, gameConfig.AdminPort, gameConfig.AdminToken), nil
+}
+
+type processStatus struct {
+	ID     uint16
+	Online bool
+	Error  string `json:",omitempty"`
+}
+
+func (ps *processStatus) setError(err error) {
+	if err != nil && ps.Error == "" {
+		ps.Error = err.Error()
+	}
+}
+
+type gameStatus struct {
+	processStatus
+	Entities int
+	Spaces   []json.RawMessage
+	Storage  json.RawMessage
+}
+
+type gateStatus struct {
+	processStatus
+	Clients int
+}
+
+type dispatcherStatus struct {
+	processStatus
+	Routing json.RawMessage
+}
+
+type clusterOverview struct {
+	Time        time.Time
+	Dispatchers []*dispatcherStatus
+	Games       []*gameStatus
+	Gates       []*gateStatus
+}
+
+// Query all processes concurrently, processes failing to reply are offline
+func getClusterOverview() *clusterOverview {
+	cfg := config.Get()
+	overview := &clusterOverview{Time: time.Now()}
+	var wait sync.WaitGroup
+
+	dispids := make([]int, 0, len(cfg.Dispatchers))
+	for dispid := range cfg.Dispatchers {
+		dispids = append(dispids, dispid)
+	}
+	sort.Ints(dispids)
+	for _, dispid := range dispids {
+		dispatcherConfig := cfg.Dispatchers[dispid]
+		status := &dispatcherStatus{processStatus: processStatus{ID: uint16(dispid)}}
+		overview.Dispatchers = append(overview.Dispatchers, status)
+		api := newAdminAPI(dispatcherConfig.AdminIp, dispatcherConfig.AdminPort, dispatcherConfig.AdminToken)
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			err := api.get("/routing", nil, &status.Routing)
+			status.setError(err)
+			status.Online = err == nil
+		}()
+	}
+
+	for _, gameid := range config.GetGameIDs() {
+		status := &gameStatus{processStatus: processStatus{ID: gameid}}
+		overview.Games = append(overview.Games, status)
+		api, _ := gameAdminAPI(gameid)
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			var entities struct {
+				Total int
+			}
+			err := api.get("/entities", url.Values{"limit": {"1"}}, &entities)
+			status.setError(err)
+			status.Online = err == nil
+			if err != nil {
+				return
+			}
+			status.Entities = entities.Total
+			status.setError(api.get("/spaces", nil, &status.Spaces))
+			status.setError(api.get("/storage", nil, &status.Storage))
+		}()
+	}
+
+	for _, gateid := range config.GetGateIDs() {
+		gateConfig := config.GetGate(gateid)
+		status := &gateStatus{processStatus: processStatus{ID: gateid}}
+		overview.Gates = append(overview.Gates, status)
+		api := newAdminAPI(gateConfig.AdminIp, gateConfig.AdminPort, gateConfig.AdminToken)
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			var clients struct {
+				Total int
+			}
+			err := api.get("/clients", url.Values{"limit": {"1"}}, &clients)
+			status.setError(err)
+			status.Online = err == nil
+			status.Clients = clients.Total
+		}()
+	}
+
+	wait.Wait()
+	return overview
+}
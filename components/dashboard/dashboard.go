@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xiaonanln/goworld/components/binutil"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Dashboard serves the web UI of the cluster configured in [dashboard], showing entities, spaces, storage latencies
+// of games, clients of gates and routing stats of dispatchers, which are aggregated from admin APIs of all
+// processes. Games can be freezed and cluster save points can be started from the web UI.
+//
+// Processes without admin_port are shown as offline.
+
+var (
+	configFile = ""
+)
+
+func parseArgs() {
+	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.Parse()
+}
+
+func main() {
+	parseArgs()
+
+	if configFile != "" {
+		config.SetConfigFile(configFile)
+	}
+
+	dashboardConfig := config.GetDashboard()
+	if dashboardConfig.Port == 0 {
+		fmt.Fprintf(os.Stderr, "dashboard port is not configured\n")
+		os.Exit(1)
+	}
+	if dashboardConfig.Token == "" {
+		fmt.Fprintf(os.Stderr, "dashboard token is not configured\n")
+		os.Exit(1)
+	}
+
+	gwlog.SetGlobalField("component", "dashboard")
+	binutil.SetupGWLog(dashboardConfig.LogLevel, dashboardConfig.LogFormat, dashboardConfig.LogFile, dashboardConfig.LogStderr)
+	serveDashboard(dashboardConfig)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	DASHBOARD_TOKEN_HEADER = "X-Dashboard-Token"
+
+	_SPACE_MAP_ENTITIES_LIMIT = 5000
+)
+
+type apiHandler func(r *http.Request) (interface{}, error)
+
+// Serve the web UI and APIs of the dashboard, APIs require the token of dashboard, blocks forever
+func serveDashboard(dashboardConfig *config.DashboardConfig) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", serveUI)
+	mux.HandleFunc("/api/overview", apiHandlerFunc(dashboardConfig.Token, http.MethodGet, apiOverview))
+	mux.HandleFunc("/api/space", apiHandlerFunc(dashboardConfig.Token, http.MethodGet, apiSpaceMap))
+	mux.HandleFunc("/api/freeze", apiHandlerFunc(dashboardConfig.Token, http.MethodPost, apiFreezeGame))
+	mux.HandleFunc("/api/save", apiHandlerFunc(dashboardConfig.Token, http.MethodPost, apiClusterSavePoint))
+
+	host := fmt.Sprintf("%s:%d", dashboardConfig.Ip, dashboardConfig.Port)
+	gwlog.Info("dashboard listening on http://%s/ ...", host)
+	err := http.ListenAndServe(host, mux)
+	gwlog.Fatal("dashboard quited: %s", err)
+}
+
+func serveUI(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardHTML))
+}
+
+func apiHandlerFunc(token string, method string, handler apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": method + " only"})
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(DASHBOARD_TOKEN_HEADER)), []byte(token)) != 1 {
+			gwlog.Warn("dashboard: unauthorized request from %s", r.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		result, err := handler(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func requestGameAdminAPI(r *http.Request) (adminAPI, error) {
+	gameid, err := strconv.Atoi(r.URL.Query().Get("game"))
+	if err != nil || gameid <= 0 {
+		return adminAPI{}, errors.Errorf("invalid game: %s", r.URL.Query().Get("game"))
+	}
+	return gameAdminAPI(uint16(gameid))
+}
+
+func apiOverview(r *http.Request) (interface{}, error) {
+	return getClusterOverview(), nil
+}
+
+// Entities and their positions in the space, for drawing the space map
+func apiSpaceMap(r *http.Request) (interface{}, error) {
+	api, err := requestGameAdminAPI(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entities json.RawMessage
+	query := url.Values{"space": {r.URL.Query().Get("id")}, "limit": {strconv.Itoa(_SPACE_MAP_ENTITIES_LIMIT)}}
+	err = api.get("/entities", query, &entities)
+	return entities, err
+}
+
+func apiFreezeGame(r *http.Request) (interface{}, error) {
+	api, err := requestGameAdminAPI(r)
+	if err != nil {
+		return nil, err
+	}
+
+	gwlog.Info("dashboard: freezing game %s requested by %s", r.URL.Query().Get("game"), r.RemoteAddr)
+	var result json.RawMessage
+	err = api.post("/freeze", nil, &result)
+	return result, err
+}
+
+// Start a cluster save point by the game, which saves entities of all games
+func apiClusterSavePoint(r *http.Request) (interface{}, error) {
+	api, err := requestGameAdminAPI(r)
+	if err != nil {
+		return nil, err
+	}
+
+	label := r.URL.Query().Get("label")
+	gwlog.Info("dashboard: cluster save point %s requested by %s", label, r.RemoteAddr)
+	var result json.RawMessage
+	err = api.post("/save", url.Values{"label": {label}}, &result)
+	return result, err
+}
@@ -0,0 +1,196 @@
+package main
+
+// Web UI of the dashboard, which polls /api/overview and draws space maps on canvas
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>GoWorld Dashboard</title>
+<style>
+body { font-family: sans-serif; margin: 20px; color: #222; }
+h2 { margin-top: 28px; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+.offline { color: #b00; }
+.online { color: #080; }
+a { cursor: pointer; color: #06c; }
+#spacemap { border: 1px solid #ccc; background: #fafafa; }
+</style>
+</head>
+<body>
+<h1>GoWorld Dashboard <small id="time"></small></h1>
+<div id="error" class="offline"></div>
+
+<h2>Dispatchers</h2>
+<table id="dispatchers"></table>
+
+<h2>Games</h2>
+<p><button onclick="startSave()">Start cluster save point</button></p>
+<table id="games"></table>
+
+<h2>Gates</h2>
+<table id="gates"></table>
+
+<h2>Space map <small id="spacename"></small></h2>
+<canvas id="spacemap" width="600" height="600"></canvas>
+
+<script>
+var token = localStorage.getItem("goworld_dashboard_token") || "";
+var selectedSpace = null;
+var onlineGames = [];
+
+function api(method, path) {
+	if (!token) {
+		token = prompt("Dashboard token") || "";
+		localStorage.setItem("goworld_dashboard_token", token);
+	}
+	return fetch(path, {method: method, headers: {"X-Dashboard-Token": token}}).then(function (resp) {
+		return resp.json().then(function (data) {
+			if (resp.status == 401) {
+				token = "";
+				localStorage.removeItem("goworld_dashboard_token");
+			}
+			if (!resp.ok) {
+				throw new Error(data.error || resp.statusText);
+			}
+			return data;
+		});
+	});
+}
+
+function esc(s) {
+	return String(s).replace(/[&<>"]/g, function (c) {
+		return {"&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;"}[c];
+	});
+}
+
+function ms(ns) {
+	return (ns / 1e6).toFixed(2) + "ms";
+}
+
+function statusCell(p) {
+	return p.Online ? '<td class="online">online</td>' : '<td class="offline">offline ' + esc(p.Error || "") + '</td>';
+}
+
+function renderTable(id, header, rows) {
+	var html = "<tr>" + header.map(function (h) { return "<th>" + h + "</th>"; }).join("") + "</tr>";
+	document.getElementById(id).innerHTML = html + rows.join("");
+}
+
+function renderDispatchers(dispatchers) {
+	renderTable("dispatchers", ["ID", "Status", "Entities", "Blocked", "Pending calls", "Clients", "Games connected", "Gates connected"],
+		dispatchers.map(function (d) {
+			var r = d.Routing || {};
+			var count = function (list) {
+				return (list || []).filter(function (x) { return x.Connected; }).length + "/" + (list || []).length;
+			};
+			return "<tr><td>" + d.ID + "</td>" + statusCell(d) +
+				"<td>" + (r.Entities || 0) + "</td><td>" + (r.BlockedEntities || 0) + "</td><td>" + (r.PendingCalls || 0) +
+				"</td><td>" + (r.Clients || 0) + "</td><td>" + count(r.Games) + "</td><td>" + count(r.Gates) + "</td></tr>";
+		}));
+}
+
+function renderGames(games) {
+	onlineGames = games.filter(function (g) { return g.Online; }).map(function (g) { return g.ID; });
+	renderTable("games", ["ID", "Status", "Entities", "Spaces", "Storage queue", "Storage latency (avg / max)", ""],
+		games.map(function (g) {
+			var storage = g.Storage || {};
+			var latency = Object.keys(storage.Operations || {}).sort().map(function (op) {
+				var s = storage.Operations[op];
+				return esc(op) + ": " + ms(s.AvgDuration) + " / " + ms(s.MaxDuration) + " x" + s.Count;
+			}).join("<br>");
+			var spaces = (g.Spaces || []).map(function (s) {
+				return '<a onclick="selectSpace(' + g.ID + ", '" + esc(s.ID) + "')\">" + esc(s.ID) + "</a> kind " + s.Kind + ", " + s.Entities + " entities";
+			}).join("<br>");
+			return "<tr><td>" + g.ID + "</td>" + statusCell(g) + "<td>" + g.Entities + "</td><td>" + spaces +
+				"</td><td>" + (storage.QueueLength || 0) + "</td><td>" + latency +
+				'</td><td><button onclick="freezeGame(' + g.ID + ')"' + (g.Online ? "" : " disabled") + ">Freeze</button></td></tr>";
+		}));
+}
+
+function renderGates(gates) {
+	renderTable("gates", ["ID", "Status", "Clients"], gates.map(function (g) {
+		return "<tr><td>" + g.ID + "</td>" + statusCell(g) + "<td>" + g.Clients + "</td></tr>";
+	}));
+}
+
+function refresh() {
+	api("GET", "/api/overview").then(function (overview) {
+		document.getElementById("error").textContent = "";
+		document.getElementById("time").textContent = new Date(overview.Time).toLocaleString();
+		renderDispatchers(overview.Dispatchers || []);
+		renderGames(overview.Games || []);
+		renderGates(overview.Gates || []);
+		if (selectedSpace) {
+			drawSpace();
+		}
+	}).catch(function (err) {
+		document.getElementById("error").textContent = err.message;
+	});
+}
+
+function selectSpace(game, id) {
+	selectedSpace = {game: game, id: id};
+	document.getElementById("spacename").textContent = id + " on game " + game;
+	drawSpace();
+}
+
+function drawSpace() {
+	var space = selectedSpace;
+	api("GET", "/api/space?game=" + space.game + "&id=" + encodeURIComponent(space.id)).then(function (result) {
+		var canvas = document.getElementById("spacemap");
+		var ctx = canvas.getContext("2d");
+		var entities = result.Entities || [];
+		ctx.clearRect(0, 0, canvas.width, canvas.height);
+
+		var minX = Infinity, maxX = -Infinity, minZ = Infinity, maxZ = -Infinity;
+		entities.forEach(function (e) {
+			minX = Math.min(minX, e.Position.X); maxX = Math.max(maxX, e.Position.X);
+			minZ = Math.min(minZ, e.Position.Z); maxZ = Math.max(maxZ, e.Position.Z);
+		});
+		var scale = Math.min((canvas.width - 20) / Math.max(maxX - minX, 1), (canvas.height - 20) / Math.max(maxZ - minZ, 1));
+		entities.forEach(function (e) {
+			ctx.fillStyle = e.Client ? "#d40" : "#06c";
+			ctx.beginPath();
+			ctx.arc(10 + (e.Position.X - minX) * scale, 10 + (e.Position.Z - minZ) * scale, 3, 0, 2 * Math.PI);
+			ctx.fill();
+		});
+		ctx.fillStyle = "#222";
+		ctx.fillText(result.Total + " entities, players in red", 10, canvas.height - 6);
+	}).catch(function (err) {
+		document.getElementById("spacename").textContent = space.id + ": " + err.message;
+	});
+}
+
+function freezeGame(game) {
+	if (!confirm("Freeze game " + game + "? The game quits and should be restarted with -restore.")) {
+		return;
+	}
+	api("POST", "/api/freeze?game=" + game).then(refresh).catch(function (err) {
+		alert("Freeze game " + game + " failed: " + err.message);
+	});
+}
+
+function startSave() {
+	var label = prompt("Label of cluster save point");
+	if (label === null) {
+		return;
+	}
+	if (onlineGames.length == 0) {
+		alert("No game is online");
+		return;
+	}
+	api("POST", "/api/save?game=" + onlineGames[0] + "&label=" + encodeURIComponent(label)).then(function (savePoint) {
+		alert("Cluster save point " + savePoint.id + " finished");
+	}).catch(function (err) {
+		alert("Cluster save point failed: " + err.message);
+	});
+}
+
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
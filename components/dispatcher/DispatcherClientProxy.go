@@ -17,9 +17,11 @@ import (
 
 type DispatcherClientProxy struct {
 	*proto.GoWorldConnection
-	owner  *DispatcherService
-	gameid uint16
-	gateid uint16
+	owner      *DispatcherService
+	gameid     uint16
+	gateid     uint16
+	isStandby  bool // standby dispatcher replicating routing tables
+	isFreezing bool // game is freezing, which is restored instead of taken over by standby
 }
 
 func newDispatcherClientProxy(owner *DispatcherService, _conn net.Conn) *DispatcherClientProxy {
@@ -78,26 +80,44 @@ func (dcp *DispatcherClientProxy) serve() {
 		if consts.DEBUG_PACKETS {
 			gwlog.Debug("%s.RecvPacket: msgtype=%v, payload=%v", dcp, msgtype, pkt.Payload())
 		}
+
+		if !dcp.isMsgTypeAllowed(msgtype) {
+			gwlog.Panicf("%s: msgtype %d is not allowed", dcp, msgtype)
+		}
+		recordPacket(msgtype)
+
 		if msgtype == proto.MT_SYNC_POSITION_YAW_FROM_CLIENT {
 			dcp.owner.HandleSyncPositionYawFromClient(dcp, pkt)
 		} else if msgtype == proto.MT_SYNC_POSITION_YAW_ON_CLIENTS {
 			dcp.owner.HandleSyncPositionYawOnClients(dcp, pkt)
 		} else if msgtype == proto.MT_CALL_ENTITY_METHOD {
 			dcp.owner.HandleCallEntityMethod(dcp, pkt)
-		} else if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
+		} else if proto.IsRedirectToGateProxyMsgType(msgtype) {
 			dcp.owner.HandleDoSomethingOnSpecifiedClient(dcp, pkt)
-		} else if msgtype == proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT {
+		} else if msgtype == proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT || msgtype == proto.MT_CALL_ENTITY_METHOD_FROM_PROTOBUF_CLIENT {
 			dcp.owner.HandleCallEntityMethodFromClient(dcp, pkt)
 		} else if msgtype == proto.MT_MIGRATE_REQUEST {
 			dcp.owner.HandleMigrateRequest(dcp, pkt)
 		} else if msgtype == proto.MT_REAL_MIGRATE {
 			dcp.owner.HandleRealMigrate(dcp, pkt)
+		} else if msgtype == proto.MT_CANCEL_MIGRATE {
+			dcp.owner.HandleCancelMigrate(dcp, pkt)
+		} else if msgtype == proto.MT_MIGRATE_GROUP_REQUEST {
+			dcp.owner.HandleMigrateGroupRequest(dcp, pkt)
+		} else if msgtype == proto.MT_REQUEST_MIGRATE_DATA {
+			dcp.owner.HandleRequestMigrateData(dcp, pkt)
+		} else if msgtype == proto.MT_MIGRATE_DATA {
+			dcp.owner.HandleMigrateData(dcp, pkt)
 		} else if msgtype == proto.MT_CALL_FILTERED_CLIENTS {
 			dcp.owner.HandleCallFilteredClientProxies(dcp, pkt)
 		} else if msgtype == proto.MT_NOTIFY_CLIENT_CONNECTED {
 			dcp.owner.HandleNotifyClientConnected(dcp, pkt)
 		} else if msgtype == proto.MT_NOTIFY_CLIENT_DISCONNECTED {
 			dcp.owner.HandleNotifyClientDisconnected(dcp, pkt)
+		} else if msgtype == proto.MT_NOTIFY_CLIENT_RESUMED {
+			dcp.owner.HandleNotifyClientResumed(dcp, pkt)
+		} else if msgtype >= proto.MT_TRANSFER_CLIENT_SESSION && msgtype <= proto.MT_FORWARD_CLIENT_PACKET {
+			dcp.owner.HandleRedirectToGate(dcp, pkt)
 		} else if msgtype == proto.MT_LOAD_ENTITY_ANYWHERE {
 			dcp.owner.HandleLoadEntityAnywhere(dcp, pkt)
 		} else if msgtype == proto.MT_NOTIFY_CREATE_ENTITY {
@@ -106,8 +126,16 @@ func (dcp *DispatcherClientProxy) serve() {
 		} else if msgtype == proto.MT_NOTIFY_DESTROY_ENTITY {
 			eid := pkt.ReadEntityID()
 			dcp.owner.HandleNotifyDestroyEntity(dcp, pkt, eid)
+		} else if msgtype == proto.MT_CLAIM_ENTITY {
+			dcp.owner.HandleClaimEntity(dcp, pkt)
 		} else if msgtype == proto.MT_CREATE_ENTITY_ANYWHERE {
 			dcp.owner.HandleCreateEntityAnywhere(dcp, pkt)
+		} else if msgtype == proto.MT_CREATE_ENTITY_ANYWHERE_ACK {
+			dcp.owner.HandleCreateEntityAnywhereAck(dcp, pkt)
+		} else if msgtype == proto.MT_CALL_ENTITY_METHOD_WITH_RESULT {
+			dcp.owner.HandleCallEntityMethodWithResult(dcp, pkt)
+		} else if msgtype == proto.MT_CALL_ENTITY_METHOD_RESULT {
+			dcp.owner.HandleCallEntityMethodResult(dcp, pkt)
 		} else if msgtype == proto.MT_DECLARE_SERVICE {
 			dcp.owner.HandleDeclareService(dcp, pkt)
 		} else if msgtype == proto.MT_SET_GAME_ID {
@@ -121,6 +149,7 @@ func (dcp *DispatcherClientProxy) serve() {
 			if dcp.gameid > 0 || dcp.gateid > 0 {
 				gwlog.Panicf("already set gameid=%d, gateid=%d", dcp.gameid, dcp.gateid)
 			}
+			dcp.authenticate(proto.AUTH_ROLE_GAME, gameid, pkt)
 			dcp.gameid = gameid
 			dcp.startAutoFlush()
 			dcp.owner.HandleSetGameID(dcp, pkt, gameid, isReconnect, isRestore)
@@ -133,9 +162,35 @@ func (dcp *DispatcherClientProxy) serve() {
 			if dcp.gameid > 0 || dcp.gateid > 0 {
 				gwlog.Panicf("already set gameid=%d, gateid=%d", dcp.gameid, dcp.gateid)
 			}
+			dcp.authenticate(proto.AUTH_ROLE_GATE, gateid, pkt)
 			dcp.gateid = gateid
 			dcp.startAutoFlush()
 			dcp.owner.HandleSetGateID(dcp, pkt, gateid)
+		} else if msgtype == proto.MT_SET_STANDBY_DISPATCHER {
+			dcp.authenticate(proto.AUTH_ROLE_STANDBY, 0, pkt)
+			dcp.isStandby = true
+			dcp.startAutoFlush()
+			dcp.owner.HandleSetStandbyDispatcher(dcp)
+		} else if msgtype == proto.MT_REGISTER_LOGIN {
+			dcp.owner.HandleRegisterLogin(dcp, pkt)
+		} else if msgtype == proto.MT_SET_MAINTENANCE_MODE {
+			dcp.owner.HandleSetMaintenanceMode(dcp, pkt)
+		} else if msgtype == proto.MT_NOTIFY_GWVAR_CHANGE {
+			dcp.owner.HandleNotifyGwvarChange(dcp, pkt)
+		} else if msgtype == proto.MT_REPORT_GAME_LOAD {
+			dcp.owner.HandleReportGameLoad(dcp, pkt)
+		} else if msgtype == proto.MT_REPORT_GAME_STATS {
+			dcp.owner.HandleReportGameStats(dcp, pkt)
+		} else if msgtype == proto.MT_REPLICATE_GAME_ENTITY {
+			dcp.owner.HandleReplicateGameEntity(dcp, pkt)
+		} else if msgtype == proto.MT_START_CLUSTER_SAVE_POINT {
+			dcp.owner.HandleStartClusterSavePoint(dcp, pkt)
+		} else if msgtype == proto.MT_CLUSTER_SAVE_POINT_PREPARE_ACK {
+			dcp.owner.HandleClusterSavePointPrepareAck(dcp, pkt)
+		} else if msgtype == proto.MT_CLUSTER_SAVE_POINT_COMMIT_ACK {
+			dcp.owner.HandleClusterSavePointCommitAck(dcp, pkt)
+		} else if msgtype == proto.MT_FINISH_CLUSTER_SAVE_POINT {
+			dcp.owner.HandleFinishClusterSavePoint(dcp, pkt)
 		} else if msgtype == proto.MT_START_FREEZE_GAME {
 			// freeze the game
 			dcp.owner.HandleStartFreezeGame(dcp, pkt)
@@ -155,6 +210,8 @@ func (dcp *DispatcherClientProxy) String() string {
 		return fmt.Sprintf("DispatcherClientProxy<game%d|%s>", dcp.gameid, dcp.RemoteAddr())
 	} else if dcp.gateid > 0 {
 		return fmt.Sprintf("DispatcherClientProxy<gate%d|%s>", dcp.gateid, dcp.RemoteAddr())
+	} else if dcp.isStandby {
+		return fmt.Sprintf("DispatcherClientProxy<standby|%s>", dcp.RemoteAddr())
 	} else {
 		return fmt.Sprintf("DispatcherClientProxy<%s>", dcp.RemoteAddr())
 	}
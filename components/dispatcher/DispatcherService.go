@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 
 	"net"
@@ -9,8 +10,6 @@ import (
 
 	"time"
 
-	"sync/atomic"
-
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
@@ -18,6 +17,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/placement"
 	"github.com/xiaonanln/goworld/engine/proto"
 )
 
@@ -31,6 +31,7 @@ type EntityDispatchInfo struct {
 	gameid             uint16
 	blockUntilTime     time.Time
 	pendingPacketQueue *xnsyncutil.SyncQueue
+	flushLock          sync.Mutex // serialize flushing pending calls by handlers holding the read lock
 }
 
 func newEntityDispatchInfo() *EntityDispatchInfo {
@@ -57,10 +58,14 @@ func (info *EntityDispatchInfo) isBlockingRPC() bool {
 }
 
 type DispatcherService struct {
-	config            *config.DispatcherConfig
-	gameClients       []*DispatcherClientProxy
-	gateClients       []*DispatcherClientProxy
-	chooseClientIndex int64
+	dispid         uint16
+	config         *config.DispatcherConfig
+	tlsConfig      *tls.Config
+	gameClients    []*DispatcherClientProxy
+	gateClients    []*DispatcherClientProxy
+	gameNamespaces []common.Namespace
+	gateNamespaces []common.Namespace
+	hasNamespaces  bool // namespaces are checked only if configured
 
 	entityDispatchInfosLock sync.RWMutex
 	entityDispatchInfos     map[common.EntityID]*EntityDispatchInfo
@@ -71,25 +76,59 @@ type DispatcherService struct {
 	clientsLock        sync.RWMutex
 	targetGameOfClient map[common.ClientID]uint16
 
+	loginSessionsLock sync.Mutex
+	loginSessions     map[string][]loginSession
+	loginKeyOfClient  map[common.ClientID]string
+	maintenance       maintenanceState
+
+	pendingRpcsLock      sync.Mutex
+	pendingRpcs          map[pendingRpcKey]*pendingRpc
+	lastPendingRpcsSweep time.Time
+
+	clusterSaveLock   sync.Mutex
+	clusterSave       *clusterSavePoint // the cluster save point in progress
+	lastClusterSaveID uint32
+
 	entitySyncInfosToGameLock sync.Mutex
 	entitySyncInfosToGame     [][]byte // cache entity sync infos to gates
+
+	isStandby   bool                  // started as standby dispatcher
+	takenOver   xnsyncutil.AtomicBool // standby dispatcher has taken over the primary
+	standbyLock sync.Mutex
+	standbyDcp  *DispatcherClientProxy // the standby dispatcher replicating from this primary
+
+	topology *topologyFeed
+
+	gameLoadsLock   sync.Mutex
+	gameLoads       []placement.GameLoad // load of each game, for choosing games by the placement policy
+	placementPolicy placement.Policy
 }
 
-func newDispatcherService() *DispatcherService {
+func newDispatcherService(dispid uint16, isStandby bool) *DispatcherService {
 	cfg := config.Get()
 	gameCount := len(cfg.Games)
 	gateCount := len(cfg.Gates)
+	gameNamespaces, gateNamespaces, hasNamespaces := readNamespaces()
 	return &DispatcherService{
-		config:            &cfg.Dispatcher,
-		gameClients:       make([]*DispatcherClientProxy, gameCount),
-		gateClients:       make([]*DispatcherClientProxy, gateCount),
-		chooseClientIndex: 0,
+		dispid:         dispid,
+		config:         config.GetDispatcherByID(dispid),
+		gameClients:    make([]*DispatcherClientProxy, gameCount),
+		gateClients:    make([]*DispatcherClientProxy, gateCount),
+		gameNamespaces: gameNamespaces,
+		gateNamespaces: gateNamespaces,
+		hasNamespaces:  hasNamespaces,
 
 		entityDispatchInfos: map[common.EntityID]*EntityDispatchInfo{},
 		registeredServices:  map[string]entity.EntityIDSet{},
 		targetGameOfClient:  map[common.ClientID]uint16{},
+		loginSessions:       map[string][]loginSession{},
+		loginKeyOfClient:    map[common.ClientID]string{},
+		pendingRpcs:         map[pendingRpcKey]*pendingRpc{},
 
 		entitySyncInfosToGame: make([][]byte, gameCount),
+		isStandby:             isStandby,
+		topology:              newTopologyFeed(),
+		gameLoads:             newGameLoads(gameCount),
 	}
 }
 
@@ -124,6 +163,7 @@ func (service *DispatcherService) newEntityDispatcherInfo(entityID common.Entity
 func (service *DispatcherService) delEntityDispatchInfo(entityID common.EntityID) {
 	service.entityDispatchInfosLock.Lock()
 	delete(service.entityDispatchInfos, entityID)
+	service.replicateEntityLocation(entityID, 0)
 	service.entityDispatchInfosLock.Unlock()
 }
 
@@ -156,19 +196,61 @@ func (service *DispatcherService) setEntityDispatcherInfoForWrite(entityID commo
 }
 
 func (service *DispatcherService) String() string {
-	return "DispatcherService"
+	return fmt.Sprintf("DispatcherService<%d>", service.dispid)
+}
+
+// The first dispatcher handles cluster-wide messages (e.g. logins) and messages of clients if there are
+// multiple dispatchers, other dispatchers only route messages of entities sharded to them
+func (service *DispatcherService) isFirstDispatcher() bool {
+	return service.dispid == 1
 }
 
 func (service *DispatcherService) run() {
+	if service.config.IsTLSEnabled() {
+		tlsConfig, err := netutil.NewTLSConfig(service.config.TLSCert, service.config.TLSKey, service.config.TLSCA, true, "")
+		if err != nil {
+			gwlog.Fatal("Setup TLS failed: %s", err)
+		}
+		service.tlsConfig = tlsConfig
+	}
+	placementPolicy, err := placement.NewPolicy(service.config.PlacementPolicy)
+	if err != nil {
+		gwlog.Fatal("Setup placement policy failed: %s", err)
+	}
+	service.placementPolicy = placementPolicy
+
+	service.registerMetrics()
+	service.registerTopologyHandlers()
+	go service.sweepEntityReferencesForever()
+	go service.flushExpiredPendingCallsForever()
+	go service.publishTopologySnapshotsForever()
+
 	host := fmt.Sprintf("%s:%d", service.config.Ip, service.config.Port)
+	if service.isStandby {
+		if !service.config.HasStandby() {
+			gwlog.Fatal("%s: standby_port is not configured for standby dispatcher", service)
+		}
+		host = fmt.Sprintf("%s:%d", service.config.StandbyIp, service.config.StandbyPort)
+		go service.serveAsStandby()
+	}
 	netutil.ServeTCPForever(host, service)
 }
 
 func (service *DispatcherService) ServeTCPConnection(conn net.Conn) {
+	if !service.isAcceptingConnections() {
+		gwlog.Warn("%s: connection from %s is rejected, primary dispatcher is alive", service, conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
 	tcpConn := conn.(*net.TCPConn)
 	tcpConn.SetReadBuffer(consts.DISPATCHER_CLIENT_PROXY_READ_BUFFER_SIZE)
 	tcpConn.SetWriteBuffer(consts.DISPATCHER_CLIENT_PROXY_WRITE_BUFFER_SIZE)
 
+	if service.tlsConfig != nil {
+		conn = tls.Server(conn, service.tlsConfig)
+	}
+
 	client := newDispatcherClientProxy(service, conn)
 	client.serve()
 }
@@ -184,7 +266,7 @@ func (service *DispatcherService) HandleSetGameID(dcp *DispatcherClientProxy, pk
 	olddcp := service.gameClients[gameid-1] // should be nil, unless reconnect
 	service.gameClients[gameid-1] = dcp
 
-	if !isRestore {
+	if !isRestore && !(isReconnect && service.isStandby) && service.isFirstDispatcher() { // games failing over to standby are ready already
 		// notify all games that all games connected to dispatcher now!
 		if service.isAllGameClientsConnected() {
 			pkt.ClearPayload() // reuse this packet
@@ -207,18 +289,22 @@ func (service *DispatcherService) HandleSetGameID(dcp *DispatcherClientProxy, pk
 	if isRestore {
 		gwlog.Debug("Game %d restored: %s", dcp.gameid, dcp)
 	}
+	service.topology.publish(TOPOLOGY_EVENT_GAME_CONNECTED, &topologyIDEvent{gameid})
 
 	return
 }
 
 func (service *DispatcherService) HandleSetGateID(dcp *DispatcherClientProxy, pkt *netutil.Packet, gateid uint16) {
 	service.gateClients[gateid-1] = dcp
+	service.topology.publish(TOPOLOGY_EVENT_GATE_CONNECTED, &topologyIDEvent{gateid})
+	service.sendMaintenanceModeToGate(dcp)
 }
 
 func (service *DispatcherService) HandleStartFreezeGame(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
 	// freeze the game, which block all entities of that game
 	gwlog.Info("Handling start freeze game ...")
 	gameid := dcp.gameid
+	dcp.isFreezing = true
 	service.entityDispatchInfosLock.RLock()
 
 	for _, info := range service.entityDispatchInfos {
@@ -254,24 +340,30 @@ func (service *DispatcherService) dispatcherClientOfGate(gateid uint16) *Dispatc
 	return service.gateClients[gateid-1]
 }
 
-// Choose a dispatcher client for sending Anywhere packets
-func (service *DispatcherService) chooseGameDispatcherClient() *DispatcherClientProxy {
-	index := atomic.LoadInt64(&service.chooseClientIndex)
-	client := service.gameClients[index]
-	atomic.StoreInt64(&service.chooseClientIndex, int64((int(index)+1)%len(service.gameClients)))
-	return client
-}
-
 func (service *DispatcherService) HandleDispatcherClientDisconnect(dcp *DispatcherClientProxy) {
 	// nothing to do when client disconnected
 	gwlog.Warn("%s disconnected", dcp)
-	if dcp.gateid > 0 {
+	if dcp.isStandby {
+		service.handleStandbyDisconnect(dcp)
+	} else if dcp.gateid > 0 {
 		// gate disconnected, notify all clients disconnected
 		service.handleGateDown(dcp.gateid)
+		service.topology.clearGateClients(dcp.gateid)
+		service.topology.publish(TOPOLOGY_EVENT_GATE_DISCONNECTED, &topologyIDEvent{dcp.gateid})
+	} else if dcp.gameid > 0 {
+		service.failPendingRpcsOfGame(dcp.gameid)
+		service.abortClusterSavePoint(0, fmt.Sprintf("game %d disconnected", dcp.gameid))
+		service.scheduleGameTakeover(dcp)
+		service.topology.publish(TOPOLOGY_EVENT_GAME_DISCONNECTED, &topologyIDEvent{dcp.gameid})
 	}
 }
 
 func (service *DispatcherService) handleGateDown(gateid uint16) {
+	if !service.isFirstDispatcher() {
+		return // games are notified by the first dispatcher
+	}
+	service.cleanupLoginSessionsOfGate(gateid)
+
 	pkt := netutil.NewPacket()
 	pkt.AppendUint16(proto.MT_NOTIFY_GATE_DISCONNECTED)
 	pkt.AppendUint16(gateid)
@@ -287,7 +379,14 @@ func (service *DispatcherService) HandleNotifyCreateEntity(dcp *DispatcherClient
 	entityDispatchInfo := service.setEntityDispatcherInfoForWrite(entityID)
 	defer entityDispatchInfo.Unlock()
 
+	if gameid := entityDispatchInfo.gameid; gameid != 0 && gameid != dcp.gameid && service.isGameConnected(gameid) {
+		gwlog.Error("%s.HandleNotifyCreateEntity: %s is created on game %d, but it is already on game %d, rejected", service, entityID, dcp.gameid, gameid)
+		service.sendNotifyCreateEntityRejected(dcp, entityID, gameid)
+		return
+	}
+
 	entityDispatchInfo.gameid = dcp.gameid
+	service.replicateEntityLocation(entityID, dcp.gameid)
 
 	if !entityDispatchInfo.blockUntilTime.IsZero() { // entity is loading, it's done now
 		//gwlog.Info("entity is loaded now, clear loadTime")
@@ -300,16 +399,31 @@ func (service *DispatcherService) HandleNotifyDestroyEntity(dcp *DispatcherClien
 	if consts.DEBUG_PACKETS {
 		gwlog.Debug("%s.HandleNotifyDestroyEntity: dcp=%s, entityID=%s", service, dcp, entityID)
 	}
+	if entityDispatchInfo := service.getEntityDispatcherInfoForRead(entityID); entityDispatchInfo != nil {
+		gameid := entityDispatchInfo.gameid
+		entityDispatchInfo.RUnlock()
+		if gameid != 0 && gameid != dcp.gameid {
+			gwlog.Warn("%s.HandleNotifyDestroyEntity: %s is destroyed on game %d, but it is on game %d, ignored", service, entityID, dcp.gameid, gameid)
+			return
+		}
+	}
 	service.delEntityDispatchInfo(entityID)
+	service.undeclareServicesOfEntity(entityID)
 }
 
 func (service *DispatcherService) HandleNotifyClientConnected(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
 	clientid := pkt.ReadClientID()
-	targetGame := service.chooseGameDispatcherClient()
+	targetGame := service.chooseGameDispatcherClientForGate(dcp.gateid)
+	if targetGame == nil {
+		gwlog.Error("%s.HandleNotifyClientConnected: no game for boot entity of client %s on gate %d", service, clientid, dcp.gateid)
+		return
+	}
 
 	service.clientsLock.Lock()
 	service.targetGameOfClient[clientid] = targetGame.gameid // owner is not determined yet, set to "" as placeholder
+	service.replicateClientTarget(clientid, targetGame.gameid)
 	service.clientsLock.Unlock()
+	service.topology.addGateClients(dcp.gateid, 1)
 
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debug("Target game of client %s is SET to %v on connected", clientid, targetGame.gameid)
@@ -325,17 +439,59 @@ func (service *DispatcherService) HandleNotifyClientDisconnected(dcp *Dispatcher
 	service.clientsLock.Lock()
 	targetSid := service.targetGameOfClient[clientid]
 	delete(service.targetGameOfClient, clientid)
+	service.replicateClientTarget(clientid, 0)
 	service.clientsLock.Unlock()
+	service.topology.addGateClients(dcp.gateid, -1)
+
+	service.delLoginSession(clientid)
 
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debug("Target game of client %s is %v, disconnecting ...", clientid, targetSid)
 	}
 
-	if targetSid != 0 { // if found the owner, tell it
+	if config.GetDispatcherCount() > 1 {
+		// target game is changed by migrations routed by other dispatchers, so tell all games
+		service.broadcastToGameClients(pkt)
+	} else if targetSid != 0 { // if found the owner, tell it
 		service.dispatcherClientOfGame(targetSid).SendPacket(pkt) // tell the game that the client is down
 	}
 }
 
+// The client disconnected from oldGate is reattached to its owner entity on newGate, tell the owner the new gate
+func (service *DispatcherService) HandleNotifyClientResumed(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	clientid := pkt.ReadClientID()
+	oldGate := pkt.ReadUint16()
+	newGate := pkt.ReadUint16()
+
+	service.clientsLock.RLock()
+	targetSid := service.targetGameOfClient[clientid]
+	service.clientsLock.RUnlock()
+	if oldGate != newGate {
+		service.topology.addGateClients(oldGate, -1)
+		service.topology.addGateClients(newGate, 1)
+	}
+
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("Target game of client %s is %v, resumed on gate %d", clientid, targetSid, newGate)
+	}
+
+	if config.GetDispatcherCount() > 1 {
+		service.broadcastToGameClients(pkt)
+	} else if targetSid != 0 {
+		service.dispatcherClientOfGame(targetSid).SendPacket(pkt)
+	}
+}
+
+// Redirect the packet between gates, the target gate id is the first field
+func (service *DispatcherService) HandleRedirectToGate(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	gid := pkt.ReadUint16()
+	if gid == 0 || int(gid) > len(service.gateClients) || service.dispatcherClientOfGate(gid) == nil {
+		gwlog.Warn("%s.HandleRedirectToGate: gate %d from %s is not connected", service, gid, dcp)
+		return
+	}
+	service.dispatcherClientOfGate(gid).SendPacket(pkt)
+}
+
 func (service *DispatcherService) HandleLoadEntityAnywhere(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
 	//typeName := pkt.ReadVarStr()
 	//eid := pkt.ReadEntityID()
@@ -343,17 +499,31 @@ func (service *DispatcherService) HandleLoadEntityAnywhere(dcp *DispatcherClient
 		gwlog.Debug("%s.HandleLoadEntityAnywhere: dcp=%s, pkt=%v", service, dcp, pkt.Payload())
 	}
 	eid := pkt.ReadEntityID() // field 1
+	typeName := pkt.ReadVarStr()
+	placement := pkt.ReadVarStr()
+	if !service.checkNamespace(dcp, "load", eid) {
+		return
+	}
 
 	entityDispatchInfo := service.setEntityDispatcherInfoForWrite(eid)
 	defer entityDispatchInfo.Unlock()
 
 	if entityDispatchInfo.gameid == 0 { // entity not loaded, try load now
-		dcp := service.chooseGameDispatcherClient()
+		dcp := service.chooseGameDispatcherClientWithPlacement(eid.Namespace(), placement)
+		if dcp == nil {
+			gwlog.Error("%s.HandleLoadEntityAnywhere: no game matches placement %q for loading %s.%s", service, placement, typeName, eid)
+			return
+		}
 		entityDispatchInfo.gameid = dcp.gameid
+		service.replicateEntityLocation(eid, dcp.gameid)
 		entityDispatchInfo.blockRPC(consts.DISPATCHER_LOAD_TIMEOUT)
 		dcp.SendPacket(pkt)
 	} else {
-		// entity already loaded
+		// entity already loaded or loading, loading again is ignored and the caller is told where the entity is
+		if consts.DEBUG_PACKETS {
+			gwlog.Debug("%s.HandleLoadEntityAnywhere: %s.%s is already on game %d", service, typeName, eid, entityDispatchInfo.gameid)
+		}
+		service.sendNotifyEntityAlreadyLoaded(dcp, eid, entityDispatchInfo.gameid)
 	}
 }
 
@@ -361,7 +531,39 @@ func (service *DispatcherService) HandleCreateEntityAnywhere(dcp *DispatcherClie
 	if consts.DEBUG_PACKETS {
 		gwlog.Debug("%s.HandleCreateEntityAnywhere: dcp=%s, pkt=%s", service, dcp, pkt.Payload())
 	}
-	service.chooseGameDispatcherClient().SendPacket(pkt)
+	placement := pkt.ReadVarStr()
+	targetDcp := service.chooseGameDispatcherClientWithPlacement(service.namespaceOf(dcp), placement)
+	if targetDcp == nil {
+		reqid := pkt.ReadUint32()
+		typeName := pkt.ReadVarStr()
+		gwlog.Error("%s.HandleCreateEntityAnywhere: no game matches placement %q for creating %s", service, placement, typeName)
+		if reqid != 0 {
+			service.sendCreateEntityAnywhereFailed(dcp, reqid, fmt.Sprintf("no game matches placement %q", placement))
+		}
+		return
+	}
+
+	pkt.AppendUint16(dcp.gameid) // append the caller gameid for routing back the ack
+	targetDcp.SendPacket(pkt)
+}
+
+func (service *DispatcherService) HandleCreateEntityAnywhereAck(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	gameid := pkt.ReadUint16()
+	if consts.DEBUG_PACKETS {
+		gwlog.Debug("%s.HandleCreateEntityAnywhereAck: dcp=%s, caller gameid=%d", service, dcp, gameid)
+	}
+
+	if gameid == 0 || int(gameid) > len(service.gameClients) {
+		gwlog.Error("%s.HandleCreateEntityAnywhereAck: invalid gameid: %d", service, gameid)
+		return
+	}
+
+	callerDcp := service.dispatcherClientOfGame(gameid)
+	if callerDcp == nil {
+		gwlog.Warn("%s.HandleCreateEntityAnywhereAck: game %d is not connected", service, gameid)
+		return
+	}
+	callerDcp.SendPacket(pkt)
 }
 
 func (service *DispatcherService) HandleDeclareService(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
@@ -370,9 +572,13 @@ func (service *DispatcherService) HandleDeclareService(dcp *DispatcherClientProx
 	if consts.DEBUG_PACKETS {
 		gwlog.Debug("%s.HandleDeclareService: dcp=%s, entityID=%s, serviceName=%s", service, dcp, entityID, serviceName)
 	}
+	if !service.checkNamespace(dcp, "declare service "+serviceName, entityID) {
+		return
+	}
 
 	entityDispatchInfo := service.setEntityDispatcherInfoForWrite(entityID)
 	entityDispatchInfo.gameid = dcp.gameid
+	service.replicateEntityLocation(entityID, dcp.gameid)
 	entityDispatchInfo.Unlock()
 
 	service.servicesLock.Lock()
@@ -381,11 +587,29 @@ func (service *DispatcherService) HandleDeclareService(dcp *DispatcherClientProx
 	}
 
 	service.registeredServices[serviceName].Add(entityID)
+	service.replicateService(entityID, serviceName, true)
+	pkt.AppendUint16(dcp.gameid) // append the gameid of service provider
 	service.broadcastToGameClients(pkt)
 	service.servicesLock.Unlock()
 }
 
+func (service *DispatcherService) HandleReportGameLoad(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	// broadcast the load to all games for choosing service providers
+	pkt.AppendUint16(dcp.gameid)
+	service.broadcastToGameClients(pkt)
+}
+
+func (service *DispatcherService) HandleNotifyGwvarChange(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	if consts.DEBUG_PACKETS {
+		gwlog.Debug("%s.HandleNotifyGwvarChange: dcp=%s", service, dcp)
+	}
+	// broadcast to all games, including the game which changes it
+	service.broadcastToGameClients(pkt)
+}
+
 func (service *DispatcherService) handleServiceDown(serviceName string, eid common.EntityID) {
+	service.replicateService(eid, serviceName, false)
+
 	pkt := netutil.NewPacket()
 	pkt.AppendUint16(proto.MT_UNDECLARE_SERVICE)
 	pkt.AppendEntityID(eid)
@@ -400,6 +624,9 @@ func (service *DispatcherService) HandleCallEntityMethod(dcp *DispatcherClientPr
 	if consts.DEBUG_PACKETS {
 		gwlog.Debug("%s.HandleCallEntityMethod: dcp=%s, entityID=%s", service, dcp, entityID)
 	}
+	if !service.checkNamespace(dcp, "call", entityID) {
+		return
+	}
 
 	entityDispatchInfo := service.getEntityDispatcherInfoForRead(entityID)
 	if entityDispatchInfo == nil {
@@ -409,19 +636,8 @@ func (service *DispatcherService) HandleCallEntityMethod(dcp *DispatcherClientPr
 	}
 
 	defer entityDispatchInfo.RUnlock()
-
-	if !entityDispatchInfo.isBlockingRPC() {
-		service.dispatcherClientOfGame(entityDispatchInfo.gameid).SendPacket(pkt)
-	} else {
-		// if migrating, just put the call to wait
-		if entityDispatchInfo.pendingPacketQueue.Len() < consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN {
-			pkt.AddRefCount(1)
-			entityDispatchInfo.pendingPacketQueue.Push(callQueueItem{
-				packet: pkt,
-			})
-		} else {
-			gwlog.Error("%s.HandleCallEntityMethod %s: packet queue too long, packet dropped", service, entityID)
-		}
+	if !service.dispatchCallToEntity(entityDispatchInfo, entityID, pkt) {
+		service.sendNotifyCallDropped(dcp, entityID, pkt.ReadVarStr())
 	}
 }
 
@@ -437,6 +653,9 @@ func (service *DispatcherService) HandleSyncPositionYawFromClient(dcp *Dispatche
 
 	for i := 0; i < len(payload); i += (proto.SYNC_INFO_SIZE_PER_ENTITY + common.ENTITYID_LENGTH) {
 		eid := common.EntityID(payload[i : i+common.ENTITYID_LENGTH]) // the first bytes of each entry is the EntityID
+		if !service.checkNamespace(dcp, "sync position", eid) {
+			continue
+		}
 
 		entityDispatchInfo := service.getEntityDispatcherInfoForRead(eid)
 		gameid := entityDispatchInfo.gameid
@@ -464,6 +683,9 @@ func (service *DispatcherService) HandleCallEntityMethodFromClient(dcp *Dispatch
 	if consts.DEBUG_PACKETS {
 		gwlog.Debug("%s.HandleCallEntityMethodFromClient: entityID=%s, payload=%v", service, entityID, pkt.Payload())
 	}
+	if !service.checkNamespace(dcp, "call", entityID) {
+		return
+	}
 
 	entityDispatchInfo := service.getEntityDispatcherInfoForRead(entityID)
 	if entityDispatchInfo == nil {
@@ -472,21 +694,7 @@ func (service *DispatcherService) HandleCallEntityMethodFromClient(dcp *Dispatch
 	}
 
 	defer entityDispatchInfo.RUnlock()
-
-	if !entityDispatchInfo.isBlockingRPC() {
-		service.dispatcherClientOfGame(entityDispatchInfo.gameid).SendPacket(pkt)
-	} else {
-		// if migrating, just put the call to wait
-		if entityDispatchInfo.pendingPacketQueue.Len() < consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN {
-			pkt.AddRefCount(1)
-			entityDispatchInfo.pendingPacketQueue.Push(callQueueItem{
-				packet: pkt,
-			})
-		} else {
-			gwlog.Error("%s.HandleCallEntityMethodFromClient %s: packet queue too long, packet dropped", service, entityID)
-		}
-	}
-
+	service.dispatchCallToEntity(entityDispatchInfo, entityID, pkt) // calls from clients are dropped silently
 }
 
 func (service *DispatcherService) HandleDoSomethingOnSpecifiedClient(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
@@ -513,6 +721,9 @@ func (service *DispatcherService) HandleMigrateRequest(dcp *DispatcherClientProx
 		spaceLoc = spaceDispatchInfo.gameid
 	}
 	spaceDispatchInfo.RUnlock()
+	if !service.checkNamespace(dcp, "migrate to space", spaceID) {
+		spaceLoc = 0 // entities can not migrate to spaces of other namespaces
+	}
 
 	pkt.AppendUint16(spaceLoc) // append the space game location to the packet
 
@@ -526,6 +737,46 @@ func (service *DispatcherService) HandleMigrateRequest(dcp *DispatcherClientProx
 	dcp.SendPacket(pkt)
 }
 
+// Block calls to entities of the group if all of them are on the caller game, entities sharded to other dispatchers
+// are blocked by their dispatchers
+func (service *DispatcherService) HandleMigrateGroupRequest(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	groupID := pkt.ReadUint32()
+	targetGame := pkt.ReadUint16()
+	count := int(pkt.ReadUint16())
+	eids := make([]common.EntityID, count)
+	for i := 0; i < count; i++ {
+		eids[i] = pkt.ReadEntityID()
+	}
+	if consts.DEBUG_PACKETS {
+		gwlog.Debug("%s.HandleMigrateGroupRequest: dcp=%s, group=%d, targetGame=%d, entities=%v", service, dcp, groupID, targetGame, eids)
+	}
+
+	ok := targetGame != dcp.gameid && targetGame > 0 && int(targetGame) <= len(service.gameClients) && service.dispatcherClientOfGame(targetGame) != nil
+	for _, eid := range eids {
+		if !ok {
+			break
+		}
+		entityDispatchInfo := service.getEntityDispatcherInfoForRead(eid)
+		if entityDispatchInfo == nil {
+			ok = false
+			break
+		}
+		ok = entityDispatchInfo.gameid == dcp.gameid && !entityDispatchInfo.isBlockingRPC()
+		entityDispatchInfo.RUnlock()
+	}
+
+	if ok {
+		for _, eid := range eids {
+			entityDispatchInfo := service.setEntityDispatcherInfoForWrite(eid)
+			entityDispatchInfo.blockRPC(consts.DISPATCHER_MIGRATE_TIMEOUT)
+			entityDispatchInfo.Unlock()
+		}
+	}
+
+	pkt.AppendBool(ok)
+	dcp.SendPacket(pkt)
+}
+
 func (service *DispatcherService) HandleRealMigrate(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
 	// get spaceID and make sure it exists
 	eid := pkt.ReadEntityID()
@@ -544,19 +795,34 @@ func (service *DispatcherService) HandleRealMigrate(dcp *DispatcherClientProxy,
 
 	entityDispatchInfo.blockUntilTime = time.Time{} // mark the entity as NOT migrating
 	entityDispatchInfo.gameid = targetGame
+	service.replicateEntityLocation(eid, targetGame)
 	service.clientsLock.Lock()
 	service.targetGameOfClient[clientid] = targetGame // migrating also change target game of client
+	service.replicateClientTarget(clientid, targetGame)
 	service.clientsLock.Unlock()
 
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debug("Target game of client %s is migrated to %v along with owner %s", clientid, targetGame, eid)
 	}
 
+	pkt.AppendUint16(dcp.gameid) // append the source game for caching migrate data
 	service.dispatcherClientOfGame(targetGame).SendPacket(pkt)
+	service.publishMigration(eid, dcp.gameid, targetGame)
 	// send the cached calls to target game
 	service.sendPendingPackets(entityDispatchInfo)
 }
 
+func (service *DispatcherService) HandleRequestMigrateData(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	sourceGame := pkt.ReadUint16()
+	pkt.AppendUint16(dcp.gameid) // append the target game for routing back the migrate data
+	service.dispatcherClientOfGame(sourceGame).SendPacket(pkt)
+}
+
+func (service *DispatcherService) HandleMigrateData(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	targetGame := pkt.ReadUint16()
+	service.dispatcherClientOfGame(targetGame).SendPacket(pkt)
+}
+
 func (service *DispatcherService) sendPendingPackets(entityDispatchInfo *EntityDispatchInfo) {
 	targetGame := entityDispatchInfo.gameid
 	// send the cached calls to target game
@@ -615,6 +881,7 @@ func (service *DispatcherService) cleanupEntitiesOfGame(targetGame uint16) {
 
 	for eid := range cleanEids {
 		delete(service.entityDispatchInfos, eid)
+		service.replicateEntityLocation(eid, 0)
 	}
 
 	gwlog.Info("Game %d is rebooted, %d entities cleaned, undeclare services: %s", targetGame, len(cleanEids), undeclaredServices)
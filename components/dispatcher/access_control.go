@@ -0,0 +1,44 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Message types that gates are allowed to send to dispatcher, all other message types are only allowed for games
+var gateAllowedMsgTypes = map[proto.MsgType_t]bool{
+	proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:           true,
+	proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:          true,
+	proto.MT_CALL_ENTITY_METHOD_FROM_PROTOBUF_CLIENT: true,
+	proto.MT_NOTIFY_CLIENT_CONNECTED:                 true,
+	proto.MT_NOTIFY_CLIENT_DISCONNECTED:              true,
+	proto.MT_NOTIFY_CLIENT_RESUMED:                   true,
+	proto.MT_TRANSFER_CLIENT_SESSION:                 true,
+	proto.MT_TRANSFER_CLIENT_SESSION_ACK:             true,
+	proto.MT_FORWARD_CLIENT_PACKET:                   true,
+}
+
+// Check if the dispatcher client is allowed to send the message type according to its role
+func (dcp *DispatcherClientProxy) isMsgTypeAllowed(msgtype proto.MsgType_t) bool {
+	if msgtype == proto.MT_SET_GAME_ID || msgtype == proto.MT_SET_GATE_ID || msgtype == proto.MT_SET_STANDBY_DISPATCHER {
+		return dcp.gameid == 0 && dcp.gateid == 0 && !dcp.isStandby
+	}
+
+	if dcp.gateid > 0 {
+		return gateAllowedMsgTypes[msgtype]
+	} else if dcp.gameid > 0 {
+		return !gateAllowedMsgTypes[msgtype]
+	} else {
+		// must identify as game or gate before sending anything else, standby dispatcher only receives replications
+		return false
+	}
+}
+
+func (dcp *DispatcherClientProxy) authenticate(role string, id uint16, pkt *netutil.Packet) {
+	timestamp := int64(pkt.ReadUint64())
+	token := pkt.ReadVarBytes()
+	if !proto.VerifyAuthToken(dcp.owner.config.Secret, role, id, timestamp, token) {
+		gwlog.Panicf("%s: authentication failed for %s%d", dcp, role, id)
+	}
+}
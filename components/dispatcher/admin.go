@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/admin"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// Admin endpoints of dispatcher, routing tables are read under their locks in HTTP goroutines
+
+type adminGameInfo struct {
+	GameID    uint16
+	Connected bool
+	Entities  int
+	CPU       float64
+}
+
+type adminGateInfo struct {
+	GateID    uint16
+	Connected bool
+}
+
+type adminEntityRouting struct {
+	ID           common.EntityID
+	GameID       uint16
+	Blocked      bool
+	PendingCalls int
+}
+
+func (service *DispatcherService) setupAdmin(dispatcherConfig *config.DispatcherConfig) {
+	admin.Handle("/routing", service.adminRoutingStats)
+	admin.Handle("/entity", service.adminEntityRouting)
+	admin.Handle("/services", service.adminListServices)
+	admin.Serve(dispatcherConfig.AdminIp, dispatcherConfig.AdminPort, dispatcherConfig.AdminToken)
+}
+
+// Show routing stats of entities, clients, games and gates
+func (service *DispatcherService) adminRoutingStats(query url.Values) (interface{}, error) {
+	entityCount, blockedCount, pendingCalls := 0, 0, 0
+	service.entityDispatchInfosLock.RLock()
+	entityCount = len(service.entityDispatchInfos)
+	for _, info := range service.entityDispatchInfos {
+		info.RLock()
+		if info.isBlockingRPC() {
+			blockedCount += 1
+		}
+		pendingCalls += info.pendingPacketQueue.Len()
+		info.RUnlock()
+	}
+	service.entityDispatchInfosLock.RUnlock()
+
+	service.clientsLock.RLock()
+	clientCount := len(service.targetGameOfClient)
+	service.clientsLock.RUnlock()
+
+	service.loginSessionsLock.Lock()
+	loginSessionCount := len(service.loginSessions)
+	service.loginSessionsLock.Unlock()
+
+	service.gameLoadsLock.Lock()
+	games := make([]adminGameInfo, len(service.gameLoads))
+	for i, load := range service.gameLoads {
+		games[i] = adminGameInfo{
+			GameID:    load.GameID,
+			Connected: service.dispatcherClientOfGame(load.GameID) != nil,
+			Entities:  load.Entities,
+			CPU:       load.CPU,
+		}
+	}
+	service.gameLoadsLock.Unlock()
+
+	gates := make([]adminGateInfo, len(service.gateClients))
+	for i := range service.gateClients {
+		gates[i] = adminGateInfo{GateID: uint16(i + 1), Connected: service.dispatcherClientOfGate(uint16(i+1)) != nil}
+	}
+
+	return map[string]interface{}{
+		"DispatcherID":    service.dispid,
+		"Entities":        entityCount,
+		"BlockedEntities": blockedCount,
+		"PendingCalls":    pendingCalls,
+		"Clients":         clientCount,
+		"LoginSessions":   loginSessionCount,
+		"Games":           games,
+		"Gates":           gates,
+	}, nil
+}
+
+// Show the game of the entity and its pending calls
+func (service *DispatcherService) adminEntityRouting(query url.Values) (interface{}, error) {
+	eid := common.EntityID(query.Get("id"))
+	info := service.getEntityDispatcherInfoForRead(eid)
+	if info == nil {
+		return nil, admin.ErrNotFound
+	}
+	defer info.RUnlock()
+
+	return &adminEntityRouting{
+		ID:           eid,
+		GameID:       info.gameid,
+		Blocked:      info.isBlockingRPC(),
+		PendingCalls: info.pendingPacketQueue.Len(),
+	}, nil
+}
+
+// List services registered in the dispatcher
+func (service *DispatcherService) adminListServices(query url.Values) (interface{}, error) {
+	services := map[string][]common.EntityID{}
+	service.servicesLock.Lock()
+	for serviceName, eids := range service.registeredServices {
+		services[serviceName] = eids.ToList()
+	}
+	service.servicesLock.Unlock()
+
+	for _, providers := range services {
+		sort.Slice(providers, func(i, j int) bool {
+			return providers[i] < providers[j]
+		})
+	}
+	return services, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Cluster save point saves entities of all games at a mutually consistent point:
+//
+//	1. PREPARE: all games hold cross-game entity calls and ack. Since acks are sent after all previous calls,
+//	   all calls before the save point are dispatched when all acks are received.
+//	2. COMMIT: all games handle calls before the save point, save all entities and ack when saved.
+//	3. The caller game records the marker of save point, then all games are told to FINISH and resume calls.
+//
+// The save point is aborted if any game disconnects or it is not finished in consts.CLUSTER_SAVE_POINT_TIMEOUT.
+
+const (
+	_CLUSTER_SAVE_PREPARING = iota
+	_CLUSTER_SAVE_COMMITTING
+	_CLUSTER_SAVE_MARKING
+)
+
+type clusterSavePoint struct {
+	saveid       uint32
+	label        string
+	callerGame   uint16
+	reqid        uint32
+	phase        int
+	pendingGames map[uint16]bool
+	timeoutTimer *time.Timer
+}
+
+func (service *DispatcherService) HandleStartClusterSavePoint(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	reqid := pkt.ReadUint32()
+	label := pkt.ReadVarStr()
+	gwlog.Info("%s.HandleStartClusterSavePoint: game %d starts cluster save point %s", service, dcp.gameid, label)
+
+	service.clusterSaveLock.Lock()
+	defer service.clusterSaveLock.Unlock()
+
+	if service.clusterSave != nil {
+		service.sendStartClusterSavePointAck(dcp, reqid, 0, "cluster save point "+service.clusterSave.label+" is in progress")
+		return
+	}
+	if !service.isAllGameClientsConnected() {
+		service.sendStartClusterSavePointAck(dcp, reqid, 0, "not all games are connected")
+		return
+	}
+
+	service.lastClusterSaveID += 1
+	saveid := service.lastClusterSaveID
+	service.clusterSave = &clusterSavePoint{
+		saveid:       saveid,
+		label:        label,
+		callerGame:   dcp.gameid,
+		reqid:        reqid,
+		phase:        _CLUSTER_SAVE_PREPARING,
+		pendingGames: service.allGameIDs(),
+		timeoutTimer: time.AfterFunc(consts.CLUSTER_SAVE_POINT_TIMEOUT, func() {
+			service.abortClusterSavePoint(saveid, "timeout")
+		}),
+	}
+	service.broadcastClusterSavePointMessage(proto.MT_CLUSTER_SAVE_POINT_PREPARE, saveid)
+}
+
+func (service *DispatcherService) HandleClusterSavePointPrepareAck(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	saveid := pkt.ReadUint32()
+
+	service.clusterSaveLock.Lock()
+	defer service.clusterSaveLock.Unlock()
+
+	if !service.ackClusterSavePoint(saveid, _CLUSTER_SAVE_PREPARING, dcp.gameid) {
+		return
+	}
+
+	// all games hold calls now, ask them to save entities
+	service.clusterSave.phase = _CLUSTER_SAVE_COMMITTING
+	service.clusterSave.pendingGames = service.allGameIDs()
+	service.broadcastClusterSavePointMessage(proto.MT_CLUSTER_SAVE_POINT_COMMIT, saveid)
+}
+
+func (service *DispatcherService) HandleClusterSavePointCommitAck(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	saveid := pkt.ReadUint32()
+
+	service.clusterSaveLock.Lock()
+	defer service.clusterSaveLock.Unlock()
+
+	if !service.ackClusterSavePoint(saveid, _CLUSTER_SAVE_COMMITTING, dcp.gameid) {
+		return
+	}
+
+	// all entities saved, ask the caller game to record the marker
+	cs := service.clusterSave
+	cs.phase = _CLUSTER_SAVE_MARKING
+	callerDcp := service.dispatcherClientOfGame(cs.callerGame)
+	if callerDcp == nil {
+		service.finishClusterSavePoint()
+		return
+	}
+	service.sendStartClusterSavePointAck(callerDcp, cs.reqid, saveid, "")
+}
+
+func (service *DispatcherService) HandleFinishClusterSavePoint(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	saveid := pkt.ReadUint32()
+
+	service.clusterSaveLock.Lock()
+	defer service.clusterSaveLock.Unlock()
+
+	cs := service.clusterSave
+	if cs == nil || cs.saveid != saveid || cs.phase != _CLUSTER_SAVE_MARKING || cs.callerGame != dcp.gameid {
+		return
+	}
+
+	gwlog.Info("%s: cluster save point %s is finished", service, cs.label)
+	service.finishClusterSavePoint()
+}
+
+// abort the cluster save point in progress, or any cluster save point if saveid is 0
+func (service *DispatcherService) abortClusterSavePoint(saveid uint32, errmsg string) {
+	service.clusterSaveLock.Lock()
+	defer service.clusterSaveLock.Unlock()
+
+	cs := service.clusterSave
+	if cs == nil || (saveid != 0 && cs.saveid != saveid) {
+		return
+	}
+
+	gwlog.Warn("%s: cluster save point %s is aborted: %s", service, cs.label, errmsg)
+	if cs.phase != _CLUSTER_SAVE_MARKING {
+		if callerDcp := service.dispatcherClientOfGame(cs.callerGame); callerDcp != nil {
+			service.sendStartClusterSavePointAck(callerDcp, cs.reqid, cs.saveid, errmsg)
+		}
+	}
+	service.finishClusterSavePoint()
+}
+
+// tell all games to resume calls, must be called with clusterSaveLock locked
+func (service *DispatcherService) finishClusterSavePoint() {
+	cs := service.clusterSave
+	cs.timeoutTimer.Stop()
+	service.clusterSave = nil
+	service.broadcastClusterSavePointMessage(proto.MT_FINISH_CLUSTER_SAVE_POINT, cs.saveid)
+}
+
+// returns true if all games have acked in the phase
+func (service *DispatcherService) ackClusterSavePoint(saveid uint32, phase int, gameid uint16) bool {
+	cs := service.clusterSave
+	if cs == nil || cs.saveid != saveid || cs.phase != phase {
+		return false
+	}
+
+	delete(cs.pendingGames, gameid)
+	return len(cs.pendingGames) == 0
+}
+
+func (service *DispatcherService) allGameIDs() map[uint16]bool {
+	gameids := make(map[uint16]bool, len(service.gameClients))
+	for i := range service.gameClients {
+		gameids[uint16(i+1)] = true
+	}
+	return gameids
+}
+
+func (service *DispatcherService) broadcastClusterSavePointMessage(msgtype proto.MsgType_t, saveid uint32) {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(uint16(msgtype))
+	pkt.AppendUint32(saveid)
+	for _, dcp := range service.gameClients {
+		if dcp != nil { // games might be disconnected when aborting
+			dcp.SendPacket(pkt)
+		}
+	}
+	pkt.Release()
+}
+
+func (service *DispatcherService) sendStartClusterSavePointAck(dcp *DispatcherClientProxy, reqid uint32, saveid uint32, errmsg string) {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(proto.MT_START_CLUSTER_SAVE_POINT_ACK)
+	pkt.AppendUint32(reqid)
+	pkt.AppendUint32(saveid)
+	pkt.AppendVarStr(errmsg)
+	dcp.SendPacket(pkt)
+	pkt.Release()
+}
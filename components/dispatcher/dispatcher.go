@@ -18,6 +18,8 @@ import (
 
 var (
 	configFile = ""
+	dispid     = 1
+	isStandby  = false
 	sigChan    = make(chan os.Signal, 1)
 )
 
@@ -28,6 +30,8 @@ func debuglog(format string, a ...interface{}) {
 
 func parseArgs() {
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.IntVar(&dispid, "dispid", 1, "set dispatcher ID")
+	flag.BoolVar(&isStandby, "standby", false, "run as standby dispatcher")
 	flag.Parse()
 }
 
@@ -38,15 +42,35 @@ func main() {
 		config.SetConfigFile(configFile)
 	}
 
-	dispatcherConfig := config.GetDispatcher()
-	binutil.SetupGWLog(dispatcherConfig.LogLevel, dispatcherConfig.LogFile, dispatcherConfig.LogStderr)
+	dispatcherConfig := config.GetDispatcherByID(uint16(dispid))
+	if dispatcherConfig == nil {
+		fmt.Fprintf(os.Stderr, "dispatcher%d is not configured\n", dispid)
+		os.Exit(1)
+	}
+	gwlog.SetGlobalField("dispid", dispid)
+	binutil.SetupGWLog(dispatcherConfig.LogLevel, dispatcherConfig.LogFormat, dispatcherConfig.LogFile, dispatcherConfig.LogStderr)
+	binutil.SetLogModuleLevels(dispatcherConfig.LogModuleLevels)
 	setupSignals()
+	setupConfigReload()
 	binutil.SetupPprofServer(dispatcherConfig.PProfIp, dispatcherConfig.PProfPort)
+	binutil.SetupMetricsServer(dispatcherConfig.MetricsIp, dispatcherConfig.MetricsPort)
 
-	dispatcher := newDispatcherService()
+	dispatcher := newDispatcherService(uint16(dispid), isStandby)
+	dispatcher.setupAdmin(dispatcherConfig)
 	dispatcher.run()
 }
 
+// Apply changeable settings of dispatcher when config is reloaded
+func setupConfigReload() {
+	config.OnReload(func(cfg *config.GoWorldConfig) {
+		if dispatcherConfig := cfg.Dispatchers[dispid]; dispatcherConfig != nil {
+			binutil.SetLogLevel(dispatcherConfig.LogLevel)
+			binutil.SetLogModuleLevels(dispatcherConfig.LogModuleLevels)
+		}
+	})
+	config.Watch()
+}
+
 func setupSignals() {
 	signal.Ignore(syscall.Signal(10), syscall.Signal(12))
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
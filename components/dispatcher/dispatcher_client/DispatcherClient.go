@@ -12,20 +12,24 @@ import (
 
 type DispatcherClient struct {
 	*proto.GoWorldConnection
+	dispid uint16
 }
 
-func newDispatcherClient(conn net.Conn, autoFlush bool) *DispatcherClient {
+func newDispatcherClient(dispid uint16, conn net.Conn, autoFlush bool) *DispatcherClient {
 	gwc := proto.NewGoWorldConnection(netutil.NewBufferedReadConnection(netutil.NetConnection{conn}), false)
 
 	dc := &DispatcherClient{
 		GoWorldConnection: gwc,
+		dispid:            dispid,
 	}
 	if autoFlush {
 		go func() {
 			defer gwlog.Debug("%s: auto flush routine quited", gwc)
 			for !gwc.IsClosed() {
 				time.Sleep(time.Millisecond * 10)
-				dispatcherClientDelegate.HandleDispatcherClientBeforeFlush()
+				if dispid == 1 { // sync infos are collected before flushing to the first dispatcher only
+					dispatcherClientDelegate.HandleDispatcherClientBeforeFlush()
+				}
 
 				err := gwc.Flush()
 				if err != nil {
@@ -37,6 +41,11 @@ func newDispatcherClient(conn net.Conn, autoFlush bool) *DispatcherClient {
 	return dc
 }
 
+// Get the ID of dispatcher connected
+func (dc *DispatcherClient) GetDispatcherID() uint16 {
+	return dc.dispid
+}
+
 func (dc *DispatcherClient) Close() error {
 	return dc.GoWorldConnection.Close()
 }
@@ -1,6 +1,8 @@
 package dispatcher_client
 
 import (
+	"crypto/tls"
+	"hash/fnv"
 	"time"
 
 	"sync/atomic"
@@ -10,6 +12,7 @@ import (
 	"net"
 
 	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
@@ -21,62 +24,110 @@ const (
 	LOOP_DELAY_ON_DISPATCHER_CLIENT_ERROR = time.Second
 )
 
+// Games and gates connect to all dispatchers. Entities are sharded across dispatchers by hash of EntityID, and
+// messages routed by entity location are sent to the dispatcher of the entity. Cluster-wide messages and messages to
+// clients are sent to the first dispatcher, so that messages to each client are kept in order.
+
+type dispatcherConn struct {
+	dispid            uint16
+	_dispatcherClient *DispatcherClient // DO NOT access it directly
+	isReconnect       bool
+	connectToStandby  bool // connect to standby dispatcher, switched on failures if standby is configured
+	standbyTakenOver  bool // standby dispatcher has accepted this process, so the primary is never connected again
+}
+
 var (
-	isReconnect               = false
-	_dispatcherClient         *DispatcherClient // DO NOT access it directly
+	dispatcherConns           []*dispatcherConn // dispatcher conns by dispid - 1
 	dispatcherClientDelegate  IDispatcherClientDelegate
 	dispatcherClientAutoFlush bool
 	errDispatcherNotConnected = errors.New("dispatcher not connected")
 )
 
-func getDispatcherClient() *DispatcherClient { // atomic
-	addr := (*uintptr)(unsafe.Pointer(&_dispatcherClient))
+func (dconn *dispatcherConn) getDispatcherClient() *DispatcherClient { // atomic
+	addr := (*uintptr)(unsafe.Pointer(&dconn._dispatcherClient))
 	return (*DispatcherClient)(unsafe.Pointer(atomic.LoadUintptr(addr)))
 }
 
-func setDispatcherClient(dc *DispatcherClient) { // atomic
-	addr := (*uintptr)(unsafe.Pointer(&_dispatcherClient))
+func (dconn *dispatcherConn) setDispatcherClient(dc *DispatcherClient) { // atomic
+	addr := (*uintptr)(unsafe.Pointer(&dconn._dispatcherClient))
 	atomic.StoreUintptr(addr, uintptr(unsafe.Pointer(dc)))
 }
 
-func assureConnectedDispatcherClient() *DispatcherClient {
+func (dconn *dispatcherConn) assureConnectedDispatcherClient() *DispatcherClient {
 	var err error
-	dispatcherClient := getDispatcherClient()
+	dispatcherClient := dconn.getDispatcherClient()
 	//gwlog.Debug("assureConnectedDispatcherClient: _dispatcherClient", _dispatcherClient)
 	for dispatcherClient == nil || dispatcherClient.IsClosed() {
-		dispatcherClient, err = connectDispatchClient()
+		dispatcherClient, err = dconn.connectDispatchClient()
 		if err != nil {
-			gwlog.Error("Connect to dispatcher failed: %s", err.Error())
+			gwlog.Error("Connect to dispatcher%d failed: %s", dconn.dispid, err.Error())
+			dconn.failoverDispatcher()
 			time.Sleep(LOOP_DELAY_ON_DISPATCHER_CLIENT_ERROR)
 			continue
 		}
-		dispatcherClientDelegate.OnDispatcherClientConnect(dispatcherClient, isReconnect)
+		dispatcherClientDelegate.OnDispatcherClientConnect(dispatcherClient, dconn.isReconnect)
 
-		setDispatcherClient(dispatcherClient)
-		isReconnect = true
+		dconn.setDispatcherClient(dispatcherClient)
+		dconn.isReconnect = true
 
-		gwlog.Info("dispatcher_client: connected to dispatcher: %s", dispatcherClient)
+		gwlog.Info("dispatcher_client: connected to dispatcher%d: %s", dconn.dispid, dispatcherClient)
 	}
 
 	return dispatcherClient
 }
 
-func connectDispatchClient() (*DispatcherClient, error) {
-	dispatcherConfig := config.GetDispatcher()
-	conn, err := netutil.ConnectTCP(dispatcherConfig.Ip, dispatcherConfig.Port)
+// Switch between primary and standby dispatcher, the standby only accepts connections after taking over the primary
+//
+// Processes stick to the standby once it has taken over, so that they are not split between the two dispatchers
+func (dconn *dispatcherConn) failoverDispatcher() {
+	if !config.GetDispatcherByID(dconn.dispid).HasStandby() || dconn.standbyTakenOver {
+		return
+	}
+
+	dconn.connectToStandby = !dconn.connectToStandby
+	if dconn.connectToStandby {
+		gwlog.Warn("dispatcher_client: failing over to standby of dispatcher%d ...", dconn.dispid)
+	} else {
+		gwlog.Warn("dispatcher_client: failing over to primary of dispatcher%d ...", dconn.dispid)
+	}
+}
+
+func (dconn *dispatcherConn) connectDispatchClient() (*DispatcherClient, error) {
+	dispatcherConfig := config.GetDispatcherByID(dconn.dispid)
+	ip, port := dispatcherConfig.Ip, dispatcherConfig.Port
+	if dconn.connectToStandby {
+		ip, port = dispatcherConfig.StandbyIp, dispatcherConfig.StandbyPort
+	}
+	conn, err := netutil.ConnectTCP(ip, port)
 	if err != nil {
 		return nil, err
 	}
 	tcpConn := conn.(*net.TCPConn)
 	tcpConn.SetReadBuffer(consts.DISPATCHER_CLIENT_READ_BUFFER_SIZE)
 	tcpConn.SetWriteBuffer(consts.DISPATCHER_CLIENT_WRITE_BUFFER_SIZE)
-	return newDispatcherClient(conn, dispatcherClientAutoFlush), nil
+
+	if dispatcherConfig.IsTLSEnabled() {
+		tlsConfig, err := netutil.NewTLSConfig(dispatcherConfig.TLSCert, dispatcherConfig.TLSKey, dispatcherConfig.TLSCA, false, dispatcherConfig.TLSServerName)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "tls handshake failed")
+		}
+		conn = tlsConn
+	}
+
+	return newDispatcherClient(dconn.dispid, conn, dispatcherClientAutoFlush), nil
 }
 
 type IDispatcherClientDelegate interface {
 	OnDispatcherClientConnect(dispatcherClient *DispatcherClient, isReconnect bool)
 	HandleDispatcherClientPacket(msgtype proto.MsgType_t, packet *netutil.Packet)
-	HandleDispatcherClientDisconnect()
+	HandleDispatcherClientDisconnect(dispatcherClient *DispatcherClient)
 	HandleDispatcherClientBeforeFlush()
 	//HandleDeclareService(entityID common.EntityID, serviceName string)
 	//HandleCallEntityMethod(entityID common.EntityID, method string, args []interface{})
@@ -86,20 +137,57 @@ func Initialize(delegate IDispatcherClientDelegate, autoFlush bool) {
 	dispatcherClientDelegate = delegate
 	dispatcherClientAutoFlush = autoFlush
 
-	assureConnectedDispatcherClient()
-	go netutil.ServeForever(serveDispatcherClient) // start the recv routine
+	dispatcherCount := config.GetDispatcherCount()
+	dispatcherConns = make([]*dispatcherConn, dispatcherCount)
+	for i := range dispatcherConns {
+		dconn := &dispatcherConn{dispid: uint16(i + 1)}
+		dispatcherConns[i] = dconn
+		dconn.assureConnectedDispatcherClient()
+		go netutil.ServeForever(dconn.serveDispatcherClient) // start the recv routine
+	}
 }
 
+// Get the client of the first dispatcher, for cluster-wide messages and messages to clients
 func GetDispatcherClientForSend() *DispatcherClient {
-	dispatcherClient := getDispatcherClient()
-	return dispatcherClient
+	return dispatcherConns[0].getDispatcherClient()
+}
+
+// Get the client of the dispatcher which routes messages of the entity
+func GetDispatcherClientForEntity(eid common.EntityID) *DispatcherClient {
+	return dispatcherConns[GetDispatcherIDOfEntity(eid)-1].getDispatcherClient()
+}
+
+// Get clients of all dispatchers, for messages of entities known by all dispatchers (e.g. spaces)
+func GetAllDispatcherClientsForSend() []*DispatcherClient {
+	dispatcherClients := make([]*DispatcherClient, len(dispatcherConns))
+	for i, dconn := range dispatcherConns {
+		dispatcherClients[i] = dconn.getDispatcherClient()
+	}
+	return dispatcherClients
+}
+
+// Get the number of dispatchers
+func GetDispatcherCount() int {
+	return len(dispatcherConns)
+}
+
+// Get the ID of dispatcher which the entity is sharded to
+func GetDispatcherIDOfEntity(eid common.EntityID) uint16 {
+	dispatcherCount := len(dispatcherConns)
+	if dispatcherCount <= 1 {
+		return 1
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(eid))
+	return uint16(h.Sum32()%uint32(dispatcherCount)) + 1
 }
 
 // serve the dispatcher client, receive RESPs from dispatcher and process
-func serveDispatcherClient() {
-	gwlog.Debug("serveDispatcherClient: start serving dispatcher client ...")
+func (dconn *dispatcherConn) serveDispatcherClient() {
+	gwlog.Debug("serveDispatcherClient: start serving dispatcher%d client ...", dconn.dispid)
 	for {
-		dispatcherClient := assureConnectedDispatcherClient()
+		dispatcherClient := dconn.assureConnectedDispatcherClient()
 		var msgtype proto.MsgType_t
 		pkt, err := dispatcherClient.Recv(&msgtype)
 
@@ -110,11 +198,18 @@ func serveDispatcherClient() {
 
 			gwlog.TraceError("serveDispatcherClient: RecvMsgPacket error: %s", err.Error())
 			dispatcherClient.Close()
-			dispatcherClientDelegate.HandleDispatcherClientDisconnect()
+			dispatcherClientDelegate.HandleDispatcherClientDisconnect(dispatcherClient)
+			dconn.failoverDispatcher()
 			time.Sleep(LOOP_DELAY_ON_DISPATCHER_CLIENT_ERROR)
 			continue
 		}
 
+		if dconn.connectToStandby && !dconn.standbyTakenOver {
+			// the standby only sends packets to accepted processes after taking over
+			gwlog.Warn("dispatcher_client: standby of dispatcher%d has taken over", dconn.dispid)
+			dconn.standbyTakenOver = true
+		}
+
 		if consts.DEBUG_PACKETS {
 			gwlog.Debug("%s.RecvPacket: msgtype=%v, payload=%v", dispatcherClient, msgtype, pkt.Payload())
 		}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// An entity can only be live on one game. Entities are bound to games by notifications of entity creation, and the
+// creation of an entity which is bound to another connected game is rejected by MT_NOTIFY_CREATE_ENTITY_REJECTED with
+// the existing location. The rejected game destroys its copy without saving. Games claim entities by MT_CLAIM_ENTITY
+// before loading them by LoadEntityLocally, so an entity is bound to the loading game before it is created, or the
+// game is replied the existing location and the entity is not created at all. Loading an entity which is already
+// loaded or loading by LoadEntityAnywhere is ignored, and the loading game is notified of the existing location by
+// MT_NOTIFY_ENTITY_ALREADY_LOADED. Entities bound to disconnected games can be created by other games, since games
+// failed are taken over by standby games. Destroy notifications from games which the entities are not bound to are
+// ignored, so that the destroyed copy does not unbind the live entity.
+
+var (
+	rejectedCreatesMetric = metrics.NewCounter("goworld_dispatcher_rejected_creates_total",
+		"Number of entity creations rejected since entities are already on other games")
+)
+
+// Returns if the game is connected to the dispatcher
+func (service *DispatcherService) isGameConnected(gameid uint16) bool {
+	dcp := service.dispatcherClientOfGame(gameid)
+	return dcp != nil && !dcp.IsClosed()
+}
+
+func (service *DispatcherService) sendNotifyCreateEntityRejected(dcp *DispatcherClientProxy, entityID common.EntityID, gameid uint16) {
+	rejectedCreatesMetric.Inc()
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(proto.MT_NOTIFY_CREATE_ENTITY_REJECTED)
+	pkt.AppendEntityID(entityID)
+	pkt.AppendUint16(gameid)
+	dcp.SendPacket(pkt)
+	pkt.Release()
+}
+
+func (service *DispatcherService) sendNotifyEntityAlreadyLoaded(dcp *DispatcherClientProxy, entityID common.EntityID, gameid uint16) {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(proto.MT_NOTIFY_ENTITY_ALREADY_LOADED)
+	pkt.AppendEntityID(entityID)
+	pkt.AppendUint16(gameid)
+	dcp.SendPacket(pkt)
+	pkt.Release()
+}
+
+// The game claims the entity before loading it, the entity is bound to the game if it is not on other connected games
+//
+// The claim is echoed with the game which the entity is on, or 0 if the entity is bound to the claiming game. Calls to
+// the entity are blocked until it is created or the load fails.
+func (service *DispatcherService) HandleClaimEntity(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	_ = pkt.ReadUint32() // reqid
+	entityID := pkt.ReadEntityID()
+	if consts.DEBUG_PACKETS {
+		gwlog.Debug("%s.HandleClaimEntity: dcp=%s, entityID=%s", service, dcp, entityID)
+	}
+
+	entityDispatchInfo := service.setEntityDispatcherInfoForWrite(entityID)
+	defer entityDispatchInfo.Unlock()
+
+	if gameid := entityDispatchInfo.gameid; gameid != 0 && gameid != dcp.gameid && service.isGameConnected(gameid) {
+		gwlog.Warn("%s.HandleClaimEntity: %s is claimed by game %d, but it is already on game %d, rejected", service, entityID, dcp.gameid, gameid)
+		rejectedCreatesMetric.Inc()
+		pkt.AppendUint16(gameid)
+		dcp.SendPacket(pkt)
+		return
+	}
+
+	entityDispatchInfo.gameid = dcp.gameid
+	service.replicateEntityLocation(entityID, dcp.gameid)
+	entityDispatchInfo.blockRPC(consts.DISPATCHER_LOAD_TIMEOUT)
+	pkt.AppendUint16(0)
+	dcp.SendPacket(pkt)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+type testDispatcherClient struct {
+	dcp  *DispatcherClientProxy
+	conn net.Conn
+	gwc  *proto.GoWorldConnection
+}
+
+func newTestDispatcherService(gameCount int, gateCount int) *DispatcherService {
+	return &DispatcherService{
+		gameClients:           make([]*DispatcherClientProxy, gameCount),
+		gateClients:           make([]*DispatcherClientProxy, gateCount),
+		entityDispatchInfos:   map[common.EntityID]*EntityDispatchInfo{},
+		registeredServices:    map[string]entity.EntityIDSet{},
+		targetGameOfClient:    map[common.ClientID]uint16{},
+		loginSessions:         map[string][]loginSession{},
+		loginKeyOfClient:      map[common.ClientID]string{},
+		pendingRpcs:           map[pendingRpcKey]*pendingRpc{},
+		entitySyncInfosToGame: make([][]byte, gameCount),
+		topology:              newTopologyFeed(),
+		gameLoads:             newGameLoads(gameCount),
+	}
+}
+
+func connectTestDispatcherClient(t *testing.T, service *DispatcherService) *testDispatcherClient {
+	c1, c2 := net.Pipe()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	return &testDispatcherClient{
+		dcp:  newDispatcherClientProxy(service, c1),
+		conn: c2,
+		gwc:  proto.NewGoWorldConnection(netutil.NewBufferedReadConnection(netutil.NetConnection{c2}), false),
+	}
+}
+
+func connectTestGame(t *testing.T, service *DispatcherService, gameid uint16) *testDispatcherClient {
+	game := connectTestDispatcherClient(t, service)
+	game.dcp.gameid = gameid
+	service.gameClients[gameid-1] = game.dcp
+	return game
+}
+
+// Receive the packet sent by dispatcher to the game or gate
+func (client *testDispatcherClient) recv(t *testing.T) (proto.MsgType_t, *netutil.Packet) {
+	go client.dcp.Flush() // net.Pipe blocks until the packet is read
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	var msgtype proto.MsgType_t
+	pkt, err := client.gwc.Recv(&msgtype)
+	if err != nil {
+		t.Fatalf("%s receives nothing: %s", client.dcp, err)
+	}
+	return msgtype, pkt
+}
+
+func (service *DispatcherService) gameOfEntity(eid common.EntityID) uint16 {
+	info := service.getEntityDispatcherInfoForRead(eid)
+	if info == nil {
+		return 0
+	}
+	defer info.RUnlock()
+	return info.gameid
+}
+
+func newTestPacket(msgtype proto.MsgType_t) *netutil.Packet {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(uint16(msgtype))
+	pkt.ReadUint16() // the message type is read before handling
+	return pkt
+}
+
+func TestHandleClaimEntity(t *testing.T) {
+	service := newTestDispatcherService(2, 0)
+	game1 := connectTestGame(t, service, 1)
+	game2 := connectTestGame(t, service, 2)
+	eid := common.GenEntityID()
+
+	claim := func(game *testDispatcherClient, reqid uint32) uint16 {
+		pkt := newTestPacket(proto.MT_CLAIM_ENTITY)
+		pkt.AppendUint32(reqid)
+		pkt.AppendEntityID(eid)
+		service.HandleClaimEntity(game.dcp, pkt)
+
+		msgtype, reply := game.recv(t)
+		if msgtype != proto.MT_CLAIM_ENTITY {
+			t.Fatalf("claim is replied by %d, should be %d", msgtype, proto.MT_CLAIM_ENTITY)
+		}
+		if r := reply.ReadUint32(); r != reqid {
+			t.Errorf("claim is replied with reqid %d, should be %d", r, reqid)
+		}
+		if id := reply.ReadEntityID(); id != eid {
+			t.Errorf("claim is replied with entity %s, should be %s", id, eid)
+		}
+		return reply.ReadUint16()
+	}
+
+	if gameid := claim(game1, 1); gameid != 0 {
+		t.Fatalf("claim of unbound entity is rejected by game %d", gameid)
+	}
+	if gameid := service.gameOfEntity(eid); gameid != 1 {
+		t.Fatalf("claimed entity is bound to game %d, should be 1", gameid)
+	}
+
+	if gameid := claim(game2, 2); gameid != 1 {
+		t.Fatalf("claim of entity on game 1 is replied with game %d, should be rejected", gameid)
+	}
+	if gameid := service.gameOfEntity(eid); gameid != 1 {
+		t.Fatalf("rejected claim binds entity to game %d", gameid)
+	}
+
+	if gameid := claim(game1, 3); gameid != 0 {
+		t.Fatalf("claim of entity bound to the claiming game is rejected by game %d", gameid)
+	}
+
+	game1.dcp.Close()
+	if gameid := claim(game2, 4); gameid != 0 {
+		t.Fatalf("claim of entity on disconnected game is rejected by game %d", gameid)
+	}
+	if gameid := service.gameOfEntity(eid); gameid != 2 {
+		t.Fatalf("entity on disconnected game is bound to game %d after claim, should be 2", gameid)
+	}
+}
+
+func TestHandleNotifyCreateEntityRejected(t *testing.T) {
+	service := newTestDispatcherService(2, 0)
+	connectTestGame(t, service, 1)
+	game2 := connectTestGame(t, service, 2)
+	eid := common.GenEntityID()
+
+	service.HandleNotifyCreateEntity(service.gameClients[0], newTestPacket(proto.MT_NOTIFY_CREATE_ENTITY), eid)
+	service.HandleNotifyCreateEntity(game2.dcp, newTestPacket(proto.MT_NOTIFY_CREATE_ENTITY), eid)
+
+	msgtype, pkt := game2.recv(t)
+	if msgtype != proto.MT_NOTIFY_CREATE_ENTITY_REJECTED {
+		t.Fatalf("duplicate entity is replied by %d, should be rejected", msgtype)
+	}
+	if id, gameid := pkt.ReadEntityID(), pkt.ReadUint16(); id != eid || gameid != 1 {
+		t.Errorf("rejected with entity %s on game %d, should be %s on game 1", id, gameid, eid)
+	}
+	if gameid := service.gameOfEntity(eid); gameid != 1 {
+		t.Errorf("rejected entity is bound to game %d, should be 1", gameid)
+	}
+}
+
+func TestHandleLoadEntityAnywhereAlreadyLoaded(t *testing.T) {
+	service := newTestDispatcherService(2, 0)
+	game1 := connectTestGame(t, service, 1)
+	game2 := connectTestGame(t, service, 2)
+	eid := common.GenEntityID()
+
+	service.HandleNotifyCreateEntity(game1.dcp, newTestPacket(proto.MT_NOTIFY_CREATE_ENTITY), eid)
+
+	pkt := newTestPacket(proto.MT_LOAD_ENTITY_ANYWHERE)
+	pkt.AppendEntityID(eid)
+	pkt.AppendVarStr("Avatar")
+	pkt.AppendVarStr("")
+	service.HandleLoadEntityAnywhere(game2.dcp, pkt)
+
+	msgtype, reply := game2.recv(t)
+	if msgtype != proto.MT_NOTIFY_ENTITY_ALREADY_LOADED {
+		t.Fatalf("loading loaded entity is replied by %d, should be %d", msgtype, proto.MT_NOTIFY_ENTITY_ALREADY_LOADED)
+	}
+	if id, gameid := reply.ReadEntityID(), reply.ReadUint16(); id != eid || gameid != 1 {
+		t.Errorf("already loaded with entity %s on game %d, should be %s on game 1", id, gameid, eid)
+	}
+	if gameid := service.gameOfEntity(eid); gameid != 1 {
+		t.Errorf("loaded entity is bound to game %d, should be 1", gameid)
+	}
+}
+
+func TestHandleNotifyDestroyEntityIgnored(t *testing.T) {
+	service := newTestDispatcherService(2, 0)
+	game1 := connectTestGame(t, service, 1)
+	game2 := connectTestGame(t, service, 2)
+	eid := common.GenEntityID()
+
+	service.HandleNotifyCreateEntity(game1.dcp, newTestPacket(proto.MT_NOTIFY_CREATE_ENTITY), eid)
+	service.HandleNotifyDestroyEntity(game2.dcp, newTestPacket(proto.MT_NOTIFY_DESTROY_ENTITY), eid)
+	if gameid := service.gameOfEntity(eid); gameid != 1 {
+		t.Fatalf("entity is bound to game %d after destroyed on other game, should be 1", gameid)
+	}
+
+	service.HandleNotifyDestroyEntity(game1.dcp, newTestPacket(proto.MT_NOTIFY_DESTROY_ENTITY), eid)
+	if gameid := service.gameOfEntity(eid); gameid != 0 {
+		t.Fatalf("entity is bound to game %d after destroyed on its game", gameid)
+	}
+}
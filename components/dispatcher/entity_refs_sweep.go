@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+var (
+	staleServiceRefsRemoved uint64 // number of services undeclared by sweeps, for monitoring
+)
+
+// undeclare all services of the destroyed entity and notify all games
+func (service *DispatcherService) undeclareServicesOfEntity(eid common.EntityID) {
+	service.servicesLock.Lock()
+	for serviceName, serviceEids := range service.registeredServices {
+		if serviceEids.Contains(eid) {
+			serviceEids.Del(eid)
+			service.handleServiceDown(serviceName, eid)
+		}
+	}
+	service.servicesLock.Unlock()
+}
+
+func (service *DispatcherService) sweepEntityReferencesForever() {
+	for {
+		time.Sleep(consts.ENTITY_REFS_SWEEP_INTERVAL)
+		service.sweepEntityReferences()
+	}
+}
+
+// undeclare services of entities which are not known by dispatcher, in case destroy notifications are lost
+func (service *DispatcherService) sweepEntityReferences() {
+	service.entityDispatchInfosLock.RLock()
+	defer service.entityDispatchInfosLock.RUnlock()
+
+	service.servicesLock.Lock()
+	defer service.servicesLock.Unlock()
+
+	var removed uint64
+	for serviceName, serviceEids := range service.registeredServices {
+		var staleEids []common.EntityID
+		for eid := range serviceEids {
+			if _, ok := service.entityDispatchInfos[eid]; !ok {
+				staleEids = append(staleEids, eid)
+			}
+		}
+
+		for _, eid := range staleEids {
+			serviceEids.Del(eid)
+			service.handleServiceDown(serviceName, eid)
+		}
+		removed += uint64(len(staleEids))
+
+		if len(serviceEids) == 0 {
+			delete(service.registeredServices, serviceName)
+		}
+	}
+
+	if removed > 0 {
+		total := atomic.AddUint64(&staleServiceRefsRemoved, removed)
+		gwlog.Warn("%s: %d stale service providers undeclared, %d in total", service, removed, total)
+	}
+}
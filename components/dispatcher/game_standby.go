@@ -0,0 +1,78 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Warm standby of games
+//
+// Replicas of entities sent by the primary game are forwarded to its standby games with the primary gameid appended.
+// When the primary game is disconnected and not reconnected in STANDBY_GAME_TAKEOVER_DELAY, clients targeting the
+// primary game are retargeted to the first connected standby game, and the first dispatcher tells the standby game
+// to take over. Games disconnected after freezing are restored from freezed states, so they are never taken over.
+
+func (service *DispatcherService) HandleReplicateGameEntity(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	pkt.AppendUint16(dcp.gameid) // append the primary game for standby games
+	for _, standby := range config.GetStandbyGameIDs(dcp.gameid) {
+		if standbyDcp := service.dispatcherClientOfGame(standby); standbyDcp != nil {
+			standbyDcp.SendPacket(pkt)
+		}
+	}
+}
+
+// Schedule the takeover of disconnected game by its standby game
+func (service *DispatcherService) scheduleGameTakeover(dcp *DispatcherClientProxy) {
+	if dcp.isFreezing || len(config.GetStandbyGameIDs(dcp.gameid)) == 0 {
+		return
+	}
+
+	gwlog.Warn("%s: game %d is taken over by standby if not reconnected in %s", service, dcp.gameid, consts.STANDBY_GAME_TAKEOVER_DELAY)
+	time.AfterFunc(consts.STANDBY_GAME_TAKEOVER_DELAY, func() {
+		service.takeoverGame(dcp)
+	})
+}
+
+func (service *DispatcherService) takeoverGame(dcp *DispatcherClientProxy) {
+	primary := dcp.gameid
+	if service.dispatcherClientOfGame(primary) != dcp {
+		gwlog.Info("%s: game %d is reconnected, not taken over", service, primary)
+		return
+	}
+
+	var standbyDcp *DispatcherClientProxy
+	for _, standby := range config.GetStandbyGameIDs(primary) {
+		if standbyDcp = service.dispatcherClientOfGame(standby); standbyDcp != nil {
+			break
+		}
+	}
+	if standbyDcp == nil {
+		gwlog.Error("%s: game %d failed, but no standby game is connected", service, primary)
+		return
+	}
+
+	service.clientsLock.Lock()
+	clientCount := 0
+	for clientid, gameid := range service.targetGameOfClient {
+		if gameid == primary {
+			service.targetGameOfClient[clientid] = standbyDcp.gameid
+			service.replicateClientTarget(clientid, standbyDcp.gameid)
+			clientCount += 1
+		}
+	}
+	service.clientsLock.Unlock()
+	gwlog.Warn("%s: game %d failed, taken over by standby game %d, %d clients retargeted", service, primary, standbyDcp.gameid, clientCount)
+
+	if service.isFirstDispatcher() {
+		pkt := netutil.NewPacket()
+		pkt.AppendUint16(proto.MT_TAKEOVER_GAME)
+		pkt.AppendUint16(primary)
+		standbyDcp.SendPacket(pkt)
+		pkt.Release()
+	}
+}
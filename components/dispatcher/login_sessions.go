@@ -0,0 +1,173 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+type loginSession struct {
+	clientid common.ClientID
+	gateid   uint16
+}
+
+type maintenanceState struct {
+	enabled   bool
+	message   string
+	allowlist common.StringSet
+}
+
+func (ms *maintenanceState) isLoginAllowed(loginKey string) bool {
+	return !ms.enabled || ms.allowlist.Contains(loginKey)
+}
+
+// Register the login session for the login key, and apply the duplicate-login policy
+func (service *DispatcherService) HandleRegisterLogin(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	reqid := pkt.ReadUint32()
+	loginKey := pkt.ReadVarStr()
+	clientid := pkt.ReadClientID()
+	gateid := pkt.ReadUint16()
+
+	var kicked []loginSession
+	ok := true
+	kickReason, kickMessage := proto.KICK_REASON_DUPLICATE_LOGIN, "logged in from another place"
+
+	service.loginSessionsLock.Lock()
+	if !service.maintenance.isLoginAllowed(loginKey) {
+		// reject the login and let the client know the server is under maintenance
+		ok = false
+		kicked = []loginSession{{clientid: clientid, gateid: gateid}}
+		kickReason, kickMessage = proto.KICK_REASON_MAINTENANCE, service.maintenance.message
+	} else if service.loginKeyOfClient[clientid] != loginKey {
+		service.delLoginSessionLocked(clientid)
+		sessions := service.loginSessions[loginKey]
+
+		if len(sessions) >= service.config.MaxLoginSessions {
+			if service.config.DuplicateLoginPolicy == config.DUPLICATE_LOGIN_POLICY_REJECT_NEW {
+				ok = false
+			} else {
+				// kick the oldest sessions
+				n := len(sessions) - service.config.MaxLoginSessions + 1
+				kicked = sessions[:n]
+				sessions = append([]loginSession(nil), sessions[n:]...)
+				for _, s := range kicked {
+					delete(service.loginKeyOfClient, s.clientid)
+				}
+			}
+		}
+
+		if ok {
+			service.loginSessions[loginKey] = append(sessions, loginSession{clientid: clientid, gateid: gateid})
+			service.loginKeyOfClient[clientid] = loginKey
+		}
+	}
+	service.loginSessionsLock.Unlock()
+
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s.HandleRegisterLogin: loginKey=%s, client=%s@%d, ok=%v, kicked=%v", service, loginKey, clientid, gateid, ok, kicked)
+	}
+
+	service.kickLoginSessions(kicked, kickReason, kickMessage)
+	dcp.SendRegisterLoginAck(reqid, ok)
+}
+
+// Set the cluster-wide maintenance mode, and kick all online sessions not in allowlist
+//
+// The mode is pushed to all gates, which reject connections of authenticated users not in allowlist
+func (service *DispatcherService) HandleSetMaintenanceMode(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	enabled := pkt.ReadBool()
+	message := pkt.ReadVarStr()
+	allowlist := common.StringSet{}
+	for _, loginKey := range pkt.ReadStringList() {
+		allowlist.Add(loginKey)
+	}
+
+	gwlog.Info("%s: set maintenance mode: enabled=%v, message=%s, allowlist=%v", service, enabled, message, allowlist.ToList())
+
+	var kicked []loginSession
+	service.loginSessionsLock.Lock()
+	service.maintenance = maintenanceState{enabled: enabled, message: message, allowlist: allowlist}
+	if enabled {
+		for loginKey, sessions := range service.loginSessions {
+			if !allowlist.Contains(loginKey) {
+				kicked = append(kicked, sessions...)
+			}
+		}
+		for _, s := range kicked {
+			service.delLoginSessionLocked(s.clientid)
+		}
+	}
+	service.loginSessionsLock.Unlock()
+
+	service.kickLoginSessions(kicked, proto.KICK_REASON_MAINTENANCE, message)
+	for _, gate := range service.gateClients {
+		if gate != nil {
+			gate.SendSetMaintenanceMode(enabled, message, allowlist.ToList())
+		}
+	}
+}
+
+// Push the maintenance mode to the gate connected during maintenance
+func (service *DispatcherService) sendMaintenanceModeToGate(gate *DispatcherClientProxy) {
+	service.loginSessionsLock.Lock()
+	maintenance := service.maintenance
+	service.loginSessionsLock.Unlock()
+
+	if maintenance.enabled { // gates are not in maintenance mode when started
+		gate.SendSetMaintenanceMode(true, maintenance.message, maintenance.allowlist.ToList())
+	}
+}
+
+func (service *DispatcherService) kickLoginSessions(sessions []loginSession, reason proto.KickReason, message string) {
+	for _, s := range sessions {
+		gate := service.dispatcherClientOfGate(s.gateid)
+		if gate != nil {
+			gate.SendKickClient(s.gateid, s.clientid, reason, message)
+		}
+	}
+}
+
+func (service *DispatcherService) delLoginSession(clientid common.ClientID) {
+	service.loginSessionsLock.Lock()
+	service.delLoginSessionLocked(clientid)
+	service.loginSessionsLock.Unlock()
+}
+
+func (service *DispatcherService) delLoginSessionLocked(clientid common.ClientID) {
+	loginKey, ok := service.loginKeyOfClient[clientid]
+	if !ok {
+		return
+	}
+
+	delete(service.loginKeyOfClient, clientid)
+	sessions := service.loginSessions[loginKey]
+	for i, s := range sessions {
+		if s.clientid == clientid {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+			break
+		}
+	}
+
+	if len(sessions) > 0 {
+		service.loginSessions[loginKey] = sessions
+	} else {
+		delete(service.loginSessions, loginKey)
+	}
+}
+
+func (service *DispatcherService) cleanupLoginSessionsOfGate(gateid uint16) {
+	service.loginSessionsLock.Lock()
+	for clientid := range service.loginKeyOfClient {
+		loginKey := service.loginKeyOfClient[clientid]
+		for _, s := range service.loginSessions[loginKey] {
+			if s.clientid == clientid && s.gateid == gateid {
+				service.delLoginSessionLocked(clientid)
+				break
+			}
+		}
+	}
+	service.loginSessionsLock.Unlock()
+}
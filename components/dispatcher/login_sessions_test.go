@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func connectTestGate(t *testing.T, service *DispatcherService, gateid uint16) *testDispatcherClient {
+	gate := connectTestDispatcherClient(t, service)
+	gate.dcp.gateid = gateid
+	service.HandleSetGateID(gate.dcp, newTestPacket(proto.MT_SET_GATE_ID), gateid)
+	return gate
+}
+
+func recvMaintenanceMode(t *testing.T, gate *testDispatcherClient) (bool, string, []string) {
+	msgtype, pkt := gate.recv(t)
+	if msgtype != proto.MT_SET_MAINTENANCE_MODE {
+		t.Fatalf("gate receives %d, should be %d", msgtype, proto.MT_SET_MAINTENANCE_MODE)
+	}
+	return pkt.ReadBool(), pkt.ReadVarStr(), pkt.ReadStringList()
+}
+
+func newTestMaintenanceModePacket(enabled bool, message string, allowlist []string) *netutil.Packet {
+	pkt := newTestPacket(proto.MT_SET_MAINTENANCE_MODE)
+	pkt.AppendBool(enabled)
+	pkt.AppendVarStr(message)
+	pkt.AppendStringList(allowlist)
+	return pkt
+}
+
+func TestHandleSetMaintenanceModePushedToGates(t *testing.T) {
+	service := newTestDispatcherService(1, 2)
+	game := connectTestGame(t, service, 1)
+	gate1 := connectTestGate(t, service, 1)
+
+	service.HandleSetMaintenanceMode(game.dcp, newTestMaintenanceModePacket(true, "under maintenance", []string{"admin"}))
+	enabled, message, allowlist := recvMaintenanceMode(t, gate1)
+	if !enabled || message != "under maintenance" || !reflect.DeepEqual(allowlist, []string{"admin"}) {
+		t.Fatalf("gate receives maintenance mode enabled=%v, message=%s, allowlist=%v", enabled, message, allowlist)
+	}
+
+	// gates connected during maintenance should receive the mode
+	gate2 := connectTestGate(t, service, 2)
+	enabled, message, allowlist = recvMaintenanceMode(t, gate2)
+	if !enabled || message != "under maintenance" || !reflect.DeepEqual(allowlist, []string{"admin"}) {
+		t.Fatalf("connected gate receives maintenance mode enabled=%v, message=%s, allowlist=%v", enabled, message, allowlist)
+	}
+
+	service.HandleSetMaintenanceMode(game.dcp, newTestMaintenanceModePacket(false, "", nil))
+	for _, gate := range []*testDispatcherClient{gate1, gate2} {
+		if enabled, _, _ := recvMaintenanceMode(t, gate); enabled {
+			t.Fatalf("%s is still in maintenance mode", gate.dcp)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+var (
+	packetsMetric = metrics.NewCounterVec("goworld_dispatcher_packets_total",
+		"Number of packets received by dispatcher by msgtype", "msgtype")
+)
+
+func recordPacket(msgtype proto.MsgType_t) {
+	packetsMetric.With(strconv.Itoa(int(msgtype))).Inc()
+}
+
+// Register metrics collected from routing tables when scraped
+func (service *DispatcherService) registerMetrics() {
+	metrics.NewGaugeFunc("goworld_dispatcher_entities", "Number of entities routed by dispatcher", func() float64 {
+		service.entityDispatchInfosLock.RLock()
+		defer service.entityDispatchInfosLock.RUnlock()
+		return float64(len(service.entityDispatchInfos))
+	})
+
+	metrics.NewGaugeFunc("goworld_dispatcher_pending_packets", "Number of packets queued for entities which are migrating or loading", func() float64 {
+		service.entityDispatchInfosLock.RLock()
+		defer service.entityDispatchInfosLock.RUnlock()
+		pending := 0
+		for _, info := range service.entityDispatchInfos {
+			pending += info.pendingPacketQueue.Len()
+		}
+		return float64(pending)
+	})
+
+	metrics.NewGaugeFunc("goworld_dispatcher_clients", "Number of clients routed by dispatcher", func() float64 {
+		service.clientsLock.RLock()
+		defer service.clientsLock.RUnlock()
+		return float64(len(service.targetGameOfClient))
+	})
+}
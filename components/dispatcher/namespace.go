@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Namespaces isolate games sharing the dispatchers and gates of one cluster
+//
+// Games and gates belong to namespaces configured by their config sections, and entity IDs carry the namespaces of
+// games creating them. Dispatchers only route calls from games and clients to entities in the same namespace, create
+// and load entities on games of the namespace, and accept services and migrations within the namespace.
+
+// Read namespaces of games and gates from config, hasNamespaces is false if all of them are in the default namespace
+func readNamespaces() (gameNamespaces []common.Namespace, gateNamespaces []common.Namespace, hasNamespaces bool) {
+	cfg := config.Get()
+	gameNamespaces = make([]common.Namespace, len(cfg.Games))
+	for i := range gameNamespaces {
+		gameNamespaces[i] = config.GetGame(uint16(i + 1)).Namespace
+		hasNamespaces = hasNamespaces || gameNamespaces[i] != common.DEFAULT_NAMESPACE
+	}
+	gateNamespaces = make([]common.Namespace, len(cfg.Gates))
+	for i := range gateNamespaces {
+		gateNamespaces[i] = config.GetGate(uint16(i + 1)).Namespace
+		hasNamespaces = hasNamespaces || gateNamespaces[i] != common.DEFAULT_NAMESPACE
+	}
+	return
+}
+
+// Get the namespace of the game or gate
+func (service *DispatcherService) namespaceOf(dcp *DispatcherClientProxy) common.Namespace {
+	if dcp.gateid > 0 {
+		return service.gateNamespaces[dcp.gateid-1]
+	}
+	return service.gameNamespaces[dcp.gameid-1]
+}
+
+// Check if the game or gate is allowed to access the entity, which should be in the same namespace
+func (service *DispatcherService) checkNamespace(dcp *DispatcherClientProxy, op string, eid common.EntityID) bool {
+	if !service.hasNamespaces {
+		return true
+	}
+
+	if ns := service.namespaceOf(dcp); eid.Namespace() != ns {
+		gwlog.Error("%s: %s %s: %s is not allowed to access entities of namespace %d from namespace %d", service, op, eid, dcp, eid.Namespace(), ns)
+		return false
+	}
+	return true
+}
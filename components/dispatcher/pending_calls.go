@@ -0,0 +1,111 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Calls to entities which are migrating or loading are queued in dispatcher, and sent to the new game in order when
+// the migration is done. If the migration is canceled or timeout, queued calls are sent to the current game of entity
+// before any new call, so that no call is lost or reordered.
+//
+// The queue of each entity is bounded by max_pending_calls, calls exceeding it are dropped and the caller game is
+// notified by MT_NOTIFY_CALL_DROPPED.
+
+var (
+	droppedCallsMetric = metrics.NewCounter("goworld_dispatcher_dropped_calls_total",
+		"Number of calls dropped since pending call queues of migrating entities overflow")
+)
+
+// send the call packet to the game of entity, or put the call to wait if the entity is migrating
+func (service *DispatcherService) dispatchCallToEntity(entityDispatchInfo *EntityDispatchInfo, entityID common.EntityID, pkt *netutil.Packet) bool {
+	if entityDispatchInfo.isBlockingRPC() {
+		return service.queueCallToEntity(entityDispatchInfo, entityID, pkt)
+	}
+
+	if entityDispatchInfo.pendingPacketQueue.Len() > 0 {
+		// migration is timeout with calls queued, send them before this call
+		service.flushPendingCalls(entityDispatchInfo)
+	}
+	service.dispatcherClientOfGame(entityDispatchInfo.gameid).SendPacket(pkt)
+	return true
+}
+
+func (service *DispatcherService) queueCallToEntity(entityDispatchInfo *EntityDispatchInfo, entityID common.EntityID, pkt *netutil.Packet) bool {
+	if entityDispatchInfo.pendingPacketQueue.Len() >= service.config.MaxPendingCalls {
+		gwlog.Error("%s: pending call queue of %s is full, call dropped", service, entityID)
+		droppedCallsMetric.Inc()
+		return false
+	}
+
+	pkt.AddRefCount(1)
+	entityDispatchInfo.pendingPacketQueue.Push(callQueueItem{
+		packet: pkt,
+	})
+	return true
+}
+
+// send queued calls to the current game of entity, the entity dispatch info should be locked for reading or writing
+func (service *DispatcherService) flushPendingCalls(entityDispatchInfo *EntityDispatchInfo) {
+	entityDispatchInfo.flushLock.Lock()
+	service.sendPendingPackets(entityDispatchInfo)
+	entityDispatchInfo.flushLock.Unlock()
+}
+
+func (service *DispatcherService) sendNotifyCallDropped(dcp *DispatcherClientProxy, entityID common.EntityID, method string) {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(proto.MT_NOTIFY_CALL_DROPPED)
+	pkt.AppendEntityID(entityID)
+	pkt.AppendVarStr(method)
+	dcp.SendPacket(pkt)
+	pkt.Release()
+}
+
+// The migration is not started by the game after the migrate request is acknowledged
+func (service *DispatcherService) HandleCancelMigrate(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	eid := pkt.ReadEntityID()
+	if consts.DEBUG_PACKETS {
+		gwlog.Debug("%s.HandleCancelMigrate: dcp=%s, entityID=%s", service, dcp, eid)
+	}
+
+	entityDispatchInfo := service.getEntityDispatcherInfoForWrite(eid)
+	if entityDispatchInfo == nil {
+		return
+	}
+	defer entityDispatchInfo.Unlock()
+
+	if entityDispatchInfo.gameid != dcp.gameid {
+		gwlog.Warn("%s.HandleCancelMigrate: %s is on game %d, not %s", service, eid, entityDispatchInfo.gameid, dcp)
+		return
+	}
+	entityDispatchInfo.blockUntilTime = time.Time{}
+	service.sendPendingPackets(entityDispatchInfo)
+}
+
+func (service *DispatcherService) flushExpiredPendingCallsForever() {
+	for {
+		time.Sleep(consts.ENTITY_PENDING_CALLS_FLUSH_INTERVAL)
+		service.flushExpiredPendingCalls()
+	}
+}
+
+// send queued calls of entities which are not migrating or loading any more, in case no new call triggers the flush
+func (service *DispatcherService) flushExpiredPendingCalls() {
+	service.entityDispatchInfosLock.RLock()
+	defer service.entityDispatchInfosLock.RUnlock()
+
+	for eid, info := range service.entityDispatchInfos {
+		info.Lock()
+		if !info.isBlockingRPC() && info.pendingPacketQueue.Len() > 0 {
+			gwlog.Warn("%s: migration or loading of %s is timeout, sending %d pending calls to game %d", service, eid, info.pendingPacketQueue.Len(), info.gameid)
+			service.sendPendingPackets(info)
+		}
+		info.Unlock()
+	}
+}
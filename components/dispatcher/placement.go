@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/placement"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func newGameLoads(gameCount int) []placement.GameLoad {
+	gameLoads := make([]placement.GameLoad, gameCount)
+	for i := range gameLoads {
+		gameLoads[i].GameID = uint16(i + 1)
+	}
+	return gameLoads
+}
+
+// Update the load of game by stats reported by the game
+func (service *DispatcherService) updateGameLoad(gameid uint16, stats *proto.GameStats) {
+	service.gameLoadsLock.Lock()
+	load := &service.gameLoads[gameid-1]
+	load.Entities = stats.Entities
+	load.CPU = stats.CPU
+	service.gameLoadsLock.Unlock()
+}
+
+// Choose a dispatcher client of game in the namespace whose labels match the placement constraint by the placement
+// policy, returns nil if no game matches. Standby games are never chosen.
+func (service *DispatcherService) chooseGameDispatcherClientWithPlacement(ns common.Namespace, constraint string) *DispatcherClientProxy {
+	service.gameLoadsLock.Lock()
+	defer service.gameLoadsLock.Unlock()
+
+	var candidates []placement.GameLoad
+	for i, client := range service.gameClients {
+		gameConfig := config.GetGame(uint16(i + 1))
+		if client == nil || gameConfig.StandbyOf != 0 || gameConfig.Namespace != ns || !common.MatchPlacement(constraint, gameConfig.Labels) {
+			continue
+		}
+		candidates = append(candidates, service.gameLoads[i])
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	gameid := service.placementPolicy.Choose(candidates)
+	if gameid == 0 || int(gameid) > len(service.gameClients) || service.gameClients[gameid-1] == nil {
+		gwlog.Error("%s: placement policy %s chose invalid game %d", service, service.config.PlacementPolicy, gameid)
+		gameid = candidates[0].GameID
+	}
+	service.gameLoads[gameid-1].Entities += 1 // count the entity before the next stats report of the game
+	return service.gameClients[gameid-1]
+}
+
+// Choose a dispatcher client of game for creating boot entities of clients connected to the gate
+func (service *DispatcherService) chooseGameDispatcherClientForGate(gateid uint16) *DispatcherClientProxy {
+	gateConfig := config.GetGate(gateid)
+	placement := gateConfig.BootPlacement
+	if client := service.chooseGameDispatcherClientWithPlacement(gateConfig.Namespace, placement); client != nil {
+		return client
+	}
+
+	gwlog.Error("%s: no game matches boot placement %q of gate %d, choose any game in namespace %d", service, placement, gateid, gateConfig.Namespace)
+	return service.chooseGameDispatcherClientWithPlacement(gateConfig.Namespace, "")
+}
+
+// Reply the caller game that the create entity anywhere request failed
+func (service *DispatcherService) sendCreateEntityAnywhereFailed(dcp *DispatcherClientProxy, reqid uint32, errmsg string) {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(proto.MT_CREATE_ENTITY_ANYWHERE_ACK)
+	pkt.AppendUint16(dcp.gameid)
+	pkt.AppendUint32(reqid)
+	pkt.AppendVarStr(errmsg)
+	dcp.SendPacket(pkt)
+	pkt.Release()
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Calls with results are correlated by the caller gameid and the request ID allocated by the caller.
+//
+// The dispatcher keeps pending calls until results are routed back, so that callers are replied with
+// errors immediately if the target entity is not found or the target game is disconnected.
+
+const (
+	_PENDING_RPCS_SWEEP_INTERVAL = time.Second * 10
+)
+
+type pendingRpcKey struct {
+	gameid uint16 // the caller game
+	reqid  uint32
+}
+
+type pendingRpc struct {
+	targetGame uint16
+	deadline   time.Time
+}
+
+func (service *DispatcherService) HandleCallEntityMethodWithResult(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	entityID := pkt.ReadEntityID()
+	reqid := pkt.ReadUint32()
+	timeout := time.Duration(pkt.ReadUint32()) * time.Millisecond
+
+	if consts.DEBUG_PACKETS {
+		gwlog.Debug("%s.HandleCallEntityMethodWithResult: dcp=%s, entityID=%s, reqid=%d", service, dcp, entityID, reqid)
+	}
+
+	if !service.checkNamespace(dcp, "call", entityID) {
+		service.sendRpcError(dcp, reqid, "entity not in namespace: "+string(entityID))
+		return
+	}
+
+	entityDispatchInfo := service.getEntityDispatcherInfoForRead(entityID)
+	if entityDispatchInfo == nil {
+		service.sendRpcError(dcp, reqid, "entity not found: "+string(entityID))
+		return
+	}
+	defer entityDispatchInfo.RUnlock()
+
+	key := pendingRpcKey{dcp.gameid, reqid}
+	service.addPendingRpc(key, entityDispatchInfo.gameid, timeout)
+
+	pkt.AppendUint16(dcp.gameid) // append the caller gameid for routing back the results
+	if !service.dispatchCallToEntity(entityDispatchInfo, entityID, pkt) {
+		service.delPendingRpc(key)
+		service.sendRpcError(dcp, reqid, "too many pending calls to entity "+string(entityID))
+	}
+}
+
+func (service *DispatcherService) HandleCallEntityMethodResult(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	gameid := pkt.ReadUint16()
+	reqid := pkt.ReadUint32()
+
+	if !service.delPendingRpc(pendingRpcKey{gameid, reqid}) {
+		// the call is already failed or timeout
+		if consts.DEBUG_PACKETS {
+			gwlog.Debug("%s.HandleCallEntityMethodResult: call %d of game %d not found", service, reqid, gameid)
+		}
+		return
+	}
+
+	callerDcp := service.dispatcherClientOfGame(gameid)
+	if callerDcp == nil {
+		gwlog.Warn("%s.HandleCallEntityMethodResult: game %d is not connected", service, gameid)
+		return
+	}
+	callerDcp.SendPacket(pkt)
+}
+
+func (service *DispatcherService) addPendingRpc(key pendingRpcKey, targetGame uint16, timeout time.Duration) {
+	now := time.Now()
+	service.pendingRpcsLock.Lock()
+	service.pendingRpcs[key] = &pendingRpc{targetGame: targetGame, deadline: now.Add(timeout)}
+
+	if now.Sub(service.lastPendingRpcsSweep) >= _PENDING_RPCS_SWEEP_INTERVAL {
+		// callers already timeout, results will be dropped
+		service.lastPendingRpcsSweep = now
+		for k, rpc := range service.pendingRpcs {
+			if now.After(rpc.deadline) {
+				delete(service.pendingRpcs, k)
+			}
+		}
+	}
+	service.pendingRpcsLock.Unlock()
+}
+
+func (service *DispatcherService) delPendingRpc(key pendingRpcKey) bool {
+	service.pendingRpcsLock.Lock()
+	_, ok := service.pendingRpcs[key]
+	delete(service.pendingRpcs, key)
+	service.pendingRpcsLock.Unlock()
+	return ok
+}
+
+// fail all pending calls to the disconnected game, and drop calls from it
+func (service *DispatcherService) failPendingRpcsOfGame(gameid uint16) {
+	var failed []pendingRpcKey
+	service.pendingRpcsLock.Lock()
+	for key, rpc := range service.pendingRpcs {
+		if key.gameid == gameid {
+			delete(service.pendingRpcs, key)
+		} else if rpc.targetGame == gameid {
+			delete(service.pendingRpcs, key)
+			failed = append(failed, key)
+		}
+	}
+	service.pendingRpcsLock.Unlock()
+
+	for _, key := range failed {
+		if callerDcp := service.dispatcherClientOfGame(key.gameid); callerDcp != nil {
+			service.sendRpcError(callerDcp, key.reqid, "target game disconnected")
+		}
+	}
+}
+
+func (service *DispatcherService) sendRpcError(dcp *DispatcherClientProxy, reqid uint32, errmsg string) {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(proto.MT_CALL_ENTITY_METHOD_RESULT)
+	pkt.AppendUint16(dcp.gameid)
+	pkt.AppendUint32(reqid)
+	pkt.AppendVarStr(errmsg)
+	pkt.AppendArgs(nil)
+	dcp.SendPacket(pkt)
+	pkt.Release()
+}
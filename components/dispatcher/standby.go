@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Hot standby of dispatcher
+//
+// The standby dispatcher is started with -standby and listens on standby_ip:standby_port. It connects to the primary
+// dispatcher, which sends a snapshot of routing tables (entity locations, services and target games of clients) and
+// then replicates every change of them. Games and gates are rejected by the standby while the primary is alive.
+//
+// The primary sends heartbeats to the standby, and the replication link is broken if no heartbeat is received in
+// consts.STANDBY_DISPATCHER_HEARTBEAT_TIMEOUT. The standby then keeps reconnecting to the primary, and only takes over
+// if the primary is not reconnected in consts.STANDBY_DISPATCHER_TAKEOVER_DELAY, so that the primary is not taken over
+// on transient failures of the link. If reconnected, the replicated tables are rebuilt from the new snapshot.
+//
+// After taking over, games and gates fail over to the standby address and reconnect, and routing continues with the
+// replicated tables. Games and gates stick to the standby once it has accepted them, so they never go back to the
+// primary. The standby never gives back, so the failed primary should not be restarted until the cluster is
+// restarted, otherwise games and gates started later would connect to the primary.
+//
+// Only routing tables are replicated, states in flight on the primary are lost on takeover:
+//
+//   - Calls blocked for entities being loaded or migrated, and calls waiting for results, are dropped. Calls with
+//     callbacks fail by timeout.
+//   - Entities being migrated stay on the source game if the migration request is not acked, and can enter spaces
+//     again after consts.ENTER_SPACE_REQUEST_TIMEOUT. Entities whose migration data is sent but not forwarded by the
+//     primary are lost, and should be loaded from storage.
+//   - Loads of games for placement are zero until games report stats to the standby.
+//
+// Replications are sent while holding the lock protecting the changed entry, so that they are ordered with the
+// snapshot of that entry.
+
+// Handle the standby dispatcher connected to this primary dispatcher
+func (service *DispatcherService) HandleSetStandbyDispatcher(dcp *DispatcherClientProxy) {
+	service.standbyLock.Lock()
+	oldStandby := service.standbyDcp
+	service.standbyDcp = dcp
+	service.standbyLock.Unlock()
+
+	if oldStandby != nil {
+		gwlog.Warn("%s: standby dispatcher %s is replaced by %s", service, oldStandby, dcp)
+		oldStandby.Close()
+	}
+
+	service.sendRoutingSnapshot(dcp)
+	go service.sendHeartbeatsToStandby(dcp)
+}
+
+func (service *DispatcherService) sendHeartbeatsToStandby(dcp *DispatcherClientProxy) {
+	for !dcp.IsClosed() {
+		service.standbyLock.Lock()
+		if service.standbyDcp == dcp {
+			dcp.SendReplicateHeartbeat()
+		}
+		service.standbyLock.Unlock()
+		time.Sleep(consts.STANDBY_DISPATCHER_HEARTBEAT_INTERVAL)
+	}
+}
+
+func (service *DispatcherService) handleStandbyDisconnect(dcp *DispatcherClientProxy) {
+	service.standbyLock.Lock()
+	if service.standbyDcp == dcp {
+		service.standbyDcp = nil
+	}
+	service.standbyLock.Unlock()
+}
+
+func (service *DispatcherService) sendRoutingSnapshot(dcp *DispatcherClientProxy) {
+	service.entityDispatchInfosLock.RLock()
+	entityCount := 0
+	for eid, info := range service.entityDispatchInfos {
+		info.RLock()
+		if info.gameid > 0 {
+			dcp.SendReplicateEntityLocation(eid, info.gameid)
+			entityCount += 1
+		}
+		info.RUnlock()
+	}
+	service.entityDispatchInfosLock.RUnlock()
+
+	service.servicesLock.Lock()
+	for serviceName, serviceEids := range service.registeredServices {
+		for eid := range serviceEids {
+			dcp.SendReplicateService(eid, serviceName, true)
+		}
+	}
+	service.servicesLock.Unlock()
+
+	service.clientsLock.RLock()
+	clientCount := len(service.targetGameOfClient)
+	for clientid, gameid := range service.targetGameOfClient {
+		dcp.SendReplicateClientTarget(clientid, gameid)
+	}
+	service.clientsLock.RUnlock()
+
+	gwlog.Info("%s: routing snapshot sent to standby dispatcher %s: %d entities, %d clients", service, dcp, entityCount, clientCount)
+}
+
+func (service *DispatcherService) replicateEntityLocation(eid common.EntityID, gameid uint16) {
+	service.standbyLock.Lock()
+	if service.standbyDcp != nil {
+		service.standbyDcp.SendReplicateEntityLocation(eid, gameid)
+	}
+	service.standbyLock.Unlock()
+}
+
+func (service *DispatcherService) replicateService(eid common.EntityID, serviceName string, declared bool) {
+	service.standbyLock.Lock()
+	if service.standbyDcp != nil {
+		service.standbyDcp.SendReplicateService(eid, serviceName, declared)
+	}
+	service.standbyLock.Unlock()
+}
+
+func (service *DispatcherService) replicateClientTarget(clientid common.ClientID, gameid uint16) {
+	service.standbyLock.Lock()
+	if service.standbyDcp != nil {
+		service.standbyDcp.SendReplicateClientTarget(clientid, gameid)
+	}
+	service.standbyLock.Unlock()
+}
+
+// Replicate routing tables from primary dispatcher, and take over when the primary is not reconnected in time after
+// the replication link is broken
+func (service *DispatcherService) serveAsStandby() {
+	var brokenTime time.Time // zero before connected to the primary
+	for {
+		if !brokenTime.IsZero() && time.Since(brokenTime) >= consts.STANDBY_DISPATCHER_TAKEOVER_DELAY {
+			break
+		}
+
+		gwc, err := service.connectPrimaryDispatcher()
+		if err != nil {
+			gwlog.Warn("%s: connect to primary dispatcher failed: %s, retry in %s", service, err, consts.STANDBY_DISPATCHER_RECONNECT_INTERVAL)
+			time.Sleep(consts.STANDBY_DISPATCHER_RECONNECT_INTERVAL)
+			continue
+		}
+
+		if !brokenTime.IsZero() { // the primary is alive, tables are rebuilt from the snapshot
+			service.clearReplicatedTables()
+		}
+		gwlog.Info("%s: connected to primary dispatcher %s, replicating ...", service, gwc.RemoteAddr())
+		err = service.recvReplications(gwc)
+		gwc.Close()
+		brokenTime = time.Now()
+		gwlog.Error("%s: replication from primary dispatcher is broken: %s, taking over if not reconnected in %s", service, err, consts.STANDBY_DISPATCHER_TAKEOVER_DELAY)
+	}
+
+	service.takenOver.Store(true)
+	gwlog.Warn("%s: standby dispatcher takes over, accepting games and gates on %s:%d", service, service.config.StandbyIp, service.config.StandbyPort)
+}
+
+func (service *DispatcherService) connectPrimaryDispatcher() (*proto.GoWorldConnection, error) {
+	cfg := service.config
+	conn, err := netutil.ConnectTCP(cfg.Ip, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	tcpConn := conn.(*net.TCPConn)
+	tcpConn.SetReadBuffer(consts.DISPATCHER_CLIENT_READ_BUFFER_SIZE)
+	tcpConn.SetKeepAlive(true)
+	tcpConn.SetKeepAlivePeriod(consts.STANDBY_DISPATCHER_KEEPALIVE_PERIOD)
+
+	if cfg.IsTLSEnabled() {
+		tlsConfig, err := netutil.NewTLSConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSCA, false, cfg.TLSServerName)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "tls handshake failed")
+		}
+		conn = tlsConn
+	}
+
+	gwc := proto.NewGoWorldConnection(netutil.NewBufferedReadConnection(netutil.NetConnection{conn}), false)
+	gwc.SendSetStandbyDispatcher(cfg.Secret)
+	if err := gwc.Flush(); err != nil {
+		gwc.Close()
+		return nil, err
+	}
+	return gwc, nil
+}
+
+func (service *DispatcherService) recvReplications(gwc *proto.GoWorldConnection) error {
+	for {
+		gwc.SetRecvDeadline(time.Now().Add(consts.STANDBY_DISPATCHER_HEARTBEAT_TIMEOUT))
+		var msgtype proto.MsgType_t
+		pkt, err := gwc.Recv(&msgtype)
+		if err != nil {
+			if netErr, ok := errors.Cause(err).(net.Error); ok && netErr.Timeout() {
+				return errors.Wrap(err, "heartbeat timeout")
+			} else if netutil.IsTemporaryNetError(err) {
+				continue
+			}
+			return err
+		}
+
+		if msgtype == proto.MT_REPLICATE_HEARTBEAT {
+			// the primary is alive
+		} else if msgtype == proto.MT_REPLICATE_ENTITY_LOCATION {
+			eid := pkt.ReadEntityID()
+			gameid := pkt.ReadUint16()
+			service.applyReplicatedEntityLocation(eid, gameid)
+		} else if msgtype == proto.MT_REPLICATE_SERVICE {
+			eid := pkt.ReadEntityID()
+			serviceName := pkt.ReadVarStr()
+			declared := pkt.ReadBool()
+			service.applyReplicatedService(eid, serviceName, declared)
+		} else if msgtype == proto.MT_REPLICATE_CLIENT_TARGET {
+			clientid := pkt.ReadClientID()
+			gameid := pkt.ReadUint16()
+			service.applyReplicatedClientTarget(clientid, gameid)
+		} else {
+			gwlog.TraceError("%s: unknown replication msgtype %d", service, msgtype)
+		}
+
+		pkt.Release()
+	}
+}
+
+// Clear the tables replicated from the previous connection to primary dispatcher, before receiving the snapshot
+func (service *DispatcherService) clearReplicatedTables() {
+	service.entityDispatchInfosLock.Lock()
+	service.entityDispatchInfos = map[common.EntityID]*EntityDispatchInfo{}
+	service.entityDispatchInfosLock.Unlock()
+
+	service.servicesLock.Lock()
+	service.registeredServices = map[string]entity.EntityIDSet{}
+	service.servicesLock.Unlock()
+
+	service.clientsLock.Lock()
+	service.targetGameOfClient = map[common.ClientID]uint16{}
+	service.clientsLock.Unlock()
+}
+
+func (service *DispatcherService) applyReplicatedEntityLocation(eid common.EntityID, gameid uint16) {
+	if gameid == 0 {
+		service.delEntityDispatchInfo(eid)
+		return
+	}
+
+	info := service.setEntityDispatcherInfoForWrite(eid)
+	info.gameid = gameid
+	info.Unlock()
+}
+
+func (service *DispatcherService) applyReplicatedService(eid common.EntityID, serviceName string, declared bool) {
+	service.servicesLock.Lock()
+	defer service.servicesLock.Unlock()
+
+	serviceEids, ok := service.registeredServices[serviceName]
+	if declared {
+		if !ok {
+			serviceEids = entity.EntityIDSet{}
+			service.registeredServices[serviceName] = serviceEids
+		}
+		serviceEids.Add(eid)
+	} else if ok {
+		serviceEids.Del(eid)
+		if len(serviceEids) == 0 {
+			delete(service.registeredServices, serviceName)
+		}
+	}
+}
+
+func (service *DispatcherService) applyReplicatedClientTarget(clientid common.ClientID, gameid uint16) {
+	service.clientsLock.Lock()
+	if gameid == 0 {
+		delete(service.targetGameOfClient, clientid)
+	} else {
+		service.targetGameOfClient[clientid] = gameid
+	}
+	service.clientsLock.Unlock()
+}
+
+// Games and gates are only accepted by primary dispatcher, or standby dispatcher after taking over
+func (service *DispatcherService) isAcceptingConnections() bool {
+	return !service.isStandby || service.takenOver.Load()
+}
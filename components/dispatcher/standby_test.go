@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestStandbyReplication(t *testing.T) {
+	primary := newTestDispatcherService(1, 0)
+	eid := common.GenEntityID()
+	info := primary.setEntityDispatcherInfoForWrite(eid)
+	info.gameid = 1
+	info.Unlock()
+
+	// the standby has stale tables replicated from the previous connection
+	standby := newTestDispatcherService(1, 0)
+	staleEid := common.GenEntityID()
+	standby.applyReplicatedEntityLocation(staleEid, 1)
+	standby.clearReplicatedTables()
+
+	link := connectTestDispatcherClient(t, primary)
+	link.dcp.isStandby = true
+	link.dcp.startAutoFlush()
+	primary.HandleSetStandbyDispatcher(link.dcp)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- standby.recvReplications(link.gwc)
+	}()
+
+	for deadline := time.Now().Add(time.Second); standby.gameOfEntity(eid) != 1; time.Sleep(time.Millisecond * 10) {
+		if time.Now().After(deadline) {
+			t.Fatalf("entity location is not replicated to standby")
+		}
+	}
+	if gameid := standby.gameOfEntity(staleEid); gameid != 0 {
+		t.Errorf("stale entity is on game %d after replicated from primary", gameid)
+	}
+
+	link.dcp.Close()
+	select {
+	case err := <-errChan:
+		if err == nil {
+			t.Errorf("replication is broken without error")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("replication is not broken after primary closed the link")
+	}
+}
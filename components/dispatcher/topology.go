@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Cluster topology feed for real-time dashboards, served by the pprof HTTP server of dispatcher:
+//
+//	GET /topology         snapshot of games, gates, spaces and entity counts in JSON
+//	GET /topology/feed    stream of snapshots and cluster events as Server-Sent Events
+//
+// The feed sends a snapshot event on subscribing and every _TOPOLOGY_SNAPSHOT_INTERVAL, and cluster events when they
+// happen:
+//
+//	event: snapshot             data: same as GET /topology
+//	event: game_connected       data: {"ID": 1}
+//	event: game_disconnected    data: {"ID": 1}
+//	event: gate_connected       data: {"ID": 1}
+//	event: gate_disconnected    data: {"ID": 1}
+//	event: migration            data: {"EntityID": "...", "FromGame": 1, "ToGame": 2}
+//
+// Games report their stats (entities, clients and the most crowded spaces) to all dispatchers, but each dispatcher only
+// sees migrations of entities routed by itself, and clients are only routed by the first dispatcher. Dashboards of
+// clusters with multiple dispatchers should subscribe to all of them.
+
+const (
+	TOPOLOGY_EVENT_SNAPSHOT          = "snapshot"
+	TOPOLOGY_EVENT_GAME_CONNECTED    = "game_connected"
+	TOPOLOGY_EVENT_GAME_DISCONNECTED = "game_disconnected"
+	TOPOLOGY_EVENT_GATE_CONNECTED    = "gate_connected"
+	TOPOLOGY_EVENT_GATE_DISCONNECTED = "gate_disconnected"
+	TOPOLOGY_EVENT_MIGRATION         = "migration"
+
+	_TOPOLOGY_SNAPSHOT_INTERVAL     = time.Second * 2
+	_TOPOLOGY_SUBSCRIBER_QUEUE_SIZE = 256 // events are dropped for subscribers which can not catch up
+)
+
+type topologyEvent struct {
+	name string
+	data []byte
+}
+
+type topologySnapshot struct {
+	DispatcherID uint16
+	Time         time.Time
+	Games        []gameTopology
+	Gates        []gateTopology
+	Entities     int // entities routed by this dispatcher
+	Clients      int // clients routed by this dispatcher
+}
+
+type gameTopology struct {
+	ID          uint16
+	Connected   bool
+	Stats       *proto.GameStats // the last reported stats, nil if never reported
+	StatsUpdate time.Time
+}
+
+type gateTopology struct {
+	ID        uint16
+	Connected bool
+	Clients   int
+}
+
+type topologyIDEvent struct {
+	ID uint16
+}
+
+type topologyMigrationEvent struct {
+	EntityID common.EntityID
+	FromGame uint16
+	ToGame   uint16
+}
+
+type reportedGameStats struct {
+	stats      *proto.GameStats
+	reportTime time.Time
+}
+
+type topologyFeed struct {
+	lock        sync.Mutex
+	gameStats   map[uint16]reportedGameStats
+	gateClients map[uint16]int
+	subscribers map[chan topologyEvent]struct{}
+}
+
+func newTopologyFeed() *topologyFeed {
+	return &topologyFeed{
+		gameStats:   map[uint16]reportedGameStats{},
+		gateClients: map[uint16]int{},
+		subscribers: map[chan topologyEvent]struct{}{},
+	}
+}
+
+func (feed *topologyFeed) subscribe() chan topologyEvent {
+	ch := make(chan topologyEvent, _TOPOLOGY_SUBSCRIBER_QUEUE_SIZE)
+	feed.lock.Lock()
+	feed.subscribers[ch] = struct{}{}
+	feed.lock.Unlock()
+	return ch
+}
+
+func (feed *topologyFeed) unsubscribe(ch chan topologyEvent) {
+	feed.lock.Lock()
+	delete(feed.subscribers, ch)
+	feed.lock.Unlock()
+}
+
+func (feed *topologyFeed) hasSubscribers() bool {
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	return len(feed.subscribers) > 0
+}
+
+// Publish the event to all subscribers
+func (feed *topologyFeed) publish(name string, v interface{}) {
+	if !feed.hasSubscribers() {
+		return
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		gwlog.Error("topology: marshal %s event failed: %s", name, err)
+		return
+	}
+
+	feed.lock.Lock()
+	defer feed.lock.Unlock()
+	for ch := range feed.subscribers {
+		select {
+		case ch <- topologyEvent{name, data}:
+		default:
+		}
+	}
+}
+
+func (feed *topologyFeed) addGateClients(gateid uint16, delta int) {
+	feed.lock.Lock()
+	feed.gateClients[gateid] += delta
+	feed.lock.Unlock()
+}
+
+func (feed *topologyFeed) clearGateClients(gateid uint16) {
+	feed.lock.Lock()
+	delete(feed.gateClients, gateid)
+	feed.lock.Unlock()
+}
+
+// Handle the stats reported by game periodically
+func (service *DispatcherService) HandleReportGameStats(dcp *DispatcherClientProxy, pkt *netutil.Packet) {
+	var stats proto.GameStats
+	pkt.ReadData(&stats)
+
+	feed := service.topology
+	feed.lock.Lock()
+	feed.gameStats[dcp.gameid] = reportedGameStats{&stats, time.Now()}
+	feed.lock.Unlock()
+	service.updateGameLoad(dcp.gameid, &stats)
+}
+
+func (service *DispatcherService) getTopologySnapshot() *topologySnapshot {
+	snapshot := &topologySnapshot{
+		DispatcherID: service.dispid,
+		Time:         time.Now(),
+		Games:        make([]gameTopology, len(service.gameClients)),
+		Gates:        make([]gateTopology, len(service.gateClients)),
+	}
+
+	feed := service.topology
+	feed.lock.Lock()
+	for i, dcp := range service.gameClients {
+		game := &snapshot.Games[i]
+		game.ID = uint16(i + 1)
+		game.Connected = dcp != nil && !dcp.IsClosed()
+		if reported, ok := feed.gameStats[game.ID]; ok {
+			game.Stats, game.StatsUpdate = reported.stats, reported.reportTime
+		}
+	}
+	for i, dcp := range service.gateClients {
+		gate := &snapshot.Gates[i]
+		gate.ID = uint16(i + 1)
+		gate.Connected = dcp != nil && !dcp.IsClosed()
+		gate.Clients = feed.gateClients[gate.ID]
+	}
+	feed.lock.Unlock()
+
+	service.entityDispatchInfosLock.RLock()
+	snapshot.Entities = len(service.entityDispatchInfos)
+	service.entityDispatchInfosLock.RUnlock()
+
+	service.clientsLock.RLock()
+	snapshot.Clients = len(service.targetGameOfClient)
+	service.clientsLock.RUnlock()
+	return snapshot
+}
+
+// Publish topology snapshots to subscribers periodically
+func (service *DispatcherService) publishTopologySnapshotsForever() {
+	for {
+		time.Sleep(_TOPOLOGY_SNAPSHOT_INTERVAL)
+		if service.topology.hasSubscribers() {
+			service.topology.publish(TOPOLOGY_EVENT_SNAPSHOT, service.getTopologySnapshot())
+		}
+	}
+}
+
+func (service *DispatcherService) publishMigration(eid common.EntityID, fromGame uint16, toGame uint16) {
+	service.topology.publish(TOPOLOGY_EVENT_MIGRATION, &topologyMigrationEvent{eid, fromGame, toGame})
+}
+
+func (service *DispatcherService) registerTopologyHandlers() {
+	http.HandleFunc("/topology", service.serveTopology)
+	http.HandleFunc("/topology/feed", service.serveTopologyFeed)
+}
+
+func (service *DispatcherService) serveTopology(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(service.getTopologySnapshot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func (service *DispatcherService) serveTopologyFeed(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	ch := service.topology.subscribe()
+	defer service.topology.unsubscribe(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	snapshot, err := json.Marshal(service.getTopologySnapshot())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	event := topologyEvent{TOPOLOGY_EVENT_SNAPSHOT, snapshot}
+	for {
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data); err != nil {
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case event = <-ch:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -6,20 +6,26 @@ import (
 
 	"time"
 
-	"io/ioutil"
+	"sync/atomic"
 
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
 	timer "github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/calendar"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/datatable"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/freezestore"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwvar"
 	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/rank"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 const (
@@ -42,9 +48,19 @@ type GameService struct {
 	gameDelegate IGameDelegate
 	//registeredServices map[string]entity.EntityIDSet
 
-	packetQueue         chan packetQueueItem
-	isAllGamesConnected bool
-	runState            xnsyncutil.AtomicInt
+	packetQueue            chan packetQueueItem
+	isAllGamesConnected    bool
+	runState               xnsyncutil.AtomicInt
+	lastLoadReportTime     time.Time
+	lastStatsReportTime    time.Time
+	lastRefsSweepTime      time.Time
+	lastIdleCheckTime      time.Time
+	lastReplicationTime    time.Time
+	lastFreezeSnapshotTime time.Time
+	busyTime               time.Duration // time of handling packets and ticks since last load shedding check
+	lastLoadCheckTime      time.Time
+	busyRatio              float64 // busy ratio of the main loop in the last load shedding check, reported as CPU usage
+	freezeAcksPending      int32   // number of dispatchers which have not acknowledged freezing
 	//collectEntitySyncInfosRequest chan struct{}
 	//collectEntitySycnInfosReply   chan interface{}
 }
@@ -88,8 +104,10 @@ func (gs *GameService) serveRoutine() {
 	// here begins the main loop of Game
 	for {
 		isTick := false
+		var busyStart time.Time
 		select {
 		case item := <-gs.packetQueue:
+			busyStart = time.Now()
 			msgtype, pkt := item.msgtype, item.packet
 			if msgtype == proto.MT_SYNC_POSITION_YAW_FROM_CLIENT {
 				gs.HandleSyncPositionYawFromClient(pkt)
@@ -98,16 +116,57 @@ func (gs *GameService) serveRoutine() {
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
 				clientid := pkt.ReadClientID()
-				gs.HandleCallEntityMethod(eid, method, args, clientid)
+				gs.HandleCallEntityMethod(eid, method, args, clientid, tracing.SpanContext{})
+			} else if msgtype == proto.MT_CALL_ENTITY_METHOD_FROM_PROTOBUF_CLIENT {
+				eid := pkt.ReadEntityID()
+				method := pkt.ReadVarStr()
+				data := pkt.ReadVarBytes()
+				clientid := pkt.ReadClientID()
+				entity.OnCallFromProtobufClient(eid, method, data, clientid)
 			} else if msgtype == proto.MT_CALL_ENTITY_METHOD {
 				eid := pkt.ReadEntityID()
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
-				gs.HandleCallEntityMethod(eid, method, args, "")
+				trace := tracing.ParseSpanContext(pkt.ReadVarBytes())
+				gs.HandleCallEntityMethod(eid, method, args, "", trace)
+			} else if msgtype == proto.MT_CALL_ENTITY_METHOD_WITH_RESULT {
+				eid := pkt.ReadEntityID()
+				reqid := pkt.ReadUint32()
+				_ = pkt.ReadUint32() // timeout
+				method := pkt.ReadVarStr()
+				args := pkt.ReadArgs()
+				trace := tracing.ParseSpanContext(pkt.ReadVarBytes())
+				callerGameID := pkt.ReadUint16()
+				entity.OnCallWithResult(eid, method, args, reqid, callerGameID, trace)
+			} else if msgtype == proto.MT_CALL_ENTITY_METHOD_RESULT {
+				_ = pkt.ReadUint16() // caller gameid
+				reqid := pkt.ReadUint32()
+				errmsg := pkt.ReadVarStr()
+				results := pkt.ReadArgs()
+				entity.OnCallResult(reqid, errmsg, results)
 			} else if msgtype == proto.MT_MIGRATE_REQUEST { // migrate request sent to dispatcher is sent back
 				gs.HandleMigrateRequestAck(pkt)
 			} else if msgtype == proto.MT_REAL_MIGRATE {
 				gs.HandleRealMigrate(pkt)
+			} else if msgtype == proto.MT_MIGRATE_GROUP_REQUEST { // migrate group request sent to dispatcher is sent back
+				gs.HandleMigrateGroupRequestAck(pkt)
+			} else if msgtype == proto.MT_NOTIFY_CALL_DROPPED {
+				eid := pkt.ReadEntityID()
+				method := pkt.ReadVarStr()
+				entity.OnCallDropped(eid, method)
+			} else if msgtype == proto.MT_NOTIFY_CREATE_ENTITY_REJECTED {
+				eid := pkt.ReadEntityID()
+				gameid := pkt.ReadUint16()
+				entity.OnCreateEntityRejected(eid, gameid)
+			} else if msgtype == proto.MT_NOTIFY_ENTITY_ALREADY_LOADED {
+				eid := pkt.ReadEntityID()
+				gameid := pkt.ReadUint16()
+				entity.OnEntityAlreadyLoaded(eid, gameid)
+			} else if msgtype == proto.MT_CLAIM_ENTITY {
+				reqid := pkt.ReadUint32()
+				_ = pkt.ReadEntityID()
+				gameid := pkt.ReadUint16()
+				entity.OnClaimEntityAck(reqid, gameid)
 			} else if msgtype == proto.MT_NOTIFY_CLIENT_CONNECTED {
 				clientid := pkt.ReadClientID()
 				gid := pkt.ReadUint16()
@@ -115,19 +174,37 @@ func (gs *GameService) serveRoutine() {
 			} else if msgtype == proto.MT_NOTIFY_CLIENT_DISCONNECTED {
 				clientid := pkt.ReadClientID()
 				gs.HandleNotifyClientDisconnected(clientid)
+			} else if msgtype == proto.MT_NOTIFY_CLIENT_RESUMED {
+				clientid := pkt.ReadClientID()
+				_ = pkt.ReadUint16() // old gate
+				gid := pkt.ReadUint16()
+				gs.HandleNotifyClientResumed(clientid, gid)
 			} else if msgtype == proto.MT_LOAD_ENTITY_ANYWHERE {
 				eid := pkt.ReadEntityID()
 				typeName := pkt.ReadVarStr()
 				gs.HandleLoadEntityAnywhere(typeName, eid)
 			} else if msgtype == proto.MT_CREATE_ENTITY_ANYWHERE {
+				_ = pkt.ReadVarStr() // placement
+				reqid := pkt.ReadUint32()
 				typeName := pkt.ReadVarStr()
 				var data map[string]interface{}
 				pkt.ReadData(&data)
-				gs.HandleCreateEntityAnywhere(typeName, data)
+				callerGameID := pkt.ReadUint16()
+				gs.HandleCreateEntityAnywhere(typeName, data, reqid, callerGameID)
+			} else if msgtype == proto.MT_CREATE_ENTITY_ANYWHERE_ACK {
+				_ = pkt.ReadUint16() // caller gameid
+				reqid := pkt.ReadUint32()
+				errmsg := pkt.ReadVarStr()
+				var eid common.EntityID
+				if errmsg == "" {
+					eid = pkt.ReadEntityID()
+				}
+				entity.OnCreateEntityAnywhereAck(reqid, eid, errmsg)
 			} else if msgtype == proto.MT_DECLARE_SERVICE {
 				eid := pkt.ReadEntityID()
 				serviceName := pkt.ReadVarStr()
-				gs.HandleDeclareService(eid, serviceName)
+				gid := pkt.ReadUint16()
+				gs.HandleDeclareService(eid, serviceName, gid)
 			} else if msgtype == proto.MT_UNDECLARE_SERVICE {
 				eid := pkt.ReadEntityID()
 				serviceName := pkt.ReadVarStr()
@@ -137,6 +214,49 @@ func (gs *GameService) serveRoutine() {
 			} else if msgtype == proto.MT_NOTIFY_GATE_DISCONNECTED {
 				gateid := pkt.ReadUint16()
 				gs.HandleGateDisconnected(gateid)
+			} else if msgtype == proto.MT_REGISTER_LOGIN_ACK {
+				reqid := pkt.ReadUint32()
+				ok := pkt.ReadBool()
+				entity.OnRegisterLoginAck(reqid, ok)
+			} else if msgtype == proto.MT_NOTIFY_GWVAR_CHANGE {
+				name := pkt.ReadVarStr()
+				data := pkt.ReadVarStr()
+				gwvar.OnNotifyChange(name, data)
+			} else if msgtype == proto.MT_REPORT_GAME_LOAD {
+				load := pkt.ReadUint32()
+				gid := pkt.ReadUint16()
+				entity.OnGameLoadReport(gid, load)
+			} else if msgtype == proto.MT_REQUEST_MIGRATE_DATA {
+				_ = pkt.ReadUint16() // source game
+				eid := pkt.ReadEntityID()
+				targetGame := pkt.ReadUint16()
+				entity.OnRequestMigrateData(eid, targetGame)
+			} else if msgtype == proto.MT_MIGRATE_DATA {
+				_ = pkt.ReadUint16() // target game
+				eid := pkt.ReadEntityID()
+				token := pkt.ReadUint32()
+				migrateData := pkt.ReadVarBytes()
+				entity.OnMigrateData(eid, token, migrateData)
+			} else if msgtype == proto.MT_REPLICATE_GAME_ENTITY {
+				eid := pkt.ReadEntityID()
+				typeName := pkt.ReadVarStr()
+				data := pkt.ReadVarBytes()
+				primary := pkt.ReadUint16()
+				entity.OnReplicateGameEntity(primary, eid, typeName, data)
+			} else if msgtype == proto.MT_TAKEOVER_GAME {
+				primary := pkt.ReadUint16()
+				entity.OnTakeoverGame(primary)
+			} else if msgtype == proto.MT_START_CLUSTER_SAVE_POINT_ACK {
+				reqid := pkt.ReadUint32()
+				saveid := pkt.ReadUint32()
+				errmsg := pkt.ReadVarStr()
+				entity.OnStartClusterSavePointAck(reqid, saveid, errmsg)
+			} else if msgtype == proto.MT_CLUSTER_SAVE_POINT_PREPARE {
+				entity.OnClusterSavePointPrepare(pkt.ReadUint32())
+			} else if msgtype == proto.MT_CLUSTER_SAVE_POINT_COMMIT {
+				entity.OnClusterSavePointCommit(pkt.ReadUint32())
+			} else if msgtype == proto.MT_FINISH_CLUSTER_SAVE_POINT {
+				entity.OnFinishClusterSavePoint(pkt.ReadUint32())
 			} else if msgtype == proto.MT_START_FREEZE_GAME_ACK {
 				gs.HandleStartFreezeGameAck()
 			} else {
@@ -148,6 +268,7 @@ func (gs *GameService) serveRoutine() {
 
 			pkt.Release()
 		case <-ticker:
+			busyStart = time.Now()
 			isTick = true
 			runState := gs.runState.Load()
 			if runState == rsTerminating {
@@ -159,6 +280,44 @@ func (gs *GameService) serveRoutine() {
 			}
 
 			timer.Tick()
+			entity.FireDeferredTimers()
+
+			if time.Since(gs.lastLoadReportTime) >= consts.GAME_LOAD_REPORT_INTERVAL {
+				gs.lastLoadReportTime = time.Now()
+				dispatcher_client.GetDispatcherClientForSend().SendReportGameLoad(entity.GetLocalGameLoad())
+			}
+			if time.Since(gs.lastStatsReportTime) >= consts.GAME_STATS_REPORT_INTERVAL {
+				gs.lastStatsReportTime = time.Now()
+				stats := entity.GetLocalGameStats()
+				stats.CPU = gs.busyRatio
+				for _, dispatcherClient := range dispatcher_client.GetAllDispatcherClientsForSend() {
+					dispatcherClient.SendReportGameStats(stats)
+				}
+			}
+			if time.Since(gs.lastReplicationTime) >= consts.GAME_REPLICATION_INTERVAL {
+				gs.lastReplicationTime = time.Now()
+				entity.FlushReplication()
+			}
+			if time.Since(gs.lastFreezeSnapshotTime) >= consts.FREEZE_SNAPSHOT_INTERVAL {
+				gs.lastFreezeSnapshotTime = time.Now()
+				entity.CaptureFreezeSnapshots()
+			}
+			if time.Since(gs.lastRefsSweepTime) >= consts.ENTITY_REFS_SWEEP_INTERVAL {
+				gs.lastRefsSweepTime = time.Now()
+				entity.SweepEntityReferences()
+			}
+			if time.Since(gs.lastIdleCheckTime) >= consts.ENTITY_IDLE_CHECK_INTERVAL {
+				gs.lastIdleCheckTime = time.Now()
+				entity.UnloadIdleEntities()
+			}
+			if elapsed := time.Since(gs.lastLoadCheckTime); elapsed >= consts.LOAD_SHEDDING_CHECK_INTERVAL {
+				// average busy time of main loop in each tick
+				tickTime := gs.busyTime * consts.GAME_SERVICE_TICK_INTERVAL / elapsed
+				gs.busyRatio = float64(gs.busyTime) / float64(elapsed)
+				entity.UpdateLoadShedding(tickTime, len(gs.packetQueue))
+				gs.lastLoadCheckTime = time.Now()
+				gs.busyTime = 0
+			}
 
 			//case <-gs.collectEntitySyncInfosRequest: //
 			//	gs.collectEntitySycnInfosReply <- 1
@@ -168,8 +327,11 @@ func (gs *GameService) serveRoutine() {
 		post.Tick()
 		if isTick {
 			gameDispatcherClientDelegate.HandleDispatcherClientBeforeFlush()
-			dispatcher_client.GetDispatcherClientForSend().Flush()
+			for _, dispatcherClient := range dispatcher_client.GetAllDispatcherClientsForSend() {
+				dispatcherClient.Flush()
+			}
 		}
+		gs.busyTime += time.Since(busyStart)
 	}
 }
 
@@ -181,9 +343,9 @@ func (gs *GameService) doTerminate() {
 	// wait for all posts to complete
 	gs.waitPostsComplete()
 
-	// destroy all entities
-	entity.OnGameTerminating()
-	gwlog.Info("All entities saved & destroyed, game service terminated.")
+	// destroy all entities, players first
+	res := entity.OnGameTerminating(time.Now().Add(consts.GAME_SHUTDOWN_SAVE_TIMEOUT))
+	gwlog.Info("All entities saved & destroyed (%d saved, %d failed, %d skipped), game service terminated.", res.Saved, res.Failed, res.Skipped)
 	gs.runState.Store(rsTerminated)
 
 	for {
@@ -191,34 +353,29 @@ func (gs *GameService) doTerminate() {
 	}
 }
 
-var freezePacker = netutil.JSONMsgPacker{}
+var (
+	freezePacker = netutil.JSONMsgPacker{}
+	freezeStore  freezestore.Store
+)
 
 func (gs *GameService) doFreeze() {
 	// wait for all posts to complete
 
 	kvdb.Close()
 	kvdb.WaitTerminated()
+	rank.Close()
+	rank.WaitTerminated()
 	gs.waitPostsComplete()
 
-	// save all entities
-	entity.SaveAllEntities()
+	// save all entities, players first
+	entity.SaveAllEntities(entity.SaveAllOptions{Deadline: time.Now().Add(consts.GAME_SHUTDOWN_SAVE_TIMEOUT)})
 	// destroy all entities
 	freeze := func() error {
-		freezeEntity, err := entity.Freeze(gameid)
+		freezeData, err := packFreezeData(false)
 		if err != nil {
 			return err
 		}
-		freezeData, err := freezePacker.PackMsg(freezeEntity, nil)
-		if err != nil {
-			return err
-		}
-		freezeFilename := freezeFilename(gameid)
-		err = ioutil.WriteFile(freezeFilename, freezeData, 0644)
-		if err != nil {
-			return err
-		}
-
-		return nil
+		return freezeStore.Write(freezestore.FreezeName(gameid), freezeData)
 	}
 
 	err := freeze()
@@ -236,32 +393,82 @@ func (gs *GameService) doFreeze() {
 	}
 }
 
-func freezeFilename(gameid uint16) string {
-	return fmt.Sprintf("game%d_freezed.dat", gameid)
+// Pack freeze data of all entities, or a snapshot of running entities, called in the game routine
+func packFreezeData(snapshot bool) ([]byte, error) {
+	var freezeEntity *entity.FreezeData
+	var err error
+	if snapshot {
+		freezeEntity, err = entity.Snapshot(gameid)
+	} else {
+		freezeEntity, err = entity.Freeze(gameid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return freezePacker.PackMsg(freezeEntity, nil)
 }
 
 func (gs *GameService) doRestore() error {
-	freezeFilename := freezeFilename(gameid)
-	data, err := ioutil.ReadFile(freezeFilename)
+	freezeEntity, err := readFreezeData()
 	if err != nil {
 		return err
 	}
+	return entity.RestoreFreezedEntities(freezeEntity)
+}
+
+func readFreezeData() (*entity.FreezeData, error) {
+	data, err := freezeStore.Read(freezestore.FreezeName(gameid))
+	if err != nil {
+		return nil, err
+	}
+	gwlog.Info("Read freeze data in %s: %d bytes", freezeStore, len(data))
 
 	var freezeEntity entity.FreezeData
-	freezePacker.UnpackMsg(data, &freezeEntity)
+	if err := freezePacker.UnpackMsg(data, &freezeEntity); err != nil {
+		return nil, err
+	}
+	return &freezeEntity, nil
+}
 
-	return entity.RestoreFreezedEntities(&freezeEntity)
+// Validate freeze data in the freeze target without restoring any entity, returns the exit code of the game
+func runRestoreDryRun() int {
+	freezeEntity, err := readFreezeData()
+	if err != nil {
+		gwlog.Error("Restore dry-run: read freeze data failed: %s", err)
+		return 1
+	}
+
+	errs := entity.ValidateFreezeData(freezeEntity)
+	for _, err := range errs {
+		gwlog.Error("Restore dry-run: %s", err)
+	}
+	if len(errs) > 0 {
+		gwlog.Error("Restore dry-run: %d problems found in freeze data of %d entities", len(errs), len(freezeEntity.Entities))
+		return 1
+	}
+	gwlog.Info("Restore dry-run: freeze data of %d entities are valid", len(freezeEntity.Entities))
+	return 0
 }
 
 func (gs *GameService) String() string {
 	return fmt.Sprintf("GameService<%d>", gs.id)
 }
 
-func (gs *GameService) HandleCreateEntityAnywhere(typeName string, data map[string]interface{}) {
+func (gs *GameService) HandleCreateEntityAnywhere(typeName string, data map[string]interface{}, reqid uint32, callerGameID uint16) {
 	if consts.DEBUG_PACKETS {
-		gwlog.Debug("%s.HandleCreateEntityAnywhere: typeName=%s, data=%v", gs, typeName, data)
+		gwlog.Debug("%s.HandleCreateEntityAnywhere: typeName=%s, data=%v, reqid=%d, caller=%d", gs, typeName, data, reqid, callerGameID)
 	}
-	entity.CreateEntityLocally(typeName, data, nil)
+	if reqid == 0 {
+		entity.CreateEntityLocally(typeName, data, nil)
+		return
+	}
+
+	eid, err := entity.CreateEntityLocallyWithError(typeName, data)
+	errmsg := ""
+	if err != nil {
+		errmsg = err.Error()
+	}
+	dispatcher_client.GetDispatcherClientForSend().SendCreateEntityAnywhereAck(callerGameID, reqid, eid, errmsg)
 }
 
 func (gs *GameService) HandleLoadEntityAnywhere(typeName string, entityID common.EntityID) {
@@ -271,12 +478,12 @@ func (gs *GameService) HandleLoadEntityAnywhere(typeName string, entityID common
 	entity.LoadEntityLocally(typeName, entityID)
 }
 
-func (gs *GameService) HandleDeclareService(entityID common.EntityID, serviceName string) {
+func (gs *GameService) HandleDeclareService(entityID common.EntityID, serviceName string, providerGameID uint16) {
 	// tell the entity that it is registered successfully
 	if consts.DEBUG_PACKETS {
-		gwlog.Debug("%s.HandleDeclareService: %s declares %s", gs, entityID, serviceName)
+		gwlog.Debug("%s.HandleDeclareService: %s declares %s on game %d", gs, entityID, serviceName, providerGameID)
 	}
-	entity.OnDeclareService(serviceName, entityID)
+	entity.OnDeclareService(serviceName, entityID, providerGameID)
 }
 
 func (gs *GameService) HandleUndeclareService(entityID common.EntityID, serviceName string) {
@@ -290,6 +497,10 @@ func (gs *GameService) HandleUndeclareService(entityID common.EntityID, serviceN
 func (gs *GameService) HandleNotifyAllGamesConnected() {
 	// all games are connected
 	gwlog.Info("All games connected.")
+	entity.LoadMaintenanceMode()
+	calendar.Initialize()
+	gwvar.Initialize()
+	datatable.Initialize()
 	gs.gameDelegate.OnGameReady()
 }
 
@@ -298,6 +509,10 @@ func (gs *GameService) HandleGateDisconnected(gateid uint16) {
 }
 
 func (gs *GameService) HandleStartFreezeGameAck() {
+	if pending := atomic.AddInt32(&gs.freezeAcksPending, -1); pending > 0 {
+		gwlog.Info("Start freeze game ACK received, waiting for %d dispatchers ...", pending)
+		return
+	}
 	gwlog.Info("Start freeze game ACK received, start freezing ...")
 	gs.runState.Store(rsFreezing)
 }
@@ -316,11 +531,11 @@ func (gs *GameService) HandleSyncPositionYawFromClient(pkt *netutil.Packet) {
 	}
 }
 
-func (gs *GameService) HandleCallEntityMethod(entityID common.EntityID, method string, args [][]byte, clientid common.ClientID) {
+func (gs *GameService) HandleCallEntityMethod(entityID common.EntityID, method string, args [][]byte, clientid common.ClientID, trace tracing.SpanContext) {
 	if consts.DEBUG_PACKETS {
 		gwlog.Debug("%s.HandleCallEntityMethod: %s.%s(%v)", gs, entityID, method, args)
 	}
-	entity.OnCall(entityID, method, args, clientid)
+	entity.OnCall(entityID, method, args, clientid, trace)
 }
 
 func (gs *GameService) HandleNotifyClientConnected(clientid common.ClientID, gid uint16) {
@@ -341,6 +556,14 @@ func (gs *GameService) HandleNotifyClientDisconnected(clientid common.ClientID)
 	entity.OnClientDisconnected(clientid)
 }
 
+func (gs *GameService) HandleNotifyClientResumed(clientid common.ClientID, gid uint16) {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s.HandleNotifyClientResumed: %s on gate %d", gs, clientid, gid)
+	}
+	// the owner keeps the client, which may be reattached on another gate
+	entity.OnClientResumed(clientid, gid)
+}
+
 func (gs *GameService) HandleMigrateRequestAck(pkt *netutil.Packet) {
 	eid := pkt.ReadEntityID()
 	spaceid := pkt.ReadEntityID()
@@ -353,6 +576,22 @@ func (gs *GameService) HandleMigrateRequestAck(pkt *netutil.Packet) {
 	entity.OnMigrateRequestAck(eid, spaceid, spaceLoc)
 }
 
+func (gs *GameService) HandleMigrateGroupRequestAck(pkt *netutil.Packet) {
+	groupID := pkt.ReadUint32()
+	_ = pkt.ReadUint16() // target game
+	count := int(pkt.ReadUint16())
+	eids := make([]common.EntityID, count)
+	for i := 0; i < count; i++ {
+		eids[i] = pkt.ReadEntityID()
+	}
+	ok := pkt.ReadBool()
+
+	if consts.DEBUG_PACKETS {
+		gwlog.Debug("Migrate group %d request is acknowledged: entities=%v, ok=%v", groupID, eids, ok)
+	}
+	entity.OnMigrateGroupRequestAck(groupID, eids, ok)
+}
+
 func (gs *GameService) HandleRealMigrate(pkt *netutil.Packet) {
 	eid := pkt.ReadEntityID()
 	_ = pkt.ReadUint16() // targetGame is not userful
@@ -370,14 +609,18 @@ func (gs *GameService) HandleRealMigrate(pkt *netutil.Packet) {
 	y := pkt.ReadFloat32()
 	z := pkt.ReadFloat32()
 	typeName := pkt.ReadVarStr()
-	var migrateData map[string]interface{}
-	pkt.ReadData(&migrateData)
+	token := pkt.ReadUint32()
+	baseToken := pkt.ReadUint32()
+	migratePayload := pkt.ReadVarBytes()
 	timerData := pkt.ReadVarBytes()
+	groupID := pkt.ReadUint32()
+	groupSize := pkt.ReadUint16()
+	sourceGame := pkt.ReadUint16()
 	if consts.DEBUG_PACKETS {
-		gwlog.Debug("%s.HandleRealMigrate: entity %s migrating to space %s, typeName=%s, migrateData=%v, timerData=%v, client=%s@%d", gs, eid, spaceID, typeName, migrateData, timerData, clientid, clientsrv)
+		gwlog.Debug("%s.HandleRealMigrate: entity %s migrating from game %d to space %s, typeName=%s, migratePayload=%d bytes, baseToken=%d, timerData=%v, client=%s@%d", gs, eid, sourceGame, spaceID, typeName, len(migratePayload), baseToken, timerData, clientid, clientsrv)
 	}
 
-	entity.OnRealMigrate(eid, spaceID, x, y, z, typeName, migrateData, timerData, clientid, clientsrv)
+	entity.OnRealMigrate(eid, spaceID, x, y, z, typeName, sourceGame, token, baseToken, migratePayload, timerData, clientid, clientsrv, groupID, groupSize)
 }
 
 func (gs *GameService) terminate() {
@@ -385,5 +628,9 @@ func (gs *GameService) terminate() {
 }
 
 func (gs *GameService) freeze() {
-	dispatcher_client.GetDispatcherClientForSend().SendStartFreezeGame(gameid)
+	// all dispatchers should block entities of this game before freezing
+	atomic.StoreInt32(&gs.freezeAcksPending, int32(dispatcher_client.GetDispatcherCount()))
+	for _, dispatcherClient := range dispatcher_client.GetAllDispatcherClientsForSend() {
+		dispatcherClient.SendStartFreezeGame(gameid)
+	}
 }
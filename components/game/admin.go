@@ -0,0 +1,284 @@
+package game
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/admin"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/freezestore"
+	"github.com/xiaonanln/goworld/engine/gwvar"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+// Admin endpoints of game, entities are inspected in the game routine
+
+const (
+	_ADMIN_DEFAULT_ENTITIES_LIMIT = 1000
+)
+
+type adminEntityInfo struct {
+	ID       common.EntityID
+	Type     string
+	Space    common.EntityID `json:",omitempty"`
+	Position entity.Position
+	Client   string `json:",omitempty"`
+}
+
+type adminEntityDump struct {
+	adminEntityInfo
+	Persistent bool
+	Attrs      map[string]interface{}
+}
+
+type adminSpaceInfo struct {
+	ID       common.EntityID
+	Kind     int
+	Entities int
+}
+
+func setupAdmin(gameConfig *config.GameConfig) {
+	admin.Handle("/entities", inGameRoutine(adminListEntities))
+	admin.Handle("/entity", inGameRoutine(adminDumpEntity))
+	admin.Handle("/entity/audit", inGameRoutine(adminEntityAuditTrail))
+	admin.Handle("/services", inGameRoutine(adminListServices))
+	admin.Handle("/spaces", inGameRoutine(adminListSpaces))
+	admin.Handle("/storage", adminStorageStats)
+	admin.HandleAction("/freeze", adminFreeze)
+	admin.HandleAction("/snapshot", adminSnapshot)
+	admin.HandleAction("/save", adminStartClusterSavePoint)
+	admin.HandleAction("/reload_scripts", inGameRoutine(adminReloadScripts))
+	admin.HandleAction("/maintenance", inGameRoutine(adminSetMaintenanceMode))
+	gwvar.RegisterAdminHandlers()
+	admin.Serve(gameConfig.AdminIp, gameConfig.AdminPort, gameConfig.AdminToken)
+}
+
+func inGameRoutine(handler admin.HandlerFunc) admin.HandlerFunc {
+	type result struct {
+		val interface{}
+		err error
+	}
+	return func(query url.Values) (interface{}, error) {
+		resultChan := make(chan result, 1)
+		post.Post(func() {
+			val, err := handler(query)
+			resultChan <- result{val, err}
+		})
+
+		select {
+		case r := <-resultChan:
+			return r.val, r.err
+		case <-time.After(consts.ADMIN_REQUEST_TIMEOUT):
+			return nil, errors.New("timeout")
+		}
+	}
+}
+
+func getAdminEntityInfo(e *entity.Entity) adminEntityInfo {
+	info := adminEntityInfo{ID: e.ID, Type: e.TypeName, Position: e.GetPosition()}
+	if e.Space != nil {
+		info.Space = e.Space.ID
+	}
+	if client := e.GetClient(); client != nil {
+		info.Client = client.String()
+	}
+	return info
+}
+
+// List entities filtered by type, space and whether having clients (client=1 or client=0)
+func adminListEntities(query url.Values) (interface{}, error) {
+	limit := _ADMIN_DEFAULT_ENTITIES_LIMIT
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return nil, errors.Errorf("invalid limit: %s", s)
+		}
+	}
+
+	typeName, spaceID, client := query.Get("type"), common.EntityID(query.Get("space")), query.Get("client")
+	match := func(e *entity.Entity) bool {
+		if spaceID != "" && (e.Space == nil || e.Space.ID != spaceID) {
+			return false
+		}
+		if client != "" && (e.GetClient() != nil) != (client == "1") {
+			return false
+		}
+		return true
+	}
+
+	total := 0
+	entities := []adminEntityInfo{}
+	check := func(e *entity.Entity) {
+		if e == nil || !match(e) {
+			return
+		}
+		total += 1
+		if len(entities) < limit {
+			entities = append(entities, getAdminEntityInfo(e))
+		}
+	}
+	if typeName != "" {
+		for eid := range entity.GetEntitiesByType(typeName) {
+			check(entity.GetEntity(eid))
+		}
+	} else {
+		for _, e := range entity.Entities() {
+			check(e)
+		}
+	}
+
+	return map[string]interface{}{
+		"Total":    total,
+		"Entities": entities,
+	}, nil
+}
+
+// Dump attributes of the entity
+func adminDumpEntity(query url.Values) (interface{}, error) {
+	e := entity.GetEntity(common.EntityID(query.Get("id")))
+	if e == nil {
+		return nil, admin.ErrNotFound
+	}
+
+	return &adminEntityDump{
+		adminEntityInfo: getAdminEntityInfo(e),
+		Persistent:      e.IsPersistent(),
+		Attrs:           e.Attrs.ToMap(),
+	}, nil
+}
+
+// Dump the recent client-originated RPCs of the entity, empty if client audit is not enabled for the entity type
+func adminEntityAuditTrail(query url.Values) (interface{}, error) {
+	e := entity.GetEntity(common.EntityID(query.Get("id")))
+	if e == nil {
+		return nil, admin.ErrNotFound
+	}
+
+	records := e.GetClientAuditTrail()
+	if records == nil {
+		records = []entity.ClientAuditRecord{}
+	}
+	return records, nil
+}
+
+// List services and providers known by this game
+func adminListServices(query url.Values) (interface{}, error) {
+	services := map[string][]common.EntityID{}
+	for serviceName, eids := range entity.GetAllServiceProviders() {
+		providers := eids.ToList()
+		sort.Slice(providers, func(i, j int) bool {
+			return providers[i] < providers[j]
+		})
+		services[serviceName] = providers
+	}
+	return services, nil
+}
+
+// List spaces with entity counts, the nil space is not listed
+func adminListSpaces(query url.Values) (interface{}, error) {
+	spaces := []adminSpaceInfo{}
+	for _, e := range entity.Entities() {
+		if !e.IsSpaceEntity() {
+			continue
+		}
+		space := e.ToSpace()
+		if space.IsNil() {
+			continue
+		}
+		spaces = append(spaces, adminSpaceInfo{ID: space.ID, Kind: space.Kind, Entities: space.GetEntityCount()})
+	}
+	sort.Slice(spaces, func(i, j int) bool {
+		return spaces[i].ID < spaces[j].ID
+	})
+	return spaces, nil
+}
+
+// Show the storage queue length and latencies of storage operations
+func adminStorageStats(query url.Values) (interface{}, error) {
+	return map[string]interface{}{
+		"QueueLength": storage.GetQueueLen(),
+		"Operations":  opmon.GetStats("storage."),
+	}, nil
+}
+
+// Reload entity scripts immediately, without waiting for the modification to be detected
+func adminReloadScripts(query url.Values) (interface{}, error) {
+	if err := entity.ReloadScripts(); err != nil {
+		return nil, err
+	}
+	return "reloaded", nil
+}
+
+// Enable or disable maintenance mode of the cluster, allowlist is separated by commas
+func adminSetMaintenanceMode(query url.Values) (interface{}, error) {
+	enabled, err := strconv.ParseBool(query.Get("enabled"))
+	if err != nil {
+		return nil, errors.Errorf("invalid enabled: %s", query.Get("enabled"))
+	}
+
+	mode := entity.MaintenanceMode{Enabled: enabled, Message: query.Get("message"), Allowlist: []string{}}
+	for _, loginKey := range strings.Split(query.Get("allowlist"), ",") {
+		if loginKey != "" {
+			mode.Allowlist = append(mode.Allowlist, loginKey)
+		}
+	}
+	entity.SetMaintenanceMode(mode)
+	return mode, nil
+}
+
+// Freeze the game, which quits after entities are freezed and restores by -restore
+func adminFreeze(query url.Values) (interface{}, error) {
+	if gameService.runState.Load() != rsRunning {
+		return nil, errors.New("game is not running")
+	}
+	signalChan <- syscall.Signal(10) // freeze as SIGUSR1
+	return "freezing", nil
+}
+
+// Write freeze data of the running game to the freeze target as a disaster snapshot, which is restored by -restore
+func adminSnapshot(query url.Values) (interface{}, error) {
+	data, err := inGameRoutine(func(query url.Values) (interface{}, error) {
+		return packFreezeData(true)
+	})(query)
+	if err != nil {
+		return nil, err
+	}
+
+	freezeData := data.([]byte)
+	if err := freezeStore.Write(freezestore.FreezeName(gameid), freezeData); err != nil {
+		return nil, err
+	}
+	return fmt.Sprintf("%d bytes written to %s", len(freezeData), freezeStore), nil
+}
+
+// Start a cluster save point with the label, replied when all games are saved
+func adminStartClusterSavePoint(query url.Values) (interface{}, error) {
+	type result struct {
+		savePoint *entity.ClusterSavePoint
+		err       error
+	}
+	resultChan := make(chan result, 1)
+	post.Post(func() {
+		entity.StartClusterSavePoint(query.Get("label"), func(savePoint *entity.ClusterSavePoint, err error) {
+			resultChan <- result{savePoint, err}
+		})
+	})
+
+	select {
+	case r := <-resultChan:
+		return r.savePoint, r.err
+	case <-time.After(consts.CLUSTER_SAVE_POINT_TIMEOUT + consts.ADMIN_REQUEST_TIMEOUT): // aborted by dispatcher before this
+		return nil, errors.New("timeout")
+	}
+}
@@ -2,6 +2,7 @@ package game
 
 import (
 	"flag"
+	"fmt"
 
 	"math/rand"
 	"time"
@@ -18,14 +19,21 @@ import (
 
 	"github.com/xiaonanln/goworld/components/binutil"
 	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/analytics"
+	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/freezestore"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/idip"
 	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/rank"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/tracing"
 )
 
 var (
@@ -33,6 +41,7 @@ var (
 	configFile                   string
 	logLevel                     string
 	restore                      bool
+	restoreDryRun                bool
 	gameService                  *GameService
 	signalChan                   = make(chan os.Signal, 1)
 	gameDispatcherClientDelegate = &dispatcherClientDelegate{}
@@ -48,6 +57,7 @@ func parseArgs() {
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
 	flag.StringVar(&logLevel, "log", "", "set log level, will override log level in config")
 	flag.BoolVar(&restore, "restore", false, "restore from freezed state")
+	flag.BoolVar(&restoreDryRun, "restore-dry-run", false, "validate freezed state for restoring, without running game")
 	flag.Parse()
 	gameid = uint16(gameidArg)
 }
@@ -69,28 +79,80 @@ func Run(delegate IGameDelegate) {
 		gwlog.Info("SET GOMAXPROCS = %d", gameConfig.GoMaxProcs)
 		runtime.GOMAXPROCS(gameConfig.GoMaxProcs)
 	}
-	if logLevel == "" {
+	logLevelOverridden := logLevel != ""
+	if !logLevelOverridden {
 		logLevel = gameConfig.LogLevel
 	}
-	binutil.SetupGWLog(logLevel, gameConfig.LogFile, gameConfig.LogStderr)
+	gwlog.SetGlobalField("gameid", gameid)
+	binutil.SetupGWLog(logLevel, gameConfig.LogFormat, gameConfig.LogFile, gameConfig.LogStderr)
+	binutil.SetLogModuleLevels(gameConfig.LogModuleLevels)
+
+	if gameConfig.Namespace != common.DEFAULT_NAMESPACE {
+		gwlog.Info("Game %d is in namespace %d", gameid, gameConfig.Namespace)
+		common.SetLocalNamespace(gameConfig.Namespace)
+	}
+
+	store, err := freezestore.Open(gameConfig.FreezeTarget)
+	if err != nil {
+		gwlog.Fatal("open freeze target failed: %s", err)
+	}
+	freezeStore = store
+	if restoreDryRun {
+		os.Exit(runRestoreDryRun())
+	}
 
 	storage.Initialize()
 	kvdb.Initialize()
 	crontab.Initialize()
 
 	binutil.SetupPprofServer(gameConfig.PProfIp, gameConfig.PProfPort)
+	binutil.SetupMetricsServer(gameConfig.MetricsIp, gameConfig.MetricsPort)
+	idip.Serve(gameConfig.IDIPIp, gameConfig.IDIPPort, gameConfig.IDIPToken)
+	setupAdmin(gameConfig)
+	tracing.Setup(gameConfig.TraceEndpoint, fmt.Sprintf("game%d", gameid), gameConfig.TraceSampleRatio)
+	analytics.SetSampleRatio(analytics.EVENT_RPC, gameConfig.AnalyticsRPCSampleRatio)
+	analytics.Setup(gameConfig.AnalyticsSink, gameConfig.AnalyticsTarget, fmt.Sprintf("game%d", gameid), gameConfig.AnalyticsSampleRatio)
 
 	entity.SetSaveInterval(gameConfig.SaveInterval)
+	entity.SetDefaultAoiDistance(entity.Coord(gameConfig.AOIDistance))
+	if gameConfig.StandbyOf != 0 {
+		gwlog.Info("Game %d is standby of game %d", gameid, gameConfig.StandbyOf)
+	} else if len(config.GetStandbyGameIDs(gameid)) > 0 {
+		entity.EnableReplication()
+	}
+	if gameConfig.IncrementalFreeze {
+		entity.EnableIncrementalFreeze()
+	}
 
 	gameService = newGameService(gameid, delegate)
 
 	dispatcher_client.Initialize(gameDispatcherClientDelegate, false)
 
 	setupSignals()
+	setupConfigReload(logLevelOverridden)
 
 	gameService.run(restore)
 }
 
+// Apply changeable settings of game when config is reloaded
+func setupConfigReload(logLevelOverridden bool) {
+	config.OnReload(func(cfg *config.GoWorldConfig) {
+		gameConfig := cfg.Games[int(gameid)]
+		if gameConfig == nil {
+			return
+		}
+		if !logLevelOverridden {
+			binutil.SetLogLevel(gameConfig.LogLevel)
+		}
+		binutil.SetLogModuleLevels(gameConfig.LogModuleLevels)
+		post.Post(func() {
+			entity.SetSaveInterval(gameConfig.SaveInterval)
+			entity.SetDefaultAoiDistance(entity.Coord(gameConfig.AOIDistance))
+		})
+	})
+	config.Watch()
+}
+
 func setupSignals() {
 	gwlog.Info("Setup signals ...")
 	signal.Ignore(syscall.Signal(12))
@@ -164,6 +226,8 @@ func waitKVDBFinish() {
 	gwlog.Info("Closing KVDB ...")
 	kvdb.Close()
 	kvdb.WaitTerminated()
+	rank.Close()
+	rank.WaitTerminated()
 }
 
 func waitEntityStorageFinish() {
@@ -194,7 +258,7 @@ func (delegate *dispatcherClientDelegate) OnDispatcherClientConnect(dispatcherCl
 	//	}
 	//}()
 
-	dispatcherClient.SendSetGameID(gameid, isReconnect, isRestore)
+	dispatcherClient.SendSetGameID(gameid, isReconnect, isRestore, config.GetDispatcherByID(dispatcherClient.GetDispatcherID()).Secret)
 }
 
 var lastWarnGateServiceQueueLen = 0
@@ -206,7 +270,7 @@ func (delegate *dispatcherClientDelegate) HandleDispatcherClientPacket(msgtype p
 	}
 }
 
-func (delegate *dispatcherClientDelegate) HandleDispatcherClientDisconnect() {
+func (delegate *dispatcherClientDelegate) HandleDispatcherClientDisconnect(dispatcherClient *dispatcher_client.DispatcherClient) {
 	gwlog.Error("Disconnected from dispatcher, try reconnecting ...")
 }
 
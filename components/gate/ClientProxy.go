@@ -9,6 +9,7 @@ import (
 
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
 	"github.com/xiaonanln/goworld/engine/config"
@@ -33,12 +34,14 @@ type ClientProxy struct {
 	clientid       common.ClientID
 	filterProps    map[string]string
 	clientSyncInfo clientSyncInfo
+	encoding       string // encoding of packets negotiated at handshake, never changed after connected to game
 }
 
 func newClientProxy(netConn net.Conn, cfg *config.GateConfig) *ClientProxy {
-	tcpConn := netConn.(*net.TCPConn)
-	tcpConn.SetWriteBuffer(consts.CLIENT_PROXY_WRITE_BUFFER_SIZE)
-	tcpConn.SetReadBuffer(consts.CLIENT_PROXY_READ_BUFFER_SIZE)
+	if tcpConn, ok := netConn.(*net.TCPConn); ok { // WebSocket connections are not TCP connections
+		tcpConn.SetWriteBuffer(consts.CLIENT_PROXY_WRITE_BUFFER_SIZE)
+		tcpConn.SetReadBuffer(consts.CLIENT_PROXY_READ_BUFFER_SIZE)
+	}
 
 	var conn netutil.Connection = netutil.NetConnection{netConn}
 	conn = netutil.NewBufferedReadConnection(conn)
@@ -52,6 +55,7 @@ func newClientProxy(netConn net.Conn, cfg *config.GateConfig) *ClientProxy {
 		GoWorldConnection: gwc,
 		clientid:          common.GenClientID(), // each client has its unique clientid
 		filterProps:       map[string]string{},
+		encoding:          proto.CLIENT_ENCODING_MSGPACK,
 	}
 }
 
@@ -83,6 +87,10 @@ func (cp *ClientProxy) serve() {
 				cp.handleSyncPositionYawFromClient(pkt)
 			} else if msgtype == proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT {
 				cp.handleCallEntityMethodFromClient(pkt)
+			} else if msgtype == proto.MT_ACK_CLIENT_MESSAGE {
+				gateService.handleAckClientMessage(cp, pkt)
+			} else if msgtype == proto.MT_RESUME_CLIENT_SESSION {
+				gateService.handleResumeClientSession(cp, pkt)
 			} else {
 				if consts.DEBUG_MODE {
 					gwlog.TraceError("unknown message type from client: %d", msgtype)
@@ -101,12 +109,39 @@ func (cp *ClientProxy) serve() {
 	}
 }
 
+// Receive the packet of msgtype expected in handshakes before the client proxy is connected to game
+func (cp *ClientProxy) recvHandshakePacket(expected proto.MsgType_t, timeout time.Duration) (*netutil.Packet, error) {
+	deadline := time.Now().Add(timeout)
+	var msgtype proto.MsgType_t
+	var pkt *netutil.Packet
+	for pkt == nil {
+		var err error
+		cp.SetRecvDeadline(deadline)
+		pkt, err = cp.Recv(&msgtype)
+		if pkt == nil && err != nil && (!netutil.IsTemporaryNetError(err) || time.Now().After(deadline)) {
+			return nil, err
+		}
+	}
+
+	if msgtype != expected {
+		pkt.Release()
+		return nil, errors.Errorf("expect %s, but received %s", proto.MsgTypeToString(expected), proto.MsgTypeToString(msgtype))
+	}
+	return pkt, nil
+}
+
 func (cp *ClientProxy) handleSyncPositionYawFromClient(pkt *netutil.Packet) {
 	// client syncing entity info, cache the packet for further process
 	gateService.handleSyncPositionYawFromClient(pkt)
 }
 
 func (cp *ClientProxy) handleCallEntityMethodFromClient(pkt *netutil.Packet) {
+	if cp.encoding == proto.CLIENT_ENCODING_PROTOBUF {
+		cp.handleCallEntityMethodFromProtobufClient(pkt)
+		return
+	}
+
 	pkt.AppendClientID(cp.clientid) // append clientid to the packet
-	dispatcher_client.GetDispatcherClientForSend().SendPacket(pkt)
+	eid := common.EntityID(pkt.UnreadPayload()[:common.ENTITYID_LENGTH])
+	dispatcher_client.GetDispatcherClientForEntity(eid).SendPacket(pkt)
 }
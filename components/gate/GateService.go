@@ -13,6 +13,7 @@ import (
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/clientauth"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
@@ -33,6 +34,25 @@ type GateService struct {
 	pendingSyncPackets     []*netutil.Packet
 	pendingSyncPacketsLock sync.Mutex
 
+	authVerifier clientauth.Verifier // nil if client auth is disabled
+
+	bannedIPsLock sync.Mutex
+	bannedIPs     map[string]time.Time // banned until
+
+	maintenanceLock sync.RWMutex
+	maintenance     maintenanceState // pushed by dispatchers
+
+	clientSessionsLock    sync.Mutex
+	clientSessions        map[common.ClientID]*clientSession
+	clientSessionsByToken map[string]*clientSession
+	pendingTransfers      map[common.ClientID]chan *clientSession // sessions requested from other gates
+	forwardedClients      map[common.ClientID]*forwardedClient    // sessions transferred to other gates
+
+	draining       xnsyncutil.AtomicBool
+	drainLock      sync.Mutex
+	drainThreshold int
+	drainDeadline  time.Time
+
 	terminating xnsyncutil.AtomicBool
 	terminated  *xnsyncutil.OneTimeCond
 }
@@ -44,7 +64,13 @@ func newGateService() *GateService {
 		packetQueue:        xnsyncutil.NewSyncQueue(),
 		filterTrees:        map[string]*FilterTree{},
 		pendingSyncPackets: []*netutil.Packet{},
+		bannedIPs:          map[string]time.Time{},
 		terminated:         xnsyncutil.NewOneTimeCond(),
+
+		clientSessions:        map[common.ClientID]*clientSession{},
+		clientSessionsByToken: map[string]*clientSession{},
+		pendingTransfers:      map[common.ClientID]chan *clientSession{},
+		forwardedClients:      map[common.ClientID]*forwardedClient{},
 	}
 }
 
@@ -52,7 +78,23 @@ func (gs *GateService) run() {
 	cfg := config.GetGate(gateid)
 	gwlog.Info("Compress connection: %v", cfg.CompressConnection)
 	gs.listenAddr = fmt.Sprintf("%s:%d", cfg.Ip, cfg.Port)
+	if cfg.ClientAuth != "" {
+		verifier, err := clientauth.NewVerifier(cfg.ClientAuth, cfg.ClientAuthKey)
+		if err != nil {
+			gwlog.Fatal("%s: create client auth verifier failed: %s", gs, err)
+		}
+		gs.authVerifier = verifier
+		gwlog.Info("Client auth: %s", cfg.ClientAuth)
+	}
+	gs.registerMetrics()
 	go netutil.ServeForever(gs.handlePacketRoutine)
+	go gs.expireClientSessionsForever()
+	if cfg.WebSocketPort != 0 {
+		go gs.serveWebSocketForever(cfg)
+	}
+	if cfg.KCPPort != 0 {
+		go gs.serveKCPForever(cfg)
+	}
 	netutil.ServeTCPForever(gs.listenAddr, gs)
 }
 
@@ -61,31 +103,104 @@ func (gs *GateService) String() string {
 }
 
 func (gs *GateService) ServeTCPConnection(conn net.Conn) {
+	gs.serveClientConnection(conn)
+}
+
+// serve the client connection (TCP, WebSocket or KCP) until it is closed
+func (gs *GateService) serveClientConnection(conn net.Conn) {
 	if gs.terminating.Load() {
 		// server terminating, not accepting more connections
 		conn.Close()
 		return
 	}
+	if gs.draining.Load() {
+		// gate draining, clients should connect to other gates
+		gwlog.Warn("%s: draining, connection from %s is rejected", gs, conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+
+	if ip := ipOfAddr(conn.RemoteAddr()); gs.isIPBanned(ip) {
+		gwlog.Warn("%s: %s is banned, connection is rejected", gs, ip)
+		conn.Close()
+		return
+	}
 
 	cfg := config.GetGate(gateid)
+	if cfg.MaxClients > 0 {
+		if clientCount := gs.getClientCount(); clientCount >= cfg.MaxClients {
+			gwlog.Warn("%s: max clients %d reached, connection from %s is rejected", gs, cfg.MaxClients, conn.RemoteAddr())
+			conn.Close()
+			return
+		}
+	}
 	cp := newClientProxy(conn, cfg)
+	if cfg.ClientEncodingHandshake {
+		if err := cp.handshakeEncoding(); err != nil {
+			gwlog.Warn("%s: %s encoding handshake failed: %s", gs, cp, err)
+			cp.Close()
+			return
+		}
+	}
+	if gs.authVerifier != nil {
+		user, err := cp.handshakeAuth(gs.authVerifier)
+		if err != nil {
+			gwlog.Warn("%s: %s auth failed: %s", gs, cp, err)
+			cp.SendKickClient(gateid, cp.clientid, proto.KICK_REASON_AUTH_FAILED, "auth failed")
+			cp.Flush()
+			cp.Close()
+			return
+		}
+		if ok, message := gs.isLoginAllowed(user); !ok {
+			gwlog.Warn("%s: %s is not in maintenance allowlist, connection is rejected", gs, cp)
+			cp.SendKickClient(gateid, cp.clientid, proto.KICK_REASON_MAINTENANCE, message)
+			cp.Flush()
+			cp.Close()
+			return
+		}
+	}
 
 	gs.clientProxiesLock.Lock()
 	gs.clientProxies[cp.clientid] = cp
 	gs.clientProxiesLock.Unlock()
+	clientConnectionsMetric.Inc()
+	clientsMetric.Inc()
 
 	dispatcher_client.GetDispatcherClientForSend().SendNotifyClientConnected(cp.clientid)
+	if cfg.ClientReconnectWindow > 0 {
+		gs.onClientSessionConnected(cp)
+	}
 	if consts.DEBUG_CLIENTS {
-		gwlog.Debug("%s.ServeTCPConnection: client %s connected", gs, cp)
+		gwlog.Debug("%s.serveClientConnection: client %s connected", gs, cp)
 	}
 	cp.serve()
 }
 
+func (gs *GateService) getClientCount() int {
+	gs.clientProxiesLock.RLock()
+	clientCount := len(gs.clientProxies)
+	gs.clientProxiesLock.RUnlock()
+	return clientCount
+}
+
 func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
 	gs.clientProxiesLock.Lock()
 	delete(gs.clientProxies, cp.clientid)
 	gs.clientProxiesLock.Unlock()
+	clientsMetric.Dec()
+	clientKept := gs.onClientSessionDisconnected(cp)
+	gs.clearClientFilterProps(cp)
+
+	if !clientKept {
+		dispatcher_client.GetDispatcherClientForSend().SendNotifyClientDisconnected(cp.clientid)
+	}
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s.onClientProxyClose: client %s disconnected, kept in reconnect window: %v", gs, cp, clientKept)
+	}
+}
 
+// Drop all filter props of the client proxy
+func (gs *GateService) clearClientFilterProps(cp *ClientProxy) {
 	gs.filterTreesLock.Lock()
 	for key, val := range cp.filterProps {
 		ft := gs.filterTrees[key]
@@ -96,12 +211,8 @@ func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
 			ft.Remove(cp.clientid, val)
 		}
 	}
+	cp.filterProps = map[string]string{}
 	gs.filterTreesLock.Unlock()
-
-	dispatcher_client.GetDispatcherClientForSend().SendNotifyClientDisconnected(cp.clientid)
-	if consts.DEBUG_CLIENTS {
-		gwlog.Debug("%s.onClientProxyClose: client %s disconnected", gs, cp)
-	}
 }
 
 func (gs *GateService) HandleDispatcherClientPacket(msgtype proto.MsgType_t, packet *netutil.Packet) {
@@ -109,7 +220,7 @@ func (gs *GateService) HandleDispatcherClientPacket(msgtype proto.MsgType_t, pac
 		gwlog.Debug("%s.HandleDispatcherClientPacket: msgtype=%v, packet(%d)=%v", gs, msgtype, packet.GetPayloadLen(), packet.Payload())
 	}
 
-	if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
+	if proto.IsRedirectToGateProxyMsgType(msgtype) {
 		_ = packet.ReadUint16() // gid
 		clientid := packet.ReadClientID()
 
@@ -117,23 +228,43 @@ func (gs *GateService) HandleDispatcherClientPacket(msgtype proto.MsgType_t, pac
 		clientproxy := gs.clientProxies[clientid]
 		gs.clientProxiesLock.RUnlock()
 
-		if clientproxy != nil {
+		if clientproxy == nil && gs.forwardClientPacket(clientid, packet) {
+			// the session of client is transferred to another gate
+			return
+		}
+
+		if msgtype == proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED {
+			// acknowledged messages are kept for disconnected clients
+			gs.handleCallEntityMethodOnClientAcked(clientid, clientproxy, packet)
+		} else if clientproxy != nil {
 			if msgtype == proto.MT_SET_CLIENTPROXY_FILTER_PROP {
 				gs.handleSetClientFilterProp(clientproxy, packet)
 			} else if msgtype == proto.MT_CLEAR_CLIENTPROXY_FILTER_PROPS {
 				gs.handleClearClientFilterProps(clientproxy, packet)
+			} else if msgtype == proto.MT_KICK_CLIENT {
+				gs.handleKickClient(clientproxy, packet)
+			} else if msgtype == proto.MT_BAN_CLIENT {
+				gs.handleBanClient(clientproxy, packet)
 			} else {
 				// message types that should be redirected to client proxy
 				clientproxy.SendPacket(packet)
 			}
-		} else {
+		} else if !gs.keepPacketOfDisconnectedClient(msgtype, clientid, packet) {
 			// client already disconnected, but the game service seems not knowing it, so tell it
 			dispatcher_client.GetDispatcherClientForSend().SendNotifyClientDisconnected(clientid)
 		}
+	} else if msgtype == proto.MT_TRANSFER_CLIENT_SESSION {
+		gs.handleTransferClientSession(packet)
+	} else if msgtype == proto.MT_TRANSFER_CLIENT_SESSION_ACK {
+		gs.handleTransferClientSessionAck(packet)
+	} else if msgtype == proto.MT_FORWARD_CLIENT_PACKET {
+		gs.handleForwardClientPacket(packet)
 	} else if msgtype == proto.MT_SYNC_POSITION_YAW_ON_CLIENTS {
 		gs.handleSyncPositionYawOnClients(packet)
 	} else if msgtype == proto.MT_CALL_FILTERED_CLIENTS {
 		gs.handleCallFilteredClientProxies(packet)
+	} else if msgtype == proto.MT_SET_MAINTENANCE_MODE {
+		gs.handleSetMaintenanceMode(packet)
 	} else {
 		gwlog.Panicf("%s: unknown msg type: %d", gs, msgtype)
 		if consts.DEBUG_MODE {
@@ -191,6 +322,17 @@ func (gs *GateService) handleClearClientFilterProps(clientproxy *ClientProxy, pa
 	}
 }
 
+func (gs *GateService) handleKickClient(clientproxy *ClientProxy, packet *netutil.Packet) {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s.handleKickClient: client %s kicked", gs, clientproxy)
+	}
+	gs.endClientSession(clientproxy) // kicked clients can not resume
+	// let the client know the kick reason before closing
+	clientproxy.SendPacket(packet)
+	clientproxy.Flush()
+	clientproxy.Close()
+}
+
 func (gs *GateService) handleSyncPositionYawOnClients(packet *netutil.Packet) {
 	_ = packet.ReadUint16() // read useless gateid
 	payload := packet.UnreadPayload()
@@ -265,19 +407,32 @@ func (gs *GateService) handleDispatcherClientBeforeFlush() {
 		return
 	}
 
-	packet := pendingSyncPackets[0] // use the first packet for sending
-	if len(packet.UnreadPayload()) != common.ENTITYID_LENGTH+proto.SYNC_INFO_SIZE_PER_ENTITY {
-		gwlog.Panicf("%s.handleDispatcherClientBeforeFlush: entity sync info size should be %d, but received %d", gs, proto.SYNC_INFO_SIZE_PER_ENTITY, len(packet.UnreadPayload())-common.ENTITYID_LENGTH)
-	}
+	// sync infos are merged by dispatchers of entities
+	dispatcherClients := dispatcher_client.GetAllDispatcherClientsForSend()
+	mergedPackets := make([]*netutil.Packet, len(dispatcherClients))
+	for _, syncPkt := range pendingSyncPackets {
+		payload := syncPkt.UnreadPayload()
+		if len(payload) != common.ENTITYID_LENGTH+proto.SYNC_INFO_SIZE_PER_ENTITY {
+			gwlog.Panicf("%s.handleDispatcherClientBeforeFlush: entity sync info size should be %d, but received %d", gs, proto.SYNC_INFO_SIZE_PER_ENTITY, len(payload)-common.ENTITYID_LENGTH)
+		}
 
-	//gwlog.Info("sycn packet payload len %d, unread %d", packet.GetPayloadLen(), len(packet.UnreadPayload()))
-	for _, syncPkt := range pendingSyncPackets[1:] { // merge other packets to the first packet
+		dispid := dispatcher_client.GetDispatcherIDOfEntity(common.EntityID(payload[:common.ENTITYID_LENGTH]))
+		packet := mergedPackets[dispid-1]
+		if packet == nil {
+			mergedPackets[dispid-1] = syncPkt // use the first packet for sending
+			continue
+		}
 		//gwlog.Info("sycn packet unread %d", len(syncPkt.UnreadPayload()))
-		packet.AppendBytes(syncPkt.UnreadPayload())
+		packet.AppendBytes(payload) // merge other packets to the first packet
 		syncPkt.Release()
 	}
-	dispatcher_client.GetDispatcherClientForSend().SendPacket(packet)
-	packet.Release()
+
+	for i, packet := range mergedPackets {
+		if packet != nil {
+			dispatcherClients[i].SendPacket(packet)
+			packet.Release()
+		}
+	}
 }
 
 type packetQueueItem struct { // packet queue from dispatcher client
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/admin"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// Admin endpoints of gate
+
+const (
+	_ADMIN_DEFAULT_CLIENTS_LIMIT = 1000
+)
+
+type adminClientInfo struct {
+	ClientID   common.ClientID
+	RemoteAddr string
+	Encoding   string
+}
+
+func (gs *GateService) setupAdmin(gateConfig *config.GateConfig) {
+	admin.Handle("/clients", gs.adminListClients)
+	admin.Handle("/drain_status", gs.adminDrainStatus)
+	admin.HandleAction("/drain", gs.adminStartDraining)
+	admin.Serve(gateConfig.AdminIp, gateConfig.AdminPort, gateConfig.AdminToken)
+}
+
+// List connected clients
+func (gs *GateService) adminListClients(query url.Values) (interface{}, error) {
+	limit := _ADMIN_DEFAULT_CLIENTS_LIMIT
+	if s := query.Get("limit"); s != "" {
+		var err error
+		if limit, err = strconv.Atoi(s); err != nil || limit <= 0 {
+			return nil, errors.Errorf("invalid limit: %s", s)
+		}
+	}
+
+	gs.clientProxiesLock.RLock()
+	defer gs.clientProxiesLock.RUnlock()
+
+	clients := []adminClientInfo{}
+	for _, cp := range gs.clientProxies {
+		if len(clients) >= limit {
+			break
+		}
+		clients = append(clients, adminClientInfo{
+			ClientID:   cp.clientid,
+			RemoteAddr: cp.RemoteAddr().String(),
+			Encoding:   cp.encoding,
+		})
+	}
+	return map[string]interface{}{
+		"Total":   len(gs.clientProxies),
+		"Clients": clients,
+	}, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/clientauth"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Receive MT_AUTH_CLIENT with the token as the first packet of client (after encoding handshake), and echo the user
+// of the token if accepted, the user is returned
+//
+// The client is not connected to game until the token is accepted, so no entity is bound to the client and no call
+// from the client is forwarded before authentication.
+func (cp *ClientProxy) handshakeAuth(verifier clientauth.Verifier) (string, error) {
+	pkt, err := cp.recvHandshakePacket(proto.MT_AUTH_CLIENT, consts.CLIENT_AUTH_TIMEOUT)
+	if err != nil {
+		return "", err
+	}
+	token := pkt.ReadVarStr()
+	pkt.Release()
+
+	user, err := verifier.Verify(token)
+	if err != nil {
+		return "", err
+	}
+
+	if err := cp.SendAuthClient(user); err != nil {
+		return "", err
+	}
+	gwlog.Info("%s: authenticated as user %s", cp, user)
+	return user, cp.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// IPs of clients banned by games (e.g. for exceeding RPC rate limits), connections from banned IPs are rejected
+// until the ban expires
+
+func ipOfAddr(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+func (gs *GateService) isIPBanned(ip string) bool {
+	gs.bannedIPsLock.Lock()
+	defer gs.bannedIPsLock.Unlock()
+
+	until, ok := gs.bannedIPs[ip]
+	if ok && time.Now().After(until) {
+		delete(gs.bannedIPs, ip)
+		return false
+	}
+	return ok
+}
+
+func (gs *GateService) handleBanClient(clientproxy *ClientProxy, packet *netutil.Packet) {
+	duration := time.Second * time.Duration(packet.ReadUint32())
+	message := packet.ReadVarStr()
+	ip := ipOfAddr(clientproxy.RemoteAddr())
+
+	gs.bannedIPsLock.Lock()
+	gs.bannedIPs[ip] = time.Now().Add(duration)
+	gs.bannedIPsLock.Unlock()
+	gwlog.Warn("%s: %s is banned for %s: %s", gs, ip, duration, message)
+
+	gs.endClientSession(clientproxy) // banned clients can not resume
+	clientproxy.SendKickClient(gateid, clientproxy.clientid, proto.KICK_REASON_BANNED, message)
+	clientproxy.Flush()
+	clientproxy.Close()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Receive MT_SET_CLIENT_ENCODING as the first packet of client, and echo the accepted encoding
+//
+// The handshake is done before the client proxy is connected to game, so packets to the client are always encoded
+// with the negotiated encoding.
+func (cp *ClientProxy) handshakeEncoding() error {
+	pkt, err := cp.recvHandshakePacket(proto.MT_SET_CLIENT_ENCODING, consts.CLIENT_ENCODING_HANDSHAKE_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	defer pkt.Release()
+
+	encoding := pkt.ReadVarStr()
+	if !proto.IsValidClientEncoding(encoding) {
+		return errors.Errorf("unknown encoding: %s", encoding)
+	}
+
+	cp.encoding = encoding
+	if err := cp.SendSetClientEncoding(encoding); err != nil {
+		return err
+	}
+	gwlog.Debug("%s: client encoding is %s", cp, encoding)
+	return cp.Flush()
+}
+
+// Send the packet to client, msgpack data in the packet are transcoded for clients of protobuf encoding
+func (cp *ClientProxy) SendPacket(packet *netutil.Packet) error {
+	if cp.encoding != proto.CLIENT_ENCODING_PROTOBUF {
+		return cp.GoWorldConnection.SendPacket(packet)
+	}
+
+	pkt, err := proto.TranscodeClientPacketToProtobuf(packet)
+	if err != nil {
+		gwlog.Error("%s: transcode packet to protobuf failed: %s", cp, err)
+		return err
+	} else if pkt == nil {
+		return cp.GoWorldConnection.SendPacket(packet)
+	}
+	err = cp.GoWorldConnection.SendPacket(pkt)
+	pkt.Release()
+	return err
+}
+
+// Forward the call from protobuf client to game, which decodes arguments according to the RPC method
+func (cp *ClientProxy) handleCallEntityMethodFromProtobufClient(pkt *netutil.Packet) {
+	payload := pkt.UnreadPayload() // entity id, method and typed message of arguments
+	fwdPkt := netutil.NewPacket()
+	fwdPkt.AppendUint16(proto.MT_CALL_ENTITY_METHOD_FROM_PROTOBUF_CLIENT)
+	fwdPkt.AppendBytes(payload)
+	fwdPkt.AppendClientID(cp.clientid)
+	eid := common.EntityID(payload[:common.ENTITYID_LENGTH])
+	dispatcher_client.GetDispatcherClientForEntity(eid).SendPacket(fwdPkt)
+	fwdPkt.Release()
+}
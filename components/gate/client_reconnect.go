@@ -0,0 +1,176 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Sessions in the reconnect window are resumed on other gates by transferring through dispatchers:
+//
+//  1. the new gate sends MT_TRANSFER_CLIENT_SESSION to the gate of the session (encoded in the token)
+//  2. the old gate drops the session, and sends back MT_TRANSFER_CLIENT_SESSION_ACK with unacknowledged messages
+//     and buffered packets of the session
+//  3. the new gate reattaches the client to the transferred session, and notifies the owner entity of the new gate
+//
+// Packets to the client received by the old gate after the transfer are forwarded to the new gate by
+// MT_FORWARD_CLIENT_PACKET, until the owner entity knows the new gate.
+
+type forwardedClient struct {
+	gateid     uint16
+	expireTime time.Time
+}
+
+// Resume the session on another gate, blocks the routine of the client proxy until the session is transferred
+func (gs *GateService) resumeRemoteClientSession(cp *ClientProxy, gid uint16, oldClientID common.ClientID, token string, ackedSeq uint32) {
+	if gid == 0 || config.GetGate(gateid).ClientReconnectWindow == 0 {
+		gwlog.Warn("%s: %s failed to resume session of client %s on gate %d", gs, cp, oldClientID, gid)
+		cp.SendResumeClientSessionAck(gateid, cp.clientid, false)
+		return
+	}
+
+	transferChan := make(chan *clientSession, 1)
+	gs.clientSessionsLock.Lock()
+	gs.pendingTransfers[oldClientID] = transferChan
+	gs.clientSessionsLock.Unlock()
+	dispatcher_client.GetDispatcherClientForSend().SendTransferClientSession(gid, gateid, oldClientID, token)
+
+	var sess *clientSession
+	select {
+	case sess = <-transferChan:
+	case <-time.After(consts.CLIENT_SESSION_TRANSFER_TIMEOUT):
+		gs.clientSessionsLock.Lock()
+		if gs.pendingTransfers[oldClientID] == transferChan {
+			delete(gs.pendingTransfers, oldClientID)
+		}
+		gs.clientSessionsLock.Unlock()
+	}
+
+	gs.clientSessionsLock.Lock()
+	defer gs.clientSessionsLock.Unlock()
+
+	if sess == nil || gs.clientSessions[oldClientID] != sess || sess.cp != nil {
+		gwlog.Warn("%s: %s failed to resume session of client %s on gate %d", gs, cp, oldClientID, gid)
+		cp.SendResumeClientSessionAck(gateid, cp.clientid, false)
+		return
+	}
+	gs.reattachClientSession(cp, sess, ackedSeq, gid)
+}
+
+// Transfer the disconnected session to the gate of the new connection
+func (gs *GateService) handleTransferClientSession(packet *netutil.Packet) {
+	_ = packet.ReadUint16() // gid
+	toGate := packet.ReadUint16()
+	clientid := packet.ReadClientID()
+	token := packet.ReadVarStr()
+
+	ack := netutil.NewPacket()
+	ack.AppendUint16(proto.MT_TRANSFER_CLIENT_SESSION_ACK)
+	ack.AppendUint16(toGate)
+	ack.AppendUint16(gateid)
+	ack.AppendClientID(clientid)
+
+	gs.clientSessionsLock.Lock()
+	sess := gs.clientSessionsByToken[token]
+	if sess == nil || sess.clientid != clientid || sess.cp != nil || !sess.keepClient {
+		gwlog.Warn("%s: failed to transfer session of client %s to gate %d", gs, clientid, toGate)
+		ack.AppendBool(false)
+	} else {
+		gs.delClientSession(sess)
+		gs.forwardedClients[clientid] = &forwardedClient{gateid: toGate, expireTime: time.Now().Add(consts.CLIENT_SESSION_TRANSFER_TIMEOUT)}
+
+		ack.AppendBool(true)
+		ack.AppendUint32(sess.lastSeq)
+		ack.AppendUint32(uint32(len(sess.unacked)))
+		for _, msg := range sess.unacked {
+			ack.AppendUint32(msg.seq)
+			ack.AppendVarBytes(msg.payload)
+		}
+		ack.AppendUint32(uint32(len(sess.buffered)))
+		for _, payload := range sess.buffered {
+			ack.AppendVarBytes(payload)
+		}
+		gwlog.Info("%s: session of client %s is transferred to gate %d", gs, clientid, toGate)
+	}
+	gs.clientSessionsLock.Unlock()
+
+	dispatcher_client.GetDispatcherClientForSend().SendPacket(ack)
+	ack.Release()
+}
+
+func (gs *GateService) handleTransferClientSessionAck(packet *netutil.Packet) {
+	_ = packet.ReadUint16() // gid
+	fromGate := packet.ReadUint16()
+	clientid := packet.ReadClientID()
+	ok := packet.ReadBool()
+
+	gs.clientSessionsLock.Lock()
+	defer gs.clientSessionsLock.Unlock()
+
+	transferChan := gs.pendingTransfers[clientid]
+	delete(gs.pendingTransfers, clientid)
+	if !ok {
+		if transferChan != nil {
+			transferChan <- nil
+		}
+		return
+	}
+
+	sess := &clientSession{
+		token:      genClientSessionToken(),
+		clientid:   clientid,
+		lastSeq:    packet.ReadUint32(),
+		expireTime: time.Now().Add(config.GetGate(gateid).ClientReconnectWindow),
+		keepClient: true,
+	}
+	for n := packet.ReadUint32(); n > 0; n-- {
+		seq := packet.ReadUint32()
+		payload := append([]byte(nil), packet.ReadVarBytes()...)
+		sess.unacked = append(sess.unacked, ackedMessage{seq: seq, payload: payload})
+	}
+	for n := packet.ReadUint32(); n > 0; n-- {
+		sess.buffered = append(sess.buffered, append([]byte(nil), packet.ReadVarBytes()...))
+	}
+	// the session is kept until the client is reattached, or expires in the reconnect window
+	gs.addClientSession(sess)
+
+	if transferChan != nil {
+		transferChan <- sess
+	} else {
+		gwlog.Warn("%s: session of client %s is transferred from gate %d after timeout", gs, clientid, fromGate)
+	}
+}
+
+// Forward the packet to the gate which the session of the client is transferred to, returns false if not transferred
+func (gs *GateService) forwardClientPacket(clientid common.ClientID, packet *netutil.Packet) bool {
+	gs.clientSessionsLock.Lock()
+	fc := gs.forwardedClients[clientid]
+	gs.clientSessionsLock.Unlock()
+	if fc == nil {
+		return false
+	}
+
+	fwd := netutil.NewPacket()
+	fwd.AppendUint16(proto.MT_FORWARD_CLIENT_PACKET)
+	fwd.AppendUint16(fc.gateid)
+	fwd.AppendVarBytes(packet.Payload())
+	dispatcher_client.GetDispatcherClientForSend().SendPacket(fwd)
+	fwd.Release()
+	return true
+}
+
+// Handle the forwarded packet as if it is received from dispatcher
+func (gs *GateService) handleForwardClientPacket(packet *netutil.Packet) {
+	_ = packet.ReadUint16() // gid
+	fwd := netutil.NewPacket()
+	fwd.AppendBytes(packet.ReadVarBytes())
+	msgtype := proto.MsgType_t(fwd.ReadUint16())
+	gs.HandleDispatcherClientPacket(msgtype, fwd)
+	fwd.Release()
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Client sessions track acknowledged messages (e.g. reward granted, purchase result) sent to clients.
+//
+// Each acknowledged message is numbered by a sequence in the session, and kept by the gate until the client
+// acknowledges it. The session is created on the first acknowledged message, and its token is sent to client.
+// If the client disconnects with unacknowledged messages, it can reconnect and resume the session with the token
+// in consts.CLIENT_SESSION_RESUME_TIMEOUT, then all unacknowledged messages are resent to the new connection.
+//
+// If client_reconnect_window of the gate is set, the session is created for each client when connected. The owner
+// entity is not notified when the client disconnects, and packets to the client are buffered in the window. The
+// client can reconnect to any gate and resume the session with the token, then it is reattached to the owner
+// entity with its old clientid, and the buffered packets are replayed. Sessions resumed on other gates are
+// transferred through dispatchers, see client_reconnect.go.
+
+type ackedMessage struct {
+	seq     uint32
+	payload []byte // entity ID, method and arguments
+}
+
+type clientSession struct {
+	token      string
+	clientid   common.ClientID // clientid of current (or last) connection
+	cp         *ClientProxy    // nil if client is disconnected
+	lastSeq    uint32
+	unacked    []ackedMessage
+	expireTime time.Time // the session expires if not resumed in time after disconnected
+
+	keepClient bool     // the owner entity keeps the client in the reconnect window, the client is reattached on resume
+	buffered   [][]byte // packets to the client since disconnected, replayed on resume
+}
+
+// The token is prefixed by the gate id of the session, so that it can be resumed on other gates
+func genClientSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		gwlog.Panic(err)
+	}
+	return fmt.Sprintf("%04x%s", gateid, hex.EncodeToString(b))
+}
+
+func gateOfClientSessionToken(token string) uint16 {
+	if len(token) < 4 {
+		return 0
+	}
+	gid, err := strconv.ParseUint(token[:4], 16, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(gid)
+}
+
+func (sess *clientSession) send(seq uint32, payload []byte) {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(sess.clientid)
+	packet.AppendUint32(seq)
+	packet.AppendBytes(payload)
+	sess.cp.SendPacket(packet)
+	packet.Release()
+}
+
+func (sess *clientSession) ack(seq uint32) {
+	i := 0
+	for i < len(sess.unacked) && sess.unacked[i].seq <= seq {
+		i += 1
+	}
+	sess.unacked = sess.unacked[i:]
+}
+
+func (gs *GateService) addClientSession(sess *clientSession) {
+	gs.clientSessions[sess.clientid] = sess
+	gs.clientSessionsByToken[sess.token] = sess
+}
+
+func (gs *GateService) delClientSession(sess *clientSession) {
+	delete(gs.clientSessions, sess.clientid)
+	delete(gs.clientSessionsByToken, sess.token)
+}
+
+// Create the session for the connected client if reconnect window is enabled
+func (gs *GateService) onClientSessionConnected(cp *ClientProxy) {
+	gs.clientSessionsLock.Lock()
+	sess := &clientSession{token: genClientSessionToken(), clientid: cp.clientid, cp: cp, keepClient: true}
+	gs.addClientSession(sess)
+	gs.clientSessionsLock.Unlock()
+
+	cp.SendSetClientSession(gateid, cp.clientid, sess.token)
+}
+
+func (gs *GateService) handleCallEntityMethodOnClientAcked(clientid common.ClientID, clientproxy *ClientProxy, packet *netutil.Packet) {
+	gs.clientSessionsLock.Lock()
+	defer gs.clientSessionsLock.Unlock()
+
+	sess := gs.clientSessions[clientid]
+	if sess == nil {
+		if clientproxy == nil {
+			// client already disconnected without session, tell the game
+			dispatcher_client.GetDispatcherClientForSend().SendNotifyClientDisconnected(clientid)
+			return
+		}
+
+		sess = &clientSession{token: genClientSessionToken(), clientid: clientid, cp: clientproxy}
+		gs.addClientSession(sess)
+		clientproxy.SendSetClientSession(gateid, clientid, sess.token)
+	}
+
+	sess.lastSeq += 1
+	payload := append([]byte(nil), packet.UnreadPayload()...)
+	sess.unacked = append(sess.unacked, ackedMessage{seq: sess.lastSeq, payload: payload})
+	if len(sess.unacked) > consts.CLIENT_ACKED_MESSAGE_WINDOW {
+		gwlog.Warn("%s: too many unacknowledged messages of client %s, dropping seq %d", gs, clientid, sess.unacked[0].seq)
+		sess.unacked = sess.unacked[1:]
+	}
+
+	if sess.cp != nil {
+		sess.send(sess.lastSeq, payload)
+	}
+}
+
+// Keep the packet to the disconnected client in the reconnect window, returns false if the client is gone
+func (gs *GateService) keepPacketOfDisconnectedClient(msgtype proto.MsgType_t, clientid common.ClientID, packet *netutil.Packet) bool {
+	gs.clientSessionsLock.Lock()
+	defer gs.clientSessionsLock.Unlock()
+
+	sess := gs.clientSessions[clientid]
+	if sess == nil || !sess.keepClient {
+		return false
+	}
+
+	if msgtype == proto.MT_SET_CLIENTPROXY_FILTER_PROP || msgtype == proto.MT_CLEAR_CLIENTPROXY_FILTER_PROPS {
+		// filter props are set again by the owner entity when the client is resumed
+		return true
+	} else if msgtype == proto.MT_KICK_CLIENT || msgtype == proto.MT_BAN_CLIENT {
+		gs.delClientSession(sess)
+		return false
+	}
+
+	if len(sess.buffered) >= consts.CLIENT_RECONNECT_BUFFER_SIZE {
+		gwlog.Warn("%s: too many packets buffered for disconnected client %s, session dropped", gs, clientid)
+		gs.delClientSession(sess)
+		return false
+	}
+	sess.buffered = append(sess.buffered, append([]byte(nil), packet.Payload()...))
+	return true
+}
+
+func (gs *GateService) handleAckClientMessage(cp *ClientProxy, pkt *netutil.Packet) {
+	seq := pkt.ReadUint32()
+
+	gs.clientSessionsLock.Lock()
+	if sess := gs.clientSessions[cp.clientid]; sess != nil && sess.cp == cp {
+		sess.ack(seq)
+	}
+	gs.clientSessionsLock.Unlock()
+}
+
+func (gs *GateService) handleResumeClientSession(cp *ClientProxy, pkt *netutil.Packet) {
+	oldClientID := pkt.ReadClientID()
+	token := pkt.ReadVarStr()
+	ackedSeq := pkt.ReadUint32()
+
+	if gid := gateOfClientSessionToken(token); gid != gateid {
+		// the session is on another gate
+		gs.resumeRemoteClientSession(cp, gid, oldClientID, token, ackedSeq)
+		return
+	}
+
+	gs.clientSessionsLock.Lock()
+	defer gs.clientSessionsLock.Unlock()
+
+	sess := gs.clientSessionsByToken[token]
+	if sess == nil || sess.clientid != oldClientID || sess.cp != nil {
+		gwlog.Warn("%s: %s failed to resume session of client %s", gs, cp, oldClientID)
+		cp.SendResumeClientSessionAck(gateid, cp.clientid, false)
+		return
+	}
+
+	if sess.keepClient {
+		gs.reattachClientSession(cp, sess, ackedSeq, gateid)
+		return
+	}
+
+	if gs.clientSessions[cp.clientid] != nil { // session should be resumed before receiving any acknowledged message
+		gwlog.Warn("%s: %s can not resume session of client %s after receiving acknowledged messages", gs, cp, oldClientID)
+		cp.SendResumeClientSessionAck(gateid, cp.clientid, false)
+		return
+	}
+
+	delete(gs.clientSessions, oldClientID)
+	sess.clientid = cp.clientid
+	sess.cp = cp
+	sess.expireTime = time.Time{}
+	gs.clientSessions[cp.clientid] = sess
+
+	sess.ack(ackedSeq)
+	cp.SendResumeClientSessionAck(gateid, cp.clientid, false)
+	cp.SendSetClientSession(gateid, cp.clientid, sess.token)
+	for _, msg := range sess.unacked {
+		sess.send(msg.seq, msg.payload)
+	}
+	gwlog.Info("%s: %s resumed session of client %s, %d messages resent", gs, cp, oldClientID, len(sess.unacked))
+}
+
+// Reattach the client proxy to the owner entity of the disconnected session, the client proxy takes the clientid
+// of the session, and the clientid of the new connection is disconnected.
+//
+// Should be called with clientSessionsLock locked in the routine of the client proxy.
+func (gs *GateService) reattachClientSession(cp *ClientProxy, sess *clientSession, ackedSeq uint32, oldGate uint16) {
+	newClientID := cp.clientid
+	if own := gs.clientSessions[newClientID]; own != nil {
+		gs.delClientSession(own)
+	}
+	gs.clearClientFilterProps(cp)
+
+	gs.clientProxiesLock.Lock()
+	delete(gs.clientProxies, newClientID)
+	cp.clientid = sess.clientid
+	gs.clientProxies[cp.clientid] = cp
+	gs.clientProxiesLock.Unlock()
+
+	sess.cp = cp
+	sess.expireTime = time.Time{}
+	sess.ack(ackedSeq)
+	buffered := sess.buffered
+	sess.buffered = nil
+
+	cp.SendResumeClientSessionAck(gateid, cp.clientid, true)
+	cp.SendSetClientSession(gateid, cp.clientid, sess.token)
+	for _, payload := range buffered {
+		packet := netutil.NewPacket()
+		packet.AppendBytes(payload)
+		cp.SendPacket(packet)
+		packet.Release()
+	}
+	for _, msg := range sess.unacked {
+		sess.send(msg.seq, msg.payload)
+	}
+
+	dispatcherClient := dispatcher_client.GetDispatcherClientForSend()
+	dispatcherClient.SendNotifyClientDisconnected(newClientID)
+	dispatcherClient.SendNotifyClientResumed(cp.clientid, oldGate, gateid)
+	gwlog.Info("%s: %s reattached client %s from gate %d, %d packets replayed, %d messages resent", gs, cp, sess.clientid, oldGate, len(buffered), len(sess.unacked))
+}
+
+func (gs *GateService) endClientSession(cp *ClientProxy) {
+	gs.clientSessionsLock.Lock()
+	if sess := gs.clientSessions[cp.clientid]; sess != nil && sess.cp == cp {
+		gs.delClientSession(sess)
+	}
+	gs.clientSessionsLock.Unlock()
+}
+
+// Number of disconnected clients kept for the owner entities in the reconnect window
+func (gs *GateService) getKeptClientCount() int {
+	gs.clientSessionsLock.Lock()
+	defer gs.clientSessionsLock.Unlock()
+
+	count := 0
+	for _, sess := range gs.clientSessions {
+		if sess.cp == nil && sess.keepClient {
+			count += 1
+		}
+	}
+	return count
+}
+
+// Keep the session for resuming if there are unacknowledged messages or reconnect window is enabled,
+// returns true if the client is kept for the owner entity in the reconnect window
+func (gs *GateService) onClientSessionDisconnected(cp *ClientProxy) bool {
+	gs.clientSessionsLock.Lock()
+	defer gs.clientSessionsLock.Unlock()
+
+	sess := gs.clientSessions[cp.clientid]
+	if sess == nil || sess.cp != cp {
+		return false
+	}
+
+	if !sess.keepClient {
+		if len(sess.unacked) == 0 {
+			gs.delClientSession(sess)
+			return false
+		}
+		sess.cp = nil
+		sess.expireTime = time.Now().Add(consts.CLIENT_SESSION_RESUME_TIMEOUT)
+		return false
+	}
+
+	sess.cp = nil
+	sess.expireTime = time.Now().Add(config.GetGate(gateid).ClientReconnectWindow)
+	return true
+}
+
+func (gs *GateService) expireClientSessionsForever() {
+	for {
+		time.Sleep(consts.CLIENT_SESSION_EXPIRE_CHECK_INTERVAL)
+
+		now := time.Now()
+		gs.clientSessionsLock.Lock()
+		for clientid, sess := range gs.clientSessions {
+			if sess.cp == nil && now.After(sess.expireTime) {
+				gwlog.Warn("%s: session of client %s expired, %d messages are not acknowledged", gs, clientid, len(sess.unacked))
+				gs.delClientSession(sess)
+				if sess.keepClient {
+					// the client is not resumed in the reconnect window, tell the game
+					dispatcher_client.GetDispatcherClientForSend().SendNotifyClientDisconnected(clientid)
+				}
+			}
+		}
+		for clientid, fc := range gs.forwardedClients {
+			if now.After(fc.expireTime) {
+				delete(gs.forwardedClients, clientid)
+			}
+		}
+		gs.clientSessionsLock.Unlock()
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Draining mode of gate for rolling upgrades, controlled through the admin server of gate:
+//
+//	GET  /drain_status                                          get the draining status
+//	POST /drain?threshold=N&timeout=SECONDS&message=MESSAGE    start draining
+//
+// The draining gate rejects new connections and notifies connected clients to reconnect to other gates, then
+// shuts down once the number of connected clients drops to the threshold (0 by default) or the timeout expires.
+
+type gateDrainStatus struct {
+	Draining  bool
+	Clients   int
+	Threshold int
+	Deadline  time.Time
+}
+
+func (gs *GateService) adminDrainStatus(query url.Values) (interface{}, error) {
+	return gs.getDrainStatus(), nil
+}
+
+func (gs *GateService) adminStartDraining(query url.Values) (interface{}, error) {
+	threshold := 0
+	timeout := consts.GATE_DRAIN_DEFAULT_TIMEOUT
+	if s := query.Get("threshold"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("invalid threshold: %s", s)
+		}
+		threshold = n
+	}
+	if s := query.Get("timeout"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, errors.Errorf("invalid timeout: %s", s)
+		}
+		timeout = time.Second * time.Duration(n)
+	}
+
+	if !gs.startDraining(threshold, timeout, query.Get("message")) {
+		return nil, errors.New("gate is already draining")
+	}
+	return gs.getDrainStatus(), nil
+}
+
+func (gs *GateService) getDrainStatus() *gateDrainStatus {
+	gs.drainLock.Lock()
+	status := &gateDrainStatus{
+		Draining:  gs.draining.Load(),
+		Threshold: gs.drainThreshold,
+		Deadline:  gs.drainDeadline,
+	}
+	gs.drainLock.Unlock()
+	status.Clients = gs.getClientCount()
+	return status
+}
+
+// Start draining the gate, returns false if the gate is already draining
+func (gs *GateService) startDraining(threshold int, timeout time.Duration, message string) bool {
+	gs.drainLock.Lock()
+	if gs.draining.Load() {
+		gs.drainLock.Unlock()
+		return false
+	}
+	gs.drainThreshold = threshold
+	gs.drainDeadline = time.Now().Add(timeout)
+	gs.draining.Store(true)
+	gs.drainLock.Unlock()
+
+	gs.clientProxiesLock.RLock()
+	gwlog.Info("%s: start draining, %d clients are notified to reconnect, threshold = %d, timeout = %s", gs, len(gs.clientProxies), threshold, timeout)
+	for _, cp := range gs.clientProxies {
+		cp.SendNotifyGateDraining(gateid, cp.clientid, message)
+	}
+	gs.clientProxiesLock.RUnlock()
+
+	go gs.waitDrainedAndQuit(threshold, gs.drainDeadline)
+	return true
+}
+
+func (gs *GateService) waitDrainedAndQuit(threshold int, deadline time.Time) {
+	for {
+		// sessions of disconnected clients are kept for transferring to other gates in the reconnect window
+		clientCount := gs.getClientCount() + gs.getKeptClientCount()
+		if clientCount <= threshold {
+			gwlog.Info("%s: drained, %d clients connected, gate is quitting ...", gs, clientCount)
+			break
+		} else if time.Now().After(deadline) {
+			gwlog.Warn("%s: drain timeout, %d clients still connected, gate is quitting ...", gs, clientCount)
+			break
+		}
+		time.Sleep(consts.GATE_DRAIN_CHECK_INTERVAL)
+	}
+	signalChan <- syscall.SIGTERM // let gate quit
+}
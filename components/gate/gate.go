@@ -57,18 +57,39 @@ func main() {
 		gwlog.Info("SET GOMAXPROCS = %d", gateConfig.GoMaxProcs)
 		runtime.GOMAXPROCS(gateConfig.GoMaxProcs)
 	}
-	if logLevel == "" {
+	logLevelOverridden := logLevel != ""
+	if !logLevelOverridden {
 		logLevel = gateConfig.LogLevel
 	}
-	binutil.SetupGWLog(logLevel, gateConfig.LogFile, gateConfig.LogStderr)
+	gwlog.SetGlobalField("gateid", gateid)
+	binutil.SetupGWLog(logLevel, gateConfig.LogFormat, gateConfig.LogFile, gateConfig.LogStderr)
+	binutil.SetLogModuleLevels(gateConfig.LogModuleLevels)
 
 	binutil.SetupPprofServer(gateConfig.PProfIp, gateConfig.PProfPort)
+	binutil.SetupMetricsServer(gateConfig.MetricsIp, gateConfig.MetricsPort)
 	gateService = newGateService()
+	gateService.setupAdmin(gateConfig)
 	dispatcher_client.Initialize(&dispatcherClientDelegate{}, true)
 	setupSignals()
+	setupConfigReload(logLevelOverridden)
 	gateService.run() // run gate service in another goroutine
 }
 
+// Apply changeable settings of gate when config is reloaded, max_clients is read from config for each connection
+func setupConfigReload(logLevelOverridden bool) {
+	config.OnReload(func(cfg *config.GoWorldConfig) {
+		gateConfig := cfg.Gates[int(gateid)]
+		if gateConfig == nil {
+			return
+		}
+		if !logLevelOverridden {
+			binutil.SetLogLevel(gateConfig.LogLevel)
+		}
+		binutil.SetLogModuleLevels(gateConfig.LogModuleLevels)
+	})
+	config.Watch()
+}
+
 func setupSignals() {
 	gwlog.Info("Setup signals ...")
 	signal.Ignore(syscall.Signal(10), syscall.Signal(12))
@@ -96,7 +117,7 @@ type dispatcherClientDelegate struct {
 
 func (delegate *dispatcherClientDelegate) OnDispatcherClientConnect(dispatcherClient *dispatcher_client.DispatcherClient, isReconnect bool) {
 	// called when connected / reconnected to dispatcher (not in main routine)
-	dispatcherClient.SendSetGateID(gateid)
+	dispatcherClient.SendSetGateID(gateid, config.GetDispatcherByID(dispatcherClient.GetDispatcherID()).Secret)
 }
 
 var lastWarnGateServiceQueueLen = 0
@@ -113,8 +134,13 @@ func (delegate *dispatcherClientDelegate) HandleDispatcherClientPacket(msgtype p
 	}
 }
 
-func (delegate *dispatcherClientDelegate) HandleDispatcherClientDisconnect() {
+func (delegate *dispatcherClientDelegate) HandleDispatcherClientDisconnect(dispatcherClient *dispatcher_client.DispatcherClient) {
 	//gwlog.Error("Disconnected from dispatcher, try reconnecting ...")
+	if config.GetDispatcherByID(dispatcherClient.GetDispatcherID()).HasStandby() {
+		// clients are kept, since routing tables are replicated to standby dispatcher
+		gwlog.Warn("Disconnected from dispatcher, reconnecting for failover ...")
+		return
+	}
 	// if gate is disconnected from dispatcher, we just quit
 	gwlog.Info("Disconnected from dispatcher, gate has to quit.")
 	signalChan <- syscall.SIGTERM // let gate quit
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+func getKCPOptions(cfg *config.GateConfig) netutil.KCPOptions {
+	return netutil.KCPOptions{
+		MTU:        cfg.KCPMTU,
+		SendWindow: cfg.KCPSendWindow,
+		RecvWindow: cfg.KCPRecvWindow,
+		NoDelay:    cfg.KCPNoDelay,
+	}
+}
+
+// KCP sessions are served in the same way as TCP connections
+func (gs *GateService) serveKCPForever(cfg *config.GateConfig) {
+	listenAddr := fmt.Sprintf("%s:%d", cfg.Ip, cfg.KCPPort)
+	for {
+		err := netutil.ServeKCP(listenAddr, getKCPOptions(cfg), gs)
+		gwlog.Error("KCP server@%s failed with error: %v, will restart after %s", listenAddr, err, netutil.RESTART_TCP_SERVER_INTERVAL)
+		time.Sleep(netutil.RESTART_TCP_SERVER_INTERVAL)
+	}
+}
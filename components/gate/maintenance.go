@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Maintenance mode pushed by dispatchers, connections of authenticated users not in allowlist are rejected with the
+// maintenance message. Clients connected without client auth are checked by dispatcher when logins are registered.
+
+type maintenanceState struct {
+	enabled   bool
+	message   string
+	allowlist common.StringSet
+}
+
+func (gs *GateService) handleSetMaintenanceMode(packet *netutil.Packet) {
+	enabled := packet.ReadBool()
+	message := packet.ReadVarStr()
+	allowlist := common.StringSet{}
+	for _, user := range packet.ReadStringList() {
+		allowlist.Add(user)
+	}
+
+	gwlog.Info("%s: set maintenance mode: enabled=%v, message=%s, allowlist=%v", gs, enabled, message, allowlist.ToList())
+	gs.maintenanceLock.Lock()
+	gs.maintenance = maintenanceState{enabled: enabled, message: message, allowlist: allowlist}
+	gs.maintenanceLock.Unlock()
+}
+
+// Check if the user is allowed to connect, the maintenance message is returned if not
+func (gs *GateService) isLoginAllowed(user string) (bool, string) {
+	gs.maintenanceLock.RLock()
+	defer gs.maintenanceLock.RUnlock()
+
+	if !gs.maintenance.enabled || gs.maintenance.allowlist.Contains(user) {
+		return true, ""
+	}
+	return false, gs.maintenance.message
+}
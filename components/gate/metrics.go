@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+var (
+	clientsMetric = metrics.NewGauge("goworld_gate_clients",
+		"Number of clients connected to gate")
+	clientConnectionsMetric = metrics.NewCounter("goworld_gate_client_connections_total",
+		"Number of client connections accepted by gate, including TCP, WebSocket and KCP")
+)
+
+// Register metrics collected from gate service when scraped
+func (gs *GateService) registerMetrics() {
+	metrics.NewGaugeFunc("goworld_gate_packet_queue_length", "Number of packets from dispatchers waiting to be handled", func() float64 {
+		return float64(gs.packetQueue.Len())
+	})
+}
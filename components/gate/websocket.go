@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"golang.org/x/net/websocket"
+)
+
+// WebSocket clients (e.g. browsers and WeChat minigames) speak the same client protocol as TCP clients, in binary
+// frames. The listener serves wss if TLS cert is configured, and only accepts allowed origins if configured.
+
+func (gs *GateService) serveWebSocketForever(cfg *config.GateConfig) {
+	listenAddr := fmt.Sprintf("%s:%d", cfg.Ip, cfg.WebSocketPort)
+	mux := http.NewServeMux()
+	mux.Handle(cfg.WebSocketPath, websocket.Server{
+		Handshake: gs.checkWebSocketOrigin,
+		Handler:   gs.handleWebSocketConnection,
+	})
+
+	for {
+		var err error
+		if cfg.IsWebSocketTLSEnabled() {
+			gwlog.Info("Listening on WebSocket: wss://%s%s ...", listenAddr, cfg.WebSocketPath)
+			err = http.ListenAndServeTLS(listenAddr, cfg.WebSocketTLSCert, cfg.WebSocketTLSKey, mux)
+		} else {
+			gwlog.Info("Listening on WebSocket: ws://%s%s ...", listenAddr, cfg.WebSocketPath)
+			err = http.ListenAndServe(listenAddr, mux)
+		}
+
+		gwlog.Error("WebSocket server@%s failed with error: %v, will restart after %s", listenAddr, err, netutil.RESTART_TCP_SERVER_INTERVAL)
+		time.Sleep(netutil.RESTART_TCP_SERVER_INTERVAL)
+	}
+}
+
+func (gs *GateService) checkWebSocketOrigin(wsConfig *websocket.Config, req *http.Request) error {
+	origin, err := websocket.Origin(wsConfig, req)
+	if err != nil {
+		return err
+	}
+	wsConfig.Origin = origin
+
+	allowedOrigins := config.GetGate(gateid).WebSocketOrigins
+	if len(allowedOrigins) == 0 {
+		return nil
+	}
+
+	if origin != nil {
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || allowed == origin.String() {
+				return nil
+			}
+		}
+	}
+	return errors.Errorf("WebSocket origin %v is not allowed", origin)
+}
+
+func (gs *GateService) handleWebSocketConnection(wsConn *websocket.Conn) {
+	wsConn.PayloadType = websocket.BinaryFrame
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s: WebSocket client connected from %s", gs, wsConn.Request().RemoteAddr)
+	}
+	gs.serveClientConnection(wsConn) // the connection is closed when handler returns
+}
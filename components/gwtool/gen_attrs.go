@@ -0,0 +1,365 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+const (
+	genAttrsHeader = "// Code generated by gwtool gen-attrs. DO NOT EDIT.\n\n"
+)
+
+type attrSchema struct {
+	name     string
+	attrType string
+	client   bool // synced to clients
+}
+
+type entitySchema struct {
+	typeName string // registered entity type name
+	goType   string // go type of entity struct
+	attrs    []attrSchema
+}
+
+// genAttrs parses RegisterEntity(...).DefineAttrs(...) calls in the package and generates typed accessors
+func genAttrs(args []string) error {
+	fs := flag.NewFlagSet("gen-attrs", flag.ExitOnError)
+	dir := fs.String("dir", ".", "package directory")
+	output := fs.String("o", "attrs_gen.go", "output file name in package directory")
+	fs.Parse(args)
+
+	outputPath := filepath.Join(*dir, *output)
+	fset, pkg, err := parsePackageDir(*dir, *output)
+	if err != nil {
+		return err
+	}
+
+	schemas, err := parseEntitySchemas(fset, pkg)
+	if err != nil {
+		return err
+	}
+	if len(schemas) == 0 {
+		return errors.Errorf("no typed attribute found in %s", *dir)
+	}
+
+	src, err := generateAttrAccessors(pkg.Name, schemas, collectMethods(pkg))
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(outputPath, src, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("generated %s for %d entity types\n", outputPath, len(schemas))
+	return nil
+}
+
+// parse the package in dir, excluding tests and the generated file
+func parsePackageDir(dir string, generated string) (*token.FileSet, *ast.Package, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != generated
+	}, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, nil, errors.Errorf("expect 1 package in %s, found %d", dir, len(pkgs))
+	}
+
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+	return fset, pkg, nil
+}
+
+func parseEntitySchemas(fset *token.FileSet, pkg *ast.Package) ([]*entitySchema, error) {
+	var schemas []*entitySchema
+	var parseErr error
+
+	for _, file := range pkg.Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if parseErr != nil {
+				return false
+			}
+
+			call, ok := node.(*ast.CallExpr)
+			if !ok || !isSelectorCall(call, "DefineAttrs") || len(call.Args) != 1 {
+				return true
+			}
+
+			register, ok := call.Fun.(*ast.SelectorExpr).X.(*ast.CallExpr)
+			if !ok || !isSelectorCall(register, "RegisterEntity") || len(register.Args) != 2 {
+				return true
+			}
+
+			schema, err := parseEntitySchema(register, call.Args[0])
+			if err != nil {
+				parseErr = errors.Wrap(err, fset.Position(call.Pos()).String())
+				return false
+			}
+			if len(schema.attrs) > 0 {
+				schemas = append(schemas, schema)
+			}
+			return true
+		})
+	}
+
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].typeName < schemas[j].typeName
+	})
+	return schemas, parseErr
+}
+
+func isSelectorCall(call *ast.CallExpr, name string) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	return ok && sel.Sel.Name == name
+}
+
+// parse entity type name and go type of RegisterEntity(name, &T{}) call
+func parseRegisterEntity(register *ast.CallExpr) (*entitySchema, error) {
+	typeName, err := stringLiteral(register.Args[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "entity type name")
+	}
+
+	unary, ok := register.Args[1].(*ast.UnaryExpr)
+	if !ok || unary.Op != token.AND {
+		return nil, errors.Errorf("entity %s: expect &T{} as entity pointer", typeName)
+	}
+	lit, ok := unary.X.(*ast.CompositeLit)
+	if !ok {
+		return nil, errors.Errorf("entity %s: expect &T{} as entity pointer", typeName)
+	}
+	ident, ok := lit.Type.(*ast.Ident)
+	if !ok {
+		return nil, errors.Errorf("entity %s: entity type should be defined in the same package", typeName)
+	}
+	return &entitySchema{typeName: typeName, goType: ident.Name}, nil
+}
+
+func parseEntitySchema(register *ast.CallExpr, defs ast.Expr) (*entitySchema, error) {
+	schema, err := parseRegisterEntity(register)
+	if err != nil {
+		return nil, err
+	}
+	typeName := schema.typeName
+
+	defsLit, ok := defs.(*ast.CompositeLit)
+	if !ok {
+		return nil, errors.Errorf("entity %s: attribute definitions should be a map literal", typeName)
+	}
+
+	for _, elt := range defsLit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			return nil, errors.Errorf("entity %s: invalid attribute definition", typeName)
+		}
+
+		attr, err := stringLiteral(kv.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "entity %s: attribute name", typeName)
+		}
+
+		propsLit, ok := kv.Value.(*ast.CompositeLit)
+		if !ok {
+			return nil, errors.Errorf("entity %s: attribute %s: properties should be a list literal", typeName, attr)
+		}
+
+		a := attrSchema{name: attr}
+		for _, p := range propsLit.Elts {
+			prop, err := stringLiteral(p)
+			if err != nil {
+				return nil, errors.Wrapf(err, "entity %s: attribute %s", typeName, attr)
+			}
+			if attrType, ok := entity.ParseAttrType(prop); ok {
+				a.attrType = attrType
+			} else if strings.EqualFold(prop, "Client") || strings.EqualFold(prop, "AllClients") {
+				a.client = true
+			}
+		}
+		if a.attrType != "" {
+			schema.attrs = append(schema.attrs, a)
+		}
+	}
+
+	sort.Slice(schema.attrs, func(i, j int) bool {
+		return schema.attrs[i].name < schema.attrs[j].name
+	})
+	return schema, nil
+}
+
+func stringLiteral(expr ast.Expr) (string, error) {
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", errors.Errorf("expect string literal")
+	}
+	return strconv.Unquote(lit.Value)
+}
+
+// methods promoted from entity.Entity, which should not be shadowed by generated accessors
+func entityMethods() map[string]bool {
+	methods := map[string]bool{}
+	entityPtrType := reflect.TypeOf(&entity.Entity{})
+	for i := 0; i < entityPtrType.NumMethod(); i++ {
+		methods[entityPtrType.Method(i).Name] = true
+	}
+	return methods
+}
+
+// collect declared methods and RPC names of each type, so that generated accessors do not conflict with them
+func collectMethods(pkg *ast.Package) map[string]map[string]bool {
+	methods := map[string]map[string]bool{}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 {
+				continue
+			}
+
+			recvType := fn.Recv.List[0].Type
+			if star, ok := recvType.(*ast.StarExpr); ok {
+				recvType = star.X
+			}
+			ident, ok := recvType.(*ast.Ident)
+			if !ok {
+				continue
+			}
+
+			if methods[ident.Name] == nil {
+				methods[ident.Name] = map[string]bool{}
+			}
+			// methods are also RPCs, so client RPC names can not be used either
+			name := fn.Name.Name
+			methods[ident.Name][name] = true
+			methods[ident.Name][strings.TrimSuffix(strings.TrimSuffix(name, "_AllClient"), "_Client")] = true
+		}
+	}
+	return methods
+}
+
+var attrTypeGetters = map[string]string{
+	entity.ATTR_TYPE_BOOL:    "GetBool",
+	entity.ATTR_TYPE_INT:     "GetInt",
+	entity.ATTR_TYPE_INT64:   "GetInt64",
+	entity.ATTR_TYPE_UINT64:  "GetUint64",
+	entity.ATTR_TYPE_FLOAT64: "GetFloat",
+	entity.ATTR_TYPE_STRING:  "GetStr",
+}
+
+type accessorWriter struct {
+	buf           bytes.Buffer
+	schema        *entitySchema
+	methods       map[string]bool
+	entityMethods map[string]bool
+	recv          string
+	usesType      bool // uses entity.MapAttr or entity.ListAttr
+}
+
+func (w *accessorWriter) method(name string, signature string, body string) {
+	if w.methods[name] || w.entityMethods[name] {
+		fmt.Fprintf(os.Stderr, "gwtool gen-attrs: %s.%s already declared, skipped\n", w.schema.goType, name)
+		return
+	}
+	fmt.Fprintf(&w.buf, "func (%s *%s) %s%s {\n\t%s\n}\n\n", w.recv, w.schema.goType, name, signature, body)
+}
+
+func generateAttrAccessors(pkgName string, schemas []*entitySchema, methods map[string]map[string]bool) ([]byte, error) {
+	var body bytes.Buffer
+	usesEntity := false
+	promoted := entityMethods()
+
+	for _, schema := range schemas {
+		w := &accessorWriter{
+			schema:        schema,
+			methods:       methods[schema.goType],
+			entityMethods: promoted,
+			recv:          strings.ToLower(schema.goType[:1]),
+		}
+
+		fmt.Fprintf(&w.buf, "// Typed attribute accessors of entity type %s\n\n", schema.typeName)
+		for _, attr := range schema.attrs {
+			w.writeAttr(attr)
+		}
+
+		body.Write(w.buf.Bytes())
+		usesEntity = usesEntity || w.usesType
+	}
+
+	var src bytes.Buffer
+	src.WriteString(genAttrsHeader)
+	fmt.Fprintf(&src, "package %s\n\n", pkgName)
+	if usesEntity {
+		src.WriteString("import \"github.com/xiaonanln/goworld/engine/entity\"\n\n")
+	}
+	src.Write(body.Bytes())
+
+	formatted, err := format.Source(src.Bytes())
+	if err != nil {
+		return nil, errors.Wrap(err, "format generated source")
+	}
+	return formatted, nil
+}
+
+func (w *accessorWriter) writeAttr(attr attrSchema) {
+	name := exportedName(attr.name)
+	key := strconv.Quote(attr.name)
+	recv := w.recv
+
+	if attr.attrType == entity.ATTR_TYPE_MAPATTR {
+		w.usesType = true
+		w.method("Get"+name, "() *entity.MapAttr", fmt.Sprintf("return %s.Attrs.GetMapAttr(%s)", recv, key))
+		w.method("Set"+name, "(v *entity.MapAttr)", fmt.Sprintf("%s.Attrs.Set(%s, v)", recv, key))
+		return
+	}
+
+	if elem, ok := entity.SplitListAttrType(attr.attrType); ok {
+		w.usesType = true
+		w.method("Get"+name, "() *entity.ListAttr", fmt.Sprintf("return %s.Attrs.GetListAttr(%s)", recv, key))
+		w.method("Set"+name, "(v *entity.ListAttr)", fmt.Sprintf("%s.Attrs.Set(%s, v)", recv, key))
+		if elem != "" {
+			w.method("Get"+name+"At", fmt.Sprintf("(index int) %s", elem), fmt.Sprintf("return %s.Attrs.GetListAttr(%s).%s(index)", recv, key, attrTypeGetters[elem]))
+			w.method("Set"+name+"At", fmt.Sprintf("(index int, v %s)", elem), fmt.Sprintf("%s.Attrs.GetListAttr(%s).Set(index, v)", recv, key))
+			w.method("Append"+name, fmt.Sprintf("(v %s)", elem), fmt.Sprintf("%s.Attrs.GetListAttr(%s).Append(v)", recv, key))
+		}
+		return
+	}
+
+	w.method("Get"+name, "() "+attr.attrType, fmt.Sprintf("return %s.Attrs.%s(%s)", recv, attrTypeGetters[attr.attrType], key))
+	w.method("Set"+name, fmt.Sprintf("(v %s)", attr.attrType), fmt.Sprintf("%s.Attrs.Set(%s, v)", recv, key))
+}
+
+// convert attribute name like "lastMailID" or "last_mail_id" to exported name
+func exportedName(attr string) string {
+	var name []rune
+	upper := true
+	for _, r := range attr {
+		if r == '_' || r == '-' || r == ' ' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		name = append(name, r)
+	}
+	return string(name)
+}
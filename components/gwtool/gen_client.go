@@ -0,0 +1,801 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+const (
+	genClientHeader = "// Code generated by gwtool gen-client. DO NOT EDIT.\n\n"
+)
+
+var (
+	tsIdentifierRegexp = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]*$`)
+
+	// message types used by the client SDK
+	clientMsgTypes = []struct {
+		name    string
+		msgtype proto.MsgType_t
+	}{
+		{"MT_CALL_ENTITY_METHOD_FROM_CLIENT", proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT},
+		{"MT_SYNC_POSITION_YAW_FROM_CLIENT", proto.MT_SYNC_POSITION_YAW_FROM_CLIENT},
+		{"MT_ACK_CLIENT_MESSAGE", proto.MT_ACK_CLIENT_MESSAGE},
+		{"MT_RESUME_CLIENT_SESSION", proto.MT_RESUME_CLIENT_SESSION},
+		{"MT_SET_CLIENT_ENCODING", proto.MT_SET_CLIENT_ENCODING},
+		{"MT_AUTH_CLIENT", proto.MT_AUTH_CLIENT},
+		{"MT_CREATE_ENTITY_ON_CLIENT", proto.MT_CREATE_ENTITY_ON_CLIENT},
+		{"MT_DESTROY_ENTITY_ON_CLIENT", proto.MT_DESTROY_ENTITY_ON_CLIENT},
+		{"MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT", proto.MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT},
+		{"MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT", proto.MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT},
+		{"MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT", proto.MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT},
+		{"MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT", proto.MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT},
+		{"MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT", proto.MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT},
+		{"MT_CALL_ENTITY_METHOD_ON_CLIENT", proto.MT_CALL_ENTITY_METHOD_ON_CLIENT},
+		{"MT_UPDATE_POSITION_ON_CLIENT", proto.MT_UPDATE_POSITION_ON_CLIENT},
+		{"MT_UPDATE_YAW_ON_CLIENT", proto.MT_UPDATE_YAW_ON_CLIENT},
+		{"MT_KICK_CLIENT", proto.MT_KICK_CLIENT},
+		{"MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED", proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED},
+		{"MT_SET_CLIENT_SESSION", proto.MT_SET_CLIENT_SESSION},
+		{"MT_NOTIFY_GATE_DRAINING", proto.MT_NOTIFY_GATE_DRAINING},
+		{"MT_RESUME_CLIENT_SESSION_ACK", proto.MT_RESUME_CLIENT_SESSION_ACK},
+		{"MT_CALL_FILTERED_CLIENTS", proto.MT_CALL_FILTERED_CLIENTS},
+		{"MT_SYNC_POSITION_YAW_ON_CLIENTS", proto.MT_SYNC_POSITION_YAW_ON_CLIENTS},
+	}
+
+	tsScalarTypes = map[string]string{ // go type -> typescript type
+		"bool":             "boolean",
+		"int":              "number",
+		"int8":             "number",
+		"int16":            "number",
+		"int32":            "number",
+		"int64":            "number",
+		"uint":             "number",
+		"uint8":            "number",
+		"byte":             "number",
+		"uint16":           "number",
+		"uint32":           "number",
+		"uint64":           "number",
+		"float32":          "number",
+		"float64":          "number",
+		"string":           "string",
+		"[]byte":           "Uint8Array",
+		"[]uint8":          "Uint8Array",
+		"interface{}":      "any",
+		"EntityID":         "string",
+		"common.EntityID":  "string",
+		"ClientID":         "string",
+		"common.ClientID":  "string",
+		"entity.Coord":     "number",
+		"entity.Yaw":       "number",
+		"entity.MapAttr":   "{ [key: string]: any }",
+		"*entity.MapAttr":  "{ [key: string]: any }",
+		"entity.ListAttr":  "any[]",
+		"*entity.ListAttr": "any[]",
+	}
+
+	tsAttrTypes = map[string]string{ // attribute type -> typescript type
+		entity.ATTR_TYPE_BOOL:     "boolean",
+		entity.ATTR_TYPE_INT:      "number",
+		entity.ATTR_TYPE_INT64:    "number",
+		entity.ATTR_TYPE_UINT64:   "number",
+		entity.ATTR_TYPE_FLOAT64:  "number",
+		entity.ATTR_TYPE_STRING:   "string",
+		entity.ATTR_TYPE_MAPATTR:  "{ [key: string]: any }",
+		entity.ATTR_TYPE_LISTATTR: "any[]",
+	}
+
+	// members of ClientEntity, which can not be used by generated attributes and RPC stubs
+	tsClientEntityMembers = map[string]bool{
+		"id": true, "typeName": true, "isPlayer": true, "attrs": true, "position": true, "yaw": true, "client": true,
+		"callServer": true, "onCreated": true, "onDestroy": true, "onAttrChange": true, "onPositionYawChange": true,
+		"constructor": true,
+	}
+)
+
+// genClient parses registered entity types in the package and generates client SDK of the language
+func genClient(args []string) error {
+	fs := flag.NewFlagSet("gen-client", flag.ExitOnError)
+	lang := fs.String("lang", "ts", "language of the client SDK: ts (TypeScript) or cs (C# for Unity)")
+	dir := fs.String("dir", ".", "package directory")
+	output := fs.String("o", "", "output file name in package directory, goworld.ts or GoWorldEntities.cs by default")
+	csNamespace := fs.String("cs-namespace", "GoWorldEntities", "namespace of generated C# classes")
+	csBase := fs.String("cs-base", "GoWorldUnity3D.ClientEntity", "base class of generated C# entity classes")
+	fs.Parse(args)
+
+	if *lang != "ts" && *lang != "cs" {
+		return errors.Errorf("unsupported language: %s", *lang)
+	}
+	if *output == "" && *lang == "ts" {
+		*output = "goworld.ts"
+	} else if *output == "" {
+		*output = "GoWorldEntities.cs"
+	}
+
+	outputPath := filepath.Join(*dir, *output)
+	fset, pkg, err := parsePackageDir(*dir, *output)
+	if err != nil {
+		return err
+	}
+
+	schemas, err := parseRegisteredEntities(fset, pkg)
+	if err != nil {
+		return err
+	}
+	if len(schemas) == 0 {
+		return errors.Errorf("no registered entity type found in %s", *dir)
+	}
+
+	var src []byte
+	if *lang == "ts" {
+		src = generateTypeScriptClient(schemas, collectClientRPCs(pkg))
+	} else {
+		src = generateCSharpClient(*csNamespace, *csBase, schemas, collectClientRPCs(pkg))
+	}
+	if err := ioutil.WriteFile(outputPath, src, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("generated %s for %d entity types\n", outputPath, len(schemas))
+	return nil
+}
+
+// convert go type of RPC argument to typescript type, any if not convertible
+func tsTypeOf(goType string) string {
+	if t, ok := tsScalarTypes[goType]; ok {
+		return t
+	}
+	if strings.HasPrefix(goType, "[]") {
+		return tsTypeOf(goType[2:]) + "[]"
+	}
+	if strings.HasPrefix(goType, "map[string]") {
+		return "{ [key: string]: " + tsTypeOf(goType[len("map[string]"):]) + " }"
+	}
+	return "any"
+}
+
+func tsAttrTypeOf(attrType string) string {
+	if elem, ok := entity.SplitListAttrType(attrType); ok && elem != "" {
+		return tsAttrTypes[elem] + "[]"
+	}
+	return tsAttrTypes[attrType]
+}
+
+func generateTypeScriptClient(schemas []*entitySchema, rpcs map[string][]rpcSchema) []byte {
+	var src bytes.Buffer
+	src.WriteString(genClientHeader)
+	src.WriteString("import { encode, decode } from \"@msgpack/msgpack\";\n\n")
+	for _, mt := range clientMsgTypes {
+		fmt.Fprintf(&src, "const %s = %d;\n", mt.name, mt.msgtype)
+	}
+	fmt.Fprintf(&src, "\nconst ENTITYID_LENGTH = %d;\n", common.ENTITYID_LENGTH)
+	fmt.Fprintf(&src, "const CLIENTID_LENGTH = %d;\n", common.CLIENTID_LENGTH)
+	fmt.Fprintf(&src, "const SPACE_ENTITY_TYPE = %q;\n", entity.SPACE_ENTITY_TYPE)
+	fmt.Fprintf(&src, "const CLIENT_ENCODING_MSGPACK = %q;\n", proto.CLIENT_ENCODING_MSGPACK)
+	src.WriteString(tsClientRuntime)
+
+	var typeNames []string
+	for _, schema := range schemas {
+		if !tsIdentifierRegexp.MatchString(schema.typeName) {
+			fmt.Fprintf(os.Stderr, "gwtool gen-client: entity type %s is not a valid identifier, skipped\n", schema.typeName)
+			continue
+		}
+		typeNames = append(typeNames, schema.typeName)
+		writeTypeScriptEntity(&src, schema, rpcs[schema.goType])
+	}
+
+	src.WriteString("\n// Entity classes by entity type names, overridden by GoWorldClient.registerEntityType\n")
+	src.WriteString("export const ENTITY_TYPES: { [typeName: string]: EntityClass } = {\n")
+	for _, typeName := range typeNames {
+		fmt.Fprintf(&src, "  %s: %s,\n", typeName, typeName)
+	}
+	src.WriteString("};\n")
+	return src.Bytes()
+}
+
+func writeTypeScriptEntity(src *bytes.Buffer, schema *entitySchema, typeRpcs []rpcSchema) {
+	declared := map[string]bool{}
+	declare := func(name string) bool {
+		if tsClientEntityMembers[name] || declared[name] || !tsIdentifierRegexp.MatchString(name) {
+			fmt.Fprintf(os.Stderr, "gwtool gen-client: %s.%s conflicts or is not a valid identifier, skipped\n", schema.typeName, name)
+			return false
+		}
+		declared[name] = true
+		return true
+	}
+
+	var members []string
+	for _, attr := range schema.attrs {
+		if attr.client && declare(attr.name) {
+			members = append(members, fmt.Sprintf("  get %s(): %s {\n    return this.attrs[%q];\n  }\n", attr.name, tsAttrTypeOf(attr.attrType), attr.name))
+		}
+	}
+	for _, rpc := range typeRpcs {
+		if !declare(rpc.name) {
+			continue
+		}
+		var params, args []string
+		for _, param := range rpc.params {
+			params = append(params, fmt.Sprintf("%s: %s", param.name, tsTypeOf(param.goType)))
+			args = append(args, ", "+param.name)
+		}
+		members = append(members, fmt.Sprintf("  %s(%s): void {\n    this.callServer(%q%s);\n  }\n", rpc.name, strings.Join(params, ", "), rpc.name, strings.Join(args, "")))
+	}
+
+	fmt.Fprintf(src, "\n// Entity type %s\n", schema.typeName)
+	fmt.Fprintf(src, "export class %s extends ClientEntity {\n", schema.typeName)
+	src.WriteString(strings.Join(members, "\n"))
+	src.WriteString("}\n")
+}
+
+// Runtime of the TypeScript client SDK, which connects to WebSocket of gates with msgpack encoding
+const tsClientRuntime = `
+const PAYLOAD_LEN_MASK = 0x7fffffff;
+const COMPRESSED_BIT_MASK = 0x80000000;
+
+const textEncoder = new TextEncoder();
+const textDecoder = new TextDecoder();
+
+class PacketReader {
+  private view: DataView;
+  private pos = 0;
+
+  constructor(private bytes: Uint8Array) {
+    this.view = new DataView(bytes.buffer, bytes.byteOffset, bytes.byteLength);
+  }
+
+  hasUnread(): boolean {
+    return this.pos < this.bytes.length;
+  }
+
+  readBool(): boolean {
+    return this.bytes[this.pos++] !== 0;
+  }
+
+  readUint16(): number {
+    const v = this.view.getUint16(this.pos, true);
+    this.pos += 2;
+    return v;
+  }
+
+  readUint32(): number {
+    const v = this.view.getUint32(this.pos, true);
+    this.pos += 4;
+    return v;
+  }
+
+  readFloat32(): number {
+    const v = this.view.getFloat32(this.pos, true);
+    this.pos += 4;
+    return v;
+  }
+
+  readBytes(n: number): Uint8Array {
+    const b = this.bytes.subarray(this.pos, this.pos + n);
+    this.pos += n;
+    return b;
+  }
+
+  readID(n: number): string {
+    return textDecoder.decode(this.readBytes(n));
+  }
+
+  readVarStr(): string {
+    return textDecoder.decode(this.readBytes(this.readUint32()));
+  }
+
+  readData(): any {
+    return decode(this.readBytes(this.readUint32()));
+  }
+
+  readArgs(): any[] {
+    const args: any[] = [];
+    for (let n = this.readUint16(); n > 0; n--) {
+      args.push(this.readData());
+    }
+    return args;
+  }
+}
+
+class PacketWriter {
+  private bytes = new Uint8Array(64);
+  private len = 4; // payload length is filled by finish
+
+  constructor(msgtype: number) {
+    this.appendUint16(msgtype);
+  }
+
+  private grow(n: number): DataView {
+    if (this.len + n > this.bytes.length) {
+      const bytes = new Uint8Array(Math.max(this.bytes.length * 2, this.len + n));
+      bytes.set(this.bytes.subarray(0, this.len));
+      this.bytes = bytes;
+    }
+    return new DataView(this.bytes.buffer);
+  }
+
+  appendUint16(v: number): void {
+    this.grow(2).setUint16(this.len, v, true);
+    this.len += 2;
+  }
+
+  appendUint32(v: number): void {
+    this.grow(4).setUint32(this.len, v, true);
+    this.len += 4;
+  }
+
+  appendFloat32(v: number): void {
+    this.grow(4).setFloat32(this.len, v, true);
+    this.len += 4;
+  }
+
+  appendBytes(b: Uint8Array): void {
+    this.grow(b.length);
+    this.bytes.set(b, this.len);
+    this.len += b.length;
+  }
+
+  appendID(id: string): void {
+    this.appendBytes(textEncoder.encode(id));
+  }
+
+  appendVarStr(s: string): void {
+    const b = textEncoder.encode(s);
+    this.appendUint32(b.length);
+    this.appendBytes(b);
+  }
+
+  appendArgs(args: any[]): void {
+    this.appendUint16(args.length);
+    for (const arg of args) {
+      const b = encode(arg);
+      this.appendUint32(b.length);
+      this.appendBytes(b);
+    }
+  }
+
+  finish(): Uint8Array {
+    new DataView(this.bytes.buffer).setUint32(0, this.len - 4, true);
+    return this.bytes.subarray(0, this.len);
+  }
+}
+
+// Path of the attribute container, from the container up to the root attributes, e.g. ["items", "bag"] for attrs.bag.items
+export type AttrPath = (string | number)[];
+
+export type EntityClass = new (client: GoWorldClient, typeName: string, id: string) => ClientEntity;
+
+// Entity on client, calls from server are dispatched to methods of entities with the same name
+export class ClientEntity {
+  isPlayer = false;
+  attrs: { [key: string]: any } = {};
+  position = { x: 0, y: 0, z: 0 };
+  yaw = 0;
+
+  constructor(readonly client: GoWorldClient, readonly typeName: string, readonly id: string) {}
+
+  // Call the RPC method of the entity on server
+  callServer(method: string, ...args: any[]): void {
+    this.client.callServer(this.id, method, args);
+  }
+
+  // Called when the entity is created on client, with attributes and position
+  onCreated(): void {}
+
+  // Called when the entity is destroyed on client
+  onDestroy(): void {}
+
+  // Called when the attribute in the container of path is changed, deleted, appended or popped
+  onAttrChange(path: AttrPath, key: string | number): void {}
+
+  // Called when position or yaw of the entity is synced from server
+  onPositionYawChange(): void {}
+}
+
+export interface GoWorldClientOptions {
+  encodingHandshake?: boolean; // send the encoding as the first packet, if client_encoding_handshake of the gate is enabled
+  authToken?: string; // send the token after encoding handshake, if client_auth of the gate is enabled
+}
+
+// Client connecting to the WebSocket listener of the gate
+export class GoWorldClient {
+  entities = new Map<string, ClientEntity>();
+  player: ClientEntity | null = null;
+  space: ClientEntity | null = null;
+
+  onConnected: () => void = () => {};
+  onDisconnected: () => void = () => {};
+  onKicked: (reason: number, message: string) => void = () => {};
+  onGateDraining: (message: string) => void = () => {}; // the gate is shutting down, reconnect to another gate
+  onResumed: (kept: boolean) => void = () => {}; // kept is true if the player is kept by server in the reconnect window
+
+  private ws: WebSocket | null = null;
+  private recvBuf = new Uint8Array(0);
+  private entityTypes: { [typeName: string]: EntityClass } = {};
+  private clientid = "";
+  private sessionToken = "";
+  private ackedSeq = 0;
+  private resuming = false;
+  private resumeBuf: Uint8Array[] = []; // packets of the new connection received before the session is resumed
+
+  constructor(readonly url: string, readonly options: GoWorldClientOptions = {}) {}
+
+  // Use the class for entities of the type, which should extend the generated class to handle calls from server
+  registerEntityType(typeName: string, cls: EntityClass): void {
+    this.entityTypes[typeName] = cls;
+  }
+
+  connect(): void {
+    this.open(false);
+  }
+
+  // Reconnect and resume the session, acknowledged calls to client since the last ack are resent by the gate.
+  // If client_reconnect_window of gates is set, entities are kept and the player is reattached on any gate.
+  reconnect(): void {
+    this.open(this.sessionToken !== "");
+  }
+
+  close(): void {
+    if (this.ws !== null) {
+      this.ws.close();
+      this.ws = null;
+    }
+  }
+
+  isConnected(): boolean {
+    return this.ws !== null && this.ws.readyState === WebSocket.OPEN;
+  }
+
+  callServer(id: string, method: string, args: any[]): void {
+    const w = new PacketWriter(MT_CALL_ENTITY_METHOD_FROM_CLIENT);
+    w.appendID(id);
+    w.appendVarStr(method);
+    w.appendArgs(args);
+    this.send(w);
+  }
+
+  // Sync position and yaw of the player to server
+  syncPositionYaw(x: number, y: number, z: number, yaw: number): void {
+    if (this.player === null) {
+      return;
+    }
+    this.player.position = { x: x, y: y, z: z };
+    this.player.yaw = yaw;
+    const w = new PacketWriter(MT_SYNC_POSITION_YAW_FROM_CLIENT);
+    w.appendID(this.player.id);
+    w.appendFloat32(x);
+    w.appendFloat32(y);
+    w.appendFloat32(z);
+    w.appendFloat32(yaw);
+    this.send(w);
+  }
+
+  private open(resume: boolean): void {
+    this.close();
+    if (!resume) {
+      this.clearEntities();
+    }
+    this.recvBuf = new Uint8Array(0);
+    this.resuming = resume;
+    this.resumeBuf = [];
+
+    const ws = new WebSocket(this.url);
+    ws.binaryType = "arraybuffer";
+    ws.onopen = () => {
+      if (this.options.encodingHandshake) {
+        const w = new PacketWriter(MT_SET_CLIENT_ENCODING);
+        w.appendVarStr(CLIENT_ENCODING_MSGPACK);
+        this.send(w);
+      }
+      if (this.options.authToken !== undefined) {
+        const w = new PacketWriter(MT_AUTH_CLIENT);
+        w.appendVarStr(this.options.authToken);
+        this.send(w);
+      }
+      if (resume) {
+        const w = new PacketWriter(MT_RESUME_CLIENT_SESSION);
+        w.appendID(this.clientid);
+        w.appendVarStr(this.sessionToken);
+        w.appendUint32(this.ackedSeq);
+        this.send(w);
+      } else {
+        this.sessionToken = "";
+        this.ackedSeq = 0;
+      }
+      this.onConnected();
+    };
+    ws.onmessage = (ev: MessageEvent) => this.onData(new Uint8Array(ev.data as ArrayBuffer));
+    ws.onclose = () => {
+      if (this.ws === ws) {
+        this.ws = null;
+        this.onDisconnected();
+      }
+    };
+    this.ws = ws;
+  }
+
+  private send(w: PacketWriter): void {
+    if (this.ws !== null) {
+      this.ws.send(w.finish());
+    }
+  }
+
+  private onData(data: Uint8Array): void {
+    const buf = new Uint8Array(this.recvBuf.length + data.length);
+    buf.set(this.recvBuf);
+    buf.set(data, this.recvBuf.length);
+
+    let pos = 0;
+    while (buf.length - pos >= 4) {
+      const header = new DataView(buf.buffer, pos, 4).getUint32(0, true);
+      if ((header & COMPRESSED_BIT_MASK) !== 0) {
+        throw new Error("compressed packets are not supported, compress_connection of the gate should be disabled");
+      }
+      const payloadLen = header & PAYLOAD_LEN_MASK;
+      if (buf.length - pos - 4 < payloadLen) {
+        break;
+      }
+      this.onPacket(buf.subarray(pos + 4, pos + 4 + payloadLen));
+      pos += 4 + payloadLen;
+    }
+    this.recvBuf = buf.slice(pos);
+  }
+
+  private onPacket(payload: Uint8Array): void {
+    // packets of the new connection are held until the gate answers whether the session is resumed
+    if (this.resuming && new DataView(payload.buffer, payload.byteOffset, 2).getUint16(0, true) !== MT_RESUME_CLIENT_SESSION_ACK) {
+      this.resumeBuf.push(payload);
+      return;
+    }
+    this.handlePacket(new PacketReader(payload));
+  }
+
+  private handlePacket(r: PacketReader): void {
+    const msgtype = r.readUint16();
+    if (msgtype !== MT_CALL_FILTERED_CLIENTS && msgtype !== MT_SYNC_POSITION_YAW_ON_CLIENTS && msgtype !== MT_SET_CLIENT_ENCODING && msgtype !== MT_AUTH_CLIENT) {
+      r.readUint16(); // gateid
+      this.clientid = r.readID(CLIENTID_LENGTH);
+    }
+
+    switch (msgtype) {
+      case MT_CREATE_ENTITY_ON_CLIENT: {
+        const isPlayer = r.readBool();
+        const id = r.readID(ENTITYID_LENGTH);
+        const typeName = r.readVarStr();
+        const x = r.readFloat32(), y = r.readFloat32(), z = r.readFloat32(), yaw = r.readFloat32();
+        this.createEntity(typeName, id, isPlayer, r.readData() || {}, x, y, z, yaw);
+        break;
+      }
+      case MT_DESTROY_ENTITY_ON_CLIENT: {
+        r.readVarStr(); // type name
+        this.destroyEntity(r.readID(ENTITYID_LENGTH));
+        break;
+      }
+      case MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT: {
+        const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+        const path: AttrPath = r.readData() || [];
+        const key = r.readVarStr();
+        const val = r.readData();
+        if (e !== null) {
+          this.attrContainer(e, path)[key] = val;
+          e.onAttrChange(path, key);
+        }
+        break;
+      }
+      case MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT: {
+        const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+        const path: AttrPath = r.readData() || [];
+        const key = r.readVarStr();
+        if (e !== null) {
+          delete this.attrContainer(e, path)[key];
+          e.onAttrChange(path, key);
+        }
+        break;
+      }
+      case MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT: {
+        const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+        const path: AttrPath = r.readData() || [];
+        const index = r.readUint32();
+        const val = r.readData();
+        if (e !== null) {
+          this.attrContainer(e, path)[index] = val;
+          e.onAttrChange(path, index);
+        }
+        break;
+      }
+      case MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT: {
+        const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+        const path: AttrPath = r.readData() || [];
+        if (e !== null) {
+          const list = this.attrContainer(e, path);
+          list.pop();
+          e.onAttrChange(path, list.length);
+        }
+        break;
+      }
+      case MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT: {
+        const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+        const path: AttrPath = r.readData() || [];
+        const val = r.readData();
+        if (e !== null) {
+          const list = this.attrContainer(e, path);
+          list.push(val);
+          e.onAttrChange(path, list.length - 1);
+        }
+        break;
+      }
+      case MT_CALL_ENTITY_METHOD_ON_CLIENT: {
+        const id = r.readID(ENTITYID_LENGTH);
+        const method = r.readVarStr();
+        this.callEntityMethod(this.entityOf(id), method, r.readArgs());
+        break;
+      }
+      case MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED: {
+        const seq = r.readUint32();
+        const id = r.readID(ENTITYID_LENGTH);
+        const method = r.readVarStr();
+        this.callEntityMethod(this.entityOf(id), method, r.readArgs());
+        this.ackedSeq = seq;
+        const w = new PacketWriter(MT_ACK_CLIENT_MESSAGE);
+        w.appendUint32(seq);
+        this.send(w);
+        break;
+      }
+      case MT_CALL_FILTERED_CLIENTS: {
+        r.readVarStr(); // filter key
+        r.readVarStr(); // filter value
+        const method = r.readVarStr();
+        this.callEntityMethod(this.player, method, r.readArgs());
+        break;
+      }
+      case MT_UPDATE_POSITION_ON_CLIENT: {
+        const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+        const x = r.readFloat32(), y = r.readFloat32(), z = r.readFloat32();
+        if (e !== null) {
+          e.position = { x: x, y: y, z: z };
+          e.onPositionYawChange();
+        }
+        break;
+      }
+      case MT_UPDATE_YAW_ON_CLIENT: {
+        const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+        const yaw = r.readFloat32();
+        if (e !== null) {
+          e.yaw = yaw;
+          e.onPositionYawChange();
+        }
+        break;
+      }
+      case MT_SYNC_POSITION_YAW_ON_CLIENTS: {
+        while (r.hasUnread()) {
+          const e = this.entityOf(r.readID(ENTITYID_LENGTH));
+          const x = r.readFloat32(), y = r.readFloat32(), z = r.readFloat32(), yaw = r.readFloat32();
+          if (e !== null) {
+            e.position = { x: x, y: y, z: z };
+            e.yaw = yaw;
+            e.onPositionYawChange();
+          }
+        }
+        break;
+      }
+      case MT_SET_CLIENT_SESSION: {
+        this.sessionToken = r.readVarStr();
+        break;
+      }
+      case MT_SET_CLIENT_ENCODING: {
+        r.readVarStr(); // accepted encoding
+        break;
+      }
+      case MT_AUTH_CLIENT: {
+        r.readVarStr(); // user of the token
+        break;
+      }
+      case MT_RESUME_CLIENT_SESSION_ACK: {
+        const kept = r.readBool();
+        const held = this.resumeBuf;
+        this.resuming = false;
+        this.resumeBuf = [];
+        if (!kept) {
+          // login again with the new connection
+          this.clearEntities();
+          for (const payload of held) {
+            this.handlePacket(new PacketReader(payload));
+          }
+        } // otherwise packets of the new connection (e.g. the boot entity) are dropped, since the player is reattached
+        this.onResumed(kept);
+        break;
+      }
+      case MT_NOTIFY_GATE_DRAINING: {
+        this.onGateDraining(r.readVarStr());
+        break;
+      }
+      case MT_KICK_CLIENT: {
+        const reason = r.readUint16();
+        this.onKicked(reason, r.readVarStr());
+        break;
+      }
+      default:
+        console.warn("goworld: unknown message type " + msgtype);
+    }
+  }
+
+  private createEntity(typeName: string, id: string, isPlayer: boolean, attrs: { [key: string]: any },
+                       x: number, y: number, z: number, yaw: number): void {
+    const cls = this.entityTypes[typeName] || ENTITY_TYPES[typeName] || ClientEntity;
+    const e = new cls(this, typeName, id);
+    e.isPlayer = isPlayer;
+    e.attrs = attrs;
+    e.position = { x: x, y: y, z: z };
+    e.yaw = yaw;
+
+    if (typeName === SPACE_ENTITY_TYPE) {
+      this.space = e;
+    } else {
+      this.entities.set(id, e);
+      if (isPlayer) {
+        this.player = e;
+      }
+    }
+    e.onCreated();
+  }
+
+  private destroyEntity(id: string): void {
+    let e = this.entities.get(id) || null;
+    if (e !== null) {
+      this.entities.delete(id);
+      if (this.player === e) {
+        this.player = null;
+      }
+    } else if (this.space !== null && this.space.id === id) {
+      e = this.space;
+      this.space = null;
+    }
+    if (e !== null) {
+      e.onDestroy();
+    }
+  }
+
+  private clearEntities(): void {
+    for (const id of Array.from(this.entities.keys())) {
+      this.destroyEntity(id);
+    }
+    if (this.space !== null) {
+      this.destroyEntity(this.space.id);
+    }
+  }
+
+  private entityOf(id: string): ClientEntity | null {
+    const e = this.entities.get(id);
+    if (e !== undefined) {
+      return e;
+    }
+    return this.space !== null && this.space.id === id ? this.space : null;
+  }
+
+  private attrContainer(e: ClientEntity, path: AttrPath): any {
+    let container: any = e.attrs;
+    for (let i = path.length - 1; i >= 0; i--) {
+      container = container[path[i]];
+    }
+    return container;
+  }
+
+  private callEntityMethod(e: ClientEntity | null, method: string, args: any[]): void {
+    const fn = e !== null ? (e as any)[method] : undefined;
+    if (typeof fn !== "function") {
+      console.warn("goworld: method " + method + " is not found on client entity " + (e !== null ? e.typeName : "null"));
+      return;
+    }
+    fn.apply(e, args);
+  }
+}
+`
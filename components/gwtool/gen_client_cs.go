@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+// C# entity classes for Unity projects
+//
+// Entity classes are partial classes derived from the -cs-base class of the Unity client library, which provides
+// CallServer(string method, params object[] args). Client attributes are mirrored by <Type>Attrs with typed fields,
+// which are updated by Set(key, value) of top-level attributes from attribute change callbacks of the client library.
+
+var (
+	csIdentifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	csScalarTypes = map[string]string{ // go type -> C# type
+		"bool":             "bool",
+		"int":              "long",
+		"int8":             "sbyte",
+		"int16":            "short",
+		"int32":            "int",
+		"int64":            "long",
+		"uint":             "ulong",
+		"uint8":            "byte",
+		"byte":             "byte",
+		"uint16":           "ushort",
+		"uint32":           "uint",
+		"uint64":           "ulong",
+		"float32":          "float",
+		"float64":          "double",
+		"string":           "string",
+		"[]byte":           "byte[]",
+		"[]uint8":          "byte[]",
+		"interface{}":      "object",
+		"EntityID":         "string",
+		"common.EntityID":  "string",
+		"ClientID":         "string",
+		"common.ClientID":  "string",
+		"entity.Coord":     "float",
+		"entity.Yaw":       "float",
+		"entity.MapAttr":   "Dictionary<string, object>",
+		"*entity.MapAttr":  "Dictionary<string, object>",
+		"entity.ListAttr":  "List<object>",
+		"*entity.ListAttr": "List<object>",
+	}
+
+	csAttrTypes = map[string]struct{ csType, convert string }{ // attribute type -> C# type and conversion of value
+		entity.ATTR_TYPE_BOOL:     {"bool", "Convert.ToBoolean(%s)"},
+		entity.ATTR_TYPE_INT:      {"long", "Convert.ToInt64(%s)"},
+		entity.ATTR_TYPE_INT64:    {"long", "Convert.ToInt64(%s)"},
+		entity.ATTR_TYPE_UINT64:   {"ulong", "Convert.ToUInt64(%s)"},
+		entity.ATTR_TYPE_FLOAT64:  {"double", "Convert.ToDouble(%s)"},
+		entity.ATTR_TYPE_STRING:   {"string", "Convert.ToString(%s)"},
+		entity.ATTR_TYPE_MAPATTR:  {"Dictionary<string, object>", "AttrConvert.ToMap(%s)"},
+		entity.ATTR_TYPE_LISTATTR: {"List<object>", "AttrConvert.ToList(%s, v => v)"},
+	}
+
+	csKeywords = map[string]bool{}
+)
+
+func init() {
+	for _, keyword := range strings.Fields(`abstract as base bool break byte case catch char checked class const continue
+		decimal default delegate do double else enum event explicit extern false finally fixed float for foreach goto if
+		implicit in int interface internal is lock long namespace new null object operator out override params private
+		protected public readonly ref return sbyte sealed short sizeof stackalloc static string struct switch this throw
+		true try typeof uint ulong unchecked unsafe ushort using virtual void volatile while`) {
+		csKeywords[keyword] = true
+	}
+}
+
+// convert go type of RPC argument to C# type, object if not convertible
+func csTypeOf(goType string) string {
+	if t, ok := csScalarTypes[goType]; ok {
+		return t
+	}
+	if strings.HasPrefix(goType, "[]") {
+		return csTypeOf(goType[2:]) + "[]"
+	}
+	if strings.HasPrefix(goType, "map[string]") {
+		return "Dictionary<string, " + csTypeOf(goType[len("map[string]"):]) + ">"
+	}
+	return "object"
+}
+
+// get C# type and conversion expression of value for the attribute type
+func csAttrTypeOf(attrType string) (csType string, convert string) {
+	if elem, ok := entity.SplitListAttrType(attrType); ok && elem != "" {
+		t := csAttrTypes[elem]
+		return "List<" + t.csType + ">", "AttrConvert.ToList(%s, v => " + fmt.Sprintf(t.convert, "v") + ")"
+	}
+	t := csAttrTypes[attrType]
+	return t.csType, t.convert
+}
+
+// C# identifier of the name, keywords are escaped by @
+func csIdentifier(name string) string {
+	if csKeywords[name] {
+		return "@" + name
+	}
+	return name
+}
+
+func generateCSharpClient(namespace string, baseClass string, schemas []*entitySchema, rpcs map[string][]rpcSchema) []byte {
+	var src bytes.Buffer
+	src.WriteString(genClientHeader)
+	src.WriteString("using System;\nusing System.Collections;\nusing System.Collections.Generic;\n\n")
+	fmt.Fprintf(&src, "namespace %s\n{\n", namespace)
+	src.WriteString(csAttrConvert)
+
+	for _, schema := range schemas {
+		if !csIdentifierRegexp.MatchString(schema.typeName) || csKeywords[schema.typeName] {
+			fmt.Fprintf(os.Stderr, "gwtool gen-client: entity type %s is not a valid identifier, skipped\n", schema.typeName)
+			continue
+		}
+		writeCSharpEntity(&src, schema, baseClass, rpcs[schema.goType])
+	}
+	src.WriteString("}\n")
+	return src.Bytes()
+}
+
+func writeCSharpEntity(src *bytes.Buffer, schema *entitySchema, baseClass string, typeRpcs []rpcSchema) {
+	var fields, cases []string
+	attrsClass := schema.typeName + "Attrs"
+	declared := map[string]bool{"Set": true, "SetAll": true, attrsClass: true}
+	for _, attr := range schema.attrs {
+		if !attr.client {
+			continue
+		}
+		name := exportedName(attr.name)
+		if declared[name] || !csIdentifierRegexp.MatchString(name) {
+			fmt.Fprintf(os.Stderr, "gwtool gen-client: %s.%s conflicts or is not a valid identifier, skipped\n", schema.typeName, attr.name)
+			continue
+		}
+		declared[name] = true
+
+		csType, convert := csAttrTypeOf(attr.attrType)
+		fields = append(fields, fmt.Sprintf("        public %s %s;\n", csType, name))
+		cases = append(cases, fmt.Sprintf("                case %q:\n                    %s = %s;\n                    return true;\n", attr.name, name, fmt.Sprintf(convert, "value")))
+	}
+
+	if len(fields) > 0 {
+		fmt.Fprintf(src, "\n    // Typed mirror of client attributes of entity type %s\n", schema.typeName)
+		fmt.Fprintf(src, "    public partial class %s\n    {\n", attrsClass)
+		src.WriteString(strings.Join(fields, ""))
+		src.WriteString("\n        // Update the mirror by the top-level attribute, returns false if the attribute is not mirrored\n")
+		src.WriteString("        public bool Set(string key, object value)\n        {\n            switch (key)\n            {\n")
+		src.WriteString(strings.Join(cases, ""))
+		src.WriteString("            }\n            return false;\n        }\n\n")
+		src.WriteString("        // Update the mirror by all attributes of the entity\n")
+		src.WriteString("        public void SetAll(IDictionary<string, object> attrs)\n        {\n")
+		src.WriteString("            foreach (var item in attrs)\n            {\n                Set(item.Key, item.Value);\n            }\n        }\n    }\n")
+	}
+
+	var members []string
+	if len(fields) > 0 {
+		members = append(members, fmt.Sprintf("        public readonly %s TypedAttrs = new %s();\n", attrsClass, attrsClass))
+	}
+	for _, rpc := range typeRpcs {
+		if declared["CallServer_"+rpc.name] {
+			continue // both X_Client and X_AllClient are defined
+		}
+		declared["CallServer_"+rpc.name] = true
+
+		var params, args []string
+		for _, param := range rpc.params {
+			params = append(params, fmt.Sprintf("%s %s", csTypeOf(param.goType), csIdentifier(param.name)))
+			args = append(args, ", "+csIdentifier(param.name))
+		}
+		members = append(members, fmt.Sprintf("        public void CallServer_%s(%s)\n        {\n            CallServer(%q%s);\n        }\n", rpc.name, strings.Join(params, ", "), rpc.name, strings.Join(args, "")))
+	}
+
+	fmt.Fprintf(src, "\n    // Entity type %s\n", schema.typeName)
+	fmt.Fprintf(src, "    public partial class %s : %s\n    {\n", schema.typeName, baseClass)
+	src.WriteString(strings.Join(members, "\n"))
+	src.WriteString("    }\n")
+}
+
+// Conversions of attribute values decoded by the client library, e.g. List<object> or Dictionary<object, object>
+const csAttrConvert = `    internal static class AttrConvert
+    {
+        public static List<T> ToList<T>(object value, Func<object, T> convert)
+        {
+            var list = new List<T>();
+            var items = value as IEnumerable;
+            if (items != null && !(value is string))
+            {
+                foreach (var item in items)
+                {
+                    list.Add(convert(item));
+                }
+            }
+            return list;
+        }
+
+        public static Dictionary<string, object> ToMap(object value)
+        {
+            var map = new Dictionary<string, object>();
+            var items = value as IDictionary;
+            if (items != null)
+            {
+                foreach (DictionaryEntry item in items)
+                {
+                    map[Convert.ToString(item.Key)] = item.Value;
+                }
+            }
+            return map;
+        }
+    }
+`
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+const (
+	genProtoHeader = "// Code generated by gwtool gen-proto. DO NOT EDIT.\n\n"
+
+	protoValueMessages = `// Values transcoded from msgpack data by gates for protobuf clients, e.g. attributes and arguments of calls to clients
+message Value {
+  oneof kind {
+    bool null_value = 1;
+    bool bool_value = 2;
+    sint64 int_value = 3;
+    uint64 uint_value = 4;
+    double double_value = 5;
+    string string_value = 6;
+    bytes bytes_value = 7;
+    ListValue list_value = 8;
+    MapValue map_value = 9;
+  }
+}
+
+message ListValue {
+  repeated Value values = 1;
+}
+
+message MapValue {
+  map<string, Value> fields = 1;
+}
+`
+)
+
+type rpcParam struct {
+	name   string
+	goType string
+}
+
+type rpcSchema struct {
+	name   string // RPC name without _Client or _AllClient
+	params []rpcParam
+}
+
+// genProto parses registered entity types in the package and generates .proto for clients of protobuf encoding
+func genProto(args []string) error {
+	fs := flag.NewFlagSet("gen-proto", flag.ExitOnError)
+	dir := fs.String("dir", ".", "package directory")
+	output := fs.String("o", "goworld.proto", "output file name in package directory")
+	protoPackage := fs.String("package", "goworld", "package of the generated .proto")
+	fs.Parse(args)
+
+	outputPath := filepath.Join(*dir, *output)
+	fset, pkg, err := parsePackageDir(*dir, *output)
+	if err != nil {
+		return err
+	}
+
+	schemas, err := parseRegisteredEntities(fset, pkg)
+	if err != nil {
+		return err
+	}
+	if len(schemas) == 0 {
+		return errors.Errorf("no registered entity type found in %s", *dir)
+	}
+
+	src := generateProto(*protoPackage, schemas, collectClientRPCs(pkg))
+	if err := ioutil.WriteFile(outputPath, src, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("generated %s for %d entity types\n", outputPath, len(schemas))
+	return nil
+}
+
+// parse all RegisterEntity(...) calls in the package, with attributes of DefineAttrs if chained
+func parseRegisteredEntities(fset *token.FileSet, pkg *ast.Package) ([]*entitySchema, error) {
+	var schemas []*entitySchema
+	var parseErr error
+	parsed := map[*ast.CallExpr]bool{} // RegisterEntity calls parsed with DefineAttrs
+
+	for _, file := range pkg.Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if parseErr != nil {
+				return false
+			}
+
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+
+			var schema *entitySchema
+			var err error
+			if isSelectorCall(call, "DefineAttrs") && len(call.Args) == 1 {
+				register, ok := call.Fun.(*ast.SelectorExpr).X.(*ast.CallExpr)
+				if !ok || !isSelectorCall(register, "RegisterEntity") || len(register.Args) != 2 {
+					return true
+				}
+				parsed[register] = true
+				schema, err = parseEntitySchema(register, call.Args[0])
+			} else if isSelectorCall(call, "RegisterEntity") && len(call.Args) == 2 && !parsed[call] {
+				schema, err = parseRegisterEntity(call)
+			} else {
+				return true
+			}
+
+			if err != nil {
+				parseErr = errors.Wrap(err, fset.Position(call.Pos()).String())
+				return false
+			}
+			schemas = append(schemas, schema)
+			return true
+		})
+	}
+
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].typeName < schemas[j].typeName
+	})
+	return schemas, parseErr
+}
+
+// collect RPC methods which can be called by clients of each type
+func collectClientRPCs(pkg *ast.Package) map[string][]rpcSchema {
+	rpcs := map[string][]rpcSchema{}
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || len(fn.Recv.List) != 1 || !fn.Name.IsExported() {
+				continue
+			}
+
+			name := fn.Name.Name
+			rpcName := strings.TrimSuffix(strings.TrimSuffix(name, "_AllClient"), "_Client")
+			if rpcName == name {
+				continue // server methods
+			}
+
+			recvType := fn.Recv.List[0].Type
+			if star, ok := recvType.(*ast.StarExpr); ok {
+				recvType = star.X
+			}
+			ident, ok := recvType.(*ast.Ident)
+			if !ok {
+				continue
+			}
+
+			rpc := rpcSchema{name: rpcName}
+			for _, field := range fn.Type.Params.List {
+				goType := types.ExprString(field.Type)
+				if len(field.Names) == 0 {
+					rpc.params = append(rpc.params, rpcParam{fmt.Sprintf("arg%d", len(rpc.params)+1), goType})
+				}
+				for _, paramName := range field.Names {
+					rpc.params = append(rpc.params, rpcParam{paramName.Name, goType})
+				}
+			}
+			rpcs[ident.Name] = append(rpcs[ident.Name], rpc)
+		}
+	}
+
+	for _, typeRpcs := range rpcs {
+		sort.Slice(typeRpcs, func(i, j int) bool {
+			return typeRpcs[i].name < typeRpcs[j].name
+		})
+	}
+	return rpcs
+}
+
+func generateProto(protoPackage string, schemas []*entitySchema, rpcs map[string][]rpcSchema) []byte {
+	var src bytes.Buffer
+	src.WriteString(genProtoHeader)
+	src.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&src, "package %s;\n\n", protoPackage)
+	src.WriteString(protoValueMessages)
+
+	for _, schema := range schemas {
+		var clientAttrs []attrSchema
+		for _, attr := range schema.attrs {
+			if attr.client {
+				clientAttrs = append(clientAttrs, attr)
+			}
+		}
+		typeRpcs := rpcs[schema.goType]
+		if len(clientAttrs) == 0 && len(typeRpcs) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&src, "\n// Entity type %s\n", schema.typeName)
+		if len(clientAttrs) > 0 {
+			src.WriteString("//\n// Typed client attributes in MapValue of the entity created on client:\n")
+			for _, attr := range clientAttrs {
+				fmt.Fprintf(&src, "//   %s: %s\n", attr.name, attr.attrType)
+			}
+		}
+
+		for _, rpc := range typeRpcs {
+			fmt.Fprintf(&src, "\n// Arguments of %s.%s called by clients\n", schema.typeName, rpc.name)
+			fmt.Fprintf(&src, "message %s_%s {\n", schema.typeName, rpc.name)
+			for i, param := range rpc.params {
+				protoType, repeated := entity.ProtobufArgType(param.goType)
+				if repeated {
+					protoType = "repeated " + protoType
+				}
+				fmt.Fprintf(&src, "  %s %s = %d;\n", protoType, param.name, i+1)
+			}
+			src.WriteString("}\n")
+		}
+	}
+	return src.Bytes()
+}
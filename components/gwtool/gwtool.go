@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// gwtool is the command line tool for GoWorld projects
+//
+// Usage:
+//
+//	gwtool gen-attrs [-dir DIR] [-o FILE]
+//		generate typed attribute getters and setters for entity types registered in the package of DIR
+//
+//	gwtool gen-proto [-dir DIR] [-o FILE] [-package PACKAGE]
+//		generate .proto of client RPC arguments and attributes for protobuf clients of entity types in the package of DIR
+//
+//	gwtool gen-client [-lang ts|cs] [-dir DIR] [-o FILE] [-cs-namespace NAMESPACE] [-cs-base CLASS]
+//		generate client SDK (connection, entity proxies, typed RPC stubs and attribute sync) for entity types in the package of DIR,
+//		or C# entity classes with typed CallServer_* methods and attribute mirrors for Unity projects
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"gen-attrs", "generate typed attribute getters and setters from DefineAttrs", genAttrs},
+	{"gen-proto", "generate .proto of client RPCs and attributes for protobuf clients", genProto},
+	{"gen-client", "generate client SDK of entity types, TypeScript for browsers or C# for Unity", genClient},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: gwtool <command> [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "    %-12s %s\n", cmd.name, cmd.usage)
+	}
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+
+	name := flag.Arg(0)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			if err := cmd.run(flag.Args()[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "gwtool %s: %v\n", name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "gwtool: unknown command %s\n", name)
+	usage()
+}
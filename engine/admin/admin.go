@@ -0,0 +1,150 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Admin HTTP API for live inspection of games, gates and dispatchers, served by processes with admin_port
+// configured. Requests should carry the token of admin_token:
+//
+//	GET /entities?type=Avatar&client=1
+//	X-Admin-Token: <admin_token>
+//
+// and are replied with JSON. Each process registers its endpoints by Handle, and actions changing states by
+// HandleAction which are requested by POST. GET / lists all endpoints:
+//
+//	game        /entities?type=&space=&client=&limit=   /entity?id=   /services   /spaces   /storage
+//	            /entity/audit?id=
+//	            POST /freeze   POST /save?label=   POST /reload_scripts
+//	            POST /maintenance?enabled=&message=&allowlist=
+//	            /gwvar?name=   POST /gwvar/set?name=&value=   POST /gwvar/delete?name=
+//	gate        /clients?limit=   /drain_status   POST /drain?threshold=&timeout=&message=
+//	dispatcher  /routing   /entity?id=   /services
+
+const (
+	ADMIN_TOKEN_HEADER = "X-Admin-Token"
+)
+
+var (
+	// Returned by handlers if the requested object is not found, replied with 404
+	ErrNotFound = errors.New("not found")
+
+	handlers     = map[string]endpoint{}
+	handlersLock sync.RWMutex
+)
+
+type endpoint struct {
+	method  string
+	handler HandlerFunc
+}
+
+// Handler of admin endpoint, the result is replied as JSON
+type HandlerFunc func(query url.Values) (interface{}, error)
+
+// Register the handler of the endpoint path, handlers are called in HTTP goroutines
+func Handle(path string, handler HandlerFunc) {
+	handle(path, http.MethodGet, handler)
+}
+
+// Register the handler of the action path requested by POST, e.g. freezing the game
+func HandleAction(path string, handler HandlerFunc) {
+	handle(path, http.MethodPost, handler)
+}
+
+func handle(path string, method string, handler HandlerFunc) {
+	handlersLock.Lock()
+	handlers[path] = endpoint{method: method, handler: handler}
+	handlersLock.Unlock()
+}
+
+// Serve admin requests on ip:port, requests are rejected if token is empty
+func Serve(ip string, port int, token string) {
+	if port == 0 {
+		gwlog.Info("admin server not enabled")
+		return
+	}
+	if token == "" {
+		gwlog.Fatal("admin_token should be set for admin server")
+	}
+
+	host := fmt.Sprintf("%s:%d", ip, port)
+	gwlog.Info("admin server listening on http://%s/ ...", host)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		serveAdmin(w, r, token)
+	})
+	go func() {
+		err := http.ListenAndServe(host, mux)
+		gwlog.Error("admin server quited: %s", err)
+	}()
+}
+
+func serveAdmin(w http.ResponseWriter, r *http.Request, token string) {
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(ADMIN_TOKEN_HEADER)), []byte(token)) != 1 {
+		gwlog.Warn("admin: unauthorized request from %s", r.RemoteAddr)
+		writeResponse(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	if r.URL.Path == "/" && r.Method == http.MethodGet {
+		writeResponse(w, http.StatusOK, listEndpoints())
+		return
+	}
+
+	handlersLock.RLock()
+	ep, ok := handlers[r.URL.Path]
+	handlersLock.RUnlock()
+	if !ok {
+		writeResponse(w, http.StatusNotFound, map[string]string{"error": "unknown endpoint"})
+		return
+	}
+	if r.Method != ep.method {
+		writeResponse(w, http.StatusMethodNotAllowed, map[string]string{"error": ep.method + " only"})
+		return
+	}
+
+	result, err := ep.handler(r.URL.Query())
+	if err == ErrNotFound {
+		writeResponse(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	} else if err != nil {
+		writeResponse(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	} else {
+		writeResponse(w, http.StatusOK, result)
+	}
+}
+
+func listEndpoints() []string {
+	handlersLock.RLock()
+	defer handlersLock.RUnlock()
+
+	paths := make([]string, 0, len(handlers))
+	for path, ep := range handlers {
+		if ep.method != http.MethodGet {
+			path = ep.method + " " + path
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func writeResponse(w http.ResponseWriter, status int, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
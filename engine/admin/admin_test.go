@@ -0,0 +1,72 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func getAdmin(token string, target string) *httptest.ResponseRecorder {
+	return requestAdmin(http.MethodGet, token, target)
+}
+
+func requestAdmin(method string, token string, target string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set(ADMIN_TOKEN_HEADER, token)
+	w := httptest.NewRecorder()
+	serveAdmin(w, r, "secret")
+	return w
+}
+
+func TestAdmin(t *testing.T) {
+	Handle("/echo", func(query url.Values) (interface{}, error) {
+		if query.Get("id") == "" {
+			return nil, ErrNotFound
+		}
+		return map[string]string{"id": query.Get("id")}, nil
+	})
+
+	if w := getAdmin("wrong", "/echo?id=1"); w.Code != http.StatusUnauthorized {
+		t.Errorf("should be unauthorized: %d", w.Code)
+	}
+	if w := getAdmin("secret", "/echo?id=1"); w.Code != http.StatusOK || w.Body.String() != `{"id":"1"}` {
+		t.Errorf("wrong response: %d %s", w.Code, w.Body.String())
+	}
+	if w := getAdmin("secret", "/echo"); w.Code != http.StatusNotFound {
+		t.Errorf("should be not found: %d", w.Code)
+	}
+	if w := getAdmin("secret", "/unknown"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown endpoint should be not found: %d", w.Code)
+	}
+	if w := getAdmin("secret", "/"); w.Code != http.StatusOK || w.Body.String() != `["/echo"]` {
+		t.Errorf("wrong endpoints: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAdminAction(t *testing.T) {
+	done := false
+	HandleAction("/do", func(query url.Values) (interface{}, error) {
+		done = true
+		return "ok", nil
+	})
+	defer func() {
+		handlersLock.Lock()
+		delete(handlers, "/do")
+		handlersLock.Unlock()
+	}()
+
+	if w := getAdmin("secret", "/do"); w.Code != http.StatusMethodNotAllowed || done {
+		t.Errorf("action should not be requested by GET: %d", w.Code)
+	}
+	if w := requestAdmin(http.MethodPost, "wrong", "/do"); w.Code != http.StatusUnauthorized || done {
+		t.Errorf("should be unauthorized: %d", w.Code)
+	}
+	if w := requestAdmin(http.MethodPost, "secret", "/do"); w.Code != http.StatusOK || !done {
+		t.Errorf("action should be done: %d %s", w.Code, w.Body.String())
+	}
+	if w := getAdmin("secret", "/"); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"POST /do"`) {
+		t.Errorf("wrong endpoints: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Analytics events of engine for BI pipelines, without instrumenting game code
+//
+// Engine events such as entity creation, client login and RPC calls are emitted to the configured sink in batches:
+//
+//	file    append events as JSON lines to the file of target path
+//	http    POST batches of events as JSON arrays to the target URL
+//
+// Other sinks, e.g. Kafka producers, can be plugged in by RegisterSink. Events are sampled by ratios of event types,
+// and dropped if the sink can not catch up, so that analytics never block the game routine.
+
+const (
+	EVENT_ENTITY_CREATED   = "entity_created"
+	EVENT_ENTITY_DESTROYED = "entity_destroyed"
+	EVENT_CLIENT_LOGIN     = "client_login"  // client connected to entity
+	EVENT_CLIENT_LOGOUT    = "client_logout" // client disconnected from entity
+	EVENT_RPC              = "rpc"
+	EVENT_SPACE_ENTER      = "space_enter"
+	EVENT_SPACE_LEAVE      = "space_leave"
+
+	_EMIT_QUEUE_SIZE = 8192
+	_EMIT_BATCH_SIZE = 512
+	_EMIT_INTERVAL   = time.Second
+)
+
+var (
+	enabled            bool
+	defaultSampleRatio float64
+	sampleRatios       = map[string]float64{} // sample ratios by event types overriding the default ratio
+	sampleRand         = rand.New(rand.NewSource(time.Now().UnixNano()))
+	emitQueue          = make(chan *Event, _EMIT_QUEUE_SIZE)
+
+	sinkFactoriesLock sync.Mutex
+	sinkFactories     = map[string]SinkFactory{
+		"file": newFileSink,
+		"http": newHTTPSink,
+	}
+)
+
+// Analytics event
+type Event struct {
+	Time     time.Time              `json:"time"`
+	Type     string                 `json:"type"`
+	Source   string                 `json:"source"` // process emitting the event, e.g. game1
+	EntityID string                 `json:"entity_id,omitempty"`
+	TypeName string                 `json:"type_name,omitempty"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Sink receives batches of events in the emitting routine, Write is never called concurrently
+type Sink interface {
+	Write(events []*Event) error
+}
+
+// Create the sink with target configured by analytics_target
+type SinkFactory func(target string) (Sink, error)
+
+// Register sink type which can be configured by analytics_sink, should be called before the game starts
+func RegisterSink(sinkType string, factory SinkFactory) {
+	sinkFactoriesLock.Lock()
+	sinkFactories[sinkType] = factory
+	sinkFactoriesLock.Unlock()
+}
+
+// Setup analytics with sink type and target, analytics is not enabled if sink type is empty
+func Setup(sinkType string, target string, source string, ratio float64) {
+	if sinkType == "" {
+		gwlog.Info("analytics not enabled")
+		return
+	}
+
+	sinkFactoriesLock.Lock()
+	factory := sinkFactories[sinkType]
+	sinkFactoriesLock.Unlock()
+	if factory == nil {
+		gwlog.Panicf("analytics: unknown sink type: %s", sinkType)
+	}
+	sink, err := factory(target)
+	if err != nil {
+		gwlog.Panic(errors.Wrapf(err, "analytics: create %s sink failed", sinkType))
+	}
+
+	enabled = true
+	defaultSampleRatio = ratio
+	gwlog.Info("analytics enabled: emitting events of %s to %s sink %s, sample ratio = %v", source, sinkType, target, ratio)
+	go emitRoutine(sink, source)
+}
+
+// Set the sample ratio of event type, which overrides the default sample ratio, should be called in the game routine
+func SetSampleRatio(eventType string, ratio float64) {
+	sampleRatios[eventType] = ratio
+}
+
+// Check if analytics is enabled
+func IsEnabled() bool {
+	return enabled
+}
+
+// Emit the event if sampled, should be called in the game routine
+func Emit(eventType string, entityID string, typeName string, fields map[string]interface{}) {
+	if !enabled || !isSampled(eventType) {
+		return
+	}
+
+	event := &Event{
+		Time:     time.Now(),
+		Type:     eventType,
+		EntityID: entityID,
+		TypeName: typeName,
+		Fields:   fields,
+	}
+	select {
+	case emitQueue <- event:
+	default:
+		gwlog.Warn("analytics: emit queue is full, event %s dropped", eventType)
+	}
+}
+
+func isSampled(eventType string) bool {
+	ratio, ok := sampleRatios[eventType]
+	if !ok {
+		ratio = defaultSampleRatio
+	}
+	return ratio >= 1 || (ratio > 0 && sampleRand.Float64() < ratio)
+}
+
+func emitRoutine(sink Sink, source string) {
+	ticker := time.NewTicker(_EMIT_INTERVAL)
+	batch := make([]*Event, 0, _EMIT_BATCH_SIZE)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := sink.Write(batch); err != nil {
+			gwlog.Warn("analytics: write %d events failed: %s", len(batch), err)
+		}
+		batch = make([]*Event, 0, _EMIT_BATCH_SIZE) // sinks might keep the batch
+	}
+
+	for {
+		select {
+		case event := <-emitQueue:
+			event.Source = source
+			batch = append(batch, event)
+			if len(batch) >= _EMIT_BATCH_SIZE {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
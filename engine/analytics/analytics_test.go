@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsSampled(t *testing.T) {
+	defaultSampleRatio = 1
+	sampleRatios[EVENT_RPC] = 0
+	defer func() {
+		defaultSampleRatio = 0
+		delete(sampleRatios, EVENT_RPC)
+	}()
+
+	if !isSampled(EVENT_ENTITY_CREATED) {
+		t.Errorf("events should be sampled with default ratio 1")
+	}
+	if isSampled(EVENT_RPC) {
+		t.Errorf("RPC events should not be sampled with ratio 0")
+	}
+
+	sampleRatios[EVENT_RPC] = 0.5
+	sampled := 0
+	for i := 0; i < 10000; i++ {
+		if isSampled(EVENT_RPC) {
+			sampled++
+		}
+	}
+	if sampled < 4000 || sampled > 6000 {
+		t.Errorf("%d of 10000 events are sampled with ratio 0.5", sampled)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "analytics.log")
+	sink, err := newFileSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []*Event{
+		{Type: EVENT_ENTITY_CREATED, Source: "game1", EntityID: "id1", TypeName: "Avatar"},
+		{Type: EVENT_RPC, Source: "game1", EntityID: "id1", TypeName: "Avatar", Fields: map[string]interface{}{"method": "Say"}},
+	}
+	if err := sink.Write(events); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid line %q: %s", scanner.Text(), err)
+		}
+		lines = append(lines, event)
+	}
+	if len(lines) != 2 || lines[0].Type != EVENT_ENTITY_CREATED || lines[1].Fields["method"] != "Say" {
+		t.Errorf("events are written as %v", lines)
+	}
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_HTTP_SINK_REQUEST_TIMEOUT = 5 * time.Second
+)
+
+// Sink appending events as JSON lines to file
+type fileSink struct {
+	file *os.File
+}
+
+func newFileSink(target string) (Sink, error) {
+	if target == "" {
+		return nil, errors.New("file path is empty")
+	}
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSink{file: file}, nil
+}
+
+func (sink *fileSink) Write(events []*Event) error {
+	w := bufio.NewWriter(sink.file)
+	encoder := json.NewEncoder(w) // Encode appends newline to each event
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Sink posting batches of events as JSON arrays to URL
+type httpSink struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPSink(target string) (Sink, error) {
+	if target == "" {
+		return nil, errors.New("URL is empty")
+	}
+	return &httpSink{
+		url:    target,
+		client: &http.Client{Timeout: _HTTP_SINK_REQUEST_TIMEOUT},
+	}, nil
+}
+
+func (sink *httpSink) Write(events []*Event) error {
+	data, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	resp, err := sink.client.Post(sink.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("post to %s: %s", sink.url, resp.Status)
+	}
+	return nil
+}
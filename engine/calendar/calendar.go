@@ -0,0 +1,271 @@
+package calendar
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+// Scheduled server events (double-XP windows, boss spawns, etc.) are saved in KVDB and loaded by all games,
+// each game fires start / end callbacks to local subscribers at the right time.
+//
+// Events which are already started when the game starts (or reloads events) are started immediately,
+// so that subscribers can catch up after restarts.
+
+const (
+	_CALENDAR_KVDB_KEY_PREFIX = "__calendar__/"
+	_CALENDAR_RELOAD_INTERVAL = time.Minute // reload interval for loading events added by other games
+)
+
+var (
+	initialized = false
+	events      = map[string]*scheduledEvent{}
+	subscribers = map[Handle]*subscriber{}
+	nextHandle  = Handle(1)
+
+	logger = gwlog.Module("calendar")
+)
+
+// Scheduled event from Start to End
+type Event struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+	Data  map[string]interface{}
+}
+
+// Check if the event is active at the specified time
+func (ev *Event) IsActive(t time.Time) bool {
+	return !t.Before(ev.Start) && t.Before(ev.End)
+}
+
+// Callback for event start (started = true) and end (started = false)
+type EventCallback func(ev *Event, started bool)
+
+type Handle int // Return value of Subscribe, can be used to unsubscribe
+
+type subscriber struct {
+	eventName string // subscribe to all events if empty
+	cb        EventCallback
+}
+
+type scheduledEvent struct {
+	Event
+	started    bool
+	startTimer *timer.Timer
+	endTimer   *timer.Timer
+}
+
+func (sev *scheduledEvent) cancelTimers() {
+	if sev.startTimer != nil {
+		sev.startTimer.Cancel()
+		sev.startTimer = nil
+	}
+	if sev.endTimer != nil {
+		sev.endTimer.Cancel()
+		sev.endTimer = nil
+	}
+}
+
+// Initialize calendar module and load events from KVDB, called by engine when game is ready
+func Initialize() {
+	if initialized { // games might be ready for multiple times if dispatcher reconnects
+		reload()
+		return
+	}
+
+	initialized = true
+	reload()
+	timer.AddTimer(_CALENDAR_RELOAD_INTERVAL, reload)
+}
+
+// Subscribe to start & end of the specified event, or all events if eventName is empty
+//
+// The callback is called immediately if the event is already active
+func Subscribe(eventName string, cb EventCallback) Handle {
+	h := nextHandle
+	nextHandle += 1
+	subscribers[h] = &subscriber{eventName: eventName, cb: cb}
+
+	for _, sev := range events {
+		if sev.started && (eventName == "" || eventName == sev.Name) {
+			ev := sev.Event
+			gwutils.RunPanicless(func() {
+				cb(&ev, true)
+			})
+		}
+	}
+	return h
+}
+
+// Unsubscribe the subscribed handle
+func (h Handle) Unsubscribe() {
+	delete(subscribers, h)
+}
+
+// Add or replace the event, and save it to KVDB so that all games will schedule it
+func AddEvent(ev Event, callback kvdb.KVDBPutCallback) {
+	if ev.Name == "" {
+		gwlog.Panicf("calendar.AddEvent: event name is empty")
+	}
+	if !ev.Start.Before(ev.End) {
+		gwlog.Panicf("calendar.AddEvent: event %s should end after start: %s ~ %s", ev.Name, ev.Start, ev.End)
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+
+	kvdb.Put(_CALENDAR_KVDB_KEY_PREFIX+ev.Name, string(data), callback)
+	scheduleEvent(ev)
+}
+
+// Remove the event from KVDB, the event is ended immediately if it is active
+func RemoveEvent(name string, callback kvdb.KVDBPutCallback) {
+	kvdb.Put(_CALENDAR_KVDB_KEY_PREFIX+name, "", callback) // empty value for removed event
+	unscheduleEvent(name)
+}
+
+// Get the event by name, returns nil if event not found
+func GetEvent(name string) *Event {
+	sev := events[name]
+	if sev == nil {
+		return nil
+	}
+	ev := sev.Event
+	return &ev
+}
+
+// Get all active events
+func GetActiveEvents() []*Event {
+	var active []*Event
+	for _, sev := range events {
+		if sev.started {
+			ev := sev.Event
+			active = append(active, &ev)
+		}
+	}
+	return active
+}
+
+func reload() {
+	beginKey := _CALENDAR_KVDB_KEY_PREFIX
+	endKey := beginKey[:len(beginKey)-1] + string(beginKey[len(beginKey)-1]+1)
+	kvdb.GetRange(beginKey, endKey, func(items []kvdb_types.KVItem, err error) {
+		if err != nil {
+			logger.TraceError("calendar: load events failed: %s", err)
+			return
+		}
+
+		for _, item := range items {
+			name := strings.TrimPrefix(item.Key, _CALENDAR_KVDB_KEY_PREFIX)
+			if item.Val == "" {
+				unscheduleEvent(name)
+				continue
+			}
+
+			var ev Event
+			if err := json.Unmarshal([]byte(item.Val), &ev); err != nil {
+				logger.TraceError("calendar: invalid event %s: %s", item.Key, err)
+				continue
+			}
+			scheduleEvent(ev)
+		}
+	})
+}
+
+func scheduleEvent(ev Event) {
+	now := time.Now()
+	sev := events[ev.Name]
+	if sev == nil && !now.Before(ev.End) {
+		return // event is already ended
+	}
+
+	if sev != nil {
+		if sev.Start.Equal(ev.Start) && sev.End.Equal(ev.End) {
+			sev.Data = ev.Data
+			return // not changed
+		}
+
+		sev.cancelTimers()
+		sev.Event = ev
+	} else {
+		sev = &scheduledEvent{Event: ev}
+		events[ev.Name] = sev
+	}
+
+	if sev.started && !sev.IsActive(now) {
+		endEvent(sev)
+	}
+
+	if !now.Before(sev.End) {
+		delete(events, sev.Name) // event is changed to be already ended
+		return
+	}
+
+	if now.Before(sev.Start) {
+		sev.startTimer = timer.AddCallback(sev.Start.Sub(now), func() {
+			sev.startTimer = nil
+			startEvent(sev)
+		})
+	} else if now.Before(sev.End) && !sev.started {
+		// event is already started, catch up
+		startEvent(sev)
+	}
+
+	if now.Before(sev.End) {
+		sev.endTimer = timer.AddCallback(sev.End.Sub(now), func() {
+			sev.endTimer = nil
+			endEvent(sev)
+			delete(events, sev.Name)
+		})
+	}
+}
+
+func unscheduleEvent(name string) {
+	sev := events[name]
+	if sev == nil {
+		return
+	}
+
+	sev.cancelTimers()
+	if sev.started {
+		endEvent(sev)
+	}
+	delete(events, name)
+}
+
+func startEvent(sev *scheduledEvent) {
+	logger.Info("calendar: event %s started: %s ~ %s", sev.Name, sev.Start, sev.End)
+	sev.started = true
+	notifySubscribers(sev, true)
+}
+
+func endEvent(sev *scheduledEvent) {
+	if !sev.started {
+		return
+	}
+
+	logger.Info("calendar: event %s ended", sev.Name)
+	sev.started = false
+	notifySubscribers(sev, false)
+}
+
+func notifySubscribers(sev *scheduledEvent, started bool) {
+	ev := sev.Event
+	for _, sub := range subscribers {
+		if sub.eventName == "" || sub.eventName == ev.Name {
+			cb := sub.cb
+			gwutils.RunPanicless(func() {
+				cb(&ev, started)
+			})
+		}
+	}
+}
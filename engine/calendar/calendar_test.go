@@ -0,0 +1,76 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventIsActive(t *testing.T) {
+	now := time.Now()
+	ev := Event{Name: "test", Start: now.Add(-time.Minute), End: now.Add(time.Minute)}
+	if !ev.IsActive(now) {
+		t.Errorf("event should be active")
+	}
+	if ev.IsActive(now.Add(time.Minute)) {
+		t.Errorf("event should not be active at end time")
+	}
+}
+
+func TestCatchUpActiveEvent(t *testing.T) {
+	var started, ended []string
+	h := Subscribe("catchup", func(ev *Event, isStart bool) {
+		if isStart {
+			started = append(started, ev.Name)
+		} else {
+			ended = append(ended, ev.Name)
+		}
+	})
+	defer h.Unsubscribe()
+
+	now := time.Now()
+	scheduleEvent(Event{Name: "catchup", Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	if len(started) != 1 {
+		t.Fatalf("active event should be started immediately, but started %d times", len(started))
+	}
+
+	scheduleEvent(Event{Name: "catchup", Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	if len(started) != 1 {
+		t.Errorf("unchanged event should not be started again")
+	}
+
+	unscheduleEvent("catchup")
+	if len(ended) != 1 {
+		t.Errorf("removed active event should be ended")
+	}
+	if GetEvent("catchup") != nil {
+		t.Errorf("removed event should not be found")
+	}
+}
+
+func TestSkipEndedEvent(t *testing.T) {
+	now := time.Now()
+	scheduleEvent(Event{Name: "ended", Start: now.Add(-time.Hour * 2), End: now.Add(-time.Hour)})
+	if GetEvent("ended") != nil {
+		t.Errorf("ended event should not be scheduled")
+	}
+}
+
+func TestChangeEventToEnded(t *testing.T) {
+	var ended []string
+	h := Subscribe("changed", func(ev *Event, isStart bool) {
+		if !isStart {
+			ended = append(ended, ev.Name)
+		}
+	})
+	defer h.Unsubscribe()
+
+	now := time.Now()
+	scheduleEvent(Event{Name: "changed", Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	scheduleEvent(Event{Name: "changed", Start: now.Add(-time.Hour * 2), End: now.Add(-time.Hour)})
+	if len(ended) != 1 {
+		t.Errorf("active event changed to be ended should be ended, but ended %d times", len(ended))
+	}
+	if GetEvent("changed") != nil {
+		t.Errorf("event changed to be ended should be removed")
+	}
+}
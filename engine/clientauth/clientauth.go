@@ -0,0 +1,54 @@
+package clientauth
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Authentication of clients at gates
+//
+// If client_auth of the gate is set, the first packet of client (after encoding handshake) must carry a token, which
+// is validated by the verifier before the client is connected to game:
+//
+//	hmac    token is "USER.EXPIRE.SIGNATURE", where EXPIRE is unix seconds and SIGNATURE is the hex HMAC-SHA256
+//	        of "USER.EXPIRE" with the secret client_auth_key
+//	jwt     JWT signed by HS256 with the secret client_auth_key, exp is checked and sub is the user
+//	http    POST the token to the URL client_auth_key, the token is valid if status is 200, and the body is the user
+//
+// Other verifiers can be plugged in by RegisterVerifier for custom builds of gate.
+
+// Verifier validates the token of client, and returns the user of token. Verify is called concurrently.
+type Verifier interface {
+	Verify(token string) (user string, err error)
+}
+
+// Create the verifier with key configured by client_auth_key
+type VerifierFactory func(key string) (Verifier, error)
+
+var (
+	verifierFactoriesLock sync.Mutex
+	verifierFactories     = map[string]VerifierFactory{
+		"hmac": newHMACVerifier,
+		"jwt":  newJWTVerifier,
+		"http": newHTTPVerifier,
+	}
+)
+
+// Register verifier type which can be configured by client_auth, should be called before the gate starts
+func RegisterVerifier(verifierType string, factory VerifierFactory) {
+	verifierFactoriesLock.Lock()
+	verifierFactories[verifierType] = factory
+	verifierFactoriesLock.Unlock()
+}
+
+// Create the verifier of type with key
+func NewVerifier(verifierType string, key string) (Verifier, error) {
+	verifierFactoriesLock.Lock()
+	factory := verifierFactories[verifierType]
+	verifierFactoriesLock.Unlock()
+	if factory == nil {
+		return nil, errors.Errorf("unknown client auth verifier: %s", verifierType)
+	}
+	return factory(key)
+}
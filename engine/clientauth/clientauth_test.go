@@ -0,0 +1,86 @@
+package clientauth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHMACVerifier(t *testing.T) {
+	v, err := NewVerifier("hmac", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := GenHMACToken("secret", "user.1", time.Now().Add(time.Minute))
+	if user, err := v.Verify(token); err != nil || user != "user.1" {
+		t.Errorf("verify %s: user=%s, err=%v", token, user, err)
+	}
+	if _, err := v.Verify(GenHMACToken("other", "user.1", time.Now().Add(time.Minute))); err == nil {
+		t.Errorf("token signed by other secret should be invalid")
+	}
+	if _, err := v.Verify(GenHMACToken("secret", "user.1", time.Now().Add(-time.Minute))); err == nil {
+		t.Errorf("expired token should be invalid")
+	}
+	if _, err := v.Verify("user.1"); err == nil {
+		t.Errorf("malformed token should be invalid")
+	}
+}
+
+func genJWT(secret string, header string, claims string) string {
+	data := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return data + "." + base64.RawURLEncoding.EncodeToString(signHMAC([]byte(secret), data))
+}
+
+func TestJWTVerifier(t *testing.T) {
+	v, err := NewVerifier("jwt", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token := genJWT("secret", `{"alg":"HS256","typ":"JWT"}`, `{"sub":"user1","exp":4102444800}`)
+	if user, err := v.Verify(token); err != nil || user != "user1" {
+		t.Errorf("verify %s: user=%s, err=%v", token, user, err)
+	}
+	if _, err := v.Verify(genJWT("other", `{"alg":"HS256"}`, `{"sub":"user1"}`)); err == nil {
+		t.Errorf("JWT signed by other secret should be invalid")
+	}
+	if _, err := v.Verify(genJWT("secret", `{"alg":"none"}`, `{"sub":"user1"}`)); err == nil {
+		t.Errorf("JWT of alg none should be invalid")
+	}
+	if _, err := v.Verify(genJWT("secret", `{"alg":"HS256"}`, `{"sub":"user1","exp":1}`)); err == nil {
+		t.Errorf("expired JWT should be invalid")
+	}
+}
+
+func TestHTTPVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 64)
+		n, _ := r.Body.Read(buf)
+		if string(buf[:n]) != "good" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte("user1\n"))
+	}))
+	defer server.Close()
+
+	v, err := NewVerifier("http", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user, err := v.Verify("good"); err != nil || user != "user1" {
+		t.Errorf("verify good token: user=%s, err=%v", user, err)
+	}
+	if _, err := v.Verify("bad"); err == nil {
+		t.Errorf("bad token should be invalid")
+	}
+}
+
+func TestUnknownVerifier(t *testing.T) {
+	if _, err := NewVerifier("unknown", "key"); err == nil {
+		t.Errorf("unknown verifier type should fail")
+	}
+}
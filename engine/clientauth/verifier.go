@@ -0,0 +1,157 @@
+package clientauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_HTTP_VERIFY_TIMEOUT = 5 * time.Second
+)
+
+func signHMAC(secret []byte, data string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// Verifier of tokens signed by HMAC-SHA256 with expire time
+type hmacVerifier struct {
+	secret []byte
+}
+
+func newHMACVerifier(key string) (Verifier, error) {
+	if key == "" {
+		return nil, errors.New("HMAC secret is empty")
+	}
+	return &hmacVerifier{secret: []byte(key)}, nil
+}
+
+// Generate the HMAC token of user expiring at expireTime, for login servers issuing tokens to clients
+func GenHMACToken(secret string, user string, expireTime time.Time) string {
+	data := user + "." + strconv.FormatInt(expireTime.Unix(), 10)
+	return data + "." + hex.EncodeToString(signHMAC([]byte(secret), data))
+}
+
+func (v *hmacVerifier) Verify(token string) (string, error) {
+	i := strings.LastIndexByte(token, '.')
+	if i < 0 {
+		return "", errors.New("malformed token")
+	}
+	data := token[:i]
+	sig, err := hex.DecodeString(token[i+1:])
+	if err != nil || !hmac.Equal(sig, signHMAC(v.secret, data)) {
+		return "", errors.New("invalid signature")
+	}
+
+	j := strings.LastIndexByte(data, '.')
+	if j < 0 {
+		return "", errors.New("malformed token")
+	}
+	expire, err := strconv.ParseInt(data[j+1:], 10, 64)
+	if err != nil {
+		return "", errors.New("malformed expire time")
+	}
+	if time.Now().Unix() > expire {
+		return "", errors.New("token expired")
+	}
+	return data[:j], nil
+}
+
+// Verifier of JWT signed by HS256
+type jwtVerifier struct {
+	secret []byte
+}
+
+func newJWTVerifier(key string) (Verifier, error) {
+	if key == "" {
+		return nil, errors.New("JWT secret is empty")
+	}
+	return &jwtVerifier{secret: []byte(key)}, nil
+}
+
+func (v *jwtVerifier) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed JWT")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "HS256" {
+		return "", errors.Errorf("unsupported JWT alg: %s", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, signHMAC(v.secret, parts[0]+"."+parts[1])) {
+		return "", errors.New("invalid signature")
+	}
+
+	var claims struct {
+		Sub string `json:"sub"`
+		Exp int64  `json:"exp"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.Exp != 0 && time.Now().Unix() > claims.Exp {
+		return "", errors.New("token expired")
+	}
+	return claims.Sub, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.Wrap(err, "malformed JWT")
+	}
+	return errors.Wrap(json.Unmarshal(data, v), "malformed JWT")
+}
+
+// Verifier posting tokens to the HTTP service of accounts
+type httpVerifier struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPVerifier(key string) (Verifier, error) {
+	if key == "" {
+		return nil, errors.New("URL is empty")
+	}
+	return &httpVerifier{
+		url:    key,
+		client: &http.Client{Timeout: _HTTP_VERIFY_TIMEOUT},
+	}, nil
+}
+
+func (v *httpVerifier) Verify(token string) (string, error) {
+	resp, err := v.client.Post(v.url, "text/plain", bytes.NewReader([]byte(token)))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("post to %s: %s", v.url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"fmt"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+// Namespaces isolate multiple games (e.g. small games or test partitions) sharing one cluster
+//
+// Each game belongs to one namespace configured by its config section, and entity IDs generated by the game carry
+// the namespace, so that dispatchers can route calls, create entities and declare services only within namespaces.
+// The default namespace 0 is compatible with entity IDs generated without namespaces.
+type Namespace uint8
+
+const (
+	DEFAULT_NAMESPACE Namespace = 0
+	MAX_NAMESPACE     Namespace = 63
+)
+
+var (
+	localNamespace = DEFAULT_NAMESPACE
+)
+
+// Set the namespace of entity IDs generated by this process
+func SetLocalNamespace(ns Namespace) {
+	if ns > MAX_NAMESPACE {
+		gwlog.Panicf("invalid namespace: %d", ns)
+	}
+	localNamespace = ns
+}
+
+// Get the namespace of this process
+func GetLocalNamespace() Namespace {
+	return localNamespace
+}
+
+// Get the namespace of entity
+func (id EntityID) Namespace() Namespace {
+	return Namespace(uuid.NamespaceOf(string(id)))
+}
+
+// Get the prefix of KVDB keys in namespace, empty for the default namespace
+func (ns Namespace) KeyPrefix() string {
+	if ns == DEFAULT_NAMESPACE {
+		return ""
+	}
+	return fmt.Sprintf("ns%d/", ns)
+}
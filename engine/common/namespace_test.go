@@ -0,0 +1,19 @@
+package common
+
+import "testing"
+
+func TestNamespace(t *testing.T) {
+	if ns := GenEntityID().Namespace(); ns != DEFAULT_NAMESPACE {
+		t.Fatalf("entity ID should be in default namespace, but got %d", ns)
+	}
+
+	SetLocalNamespace(5)
+	defer SetLocalNamespace(DEFAULT_NAMESPACE)
+	if id := GenEntityID(); len(id) != ENTITYID_LENGTH || id.Namespace() != 5 {
+		t.Fatalf("entity ID %s should be in namespace 5", id)
+	}
+
+	if DEFAULT_NAMESPACE.KeyPrefix() != "" || Namespace(5).KeyPrefix() != "ns5/" {
+		t.Errorf("wrong key prefix")
+	}
+}
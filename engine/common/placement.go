@@ -0,0 +1,62 @@
+package common
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Labels of game, e.g. "region=eu,tier=premium"
+type Labels map[string]string
+
+// Parse labels in the format of "key1=value1,key2=value2"
+func ParseLabels(s string) (Labels, error) {
+	labels := Labels{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || key == "" {
+			return nil, fmt.Errorf("invalid label: %s", item)
+		}
+		labels[key] = strings.TrimSpace(kv[1])
+	}
+	return labels, nil
+}
+
+func (labels Labels) String() string {
+	items := make([]string, 0, len(labels))
+	for key, val := range labels {
+		items = append(items, key+"="+val)
+	}
+	return strings.Join(items, ",")
+}
+
+// Check if labels match the placement constraint
+//
+// Placement constraint is a comma separated list of requirements "key=value" or "key!=value", and matches labels
+// only if all requirements are satisfied. Empty placement constraint matches any labels.
+func MatchPlacement(placement string, labels Labels) bool {
+	for _, item := range strings.Split(placement, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if kv := strings.SplitN(item, "!=", 2); len(kv) == 2 {
+			if labels[strings.TrimSpace(kv[0])] == strings.TrimSpace(kv[1]) {
+				return false
+			}
+		} else if kv := strings.SplitN(item, "=", 2); len(kv) == 2 {
+			if val, ok := labels[strings.TrimSpace(kv[0])]; !ok || val != strings.TrimSpace(kv[1]) {
+				return false
+			}
+		} else if _, ok := labels[item]; !ok { // only the key is required
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,36 @@
+package common
+
+import "testing"
+
+func TestParseLabels(t *testing.T) {
+	labels, err := ParseLabels("region=eu, tier = premium,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(labels) != 2 || labels["region"] != "eu" || labels["tier"] != "premium" {
+		t.Errorf("wrong labels: %v", labels)
+	}
+
+	if _, err := ParseLabels("region"); err == nil {
+		t.Errorf("label without value should be invalid")
+	}
+}
+
+func TestMatchPlacement(t *testing.T) {
+	labels := Labels{"region": "eu", "tier": "premium"}
+	for placement, match := range map[string]bool{
+		"":                      true,
+		"region=eu":             true,
+		"region=eu,tier=free":   false,
+		"region!=us":            true,
+		"region!=eu":            false,
+		"tier":                  true,
+		"gpu":                   false,
+		"zone=":                 false,
+		"tier=premium, gpu!=1 ": true,
+	} {
+		if MatchPlacement(placement, labels) != match {
+			t.Errorf("MatchPlacement(%q) should be %v", placement, match)
+		}
+	}
+}
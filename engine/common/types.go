@@ -14,6 +14,9 @@ func (id EntityID) IsNil() bool {
 }
 
 func GenEntityID() EntityID {
+	if localNamespace != DEFAULT_NAMESPACE {
+		return EntityID(uuid.GenNamespacedUUID(byte(localNamespace)))
+	}
 	return EntityID(uuid.GenUUID())
 }
 
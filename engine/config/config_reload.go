@@ -0,0 +1,170 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Hot reload of changeable settings on SIGHUP or config file change, without restarting processes
+//
+// The config file is read again, and only changeable settings are applied to the current config: log_level and
+// log_module_levels of all processes, save_interval and aoi_distance of games, and max_clients of gates. Other
+// settings such as addresses and ports are kept until processes restart. The current config is also kept if the
+// config file fails to read, so that a broken config file does not crash running processes. Processes register
+// reload handlers to apply the changed settings to running services.
+
+const (
+	_CONFIG_WATCH_INTERVAL = time.Second * 3
+)
+
+var (
+	reloadHandlers     []func(cfg *GoWorldConfig)
+	reloadHandlersLock sync.Mutex
+	watchOnce          sync.Once
+)
+
+// Register the handler called with the config after changeable settings are reloaded, handlers are not called in
+// the main routine
+func OnReload(handler func(cfg *GoWorldConfig)) {
+	reloadHandlersLock.Lock()
+	reloadHandlers = append(reloadHandlers, handler)
+	reloadHandlersLock.Unlock()
+}
+
+// Watch the config file, reload changeable settings on SIGHUP or when the config file is modified
+func Watch() {
+	watchOnce.Do(func() {
+		go watchForever()
+	})
+}
+
+func watchForever() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	ticker := time.NewTicker(_CONFIG_WATCH_INTERVAL)
+	modTime := configModTime()
+
+	for {
+		select {
+		case <-sigChan:
+			gwlog.Info("SIGHUP received, reloading config file %s ...", configFilePath)
+		case <-ticker.C:
+			if configModTime().Equal(modTime) {
+				continue
+			}
+			gwlog.Info("Config file %s is modified, reloading ...", configFilePath)
+		}
+
+		modTime = configModTime()
+		if err := ReloadChangeable(); err != nil {
+			gwlog.Error("Reload config failed, current config is kept: %s", err)
+		}
+	}
+}
+
+// Get the modification time of config file, zero if the file can not be accessed, e.g. when it is being replaced
+func configModTime() time.Time {
+	info, err := os.Stat(configFilePath)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// Read the config file again and apply changeable settings to the current config, then call reload handlers
+func ReloadChangeable() error {
+	latest, err := tryReadGoWorldConfig()
+	if err != nil {
+		return err
+	}
+
+	configLock.Lock()
+	cfg := latest
+	if goWorldConfig != nil {
+		cfg = mergeChangeableConfig(goWorldConfig, latest)
+		if DumpPretty(cfg) != DumpPretty(latest) {
+			gwlog.Warn("Config file %s has changes of settings which are not changeable at runtime, they are applied after restart", configFilePath)
+		}
+	}
+	goWorldConfig = cfg
+	configLock.Unlock()
+
+	reloadHandlersLock.Lock()
+	handlers := reloadHandlers
+	reloadHandlersLock.Unlock()
+	for _, handler := range handlers {
+		handler(cfg)
+	}
+	return nil
+}
+
+// Read the config file, config errors are returned instead of panics
+func tryReadGoWorldConfig() (config *GoWorldConfig, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			config, err = nil, errors.Errorf("%v", r)
+		}
+	}()
+	return readGoWorldConfig(), nil
+}
+
+// Copy the current config with changeable settings of the latest config, configs are never modified in place since
+// they are accessed concurrently
+func mergeChangeableConfig(current *GoWorldConfig, latest *GoWorldConfig) *GoWorldConfig {
+	cfg := *current
+	mergeChangeableGameConfig(&cfg.GameCommon, &latest.GameCommon)
+	cfg.Games = make(map[int]*GameConfig, len(current.Games))
+	for id, game := range current.Games {
+		game := *game
+		if latestGame := latest.Games[id]; latestGame != nil {
+			mergeChangeableGameConfig(&game, latestGame)
+		}
+		cfg.Games[id] = &game
+	}
+
+	mergeChangeableGateConfig(&cfg.GateCommon, &latest.GateCommon)
+	cfg.Gates = make(map[int]*GateConfig, len(current.Gates))
+	for id, gate := range current.Gates {
+		gate := *gate
+		if latestGate := latest.Gates[id]; latestGate != nil {
+			mergeChangeableGateConfig(&gate, latestGate)
+		}
+		cfg.Gates[id] = &gate
+	}
+
+	cfg.Dispatcher.LogLevel = latest.Dispatcher.LogLevel
+	cfg.Dispatcher.LogModuleLevels = latest.Dispatcher.LogModuleLevels
+	cfg.Dispatchers = make(map[int]*DispatcherConfig, len(current.Dispatchers))
+	cfg.Dispatchers[1] = &cfg.Dispatcher
+	for id, dispatcher := range current.Dispatchers {
+		if id == 1 {
+			continue
+		}
+		dispatcher := *dispatcher
+		if latestDispatcher := latest.Dispatchers[id]; latestDispatcher != nil {
+			dispatcher.LogLevel = latestDispatcher.LogLevel
+			dispatcher.LogModuleLevels = latestDispatcher.LogModuleLevels
+		}
+		cfg.Dispatchers[id] = &dispatcher
+	}
+	return &cfg
+}
+
+func mergeChangeableGameConfig(game *GameConfig, latest *GameConfig) {
+	game.LogLevel = latest.LogLevel
+	game.LogModuleLevels = latest.LogModuleLevels
+	game.SaveInterval = latest.SaveInterval
+	game.AOIDistance = latest.AOIDistance
+}
+
+func mergeChangeableGateConfig(gate *GateConfig, latest *GateConfig) {
+	gate.LogLevel = latest.LogLevel
+	gate.LogModuleLevels = latest.LogModuleLevels
+	gate.MaxClients = latest.MaxClients
+}
@@ -67,3 +67,31 @@ func TestGetStorage(t *testing.T) {
 	gwlog.Info("storage config:")
 	fmt.Fprintf(os.Stderr, "%s\n", DumpPretty(cfg))
 }
+
+func TestMergeChangeableConfig(t *testing.T) {
+	newConfig := func(port int, maxClients int, logLevel string) *GoWorldConfig {
+		cfg := &GoWorldConfig{
+			Dispatchers: map[int]*DispatcherConfig{},
+			Games:       map[int]*GameConfig{1: {LogLevel: logLevel, PProfPort: port}},
+			Gates:       map[int]*GateConfig{1: {Port: port, MaxClients: maxClients, LogLevel: logLevel}},
+		}
+		cfg.Dispatcher.LogLevel = logLevel
+		cfg.Dispatchers[1] = &cfg.Dispatcher
+		return cfg
+	}
+
+	current := newConfig(15011, 0, "debug")
+	cfg := mergeChangeableConfig(current, newConfig(15012, 100, "info"))
+	if cfg.Gates[1].MaxClients != 100 || cfg.Gates[1].LogLevel != "info" || cfg.Games[1].LogLevel != "info" || cfg.Dispatcher.LogLevel != "info" {
+		t.Errorf("changeable settings are not merged: %s", DumpPretty(cfg))
+	}
+	if cfg.Gates[1].Port != 15011 || cfg.Games[1].PProfPort != 15011 {
+		t.Errorf("settings which are not changeable are merged: %s", DumpPretty(cfg))
+	}
+	if cfg.Dispatchers[1] != &cfg.Dispatcher {
+		t.Errorf("dispatcher1 should be the dispatcher section")
+	}
+	if current.Gates[1].MaxClients != 0 || current.Dispatcher.LogLevel != "debug" {
+		t.Errorf("current config is modified in place")
+	}
+}
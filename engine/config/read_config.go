@@ -18,18 +18,40 @@ import (
 	"os"
 
 	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"gopkg.in/ini.v1"
 )
 
 const (
-	DEFAULT_CONFIG_FILE   = "goworld.ini"
-	DEFAULT_LOCALHOST_IP  = "127.0.0.1"
-	DEFAULT_SAVE_ITNERVAL = time.Minute * 5
-	DEFAULT_PPROF_IP      = "127.0.0.1"
-	DEFAULT_LOG_LEVEL     = "debug"
-	DEFAULT_STORAGE_DB    = "goworld"
+	DEFAULT_CONFIG_FILE        = "goworld.ini"
+	DEFAULT_LOCALHOST_IP       = "127.0.0.1"
+	DEFAULT_SAVE_ITNERVAL      = time.Minute * 5
+	DEFAULT_PPROF_IP           = "127.0.0.1"
+	DEFAULT_LOG_LEVEL          = "debug"
+	DEFAULT_STORAGE_DB         = "goworld"
+	DEFAULT_WEBSOCKET_PATH     = "/"
+	DEFAULT_KCP_MTU            = 1400
+	DEFAULT_KCP_WINDOW         = 128
+	DEFAULT_TRACE_SAMPLE_RATIO = 0.1
+
+	DEFAULT_ANALYTICS_SAMPLE_RATIO     = 1
+	DEFAULT_ANALYTICS_RPC_SAMPLE_RATIO = 0.01 // RPC events are sampled by default since they are much more than others
+	DEFAULT_PLACEMENT_POLICY           = "least_loaded"
+
+	DUPLICATE_LOGIN_POLICY_KICK_OLD   = "kick_old"
+	DUPLICATE_LOGIN_POLICY_REJECT_NEW = "reject_new"
+
+	STORAGE_READ_VALIDATION_OFF        = "off"
+	STORAGE_READ_VALIDATION_RETRY      = "retry"      // retry reading corrupted data, fail the load if retries fail
+	STORAGE_READ_VALIDATION_BACKUP     = "backup"     // also fall back to the last-known-good backup copy
+	STORAGE_READ_VALIDATION_QUARANTINE = "quarantine" // also copy corrupted data to quarantine before falling back
+	DEFAULT_STORAGE_READ_RETRIES       = 3
+	DEFAULT_STORAGE_BREAKER_FAILURES   = 5
+	DEFAULT_STORAGE_BREAKER_COOLDOWN   = time.Second * 5
+	DEFAULT_STORAGE_RETRY_QUEUE_SIZE   = 10000
+	DEFAULT_STORAGE_S3_CACHE_SIZE      = 1000
 )
 
 var (
@@ -45,8 +67,43 @@ type GameConfig struct {
 	LogStderr    bool
 	PProfIp      string
 	PProfPort    int
+	MetricsIp    string // metrics HTTP server serving /metrics, disabled if port is 0
+	MetricsPort  int
 	LogLevel     string
+	LogFormat    string // text or json
 	GoMaxProcs   int
+	Labels       common.Labels    // labels for placement constraints, e.g. region=eu,tier=premium
+	Namespace    common.Namespace // namespace of entities and services, isolated from games of other namespaces
+	AOIDistance  float64          // default AOI distance of entities, DEFAULT_AOI_DISTANCE of entities if 0
+	StandbyOf    uint16           // primary game of this standby game, 0 if this game is not a standby
+
+	// capture freeze data of changed entities in the background, so that freezing only handles recent changes
+	IncrementalFreeze bool
+	// file, Redis or S3 URL where freeze data are written to and restored from, working directory if empty
+	FreezeTarget string
+
+	// levels of log modules like "aoi=warn,kvdb=error", overriding log_level for the modules
+	LogModuleLevels string
+
+	// admin HTTP API for live inspection, disabled if port is 0
+	AdminIp    string
+	AdminPort  int
+	AdminToken string
+
+	// IDIP adapter for GM operations of operations platforms, disabled if port is 0
+	IDIPIp    string
+	IDIPPort  int
+	IDIPToken string
+
+	// tracing of entity RPC chains exported to OTLP/HTTP endpoint, disabled if endpoint is empty
+	TraceEndpoint    string
+	TraceSampleRatio float64
+
+	// analytics events of engine emitted to file, http or custom sinks, disabled if sink is empty
+	AnalyticsSink           string
+	AnalyticsTarget         string // file path or URL of sink
+	AnalyticsSampleRatio    float64
+	AnalyticsRPCSampleRatio float64
 }
 
 type GateConfig struct {
@@ -56,9 +113,47 @@ type GateConfig struct {
 	LogStderr          bool
 	PProfIp            string
 	PProfPort          int
+	MetricsIp          string // metrics HTTP server serving /metrics, disabled if port is 0
+	MetricsPort        int
 	LogLevel           string
+	LogFormat          string // text or json
 	GoMaxProcs         int
 	CompressConnection bool
+	BootPlacement      string           // placement constraint of games creating boot entities for clients of this gate
+	Namespace          common.Namespace // clients of this gate can only call entities in the namespace
+	MaxClients         int              // max number of connected clients, new connections are rejected if reached, unlimited if 0
+
+	// levels of log modules like "aoi=warn,kvdb=error", overriding log_level for the modules
+	LogModuleLevels string
+
+	// admin HTTP API for live inspection, disabled if port is 0
+	AdminIp    string
+	AdminPort  int
+	AdminToken string
+
+	ClientEncodingHandshake bool          // clients negotiate encoding of packets (msgpack or protobuf) by the first packet
+	ClientReconnectWindow   time.Duration // disconnected clients can resume sessions on any gate in time without logout, disabled if 0
+	ClientAuth              string        // verifier of the token sent by clients as the first packet, disabled if empty
+	ClientAuthKey           string        // secret or URL of the verifier
+
+	// WebSocket listener for browser clients, disabled if port is 0
+	WebSocketPort    int
+	WebSocketPath    string
+	WebSocketOrigins []string // allowed origins of WebSocket clients, all origins are allowed if empty
+	WebSocketTLSCert string   // cert and key for wss
+	WebSocketTLSKey  string
+
+	// KCP listener for clients on lossy networks, disabled if port is 0
+	KCPPort       int
+	KCPMTU        int
+	KCPSendWindow int
+	KCPRecvWindow int
+	KCPNoDelay    bool // fast mode for lower latency
+}
+
+// Check if WebSocket clients are connected through TLS (wss)
+func (config *GateConfig) IsWebSocketTLSEnabled() bool {
+	return config.WebSocketTLSCert != ""
 }
 
 type DispatcherConfig struct {
@@ -69,37 +164,115 @@ type DispatcherConfig struct {
 	PProfIp   string
 	PProfPort int
 	LogLevel  string
+	LogFormat string // text or json
+	Secret    string
+
+	MetricsIp   string // metrics HTTP server serving /metrics, disabled if port is 0
+	MetricsPort int
+
+	DuplicateLoginPolicy string
+	MaxLoginSessions     int
+
+	MaxPendingCalls int    // max number of calls queued for each entity during migrating or loading
+	PlacementPolicy string // policy of choosing games for entities created or loaded anywhere
+
+	// levels of log modules like "aoi=warn,kvdb=error", overriding log_level for the modules
+	LogModuleLevels string
+
+	// admin HTTP API for live inspection, disabled if port is 0
+	AdminIp    string
+	AdminPort  int
+	AdminToken string
+
+	TLSCert       string
+	TLSKey        string
+	TLSCA         string
+	TLSServerName string
+
+	// Standby dispatcher which replicates routing tables of the primary dispatcher, and takes over on primary failure
+	StandbyIp   string
+	StandbyPort int
+}
+
+// Check if a standby dispatcher is configured
+func (config *DispatcherConfig) HasStandby() bool {
+	return config.StandbyPort > 0
+}
+
+// Check if inter-process links to dispatcher are encrypted by TLS
+func (config *DispatcherConfig) IsTLSEnabled() bool {
+	return config.TLSCert != ""
+}
+
+// Config of the dashboard serving web UI of the cluster, which aggregates admin APIs of all processes
+type DashboardConfig struct {
+	Ip        string
+	Port      int
+	Token     string // token of users logging in the web UI
+	LogFile   string
+	LogStderr bool
+	LogLevel  string
+	LogFormat string // text or json
 }
 
 type GoWorldConfig struct {
-	Dispatcher DispatcherConfig
-	GameCommon GameConfig
-	GateCommon GateConfig
-	Games      map[int]*GameConfig
-	Gates      map[int]*GateConfig
-	Storage    StorageConfig
-	KVDB       KVDBConfig
+	Dispatcher   DispatcherConfig
+	Dispatchers  map[int]*DispatcherConfig // all dispatchers by ID, dispatcher1 is the [dispatcher] section
+	GameCommon   GameConfig
+	GateCommon   GateConfig
+	Games        map[int]*GameConfig
+	Gates        map[int]*GateConfig
+	Storage      StorageConfig
+	TypeStorages map[string]*StorageConfig // storages of entity types by names of [storage_<name>] sections
+	KVDB         KVDBConfig
+	Rank         RankConfig
+	Dashboard    DashboardConfig
 }
 
 type StorageConfig struct {
 	Type string
 	// Filesystem Storage Configs
 	Directory string // directory for filesystem storage
-	// MongoDB storage configs
-	Url  string
+	// MongoDB, PostgreSQL, SQLite & bolt storage configs
+	Url  string // path of database file for SQLite & bolt
 	DB   string
-	Host string // Redis host
+	Host string // Redis host, or comma separated start nodes of Redis Cluster
+	// Write-behind cache, disabled if window is 0
+	WriteBehindWindow time.Duration
+	// Timeouts of storage operations and circuit breaker, disabled if timeout is 0
+	OpTimeout       time.Duration
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	RetryQueueSize  int // max number of entities with failed writes waiting for retry
+	// Validation of data read from storage, see STORAGE_READ_VALIDATION_*
+	ReadValidation string
+	ReadRetries    int
+	// Check revisions of entity data before saving, so saves of the same entity by two games fail
+	CheckRevision bool
+	// Entities of cold types are stored in S3 (or S3-compatible storages) of cold url, disabled if no cold types
+	ColdTypes []string
+	ColdUrl   string
+	CacheSize int // max number of entities in the local cache of S3 storages
+	// Entity types stored in the storage, only for storages of [storage_<name>] sections
+	Types []string
 }
 
 type KVDBConfig struct {
 	Type       string
-	Url        string // MongoDB
+	Url        string // MongoDB, or path of database file for bolt
 	DB         string // MongoDB
 	Collection string // MongoDB
 	Host       string // Redis
 
 }
 
+// Config of the engine of rank service, ranks are kept in memory of the service if type is empty
+type RankConfig struct {
+	Type string
+	Host string // Redis
+	DB   string // Redis
+}
+
 func SetConfigFile(f string) {
 	configFilePath = f
 }
@@ -117,8 +290,8 @@ func Reload() *GoWorldConfig {
 	configLock.Lock()
 	defer configLock.Unlock()
 
-	goWorldConfig = nil
-	return Get()
+	goWorldConfig = readGoWorldConfig()
+	return goWorldConfig
 }
 
 func GetGame(serverid uint16) *GameConfig {
@@ -144,6 +317,17 @@ func GetGameIDs() []uint16 {
 	return res
 }
 
+// Get IDs of standby games of the primary game
+func GetStandbyGameIDs(primary uint16) []uint16 {
+	var standbys []uint16
+	for _, id := range GetGameIDs() {
+		if GetGame(id).StandbyOf == primary {
+			standbys = append(standbys, id)
+		}
+	}
+	return standbys
+}
+
 func GetGateIDs() []uint16 {
 	cfg := Get()
 	gateIDs := make([]int, 0, len(cfg.Gates))
@@ -163,14 +347,36 @@ func GetDispatcher() *DispatcherConfig {
 	return &Get().Dispatcher
 }
 
+// Get the config of dispatcher by ID, dispatcher IDs are 1, 2, ..., N
+func GetDispatcherByID(dispid uint16) *DispatcherConfig {
+	return Get().Dispatchers[int(dispid)]
+}
+
+func GetDispatcherCount() int {
+	return len(Get().Dispatchers)
+}
+
 func GetStorage() *StorageConfig {
 	return &Get().Storage
 }
 
+// Get storage configs of entity types by names of [storage_<name>] sections
+func GetTypeStorages() map[string]*StorageConfig {
+	return Get().TypeStorages
+}
+
 func GetKVDB() *KVDBConfig {
 	return &Get().KVDB
 }
 
+func GetRank() *RankConfig {
+	return &Get().Rank
+}
+
+func GetDashboard() *DashboardConfig {
+	return &Get().Dashboard
+}
+
 func DumpPretty(cfg interface{}) string {
 	s, err := json.MarshalIndent(cfg, "", "    ")
 	if err != nil {
@@ -181,8 +387,10 @@ func DumpPretty(cfg interface{}) string {
 
 func readGoWorldConfig() *GoWorldConfig {
 	config := GoWorldConfig{
-		Games: map[int]*GameConfig{},
-		Gates: map[int]*GateConfig{},
+		Dispatchers:  map[int]*DispatcherConfig{},
+		Games:        map[int]*GameConfig{},
+		Gates:        map[int]*GateConfig{},
+		TypeStorages: map[string]*StorageConfig{},
 	}
 	gwlog.Info("Using config file: %s", configFilePath)
 	iniFile, err := ini.Load(configFilePath)
@@ -191,6 +399,10 @@ func readGoWorldConfig() *GoWorldConfig {
 	readGameCommonConfig(serverCommonSec, &config.GameCommon)
 	gateCommonSec := iniFile.Section("gate_common")
 	readGateCommonConfig(gateCommonSec, &config.GateCommon)
+	dispatcherSec := iniFile.Section("dispatcher")
+	readDispatcherConfig(dispatcherSec, &config.Dispatcher)
+	config.Dispatchers[1] = &config.Dispatcher
+	readDashboardConfig(iniFile.Section("dashboard"), &config.Dashboard)
 
 	for _, sec := range iniFile.Sections() {
 		secName := sec.Name()
@@ -200,11 +412,16 @@ func readGoWorldConfig() *GoWorldConfig {
 
 		//gwlog.Info("Section %s", sec.Name())
 		secName = strings.ToLower(secName)
-		if secName == "dispatcher" {
-			// dispatcher config
-			readDispatcherConfig(sec, &config.Dispatcher)
-		} else if secName == "server_common" || secName == "gate_common" {
+		if secName == "dispatcher" || secName == "server_common" || secName == "gate_common" || secName == "dashboard" {
 			// ignore common section here
+		} else if len(secName) > 10 && secName[:10] == "dispatcher" {
+			// config of other dispatchers
+			id, err := strconv.Atoi(secName[10:])
+			checkConfigError(err, fmt.Sprintf("invalid dispatcher name: %s", secName))
+			if id <= 1 {
+				gwlog.Panicf("invalid dispatcher name: %s, should be dispatcher2, dispatcher3, ...", secName)
+			}
+			config.Dispatchers[id] = readOtherDispatcherConfig(sec, &config.Dispatcher)
 		} else if len(secName) > 6 && secName[:6] == "server" {
 			// server config
 			id, err := strconv.Atoi(secName[6:])
@@ -217,14 +434,43 @@ func readGoWorldConfig() *GoWorldConfig {
 		} else if secName == "storage" {
 			// storage config
 			readStorageConfig(sec, &config.Storage)
+			if len(config.Storage.Types) > 0 {
+				gwlog.Panicf("types should only be set in [storage_<name>] sections")
+			}
+		} else if strings.HasPrefix(secName, "storage_") {
+			// storage of entity types
+			typeStorage := &StorageConfig{}
+			readStorageConfig(sec, typeStorage)
+			if len(typeStorage.Types) == 0 {
+				gwlog.Panicf("types is not set in storage config of section %s", secName)
+			}
+			config.TypeStorages[secName[len("storage_"):]] = typeStorage
 		} else if secName == "kvdb" {
 			// kvdb config
 			readKVDBConfig(sec, &config.KVDB)
+		} else if secName == "rank" {
+			// rank config
+			readRankConfig(sec, &config.Rank)
 		} else {
 			gwlog.Error("unknown section: %s", secName)
 		}
 
 	}
+	for id := 1; id <= len(config.Dispatchers); id++ {
+		if config.Dispatchers[id] == nil {
+			gwlog.Panicf("dispatcher%d is not configured, dispatcher IDs should be continuous", id)
+		}
+	}
+	validateTypeStorages(&config)
+	for id, game := range config.Games {
+		if game.StandbyOf == 0 {
+			continue
+		}
+		primary := config.Games[int(game.StandbyOf)]
+		if primary == nil || int(game.StandbyOf) == id || primary.StandbyOf != 0 {
+			gwlog.Panicf("server%d has invalid standby_of: %d, should be another game which is not a standby", id, game.StandbyOf)
+		}
+	}
 	return &config
 }
 
@@ -236,7 +482,16 @@ func readGameCommonConfig(section *ini.Section, scc *GameConfig) {
 	scc.SaveInterval = DEFAULT_SAVE_ITNERVAL
 	scc.PProfIp = DEFAULT_PPROF_IP
 	scc.PProfPort = 0 // pprof not enabled by default
+	scc.MetricsIp = DEFAULT_PPROF_IP
+	scc.MetricsPort = 0 // metrics server not enabled by default
 	scc.GoMaxProcs = 0
+	scc.AdminIp = DEFAULT_PPROF_IP
+	scc.AdminPort = 0 // admin server not enabled by default
+	scc.IDIPIp = DEFAULT_PPROF_IP
+	scc.IDIPPort = 0 // IDIP adapter not enabled by default
+	scc.TraceSampleRatio = DEFAULT_TRACE_SAMPLE_RATIO
+	scc.AnalyticsSampleRatio = DEFAULT_ANALYTICS_SAMPLE_RATIO
+	scc.AnalyticsRPCSampleRatio = DEFAULT_ANALYTICS_RPC_SAMPLE_RATIO
 
 	_readGameConfig(section, scc)
 }
@@ -266,23 +521,99 @@ func _readGameConfig(sec *ini.Section, sc *GameConfig) {
 			sc.PProfIp = key.MustString(sc.PProfIp)
 		} else if name == "pprof_port" {
 			sc.PProfPort = key.MustInt(sc.PProfPort)
+		} else if name == "metrics_ip" {
+			sc.MetricsIp = key.MustString(sc.MetricsIp)
+		} else if name == "metrics_port" {
+			sc.MetricsPort = key.MustInt(sc.MetricsPort)
+		} else if name == "admin_ip" {
+			sc.AdminIp = key.MustString(sc.AdminIp)
+		} else if name == "admin_port" {
+			sc.AdminPort = key.MustInt(sc.AdminPort)
+		} else if name == "admin_token" {
+			sc.AdminToken = key.MustString(sc.AdminToken)
 		} else if name == "log_level" {
 			sc.LogLevel = key.MustString(sc.LogLevel)
+		} else if name == "log_format" {
+			sc.LogFormat = key.MustString(sc.LogFormat)
+		} else if name == "log_module_levels" {
+			sc.LogModuleLevels = readLogModuleLevels(sec, key)
 		} else if name == "gomaxprocs" {
 			sc.GoMaxProcs = key.MustInt(sc.GoMaxProcs)
+		} else if name == "labels" {
+			labels, err := common.ParseLabels(key.MustString(""))
+			if err != nil {
+				gwlog.Panic(errors.Wrapf(err, "section %s has invalid labels", sec.Name()))
+			}
+			sc.Labels = labels
+		} else if name == "namespace" {
+			sc.Namespace = readNamespace(sec, key)
+		} else if name == "idip_ip" {
+			sc.IDIPIp = key.MustString(sc.IDIPIp)
+		} else if name == "idip_port" {
+			sc.IDIPPort = key.MustInt(sc.IDIPPort)
+		} else if name == "idip_token" {
+			sc.IDIPToken = key.MustString(sc.IDIPToken)
+		} else if name == "trace_endpoint" {
+			sc.TraceEndpoint = key.MustString(sc.TraceEndpoint)
+		} else if name == "trace_sample_ratio" {
+			sc.TraceSampleRatio = key.MustFloat64(sc.TraceSampleRatio)
+		} else if name == "analytics_sink" {
+			sc.AnalyticsSink = key.MustString(sc.AnalyticsSink)
+		} else if name == "analytics_target" {
+			sc.AnalyticsTarget = key.MustString(sc.AnalyticsTarget)
+		} else if name == "analytics_sample_ratio" {
+			sc.AnalyticsSampleRatio = key.MustFloat64(sc.AnalyticsSampleRatio)
+		} else if name == "analytics_rpc_sample_ratio" {
+			sc.AnalyticsRPCSampleRatio = key.MustFloat64(sc.AnalyticsRPCSampleRatio)
+		} else if name == "standby_of" {
+			sc.StandbyOf = uint16(key.MustInt(0))
+		} else if name == "incremental_freeze" {
+			sc.IncrementalFreeze = key.MustBool(sc.IncrementalFreeze)
+		} else if name == "freeze_target" {
+			sc.FreezeTarget = key.MustString(sc.FreezeTarget)
+		} else if name == "aoi_distance" {
+			sc.AOIDistance = key.MustFloat64(sc.AOIDistance)
+			if sc.AOIDistance < 0 {
+				gwlog.Panicf("section %s has invalid aoi_distance: %v", sec.Name(), sc.AOIDistance)
+			}
 		} else {
 			gwlog.Panicf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 }
 
+func readNamespace(sec *ini.Section, key *ini.Key) common.Namespace {
+	ns := key.MustInt(0)
+	if ns < 0 || ns > int(common.MAX_NAMESPACE) {
+		gwlog.Panicf("section %s has invalid namespace %d, should be in [0, %d]", sec.Name(), ns, common.MAX_NAMESPACE)
+	}
+	return common.Namespace(ns)
+}
+
+func readLogModuleLevels(sec *ini.Section, key *ini.Key) string {
+	levels := key.MustString("")
+	if _, err := gwlog.ParseModuleLevels(levels); err != nil {
+		gwlog.Panic(errors.Wrapf(err, "section %s has invalid log_module_levels", sec.Name()))
+	}
+	return levels
+}
+
 func readGateCommonConfig(section *ini.Section, scc *GateConfig) {
 	scc.LogFile = "gate.log"
 	scc.LogStderr = true
 	scc.LogLevel = DEFAULT_LOG_LEVEL
 	scc.PProfIp = DEFAULT_PPROF_IP
 	scc.PProfPort = 0 // pprof not enabled by default
+	scc.MetricsIp = DEFAULT_PPROF_IP
+	scc.MetricsPort = 0 // metrics server not enabled by default
 	scc.GoMaxProcs = 0
+	scc.AdminIp = DEFAULT_PPROF_IP
+	scc.AdminPort = 0 // admin server not enabled by default
+	scc.WebSocketPath = DEFAULT_WEBSOCKET_PATH
+	scc.KCPMTU = DEFAULT_KCP_MTU
+	scc.KCPSendWindow = DEFAULT_KCP_WINDOW
+	scc.KCPRecvWindow = DEFAULT_KCP_WINDOW
+	scc.KCPNoDelay = true
 
 	_readGateConfig(section, scc)
 }
@@ -309,12 +640,65 @@ func _readGateConfig(sec *ini.Section, sc *GateConfig) {
 			sc.PProfIp = key.MustString(sc.PProfIp)
 		} else if name == "pprof_port" {
 			sc.PProfPort = key.MustInt(sc.PProfPort)
+		} else if name == "metrics_ip" {
+			sc.MetricsIp = key.MustString(sc.MetricsIp)
+		} else if name == "metrics_port" {
+			sc.MetricsPort = key.MustInt(sc.MetricsPort)
+		} else if name == "admin_ip" {
+			sc.AdminIp = key.MustString(sc.AdminIp)
+		} else if name == "admin_port" {
+			sc.AdminPort = key.MustInt(sc.AdminPort)
+		} else if name == "admin_token" {
+			sc.AdminToken = key.MustString(sc.AdminToken)
 		} else if name == "log_level" {
 			sc.LogLevel = key.MustString(sc.LogLevel)
+		} else if name == "log_format" {
+			sc.LogFormat = key.MustString(sc.LogFormat)
+		} else if name == "log_module_levels" {
+			sc.LogModuleLevels = readLogModuleLevels(sec, key)
 		} else if name == "gomaxprocs" {
 			sc.GoMaxProcs = key.MustInt(sc.GoMaxProcs)
 		} else if name == "compress_connection" {
 			sc.CompressConnection = key.MustBool(sc.CompressConnection)
+		} else if name == "boot_placement" {
+			sc.BootPlacement = key.MustString(sc.BootPlacement)
+		} else if name == "namespace" {
+			sc.Namespace = readNamespace(sec, key)
+		} else if name == "max_clients" {
+			sc.MaxClients = key.MustInt(sc.MaxClients)
+		} else if name == "client_encoding_handshake" {
+			sc.ClientEncodingHandshake = key.MustBool(sc.ClientEncodingHandshake)
+		} else if name == "client_reconnect_window" {
+			sc.ClientReconnectWindow = time.Second * time.Duration(key.MustInt(int(sc.ClientReconnectWindow/time.Second)))
+		} else if name == "client_auth" {
+			sc.ClientAuth = key.MustString(sc.ClientAuth)
+		} else if name == "client_auth_key" {
+			sc.ClientAuthKey = key.MustString(sc.ClientAuthKey)
+		} else if name == "websocket_port" {
+			sc.WebSocketPort = key.MustInt(sc.WebSocketPort)
+		} else if name == "websocket_path" {
+			sc.WebSocketPath = key.MustString(sc.WebSocketPath)
+		} else if name == "websocket_origins" {
+			sc.WebSocketOrigins = nil
+			for _, origin := range strings.Split(key.MustString(""), ",") {
+				if origin = strings.TrimSpace(origin); origin != "" {
+					sc.WebSocketOrigins = append(sc.WebSocketOrigins, origin)
+				}
+			}
+		} else if name == "websocket_tls_cert" {
+			sc.WebSocketTLSCert = key.MustString(sc.WebSocketTLSCert)
+		} else if name == "websocket_tls_key" {
+			sc.WebSocketTLSKey = key.MustString(sc.WebSocketTLSKey)
+		} else if name == "kcp_port" {
+			sc.KCPPort = key.MustInt(sc.KCPPort)
+		} else if name == "kcp_mtu" {
+			sc.KCPMTU = key.MustInt(sc.KCPMTU)
+		} else if name == "kcp_sndwnd" {
+			sc.KCPSendWindow = key.MustInt(sc.KCPSendWindow)
+		} else if name == "kcp_rcvwnd" {
+			sc.KCPRecvWindow = key.MustInt(sc.KCPRecvWindow)
+		} else if name == "kcp_nodelay" {
+			sc.KCPNoDelay = key.MustBool(sc.KCPNoDelay)
 		} else {
 			gwlog.Panicf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
@@ -323,12 +707,45 @@ func _readGateConfig(sec *ini.Section, sc *GateConfig) {
 
 func readDispatcherConfig(sec *ini.Section, config *DispatcherConfig) {
 	config.Ip = DEFAULT_LOCALHOST_IP
+	config.Port = 0
 	config.LogFile = ""
 	config.LogStderr = true
 	config.LogLevel = DEFAULT_LOG_LEVEL
 	config.PProfIp = DEFAULT_PPROF_IP
 	config.PProfPort = 0
+	config.MetricsIp = DEFAULT_PPROF_IP
+	config.MetricsPort = 0
+	config.AdminIp = DEFAULT_PPROF_IP
+	config.AdminPort = 0
+	config.DuplicateLoginPolicy = DUPLICATE_LOGIN_POLICY_KICK_OLD
+	config.MaxLoginSessions = 1
+	config.MaxPendingCalls = consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN
+	config.PlacementPolicy = DEFAULT_PLACEMENT_POLICY
+	config.StandbyIp = DEFAULT_LOCALHOST_IP
+	config.StandbyPort = 0
 
+	_readDispatcherConfig(sec, config)
+}
+
+// Read config of dispatcher2, dispatcher3, ..., which is copied from [dispatcher] except address and standby
+func readOtherDispatcherConfig(sec *ini.Section, dispatcherConfig *DispatcherConfig) *DispatcherConfig {
+	var config DispatcherConfig = *dispatcherConfig
+	config.Port = 0
+	config.PProfPort = 0
+	config.MetricsPort = 0
+	config.AdminPort = 0
+	config.StandbyPort = 0
+	if config.TLSServerName == dispatcherConfig.Ip {
+		config.TLSServerName = "" // defaults to ip of this dispatcher
+	}
+	_readDispatcherConfig(sec, &config)
+	if config.Port == 0 {
+		gwlog.Panicf("section %s: port is not set", sec.Name())
+	}
+	return &config
+}
+
+func _readDispatcherConfig(sec *ini.Section, config *DispatcherConfig) {
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "ip" {
@@ -343,21 +760,106 @@ func readDispatcherConfig(sec *ini.Section, config *DispatcherConfig) {
 			config.PProfIp = key.MustString(config.PProfIp)
 		} else if name == "pprof_port" {
 			config.PProfPort = key.MustInt(config.PProfPort)
+		} else if name == "metrics_ip" {
+			config.MetricsIp = key.MustString(config.MetricsIp)
+		} else if name == "metrics_port" {
+			config.MetricsPort = key.MustInt(config.MetricsPort)
+		} else if name == "admin_ip" {
+			config.AdminIp = key.MustString(config.AdminIp)
+		} else if name == "admin_port" {
+			config.AdminPort = key.MustInt(config.AdminPort)
+		} else if name == "admin_token" {
+			config.AdminToken = key.MustString(config.AdminToken)
 		} else if name == "log_level" {
 			config.LogLevel = key.MustString(config.LogLevel)
+		} else if name == "log_format" {
+			config.LogFormat = key.MustString(config.LogFormat)
+		} else if name == "log_module_levels" {
+			config.LogModuleLevels = readLogModuleLevels(sec, key)
+		} else if name == "secret" {
+			config.Secret = key.MustString(config.Secret)
+		} else if name == "duplicate_login_policy" {
+			config.DuplicateLoginPolicy = strings.ToLower(key.MustString(config.DuplicateLoginPolicy))
+		} else if name == "max_login_sessions" {
+			config.MaxLoginSessions = key.MustInt(config.MaxLoginSessions)
+		} else if name == "max_pending_calls" {
+			config.MaxPendingCalls = key.MustInt(config.MaxPendingCalls)
+		} else if name == "placement_policy" {
+			config.PlacementPolicy = strings.ToLower(key.MustString(config.PlacementPolicy))
+		} else if name == "tls_cert" {
+			config.TLSCert = key.MustString(config.TLSCert)
+		} else if name == "tls_key" {
+			config.TLSKey = key.MustString(config.TLSKey)
+		} else if name == "tls_ca" {
+			config.TLSCA = key.MustString(config.TLSCA)
+		} else if name == "tls_server_name" {
+			config.TLSServerName = key.MustString(config.TLSServerName)
+		} else if name == "standby_ip" {
+			config.StandbyIp = key.MustString(config.StandbyIp)
+		} else if name == "standby_port" {
+			config.StandbyPort = key.MustInt(config.StandbyPort)
 		} else {
 			gwlog.Panicf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
+
+	if config.DuplicateLoginPolicy != DUPLICATE_LOGIN_POLICY_KICK_OLD && config.DuplicateLoginPolicy != DUPLICATE_LOGIN_POLICY_REJECT_NEW {
+		gwlog.Panicf("section %s: invalid duplicate_login_policy: %s", sec.Name(), config.DuplicateLoginPolicy)
+	}
+	if config.MaxLoginSessions < 1 {
+		config.MaxLoginSessions = 1
+	}
+	if config.MaxPendingCalls < 1 {
+		config.MaxPendingCalls = consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN
+	}
+
+	if config.TLSServerName == "" {
+		config.TLSServerName = config.Ip
+	}
 	return
 }
 
+func readDashboardConfig(sec *ini.Section, config *DashboardConfig) {
+	config.Ip = DEFAULT_PPROF_IP
+	config.Port = 0 // dashboard not enabled by default
+	config.LogFile = "dashboard.log"
+	config.LogStderr = true
+	config.LogLevel = DEFAULT_LOG_LEVEL
+
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "ip" {
+			config.Ip = key.MustString(config.Ip)
+		} else if name == "port" {
+			config.Port = key.MustInt(config.Port)
+		} else if name == "token" {
+			config.Token = key.MustString(config.Token)
+		} else if name == "log_file" {
+			config.LogFile = key.MustString(config.LogFile)
+		} else if name == "log_stderr" {
+			config.LogStderr = key.MustBool(config.LogStderr)
+		} else if name == "log_level" {
+			config.LogLevel = key.MustString(config.LogLevel)
+		} else if name == "log_format" {
+			config.LogFormat = key.MustString(config.LogFormat)
+		} else {
+			gwlog.Panicf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+}
+
 func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 	// setup default values
 	config.Type = "filesystem"
 	config.Directory = "_entity_storage"
 	config.DB = DEFAULT_STORAGE_DB
 	config.Url = ""
+	config.ReadValidation = STORAGE_READ_VALIDATION_OFF
+	config.ReadRetries = DEFAULT_STORAGE_READ_RETRIES
+	config.BreakerFailures = DEFAULT_STORAGE_BREAKER_FAILURES
+	config.BreakerCooldown = DEFAULT_STORAGE_BREAKER_COOLDOWN
+	config.RetryQueueSize = DEFAULT_STORAGE_RETRY_QUEUE_SIZE
+	config.CacheSize = DEFAULT_STORAGE_S3_CACHE_SIZE
 
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
@@ -371,6 +873,30 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 			config.DB = key.MustString(config.DB)
 		} else if name == "host" {
 			config.Host = key.MustString(config.Host)
+		} else if name == "write_behind_window" {
+			config.WriteBehindWindow = time.Millisecond * time.Duration(key.MustInt(0))
+		} else if name == "op_timeout" {
+			config.OpTimeout = time.Millisecond * time.Duration(key.MustInt(0))
+		} else if name == "breaker_failures" {
+			config.BreakerFailures = key.MustInt(config.BreakerFailures)
+		} else if name == "breaker_cooldown" {
+			config.BreakerCooldown = time.Millisecond * time.Duration(key.MustInt(int(config.BreakerCooldown/time.Millisecond)))
+		} else if name == "retry_queue_size" {
+			config.RetryQueueSize = key.MustInt(config.RetryQueueSize)
+		} else if name == "read_validation" {
+			config.ReadValidation = strings.ToLower(key.MustString(config.ReadValidation))
+		} else if name == "read_retries" {
+			config.ReadRetries = key.MustInt(config.ReadRetries)
+		} else if name == "check_revision" {
+			config.CheckRevision = key.MustBool(config.CheckRevision)
+		} else if name == "cold_types" {
+			config.ColdTypes = readTypeNames(key)
+		} else if name == "cold_url" {
+			config.ColdUrl = key.MustString(config.ColdUrl)
+		} else if name == "cache_size" {
+			config.CacheSize = key.MustInt(config.CacheSize)
+		} else if name == "types" {
+			config.Types = readTypeNames(key)
 		} else {
 			gwlog.Panicf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
@@ -385,6 +911,17 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 	validateStorageConfig(config)
 }
 
+// Read comma separated entity type names
+func readTypeNames(key *ini.Key) []string {
+	var typeNames []string
+	for _, typeName := range strings.Split(key.MustString(""), ",") {
+		if typeName = strings.TrimSpace(typeName); typeName != "" {
+			typeNames = append(typeNames, typeName)
+		}
+	}
+	return typeNames
+}
+
 func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
@@ -430,6 +967,11 @@ func validateKVDBConfig(config *KVDBConfig) {
 		if err != nil {
 			gwlog.Panic(errors.Wrap(err, "redis db must be integer"))
 		}
+	} else if config.Type == "bolt" {
+		if config.Url == "" {
+			fmt.Fprintf(gwlog.GetOutput(), "%s\n", DumpPretty(config))
+			gwlog.Panicf("invalid %s KVDB config above", config.Type)
+		}
 	} else {
 		gwlog.Panicf("unknown storage type: %s", config.Type)
 		if consts.DEBUG_MODE {
@@ -438,6 +980,38 @@ func validateKVDBConfig(config *KVDBConfig) {
 	}
 }
 
+func readRankConfig(sec *ini.Section, config *RankConfig) {
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "type" {
+			config.Type = key.MustString(config.Type)
+		} else if name == "host" {
+			config.Host = key.MustString(config.Host)
+		} else if name == "db" {
+			config.DB = key.MustString(config.DB)
+		} else {
+			gwlog.Panicf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	if config.Type == "" {
+		// ranks are kept in memory, it's OK
+	} else if config.Type == "redis" {
+		if config.DB == "" {
+			config.DB = "0"
+		}
+		if config.Host == "" {
+			gwlog.Panicf("redis host is not set in rank config")
+		}
+		_, err := strconv.Atoi(config.DB) // make sure db is integer for redis
+		if err != nil {
+			gwlog.Panic(errors.Wrap(err, "redis db must be integer"))
+		}
+	} else {
+		gwlog.Panicf("unknown rank type: %s", config.Type)
+	}
+}
+
 func checkConfigError(err error, msg string) {
 	if err != nil {
 		if msg == "" {
@@ -447,6 +1021,25 @@ func checkConfigError(err error, msg string) {
 	}
 }
 
+// Each type should be stored in only one storage
+func validateTypeStorages(config *GoWorldConfig) {
+	storageOfType := map[string]string{}
+	for _, typeName := range config.Storage.ColdTypes {
+		storageOfType[typeName] = "cold"
+	}
+	for name, typeStorage := range config.TypeStorages {
+		if typeStorage.WriteBehindWindow > 0 || typeStorage.OpTimeout > 0 || len(typeStorage.ColdTypes) > 0 {
+			gwlog.Panicf("write_behind_window, op_timeout and cold_types should only be set in [storage] section")
+		}
+		for _, typeName := range typeStorage.Types {
+			if other, ok := storageOfType[typeName]; ok {
+				gwlog.Panicf("type %s is routed to both storage %s and storage %s", typeName, other, name)
+			}
+			storageOfType[typeName] = name
+		}
+	}
+}
+
 func validateStorageConfig(config *StorageConfig) {
 	if config.Type == "filesystem" {
 		// directory must be set
@@ -467,10 +1060,41 @@ func validateStorageConfig(config *StorageConfig) {
 		if _, err := strconv.Atoi(config.DB); err != nil {
 			gwlog.Panic(errors.Wrap(err, "redis db must be integer"))
 		}
+	} else if config.Type == "postgres" {
+		if config.Url == "" {
+			gwlog.Panicf("url is not set in %s storage config", config.Type)
+		}
+	} else if config.Type == "redis_cluster" {
+		if config.Host == "" {
+			gwlog.Panicf("redis cluster host is not set")
+		}
+	} else if config.Type == "sqlite" || config.Type == "bolt" {
+		if config.Url == "" {
+			gwlog.Panicf("url (path of database file) is not set in %s storage config", config.Type)
+		}
+	} else if config.Type == "s3" {
+		if !strings.HasPrefix(config.Url, "s3://") {
+			gwlog.Panicf("url should be s3://bucket/prefix in %s storage config, but is %q", config.Type, config.Url)
+		}
 	} else {
 		gwlog.Panicf("unknown storage type: %s", config.Type)
 		if consts.DEBUG_MODE {
 			os.Exit(2)
 		}
 	}
+
+	switch config.ReadValidation {
+	case STORAGE_READ_VALIDATION_OFF, STORAGE_READ_VALIDATION_RETRY, STORAGE_READ_VALIDATION_BACKUP, STORAGE_READ_VALIDATION_QUARANTINE:
+	default:
+		gwlog.Panicf("unknown storage read validation: %s", config.ReadValidation)
+	}
+	if config.ReadRetries < 0 {
+		gwlog.Panicf("invalid storage read retries: %d", config.ReadRetries)
+	}
+	if config.OpTimeout > 0 && (config.BreakerFailures <= 0 || config.RetryQueueSize <= 0) {
+		gwlog.Panicf("invalid storage circuit breaker: breaker_failures=%d, retry_queue_size=%d", config.BreakerFailures, config.RetryQueueSize)
+	}
+	if len(config.ColdTypes) > 0 && !strings.HasPrefix(config.ColdUrl, "s3://") {
+		gwlog.Panicf("cold_url should be s3://bucket/prefix if cold_types is set, but is %q", config.ColdUrl)
+	}
 }
@@ -11,11 +11,21 @@ const (
 	DISPATCHER_CLIENT_PROXY_WRITE_BUFFER_SIZE = 1024 * 1024
 	DISPATCHER_CLIENT_PROXY_READ_BUFFER_SIZE  = 1024 * 1024
 	ENTITY_PENDING_PACKET_QUEUE_MAX_LEN       = 1000
+	STANDBY_DISPATCHER_RECONNECT_INTERVAL     = time.Second      // interval of standby connecting to primary dispatcher
+	STANDBY_DISPATCHER_KEEPALIVE_PERIOD       = time.Second * 5  // TCP keepalive for detecting primary host failures
+	STANDBY_DISPATCHER_HEARTBEAT_INTERVAL     = time.Second      // interval of primary dispatcher sending heartbeats to standby
+	STANDBY_DISPATCHER_HEARTBEAT_TIMEOUT      = time.Second * 5  // replication is broken if no heartbeat is received in time
+	STANDBY_DISPATCHER_TAKEOVER_DELAY         = time.Second * 10 // standby dispatcher takes over if primary is not reconnected in time
+	STANDBY_GAME_TAKEOVER_DELAY               = time.Second * 3  // standby game takes over if primary game is not reconnected in time
 
 	// For Game & Gate
 	GAME_SERVICE_PACKET_QUEUE_SIZE = 10000 // packet queue size
 	// For Game
-	GAME_SERVICE_TICK_INTERVAL = time.Millisecond * 10 // server tick interval => affect timer resolution
+	GAME_SERVICE_TICK_INTERVAL   = time.Millisecond * 10 // server tick interval => affect timer resolution
+	GAME_LOAD_REPORT_INTERVAL    = time.Second * 5       // interval of reporting game load for choosing service providers
+	LOAD_SHEDDING_CHECK_INTERVAL = time.Second           // interval of checking main loop load for load shedding
+	GAME_STATS_REPORT_INTERVAL   = time.Second * 2       // interval of reporting game stats for the cluster topology feed
+	GAME_STATS_MAX_SPACES        = 100                   // only the most crowded spaces are reported in game stats
 
 	DISPATCHER_CLIENT_WRITE_BUFFER_SIZE = 1024 * 1024
 	DISPATCHER_CLIENT_READ_BUFFER_SIZE  = 1024 * 1024
@@ -24,6 +34,19 @@ const (
 	CLIENT_PROXY_WRITE_BUFFER_SIZE = 1024 * 1024
 	CLIENT_PROXY_READ_BUFFER_SIZE  = 1024 * 1024
 	COMPRESS_WRITER_POOL_SIZE      = 100
+	CLIENT_SESSION_RESUME_TIMEOUT  = time.Minute * 2 // unacknowledged messages are kept for resuming in time
+	CLIENT_ACKED_MESSAGE_WINDOW    = 1000            // max number of unacknowledged messages of each client
+
+	CLIENT_ENCODING_HANDSHAKE_TIMEOUT = time.Second * 10 // clients must negotiate encoding in time if handshake is enabled
+	CLIENT_AUTH_TIMEOUT               = time.Second * 10 // clients must send the token in time if auth is enabled
+	RPC_RATE_LIMIT_BAN_DURATION       = time.Minute * 10 // IP of clients exceeding RPC rate limits is banned by gate for the duration
+
+	GATE_DRAIN_DEFAULT_TIMEOUT = time.Minute * 10 // draining gate shuts down after timeout even if clients are still connected
+	GATE_DRAIN_CHECK_INTERVAL  = time.Second
+
+	CLIENT_RECONNECT_BUFFER_SIZE         = 1000            // max number of packets buffered for each client in the reconnect window
+	CLIENT_SESSION_TRANSFER_TIMEOUT      = time.Second * 5 // sessions should be transferred from other gates in time
+	CLIENT_SESSION_EXPIRE_CHECK_INTERVAL = time.Second
 
 	//SAVE_INTERVAL      = time.Minute * 5 // Save interval of entities
 
@@ -31,9 +54,59 @@ const (
 	DISPATCHER_MIGRATE_TIMEOUT     = time.Minute * 5
 	DISPATCHER_LOAD_TIMEOUT        = time.Minute * 5
 	DISPATCHER_FREEZE_GAME_TIMEOUT = time.Minute * 5
+	CREATE_ENTITY_ANYWHERE_TIMEOUT = time.Minute      // callback of create entity anywhere is called with error after timeout
+	REGISTER_LOGIN_TIMEOUT         = time.Second * 30 // callback of register login session is called with error after timeout
+	RPC_CALL_DEFAULT_TIMEOUT       = time.Second * 30 // default timeout of calls with results
+	IDIP_REQUEST_TIMEOUT           = time.Second * 40 // IDIP requests are replied with timeout if not handled in time
+	ADMIN_REQUEST_TIMEOUT          = time.Second * 5  // admin requests inspecting games are replied with timeout if the game is busy
+	CLUSTER_SAVE_POINT_TIMEOUT     = time.Minute      // cluster save point is aborted if not finished in time
+	MIGRATE_DATA_CACHE_SIZE        = 10000            // max number of cached migrate data for sending diffs
+	// max clock difference between dispatcher and game / gate for authentication
+	DISPATCHER_AUTH_TIMESTAMP_TOLERANCE = time.Minute
+	// For Entry Queue
+	ENTRY_QUEUE_POSITION_PUSH_INTERVAL = time.Second * 3
+	// For Space Instance Service
+	SPACE_INSTANCE_CHECK_INTERVAL  = time.Second      // interval of recycling instances whose players are gone for TTL
+	SPACE_INSTANCE_REPORT_INTERVAL = time.Second      // interval of reporting player counts of instances to the service
+	SPACE_INSTANCE_REQUEST_TIMEOUT = time.Second * 30 // requests of instances fail if not replied in time
+	// For Persistent Timer Service
+	PERSISTENT_TIMER_LOAD_INTERVAL = time.Minute     // interval of loading timers due soon from KVDB
+	PERSISTENT_TIMER_LOAD_AHEAD    = time.Minute * 2 // timers due in this duration are loaded and scheduled
+	// For Chat Service
+	CHAT_RATE_TRACKERS_SWEEP_INTERVAL = time.Minute
+	// For Mail Service
+	MAILBOX_LOAD_RETRY_INTERVAL = time.Second // loading mailboxes is retried if storage fails
+	// For Matchmaking Service
+	MATCHMAKING_INTERVAL       = time.Second      // interval of forming matches by matchers
+	MATCHMAKING_TICKET_TIMEOUT = time.Minute * 10 // tickets waiting longer are removed from queues
+	// For Rank Service
+	RANK_REQUEST_TIMEOUT = time.Second * 30 // rank queries fail if not replied in time
+	// For Entity Scripts
+	ENTITY_SCRIPT_WATCH_INTERVAL = time.Second * 2 // interval of checking modification of script files
+	// For Data Tables
+	DATATABLE_WATCH_INTERVAL = time.Second * 2 // interval of checking modification of table files
 	// For Storage
+	SAVE_ALL_DEFAULT_CONCURRENCY = 1000                  // max number of saves in progress when saving all entities
+	SAVE_ALL_WAIT_INTERVAL       = time.Millisecond * 10 // interval of checking saves in progress
+	SAVE_ALL_PROGRESS_INTERVAL   = time.Second * 5       // interval of reporting progress of saving all entities
+	GAME_SHUTDOWN_SAVE_TIMEOUT   = time.Minute * 2       // entities not saved in time are skipped when game is terminating or freezing
+	STORAGE_SAVE_BATCH_SIZE      = 100                   // max number of saves in the same tick written in one batch
 	// For Operation Monitor
 	OPMON_DUMP_INTERVAL = time.Second * 10
+	// For Entity Profiler
+	ENTITY_PROFILER_SAMPLE_INTERVAL = time.Millisecond * 10
+	// For Entity History
+	ENTITY_HISTORY_DEFAULT_CAPACITY = 1000 // default number of records kept for each entity
+	// For Sweeping References of Destroyed Entities in Dispatcher & Game
+	ENTITY_REFS_SWEEP_INTERVAL = time.Minute
+	// For Flushing Calls Queued in Dispatcher after Migrations Timeout or Canceled
+	ENTITY_PENDING_CALLS_FLUSH_INTERVAL = time.Second * 5
+	// For Replicating Entities to Standby Games
+	GAME_REPLICATION_INTERVAL = time.Millisecond * 200
+	// For Incremental Freeze
+	FREEZE_SNAPSHOT_INTERVAL = time.Second * 10 // interval of capturing attributes of changed entities
+	// For Unloading Idle Entities
+	ENTITY_IDLE_CHECK_INTERVAL = time.Second * 10
 )
 
 // Debug Options
@@ -16,6 +16,8 @@ var (
 	cancelledHandles = []Handle{}
 	entries          = map[Handle]*entry{}
 	nextHandle       = Handle(1)
+
+	logger = gwlog.Module("crontab")
 )
 
 type Handle int // Return value of Register, can be used to cancel the register
@@ -132,7 +134,7 @@ func (h Handle) Unregister() {
 
 func unregisterCancelledHandles() {
 	for _, h := range cancelledHandles {
-		gwlog.Debug("unregisterCancelledHandles: cancelling %d", h)
+		logger.Debug("unregisterCancelledHandles: cancelling %d", h)
 		delete(entries, h)
 	}
 	cancelledHandles = nil
@@ -150,7 +152,7 @@ func Initialize() {
 	}
 
 	d -= time.Nanosecond * time.Duration(now.Nanosecond())
-	gwlog.Debug("current time is %s, will setup repeat time after %s", now, d)
+	logger.Debug("current time is %s, will setup repeat time after %s", now, d)
 	timer.AddCallback(d, func() {
 		setupRepeatTimer()
 		check()
@@ -161,7 +163,7 @@ func check() {
 	unregisterCancelledHandles()
 
 	now := time.Now()
-	gwlog.Debug("Crontab: checking %d callbacks ...", len(entries))
+	logger.Debug("Crontab: checking %d callbacks ...", len(entries))
 	dayofweek, month, day, hour, minute := now.Weekday(), now.Month(), now.Day(), now.Hour(), now.Minute()
 
 	for _, entry := range entries {
@@ -174,7 +176,7 @@ func check() {
 }
 
 func setupRepeatTimer() {
-	gwlog.Debug("Crontab: setup repeat timer at time %s", time.Now())
+	logger.Debug("Crontab: setup repeat timer at time %s", time.Now())
 	timer.AddTimer(time.Minute, check)
 }
 
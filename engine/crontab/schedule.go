@@ -0,0 +1,155 @@
+package crontab
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule parsed from cron expressions, used by entity cron timers to compute fire times
+//
+// Expressions have 6 fields (second minute hour day-of-month month day-of-week) or 5 fields without second, e.g.
+// "0 0 4 * * *" for 4:00 every day. Each field is "*", "?", a value, a range "a-b", or a list of them separated by
+// commas, optionally with a step like "*/5" or "10-30/10". Day-of-week is 0-7, both 0 and 7 are Sunday.
+//
+// Expressions are evaluated in local time unless prefixed by a timezone, e.g. "TZ=Asia/Shanghai 0 0 4 * * *".
+type Schedule struct {
+	second, minute, hour, day, month, dayofweek uint64 // bit masks of matching values
+	dayStar, dayofweekStar                      bool
+	Location                                    *time.Location
+}
+
+const (
+	_SCHEDULE_SEARCH_YEARS = 5 // stop searching fire times if no time matches in years, e.g. Feb 30
+)
+
+// Parse a cron expression to schedule
+func Parse(spec string) (*Schedule, error) {
+	fields := strings.Fields(spec)
+	loc := time.Local
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		var err error
+		loc, err = time.LoadLocation(fields[0][strings.IndexByte(fields[0], '=')+1:])
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid timezone")
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) == 5 {
+		fields = append([]string{"0"}, fields...)
+	} else if len(fields) != 6 {
+		return nil, errors.Errorf("expected 5 or 6 fields, got %d", len(fields))
+	}
+
+	s := &Schedule{Location: loc}
+	var err error
+	if s.second, err = parseField(fields[0], 0, 59); err != nil {
+		return nil, errors.Wrap(err, "second")
+	}
+	if s.minute, err = parseField(fields[1], 0, 59); err != nil {
+		return nil, errors.Wrap(err, "minute")
+	}
+	if s.hour, err = parseField(fields[2], 0, 23); err != nil {
+		return nil, errors.Wrap(err, "hour")
+	}
+	if s.day, err = parseField(fields[3], 1, 31); err != nil {
+		return nil, errors.Wrap(err, "day-of-month")
+	}
+	if s.month, err = parseField(fields[4], 1, 12); err != nil {
+		return nil, errors.Wrap(err, "month")
+	}
+	if s.dayofweek, err = parseField(fields[5], 0, 7); err != nil {
+		return nil, errors.Wrap(err, "day-of-week")
+	}
+	if s.dayofweek&(1<<7) != 0 { // 7 is also Sunday
+		s.dayofweek |= 1
+	}
+	s.dayStar = fields[3] == "*" || fields[3] == "?"
+	s.dayofweekStar = fields[5] == "*" || fields[5] == "?"
+	return s, nil
+}
+
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.IndexByte(part, '/'); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, errors.Errorf("invalid step in %q", part)
+			}
+		}
+
+		var low, high int
+		if rangePart == "*" || rangePart == "?" {
+			low, high = min, max
+		} else {
+			var err error
+			bounds := strings.SplitN(rangePart, "-", 2)
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, errors.Errorf("invalid value in %q", part)
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, errors.Errorf("invalid value in %q", part)
+				}
+			} else if step > 1 { // "a/n" means from a to max
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, errors.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dayMatch := s.day&(1<<uint(t.Day())) != 0
+	dayofweekMatch := s.dayofweek&(1<<uint(t.Weekday())) != 0
+	if s.dayStar || s.dayofweekStar {
+		return dayMatch && dayofweekMatch
+	}
+	return dayMatch || dayofweekMatch // either matches if both are restricted, same as crontab
+}
+
+// Next returns the first matching time after t, or zero time if no time matches
+func (s *Schedule) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(s.Location).Truncate(time.Second).Add(time.Second)
+	yearLimit := t.Year() + _SCHEDULE_SEARCH_YEARS
+
+	for t.Year() <= yearLimit {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.Location)
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.Location)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.Location)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Truncate(time.Minute).Add(time.Minute)
+			continue
+		}
+		if s.second&(1<<uint(t.Second())) == 0 {
+			t = t.Add(time.Second)
+			continue
+		}
+		return t.In(origLoc)
+	}
+	return time.Time{}
+}
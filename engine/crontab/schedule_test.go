@@ -0,0 +1,56 @@
+package crontab
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	utc8 := time.FixedZone("UTC+8", 8*3600)
+	now := time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC) // Monday
+
+	cases := []struct {
+		spec string
+		next time.Time
+	}{
+		{"TZ=UTC 0 0 4 * * *", time.Date(2017, 1, 2, 4, 0, 0, 0, time.UTC)},
+		{"TZ=UTC */10 * * * * *", time.Date(2017, 1, 2, 3, 4, 10, 0, time.UTC)},
+		{"TZ=UTC 0 0 0 1 * *", time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 30 3 * * 0", time.Date(2017, 1, 8, 3, 30, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 12 * * 7", time.Date(2017, 1, 8, 12, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 0 15 * 5", time.Date(2017, 1, 6, 0, 0, 0, 0, time.UTC)}, // day-of-month or day-of-week
+		{"TZ=UTC 0 0 9-17/4 * 1-3 1-5", time.Date(2017, 1, 2, 17, 0, 0, 0, utc8)},
+		{"TZ=Asia/Shanghai 0 0 4 * * *", time.Date(2017, 1, 2, 20, 0, 0, 0, time.UTC)},
+		{"TZ=UTC 0 0 0 30 2 *", time.Time{}},
+	}
+	for _, c := range cases {
+		s, err := Parse(c.spec)
+		if err != nil {
+			t.Errorf("parse %q failed: %s", c.spec, err)
+			continue
+		}
+		if next := s.Next(now); !next.Equal(c.next) {
+			t.Errorf("%q: next of %s should be %s, but is %s", c.spec, now, c.next, next)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * * *",
+		"* * 24 * * *",
+		"* * * 0 * *",
+		"* * * * 13 *",
+		"* * * * * 8",
+		"*/0 * * * * *",
+		"5-1 * * * * *",
+		"a * * * * *",
+		"TZ=Nowhere/Never * * * * * *",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("parse %q should fail", spec)
+		}
+	}
+}
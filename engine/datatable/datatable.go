@@ -0,0 +1,264 @@
+package datatable
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Data tables (items, skills, level exps, etc.) are loaded from CSV, Excel (.xlsx) or JSON files into row structs,
+// and reloaded at runtime without restarting games:
+//
+//	type ItemRow struct {
+//		ID    int    `table:"id"`
+//		Name  string `table:"name"`
+//		Price float64
+//		Tags  []string // written in JSON in CSV and Excel cells
+//	}
+//	items := datatable.Register("items", "data/items.csv", ItemRow{}, "ID")
+//	row, ok := items.Get(1001) // row.(*ItemRow)
+//
+// Table files are watched by all games. When a file is modified, the new version is saved in KVDB and all games
+// reload the table when they are notified of the version, so tables are switched on all games at the same time.
+// Games reload tables by themselves if KVDB is not configured. Tables are parsed in the background and swapped
+// atomically, the current table is kept if the new file fails to load. Rows should never be modified.
+
+const (
+	_DATATABLE_KVDB_KEY_PREFIX = "__datatable__/"
+)
+
+var (
+	tables      = map[string]*Table{}
+	initialized = false
+)
+
+// Table of rows loaded from the table file
+type Table struct {
+	name      string
+	path      string
+	rowType   reflect.Type
+	keyField  int // index of the key field, -1 if rows are not indexed
+	data      atomic.Value
+	version   string    // version of the loaded file
+	modTime   time.Time // modification time of the loaded file
+	loadSeq   int       // sequence of the latest load, results of earlier loads are dropped
+	callbacks []func(t *Table)
+}
+
+type tableData struct {
+	rows  []interface{}
+	index map[interface{}]interface{}
+}
+
+// Register the table and load rows from the file into structs of the type of rowPrototype
+//
+// Rows are indexed by the field keyField for Get, or not indexed if keyField is empty. Should be called on all games
+// before running.
+func Register(name string, path string, rowPrototype interface{}, keyField string) *Table {
+	if _, ok := tables[name]; ok {
+		gwlog.Panicf("datatable.Register: table %s is already registered", name)
+	}
+
+	rowType := reflect.Indirect(reflect.ValueOf(rowPrototype)).Type()
+	if rowType.Kind() != reflect.Struct {
+		gwlog.Panicf("datatable.Register: row of table %s should be struct, but is %s", name, rowType)
+	}
+
+	t := &Table{name: name, path: path, rowType: rowType, keyField: -1}
+	if keyField != "" {
+		field, ok := rowType.FieldByName(keyField)
+		if !ok || len(field.Index) != 1 {
+			gwlog.Panicf("datatable.Register: key field %s of table %s is not found in %s", keyField, name, rowType)
+		}
+		t.keyField = field.Index[0]
+	}
+
+	t.modTime = fileModTime(path)
+	data, err := t.load()
+	if err != nil {
+		gwlog.Panicf("datatable.Register: load table %s from %s failed: %s", name, path, err)
+	}
+	t.data.Store(data)
+	tables[name] = t
+	gwlog.Info("datatable: table %s loaded from %s: %d rows", name, path, len(data.rows))
+	return t
+}
+
+// Get the registered table, returns nil if not found
+func GetTable(name string) *Table {
+	return tables[name]
+}
+
+// Initialize datatable module and start watching table files, called by engine when game is ready
+func Initialize() {
+	if initialized { // games might be ready for multiple times if dispatcher reconnects
+		return
+	}
+
+	initialized = true
+	if isCoordinated() {
+		kvdb.Watch(_DATATABLE_KVDB_KEY_PREFIX, onVersionChanged)
+	}
+	timer.AddTimer(consts.DATATABLE_WATCH_INTERVAL, checkModifiedTables)
+}
+
+// Reload the table on all games, e.g. after table files are deployed to machines of all games
+func Reload(name string) {
+	t := tables[name]
+	if t == nil {
+		gwlog.Error("datatable.Reload: table %s is not registered", name)
+		return
+	}
+	t.requestReload(strconv.FormatInt(time.Now().UnixNano(), 10))
+}
+
+// Tables are reloaded by versions in KVDB if KVDB is configured
+func isCoordinated() bool {
+	return config.GetKVDB().Type != ""
+}
+
+// Get the modification time of table file, zero if the file can not be accessed, e.g. when it is being replaced
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func checkModifiedTables() {
+	for _, t := range tables {
+		modTime := fileModTime(t.path)
+		if modTime.IsZero() || modTime.Equal(t.modTime) {
+			continue
+		}
+		gwlog.Info("datatable: table file %s is modified, reloading table %s ...", t.path, t.name)
+		t.modTime = modTime
+		t.requestReload(strconv.FormatInt(modTime.UnixNano(), 10))
+	}
+}
+
+func (t *Table) requestReload(version string) {
+	if !isCoordinated() {
+		t.reload(version)
+		return
+	}
+
+	kvdb.Put(_DATATABLE_KVDB_KEY_PREFIX+t.name, version, func(err error) {
+		if err != nil {
+			gwlog.TraceError("datatable: save version of table %s failed: %s", t.name, err)
+		}
+	})
+}
+
+func onVersionChanged(key string, version string) {
+	name := strings.TrimPrefix(key, _DATATABLE_KVDB_KEY_PREFIX)
+	if t := tables[name]; t != nil && version != t.version {
+		t.reload(version)
+	}
+}
+
+// Load the table in the background and swap it in the game routine
+func (t *Table) reload(version string) {
+	t.loadSeq += 1
+	seq := t.loadSeq
+	go func() {
+		data, err := t.load()
+		post.Post(func() {
+			if seq != t.loadSeq {
+				return // reloaded again
+			}
+			if err != nil {
+				gwlog.Error("datatable: reload table %s from %s failed, current table is kept: %s", t.name, t.path, err)
+				return
+			}
+
+			t.data.Store(data)
+			t.version = version
+			gwlog.Info("datatable: table %s reloaded from %s: %d rows, version %s", t.name, t.path, len(data.rows), version)
+			for _, cb := range t.callbacks {
+				gwutils.RunPanicless(func() {
+					cb(t)
+				})
+			}
+		})
+	}()
+}
+
+// Load rows from the table file, called in any goroutine
+func (t *Table) load() (*tableData, error) {
+	rows, err := loadRows(t.path, t.rowType)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &tableData{rows: rows}
+	if t.keyField >= 0 {
+		data.index = make(map[interface{}]interface{}, len(rows))
+		for _, row := range rows {
+			key := normalizeKey(reflect.ValueOf(row).Elem().Field(t.keyField).Interface())
+			if _, ok := data.index[key]; ok {
+				gwlog.Warn("datatable: table %s has duplicate key %v, the last row is used", t.name, key)
+			}
+			data.index[key] = row
+		}
+	}
+	return data, nil
+}
+
+// Keys of all integer types are indexed as int64, so rows can be found by keys of any integer type
+func normalizeKey(key interface{}) interface{} {
+	v := reflect.ValueOf(key)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		return v.Float()
+	case reflect.String:
+		return v.String()
+	}
+	return key
+}
+
+func (t *Table) getData() *tableData {
+	return t.data.Load().(*tableData)
+}
+
+// Name of the table
+func (t *Table) Name() string {
+	return t.name
+}
+
+// Get the row of the key, which is a pointer to the row struct
+func (t *Table) Get(key interface{}) (interface{}, bool) {
+	row, ok := t.getData().index[normalizeKey(key)]
+	return row, ok
+}
+
+// Get all rows in the order of the table file
+func (t *Table) Rows() []interface{} {
+	return t.getData().rows
+}
+
+// Get the number of rows
+func (t *Table) Len() int {
+	return len(t.getData().rows)
+}
+
+// Add the callback called in the game routine when the table is reloaded, e.g. to rebuild caches of rows
+func (t *Table) OnReload(cb func(t *Table)) {
+	t.callbacks = append(t.callbacks, cb)
+}
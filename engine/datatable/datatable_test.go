@@ -0,0 +1,80 @@
+package datatable
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type testItemRow struct {
+	ID    int32 `table:"id"`
+	Name  string
+	Price float64
+	Tags  []string
+}
+
+func writeTestTable(t *testing.T, name string, content string) string {
+	dir, err := ioutil.TempDir("", "datatable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRegisterCSV(t *testing.T) {
+	path := writeTestTable(t, "items.csv", "id,Name,Price,Tags,Unknown\n"+
+		"1001,sword,9.5,\"[\"\"weapon\"\"]\",x\n"+
+		",,,,\n"+
+		"1002,shield,,,\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	table := Register("test_items_csv", path, testItemRow{}, "ID")
+	if table.Len() != 2 {
+		t.Fatalf("wrong rows: %v", table.Rows())
+	}
+
+	row, ok := table.Get(1001) // key of int is found for int32 key field
+	if !ok {
+		t.Fatalf("row 1001 not found")
+	}
+	item := row.(*testItemRow)
+	if item.Name != "sword" || item.Price != 9.5 || len(item.Tags) != 1 || item.Tags[0] != "weapon" {
+		t.Errorf("wrong row: %+v", item)
+	}
+
+	if row, ok := table.Get(int64(1002)); !ok || row.(*testItemRow).Price != 0 {
+		t.Errorf("wrong row 1002: %+v", row)
+	}
+	if _, ok := table.Get(1003); ok {
+		t.Errorf("row 1003 should not exist")
+	}
+}
+
+func TestRegisterJSON(t *testing.T) {
+	path := writeTestTable(t, "items.json", `[{"ID": 1, "Name": "potion", "Tags": ["consumable"]}, {"ID": 2, "Name": "elixir"}]`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	table := Register("test_items_json", path, &testItemRow{}, "")
+	if table.Len() != 2 || table.Rows()[1].(*testItemRow).Name != "elixir" {
+		t.Fatalf("wrong rows: %v", table.Rows())
+	}
+	if _, ok := table.Get(1); ok {
+		t.Errorf("rows should not be indexed without key field")
+	}
+}
+
+func TestParseInvalidCell(t *testing.T) {
+	path := writeTestTable(t, "items.csv", "id,Name\nabc,sword\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	table := &Table{name: "test_invalid", path: path, rowType: reflect.TypeOf(testItemRow{}), keyField: -1}
+	if _, err := table.load(); err == nil {
+		t.Errorf("invalid id should fail")
+	}
+}
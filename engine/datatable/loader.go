@@ -0,0 +1,166 @@
+package datatable
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/360EntSecGroup-Skylar/excelize"
+	"github.com/pkg/errors"
+)
+
+const (
+	_FIELD_TAG = "table" // tag of row struct fields for column names, fields without tag use field names
+)
+
+// Load rows of the table file into pointers of new row structs, the format is decided by the file extension
+func loadRows(path string, rowType reflect.Type) ([]interface{}, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return loadCSV(path, rowType)
+	case ".xlsx":
+		return loadExcel(path, rowType)
+	case ".json":
+		return loadJSON(path, rowType)
+	default:
+		return nil, errors.Errorf("unknown format of table file: %s", path)
+	}
+}
+
+// CSV files have column names in the first row
+func loadCSV(path string, rowType reflect.Type) ([]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	return parseRecords(records, rowType)
+}
+
+// Excel files have column names in the first row of the first sheet
+func loadExcel(path string, rowType reflect.Type) ([]interface{}, error) {
+	xlsx, err := excelize.OpenFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseRecords(xlsx.GetRows(xlsx.GetSheetName(1)), rowType)
+}
+
+// JSON files are arrays of objects, which are decoded by encoding/json
+func loadJSON(path string, rowType reflect.Type) ([]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsPtr := reflect.New(reflect.SliceOf(reflect.PtrTo(rowType)))
+	if err := json.Unmarshal(data, rowsPtr.Interface()); err != nil {
+		return nil, err
+	}
+
+	rowsVal := rowsPtr.Elem()
+	rows := make([]interface{}, rowsVal.Len())
+	for i := range rows {
+		rows[i] = rowsVal.Index(i).Interface()
+	}
+	return rows, nil
+}
+
+// Parse records of text cells with column names in the first record, empty records are skipped
+func parseRecords(records [][]string, rowType reflect.Type) ([]interface{}, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	fields := make([]int, len(records[0])) // field index of each column, -1 for columns without fields
+	for col, name := range records[0] {
+		fields[col] = fieldByColumn(rowType, strings.TrimSpace(name))
+	}
+
+	var rows []interface{}
+	for r, record := range records[1:] {
+		if isEmptyRecord(record) {
+			continue
+		}
+
+		row := reflect.New(rowType)
+		for col, cell := range record {
+			if col >= len(fields) || fields[col] < 0 || cell == "" {
+				continue
+			}
+			if err := setField(row.Elem().Field(fields[col]), cell); err != nil {
+				return nil, errors.Wrapf(err, "row %d column %s", r+2, records[0][col])
+			}
+		}
+		rows = append(rows, row.Interface())
+	}
+	return rows, nil
+}
+
+func fieldByColumn(rowType reflect.Type, column string) int {
+	for i := 0; i < rowType.NumField(); i++ {
+		field := rowType.Field(i)
+		if field.PkgPath != "" { // unexported
+			continue
+		}
+		if name := field.Tag.Get(_FIELD_TAG); name == column || (name == "" && field.Name == column) {
+			return i
+		}
+	}
+	return -1
+}
+
+func isEmptyRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Set the field by text of cell, slices, maps and structs are written in JSON
+func setField(field reflect.Value, cell string) error {
+	cell = strings.TrimSpace(cell)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(cell)
+	case reflect.Bool:
+		v, err := strconv.ParseBool(cell)
+		if err != nil {
+			return err
+		}
+		field.SetBool(v)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(cell, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(v)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(cell, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(v)
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(cell, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(v)
+	default:
+		return json.Unmarshal([]byte(cell), field.Addr().Interface())
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"math"
 	"unsafe"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
 )
 
 type Coord float32
@@ -25,9 +27,18 @@ func (p Position) DistanceTo(o Position) Coord {
 	return Coord(math.Sqrt(float64(dx*dx + dy*dy + dz*dz)))
 }
 
+// AOI of entity
+//
+// Each entity is interested in entities within its own AOI distance on both X and Z axis, so visibility can be
+// asymmetric when AOI distances are different: A is interested in B, but B is not interested in A. AOI calculators
+// maintain nearby entities, which are within the larger AOI distance of each pair, and interests of both directions
+// are checked among nearby entities.
 type AOI struct {
 	pos       Position
-	neighbors EntitySet
+	dist      Coord     // AOI distance
+	nearby    EntitySet // entities within the AOI distance of either side, maintained by AOI calculators
+	neighbors EntitySet // entities interested by this entity, which are created on its client
+	watchers  EntitySet // entities interested in this entity, whose clients are notified of its changes
 	xNext     *AOI
 	xPrev     *AOI
 	zNext     *AOI
@@ -35,8 +46,40 @@ type AOI struct {
 	markVal   int
 }
 
+var (
+	defaultAoiDistance Coord = DEFAULT_AOI_DISTANCE
+)
+
+// Set the default AOI distance of entities, DEFAULT_AOI_DISTANCE is used if dist is 0
+//
+// The default AOI distance can be changed at runtime: entities whose AOI distances equal the old default are changed to
+// the new default, while entities with other distances set by SetAoiDistance are not changed.
+func SetDefaultAoiDistance(dist Coord) {
+	if dist == 0 {
+		dist = DEFAULT_AOI_DISTANCE
+	}
+	if dist < 0 {
+		gwlog.Panicf("SetDefaultAoiDistance: invalid AOI distance %v", dist)
+	}
+	if dist == defaultAoiDistance {
+		return
+	}
+
+	old := defaultAoiDistance
+	defaultAoiDistance = dist
+	gwlog.Info("Default AOI distance set to %v", dist)
+	for _, e := range entityManager.entities {
+		if e.aoi.dist == old {
+			e.SetAoiDistance(dist)
+		}
+	}
+}
+
 func initAOI(aoi *AOI) {
+	aoi.dist = defaultAoiDistance
+	aoi.nearby = EntitySet{}
 	aoi.neighbors = EntitySet{}
+	aoi.watchers = EntitySet{}
 }
 
 // Get the owner entity of this AOI
@@ -53,10 +96,52 @@ func (aoi *AOI) getEntity() *Entity {
 
 func (aoi *AOI) interest(other *Entity) {
 	aoi.neighbors.Add(other)
+	other.aoi.watchers.Add(aoi.getEntity())
 }
 
 func (aoi *AOI) uninterest(other *Entity) {
 	aoi.neighbors.Del(other)
+	other.aoi.watchers.Del(aoi.getEntity())
+}
+
+// Check if the other AOI is within AOI distance of this one
+func (aoi *AOI) covers(other *AOI) bool {
+	dx := aoi.pos.X - other.pos.X
+	dz := aoi.pos.Z - other.pos.Z
+	return dx >= -aoi.dist && dx <= aoi.dist && dz >= -aoi.dist && dz <= aoi.dist
+}
+
+// AOI distances of entities in AOI calculator, for calculating the range of searching nearby entities
+type aoiDistances struct {
+	counts map[Coord]int
+	max    Coord
+}
+
+func (ad *aoiDistances) add(dist Coord) {
+	if ad.counts == nil {
+		ad.counts = map[Coord]int{}
+	}
+	ad.counts[dist] += 1
+	if dist > ad.max {
+		ad.max = dist
+	}
+}
+
+func (ad *aoiDistances) remove(dist Coord) {
+	ad.counts[dist] -= 1
+	if ad.counts[dist] > 0 {
+		return
+	}
+
+	delete(ad.counts, dist)
+	if dist == ad.max {
+		ad.max = 0
+		for d := range ad.counts {
+			if d > ad.max {
+				ad.max = d
+			}
+		}
+	}
 }
 
 //func (sl *xAOIList) coord(aoi *AOI) Coord {
@@ -1,15 +1,46 @@
 package entity
 
+import (
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// AOI backends, all backends calculate the same nearby entities: entities within the larger AOI distance of each pair
+// on both X and Z axis
+const (
+	AOI_BACKEND_XZLIST      = "xzlist"     // sweep lists on X and Z axis, default
+	AOI_BACKEND_BRUTE_FORCE = "bruteforce" // check all entities, fast for spaces with few entities
+	AOI_BACKEND_GRID        = "grid"       // grid cells of AOI distance, fast for crowded spaces
+)
+
 type AOICalculator interface {
 	Enter(aoi *AOI, pos Position)
 	Leave(aoi *AOI)
 	Move(aoi *AOI, newPos Position)
+	SetDistance(aoi *AOI, dist Coord)
+	// Calculate nearby entities of aoi, returns the entered and left ones compared to aoi.nearby
 	Adjust(aoi *AOI) (enter []*AOI, leave []*AOI)
+	// Visit all AOIs in the rect on X and Z axis, including the borders
+	VisitRect(minX, maxX, minZ, maxZ Coord, visit func(aoi *AOI))
+}
+
+func newAOICalculator(backend string) (AOICalculator, error) {
+	if backend == AOI_BACKEND_XZLIST {
+		return newXZListAOICalculator(), nil
+	} else if backend == AOI_BACKEND_BRUTE_FORCE {
+		return newBruteForceAOICalculator(), nil
+	} else if backend == AOI_BACKEND_GRID {
+		return newGridAOICalculator(), nil
+	} else {
+		return nil, errors.Errorf("unknown AOI backend: %s", backend)
+	}
 }
 
 type XZListAOICalculator struct {
 	xSweepList *xAOIList
 	zSweepList *zAOIList
+	distances  aoiDistances
 }
 
 func newXZListAOICalculator() *XZListAOICalculator {
@@ -21,6 +52,7 @@ func newXZListAOICalculator() *XZListAOICalculator {
 
 func (cal *XZListAOICalculator) Enter(aoi *AOI, pos Position) {
 	aoi.pos = pos
+	cal.distances.add(aoi.dist)
 	cal.xSweepList.Insert(aoi)
 	cal.zSweepList.Insert(aoi)
 
@@ -35,6 +67,13 @@ func (cal *XZListAOICalculator) Enter(aoi *AOI, pos Position) {
 func (cal *XZListAOICalculator) Leave(aoi *AOI) {
 	cal.xSweepList.Remove(aoi)
 	cal.zSweepList.Remove(aoi)
+	cal.distances.remove(aoi.dist)
+}
+
+func (cal *XZListAOICalculator) SetDistance(aoi *AOI, dist Coord) {
+	cal.distances.remove(aoi.dist)
+	aoi.dist = dist
+	cal.distances.add(dist)
 }
 
 func (cal *XZListAOICalculator) Move(aoi *AOI, pos Position) {
@@ -49,30 +88,54 @@ func (cal *XZListAOICalculator) Move(aoi *AOI, pos Position) {
 }
 
 func (cal *XZListAOICalculator) Adjust(aoi *AOI) (enter []*AOI, leave []*AOI) {
-	cal.xSweepList.Mark(aoi)
-	cal.zSweepList.Mark(aoi)
-	// aoi marked twice are neighbors
-	for neighbor := range aoi.neighbors {
+	// sweep in the max AOI distance, and check the AOI distance of each pair later
+	dist := cal.distances.max
+	cal.xSweepList.Mark(aoi, dist)
+	cal.zSweepList.Mark(aoi, dist)
+	// aoi marked twice are in range
+	for neighbor := range aoi.nearby {
 		naoi := &neighbor.aoi
-		if naoi.markVal == 2 {
+		if naoi.markVal == 2 && isAOINearby(aoi, naoi) {
 			// neighbors kept
 			naoi.markVal = -2 // mark this as neighbor
-		} else { // markVal < 2
+		} else {
 			// was neighbor, but not any more
 			leave = append(leave, naoi)
 		}
 	}
 
 	// travel in X list again to find all new neighbors, whose markVal == 2
-	enter = cal.xSweepList.GetClearMarkedNeighbors(aoi)
+	for _, naoi := range cal.xSweepList.GetClearMarkedNeighbors(aoi, dist) {
+		if isAOINearby(aoi, naoi) {
+			enter = append(enter, naoi)
+		}
+	}
 	// travel in Z list again to unmark all
-	cal.zSweepList.ClearMark(aoi)
+	cal.zSweepList.ClearMark(aoi, dist)
 
 	// now all marked neighbors are cleared
 	// travel in neighbors
 	return
 }
 
+func (cal *XZListAOICalculator) VisitRect(minX, maxX, minZ, maxZ Coord, visit func(aoi *AOI)) {
+	// travel the X list from the nearer end of the rect
+	xlist := cal.xSweepList
+	if xlist.head == nil || xlist.tail.pos.X-maxX < minX-xlist.head.pos.X {
+		for aoi := xlist.tail; aoi != nil && aoi.pos.X >= minX; aoi = aoi.xPrev {
+			if isAOIInRect(aoi, minX, maxX, minZ, maxZ) {
+				visit(aoi)
+			}
+		}
+	} else {
+		for aoi := xlist.head; aoi != nil && aoi.pos.X <= maxX; aoi = aoi.xNext {
+			if isAOIInRect(aoi, minX, maxX, minZ, maxZ) {
+				visit(aoi)
+			}
+		}
+	}
+}
+
 type aoiListOperator interface {
 	GetCoord(aoi *AOI) Coord
 	//SetCoord(aoi *AOI) Coord
@@ -81,3 +144,193 @@ type aoiListOperator interface {
 	GetPrev(aoi *AOI) *AOI
 	SetPrev(aoi *AOI, prev *AOI)
 }
+
+func isAOIInRect(aoi *AOI, minX, maxX, minZ, maxZ Coord) bool {
+	return aoi.pos.X >= minX && aoi.pos.X <= maxX && aoi.pos.Z >= minZ && aoi.pos.Z <= maxZ
+}
+
+// Check if two AOIs are nearby, i.e. either is within AOI distance of the other
+func isAOINearby(aoi *AOI, other *AOI) bool {
+	return aoi.covers(other) || other.covers(aoi)
+}
+
+// Adjust neighbors of aoi by checking candidates, for calculators without sweep lists
+func adjustAOIWithCandidates(aoi *AOI, forEachCandidate func(visit func(other *AOI))) (enter []*AOI, leave []*AOI) {
+	forEachCandidate(func(other *AOI) {
+		if other == aoi || !isAOINearby(aoi, other) {
+			return
+		}
+		other.markVal = 1
+		if !aoi.nearby.Contains(other.getEntity()) {
+			enter = append(enter, other)
+		}
+	})
+
+	for neighbor := range aoi.nearby {
+		naoi := &neighbor.aoi
+		if naoi.markVal == 0 {
+			leave = append(leave, naoi)
+		}
+	}
+
+	forEachCandidate(func(other *AOI) {
+		other.markVal = 0
+	})
+	return
+}
+
+type BruteForceAOICalculator struct {
+	aois AOISet
+}
+
+func newBruteForceAOICalculator() *BruteForceAOICalculator {
+	return &BruteForceAOICalculator{
+		aois: AOISet{},
+	}
+}
+
+func (cal *BruteForceAOICalculator) Enter(aoi *AOI, pos Position) {
+	aoi.pos = pos
+	cal.aois.Add(aoi)
+}
+
+func (cal *BruteForceAOICalculator) Leave(aoi *AOI) {
+	cal.aois.Del(aoi)
+}
+
+func (cal *BruteForceAOICalculator) Move(aoi *AOI, pos Position) {
+	aoi.pos = pos
+}
+
+func (cal *BruteForceAOICalculator) SetDistance(aoi *AOI, dist Coord) {
+	aoi.dist = dist
+}
+
+func (cal *BruteForceAOICalculator) VisitRect(minX, maxX, minZ, maxZ Coord, visit func(aoi *AOI)) {
+	for aoi := range cal.aois {
+		if isAOIInRect(aoi, minX, maxX, minZ, maxZ) {
+			visit(aoi)
+		}
+	}
+}
+
+func (cal *BruteForceAOICalculator) Adjust(aoi *AOI) (enter []*AOI, leave []*AOI) {
+	return adjustAOIWithCandidates(aoi, func(visit func(other *AOI)) {
+		for other := range cal.aois {
+			visit(other)
+		}
+	})
+}
+
+type aoiGridKey struct {
+	x, z int
+}
+
+type GridAOICalculator struct {
+	cells     map[aoiGridKey]AOISet
+	distances aoiDistances
+}
+
+func newGridAOICalculator() *GridAOICalculator {
+	return &GridAOICalculator{
+		cells: map[aoiGridKey]AOISet{},
+	}
+}
+
+// Cell size is the default AOI distance, so that neighbors are in the 3x3 cells around if all entities use the default
+func (cal *GridAOICalculator) cellOf(pos Position) aoiGridKey {
+	return aoiGridKey{
+		x: int(math.Floor(float64(pos.X / DEFAULT_AOI_DISTANCE))),
+		z: int(math.Floor(float64(pos.Z / DEFAULT_AOI_DISTANCE))),
+	}
+}
+
+func (cal *GridAOICalculator) addToCell(key aoiGridKey, aoi *AOI) {
+	cell := cal.cells[key]
+	if cell == nil {
+		cell = AOISet{}
+		cal.cells[key] = cell
+	}
+	cell.Add(aoi)
+}
+
+func (cal *GridAOICalculator) delFromCell(key aoiGridKey, aoi *AOI) {
+	cell := cal.cells[key]
+	cell.Del(aoi)
+	if len(cell) == 0 {
+		delete(cal.cells, key)
+	}
+}
+
+func (cal *GridAOICalculator) Enter(aoi *AOI, pos Position) {
+	aoi.pos = pos
+	cal.addToCell(cal.cellOf(pos), aoi)
+	cal.distances.add(aoi.dist)
+}
+
+func (cal *GridAOICalculator) Leave(aoi *AOI) {
+	cal.delFromCell(cal.cellOf(aoi.pos), aoi)
+	cal.distances.remove(aoi.dist)
+}
+
+func (cal *GridAOICalculator) SetDistance(aoi *AOI, dist Coord) {
+	cal.distances.remove(aoi.dist)
+	aoi.dist = dist
+	cal.distances.add(dist)
+}
+
+func (cal *GridAOICalculator) Move(aoi *AOI, pos Position) {
+	oldKey := cal.cellOf(aoi.pos)
+	aoi.pos = pos
+	newKey := cal.cellOf(pos)
+	if oldKey != newKey {
+		cal.delFromCell(oldKey, aoi)
+		cal.addToCell(newKey, aoi)
+	}
+}
+
+func (cal *GridAOICalculator) Adjust(aoi *AOI) (enter []*AOI, leave []*AOI) {
+	key := cal.cellOf(aoi.pos)
+	// cells around within the max AOI distance
+	r := int(math.Ceil(float64(cal.distances.max / DEFAULT_AOI_DISTANCE)))
+	if r < 1 {
+		r = 1
+	}
+	return adjustAOIWithCandidates(aoi, func(visit func(other *AOI)) {
+		for x := key.x - r; x <= key.x+r; x++ {
+			for z := key.z - r; z <= key.z+r; z++ {
+				for other := range cal.cells[aoiGridKey{x, z}] {
+					visit(other)
+				}
+			}
+		}
+	})
+}
+
+func (cal *GridAOICalculator) VisitRect(minX, maxX, minZ, maxZ Coord, visit func(aoi *AOI)) {
+	minKey := cal.cellOf(Position{X: minX, Z: minZ})
+	maxKey := cal.cellOf(Position{X: maxX, Z: maxZ})
+	visitCell := func(cell AOISet) {
+		for aoi := range cell {
+			if isAOIInRect(aoi, minX, maxX, minZ, maxZ) {
+				visit(aoi)
+			}
+		}
+	}
+
+	if (maxKey.x-minKey.x+1)*(maxKey.z-minKey.z+1) > len(cal.cells) {
+		// the rect is larger than the occupied area, travel occupied cells only
+		for key, cell := range cal.cells {
+			if key.x >= minKey.x && key.x <= maxKey.x && key.z >= minKey.z && key.z <= maxKey.z {
+				visitCell(cell)
+			}
+		}
+		return
+	}
+
+	for x := minKey.x; x <= maxKey.x; x++ {
+		for z := minKey.z; z <= maxKey.z; z++ {
+			visitCell(cal.cells[aoiGridKey{x, z}])
+		}
+	}
+}
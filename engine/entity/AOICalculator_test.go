@@ -3,8 +3,13 @@ package entity
 import (
 	"math"
 	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
 	"testing"
 	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
 )
 
 func init() {
@@ -70,7 +75,7 @@ func TestXAOIList_Move(t *testing.T) {
 	}
 }
 
-func TestXAOIList_Interested(t *testing.T) {
+func TestXAOIList_GetClearMarkedNeighbors(t *testing.T) {
 	for i := 0; i < 1000; i++ {
 		aois := []*AOI{}
 		list := newXAOIList()
@@ -85,19 +90,27 @@ func TestXAOIList_Interested(t *testing.T) {
 
 		for r := 0; r < 10; r++ {
 			aoi := aois[rand.Intn(len(aois))]
-			interested := list.Interested(aoi)
-			for other := range interested {
-				if math.Abs(float64(aoi.pos.X-other.pos.X)) > DEFAULT_AOI_DISTANCE {
+			dist := Coord(rand.Intn(50))
+			// neighbors are marked twice by both sweep lists in AOI calculators
+			list.Mark(aoi, dist)
+			list.Mark(aoi, dist)
+			interested := map[*AOI]bool{}
+			for _, other := range list.GetClearMarkedNeighbors(aoi, dist) {
+				interested[other] = true
+				if math.Abs(float64(aoi.pos.X-other.pos.X)) > float64(dist) {
 					t.Errorf("should not interest")
 				}
 			}
 			for _, other := range aois {
-				if other == aoi || interested.Contains(other) {
+				if other.markVal != 0 {
+					t.Errorf("mark is not cleared")
+				}
+				if other == aoi || interested[other] {
 					continue
 				}
 
-				if math.Abs(float64(aoi.pos.X-other.pos.X)) <= DEFAULT_AOI_DISTANCE {
-					t.Errorf("should not interest")
+				if math.Abs(float64(aoi.pos.X-other.pos.X)) <= float64(dist) {
+					t.Errorf("should interest")
 				}
 			}
 		}
@@ -162,3 +175,154 @@ func checkList(t *testing.T, list *xAOIList, N int) {
 		t.Errorf("unexpected not nil ")
 	}
 }
+
+var testAOIBackends = []string{AOI_BACKEND_XZLIST, AOI_BACKEND_BRUTE_FORCE, AOI_BACKEND_GRID}
+
+func TestAOIBackends(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		seed := rand.Int63()
+		var expected []map[common.EntityID][]common.EntityID
+		for _, backend := range testAOIBackends {
+			interests := runRandomAOIOperations(t, newTestAOISpace(t, backend), rand.New(rand.NewSource(seed)))
+			if expected == nil {
+				expected = interests
+			} else if !reflect.DeepEqual(interests, expected) {
+				t.Fatalf("backend %s calculates different interests from %s", backend, testAOIBackends[0])
+			}
+		}
+	}
+}
+
+func TestSpace_SetAOIBackend(t *testing.T) {
+	r := rand.New(rand.NewSource(rand.Int63()))
+	space := newTestAOISpace(t, AOI_BACKEND_XZLIST)
+	for i := 0; i < 200; i++ {
+		testAOIEnter(space, i, randTestAOIPos(r), randTestAOIDistance(r))
+	}
+	checkAOIInterests(t, space)
+	interests := getAOIInterests(space)
+
+	for _, backend := range []string{AOI_BACKEND_GRID, AOI_BACKEND_BRUTE_FORCE, AOI_BACKEND_XZLIST, AOI_BACKEND_GRID} {
+		if err := space.SetAOIBackend(backend); err != nil {
+			t.Fatal(err)
+		}
+		if space.GetAOIBackend() != backend {
+			t.Fatalf("AOI backend should be %s, but is %s", backend, space.GetAOIBackend())
+		}
+		if !reflect.DeepEqual(getAOIInterests(space), interests) {
+			t.Fatalf("interests changed after switching to %s", backend)
+		}
+	}
+
+	if err := space.SetAOIBackend("unknown"); err == nil || space.GetAOIBackend() != AOI_BACKEND_GRID {
+		t.Errorf("switching to unknown backend should fail")
+	}
+
+	// the new backend keeps working
+	for e := range space.entities {
+		space.move(e, randTestAOIPos(r))
+	}
+	checkAOIInterests(t, space)
+}
+
+// Create a space with AOI only, entities enter and leave without clients and callbacks of space
+func newTestAOISpace(t *testing.T, backend string) *Space {
+	aoiCalc, err := newAOICalculator(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Space{entities: EntitySet{}, Kind: 1, aoiCalc: aoiCalc, aoiBackend: backend}
+}
+
+func testAOIEnter(space *Space, id int, pos Position, dist Coord) *Entity {
+	e := &Entity{ID: common.EntityID(strconv.Itoa(id)), Space: space}
+	initAOI(&e.aoi)
+	e.aoi.dist = dist
+	space.entities.Add(e)
+	space.aoiCalc.Enter(&e.aoi, pos)
+	space.adjustAOI(e, false)
+	return e
+}
+
+func testAOILeave(space *Space, e *Entity) {
+	space.removeFromAOI(e)
+	space.entities.Del(e)
+}
+
+// Positions on a grid of 10, so that distances of entities are often equal to AOI distances
+func randTestAOIPos(r *rand.Rand) Position {
+	return Position{X: Coord(r.Intn(60)*10 - 300), Y: Coord(r.Intn(10)), Z: Coord(r.Intn(60)*10 - 300)}
+}
+
+func randTestAOIDistance(r *rand.Rand) Coord {
+	return []Coord{DEFAULT_AOI_DISTANCE, DEFAULT_AOI_DISTANCE, 20, 50, 250}[r.Intn(5)]
+}
+
+// Enter, move, leave entities and change AOI distances randomly, returns interests after each operation
+func runRandomAOIOperations(t *testing.T, space *Space, r *rand.Rand) (interests []map[common.EntityID][]common.EntityID) {
+	var entities []*Entity
+	for i := 0; i < 100; i++ {
+		switch op := r.Intn(10); {
+		case op < 3 || len(entities) == 0:
+			entities = append(entities, testAOIEnter(space, i, randTestAOIPos(r), randTestAOIDistance(r)))
+		case op < 7:
+			e := entities[r.Intn(len(entities))]
+			pos := e.aoi.pos
+			pos.X += Coord(r.Intn(11)*10 - 50)
+			pos.Z += Coord(r.Intn(11)*10 - 50)
+			space.move(e, pos)
+		case op < 9:
+			entities[r.Intn(len(entities))].SetAoiDistance(randTestAOIDistance(r))
+		default:
+			j := r.Intn(len(entities))
+			testAOILeave(space, entities[j])
+			entities = append(entities[:j], entities[j+1:]...)
+		}
+		checkAOIInterests(t, space)
+		interests = append(interests, getAOIInterests(space))
+	}
+	return
+}
+
+// Check interests of entities in space by their AOI distances
+func checkAOIInterests(t *testing.T, space *Space) {
+	for e := range space.entities {
+		neighbors, watchers := EntitySet{}, EntitySet{}
+		for other := range space.entities {
+			if other == e {
+				continue
+			}
+			if e.aoi.covers(&other.aoi) {
+				neighbors.Add(other)
+			}
+			if other.aoi.covers(&e.aoi) {
+				watchers.Add(other)
+			}
+		}
+		if !reflect.DeepEqual(sortedEntityIDs(e.aoi.neighbors), sortedEntityIDs(neighbors)) {
+			t.Fatalf("%s: wrong neighbors %v, should be %v", space.aoiBackend, e.aoi.neighbors, neighbors)
+		}
+		if !reflect.DeepEqual(sortedEntityIDs(e.aoi.watchers), sortedEntityIDs(watchers)) {
+			t.Fatalf("%s: wrong watchers %v, should be %v", space.aoiBackend, e.aoi.watchers, watchers)
+		}
+	}
+}
+
+func getAOIInterests(space *Space) map[common.EntityID][]common.EntityID {
+	interests := map[common.EntityID][]common.EntityID{}
+	for e := range space.entities {
+		interests[e.ID] = sortedEntityIDs(e.aoi.neighbors)
+	}
+	return interests
+}
+
+func sortedEntityIDs(entities EntitySet) []common.EntityID {
+	ids := []common.EntityID{}
+	for e := range entities {
+		ids = append(ids, e.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	return ids
+}
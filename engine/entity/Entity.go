@@ -1,7 +1,9 @@
 package entity
 
 import (
+	"encoding/json"
 	"fmt"
+	"math/rand"
 	"reflect"
 
 	"time"
@@ -11,15 +13,20 @@ import (
 
 	"unsafe"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/analytics"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwrand"
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/tracing"
 	"github.com/xiaonanln/typeconv"
 )
 
@@ -35,7 +42,10 @@ type entityTimerInfo struct {
 	Method         string
 	Args           []interface{}
 	Repeat         bool
+	Cron           string // cron spec of cron timers, fire times are computed from it instead of RepeatInterval
 	rawTimer       *timer.Timer
+	deferred       bool              // deferred by CPU budget or execution group
+	cronSchedule   *crontab.Schedule // parsed from Cron, nil after the timer is restored
 }
 
 type Entity struct {
@@ -45,6 +55,7 @@ type Entity struct {
 	IV       reflect.Value
 
 	destroyed bool
+	isGhost   bool // ghost of entity in stitched space
 	typeDesc  *EntityTypeDesc
 	Space     *Space
 	aoi       AOI
@@ -53,10 +64,17 @@ type Entity struct {
 	rawTimers   map[*timer.Timer]struct{}
 	timers      map[EntityTimerID]*entityTimerInfo
 	lastTimerId EntityTimerID
+	cpuUsage    entityCPUUsage
+	execGroup   string       // execution group overriding the group of entity type
+	components  []IComponent // components of entity type in order
+
+	saveTimer         *timer.Timer
+	saveTimerInterval time.Duration // save interval when the save timer is setup
 
 	client           *GameClient
 	declaredServices StringSet
 	becamePlayer     bool
+	tags             StringSet // tags indexed by entity manager, nil if no tags
 
 	Attrs *MapAttr
 
@@ -69,6 +87,31 @@ type Entity struct {
 	filterProps map[string]string
 
 	syncInfoFlag syncInfoFlag
+	clientAudit  *clientAuditTrail
+	history      *entityHistory // attr mutations and RPCs for debugging, nil if not enabled
+
+	attrRateTrackers map[string]*attrRateTracker
+	rpcRateTrackers  map[rpcRateKey]*rpcRateTracker
+	calendarHandles  []calendarHandle
+
+	attrWatchers        map[string][]*attrWatcher
+	lastAttrWatchHandle AttrWatchHandle
+	attrsLoaded         bool // attr watchers are not notified when loading attrs
+
+	randStreams map[string]*gwrand.Stream
+	pathMove    *entityPathMove // nil if not moving along path
+
+	allClientDataCache []byte                      // packed all-client attrs for observers, nil if invalidated by attr changes
+	snapshotCopies     map[string]attrSnapshotCopy // copies of nested attrs shared by attr snapshots, nil if never snapshotted
+
+	dirtyAttrs      StringSet // persistent attributes changed since last save, nil if partial save is disabled
+	fullSaveNeeded  bool
+	storageRevision int64     // revision of the last saved (or loaded) data, see storage.IsRevisionCheckEnabled
+	duplicate       bool      // the entity is already on (or saved by) another game, never saved
+	lastActiveTime  time.Time // last time of RPC, timer or client change, only if the type has idle timeout
+
+	replica        *entityReplicaState   // nil if not replicated to standby games
+	freezeSnapshot *entityFreezeSnapshot // nil if not captured by incremental freeze
 }
 
 type syncInfoFlag int
@@ -103,34 +146,59 @@ type IEntity interface {
 	OnClientDisconnected() // Called when client disconnected
 }
 
+// Optional interface for entities to handle client kicks
+type IClientKickHandler interface {
+	OnClientKicked(reason proto.KickReason, message string) // Called just before the client is kicked
+}
+
 func (e *Entity) String() string {
 	return fmt.Sprintf("%s<%s>", e.TypeName, e.ID)
 }
 
+// Get the logger of the entity, logs of which have entityID and entityType fields
+func (e *Entity) Logger() *gwlog.Logger {
+	return gwlog.Module("entity").WithFields(gwlog.Fields{"entityID": e.ID, "entityType": e.TypeName})
+}
+
 func (e *Entity) Destroy() {
+	e.destroyWithSaveCallback(nil)
+}
+
+// destroy the entity and save it with callback, returns false if the entity is not saved and the callback is never called
+func (e *Entity) destroyWithSaveCallback(callback storage.SaveCallbackFunc) bool {
 	if e.destroyed {
-		return
+		return false
 	}
 	gwlog.Debug("%s.Destroy ...", e)
-	e.destroyEntity(false)
-	dispatcher_client.GetDispatcherClientForSend().SendNotifyDestroyEntity(e.ID)
+	saved := e.destroyEntity(false, callback)
+	notifyDestroyEntity(e.TypeName, e.ID)
+	return saved
 }
 
-func (e *Entity) destroyEntity(isMigrate bool) {
+func (e *Entity) destroyEntity(isMigrate bool, saveCallback storage.SaveCallbackFunc) (saved bool) {
 	e.Space.leave(e)
 
+	e.emitAnalytics(analytics.EVENT_ENTITY_DESTROYED, "migrate", isMigrate)
 	if !isMigrate {
 		gwutils.RunPanicless(e.I.OnDestroy)
+		e.callComponentHooks(IComponent.OnDestroy)
 	} else {
 		gwutils.RunPanicless(e.I.OnMigrateOut)
+		e.callComponentHooks(IComponent.OnMigrateOut)
 	}
 
 	e.clearRawTimers()
 	e.rawTimers = nil // prohibit further use
+	e.clearTags()
+	e.unsubscribeCalendarEvents()
+	e.stopReplication()
 
 	if !isMigrate {
 		e.SetClient(nil) // always set client to nil before destroy
-		e.Save()
+		saved = e.save(saveCallback)
+		e.onMailReceiverDestroyed()
+		forgetMigrateData(e.ID)
+		entityManager.onEntityDestroyed(e.ID)
 	} else {
 		if e.client != nil {
 			entityManager.onEntityLoseClient(e.client.clientid)
@@ -140,6 +208,7 @@ func (e *Entity) destroyEntity(isMigrate bool) {
 
 	entityManager.del(e.ID)
 	e.destroyed = true
+	return
 }
 
 func (e *Entity) IsDestroyed() bool {
@@ -147,17 +216,32 @@ func (e *Entity) IsDestroyed() bool {
 }
 
 func (e *Entity) Save() {
-	if !e.I.IsPersistent() {
-		return
+	e.save(nil)
+}
+
+// save the entity with callback, returns false if the entity is not saved and the callback is never called
+func (e *Entity) save(callback storage.SaveCallbackFunc) bool {
+	if !e.isSavable() {
+		return false
 	}
 
 	if consts.DEBUG_SAVE_LOAD {
 		gwlog.Debug("SAVING %s ...", e)
 	}
 
-	data := e.I.GetPersistentData()
+	e.savePersistentData(callback)
+	return true
+}
+
+func (e *Entity) isSavable() bool {
+	if !e.I.IsPersistent() || e.duplicate {
+		return false
+	}
 
-	storage.Save(e.TypeName, e.ID, data, nil)
+	if e.Space != nil && e.Space.IsReplaying() { // replayed entities are local copies, never save them
+		return false
+	}
+	return true
 }
 
 func (e *Entity) IsSpaceEntity() bool {
@@ -185,24 +269,76 @@ func (e *Entity) init(typeName string, entityID EntityID, entityInstance reflect
 	e.timers = map[EntityTimerID]*entityTimerInfo{}
 	e.declaredServices = StringSet{}
 	e.filterProps = map[string]string{}
+	if e.typeDesc.clientAuditSize > 0 {
+		e.clientAudit = newClientAuditTrail(e.typeDesc.clientAuditSize)
+	}
+	if e.typeDesc.partialSave {
+		e.dirtyAttrs = StringSet{}
+		e.fullSaveNeeded = true
+	}
 
 	attrs := NewMapAttr()
 	attrs.owner = e
 	e.Attrs = attrs
 
+	e.markActive()
+
 	initAOI(&e.aoi)
 	gwutils.RunPanicless(e.I.OnInit)
+	e.initComponents()
 }
 
 func (e *Entity) setupSaveTimer() {
-	e.addRawTimer(saveInterval, e.Save)
+	interval := e.typeDesc.getSaveInterval()
+	e.saveTimerInterval = interval
+	if jitter := e.typeDesc.saveJitter; jitter > 0 {
+		// the first save is delayed randomly, so that saves of entities created or loaded together are spread
+		e.saveTimer = e.addRawCallback(interval+time.Duration(rand.Int63n(int64(jitter))), func() {
+			e.saveTimer = e.addRawTimer(interval, e.onSaveTimer)
+			e.onSaveTimer()
+		})
+		return
+	}
+	e.saveTimer = e.addRawTimer(interval, e.onSaveTimer)
 }
 
+func (e *Entity) onSaveTimer() {
+	if e.saveTimerInterval != e.typeDesc.getSaveInterval() {
+		// save interval is changed at runtime, save timers are rescheduled when they fire so that saves are spread
+		e.cancelRawTimer(e.saveTimer)
+		e.setupSaveTimer()
+	}
+	e.Save()
+	e.captureOnSave()
+}
+
+// Set the save interval of persistent entities, which can be changed at runtime
 func SetSaveInterval(duration time.Duration) {
+	if duration == saveInterval {
+		return
+	}
 	saveInterval = duration
 	gwlog.Info("Save interval set to %s", saveInterval)
 }
 
+// Set the save interval of entities of this type, which overrides the save interval set by SetSaveInterval
+func (desc *EntityTypeDesc) SetSaveInterval(duration time.Duration) {
+	desc.saveInterval = duration
+}
+
+// Delay the first save of each entity of this type randomly by up to jitter, so that saves of many entities created
+// or loaded at the same time (e.g. after restart) are not aligned on the same tick
+func (desc *EntityTypeDesc) SetSaveJitter(jitter time.Duration) {
+	desc.saveJitter = jitter
+}
+
+func (desc *EntityTypeDesc) getSaveInterval() time.Duration {
+	if desc.saveInterval > 0 {
+		return desc.saveInterval
+	}
+	return saveInterval
+}
+
 // Space Operations related to e
 
 // Interests and Uninterest among entities
@@ -216,10 +352,41 @@ func (e *Entity) uninterest(other *Entity) {
 	e.client.SendDestroyEntity(other)
 }
 
+// Get entities within AOI distance of this entity, which are created on the client of this entity
 func (e *Entity) Neighbors() EntitySet {
 	return e.aoi.neighbors
 }
 
+// Get entities which have this entity as neighbor, they are not always neighbors of this entity if AOI distances are
+// different
+func (e *Entity) Watchers() EntitySet {
+	return e.aoi.watchers
+}
+
+// Set the AOI distance of entity, which is the default AOI distance set by SetDefaultAoiDistance by default
+//
+// The entity sees others within its own AOI distance on both X and Z axis, regardless of AOI distances of others,
+// so visibility of two entities can be asymmetric. AOI distance is not persistent and not migrated with entity, so
+// it should be set again in OnMigrateIn or OnRestored if necessary.
+func (e *Entity) SetAoiDistance(dist Coord) {
+	if dist <= 0 {
+		gwlog.Panicf("%s.SetAoiDistance: invalid AOI distance %v", e, dist)
+	}
+
+	if e.Space == nil || e.Space.IsNil() {
+		e.aoi.dist = dist
+		return
+	}
+
+	e.Space.aoiCalc.SetDistance(&e.aoi, dist)
+	e.Space.adjustAOI(e, true)
+}
+
+// Get the AOI distance of entity
+func (e *Entity) GetAoiDistance() Coord {
+	return e.aoi.dist
+}
+
 // Timer & Callback Management
 type EntityTimerID int
 
@@ -277,17 +444,31 @@ func (e *Entity) CancelTimer(tid EntityTimerID) {
 
 func (e *Entity) triggerTimer(tid EntityTimerID, isRepeat bool) {
 	timerInfo := e.timers[tid] // should never be nil
+	e.markActive()
 	if !timerInfo.Repeat {
+		if e.deferTimerByCPUBudget(tid, timerInfo) {
+			return
+		}
 		delete(e.timers, tid)
 	} else {
-		if !isRepeat {
-			timerInfo.rawTimer = e.addRawTimer(timerInfo.RepeatInterval, func() {
-				e.triggerTimer(tid, true)
-			})
+		if timerInfo.Cron != "" {
+			if !e.scheduleCronTimer(tid, timerInfo) {
+				delete(e.timers, tid) // fire for the last time
+			}
+		} else {
+			if !isRepeat {
+				timerInfo.rawTimer = e.addRawTimer(timerInfo.RepeatInterval, func() {
+					e.triggerTimer(tid, true)
+				})
+			}
+
+			now := time.Now()
+			timerInfo.FireTime = now.Add(timerInfo.RepeatInterval)
 		}
 
-		now := time.Now()
-		timerInfo.FireTime = now.Add(timerInfo.RepeatInterval)
+		if loadShedding.shouldPauseTimer(e) || e.deferTimerByCPUBudget(tid, timerInfo) {
+			return
+		}
 	}
 
 	e.onCallFromLocal(timerInfo.Method, timerInfo.Args)
@@ -306,11 +487,21 @@ func (e *Entity) dumpTimers() []byte {
 		return nil
 	}
 
+	data := e.packTimers()
+	e.timers = nil // no more AddCallback or AddTimer
+	return data
+}
+
+// pack timers without stopping them, for snapshots of running entities
+func (e *Entity) packTimers() []byte {
+	if len(e.timers) == 0 {
+		return nil
+	}
+
 	timers := make([]*entityTimerInfo, 0, len(e.timers))
 	for _, t := range e.timers {
 		timers = append(timers, t)
 	}
-	e.timers = nil // no more AddCallback or AddTimer
 	data, err := timersPacker.PackMsg(timers, nil)
 	if err != nil {
 		gwlog.TraceError("%s dump timers failed: %s", e, err)
@@ -382,9 +573,21 @@ func (e *Entity) Call(id EntityID, method string, args ...interface{}) {
 	callEntity(id, method, args)
 }
 
+// Call the service, the provider is chosen by the strategy of service
 func (e *Entity) CallService(serviceName string, method string, args ...interface{}) {
-	serviceEid := entityManager.chooseServiceProvider(serviceName)
+	if err := e.TryCallService(serviceName, method, args...); err != nil {
+		gwlog.Panic(err)
+	}
+}
+
+// Call the service, returns EntityNotFoundError if the service is not found
+func (e *Entity) TryCallService(serviceName string, method string, args ...interface{}) error {
+	serviceEid, err := entityManager.chooseServiceProvider(serviceName, e.ID)
+	if err != nil {
+		return err
+	}
 	callEntity(serviceEid, method, args)
+	return nil
 }
 
 func (e *Entity) syncPositionYawFromClient(x, y, z Coord, yaw Yaw) {
@@ -400,11 +603,21 @@ func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
 		}
 	}()
 
+	defer leaveProfFrame(e.enterProfFrame(methodName))
+	defer leaveCPUBudgetFrame(e.enterCPUBudgetFrame())
+	e.markActive()
+
 	rpcDesc := e.typeDesc.rpcDescs[methodName]
 	if rpcDesc == nil {
+		if rpc, ok := e.getScriptRpc(methodName); ok {
+			e.invokeScriptFromLocal(methodName, rpc, args)
+			return
+		}
 		// rpc not found
 		gwlog.Panicf("%s.onCallFromLocal: Method %s is not a valid RPC, args=%v", e, methodName, args)
 	}
+	defer recordRpcCall(e.TypeName, methodName, _RPC_CALLER_LOCAL, time.Now())
+	e.emitAnalytics(analytics.EVENT_RPC, "method", methodName, "caller", _RPC_CALLER_LOCAL)
 
 	// rpc call from server
 	if rpcDesc.Flags&RF_SERVER == 0 {
@@ -418,7 +631,7 @@ func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
 
 	methodType := rpcDesc.MethodType
 	in := make([]reflect.Value, rpcDesc.NumArgs+1)
-	in[0] = reflect.ValueOf(e.I) // first argument is the bind instance (self)
+	in[0] = e.rpcReceiver(rpcDesc) // first argument is the bind instance (self or component)
 
 	for i, arg := range args {
 		argType := methodType.In(i + 1)
@@ -430,32 +643,62 @@ func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
 		in[i+1] = reflect.Zero(argType)
 	}
 
+	if e.history != nil {
+		e.history.recordRpc(methodName, _RPC_CALLER_LOCAL, "", in[1:])
+	}
 	rpcDesc.Func.Call(in)
 }
 
-func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid ClientID) {
+func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid ClientID, trace tracing.SpanContext) {
 	defer func() {
 		err := recover() // recover from any error during RPC call
 		if err != nil {
-			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+			if e.clientAudit != nil {
+				gwlog.TraceError("%s.%s paniced: %s, recent client calls:%s", e, methodName, err, e.dumpClientAuditTrail())
+			} else {
+				gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+			}
 		}
 	}()
 
+	if clientid != "" && e.clientAudit != nil {
+		e.clientAudit.record(clientid, methodName, args)
+	}
+
+	if _, err := e.invokeFromRemote(methodName, args, clientid, trace); err != nil {
+		gwlog.Error("%s.onCallFromRemote: %s", e, err)
+	}
+}
+
+// invoke the RPC method with packed arguments, returns the results of method
+func (e *Entity) invokeFromRemote(methodName string, args [][]byte, clientid ClientID, trace tracing.SpanContext) ([]reflect.Value, error) {
+	defer leaveProfFrame(e.enterProfFrame(methodName))
+	defer leaveCPUBudgetFrame(e.enterCPUBudgetFrame())
+	e.markActive()
+
 	rpcDesc := e.typeDesc.rpcDescs[methodName]
 	if rpcDesc == nil {
+		if rpc, ok := e.getScriptRpc(methodName); ok {
+			return nil, e.invokeScriptFromRemote(methodName, rpc, args, clientid)
+		}
 		// rpc not found
-		gwlog.Error("%s.onCallFromRemote: Method %s is not a valid RPC, args=%v", e, methodName, args)
-		return
+		return nil, errors.Errorf("Method %s is not a valid RPC, args=%v", methodName, args)
 	}
 
 	methodType := rpcDesc.MethodType
 	if clientid == "" {
+		defer recordRpcCall(e.TypeName, methodName, _RPC_CALLER_SERVER, time.Now())
+		e.emitAnalytics(analytics.EVENT_RPC, "method", methodName, "caller", _RPC_CALLER_SERVER)
+		defer endRpcSpan(e.startRpcSpan(methodName, _RPC_CALLER_SERVER, trace))
 		// rpc call from server
 		if rpcDesc.Flags&RF_SERVER == 0 {
 			// can not call from server
 			gwlog.Panicf("%s.onCallFromRemote: Method %s can not be called from Server: flags=%v", e, methodName, rpcDesc.Flags)
 		}
 	} else {
+		defer recordRpcCall(e.TypeName, methodName, _RPC_CALLER_CLIENT, time.Now())
+		e.emitAnalytics(analytics.EVENT_RPC, "method", methodName, "caller", _RPC_CALLER_CLIENT)
+		defer endRpcSpan(e.startRpcSpan(methodName, _RPC_CALLER_CLIENT, trace))
 		isFromOwnClient := clientid == e.getClientID()
 		if rpcDesc.Flags&RF_OWN_CLIENT == 0 && isFromOwnClient {
 			gwlog.Panicf("%s.onCallFromRemote: Method %s can not be called from OwnClient: flags=%v", e, methodName, rpcDesc.Flags)
@@ -465,12 +708,11 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid Cli
 	}
 
 	if rpcDesc.NumArgs < len(args) {
-		gwlog.Error("%s.onCallFromRemote: Method %s receives %d arguments, but given %d", e, methodName, rpcDesc.NumArgs, len(args))
-		return
+		return nil, errors.Errorf("Method %s receives %d arguments, but given %d", methodName, rpcDesc.NumArgs, len(args))
 	}
 
 	in := make([]reflect.Value, rpcDesc.NumArgs+1)
-	in[0] = reflect.ValueOf(e.I) // first argument is the bind instance (self)
+	in[0] = e.rpcReceiver(rpcDesc) // first argument is the bind instance (self or component)
 
 	for i, arg := range args {
 		argType := methodType.In(i + 1)
@@ -489,13 +731,20 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid Cli
 		in[i+1] = reflect.Zero(argType)
 	}
 
-	rpcDesc.Func.Call(in)
+	if e.history != nil {
+		caller := _RPC_CALLER_SERVER
+		if clientid != "" {
+			caller = _RPC_CALLER_CLIENT
+		}
+		e.history.recordRpc(methodName, caller, clientid, in[1:])
+	}
+	return rpcDesc.Func.Call(in), nil
 }
 
 // Register for global service
 func (e *Entity) DeclareService(serviceName string) {
 	e.declaredServices.Add(serviceName)
-	dispatcher_client.GetDispatcherClientForSend().SendDeclareService(e.ID, serviceName)
+	dispatcher_client.GetDispatcherClientForEntity(e.ID).SendDeclareService(e.ID, serviceName)
 }
 
 // Default Handlers
@@ -557,6 +806,21 @@ func (e *Entity) getAllClientData() map[string]interface{} {
 	return e.Attrs.ToMapWithFilter(e.typeDesc.allClientAttrs.Contains)
 }
 
+// Get packed all-client attrs for creating the entity on observing clients
+//
+// The packed data is cached until any all-client attr changes, so that many clients entering the sight
+// of the entity at the same time do not marshal the same attrs over and over again
+func (e *Entity) getPackedAllClientData() []byte {
+	if e.allClientDataCache == nil {
+		data, err := netutil.MSG_PACKER.PackMsg(e.getAllClientData(), nil)
+		if err != nil {
+			gwlog.Panic(err)
+		}
+		e.allClientDataCache = data
+	}
+	return e.allClientDataCache
+}
+
 func (e *Entity) GetMigrateData() map[string]interface{} {
 	return e.Attrs.ToMap() // all attrs are migrated, without filter
 }
@@ -580,20 +844,33 @@ type entityFreezeData struct {
 	TimerData []byte
 	Pos       Position
 	Attrs     map[string]interface{}
+	AttrsData json.RawMessage `json:",omitempty"` // attributes captured by incremental freeze, used instead of Attrs
 	Yaw       Yaw
 	SpaceID   EntityID
 	Client    *clientData
 	ESR       *enteringSpaceRequestData
+	Revision  int64         `json:",omitempty"` // storage revision, put in Attrs when restored
+	Calendar  []interface{} `json:",omitempty"` // calendar subscriptions, put in Attrs when restored
 }
 
 func (e *Entity) GetFreezeData() *entityFreezeData {
+	return e.getFreezeData(e.dumpTimers())
+}
+
+func (e *Entity) getFreezeData(timerData []byte) *entityFreezeData {
 	data := &entityFreezeData{
 		Type:      e.TypeName,
-		TimerData: e.dumpTimers(),
-		Attrs:     e.Attrs.ToMap(),
+		TimerData: timerData,
 		Pos:       e.aoi.pos,
 		Yaw:       e.yaw,
 		SpaceID:   e.Space.ID,
+		Revision:  e.storageRevision,
+		Calendar:  e.dumpCalendarSubscriptions(),
+	}
+	if attrsData := e.getCapturedAttrs(); attrsData != nil {
+		data.AttrsData = attrsData
+	} else {
+		data.Attrs = e.Attrs.ToMap()
 	}
 	if e.client != nil {
 		data.Client = &clientData{
@@ -631,6 +908,8 @@ func (e *Entity) SetClient(client *GameClient) {
 	}
 
 	e.client = client
+	e.markReplicaDirty()
+	e.markActive()
 
 	if oldClient != nil {
 		// send destroy entity to client
@@ -662,9 +941,13 @@ func (e *Entity) SetClient(client *GameClient) {
 
 	if oldClient == nil && client != nil {
 		// got net client
+		e.emitClientAnalytics(analytics.EVENT_CLIENT_LOGIN, client)
 		gwutils.RunPanicless(e.I.OnClientConnected)
+		e.callComponentHooks(IComponent.OnClientConnected)
 	} else if oldClient != nil && client == nil {
+		e.emitClientAnalytics(analytics.EVENT_CLIENT_LOGOUT, oldClient)
 		gwutils.RunPanicless(e.I.OnClientDisconnected)
+		e.callComponentHooks(IComponent.OnClientDisconnected)
 	}
 }
 
@@ -672,6 +955,14 @@ func (e *Entity) CallClient(method string, args ...interface{}) {
 	e.client.call(e.ID, method, args...)
 }
 
+// Call the client method with acknowledged delivery, for critical notifications such as rewards and purchases
+//
+// The gate keeps the call until the client acknowledges it, and resends it if the client reconnects and resumes the
+// session in time. The call is dropped if the client has no session and is already disconnected.
+func (e *Entity) CallClientAcked(method string, args ...interface{}) {
+	e.client.callAcked(e.ID, method, args...)
+}
+
 func (e *Entity) GiveClientTo(other *Entity) {
 	if e.client == nil {
 		gwlog.Warn("%s.GiveClientTo(%s): client is nil", e, other)
@@ -687,12 +978,30 @@ func (e *Entity) GiveClientTo(other *Entity) {
 	other.SetClient(client)
 }
 
+// Kick the client of entity with reason code and message
+//
+// The client receives the reason before disconnected, and OnClientDisconnected will be called after the client is disconnected
+func (e *Entity) KickClient(reason proto.KickReason, message string) {
+	if e.client == nil {
+		gwlog.Warn("%s.KickClient: client is nil", e)
+		return
+	}
+
+	gwlog.Info("%s.KickClient: client=%s, reason=%d, message=%s", e, e.client, reason, message)
+	if handler, ok := e.I.(IClientKickHandler); ok {
+		gwutils.RunPanicless(func() {
+			handler.OnClientKicked(reason, message)
+		})
+	}
+	e.client.Kick(reason, message)
+}
+
 func (e *Entity) ForAllClients(f func(client *GameClient)) {
 	if e.client != nil {
 		f(e.client)
 	}
 
-	for neighbor := range e.Neighbors() {
+	for neighbor := range e.aoi.watchers {
 		if neighbor.client != nil {
 			f(neighbor.client)
 		}
@@ -704,8 +1013,26 @@ func (e *Entity) notifyClientDisconnected() {
 	if e.client == nil {
 		gwlog.Panic(e.client)
 	}
+	releaseEntryQueueSlot(e.client.clientid)
+	e.emitClientAnalytics(analytics.EVENT_CLIENT_LOGOUT, e.client)
 	e.client = nil
+	e.markReplicaDirty()
 	gwutils.RunPanicless(e.I.OnClientDisconnected)
+	e.callComponentHooks(IComponent.OnClientDisconnected)
+}
+
+// called when the client is reattached in the reconnect window of gates, no client hook is called
+func (e *Entity) onClientResumed(gateid uint16) {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s.onClientResumed: %s on gate %d", e, e.client, gateid)
+	}
+	e.client = MakeGameClient(e.client.clientid, gateid)
+	e.markReplicaDirty()
+
+	// filter properties are dropped by the gate with the disconnected client proxy
+	for key, val := range e.filterProps {
+		dispatcher_client.GetDispatcherClientForSend().SendSetClientFilterProp(e.client.gateid, e.client.clientid, key, val)
+	}
 }
 
 func (e *Entity) OnClientConnected() {
@@ -735,6 +1062,10 @@ func (e *Entity) getAttrFlag(attrName string) (flag attrFlag) {
 }
 
 func (e *Entity) sendMapAttrChangeToClients(ma *MapAttr, key string, val interface{}) {
+	if e.history != nil {
+		e.history.recordAttr(ENTITY_HISTORY_OP_MAP_SET, ma.getPathFromOwner(), key, val)
+	}
+
 	var flag attrFlag
 	if ma == e.Attrs {
 		// this is the root attr
@@ -744,9 +1075,10 @@ func (e *Entity) sendMapAttrChangeToClients(ma *MapAttr, key string, val interfa
 	}
 
 	if flag&afAllClient != 0 {
+		e.allClientDataCache = nil
 		path := ma.getPathFromOwner()
 		e.client.SendNotifyMapAttrChange(e.ID, path, key, val)
-		for neighbor := range e.aoi.neighbors {
+		for neighbor := range e.aoi.watchers {
 			neighbor.client.SendNotifyMapAttrChange(e.ID, path, key, val)
 		}
 	} else if flag&afClient != 0 {
@@ -756,6 +1088,10 @@ func (e *Entity) sendMapAttrChangeToClients(ma *MapAttr, key string, val interfa
 }
 
 func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
+	if e.history != nil {
+		e.history.recordAttr(ENTITY_HISTORY_OP_MAP_DEL, ma.getPathFromOwner(), key, nil)
+	}
+
 	var flag attrFlag
 	if ma == e.Attrs {
 		// this is the root attr
@@ -765,9 +1101,10 @@ func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
 	}
 
 	if flag&afAllClient != 0 {
+		e.allClientDataCache = nil
 		path := ma.getPathFromOwner()
 		e.client.SendNotifyMapAttrDel(e.ID, path, key)
-		for neighbor := range e.aoi.neighbors {
+		for neighbor := range e.aoi.watchers {
 			neighbor.client.SendNotifyMapAttrDel(e.ID, path, key)
 		}
 	} else if flag&afClient != 0 {
@@ -777,12 +1114,17 @@ func (e *Entity) sendMapAttrDelToClients(ma *MapAttr, key string) {
 }
 
 func (e *Entity) sendListAttrChangeToClients(la *ListAttr, index int, val interface{}) {
+	if e.history != nil {
+		e.history.recordAttr(ENTITY_HISTORY_OP_LIST_SET, la.getPathFromOwner(), index, val)
+	}
+
 	flag := la.flag
 
 	if flag&afAllClient != 0 {
+		e.allClientDataCache = nil
 		path := la.getPathFromOwner()
 		e.client.SendNotifyListAttrChange(e.ID, path, uint32(index), val)
-		for neighbor := range e.aoi.neighbors {
+		for neighbor := range e.aoi.watchers {
 			neighbor.client.SendNotifyListAttrChange(e.ID, path, uint32(index), val)
 		}
 	} else if flag&afClient != 0 {
@@ -792,11 +1134,16 @@ func (e *Entity) sendListAttrChangeToClients(la *ListAttr, index int, val interf
 }
 
 func (e *Entity) sendListAttrPopToClients(la *ListAttr) {
+	if e.history != nil {
+		e.history.recordAttr(ENTITY_HISTORY_OP_LIST_POP, la.getPathFromOwner(), nil, nil)
+	}
+
 	flag := la.flag
 	if flag&afAllClient != 0 {
+		e.allClientDataCache = nil
 		path := la.getPathFromOwner()
 		e.client.SendNotifyListAttrPop(e.ID, path)
-		for neighbor := range e.aoi.neighbors {
+		for neighbor := range e.aoi.watchers {
 			neighbor.client.SendNotifyListAttrPop(e.ID, path)
 		}
 	} else if flag&afClient != 0 {
@@ -806,11 +1153,16 @@ func (e *Entity) sendListAttrPopToClients(la *ListAttr) {
 }
 
 func (e *Entity) sendListAttrAppendToClients(la *ListAttr, val interface{}) {
+	if e.history != nil {
+		e.history.recordAttr(ENTITY_HISTORY_OP_LIST_APPEND, la.getPathFromOwner(), nil, val)
+	}
+
 	flag := la.flag
 	if flag&afAllClient != 0 {
+		e.allClientDataCache = nil
 		path := la.getPathFromOwner()
 		e.client.SendNotifyListAttrAppend(e.ID, path, val)
-		for neighbor := range e.aoi.neighbors {
+		for neighbor := range e.aoi.watchers {
 			neighbor.client.SendNotifyListAttrAppend(e.ID, path, val)
 		}
 	} else if flag&afClient != 0 {
@@ -846,11 +1198,18 @@ func (e *Entity) GetListAttr(key string) *ListAttr {
 
 // Enter target space
 func (e *Entity) EnterSpace(spaceID EntityID, pos Position) {
+	if err := e.TryEnterSpace(spaceID, pos); err != nil {
+		gwlog.Error("%s can not enter space %s: %s", e, spaceID, err)
+	}
+}
+
+// Enter the space, returns MigrationInProgressError if the entity is entering another space
+func (e *Entity) TryEnterSpace(spaceID EntityID, pos Position) error {
 	if e.isEnteringSpace() {
-		gwlog.Error("%s is entering space %s, can not enter space %s", e, e.enteringSpaceRequest.SpaceID, spaceID)
-		return
+		return &MigrationInProgressError{EntityID: e.ID, SpaceID: e.enteringSpaceRequest.SpaceID}
 	}
 	e.requestMigrateTo(spaceID, pos)
+	return nil
 
 	// todo: prohibit local enter for test only, uncomment
 	//localSpace := spaceManager.getSpace(spaceID)
@@ -881,6 +1240,7 @@ func (e *Entity) enterLocalSpace(space *Space, pos Position) {
 
 		//gwlog.Info("%s.enterLocalSpace ==> %s", e, space)
 		e.Space.leave(e)
+		space.recordReplayEnter(e, pos)
 		space.enter(e, pos, false)
 	})
 }
@@ -896,7 +1256,7 @@ func (e *Entity) requestMigrateTo(spaceID EntityID, pos Position) {
 	e.enteringSpaceRequest.EnterPos = pos
 	e.enteringSpaceRequest.RequestTime = time.Now().UnixNano()
 
-	dispatcher_client.GetDispatcherClientForSend().SendMigrateRequest(spaceID, e.ID)
+	dispatcher_client.GetDispatcherClientForEntity(e.ID).SendMigrateRequest(spaceID, e.ID)
 }
 
 func (e *Entity) clearEnteringSpaceRequest() {
@@ -908,7 +1268,7 @@ func (e *Entity) clearEnteringSpaceRequest() {
 func OnMigrateRequestAck(entityID EntityID, spaceID EntityID, spaceLoc uint16) {
 	entity := entityManager.get(entityID)
 	if entity == nil {
-		//dispatcher_client.GetDispatcherClientForSend().SendCancelMigrateRequest(entityID)
+		dispatcher_client.GetDispatcherClientForEntity(entityID).SendCancelMigrate(entityID)
 		gwlog.Error("Migrate failed since entity is destroyed: spaceID=%s, entityID=%s", spaceID, entityID)
 		return
 	}
@@ -921,7 +1281,8 @@ func OnMigrateRequestAck(entityID EntityID, spaceID EntityID, spaceLoc uint16) {
 	}
 
 	if !entity.isEnteringSpace() {
-		// replay from dispatcher is too late ?
+		// replay from dispatcher is too late ? stop queuing calls to the entity in dispatcher
+		dispatcher_client.GetDispatcherClientForEntity(entityID).SendCancelMigrate(entityID)
 		return
 	}
 
@@ -930,10 +1291,10 @@ func OnMigrateRequestAck(entityID EntityID, spaceID EntityID, spaceLoc uint16) {
 		return
 	}
 
-	entity.realMigrateTo(spaceID, entity.enteringSpaceRequest.EnterPos, spaceLoc)
+	entity.realMigrateTo(spaceID, entity.enteringSpaceRequest.EnterPos, spaceLoc, 0, 0)
 }
 
-func (e *Entity) realMigrateTo(spaceID EntityID, pos Position, spaceLoc uint16) {
+func (e *Entity) realMigrateTo(spaceID EntityID, pos Position, spaceLoc uint16, groupID uint32, groupSize uint16) {
 	var clientid ClientID
 	var clientsrv uint16
 	if e.client != nil {
@@ -941,30 +1302,45 @@ func (e *Entity) realMigrateTo(spaceID EntityID, pos Position, spaceLoc uint16)
 		clientsrv = e.client.gateid
 	}
 
-	e.destroyEntity(true) // disable the entity
+	e.destroyEntity(true, nil) // disable the entity
 	timerData := e.dumpTimers()
-	migrateData := e.I.GetMigrateData()
+	migrateData := e.putCalendarSubscriptions(e.putStorageRevision(e.I.GetMigrateData()))
+	isLocal := spaceManager.getSpace(spaceID) != nil
+	token, baseToken, payload := packMigrateData(e.ID, spaceLoc, isLocal, migrateData)
 
-	dispatcher_client.GetDispatcherClientForSend().SendRealMigrate(e.ID, spaceLoc, spaceID,
-		float32(pos.X), float32(pos.Y), float32(pos.Z), e.TypeName, migrateData, timerData, clientid, clientsrv)
+	dispatcher_client.GetDispatcherClientForEntity(e.ID).SendRealMigrate(e.ID, spaceLoc, spaceID,
+		float32(pos.X), float32(pos.Y), float32(pos.Z), e.TypeName, token, baseToken, payload, timerData, clientid, clientsrv,
+		groupID, groupSize)
 }
 
 func OnRealMigrate(entityID EntityID, spaceID EntityID, x, y, z float32, typeName string,
-	migrateData map[string]interface{}, timerData []byte,
-	clientid ClientID, clientsrv uint16) {
+	sourceGame uint16, token uint32, baseToken uint32, payload []byte, timerData []byte,
+	clientid ClientID, clientsrv uint16, groupID uint32, groupSize uint16) {
 
 	if entityManager.get(entityID) != nil {
 		gwlog.Panicf("entity %s already exists", entityID)
 	}
 
-	// try to find the target space, but might be nil
-	space := spaceManager.getSpace(spaceID)
-	var client *GameClient
-	if !clientid.IsNil() {
-		client = MakeGameClient(clientid, clientsrv)
+	create := func(migrateData map[string]interface{}) {
+		// try to find the target space, but might be nil
+		space := spaceManager.getSpace(spaceID)
+		var client *GameClient
+		if !clientid.IsNil() {
+			client = MakeGameClient(clientid, clientsrv)
+		}
+		pos := Position{Coord(x), Coord(y), Coord(z)}
+		createEntity(typeName, space, pos, entityID, migrateData, timerData, client, ccMigrate)
+	}
+	if groupID != 0 {
+		create = holdGroupMigrateIn(entityID, sourceGame, groupID, groupSize, create)
+	}
+
+	migrateData, ok := unpackMigrateData(entityID, sourceGame, token, baseToken, payload)
+	if !ok {
+		holdMigrateIn(entityID, sourceGame, create)
+		return
 	}
-	pos := Position{Coord(x), Coord(y), Coord(z)}
-	createEntity(typeName, space, pos, entityID, migrateData, timerData, client, ccMigrate)
+	create(migrateData)
 }
 
 func (e *Entity) OnMigrateOut() {
@@ -1010,6 +1386,7 @@ func (e *Entity) GetPosition() Position {
 }
 
 func (e *Entity) SetPosition(pos Position) {
+	e.StopMoving()
 	e.setPositionYaw(pos, e.yaw, false)
 }
 
@@ -1033,6 +1410,10 @@ func (e *Entity) setPositionYaw(pos Position, yaw Yaw, fromClient bool) {
 }
 
 func CollectEntitySyncInfos() {
+	if loadShedding.shouldSkipSync() {
+		return // sync infos are collected in later flushes
+	}
+
 	cfg := config.Get()
 	gateCount := len(cfg.Gates)
 	entitySyncInfosToGate := make([]*netutil.Packet, gateCount)
@@ -1043,10 +1424,10 @@ func CollectEntitySyncInfos() {
 		entitySyncInfosToGate[gateid-1] = packet
 	}
 
-	for eid, e := range entityManager.entities {
+	collectSyncInfo := func(eid EntityID, e *Entity) {
 		syncInfoFlag := e.syncInfoFlag
 		if syncInfoFlag == 0 {
-			continue
+			return
 		}
 
 		e.syncInfoFlag = 0
@@ -1062,7 +1443,7 @@ func CollectEntitySyncInfos() {
 			packet.AppendFloat32(syncInfo.Yaw)
 		}
 		if syncInfoFlag&sifSyncNeighborClients != 0 {
-			for neighbor := range e.aoi.neighbors {
+			for neighbor := range e.aoi.watchers {
 				client := neighbor.client
 				if client != nil {
 					gateid := client.gateid
@@ -1078,6 +1459,13 @@ func CollectEntitySyncInfos() {
 		}
 	}
 
+	for eid, e := range entityManager.entities {
+		collectSyncInfo(eid, e)
+	}
+	for e := range ghostEntities { // ghosts of entities in stitched spaces
+		collectSyncInfo(e.ID, e)
+	}
+
 	// send to dispatcher, one gate by one gate
 	for _, packet := range entitySyncInfosToGate {
 		//gwlog.Info("SYNC %d PAYLOAD %d", gateid, packet.GetPayloadLen())
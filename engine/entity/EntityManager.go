@@ -3,13 +3,14 @@ package entity
 import (
 	"reflect"
 
-	"math/rand"
-
 	"os"
 
 	"strings"
 
+	"time"
+
 	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/analytics"
 	. "github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
 	"github.com/xiaonanln/goworld/engine/consts"
@@ -17,6 +18,8 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+	"github.com/xiaonanln/goworld/engine/tracing"
 	"github.com/xiaonanln/typeconv"
 )
 
@@ -31,6 +34,27 @@ type EntityTypeDesc struct {
 	allClientAttrs  StringSet
 	clientAttrs     StringSet
 	persistentAttrs StringSet
+	clientAuditSize int
+	attrRateLimits  map[string]attrRateLimit
+	rpcRateLimits   map[string]rpcRateLimit
+	attrTypes       map[string]string
+	attrChangeHooks StringSet     // attributes notified to IAttrChangeHandler
+	placement       string        // placement constraint of games for creating and loading entities anywhere
+	partialSave     bool          // save only changed persistent attributes
+	lowPriority     bool          // repeated timers can be paused by load shedding
+	criticalRPCs    StringSet     // client RPCs never rejected by load shedding
+	cpuBudget       time.Duration // execution time per second, timers are deferred if exceeded
+	execGroup       string        // execution group sharing the main loop time
+	avatarType      string        // avatar type of account type
+	restoreDeps     []string      // entity types restored before this type
+	components      []*ComponentDesc
+	componentAttrs  map[string]string // component names by attributes defined by components
+	replicated      bool              // replicated to standby games
+	script          *entityScript     // Lua script implementing RPC methods
+	saveInterval    time.Duration     // overrides the global save interval if not 0
+	saveJitter      time.Duration     // max random delay of the first save of each entity
+	idleTimeout     time.Duration     // idle entities are unloaded after the timeout if not 0
+	freezeMigrators []freezeMigration // migrations of freeze data ordered by versions
 }
 
 var _VALID_ATTR_DEFS = StringSet{} // all valid attribute defs
@@ -39,19 +63,25 @@ func init() {
 	_VALID_ATTR_DEFS.Add(strings.ToLower("Client"))
 	_VALID_ATTR_DEFS.Add(strings.ToLower("AllClients"))
 	_VALID_ATTR_DEFS.Add(strings.ToLower("Persistent"))
+	_VALID_ATTR_DEFS.Add(strings.ToLower("OnChange"))
 }
 
 func (desc *EntityTypeDesc) DefineAttrs(attrDefs map[string][]string) {
 
 	for attr, defs := range attrDefs {
-		isAllClient, isClient, isPersistent := false, false, false
+		isAllClient, isClient, isPersistent, isOnChange := false, false, false, false
 
 		for _, def := range defs {
+			if attrType, ok := ParseAttrType(def); ok {
+				desc.setAttrType(attr, attrType)
+				continue
+			}
+
 			def := strings.ToLower(def)
 
 			if !_VALID_ATTR_DEFS.Contains(def) {
 				// not a valid def
-				gwlog.Panicf("attribute %s: invalid property: %s; all valid properties: %v", attr, def, _VALID_ATTR_DEFS.ToList())
+				gwlog.Panicf("attribute %s: invalid property: %s; all valid properties: %v", attr, def, validAttrDefsForError())
 			}
 
 			if def == "allclients" {
@@ -61,6 +91,8 @@ func (desc *EntityTypeDesc) DefineAttrs(attrDefs map[string][]string) {
 				isClient = true
 			} else if def == "persistent" {
 				isPersistent = true
+			} else if def == "onchange" {
+				isOnChange = true
 			}
 		}
 
@@ -73,6 +105,9 @@ func (desc *EntityTypeDesc) DefineAttrs(attrDefs map[string][]string) {
 		if isPersistent {
 			desc.persistentAttrs.Add(attr)
 		}
+		if isOnChange {
+			desc.attrChangeHooks.Add(attr)
+		}
 	}
 }
 
@@ -80,6 +115,12 @@ type EntityManager struct {
 	entities           EntityMap
 	ownerOfClient      map[ClientID]EntityID
 	registeredServices map[string]EntityIDSet
+	serviceGames       map[EntityID]uint16 // the game of each service provider
+
+	serviceProviderLists map[string][]EntityID // sorted service providers for choosing, cleared when services change
+
+	tagIndex  map[string]EntitySet   // local entities by tags
+	typeIndex map[string]EntityIDSet // local entities by type names
 }
 
 func newEntityManager() *EntityManager {
@@ -87,15 +128,37 @@ func newEntityManager() *EntityManager {
 		entities:           EntityMap{},
 		ownerOfClient:      map[ClientID]EntityID{},
 		registeredServices: map[string]EntityIDSet{},
+		serviceGames:       map[EntityID]uint16{},
+
+		serviceProviderLists: map[string][]EntityID{},
+
+		tagIndex:  map[string]EntitySet{},
+		typeIndex: map[string]EntityIDSet{},
 	}
 }
 
 func (em *EntityManager) put(entity *Entity) {
 	em.entities.Add(entity)
+	eids := em.typeIndex[entity.TypeName]
+	if eids == nil {
+		eids = EntityIDSet{}
+		em.typeIndex[entity.TypeName] = eids
+	}
+	eids.Add(entity.ID)
+	recordEntityCreated(entity.TypeName)
 }
 
 func (em *EntityManager) del(entityID EntityID) {
-	em.entities.Del(entityID)
+	if entity := em.entities.Get(entityID); entity != nil {
+		recordEntityDestroyed(entity.TypeName)
+		em.entities.Del(entityID)
+		if eids := em.typeIndex[entity.TypeName]; eids != nil {
+			eids.Del(entityID)
+			if len(eids) == 0 {
+				delete(em.typeIndex, entity.TypeName)
+			}
+		}
+	}
 }
 
 func (em *EntityManager) get(id EntityID) *Entity {
@@ -119,6 +182,14 @@ func (em *EntityManager) onClientDisconnected(clientid ClientID) {
 	}
 }
 
+func (em *EntityManager) onClientResumed(clientid ClientID, gateid uint16) {
+	eid := em.ownerOfClient[clientid]
+	owner := em.get(eid)
+	if owner != nil && owner.client != nil && owner.client.clientid == clientid {
+		owner.onClientResumed(gateid)
+	}
+}
+
 func (em *EntityManager) onGateDisconnected(gateid uint16) {
 	for _, entity := range em.entities {
 		client := entity.client
@@ -129,13 +200,15 @@ func (em *EntityManager) onGateDisconnected(gateid uint16) {
 	}
 }
 
-func (em *EntityManager) onDeclareService(serviceName string, eid EntityID) {
+func (em *EntityManager) onDeclareService(serviceName string, eid EntityID, gameid uint16) {
 	eids, ok := em.registeredServices[serviceName]
 	if !ok {
 		eids = EntityIDSet{}
 		em.registeredServices[serviceName] = eids
 	}
 	eids.Add(eid)
+	em.serviceGames[eid] = gameid
+	delete(em.serviceProviderLists, serviceName)
 }
 
 func (em *EntityManager) onUndeclareService(serviceName string, eid EntityID) {
@@ -143,23 +216,27 @@ func (em *EntityManager) onUndeclareService(serviceName string, eid EntityID) {
 	if ok {
 		eids.Del(eid)
 	}
+	delete(em.serviceProviderLists, serviceName)
+
+	for _, eids := range em.registeredServices {
+		if eids.Contains(eid) {
+			return // still providing other services
+		}
+	}
+	delete(em.serviceGames, eid)
 }
 
-func (em *EntityManager) chooseServiceProvider(serviceName string) EntityID {
-	// choose one entity ID of service providers randomly
+func (em *EntityManager) chooseServiceProvider(serviceName string, caller EntityID) (EntityID, error) {
+	return em.chooseServiceProviderWithStrategy(serviceName, caller, GetServiceStrategy(serviceName))
+}
+
+func (em *EntityManager) chooseServiceProviderWithStrategy(serviceName string, caller EntityID, strategy ServiceStrategy) (EntityID, error) {
 	eids, ok := em.registeredServices[serviceName]
-	if !ok {
-		gwlog.Panicf("service not found: %s", serviceName)
+	if !ok || len(eids) == 0 {
+		return "", &EntityNotFoundError{ServiceName: serviceName}
 	}
 
-	r := rand.Intn(len(eids)) // get a random one
-	for eid := range eids {
-		if r == 0 {
-			return eid
-		}
-		r -= 1
-	}
-	return "" // never goes here
+	return strategy.ChooseProvider(serviceName, em.getServiceProviderList(serviceName), caller), nil
 }
 
 func RegisterEntity(typeName string, entityPtr IEntity) *EntityTypeDesc {
@@ -177,6 +254,8 @@ func RegisterEntity(typeName string, entityPtr IEntity) *EntityTypeDesc {
 		clientAttrs:     StringSet{},
 		allClientAttrs:  StringSet{},
 		persistentAttrs: StringSet{},
+		attrChangeHooks: StringSet{},
+		criticalRPCs:    StringSet{},
 	}
 	registeredEntityTypes[typeName] = entityTypeDesc
 
@@ -197,8 +276,23 @@ const (
 	ccCreate createCause = 1 + iota
 	ccMigrate
 	ccRestore
+	ccTakeover
 )
 
+func (cause createCause) String() string {
+	switch cause {
+	case ccCreate:
+		return "create"
+	case ccMigrate:
+		return "migrate"
+	case ccRestore:
+		return "restore"
+	case ccTakeover:
+		return "takeover"
+	}
+	return "unknown"
+}
+
 func createEntity(typeName string, space *Space, pos Position, entityID EntityID, data map[string]interface{}, timerData []byte, client *GameClient, cause createCause) EntityID {
 	//gwlog.Debug("createEntity: %s in Space %s", typeName, space)
 	entityTypeDesc, ok := registeredEntityTypes[typeName]
@@ -222,7 +316,10 @@ func createEntity(typeName string, space *Space, pos Position, entityID EntityID
 	entity.Space = nilSpace
 
 	entityManager.put(entity)
+	var calendarSubscriptions []calendarHandle
 	if data != nil {
+		entity.storageRevision = storage.PopRevision(data)
+		calendarSubscriptions = popCalendarSubscriptions(data)
 		if cause == ccCreate {
 			entity.I.LoadPersistentData(data)
 		} else {
@@ -231,6 +328,7 @@ func createEntity(typeName string, space *Space, pos Position, entityID EntityID
 	} else {
 		entity.Save() // save immediately after creation
 	}
+	entity.attrsLoaded = true
 
 	if timerData != nil {
 		entity.restoreTimers(timerData)
@@ -241,9 +339,10 @@ func createEntity(typeName string, space *Space, pos Position, entityID EntityID
 		entity.setupSaveTimer()
 	}
 
-	if cause == ccCreate || cause == ccRestore {
-		dispatcher_client.GetDispatcherClientForSend().SendNotifyCreateEntity(entityID)
+	if cause == ccCreate || cause == ccRestore || cause == ccTakeover {
+		notifyCreateEntity(typeName, entityID)
 	}
+	entity.startReplication()
 
 	if client != nil {
 		// assign client to the newly created
@@ -256,47 +355,151 @@ func createEntity(typeName string, space *Space, pos Position, entityID EntityID
 	}
 
 	gwlog.Debug("Entity %s created, cause=%d, client=%s", entity, cause, client)
+	entity.emitAnalytics(analytics.EVENT_ENTITY_CREATED, "cause", cause.String())
 	if cause == ccCreate {
 		gwutils.RunPanicless(entity.I.OnCreated)
+		entity.callComponentHooks(IComponent.OnCreated)
 	} else if cause == ccMigrate {
 		gwutils.RunPanicless(entity.I.OnMigrateIn)
+		entity.callComponentHooks(IComponent.OnMigrateIn)
 	} else if cause == ccRestore {
 		// restore should be silent
 		gwutils.RunPanicless(entity.I.OnRestored)
+		entity.callComponentHooks(IComponent.OnRestored)
+	} else if cause == ccTakeover {
+		if handler, ok := entity.I.(ITakeoverHandler); ok {
+			gwutils.RunPanicless(handler.OnTakenOver)
+		}
 	}
+	if cause == ccCreate || cause == ccRestore || cause == ccTakeover {
+		entity.onMailReceiverCreated()
+	}
+	entity.resubscribeCalendarEvents(calendarSubscriptions)
 
 	if space != nil {
+		if cause == ccMigrate {
+			space.recordReplayEnter(entity, pos)
+		}
 		space.enter(entity, pos, cause == ccRestore)
 	}
 
+	if account, ok := entity.I.(iAccountMigrateIn); ok && cause == ccMigrate {
+		account.onAccountMigrateIn() // the account may give client to avatar and destroy itself
+	}
+
 	return entityID
 }
 
-func loadEntityLocally(typeName string, entityID EntityID, space *Space, pos Position) {
+// Notify the dispatcher of entity that it is created, spaces are notified to all dispatchers for migrating into them
+func notifyCreateEntity(typeName string, entityID EntityID) {
+	if typeName == SPACE_ENTITY_TYPE {
+		for _, dispatcherClient := range dispatcher_client.GetAllDispatcherClientsForSend() {
+			dispatcherClient.SendNotifyCreateEntity(entityID)
+		}
+	} else {
+		dispatcher_client.GetDispatcherClientForEntity(entityID).SendNotifyCreateEntity(entityID)
+	}
+}
+
+func notifyDestroyEntity(typeName string, entityID EntityID) {
+	if typeName == SPACE_ENTITY_TYPE {
+		for _, dispatcherClient := range dispatcher_client.GetAllDispatcherClientsForSend() {
+			dispatcherClient.SendNotifyDestroyEntity(entityID)
+		}
+	} else {
+		dispatcher_client.GetDispatcherClientForEntity(entityID).SendNotifyDestroyEntity(entityID)
+	}
+}
+
+func loadEntityLocally(typeName string, entityID EntityID, space *Space, pos Position, callback CreateEntityCallback) {
+	loadFailed := func(err error) {
+		gwlog.TraceError("load entity %s.%s failed: %s", typeName, entityID, err)
+		notifyDestroyEntity(typeName, entityID) // load entity failed, tell dispatcher
+		if callback != nil {
+			callback("", err)
+		}
+	}
+
+	if _, ok := registeredEntityTypes[typeName]; !ok {
+		loadFailed(errors.Errorf("unknown entity type: %s", typeName))
+		return
+	}
+
+	if entityManager.get(entityID) != nil { // loading the entity again is ignored
+		if callback != nil {
+			callback(entityID, nil)
+		}
+		return
+	}
+
+	if typeName == SPACE_ENTITY_TYPE { // spaces are bound to all dispatchers
+		loadEntityDataLocally(typeName, entityID, space, pos, callback, loadFailed)
+		return
+	}
+
+	// claim the entity before loading, so that it is not loaded on two games
+	claimEntity(entityID, func(gameid uint16, err error) {
+		if err != nil {
+			loadFailed(err)
+			return
+		}
+
+		if gameid != 0 { // the entity is on another game and is not bound to this game, dispatcher is not notified
+			gwlog.Warn("load entity %s.%s ignored: already loaded on game %d", typeName, entityID, gameid)
+			OnEntityAlreadyLoaded(entityID, gameid)
+			if callback != nil {
+				callback("", &EntityAlreadyLoadedError{EntityID: entityID, GameID: gameid})
+			}
+			return
+		}
+
+		loadEntityDataLocally(typeName, entityID, space, pos, callback, loadFailed)
+	})
+}
+
+func loadEntityDataLocally(typeName string, entityID EntityID, space *Space, pos Position, callback CreateEntityCallback, loadFailed func(err error)) {
 	// load the data from storage
 	storage.Load(typeName, entityID, func(data interface{}, err error) {
 		// callback runs in main routine
-		if err != nil {
-			gwlog.Panicf("load entity %s.%s failed: %s", typeName, entityID, err)
-			dispatcher_client.GetDispatcherClientForSend().SendNotifyDestroyEntity(entityID) // load entity failed, tell dispatcher
+		if entityManager.get(entityID) != nil { // the entity is loaded during the load
+			if callback != nil {
+				callback(entityID, nil)
+			}
+			return
+		}
+
+		if storage.IsCorruptedData(err) {
+			loadFailed(&EntityDataCorruptedError{EntityID: entityID, Err: err})
+			return
+		} else if err != nil {
+			loadFailed(&StorageUnavailableError{Err: err})
+			return
+		}
+
+		if data == nil {
+			loadFailed(&EntityNotFoundError{EntityID: entityID})
+			return
 		}
 
 		if space != nil && space.IsDestroyed() {
 			// Space might be destroy during the Load process, so cancel the entity creation
-			dispatcher_client.GetDispatcherClientForSend().SendNotifyDestroyEntity(entityID) // load entity failed, tell dispatcher
+			loadFailed(&SpaceDestroyedError{SpaceID: space.ID})
 			return
 		}
 
 		createEntity(typeName, space, pos, entityID, data.(map[string]interface{}), nil, nil, ccCreate)
+		if callback != nil {
+			callback(entityID, nil)
+		}
 	})
 }
 
 func loadEntityAnywhere(typeName string, entityID EntityID) {
-	dispatcher_client.GetDispatcherClientForSend().SendLoadEntityAnywhere(typeName, entityID)
+	dispatcher_client.GetDispatcherClientForEntity(entityID).SendLoadEntityAnywhere(typeName, entityID, getPlacement(typeName, nil))
 }
 
 func createEntityAnywhere(typeName string, data map[string]interface{}) {
-	dispatcher_client.GetDispatcherClientForSend().SendCreateEntityAnywhere(typeName, data)
+	dispatcher_client.GetDispatcherClientForSend().SendCreateEntityAnywhere(typeName, data, 0, getPlacement(typeName, data))
 }
 
 func CreateEntityLocally(typeName string, data map[string]interface{}, client *GameClient) EntityID {
@@ -308,7 +511,12 @@ func CreateEntityAnywhere(typeName string) {
 }
 
 func LoadEntityLocally(typeName string, entityID EntityID) {
-	loadEntityLocally(typeName, entityID, nil, Position{})
+	loadEntityLocally(typeName, entityID, nil, Position{}, nil)
+}
+
+// Load entity locally, the callback is called with error if the entity is not loaded
+func LoadEntityLocallyWithCallback(typeName string, entityID EntityID, callback CreateEntityCallback) {
+	loadEntityLocally(typeName, entityID, nil, Position{}, callback)
 }
 
 func LoadEntityAnywhere(typeName string, entityID EntityID) {
@@ -319,8 +527,16 @@ func OnClientDisconnected(clientid ClientID) {
 	entityManager.onClientDisconnected(clientid) // pop the owner eid
 }
 
-func OnDeclareService(serviceName string, entityid EntityID) {
-	entityManager.onDeclareService(serviceName, entityid)
+// Called by engine when the client is reattached to its owner in the reconnect window of gates
+func OnClientResumed(clientid ClientID, gateid uint16) {
+	entityManager.onClientResumed(clientid, gateid)
+}
+
+func OnDeclareService(serviceName string, entityid EntityID, gameid uint16) {
+	if entityid.Namespace() != GetLocalNamespace() {
+		return // services of other namespaces are invisible
+	}
+	entityManager.onDeclareService(serviceName, entityid, gameid)
 }
 
 func OnUndeclareService(serviceName string, entityid EntityID) {
@@ -331,6 +547,11 @@ func GetServiceProviders(serviceName string) EntityIDSet {
 	return entityManager.registeredServices[serviceName]
 }
 
+// Get providers of all services by service names, the result should not be modified
+func GetAllServiceProviders() map[string]EntityIDSet {
+	return entityManager.registeredServices
+}
+
 func callEntity(id EntityID, method string, args []interface{}) {
 	callRemote(id, method, args)
 
@@ -346,18 +567,46 @@ func callEntity(id EntityID, method string, args []interface{}) {
 }
 
 func callRemote(id EntityID, method string, args []interface{}) {
-	dispatcher_client.GetDispatcherClientForSend().SendCallEntityMethod(id, method, args)
+	sendCallRemote(id, method, args, tracing.Current())
 }
 
-func OnCall(id EntityID, method string, args [][]byte, clientID ClientID) {
+func sendCallRemote(id EntityID, method string, args []interface{}, trace tracing.SpanContext) {
+	if holdingCallsForSavePoint != 0 {
+		holdCall(func() {
+			sendCallRemote(id, method, args, trace)
+		})
+		return
+	}
+	dispatcher_client.GetDispatcherClientForEntity(id).SendCallEntityMethod(id, method, args, trace.Marshal())
+}
+
+func OnCall(id EntityID, method string, args [][]byte, clientID ClientID, trace tracing.SpanContext) {
 	e := entityManager.get(id)
-	if e == nil {
+	if e == nil && holdCallToMigratingIn(id, func() { OnCall(id, method, args, clientID, trace) }) {
+		return
+	} else if e == nil {
 		// entity not found, may destroyed before call
 		gwlog.Error("Entity %s is not found while calling %s%v", id, method, args)
 		return
 	}
 
-	e.onCallFromRemote(method, args, clientID)
+	if clientID != "" && !e.checkRpcRate(method, clientID) {
+		// calls exceeding rate limits are dropped
+		return
+	}
+
+	if clientID != "" && e.Space.isRecordingReplay() {
+		// client inputs in replayable spaces are executed at next tick
+		e.Space.queueReplayInput(&ReplayInput{EntityID: id, Method: method, Args: args, clientid: clientID})
+		return
+	}
+
+	if clientID != "" && loadShedding.shouldRejectClientRPC(e, method) {
+		gwlog.Warn("%s.%s from client %s is rejected by load shedding", e, method, clientID)
+		return
+	}
+
+	e.onCallFromRemote(method, args, clientID, trace)
 }
 
 func OnSyncPositionYawFromClient(eid EntityID, x, y, z Coord, yaw Yaw) {
@@ -368,6 +617,11 @@ func OnSyncPositionYawFromClient(eid EntityID, x, y, z Coord, yaw Yaw) {
 		return
 	}
 
+	if e.Space.isRecordingReplay() {
+		e.Space.queueReplayInput(&ReplayInput{EntityID: eid, Pos: Position{x, y, z}, Yaw: yaw})
+		return
+	}
+
 	e.syncPositionYawFromClient(x, y, z, yaw)
 }
 
@@ -375,10 +629,14 @@ func GetEntity(id EntityID) *Entity {
 	return entityManager.get(id)
 }
 
-func OnGameTerminating() {
-	for _, e := range entityManager.entities {
-		e.Destroy()
-	}
+// Destroy all entities when game is terminating, entities are saved when destroyed
+//
+// Entities with clients are destroyed first, and entities not destroyed before the deadline are dropped without
+// saving, see SaveAllEntities.
+func OnGameTerminating(deadline time.Time) SaveAllResult {
+	return saveEntities("destroy", SaveAllOptions{Deadline: deadline}, func(e *Entity, done storage.SaveCallbackFunc) bool {
+		return e.destroyWithSaveCallback(done)
+	})
 }
 
 func OnGateDisconnected(gateid uint16) {
@@ -386,33 +644,170 @@ func OnGateDisconnected(gateid uint16) {
 	entityManager.onGateDisconnected(gateid)
 }
 
-func SaveAllEntities() {
+// Options of saving all entities
+type SaveAllOptions struct {
+	Concurrency int                     // max number of saves in progress, consts.SAVE_ALL_DEFAULT_CONCURRENCY if not set
+	Deadline    time.Time               // entities not saved before the deadline are skipped, no deadline if zero
+	Progress    func(res SaveAllResult) // called periodically and when finished
+}
+
+// Result of saving all entities
+type SaveAllResult struct {
+	Total   int // number of entities to save
+	Saved   int // number of entities saved
+	Failed  int // number of entities not saved because of revision conflicts
+	Skipped int // number of entities not saved before the deadline
+	Elapsed time.Duration
+}
+
+// Save all persistent entities and wait for saves to finish
+//
+// Saves are issued with bounded concurrency, so storage queue does not hold data of all entities at once. Entities with
+// clients are saved first, so that players are saved before the deadline even if storage is slow.
+func SaveAllEntities(opts SaveAllOptions) SaveAllResult {
+	return saveEntities("save", opts, func(e *Entity, done storage.SaveCallbackFunc) bool {
+		return e.save(done)
+	})
+}
+
+// Run the operation on all entities by saving priority, the operation returns false if the entity is not saved and
+// done is never called
+func saveEntities(op string, opts SaveAllOptions, operate func(e *Entity, done storage.SaveCallbackFunc) bool) SaveAllResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = consts.SAVE_ALL_DEFAULT_CONCURRENCY
+	}
+
+	entities := entitiesBySavingPriority()
+	startTime := time.Now()
+	res := SaveAllResult{}
+	for _, e := range entities {
+		if e.isSavable() {
+			res.Total += 1
+		}
+	}
+
+	inProgress := 0
+	onSaved := func(err error) {
+		inProgress -= 1
+		if err != nil {
+			res.Failed += 1
+		} else {
+			res.Saved += 1
+		}
+	}
+	report := func() {
+		res.Elapsed = time.Since(startTime)
+		gwlog.Info("%s all entities: %d/%d saved, %d failed, %d in progress, elapsed %s", op, res.Saved, res.Total, res.Failed, inProgress, res.Elapsed)
+		if opts.Progress != nil {
+			gwutils.RunPanicless(func() {
+				opts.Progress(res)
+			})
+		}
+	}
+
+	lastReportTime := startTime
+	i := 0
+	for i < len(entities) || inProgress > 0 {
+		if !opts.Deadline.IsZero() && time.Now().After(opts.Deadline) {
+			gwlog.Error("%s all entities: deadline exceeded, %d entities are not saved", op, res.Total-res.Saved-res.Failed)
+			break
+		}
+
+		for i < len(entities) && inProgress < concurrency {
+			e := entities[i]
+			i += 1
+			if e.IsDestroyed() { // destroyed by other entities
+				continue
+			}
+			if operate(e, onSaved) {
+				inProgress += 1
+			}
+		}
+
+		if inProgress > 0 {
+			// save callbacks are posted by storage
+			time.Sleep(consts.SAVE_ALL_WAIT_INTERVAL)
+			post.Tick()
+		}
+
+		if time.Since(lastReportTime) >= consts.SAVE_ALL_PROGRESS_INTERVAL {
+			lastReportTime = time.Now()
+			report()
+		}
+	}
+
+	res.Skipped = res.Total - res.Saved - res.Failed
+	report()
+	return res
+}
+
+// Get all entities with entities with clients first, then other persistent entities
+func entitiesBySavingPriority() []*Entity {
+	var withClients, persistent, others []*Entity
 	for _, e := range entityManager.entities {
-		e.Save()
+		if e.client != nil {
+			withClients = append(withClients, e)
+		} else if e.isSavable() {
+			persistent = append(persistent, e)
+		} else {
+			others = append(others, e)
+		}
 	}
+	return append(append(withClients, persistent...), others...)
+}
+
+// Get IDs of local entities of the type as an EntityIDSet (do not modify it!)
+func GetEntitiesByType(typeName string) EntityIDSet {
+	return entityManager.typeIndex[typeName]
 }
 
 // Called by engine when server is freezing
 
 type FreezeData struct {
-	Entities map[EntityID]*entityFreezeData
-	Services map[string][]EntityID
+	Version      int            // format version, 0 if produced before versioning
+	TypeVersions map[string]int // versions of entity types defining freeze migrations
+	Entities     map[EntityID]*entityFreezeData
+	Services     map[string][]EntityID
+	ServiceGames map[EntityID]uint16
 }
 
 func Freeze(gameid uint16) (*FreezeData, error) {
-	freeze := FreezeData{}
+	return freezeEntities(gameid, false)
+}
+
+// Get freeze data of entities as a snapshot, entities keep running
+func Snapshot(gameid uint16) (*FreezeData, error) {
+	return freezeEntities(gameid, true)
+}
+
+func freezeEntities(gameid uint16, snapshot bool) (*FreezeData, error) {
+	freeze := FreezeData{Version: FREEZE_DATA_VERSION, TypeVersions: getFreezeTypeVersions()}
 
 	entityFreezeInfos := map[EntityID]*entityFreezeData{}
 	foundNilSpace := false
+	captured := 0
 	for _, e := range entityManager.entities {
-		entityFreezeInfos[e.ID] = e.GetFreezeData()
-		if e.IsSpaceEntity() {
-			if e.ToSpace().IsNil() {
-				if foundNilSpace {
-					return nil, errors.Errorf("found duplicate nil space")
-				}
-				foundNilSpace = true
+		var data *entityFreezeData
+		if snapshot {
+			data = e.getFreezeData(e.packTimers())
+		} else {
+			data = e.GetFreezeData()
+		}
+		if data.AttrsData != nil {
+			captured += 1
+		}
+		entityFreezeInfos[e.ID] = data
+	}
+	if incrementalFreezeEnabled {
+		gwlog.Info("Freeze: %d of %d entities are captured by incremental freeze", captured, len(entityFreezeInfos))
+	}
+	for eid := range entityManager.typeIndex[SPACE_ENTITY_TYPE] {
+		if entityManager.get(eid).ToSpace().IsNil() {
+			if foundNilSpace {
+				return nil, errors.Errorf("found duplicate nil space")
 			}
+			foundNilSpace = true
 		}
 	}
 
@@ -426,6 +821,7 @@ func Freeze(gameid uint16) (*FreezeData, error) {
 		registeredServices[serviceName] = eids.ToList()
 	}
 	freeze.Services = registeredServices
+	freeze.ServiceGames = entityManager.serviceGames
 
 	return &freeze, nil
 }
@@ -439,6 +835,13 @@ func RestoreFreezedEntities(freeze *FreezeData) (err error) {
 
 	}()
 
+	if err := unpackCapturedAttrs(freeze); err != nil {
+		return err
+	}
+	if err := migrateFreezeData(freeze); err != nil {
+		return err
+	}
+
 	restoreEntities := func(filter func(typeName string, spaceKind int64) bool) {
 		for eid, info := range freeze.Entities {
 			typeName := info.Type
@@ -458,6 +861,12 @@ func RestoreFreezedEntities(freeze *FreezeData) (err error) {
 				if info.Client != nil {
 					client = MakeGameClient(info.Client.ClientID, info.Client.GateID)
 				}
+				if info.Revision > 0 {
+					info.Attrs[storage_common.REVISION_KEY] = info.Revision
+				}
+				if info.Calendar != nil {
+					info.Attrs[_CALENDAR_SUBSCRIPTIONS_KEY] = info.Calendar
+				}
 				createEntity(typeName, space, info.Pos, eid, info.Attrs, info.TimerData, client, ccRestore)
 				gwlog.Info("Restored %s<%s> in space %s", typeName, eid, space)
 
@@ -482,10 +891,22 @@ func RestoreFreezedEntities(freeze *FreezeData) (err error) {
 		return typeName == SPACE_ENTITY_TYPE && spaceKind != 0
 	})
 
-	// step  3: restore all other spaces
-	restoreEntities(func(typeName string, spaceKind int64) bool {
-		return typeName != SPACE_ENTITY_TYPE
-	})
+	// step 3: restore all other entities, in order of restore dependencies of entity types
+	typeNames := StringSet{}
+	for _, info := range freeze.Entities {
+		if info.Type != SPACE_ENTITY_TYPE {
+			typeNames.Add(info.Type)
+		}
+	}
+	restoreOrder, err := getRestoreOrder(typeNames)
+	if err != nil {
+		return err
+	}
+	for _, restoreType := range restoreOrder {
+		restoreEntities(func(typeName string, spaceKind int64) bool {
+			return typeName == restoreType
+		})
+	}
 
 	for serviceName, _eids := range freeze.Services {
 		eids := EntityIDSet{}
@@ -494,6 +915,10 @@ func RestoreFreezedEntities(freeze *FreezeData) (err error) {
 		}
 		entityManager.registeredServices[serviceName] = eids
 	}
+	entityManager.serviceProviderLists = map[string][]EntityID{}
+	for eid, gameid := range freeze.ServiceGames {
+		entityManager.serviceGames[eid] = gameid
+	}
 
 	return nil
 }
@@ -2,11 +2,13 @@ package entity
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
 )
 
 type GameClient struct {
@@ -33,17 +35,17 @@ func (client *GameClient) SendCreateEntity(entity *Entity, isPlayer bool) {
 		return
 	}
 
-	var clientData map[string]interface{}
+	pos := entity.aoi.pos
+	yaw := entity.yaw
 	if !isPlayer {
-		clientData = entity.getAllClientData()
-	} else {
-		clientData = entity.getClientData()
+		// observers share the cached packed data, which avoids marshaling all client attrs per observer
+		dispatcher_client.GetDispatcherClientForSend().SendCreateEntityOnClientWithPackedData(client.gateid, client.clientid, entity.TypeName, entity.ID, false,
+			entity.getPackedAllClientData(), float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
+		return
 	}
 
-	pos := entity.aoi.pos
-	yaw := entity.yaw
-	dispatcher_client.GetDispatcherClientForSend().SendCreateEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID, isPlayer,
-		clientData, float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
+	dispatcher_client.GetDispatcherClientForSend().SendCreateEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID, true,
+		entity.getClientData(), float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
 }
 
 func (client *GameClient) SendDestroyEntity(entity *Entity) {
@@ -53,6 +55,26 @@ func (client *GameClient) SendDestroyEntity(entity *Entity) {
 	dispatcher_client.GetDispatcherClientForSend().SendDestroyEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID)
 }
 
+// Send the kick reason and message to client, and the gate closes the client connection afterwards
+func (client *GameClient) Kick(reason proto.KickReason, message string) {
+	if client == nil {
+		return
+	}
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s.Kick: reason=%d, message=%s", client, reason, message)
+	}
+	dispatcher_client.GetDispatcherClientForSend().SendKickClient(client.gateid, client.clientid, reason, message)
+}
+
+// Kick the client and let the gate ban the IP of client for the duration
+func (client *GameClient) Ban(duration time.Duration, message string) {
+	if client == nil {
+		return
+	}
+	gwlog.Info("%s.Ban: duration=%s, message=%s", client, duration, message)
+	dispatcher_client.GetDispatcherClientForSend().SendBanClient(client.gateid, client.clientid, duration, message)
+}
+
 func (client *GameClient) call(entityID common.EntityID, method string, args ...interface{}) {
 	if client == nil {
 		return
@@ -60,6 +82,13 @@ func (client *GameClient) call(entityID common.EntityID, method string, args ...
 	dispatcher_client.GetDispatcherClientForSend().SendCallEntityMethodOnClient(client.gateid, client.clientid, entityID, method, args)
 }
 
+func (client *GameClient) callAcked(entityID common.EntityID, method string, args ...interface{}) {
+	if client == nil {
+		return
+	}
+	dispatcher_client.GetDispatcherClientForSend().SendCallEntityMethodOnClientAcked(client.gateid, client.clientid, entityID, method, args)
+}
+
 func (client *GameClient) SendNotifyMapAttrChange(entityID common.EntityID, path []interface{}, key string, val interface{}) {
 	if client == nil {
 		return
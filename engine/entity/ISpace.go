@@ -8,3 +8,8 @@ type ISpace interface {
 	OnEntityEnterSpace(entity *Entity) // Called when any entity enters space
 	OnEntityLeaveSpace(entity *Entity) // Called when any entity leaves space
 }
+
+// Optional interface for spaces of replayable kinds
+type IReplayableSpace interface {
+	OnReplayTick(tick uint64) // Called every tick of replayable space, put deterministic logic here
+}
@@ -28,6 +28,7 @@ func (a *ListAttr) clearOwner() {
 
 func (a *ListAttr) Set(index int, val interface{}) {
 	a.items[index] = val
+	markAttrDirty(a, index)
 	if sa, ok := val.(*MapAttr); ok {
 		// val is ListAttr, set parent and owner accordingly
 		if sa.parent != nil || sa.owner != nil || sa.pkey != nil {
@@ -138,6 +139,7 @@ func (a *ListAttr) Pop() interface{} {
 	size := len(a.items)
 	val := a.items[size-1]
 	a.items = a.items[:size-1]
+	markAttrDirty(a, size-1)
 
 	if sa, ok := val.(*MapAttr); ok {
 		sa.clearOwner()
@@ -157,6 +159,7 @@ func (a *ListAttr) PopListAttr() *ListAttr {
 func (a *ListAttr) Append(val interface{}) {
 	a.items = append(a.items, val)
 	index := len(a.items) - 1
+	markAttrDirty(a, index)
 
 	if sa, ok := val.(*MapAttr); ok {
 		// val is ListAttr, set parent and owner accordingly
@@ -24,7 +24,13 @@ func (a *MapAttr) HasKey(key string) bool {
 }
 
 func (a *MapAttr) Set(key string, val interface{}) {
+	oldVal := a.attrs[key]
+	if owner := a.owner; owner != nil && a == owner.Attrs && owner.typeDesc.attrRateLimits != nil {
+		owner.checkAttrRate(key, oldVal, val)
+	}
+
 	a.attrs[key] = val
+	markAttrDirty(a, key)
 	if sa, ok := val.(*MapAttr); ok {
 		// val is MapAttr, set parent and owner accordingly
 		if sa.parent != nil || sa.owner != nil || sa.pkey != nil {
@@ -60,6 +66,10 @@ func (a *MapAttr) Set(key string, val interface{}) {
 	} else {
 		a.sendAttrChangeToClients(key, val)
 	}
+
+	if owner := a.owner; owner != nil && a == owner.Attrs {
+		owner.notifyAttrChange(key, oldVal, val)
+	}
 }
 func (a *MapAttr) SetDefault(key string, val interface{}) {
 	if _, ok := a.attrs[key]; !ok {
@@ -153,6 +163,7 @@ func (a *MapAttr) Pop(key string) interface{} {
 	}
 
 	delete(a.attrs, key)
+	markAttrDirty(a, key)
 	if sa, ok := val.(*MapAttr); ok {
 		sa.clearOwner()
 	} else if sa, ok := val.(*ListAttr); ok {
@@ -160,6 +171,9 @@ func (a *MapAttr) Pop(key string) interface{} {
 	}
 
 	a.sendAttrDelToClients(key)
+	if owner := a.owner; owner != nil && a == owner.Attrs {
+		owner.notifyAttrChange(key, val, nil)
+	}
 	return val
 }
 
@@ -3,6 +3,7 @@ package entity
 import (
 	"fmt"
 
+	"github.com/xiaonanln/goworld/engine/analytics"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
@@ -23,10 +24,16 @@ var (
 type Space struct {
 	Entity
 
-	entities EntitySet
-	Kind     int
-	I        ISpace
-	aoiCalc  AOICalculator
+	entities  EntitySet
+	Kind      int
+	I         ISpace
+	aoiCalc   AOICalculator
+	replay    *spaceReplay
+	instance  *spaceInstanceInfo
+	stitching *spaceStitching // nil if the space is not stitched
+
+	aoiBackend      string // name of AOI backend used by aoiCalc
+	reportedPlayers int    // player count last reported to the space instance service
 }
 
 func init() {
@@ -45,6 +52,7 @@ func (space *Space) OnInit() {
 	space.entities = EntitySet{}
 	space.I = space.Entity.I.(ISpace)
 	space.aoiCalc = newXZListAOICalculator()
+	space.aoiBackend = AOI_BACKEND_XZLIST
 	gwutils.RunPanicless(space.I.OnSpaceInit)
 }
 
@@ -82,6 +90,11 @@ func (space *Space) onSpaceCreated() {
 		gwlog.Info("Created nil space: %s", nilSpace)
 		return
 	}
+
+	space.initReplay()
+	if space.isServiceInstance() {
+		space.addRawTimer(consts.SPACE_INSTANCE_REPORT_INTERVAL, space.reportInstancePlayers)
+	}
 }
 
 func (space *Space) OnSpaceCreated() {
@@ -92,12 +105,16 @@ func (space *Space) OnSpaceCreated() {
 
 func (space *Space) OnDestroy() {
 	gwutils.RunPanicless(space.I.OnSpaceDestroy)
+	space.destroyStitching()
 	// destroy all entities
 	for e := range space.entities {
 		e.Destroy()
 	}
 
 	spaceManager.delSpace(space.ID)
+	if space.isServiceInstance() {
+		callSpaceInstanceService("SpaceInstanceDestroyed", space.ID)
+	}
 }
 
 func (space *Space) OnSpaceDestroy() {
@@ -115,7 +132,12 @@ func (space *Space) CreateEntity(typeName string, pos Position) {
 }
 
 func (space *Space) LoadEntity(typeName string, entityID common.EntityID, pos Position) {
-	loadEntityLocally(typeName, entityID, space, pos)
+	loadEntityLocally(typeName, entityID, space, pos, nil)
+}
+
+// Load entity into the space, the callback is called with error if the entity is not loaded
+func (space *Space) LoadEntityWithCallback(typeName string, entityID common.EntityID, pos Position, callback CreateEntityCallback) {
+	loadEntityLocally(typeName, entityID, space, pos, callback)
 }
 
 func (space *Space) enter(entity *Entity, pos Position, isRestore bool) {
@@ -131,8 +153,10 @@ func (space *Space) enter(entity *Entity, pos Position, isRestore bool) {
 		return
 	}
 
+	space.removeGhostOf(entity.ID)
 	entity.Space = space
 	space.entities.Add(entity)
+	space.onPlayerEntered(entity)
 
 	space.aoiCalc.Enter(&entity.aoi, pos)
 	entity.syncInfoFlag |= (sifSyncOwnClient | sifSyncNeighborClients)
@@ -140,28 +164,19 @@ func (space *Space) enter(entity *Entity, pos Position, isRestore bool) {
 	if !isRestore {
 		entity.client.SendCreateEntity(&space.Entity, false) // create Space entity before every other entities
 
-		enter, _ := space.aoiCalc.Adjust(&entity.aoi)
-		// gwlog.Info("Entity %s entering at pos %v: %v: enter %d neighbors", entity, pos, entity.GetPosition(), len(enter))
-
-		for _, naoi := range enter {
-			neighbor := naoi.getEntity()
-			entity.interest(neighbor)
-			neighbor.interest(entity)
-		}
+		space.adjustAOI(entity, true)
 
+		entity.emitAnalytics(analytics.EVENT_SPACE_ENTER, "space_id", string(space.ID), "space_kind", space.Kind)
 		gwutils.RunPanicless(func() {
 			space.I.OnEntityEnterSpace(entity)
 			entity.I.OnEnterSpace()
 		})
+		entity.callComponentHooks(IComponent.OnEnterSpace)
 	} else {
-		enter, _ := space.aoiCalc.Adjust(&entity.aoi)
-		for _, naoi := range enter {
-			neighbor := naoi.getEntity()
-			entity.aoi.interest(neighbor)
-			neighbor.aoi.interest(entity)
-		}
+		space.adjustAOI(entity, false)
 	}
 
+	space.checkAOIBackendThresholds()
 	//space.verifyAOICorrectness(entity)
 }
 
@@ -174,41 +189,84 @@ func (space *Space) leave(entity *Entity) {
 		return
 	}
 
-	for neighbor := range entity.aoi.neighbors {
-		entity.uninterest(neighbor)
-		neighbor.uninterest(entity)
-	}
-	space.aoiCalc.Leave(&entity.aoi)
+	space.removeFromAOI(entity)
 	entity.client.SendDestroyEntity(&space.Entity)
 	// remove from Space entities
 	space.entities.Del(entity)
 	entity.Space = nilSpace
+	space.checkAOIBackendThresholds()
+	entity.emitAnalytics(analytics.EVENT_SPACE_LEAVE, "space_id", string(space.ID), "space_kind", space.Kind)
 
 	gwutils.RunPanicless(func() {
 		space.I.OnEntityLeaveSpace(entity)
 	})
 
 	entity.I.OnLeaveSpace(space)
+	entity.callComponentHooks(func(c IComponent) {
+		c.OnLeaveSpace(space)
+	})
 }
 
 func (space *Space) move(entity *Entity, newPos Position) {
 	space.aoiCalc.Move(&entity.aoi, newPos)
+	space.adjustAOI(entity, true)
+	if space.stitching != nil {
+		space.checkStitchBorder(entity, newPos)
+	}
+
+	//space.verifyAOICorrectness(entity)
+	//opmon.Finish(time.Millisecond * 10)
+}
+
+// Adjust nearby entities of entity, and update interests in both directions by AOI distances
+//
+// Visibility is asymmetric if entities have different AOI distances: an entity is interested in others within its own
+// AOI distance, so it might be seen by others which it does not see.
+func (space *Space) adjustAOI(entity *Entity, notifyClients bool) {
 	enter, leave := space.aoiCalc.Adjust(&entity.aoi)
 
 	for _, naoi := range leave {
-		neighbor := naoi.getEntity()
-		entity.uninterest(neighbor)
-		neighbor.uninterest(entity)
+		other := naoi.getEntity()
+		entity.aoi.nearby.Del(other)
+		other.aoi.nearby.Del(entity)
+		updateInterest(entity, other, notifyClients)
+		updateInterest(other, entity, notifyClients)
 	}
 
 	for _, naoi := range enter {
-		neighbor := naoi.getEntity()
-		entity.interest(neighbor)
-		neighbor.interest(entity)
+		other := naoi.getEntity()
+		entity.aoi.nearby.Add(other)
+		other.aoi.nearby.Add(entity)
 	}
 
-	//space.verifyAOICorrectness(entity)
-	//opmon.Finish(time.Millisecond * 10)
+	// interests of nearby entities might change without entering or leaving, e.g. AOI distance changed
+	for other := range entity.aoi.nearby {
+		updateInterest(entity, other, notifyClients)
+		updateInterest(other, entity, notifyClients)
+	}
+}
+
+// Update the interest of entity in other according to the AOI distance of entity
+func updateInterest(entity *Entity, other *Entity, notifyClient bool) {
+	covered := entity.aoi.nearby.Contains(other) && entity.aoi.covers(&other.aoi)
+	interested := entity.aoi.neighbors.Contains(other)
+	if covered == interested {
+		return
+	}
+
+	if covered {
+		if notifyClient {
+			entity.interest(other)
+		} else {
+			entity.aoi.interest(other)
+		}
+	} else {
+		if notifyClient {
+			entity.uninterest(other)
+		} else {
+			entity.aoi.uninterest(other)
+		}
+	}
 }
 
 //func (space *Space) verifyAOICorrectness(entity *Entity) {
@@ -265,3 +323,18 @@ func (space *Space) GetEntityCount() int {
 func (space *Space) addToAOI(entity *Entity) {
 
 }
+
+// Remove entity from AOI, and uninterest nearby entities in both directions
+func (space *Space) removeFromAOI(entity *Entity) {
+	for other := range entity.aoi.nearby {
+		entity.aoi.nearby.Del(other)
+		other.aoi.nearby.Del(entity)
+		if entity.aoi.neighbors.Contains(other) {
+			entity.uninterest(other)
+		}
+		if other.aoi.neighbors.Contains(entity) {
+			other.uninterest(entity)
+		}
+	}
+	space.aoiCalc.Leave(&entity.aoi)
+}
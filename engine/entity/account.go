@@ -0,0 +1,276 @@
+package entity
+
+import (
+	"time"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// AccountEntity is the engine-managed login entity, which should be the boot entity of gates.
+//
+// Account types embed AccountEntity instead of Entity, and set the avatar type with desc.SetAvatarType. The login process:
+//
+//	client calls Login(username, password)
+//	  -> Authenticate: check the credentials, rejects all by default
+//	  -> the login session is registered with the duplicate-login policy of dispatcher
+//	  -> OnLogin: called with the result, calls client method OnLogin(ok) by default
+//	  -> OnSelectAvatar: select the avatar bound to the account (nil if none), then call SelectAvatar
+//	  -> the avatar is created or loaded, the account migrates to the space of avatar if it is on other game
+//	  -> the client is given to the avatar, and the account is destroyed
+//
+// OnLogout is called if the client disconnects before it is given to the avatar, then the account is destroyed.
+//
+// Avatars are bound to usernames in KVDB by ACCOUNT_AVATAR_KVDB_KEY_PREFIX+username. Bindings saved by the bare
+// username, as the login example did before AccountEntity, are still read if the username has no binding, and
+// saved to the new key after the avatar is selected, so existing players keep their avatars.
+
+const (
+	ACCOUNT_AVATAR_KVDB_KEY_PREFIX = "__account_avatar__:" // KVDB key of the avatar bound to each username
+	ACCOUNT_CLIENT_LOGIN_METHOD    = "OnLogin"             // client method called with the login result (ok bool)
+
+	_ACCOUNT_LOGIN_AVATAR_ATTR_KEY  = "_loginAvatarID"
+	_ACCOUNT_ENTER_AVATAR_RETRY     = time.Second
+	_ACCOUNT_ENTER_AVATAR_MAX_RETRY = 10
+)
+
+// Hooks of account entities, all have default implementations in AccountEntity
+type IAccount interface {
+	// Check the credentials of login, call the callback with the result
+	Authenticate(username string, password string, callback func(ok bool))
+	// Called when the login is accepted or rejected
+	OnLogin(username string, ok bool)
+	// Select the avatar to enter after login, avatarID is the avatar bound to the account, nil if none
+	//
+	// Override to let client choose the avatar, SelectAvatar should be called after the avatar is chosen
+	OnSelectAvatar(avatarID EntityID)
+	// Called when the client disconnects before entering the avatar
+	OnLogout()
+}
+
+// The account entity to be embedded by account types
+type AccountEntity struct {
+	Entity
+
+	username      string
+	logining      bool
+	enterRetries  int
+	enteredAvatar bool // the client is given to the avatar
+}
+
+// Set the avatar type created for accounts without avatars, only for account types
+func (desc *EntityTypeDesc) SetAvatarType(avatarType string) {
+	desc.avatarType = avatarType
+}
+
+// Get the username of logged in account, empty if not logged in
+func (a *AccountEntity) GetUsername() string {
+	return a.username
+}
+
+func (a *AccountEntity) iAccount() IAccount {
+	return a.I.(IAccount)
+}
+
+// Called by client to login with username and password
+func (a *AccountEntity) Login_Client(username string, password string) {
+	if a.logining || a.username != "" {
+		gwlog.Error("%s is already logining or logged in", a)
+		return
+	}
+
+	a.logining = true
+	gwutils.RunPanicless(func() {
+		a.iAccount().Authenticate(username, password, func(ok bool) {
+			if a.IsDestroyed() {
+				return
+			}
+
+			if !ok {
+				a.onLoginFinished(username, false)
+				return
+			}
+
+			a.RegisterLoginSession(username, func(ok bool, err error) {
+				if a.IsDestroyed() {
+					return
+				}
+				if err != nil {
+					gwlog.Error("%s: register login of %s failed: %s", a, username, err)
+				}
+				a.onLoginFinished(username, ok)
+			})
+		})
+	})
+}
+
+func (a *AccountEntity) onLoginFinished(username string, ok bool) {
+	a.logining = false
+	if ok {
+		a.username = username
+	}
+
+	gwutils.RunPanicless(func() {
+		a.iAccount().OnLogin(username, ok)
+	})
+	if !ok {
+		return
+	}
+
+	getAccountAvatar(username, func(avatarID EntityID, err error) {
+		if a.IsDestroyed() {
+			return
+		}
+		if err != nil {
+			gwlog.Error("%s: get avatar of %s failed: %s", a, username, err)
+			a.KickClient(proto.KICK_REASON_NONE, "get avatar failed")
+			return
+		}
+
+		gwutils.RunPanicless(func() {
+			a.iAccount().OnSelectAvatar(avatarID)
+		})
+	})
+}
+
+// Get the avatar bound to the username, the legacy binding by the bare username is read if there is no binding
+func getAccountAvatar(username string, callback func(avatarID EntityID, err error)) {
+	kvdb.Get(ACCOUNT_AVATAR_KVDB_KEY_PREFIX+username, func(val string, err error) {
+		if err != nil || val != "" {
+			callback(EntityID(val), err)
+			return
+		}
+
+		kvdb.Get(username, func(val string, err error) {
+			if err != nil {
+				callback("", err)
+			} else if len(val) == ENTITYID_LENGTH {
+				gwlog.Info("Avatar %s of %s is read from the legacy binding", val, username)
+				callback(EntityID(val), nil)
+			} else {
+				callback("", nil) // not a legacy binding
+			}
+		})
+	})
+}
+
+// Rejects all logins by default, should be overridden to check credentials
+func (a *AccountEntity) Authenticate(username string, password string, callback func(ok bool)) {
+	gwlog.Error("%s: Authenticate is not implemented, login of %s is rejected", a, username)
+	callback(false)
+}
+
+// Calls client method OnLogin with the result by default
+func (a *AccountEntity) OnLogin(username string, ok bool) {
+	a.CallClient(ACCOUNT_CLIENT_LOGIN_METHOD, ok)
+}
+
+// Selects the avatar bound to the account by default, a new avatar is created if none
+func (a *AccountEntity) OnSelectAvatar(avatarID EntityID) {
+	a.SelectAvatar(avatarID)
+}
+
+func (a *AccountEntity) OnLogout() {
+}
+
+// Enter the avatar after login, a new avatar is created and bound to the account if avatarID is nil
+func (a *AccountEntity) SelectAvatar(avatarID EntityID) {
+	if a.username == "" {
+		gwlog.Error("%s.SelectAvatar: not logged in", a)
+		return
+	}
+
+	avatarType := a.typeDesc.avatarType
+	if avatarType == "" {
+		gwlog.Panicf("%s.SelectAvatar: avatar type is not set", a)
+	}
+
+	if avatarID.IsNil() {
+		avatarID = CreateEntityLocally(avatarType, nil, nil)
+		gwlog.Info("%s: created avatar %s for %s", a, avatarID, a.username)
+	}
+	kvdb.Put(ACCOUNT_AVATAR_KVDB_KEY_PREFIX+a.username, string(avatarID), nil)
+
+	a.Attrs.Set(_ACCOUNT_LOGIN_AVATAR_ATTR_KEY, string(avatarID))
+	a.enterAvatar()
+}
+
+// Give client to the avatar if it is local, or load the avatar anywhere and migrate to its space
+func (a *AccountEntity) enterAvatar() {
+	avatarID := EntityID(a.Attrs.GetStr(_ACCOUNT_LOGIN_AVATAR_ATTR_KEY))
+	if avatar := entityManager.get(avatarID); avatar != nil {
+		a.giveClientToAvatar(avatar)
+		return
+	}
+
+	LoadEntityAnywhere(a.typeDesc.avatarType, avatarID)
+	a.CallWithResult(avatarID, "GetSpaceIDForAccount").Then(func(results RpcResults, err error) {
+		if a.IsDestroyed() || a.enteredAvatar {
+			return
+		}
+
+		var spaceID EntityID
+		if err == nil {
+			err = results.Decode(&spaceID)
+		}
+		if err != nil || spaceID.IsNil() {
+			gwlog.Warn("%s: get space of avatar %s failed: %v, space=%s", a, avatarID, err, spaceID)
+			a.retryEnterAvatar()
+			return
+		}
+
+		if avatar := entityManager.get(avatarID); avatar != nil {
+			a.giveClientToAvatar(avatar) // avatar is loaded to this game
+		} else {
+			a.EnterSpace(spaceID, Position{})
+		}
+	})
+}
+
+func (a *AccountEntity) retryEnterAvatar() {
+	a.enterRetries += 1
+	if a.enterRetries > _ACCOUNT_ENTER_AVATAR_MAX_RETRY {
+		gwlog.Error("%s: enter avatar failed after %d retries", a, _ACCOUNT_ENTER_AVATAR_MAX_RETRY)
+		a.KickClient(proto.KICK_REASON_NONE, "enter avatar failed")
+		return
+	}
+	a.addRawCallback(_ACCOUNT_ENTER_AVATAR_RETRY, a.enterAvatar)
+}
+
+// Called by engine after the account migrates to the space of avatar
+func (a *AccountEntity) onAccountMigrateIn() {
+	if a.Attrs.HasKey(_ACCOUNT_LOGIN_AVATAR_ATTR_KEY) && !a.enteredAvatar {
+		a.enterAvatar()
+	}
+}
+
+func (a *AccountEntity) giveClientToAvatar(avatar *Entity) {
+	a.enteredAvatar = true
+	a.GiveClientTo(avatar)
+	a.Destroy()
+}
+
+func (a *AccountEntity) OnClientDisconnected() {
+	if a.enteredAvatar {
+		return // client is given to avatar
+	}
+
+	gwutils.RunPanicless(a.iAccount().OnLogout)
+	a.Destroy()
+}
+
+// Get the space ID of entity for accounts entering it, returns nil if the entity is not in any space
+func (e *Entity) GetSpaceIDForAccount() EntityID {
+	if e.Space == nil || e.Space.IsNil() {
+		return ""
+	}
+	return e.Space.ID
+}
+
+// Internal interface of account entities for handling migration
+type iAccountMigrateIn interface {
+	onAccountMigrateIn()
+}
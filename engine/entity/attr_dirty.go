@@ -0,0 +1,114 @@
+package entity
+
+import (
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Partial save writes only persistent attributes changed since the last save, instead of all persistent data.
+//
+// Changes in MapAttr and ListAttr mark the top-level attribute they belong to as dirty. The first save of an entity
+// on each game is always a full save, because the entity may be created, loaded or migrated in with unsaved changes.
+// Partial save is used only if the storage engine supports it, see storage.IsPartialWriteSupported.
+
+// Enable partial save for entities of this type
+//
+// Only for types using the default GetPersistentData, since the patch is built from persistent attributes
+func (desc *EntityTypeDesc) EnablePartialSave() {
+	desc.partialSave = true
+}
+
+// Find the entity and the top-level attribute which the attr (with the key in it) belongs to
+func getAttrRoot(attr interface{}, key interface{}) (*Entity, string) {
+	for {
+		if ma, ok := attr.(*MapAttr); ok {
+			if ma.parent == nil {
+				if ma.owner == nil || ma.owner.Attrs != ma {
+					return nil, ""
+				}
+				rootKey, _ := key.(string)
+				return ma.owner, rootKey
+			}
+			attr, key = ma.parent, ma.pkey
+		} else {
+			la := attr.(*ListAttr)
+			if la.parent == nil {
+				return nil, ""
+			}
+			attr, key = la.parent, la.pkey
+		}
+	}
+}
+
+// Mark the top-level attribute of the changed attr as dirty, and the owner entity for replicating to standby games
+// and capturing freeze data. Copies of the top-level attribute for attr snapshots are dropped.
+func markAttrDirty(attr interface{}, key interface{}) {
+	owner, rootKey := getAttrRoot(attr, key)
+	if owner == nil {
+		return
+	}
+	if owner.dirtyAttrs != nil && owner.typeDesc.persistentAttrs.Contains(rootKey) {
+		owner.dirtyAttrs.Add(rootKey)
+	}
+	owner.dropSnapshotCopy(rootKey)
+	owner.markReplicaDirty()
+	owner.markFreezeDirty()
+}
+
+// Pop the patch of dirty persistent attributes
+func (e *Entity) popPersistentPatch() storage_common.EntityDataPatch {
+	patch := storage_common.EntityDataPatch{
+		Set: map[string]interface{}{},
+	}
+
+	for key := range e.dirtyAttrs {
+		if !e.Attrs.HasKey(key) {
+			patch.Unset = append(patch.Unset, key)
+			continue
+		}
+
+		val := e.Attrs.attrs[key]
+		if ma, ok := val.(*MapAttr); ok {
+			patch.Set[key] = ma.ToMap()
+		} else if la, ok := val.(*ListAttr); ok {
+			patch.Set[key] = la.ToList()
+		} else {
+			patch.Set[key] = val
+		}
+	}
+
+	e.dirtyAttrs = StringSet{}
+	return patch
+}
+
+func (e *Entity) savePersistentData(callback storage.SaveCallbackFunc) {
+	if e.dirtyAttrs != nil && !e.fullSaveNeeded && storage.IsPartialWriteSupported() {
+		storage.SavePartial(e.TypeName, e.ID, e.popPersistentPatch(), callback)
+		return
+	}
+
+	data := e.I.GetPersistentData()
+	if storage.IsRevisionCheckEnabled() {
+		e.storageRevision += 1
+		data = e.putStorageRevision(data)
+		callback = e.checkSaveRevision(callback)
+	}
+	if e.dirtyAttrs != nil {
+		e.dirtyAttrs = StringSet{}
+		e.fullSaveNeeded = false
+	}
+	storage.Save(e.TypeName, e.ID, data, callback)
+}
+
+// Put the storage revision in data, so that the entity is saved with the revision after loaded by data
+func (e *Entity) putStorageRevision(data map[string]interface{}) map[string]interface{} {
+	if e.storageRevision == 0 {
+		return data
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data[storage_common.REVISION_KEY] = e.storageRevision
+	return data
+}
@@ -0,0 +1,116 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Expected bound of attribute increase rate, e.g. gold per minute
+type attrRateLimit struct {
+	maxIncrease float64
+	per         time.Duration
+}
+
+type attrRateTracker struct {
+	windowStart time.Time
+	increase    float64
+	reported    bool
+}
+
+// Optional interface for entities to handle attribute rate anomalies
+type IAttrRateAnomalyHandler interface {
+	OnAttrRateAnomaly(key string, increase float64, per time.Duration) // Called when attribute increases faster than declared bound
+}
+
+var (
+	attrRateAnomalyCallback func(e *Entity, key string, increase float64, per time.Duration)
+)
+
+// Declare the expected bound of increase rate of the root attribute, e.g. at most 1000 gold per minute
+//
+// The engine tracks increases of numeric values of the attribute, and reports an anomaly when the bound is exceeded
+func (desc *EntityTypeDesc) DefineAttrRateLimit(attr string, maxIncrease float64, per time.Duration) {
+	if desc.attrRateLimits == nil {
+		desc.attrRateLimits = map[string]attrRateLimit{}
+	}
+	desc.attrRateLimits[attr] = attrRateLimit{maxIncrease: maxIncrease, per: per}
+}
+
+// Set the global callback for attribute rate anomalies of all entities, useful for metrics & anti-cheat logs
+func SetAttrRateAnomalyCallback(cb func(e *Entity, key string, increase float64, per time.Duration)) {
+	attrRateAnomalyCallback = cb
+}
+
+func (e *Entity) checkAttrRate(key string, oldVal interface{}, newVal interface{}) {
+	limit, ok := e.typeDesc.attrRateLimits[key]
+	if !ok || oldVal == nil {
+		return
+	}
+
+	oldNum, ok1 := toFloat64(oldVal)
+	newNum, ok2 := toFloat64(newVal)
+	if !ok1 || !ok2 || newNum <= oldNum {
+		return
+	}
+
+	if e.attrRateTrackers == nil {
+		e.attrRateTrackers = map[string]*attrRateTracker{}
+	}
+	tracker := e.attrRateTrackers[key]
+	now := time.Now()
+	if tracker == nil || now.Sub(tracker.windowStart) >= limit.per {
+		tracker = &attrRateTracker{windowStart: now}
+		e.attrRateTrackers[key] = tracker
+	}
+
+	tracker.increase += newNum - oldNum
+	if tracker.increase <= limit.maxIncrease || tracker.reported {
+		return
+	}
+
+	tracker.reported = true // report at most once per window
+	gwlog.Warn("%s: attribute %s increased %v in %s, exceeds %v", e, key, tracker.increase, limit.per, limit.maxIncrease)
+	if attrRateAnomalyCallback != nil {
+		gwutils.RunPanicless(func() {
+			attrRateAnomalyCallback(e, key, tracker.increase, limit.per)
+		})
+	}
+	if handler, ok := e.I.(IAttrRateAnomalyHandler); ok {
+		gwutils.RunPanicless(func() {
+			handler.OnAttrRateAnomaly(key, tracker.increase, limit.per)
+		})
+	}
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
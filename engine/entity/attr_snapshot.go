@@ -0,0 +1,201 @@
+package entity
+
+import (
+	"reflect"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Snapshot of entity attributes, which can be restored to roll back attribute changes
+//
+// Nested MapAttr and ListAttr are copied as plain maps and lists when the snapshot is taken, so the snapshot is
+// not affected by later changes and can be restored for multiple times. Copies of nested attributes are kept by the
+// entity and shared by later snapshots until the attributes are changed, so only changed top-level attributes are
+// copied again. The copies cost as much memory as the nested attributes of the entity, and are kept as long as the
+// entity exists.
+type AttrSnapshot struct {
+	owner   *Entity
+	attrs   map[string]interface{} // key -> captured value
+	missing StringSet              // keys not existing when snapshot is taken
+	keys    []string
+}
+
+// Capture a snapshot of the specified attributes, or all attributes if no key is specified
+func (e *Entity) SnapshotAttrs(keys ...string) *AttrSnapshot {
+	if len(keys) == 0 {
+		keys = e.Attrs.GetKeys()
+	}
+
+	s := &AttrSnapshot{
+		owner:   e,
+		attrs:   make(map[string]interface{}, len(keys)),
+		missing: StringSet{},
+		keys:    keys,
+	}
+
+	for _, key := range keys {
+		val, ok := e.Attrs.attrs[key]
+		if !ok {
+			s.missing.Add(key)
+			continue
+		}
+
+		s.attrs[key] = e.getSnapshotCopy(key, val)
+	}
+	return s
+}
+
+// Copy of a nested attribute, shared by snapshots until the attribute is changed
+type attrSnapshotCopy struct {
+	attr interface{} // the copied *MapAttr or *ListAttr
+	val  interface{}
+}
+
+// Get the copy of top-level attribute for snapshots, copies of unchanged nested attributes are reused
+func (e *Entity) getSnapshotCopy(key string, val interface{}) interface{} {
+	var copied interface{}
+	if ma, ok := val.(*MapAttr); ok {
+		if c, ok := e.snapshotCopies[key]; ok && c.attr == val {
+			return c.val
+		}
+		copied = ma.ToMap()
+	} else if la, ok := val.(*ListAttr); ok {
+		if c, ok := e.snapshotCopies[key]; ok && c.attr == val {
+			return c.val
+		}
+		copied = la.ToList()
+	} else {
+		return val
+	}
+
+	if e.snapshotCopies == nil {
+		e.snapshotCopies = map[string]attrSnapshotCopy{}
+	}
+	e.snapshotCopies[key] = attrSnapshotCopy{attr: val, val: copied}
+	return copied
+}
+
+// Drop the copy of changed top-level attribute
+func (e *Entity) dropSnapshotCopy(key string) {
+	if e.snapshotCopies != nil {
+		delete(e.snapshotCopies, key)
+	}
+}
+
+// Restore attributes to the snapshot
+//
+// Only attributes captured by the snapshot are restored. Nested MapAttr and ListAttr are restored in place, so
+// references to them held by game code are still valid, and only changed values are set, so unchanged values are
+// not synced to clients and attr watchers are not notified for them.
+func (e *Entity) RestoreSnapshot(s *AttrSnapshot) {
+	if s.owner != e {
+		gwlog.Panicf("%s.RestoreSnapshot: snapshot is taken from %s", e, s.owner)
+	}
+
+	attrs := e.Attrs
+	for _, key := range s.keys {
+		if s.missing.Contains(key) {
+			if attrs.HasKey(key) {
+				attrs.Del(key)
+			}
+			continue
+		}
+		restoreMapAttrItem(attrs, key, s.attrs[key])
+	}
+}
+
+// Restore the value of key in MapAttr, nested attributes of the same kind are restored in place
+func restoreMapAttrItem(a *MapAttr, key string, val interface{}) {
+	cur, exists := a.attrs[key]
+	if exists {
+		if restoreNestedAttr(cur, val) || isSnapshotValueUnchanged(cur, val) {
+			return
+		}
+
+		switch cur.(type) {
+		case *MapAttr, *ListAttr:
+			a.Del(key) // the nested attribute is replaced by value of other kind
+		}
+	}
+	a.Set(key, snapshotValueToAttr(val))
+}
+
+// Restore the item of ListAttr, nested attributes of the same kind are restored in place
+func restoreListAttrItem(a *ListAttr, index int, val interface{}) {
+	cur := a.items[index]
+	if restoreNestedAttr(cur, val) || isSnapshotValueUnchanged(cur, val) {
+		return
+	}
+
+	switch ca := cur.(type) {
+	case *MapAttr:
+		ca.clearOwner()
+	case *ListAttr:
+		ca.clearOwner()
+	}
+	a.Set(index, snapshotValueToAttr(val))
+}
+
+// Restore the nested attribute in place if the captured value is of the same kind, returns false otherwise
+func restoreNestedAttr(cur interface{}, val interface{}) bool {
+	if ma, ok := cur.(*MapAttr); ok {
+		doc, ok := val.(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		for _, key := range ma.GetKeys() {
+			if _, ok := doc[key]; !ok {
+				ma.Del(key)
+			}
+		}
+		for key, v := range doc {
+			restoreMapAttrItem(ma, key, v)
+		}
+		return true
+	} else if la, ok := cur.(*ListAttr); ok {
+		list, ok := val.([]interface{})
+		if !ok {
+			return false
+		}
+
+		for la.Size() > len(list) {
+			la.Pop()
+		}
+		for i, v := range list {
+			if i < la.Size() {
+				restoreListAttrItem(la, i, v)
+			} else {
+				la.Append(snapshotValueToAttr(v))
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func snapshotValueToAttr(val interface{}) interface{} {
+	if iv, ok := val.(map[string]interface{}); ok {
+		ia := NewMapAttr()
+		ia.AssignMap(iv)
+		return ia
+	} else if iv, ok := val.([]interface{}); ok {
+		ia := NewListAttr()
+		ia.AssignList(iv)
+		return ia
+	}
+	return val
+}
+
+func isSnapshotValueUnchanged(cur interface{}, val interface{}) bool {
+	switch cur.(type) {
+	case *MapAttr, *ListAttr:
+		return false
+	}
+
+	if reflect.TypeOf(cur) != reflect.TypeOf(val) {
+		return false
+	}
+	return reflect.DeepEqual(cur, val)
+}
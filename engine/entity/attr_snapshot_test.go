@@ -0,0 +1,149 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func newTestAttrsEntity() *Entity {
+	e := &Entity{typeDesc: &EntityTypeDesc{}, attrsLoaded: true}
+	e.Attrs = NewMapAttr()
+	e.Attrs.owner = e
+	return e
+}
+
+func TestRestoreSnapshot(t *testing.T) {
+	e := newTestAttrsEntity()
+	e.Attrs.Set("gold", 100)
+	e.Attrs.Set("name", "knight")
+	bag := NewMapAttr()
+	e.Attrs.Set("bag", bag)
+	bag.Set("sword", 1)
+	bag.Set("shield", 1)
+	quests := NewListAttr()
+	e.Attrs.Set("quests", quests)
+	quests.Append("q1")
+	quests.Append("q2")
+	original := e.Attrs.ToMap()
+
+	s := e.SnapshotAttrs("gold", "name", "bag", "quests", "title")
+
+	e.Attrs.Set("gold", 50)
+	bag.Del("shield")
+	bag.Set("potion", 3)
+	quests.Pop()
+	quests.Append("q3")
+	quests.Append("q4")
+	e.Attrs.Set("title", "hero")
+
+	changes := map[string]int{}
+	for _, key := range []string{"gold", "name", "bag", "quests", "title"} {
+		key := key
+		e.WatchAttr(key, func(oldVal, newVal interface{}) {
+			changes[key] += 1
+		})
+	}
+
+	e.RestoreSnapshot(s)
+	if !reflect.DeepEqual(e.Attrs.ToMap(), original) {
+		t.Fatalf("attrs not restored: %v, should be %v", e.Attrs.ToMap(), original)
+	}
+	if e.Attrs.GetMapAttr("bag") != bag || e.Attrs.GetListAttr("quests") != quests {
+		t.Errorf("nested attrs should be restored in place")
+	}
+	if !reflect.DeepEqual(changes, map[string]int{"gold": 1, "title": 1}) {
+		t.Errorf("only changed attrs should be set: %v", changes)
+	}
+
+	// restoring again changes nothing
+	changes = map[string]int{}
+	e.RestoreSnapshot(s)
+	if len(changes) != 0 {
+		t.Errorf("unchanged attrs should not be set: %v", changes)
+	}
+
+	// the snapshot is not affected by changes after taken
+	bag.Set("sword", 2)
+	e.Attrs.Del("quests")
+	e.Attrs.Set("quests", "none")
+	e.RestoreSnapshot(s)
+	if !reflect.DeepEqual(e.Attrs.ToMap(), original) {
+		t.Errorf("attrs not restored: %v, should be %v", e.Attrs.ToMap(), original)
+	}
+}
+
+func TestRestoreSnapshotNestedList(t *testing.T) {
+	e := newTestAttrsEntity()
+	slots := NewListAttr()
+	e.Attrs.Set("slots", slots)
+	slot := NewMapAttr()
+	slots.Append(slot)
+	slot.Set("item", "sword")
+	slots.Append(1)
+	original := e.Attrs.ToMap()
+
+	s := e.SnapshotAttrs()
+	slot.Set("item", "axe")
+	slots.Set(1, 2)
+	slots.Append(3)
+
+	e.RestoreSnapshot(s)
+	if !reflect.DeepEqual(e.Attrs.ToMap(), original) {
+		t.Fatalf("attrs not restored: %v, should be %v", e.Attrs.ToMap(), original)
+	}
+	if slots.Get(0) != slot {
+		t.Errorf("nested attrs in list should be restored in place")
+	}
+}
+
+func TestSnapshotSharesUnchangedAttrs(t *testing.T) {
+	e := newTestAttrsEntity()
+	bag := NewMapAttr()
+	e.Attrs.Set("bag", bag)
+	sword := NewMapAttr()
+	bag.Set("sword", sword)
+	sword.Set("level", 1)
+	quests := NewListAttr()
+	e.Attrs.Set("quests", quests)
+	quests.Append("q1")
+	original := e.Attrs.ToMap()
+
+	isShared := func(s1, s2 *AttrSnapshot, key string) bool {
+		return reflect.ValueOf(s1.attrs[key]).Pointer() == reflect.ValueOf(s2.attrs[key]).Pointer()
+	}
+
+	s1 := e.SnapshotAttrs()
+	sword.Set("level", 2)
+	s2 := e.SnapshotAttrs()
+	if !isShared(s1, s2, "quests") {
+		t.Errorf("copy of unchanged attr should be shared")
+	}
+	if isShared(s1, s2, "bag") {
+		t.Errorf("attr changed in nested attr should be copied again")
+	}
+
+	// replacing the attr with a new one of the same kind
+	quests = NewListAttr()
+	e.Attrs.Set("quests", quests)
+	s3 := e.SnapshotAttrs()
+	if isShared(s2, s3, "quests") || !isShared(s2, s3, "bag") {
+		t.Errorf("only the replaced attr should be copied again")
+	}
+
+	e.RestoreSnapshot(s1)
+	if !reflect.DeepEqual(e.Attrs.ToMap(), original) {
+		t.Fatalf("attrs not restored: %v, should be %v", e.Attrs.ToMap(), original)
+	}
+	if s4 := e.SnapshotAttrs(); !reflect.DeepEqual(s4.attrs, s1.attrs) || isShared(s3, s4, "bag") {
+		t.Errorf("attrs restored should be copied again: %v", s4.attrs)
+	}
+}
+
+func TestRestoreSnapshotOfOtherEntity(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("restoring snapshot of other entity should panic")
+		}
+	}()
+	newTestAttrsEntity().RestoreSnapshot(newTestAttrsEntity().SnapshotAttrs())
+}
@@ -0,0 +1,84 @@
+package entity
+
+import (
+	"strings"
+)
+
+// Attribute types which can be declared in DefineAttrs along with attribute properties, e.g.
+//
+//	"level": {"AllClients", "Persistent", "int"},
+//	"items": {"Client", "ListAttr<string>"},
+//
+// Attribute types are used by `gwtool gen-attrs` to generate typed getters and setters on entity types.
+const (
+	ATTR_TYPE_BOOL     = "bool"
+	ATTR_TYPE_INT      = "int"
+	ATTR_TYPE_INT64    = "int64"
+	ATTR_TYPE_UINT64   = "uint64"
+	ATTR_TYPE_FLOAT64  = "float64"
+	ATTR_TYPE_STRING   = "string"
+	ATTR_TYPE_MAPATTR  = "MapAttr"
+	ATTR_TYPE_LISTATTR = "ListAttr"
+)
+
+var (
+	scalarAttrTypes = map[string]string{} // lower case -> attr type
+)
+
+func init() {
+	for _, t := range []string{ATTR_TYPE_BOOL, ATTR_TYPE_INT, ATTR_TYPE_INT64, ATTR_TYPE_UINT64, ATTR_TYPE_FLOAT64, ATTR_TYPE_STRING} {
+		scalarAttrTypes[strings.ToLower(t)] = t
+	}
+}
+
+// Parse the attribute type def, returns the canonical attribute type
+//
+// ListAttr can specify the element type as ListAttr<T>, in which T is a scalar type
+func ParseAttrType(def string) (string, bool) {
+	def = strings.TrimSpace(def)
+	ldef := strings.ToLower(def)
+
+	if t, ok := scalarAttrTypes[ldef]; ok {
+		return t, true
+	} else if ldef == "mapattr" {
+		return ATTR_TYPE_MAPATTR, true
+	} else if ldef == "listattr" {
+		return ATTR_TYPE_LISTATTR, true
+	} else if strings.HasPrefix(ldef, "listattr<") && strings.HasSuffix(ldef, ">") {
+		elem, ok := scalarAttrTypes[strings.TrimSpace(ldef[len("listattr<"):len(ldef)-1])]
+		if !ok {
+			return "", false
+		}
+		return ATTR_TYPE_LISTATTR + "<" + elem + ">", true
+	}
+	return "", false
+}
+
+// Split the ListAttr type to ListAttr and the element type, elem is empty if not specified
+func SplitListAttrType(attrType string) (elem string, ok bool) {
+	if attrType == ATTR_TYPE_LISTATTR {
+		return "", true
+	}
+	if strings.HasPrefix(attrType, ATTR_TYPE_LISTATTR+"<") && strings.HasSuffix(attrType, ">") {
+		return attrType[len(ATTR_TYPE_LISTATTR)+1 : len(attrType)-1], true
+	}
+	return "", false
+}
+
+// Get the declared type of attribute, returns empty string if type is not declared
+func (desc *EntityTypeDesc) GetAttrType(attr string) string {
+	return desc.attrTypes[attr]
+}
+
+func (desc *EntityTypeDesc) setAttrType(attr string, attrType string) {
+	if desc.attrTypes == nil {
+		desc.attrTypes = map[string]string{}
+	}
+	desc.attrTypes[attr] = attrType
+}
+
+func validAttrDefsForError() []string {
+	defs := _VALID_ATTR_DEFS.ToList()
+	defs = append(defs, "bool", "int", "int64", "uint64", "float64", "string", "MapAttr", "ListAttr", "ListAttr<T>")
+	return defs
+}
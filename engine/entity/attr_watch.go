@@ -0,0 +1,77 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Attribute watchers are notified when root attributes are set or deleted, newVal is nil if the attribute is
+// deleted. Changes inside MapAttr or ListAttr values are not notified.
+//
+// Watchers are not notified when attributes are loaded during entity creation or migration.
+
+// Callback of attribute changes
+type AttrWatchCallback func(oldVal, newVal interface{})
+
+// Handle returned by WatchAttr, can be used to unwatch
+type AttrWatchHandle int
+
+type attrWatcher struct {
+	handle AttrWatchHandle
+	cb     AttrWatchCallback
+}
+
+// Optional interface for entities to handle changes of attributes defined with "OnChange" in DefineAttrs
+type IAttrChangeHandler interface {
+	OnAttrChange(key string, oldVal, newVal interface{}) // Called when the attribute is set or deleted
+}
+
+// Watch changes of the root attribute
+func (e *Entity) WatchAttr(key string, cb AttrWatchCallback) AttrWatchHandle {
+	if e.attrWatchers == nil {
+		e.attrWatchers = map[string][]*attrWatcher{}
+	}
+
+	e.lastAttrWatchHandle += 1
+	h := e.lastAttrWatchHandle
+	e.attrWatchers[key] = append(e.attrWatchers[key], &attrWatcher{handle: h, cb: cb})
+	return h
+}
+
+// Unwatch the attribute watched by WatchAttr
+func (e *Entity) UnwatchAttr(h AttrWatchHandle) {
+	for key, watchers := range e.attrWatchers {
+		for i, w := range watchers {
+			if w.handle != h {
+				continue
+			}
+
+			if len(watchers) == 1 {
+				delete(e.attrWatchers, key)
+			} else {
+				e.attrWatchers[key] = append(watchers[:i:i], watchers[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+func (e *Entity) notifyAttrChange(key string, oldVal, newVal interface{}) {
+	if !e.attrsLoaded {
+		return
+	}
+
+	if e.typeDesc.attrChangeHooks.Contains(key) {
+		if handler, ok := e.I.(IAttrChangeHandler); ok {
+			gwutils.RunPanicless(func() {
+				handler.OnAttrChange(key, oldVal, newVal)
+			})
+		}
+	}
+
+	for _, w := range e.attrWatchers[key] {
+		cb := w.cb
+		gwutils.RunPanicless(func() {
+			cb(oldVal, newVal)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+var (
+	callDroppedCallback func(entityID common.EntityID, method string)
+)
+
+// Set the callback for calls dropped by dispatcher since too many calls are queued for the migrating target entity
+//
+// Calls of Call & CallService are not acknowledged, use CallWithResult if callers need to know whether calls succeed.
+func SetCallDroppedCallback(cb func(entityID common.EntityID, method string)) {
+	callDroppedCallback = cb
+}
+
+// Called by engine when the dispatcher drops the call from this game
+func OnCallDropped(entityID common.EntityID, method string) {
+	gwlog.Warn("Call %s.%s is dropped by dispatcher: too many pending calls", entityID, method)
+	if callDroppedCallback != nil {
+		gwutils.RunPanicless(func() {
+			callDroppedCallback(entityID, method)
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package entity
+
+import (
+	"time"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Chat service routes world, channel and private chat messages to clients across all games and gates.
+//
+// Entities join channels by JoinChatChannel, which sets filter props of their clients, so messages are broadcast by
+// gates to clients of channel members directly without going through entities. Messages are sent to the service
+// first, which limits the rate of each sender and runs the chat filter (e.g. a profanity filter) before broadcasting.
+//
+// Clients receive messages by OnChatMessage(channel, sender, senderName, text), private messages are received on
+// CHAT_CHANNEL_PRIVATE. The sender name is the "name" attribute of the sender entity if it is a string.
+
+const (
+	CHAT_SERVICE_TYPE = "__chat__"
+	CHAT_SERVICE_NAME = "__chat__"
+
+	CHAT_CHANNEL_WORLD   = "world"
+	CHAT_CHANNEL_PRIVATE = "private" // channel of private messages received by clients, can not be joined
+
+	CHAT_NAME_ATTR_KEY = "name"
+
+	// client methods called on entities of clients
+	CHAT_CLIENT_MESSAGE_METHOD  = "OnChatMessage"  // (channel string, sender EntityID, senderName string, text string)
+	CHAT_CLIENT_REJECTED_METHOD = "OnChatRejected" // (channel string, reason string)
+
+	_CHAT_FILTER_PROP_PREFIX  = "__chat__/"
+	_CHAT_PRIVATE_FILTER_PROP = "__chat_id__"
+)
+
+// Filter of chat messages run by the chat service, returns the filtered text (e.g. with profanity masked) and
+// whether the message is allowed
+type ChatFilter func(sender EntityID, channel string, text string) (string, bool)
+
+// Optional interface for entities to handle messages rejected by the chat service
+type IChatRejectedHandler interface {
+	OnChatRejected(channel string, reason string)
+}
+
+var (
+	chatFilter        ChatFilter
+	chatRateMaxCount  int
+	chatRateLimitSpan time.Duration
+)
+
+// The chat service entity, created by CreateChatServiceAnywhere
+type ChatService struct {
+	Entity
+
+	rateTrackers map[EntityID]*rpcRateTracker
+}
+
+// Register the chat service type
+//
+// Should be called on all games before running
+func RegisterChatService() {
+	RegisterEntity(CHAT_SERVICE_TYPE, &ChatService{})
+}
+
+// Create the chat service on any game, should be called only once in the cluster
+func CreateChatServiceAnywhere() {
+	createEntityAnywhere(CHAT_SERVICE_TYPE, nil)
+}
+
+// Set the filter of chat messages, e.g. a profanity filter
+//
+// Should be called on all games before running, since the chat service can be created on any game
+func SetChatFilter(filter ChatFilter) {
+	chatFilter = filter
+}
+
+// Limit each sender to send at most maxMessages messages per duration, not limited if maxMessages is 0
+//
+// Should be called on all games before running, since the chat service can be created on any game
+func SetChatRateLimit(maxMessages int, per time.Duration) {
+	chatRateMaxCount = maxMessages
+	chatRateLimitSpan = per
+}
+
+// Check if the chat service is ready
+func IsChatServiceReady() bool {
+	return len(GetServiceProviders(CHAT_SERVICE_NAME)) > 0
+}
+
+// Join the chat channel to receive messages of the channel on the client, e.g. CHAT_CHANNEL_WORLD
+//
+// Entities joining any channel also receive private messages. Channels are filter props of the entity, so they
+// follow the entity when it migrates, and are not kept by the client when the client is given to other entities.
+func (e *Entity) JoinChatChannel(channel string) {
+	if channel == "" || channel == CHAT_CHANNEL_PRIVATE {
+		gwlog.Panicf("%s.JoinChatChannel: invalid channel: %q", e, channel)
+	}
+	e.SetFilterProp(_CHAT_PRIVATE_FILTER_PROP, string(e.ID))
+	e.SetFilterProp(_CHAT_FILTER_PROP_PREFIX+channel, "1")
+}
+
+// Leave the chat channel
+func (e *Entity) LeaveChatChannel(channel string) {
+	e.SetFilterProp(_CHAT_FILTER_PROP_PREFIX+channel, "")
+}
+
+// Send the message to all clients in the channel through the chat service
+func (e *Entity) SendChatMessage(channel string, text string) {
+	e.callChatService("Chat", e.ID, e.chatName(), channel, text)
+}
+
+// Send the private message to the client of the target entity through the chat service
+//
+// The message is dropped if the target has no client or has not joined any channel.
+func (e *Entity) SendPrivateChatMessage(target EntityID, text string) {
+	e.callChatService("PrivateChat", e.ID, e.chatName(), target, text)
+}
+
+func (e *Entity) chatName() string {
+	if !e.Attrs.HasKey(CHAT_NAME_ATTR_KEY) {
+		return ""
+	}
+	name, _ := e.Attrs.Get(CHAT_NAME_ATTR_KEY).(string)
+	return name
+}
+
+func (e *Entity) callChatService(method string, args ...interface{}) {
+	serviceEid, err := entityManager.chooseServiceProvider(CHAT_SERVICE_NAME, "")
+	if err != nil {
+		gwlog.Warn("%s: chat service is not ready: %s", e, err)
+		return
+	}
+	callEntity(serviceEid, method, args)
+}
+
+// Called by chat service when the message is rejected by the rate limit or the chat filter
+func (e *Entity) ChatRejectedFromService(channel string, reason string) {
+	if e.client != nil {
+		e.client.call(e.ID, CHAT_CLIENT_REJECTED_METHOD, channel, reason)
+	}
+	if handler, ok := e.I.(IChatRejectedHandler); ok {
+		gwutils.RunPanicless(func() {
+			handler.OnChatRejected(channel, reason)
+		})
+	}
+}
+
+func (s *ChatService) OnInit() {
+	s.rateTrackers = map[EntityID]*rpcRateTracker{}
+}
+
+func (s *ChatService) OnCreated() {
+	gwlog.Info("Registering chat service ...")
+	s.DeclareService(CHAT_SERVICE_NAME)
+	s.addRawTimer(consts.CHAT_RATE_TRACKERS_SWEEP_INTERVAL, s.sweepRateTrackers)
+}
+
+// Broadcast the message to clients in the channel
+func (s *ChatService) Chat(sender EntityID, senderName string, channel string, text string) {
+	text, ok := s.checkMessage(sender, channel, text)
+	if !ok {
+		return
+	}
+	s.CallFitleredClients(_CHAT_FILTER_PROP_PREFIX+channel, "1", CHAT_CLIENT_MESSAGE_METHOD, channel, sender, senderName, text)
+}
+
+// Send the private message to the client of target
+func (s *ChatService) PrivateChat(sender EntityID, senderName string, target EntityID, text string) {
+	text, ok := s.checkMessage(sender, CHAT_CHANNEL_PRIVATE, text)
+	if !ok {
+		return
+	}
+	s.CallFitleredClients(_CHAT_PRIVATE_FILTER_PROP, string(target), CHAT_CLIENT_MESSAGE_METHOD, CHAT_CHANNEL_PRIVATE, sender, senderName, text)
+}
+
+// Check the rate limit and run the chat filter, returns the filtered text and whether the message should be sent
+func (s *ChatService) checkMessage(sender EntityID, channel string, text string) (string, bool) {
+	if !s.checkRate(sender) {
+		s.Call(sender, "ChatRejectedFromService", channel, "rate limited")
+		return "", false
+	}
+
+	if chatFilter != nil {
+		ok := false
+		gwutils.RunPanicless(func() {
+			text, ok = chatFilter(sender, channel, text)
+		})
+		if !ok {
+			s.Call(sender, "ChatRejectedFromService", channel, "filtered")
+			return "", false
+		}
+	}
+	return text, true
+}
+
+func (s *ChatService) checkRate(sender EntityID) bool {
+	if chatRateMaxCount <= 0 {
+		return true
+	}
+
+	tracker := s.rateTrackers[sender]
+	now := time.Now()
+	if tracker == nil || now.Sub(tracker.windowStart) >= chatRateLimitSpan {
+		tracker = &rpcRateTracker{windowStart: now}
+		s.rateTrackers[sender] = tracker
+	}
+	tracker.calls += 1
+	return tracker.calls <= chatRateMaxCount
+}
+
+func (s *ChatService) sweepRateTrackers() {
+	now := time.Now()
+	for sender, tracker := range s.rateTrackers {
+		if now.Sub(tracker.windowStart) >= chatRateLimitSpan {
+			delete(s.rateTrackers, sender)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package entity
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Record of a client-originated RPC
+type ClientAuditRecord struct {
+	Time     time.Time
+	ClientID ClientID
+	Method   string
+	Args     []interface{}
+}
+
+// Ring buffer of the last N client-originated RPCs of the entity
+type clientAuditTrail struct {
+	records []ClientAuditRecord
+	next    int
+	full    bool
+}
+
+func newClientAuditTrail(size int) *clientAuditTrail {
+	return &clientAuditTrail{
+		records: make([]ClientAuditRecord, size),
+	}
+}
+
+func (trail *clientAuditTrail) record(clientid ClientID, method string, args [][]byte) {
+	rec := &trail.records[trail.next]
+	rec.Time = time.Now()
+	rec.ClientID = clientid
+	rec.Method = method
+	rec.Args = make([]interface{}, len(args))
+	for i, arg := range args {
+		rec.Args[i] = toClientAuditValue(unpackClientAuditArg(arg))
+	}
+
+	trail.next += 1
+	if trail.next == len(trail.records) {
+		trail.next = 0
+		trail.full = true
+	}
+}
+
+// Decode the arg of client RPC, raw bytes are kept if the arg can not be decoded
+func unpackClientAuditArg(data []byte) (val interface{}) {
+	defer func() {
+		// the packer panics on maps of non-string keys, which are decoded but not converted
+		if err := recover(); err != nil && val == nil {
+			val = data
+		}
+	}()
+
+	if err := netutil.MSG_PACKER.UnpackMsg(data, &val); err != nil {
+		return data
+	}
+	return
+}
+
+// Convert keys of maps in the arg to strings, so that the records can be dumped as JSON by admin
+func toClientAuditValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[fmt.Sprint(k)] = toClientAuditValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			m[k] = toClientAuditValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = toClientAuditValue(item)
+		}
+		return l
+	}
+	return val
+}
+
+func (trail *clientAuditTrail) getRecords() []ClientAuditRecord {
+	if !trail.full {
+		return append([]ClientAuditRecord(nil), trail.records[:trail.next]...)
+	}
+
+	records := make([]ClientAuditRecord, 0, len(trail.records))
+	records = append(records, trail.records[trail.next:]...)
+	records = append(records, trail.records[:trail.next]...)
+	return records
+}
+
+// Enable recording the last `size` client-originated RPCs for entities of this type
+func (desc *EntityTypeDesc) EnableClientAudit(size int) {
+	desc.clientAuditSize = size
+}
+
+// Get the recorded client-originated RPCs of the entity, oldest first
+//
+// returns nil if client audit is not enabled for the entity type
+func (e *Entity) GetClientAuditTrail() []ClientAuditRecord {
+	if e.clientAudit == nil {
+		return nil
+	}
+	return e.clientAudit.getRecords()
+}
+
+// Get the recorded client-originated RPCs of the entity by EntityID
+func GetClientAuditTrail(id EntityID) []ClientAuditRecord {
+	e := entityManager.get(id)
+	if e == nil {
+		return nil
+	}
+	return e.GetClientAuditTrail()
+}
+
+func (e *Entity) dumpClientAuditTrail() string {
+	var buf bytes.Buffer
+	for _, rec := range e.GetClientAuditTrail() {
+		fmt.Fprintf(&buf, "\n\t%s %s %s%v", rec.Time.Format("2006-01-02 15:04:05.000"), rec.ClientID, rec.Method, rec.Args)
+	}
+	return buf.String()
+}
@@ -0,0 +1,90 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/admin"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+// Get the admin endpoint, retrying until the admin server is listening
+func getTestAdmin(t *testing.T, port int, path string) (int, []byte) {
+	req, _ := http.NewRequest(http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d%s", port, path), nil)
+	req.Header.Set(admin.ADMIN_TOKEN_HEADER, "secret")
+
+	var err error
+	for i := 0; i < 100; i++ {
+		var resp *http.Response
+		if resp, err = http.DefaultClient.Do(req); err == nil {
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			return resp.StatusCode, body
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("request %s failed: %s", path, err)
+	return 0, nil
+}
+
+func TestClientAuditTrailOfMapArgs(t *testing.T) {
+	e := newTestAttrsEntity()
+	e.ID = GenEntityID()
+	e.TypeName = "AuditTest"
+	e.clientAudit = newClientAuditTrail(4)
+	entityManager.put(e)
+	defer entityManager.del(e.ID)
+
+	var args [][]byte
+	for _, arg := range []interface{}{
+		map[string]interface{}{"sword": 1, "bag": map[string]interface{}{"potion": 2}},
+		map[int]int{100: 3},
+	} {
+		data, err := netutil.MSG_PACKER.PackMsg(arg, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, data)
+	}
+	OnCall(e.ID, "Trade", args, ClientID("testclient"), tracing.SpanContext{})
+
+	// same as /entity/audit of games
+	const port = 14791
+	admin.Handle("/entity/audit", func(query url.Values) (interface{}, error) {
+		records := GetClientAuditTrail(EntityID(query.Get("id")))
+		if records == nil {
+			return nil, admin.ErrNotFound
+		}
+		return records, nil
+	})
+	admin.Serve("127.0.0.1", port, "secret")
+
+	status, body := getTestAdmin(t, port, "/entity/audit?id="+string(e.ID))
+	if status != http.StatusOK {
+		t.Fatalf("audit trail should be dumped, but replied %d: %s", status, body)
+	}
+	var records []struct {
+		Method string
+		Args   []interface{}
+	}
+	if err := json.Unmarshal(body, &records); err != nil {
+		t.Fatalf("invalid audit trail %s: %s", body, err)
+	}
+	if len(records) != 1 || records[0].Method != "Trade" {
+		t.Fatalf("wrong audit trail: %s", body)
+	}
+	expected := []interface{}{
+		map[string]interface{}{"sword": 1.0, "bag": map[string]interface{}{"potion": 2.0}},
+		map[string]interface{}{"100": 3.0},
+	}
+	if fmt.Sprint(records[0].Args) != fmt.Sprint(expected) {
+		t.Errorf("wrong audit args: %v, should be %v", records[0].Args, expected)
+	}
+}
@@ -0,0 +1,142 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+)
+
+// Cluster save point saves entities of all games at a mutually consistent point, so that a trade between two
+// games is either fully saved or not saved at all.
+//
+// Cross-game entity calls are held while saving, and the marker of save point is recorded in KVDB with key
+// CLUSTER_SAVE_POINT_KVDB_KEY_PREFIX + label after all entities are saved and before calls are resumed.
+//
+// Entities migrating during the save point are saved with their last saved data.
+
+const (
+	CLUSTER_SAVE_POINT_KVDB_KEY_PREFIX = "__savepoint__/"
+)
+
+var (
+	lastClusterSavePointReqID uint32
+	pendingClusterSavePoints  = map[uint32]*pendingClusterSavePoint{}
+	holdingCallsForSavePoint  uint32 // ID of the save point for which calls are held, 0 if not holding
+	heldCalls                 []func()
+)
+
+// Marker of cluster save point recorded in KVDB
+type ClusterSavePoint struct {
+	ID    uint32    `json:"id"`
+	Label string    `json:"label"`
+	Time  time.Time `json:"time"`
+}
+
+// Callback of StartClusterSavePoint, err is not nil if the save point is aborted
+type ClusterSavePointCallback func(savePoint *ClusterSavePoint, err error)
+
+type pendingClusterSavePoint struct {
+	label    string
+	callback ClusterSavePointCallback
+}
+
+// Start a cluster save point which saves entities of all games at a mutually consistent point
+//
+// Only one cluster save point can be in progress.
+func StartClusterSavePoint(label string, callback ClusterSavePointCallback) {
+	lastClusterSavePointReqID += 1
+	reqid := lastClusterSavePointReqID
+	pendingClusterSavePoints[reqid] = &pendingClusterSavePoint{label: label, callback: callback}
+	dispatcher_client.GetDispatcherClientForSend().SendStartClusterSavePoint(reqid, label)
+}
+
+// Called by engine when the dispatcher replies the start cluster save point request
+//
+// The request succeeds when all entities of all games are saved, then the marker is recorded before resuming
+// calls of all games.
+func OnStartClusterSavePointAck(reqid uint32, saveid uint32, errmsg string) {
+	pending := pendingClusterSavePoints[reqid]
+	if pending == nil {
+		gwlog.Error("OnStartClusterSavePointAck: request %d not found", reqid)
+		return
+	}
+	delete(pendingClusterSavePoints, reqid)
+
+	if errmsg != "" {
+		gwlog.Warn("Cluster save point %s failed: %s", pending.label, errmsg)
+		if pending.callback != nil {
+			pending.callback(nil, errors.New(errmsg))
+		}
+		return
+	}
+
+	savePoint := &ClusterSavePoint{ID: saveid, Label: pending.label, Time: time.Now()}
+	data, err := json.Marshal(savePoint)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+
+	kvdb.Put(CLUSTER_SAVE_POINT_KVDB_KEY_PREFIX+pending.label, string(data), func(err error) {
+		// resume calls of all games even if the marker is not recorded
+		dispatcher_client.GetDispatcherClientForSend().SendFinishClusterSavePoint(saveid)
+
+		if err != nil {
+			gwlog.TraceError("Cluster save point %s: record marker failed: %s", pending.label, err)
+			savePoint = nil
+		} else {
+			gwlog.Info("Cluster save point %s is recorded: %s", pending.label, data)
+		}
+		if pending.callback != nil {
+			pending.callback(savePoint, err)
+		}
+	})
+}
+
+// Called by engine when the cluster save point is preparing, calls to entities are held until finished
+func OnClusterSavePointPrepare(saveid uint32) {
+	holdingCallsForSavePoint = saveid
+	dispatcher_client.GetDispatcherClientForSend().SendClusterSavePointPrepareAck(saveid)
+}
+
+// Called by engine when all games are holding calls, all entities are saved
+func OnClusterSavePointCommit(saveid uint32) {
+	saving := 0
+	onSaved := func(err error) {
+		saving -= 1
+		if saving == 0 {
+			dispatcher_client.GetDispatcherClientForSend().SendClusterSavePointCommitAck(saveid)
+		}
+	}
+
+	saving += 1 // avoid acking before all saves are started
+	for _, e := range entityManager.entities {
+		if e.save(onSaved) {
+			saving += 1
+		}
+	}
+	gwlog.Info("Cluster save point %d: saving %d entities", saveid, saving-1)
+	onSaved(nil)
+}
+
+// Called by engine when the cluster save point is finished or aborted, held calls are resumed
+func OnFinishClusterSavePoint(saveid uint32) {
+	if holdingCallsForSavePoint != saveid {
+		return
+	}
+
+	holdingCallsForSavePoint = 0
+	calls := heldCalls
+	heldCalls = nil
+	gwlog.Info("Cluster save point %d finished, %d held calls resumed", saveid, len(calls))
+	for _, call := range calls {
+		call()
+	}
+}
+
+func holdCall(call func()) {
+	heldCalls = append(heldCalls, call)
+}
@@ -0,0 +1,92 @@
+package entity
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Callback of CreateEntityAnywhereWithCallback, err is not nil if the entity is not created
+type CreateEntityCallback func(entityID EntityID, err error)
+
+type pendingCreateEntity struct {
+	callback     CreateEntityCallback
+	timeoutTimer *timer.Timer
+}
+
+var (
+	lastCreateEntityReqID uint32
+	pendingCreateEntities = map[uint32]*pendingCreateEntity{}
+)
+
+// Create entity on any game, the callback is called on this game with the ID of created entity
+//
+// The callback is called with error if the entity is not created on the target game, or the target game does
+// not reply in consts.CREATE_ENTITY_ANYWHERE_TIMEOUT
+func CreateEntityAnywhereWithCallback(typeName string, data map[string]interface{}, callback CreateEntityCallback) {
+	createEntityAnywhereWithCallback(typeName, data, getPlacement(typeName, data), callback)
+}
+
+// Create entity on any game matching the placement constraint, the callback is called on this game with the ID
+// of created entity, or the error if no game matches the placement constraint
+func CreateEntityAnywhereWithPlacement(typeName string, data map[string]interface{}, placement string, callback CreateEntityCallback) {
+	createEntityAnywhereWithCallback(typeName, data, placement, callback)
+}
+
+func createEntityAnywhereWithCallback(typeName string, data map[string]interface{}, placement string, callback CreateEntityCallback) {
+	lastCreateEntityReqID += 1
+	if lastCreateEntityReqID == 0 { // 0 is reserved for requests without callback
+		lastCreateEntityReqID = 1
+	}
+	reqid := lastCreateEntityReqID
+
+	pending := &pendingCreateEntity{callback: callback}
+	pending.timeoutTimer = timer.AddCallback(consts.CREATE_ENTITY_ANYWHERE_TIMEOUT, func() {
+		if pendingCreateEntities[reqid] != pending {
+			return
+		}
+		delete(pendingCreateEntities, reqid)
+		gwlog.Warn("CreateEntityAnywhereWithCallback: create %s timeout", typeName)
+		callback("", errors.Errorf("create entity %s timeout", typeName))
+	})
+	pendingCreateEntities[reqid] = pending
+
+	dispatcher_client.GetDispatcherClientForSend().SendCreateEntityAnywhere(typeName, data, reqid, placement)
+}
+
+// Create entity locally, returns error instead of panic if the entity can not be created
+func CreateEntityLocallyWithError(typeName string, data map[string]interface{}) (eid EntityID, err error) {
+	if _, ok := registeredEntityTypes[typeName]; !ok {
+		return "", errors.Errorf("unknown entity type: %s", typeName)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			gwlog.TraceError("CreateEntityLocallyWithError: create %s failed: %v", typeName, r)
+			eid, err = "", errors.New(fmt.Sprint(r))
+		}
+	}()
+	return CreateEntityLocally(typeName, data, nil), nil
+}
+
+// Called by engine when the target game replies the create entity anywhere request
+func OnCreateEntityAnywhereAck(reqid uint32, eid EntityID, errmsg string) {
+	pending := pendingCreateEntities[reqid]
+	if pending == nil {
+		return // timeout already
+	}
+
+	delete(pendingCreateEntities, reqid)
+	pending.timeoutTimer.Cancel()
+
+	if errmsg != "" {
+		pending.callback("", errors.New(errmsg))
+	} else {
+		pending.callback(eid, nil)
+	}
+}
@@ -0,0 +1,138 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+var (
+	duplicateEntityCallback     func(entityID common.EntityID, gameid uint16)
+	entityAlreadyLoadedCallback func(entityID common.EntityID, gameid uint16)
+	revisionConflictCallback    func(entityID common.EntityID, err error)
+
+	lastClaimEntityReqID uint32
+	pendingClaimEntities = map[uint32]*pendingClaimEntity{}
+)
+
+type claimEntityCallback func(gameid uint16, err error)
+
+type pendingClaimEntity struct {
+	callback     claimEntityCallback
+	timeoutTimer *timer.Timer
+}
+
+// Claim the entity from dispatcher before loading it locally
+//
+// The callback is called with gameid 0 if the entity is bound to this game, or the game which the entity is already
+// on, so that the entity is not created at all. It is called with error if the dispatcher does not reply in time.
+func claimEntity(entityID common.EntityID, callback claimEntityCallback) {
+	lastClaimEntityReqID += 1
+	reqid := lastClaimEntityReqID
+
+	pending := &pendingClaimEntity{callback: callback}
+	pending.timeoutTimer = timer.AddCallback(consts.DISPATCHER_LOAD_TIMEOUT, func() {
+		if pendingClaimEntities[reqid] != pending {
+			return
+		}
+		delete(pendingClaimEntities, reqid)
+		callback(0, errors.Errorf("claim entity %s timeout", entityID))
+	})
+	pendingClaimEntities[reqid] = pending
+
+	dispatcher_client.GetDispatcherClientForEntity(entityID).SendClaimEntity(reqid, entityID)
+}
+
+// Called by engine when the dispatcher replies the claim of entity
+func OnClaimEntityAck(reqid uint32, gameid uint16) {
+	pending := pendingClaimEntities[reqid]
+	if pending == nil {
+		return // timeout already
+	}
+
+	delete(pendingClaimEntities, reqid)
+	pending.timeoutTimer.Cancel()
+	pending.callback(gameid, nil)
+}
+
+// Set the callback for entities rejected by dispatcher since they are already on other games
+//
+// The rejected entity is destroyed without saving before the callback is called, and gameid is the game which the
+// entity is on, e.g. clients of the rejected entity can be redirected to the entity on that game.
+func SetDuplicateEntityCallback(cb func(entityID common.EntityID, gameid uint16)) {
+	duplicateEntityCallback = cb
+}
+
+// Called by engine when the dispatcher rejects the entity created on this game
+func OnCreateEntityRejected(entityID common.EntityID, gameid uint16) {
+	e := entityManager.get(entityID)
+	if e != nil {
+		gwlog.Error("%s is already on game %d, destroyed without saving", e, gameid)
+		e.duplicate = true
+		e.destroyEntity(false, nil) // the dispatcher is not notified, since the entity is bound to the other game
+	} else {
+		gwlog.Warn("Entity %s is already on game %d", entityID, gameid)
+	}
+
+	if duplicateEntityCallback != nil {
+		gwutils.RunPanicless(func() {
+			duplicateEntityCallback(entityID, gameid)
+		})
+	}
+}
+
+// Set the callback for entities loaded by LoadEntityAnywhere which are already loaded or loading on games
+//
+// gameid is the game which the entity is on, and loading the entity again is ignored.
+func SetEntityAlreadyLoadedCallback(cb func(entityID common.EntityID, gameid uint16)) {
+	entityAlreadyLoadedCallback = cb
+}
+
+// Called by engine when the dispatcher ignores loading the entity which is already on a game
+func OnEntityAlreadyLoaded(entityID common.EntityID, gameid uint16) {
+	gwlog.Debug("Entity %s is already loaded on game %d", entityID, gameid)
+	if entityAlreadyLoadedCallback != nil {
+		gwutils.RunPanicless(func() {
+			entityAlreadyLoadedCallback(entityID, gameid)
+		})
+	}
+}
+
+// Set the callback for entities whose saves are dropped by revision check, see storage.IsRevisionCheckEnabled
+//
+// The stored data is saved by another game since the entity is loaded, so the entity is stale and destroyed without
+// saving before the callback is called, and err is the storage.RevisionConflictError.
+func SetRevisionConflictCallback(cb func(entityID common.EntityID, err error)) {
+	revisionConflictCallback = cb
+}
+
+// Wrap the save callback to destroy the entity if the save is dropped by revision conflict
+func (e *Entity) checkSaveRevision(callback storage.SaveCallbackFunc) storage.SaveCallbackFunc {
+	return func(err error) {
+		if storage.IsRevisionConflict(err) {
+			e.onRevisionConflict(err)
+		}
+		if callback != nil {
+			callback(err)
+		}
+	}
+}
+
+func (e *Entity) onRevisionConflict(err error) {
+	if !e.destroyed {
+		gwlog.Error("%s is saved by another game: %s, destroyed without saving", e, err)
+		e.duplicate = true
+		e.Destroy()
+	}
+
+	if revisionConflictCallback != nil {
+		gwutils.RunPanicless(func() {
+			revisionConflictCallback(e.ID, err)
+		})
+	}
+}
@@ -0,0 +1,25 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/analytics"
+)
+
+// Emit analytics event of entity with fields in key-value pairs, see package analytics
+func (e *Entity) emitAnalytics(eventType string, kvs ...interface{}) {
+	if !analytics.IsEnabled() {
+		return
+	}
+
+	var fields map[string]interface{}
+	if len(kvs) > 0 {
+		fields = make(map[string]interface{}, len(kvs)/2)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			fields[kvs[i].(string)] = kvs[i+1]
+		}
+	}
+	analytics.Emit(eventType, string(e.ID), e.TypeName, fields)
+}
+
+func (e *Entity) emitClientAnalytics(eventType string, client *GameClient) {
+	e.emitAnalytics(eventType, "client_id", string(client.clientid), "gate_id", client.gateid)
+}
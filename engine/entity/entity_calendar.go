@@ -0,0 +1,90 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/calendar"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_CALENDAR_SUBSCRIPTIONS_KEY = "__calendar_subscriptions" // key of calendar subscriptions in migrate data
+)
+
+// Calendar subscription of entity, kept across migrations, freeze & restore and takeover by standby games
+type calendarHandle struct {
+	handle    calendar.Handle
+	eventName string
+	method    string
+}
+
+// Subscribe to start & end of the scheduled event, or all events if eventName is empty
+//
+// The method is called with arguments (eventName string, started bool)
+// Subscriptions are cancelled when the entity is destroyed, and subscribed again after the entity migrates in or is
+// restored, when the method is called for events already started, just like new subscriptions.
+func (e *Entity) SubscribeCalendarEvent(eventName string, method string) {
+	h := calendar.Subscribe(eventName, func(ev *calendar.Event, started bool) {
+		e.onCallFromLocal(method, []interface{}{ev.Name, started})
+	})
+	e.calendarHandles = append(e.calendarHandles, calendarHandle{h, eventName, method})
+}
+
+func (e *Entity) unsubscribeCalendarEvents() {
+	for _, ch := range e.calendarHandles {
+		ch.handle.Unsubscribe()
+	}
+	e.calendarHandles = nil
+}
+
+// Dump calendar subscriptions as [eventName, method] pairs for migrate and freeze data, nil if none
+func (e *Entity) dumpCalendarSubscriptions() []interface{} {
+	if len(e.calendarHandles) == 0 {
+		return nil
+	}
+
+	subscriptions := make([]interface{}, 0, len(e.calendarHandles))
+	for _, ch := range e.calendarHandles {
+		subscriptions = append(subscriptions, []interface{}{ch.eventName, ch.method})
+	}
+	return subscriptions
+}
+
+// Put calendar subscriptions in migrate data, so that they are subscribed again after migrated
+func (e *Entity) putCalendarSubscriptions(data map[string]interface{}) map[string]interface{} {
+	subscriptions := e.dumpCalendarSubscriptions()
+	if subscriptions == nil {
+		return data
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data[_CALENDAR_SUBSCRIPTIONS_KEY] = subscriptions
+	return data
+}
+
+// Remove calendar subscriptions from migrate data, returns subscriptions to be subscribed again
+func popCalendarSubscriptions(data map[string]interface{}) []calendarHandle {
+	subscriptions, _ := data[_CALENDAR_SUBSCRIPTIONS_KEY].([]interface{})
+	delete(data, _CALENDAR_SUBSCRIPTIONS_KEY)
+
+	var calendarHandles []calendarHandle
+	for _, sub := range subscriptions {
+		pair, _ := sub.([]interface{})
+		if len(pair) != 2 {
+			gwlog.Error("invalid calendar subscription: %v", sub)
+			continue
+		}
+		eventName, _ := pair[0].(string)
+		method, _ := pair[1].(string)
+		calendarHandles = append(calendarHandles, calendarHandle{eventName: eventName, method: method})
+	}
+	return calendarHandles
+}
+
+func (e *Entity) resubscribeCalendarEvents(calendarHandles []calendarHandle) {
+	for _, ch := range calendarHandles {
+		if e.destroyed {
+			return
+		}
+		e.SubscribeCalendarEvent(ch.eventName, ch.method)
+	}
+}
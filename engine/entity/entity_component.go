@@ -0,0 +1,153 @@
+package entity
+
+import (
+	"reflect"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Components compose entity types of reusable parts, e.g. Inventory, Buffs and Movement, instead of embedding all
+// logic into one entity struct
+//
+// Component types embed Component and are added to entity types by EntityTypeDesc.AddComponent. Each entity has one
+// instance of each component of its type, created when the entity is initialized. Components contribute:
+//
+//	attributes    defined by ComponentDesc.DefineAttrs, stored in attrs of the owner entity like entity attributes
+//	RPC methods   exported methods of components are called as "<component name>.<method>", e.g. "Inventory.AddItem"
+//	hooks         lifecycle hooks of IComponent, called in the order of components after hooks of the owner entity
+//
+// Component fields are not persistent or migrated, so component state should be kept in attributes.
+
+// Component should be embedded by component types
+type Component struct {
+	Entity *Entity // owner entity of the component
+	Name   string  // name of the component in the entity type
+}
+
+// Lifecycle hooks of component, Component provides empty implementations
+type IComponent interface {
+	OnAttached()               // Called when the owner entity is initializing, after OnInit of entity
+	OnCreated()                // Called when the owner entity is just created
+	OnDestroy()                // Called when the owner entity is destroying
+	OnMigrateOut()             // Called just before the owner entity is migrating out
+	OnMigrateIn()              // Called just after the owner entity is migrating in
+	OnRestored()               // Called when the owner entity is restored
+	OnEnterSpace()             // Called when the owner entity enters space
+	OnLeaveSpace(space *Space) // Called when the owner entity leaves space
+	OnClientConnected()        // Called when client is connected to the owner entity
+	OnClientDisconnected()     // Called when client is disconnected from the owner entity
+	getComponent() *Component
+}
+
+// Component type added to entity type
+type ComponentDesc struct {
+	name          string
+	componentType reflect.Type
+	entityDesc    *EntityTypeDesc
+}
+
+func (c *Component) OnAttached()               {}
+func (c *Component) OnCreated()                {}
+func (c *Component) OnDestroy()                {}
+func (c *Component) OnMigrateOut()             {}
+func (c *Component) OnMigrateIn()              {}
+func (c *Component) OnRestored()               {}
+func (c *Component) OnEnterSpace()             {}
+func (c *Component) OnLeaveSpace(space *Space) {}
+func (c *Component) OnClientConnected()        {}
+func (c *Component) OnClientDisconnected()     {}
+
+func (c *Component) getComponent() *Component {
+	return c
+}
+
+// Add component to entity type, the component is created for all entities of the type
+func (desc *EntityTypeDesc) AddComponent(name string, componentPtr IComponent) *ComponentDesc {
+	if name == "" {
+		gwlog.Panicf("AddComponent: component name is empty")
+	}
+	for _, cd := range desc.components {
+		if cd.name == name {
+			gwlog.Panicf("AddComponent: component %s is already added", name)
+		}
+	}
+
+	componentType := reflect.Indirect(reflect.ValueOf(componentPtr)).Type()
+	cd := &ComponentDesc{
+		name:          name,
+		componentType: componentType,
+		entityDesc:    desc,
+	}
+	index := len(desc.components)
+	desc.components = append(desc.components, cd)
+
+	componentPtrType := reflect.PtrTo(componentType)
+	for i := 0; i < componentPtrType.NumMethod(); i++ {
+		desc.rpcDescs.visitComponent(name, index, componentPtrType.Method(i))
+	}
+
+	gwlog.Debug(">>> AddComponent %s => %s <<<", name, componentType.Name())
+	return cd
+}
+
+// Define attributes of component, which are stored in attrs of the owner entity
+//
+// Attribute names should not clash with attributes of the entity type or other components.
+func (cd *ComponentDesc) DefineAttrs(attrDefs map[string][]string) {
+	desc := cd.entityDesc
+	if desc.componentAttrs == nil {
+		desc.componentAttrs = map[string]string{}
+	}
+	for attr := range attrDefs {
+		if owner, ok := desc.componentAttrs[attr]; ok && owner != cd.name {
+			gwlog.Panicf("component %s: attribute %s is already defined by component %s", cd.name, attr, owner)
+		}
+		desc.componentAttrs[attr] = cd.name
+	}
+	desc.DefineAttrs(attrDefs)
+}
+
+// Get the component of entity by name, returns nil if the entity type does not have the component
+func (e *Entity) GetComponent(name string) IComponent {
+	for i, cd := range e.typeDesc.components {
+		if cd.name == name {
+			return e.components[i]
+		}
+	}
+	return nil
+}
+
+// Create components of entity, called when the entity is initializing
+func (e *Entity) initComponents() {
+	if len(e.typeDesc.components) == 0 {
+		return
+	}
+
+	e.components = make([]IComponent, len(e.typeDesc.components))
+	for i, cd := range e.typeDesc.components {
+		component := reflect.New(cd.componentType).Interface().(IComponent)
+		c := component.getComponent()
+		c.Entity = e
+		c.Name = cd.name
+		e.components[i] = component
+	}
+	e.callComponentHooks(IComponent.OnAttached)
+}
+
+// Call hook of all components in order, panics are recovered for each component
+func (e *Entity) callComponentHooks(hook func(c IComponent)) {
+	for _, component := range e.components {
+		gwutils.RunPanicless(func() {
+			hook(component)
+		})
+	}
+}
+
+// Get the receiver of RPC method, which is the entity or one of its components
+func (e *Entity) rpcReceiver(rpcDesc *RpcDesc) reflect.Value {
+	if rpcDesc.component < 0 {
+		return reflect.ValueOf(e.I)
+	}
+	return reflect.ValueOf(e.components[rpcDesc.component])
+}
@@ -0,0 +1,152 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// CPU budget limits the execution time of each entity per second, so that one pathological entity (e.g. AI stuck in
+// heavy path finding) can not starve the whole game routine.
+//
+// Execution time of RPC methods (including timers) is charged to the executing entity, and time of nested calls to
+// other local entities is charged to the callee. When an entity uses up its budget in the current second, its timers
+// are deferred to subsequent ticks until the budget is available again. Repeated timers are fired once no matter how
+// many times they are deferred. RPCs are never deferred since they might be waiting for results.
+
+const (
+	_ENTITY_CPU_BUDGET_WINDOW = time.Second
+)
+
+var (
+	defaultEntityCPUBudget time.Duration
+
+	cpuBudgetFrameEntity *Entity // the entity which is charged for current execution time
+	cpuBudgetFrameStart  time.Time
+	cpuBudgetDeferred    = EntitySet{} // entities with deferred timers
+)
+
+type entityCPUUsage struct {
+	windowStart    time.Time
+	used           time.Duration // execution time used in the current window
+	exceeded       bool          // budget exceeded in the current window
+	deferredTimers []EntityTimerID
+}
+
+// Set the CPU budget per second of all entity types, 0 for no budget (default)
+func SetEntityCPUBudget(budget time.Duration) {
+	defaultEntityCPUBudget = budget
+}
+
+// Set the CPU budget per second of entities of this type, which overrides the budget set by SetEntityCPUBudget
+func (desc *EntityTypeDesc) SetCPUBudget(budget time.Duration) {
+	desc.cpuBudget = budget
+}
+
+func (e *Entity) getCPUBudget() time.Duration {
+	if e.typeDesc.cpuBudget > 0 {
+		return e.typeDesc.cpuBudget
+	}
+	return defaultEntityCPUBudget
+}
+
+// Enter the CPU budget frame of entity, returns the parent frame which should be restored by leaveCPUBudgetFrame
+func (e *Entity) enterCPUBudgetFrame() *Entity {
+	now := time.Now()
+	parent := cpuBudgetFrameEntity
+	if parent != nil {
+		parent.chargeCPUUsage(now.Sub(cpuBudgetFrameStart), now)
+	}
+	cpuBudgetFrameEntity, cpuBudgetFrameStart = e, now
+	return parent
+}
+
+func leaveCPUBudgetFrame(parent *Entity) {
+	now := time.Now()
+	cpuBudgetFrameEntity.chargeCPUUsage(now.Sub(cpuBudgetFrameStart), now)
+	cpuBudgetFrameEntity, cpuBudgetFrameStart = parent, now
+}
+
+func (e *Entity) chargeCPUUsage(d time.Duration, now time.Time) {
+	usage := &e.cpuUsage
+	if now.Sub(usage.windowStart) >= _ENTITY_CPU_BUDGET_WINDOW {
+		usage.windowStart = now
+		usage.used = 0
+		usage.exceeded = false
+	}
+
+	usage.used += d
+	e.chargeExecGroup(d, now)
+	budget := e.getCPUBudget()
+	if budget > 0 && !usage.exceeded && usage.used >= budget {
+		usage.exceeded = true
+		cpuBudgetExceededMetric.With(e.TypeName).Inc()
+		gwlog.Warn("%s exceeded CPU budget: used %s of %s, timers are deferred", e, usage.used, budget)
+	}
+}
+
+func (e *Entity) isOverCPUBudget() bool {
+	budget := e.getCPUBudget()
+	if budget <= 0 || time.Since(e.cpuUsage.windowStart) >= _ENTITY_CPU_BUDGET_WINDOW {
+		return false
+	}
+	return e.cpuUsage.used >= budget
+}
+
+// Check if timers of the entity should be deferred, by CPU budget of entity or time slice of execution group
+func (e *Entity) isTimerThrottled() bool {
+	return e.isOverCPUBudget() || e.isExecGroupOverSlice()
+}
+
+// Defer the timer if the entity is over CPU budget or its execution group is over time slice, returns true if deferred
+func (e *Entity) deferTimerByCPUBudget(tid EntityTimerID, timerInfo *entityTimerInfo) bool {
+	if !e.isTimerThrottled() {
+		return false
+	}
+
+	if !timerInfo.deferred {
+		timerInfo.deferred = true
+		e.cpuUsage.deferredTimers = append(e.cpuUsage.deferredTimers, tid)
+		cpuBudgetDeferred.Add(e)
+		deferredTimersMetric.With(e.TypeName).Inc()
+	}
+	return true
+}
+
+// Fire deferred timers of entities which have CPU budget available again, called by game in every tick
+func FireDeferredTimers() {
+	for e := range cpuBudgetDeferred {
+		if e.IsDestroyed() || e.timers == nil { // destroyed or migrating
+			e.cpuUsage.deferredTimers = nil
+			cpuBudgetDeferred.Del(e)
+			continue
+		}
+
+		e.fireDeferredTimers()
+		if len(e.cpuUsage.deferredTimers) == 0 {
+			cpuBudgetDeferred.Del(e)
+		}
+	}
+}
+
+func (e *Entity) fireDeferredTimers() {
+	usage := &e.cpuUsage
+	for len(usage.deferredTimers) > 0 && !e.isTimerThrottled() && !e.IsDestroyed() && e.timers != nil {
+		tid := usage.deferredTimers[0]
+		usage.deferredTimers = usage.deferredTimers[1:]
+
+		timerInfo := e.timers[tid]
+		if timerInfo == nil {
+			continue // timer cancelled
+		}
+		timerInfo.deferred = false
+		if !timerInfo.Repeat {
+			delete(e.timers, tid)
+		}
+		e.onCallFromLocal(timerInfo.Method, timerInfo.Args)
+	}
+
+	if len(usage.deferredTimers) == 0 {
+		usage.deferredTimers = nil
+	}
+}
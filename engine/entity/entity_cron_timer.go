@@ -0,0 +1,67 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/crontab"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Add a timer calling the method at times matching the cron spec, e.g. "0 0 4 * * *" for 4:00 every day
+//
+// The spec has 6 fields (second minute hour day-of-month month day-of-week) or 5 fields without second, and is
+// evaluated in local time unless prefixed by a timezone, e.g. "TZ=Asia/Shanghai 0 0 4 * * *". Like other timers,
+// cron timers are kept when the entity migrates or is frozen, and fire once on restore if fire times are missed.
+//
+// Returns an invalid timer ID if the spec is invalid.
+func (e *Entity) AddCronTimer(spec string, method string, args ...interface{}) EntityTimerID {
+	schedule, err := crontab.Parse(spec)
+	if err != nil {
+		gwlog.Error("%s.AddCronTimer %s: invalid spec %q: %s", e, method, spec, err)
+		return 0
+	}
+
+	tid := e.genTimerId()
+	info := &entityTimerInfo{
+		Method:       method,
+		Args:         args,
+		Repeat:       true,
+		Cron:         spec,
+		cronSchedule: schedule,
+	}
+	if !e.scheduleCronTimer(tid, info) {
+		return 0
+	}
+	e.timers[tid] = info
+	gwlog.Debug("%s.AddCronTimer %s %q: %d, next fire time %s", e, method, spec, tid, info.FireTime)
+	return tid
+}
+
+// schedule the cron timer at the next fire time, returns false if it never fires again
+func (e *Entity) scheduleCronTimer(tid EntityTimerID, info *entityTimerInfo) bool {
+	if info.cronSchedule == nil { // restored after migrating or freezing
+		schedule, err := crontab.Parse(info.Cron)
+		if err != nil {
+			gwlog.Error("%s: cron timer %s has invalid spec %q: %s", e, info.Method, info.Cron, err)
+			return false
+		}
+		info.cronSchedule = schedule
+	}
+
+	now := time.Now()
+	from := now
+	if from.Before(info.FireTime) { // raw timers might fire slightly early
+		from = info.FireTime
+	}
+	next := info.cronSchedule.Next(from)
+	if next.IsZero() {
+		gwlog.Warn("%s: cron timer %s %q never fires again", e, info.Method, info.Cron)
+		return false
+	}
+
+	info.FireTime = next
+	info.rawTimer = e.addRawCallback(next.Sub(now), func() {
+		e.triggerTimer(tid, false)
+	})
+	return true
+}
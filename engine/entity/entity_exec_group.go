@@ -0,0 +1,122 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Execution groups share the main loop time among groups of entities, so that background entities (e.g. mail
+// sweepers and analytics) can not crowd out latency-sensitive gameplay entities.
+//
+// Each group can have a time slice, which is the max fraction of main loop time used by its entities per second, e.g.
+// 0.6 for combat and 0.1 for background. Execution time is charged to groups the same way as CPU budget, and timers
+// of entities are deferred while their group uses up its slice. Groups without slices, including the default group,
+// are not limited.
+
+const (
+	DEFAULT_EXEC_GROUP = ""
+)
+
+var (
+	execGroups = map[string]*execGroup{}
+)
+
+type execGroup struct {
+	name        string
+	slice       float64 // fraction of main loop time per window, 0 for unlimited
+	windowStart time.Time
+	used        time.Duration
+	exceeded    bool
+}
+
+// Set the time slice of execution group as the max fraction of main loop time, 0 for unlimited
+func SetExecGroupSlice(group string, slice float64) {
+	if slice < 0 || slice > 1 {
+		gwlog.Panicf("SetExecGroupSlice: invalid slice of group %s: %v", group, slice)
+	}
+
+	g := getOrCreateExecGroup(group)
+	g.slice = slice
+
+	total := 0.0
+	for _, g := range execGroups {
+		total += g.slice
+	}
+	if total > 1 {
+		gwlog.Warn("SetExecGroupSlice: total slices of execution groups is %.2f, more than the main loop time", total)
+	}
+}
+
+// Get the time slice of execution group, 0 for unlimited
+func GetExecGroupSlice(group string) float64 {
+	if g := execGroups[group]; g != nil {
+		return g.slice
+	}
+	return 0
+}
+
+func getOrCreateExecGroup(name string) *execGroup {
+	g := execGroups[name]
+	if g == nil {
+		g = &execGroup{name: name}
+		execGroups[name] = g
+	}
+	return g
+}
+
+// Assign entities of this type to the execution group
+func (desc *EntityTypeDesc) SetExecGroup(group string) {
+	desc.execGroup = group
+}
+
+// Assign the entity to the execution group, which overrides the group of entity type and is not kept on migration
+func (e *Entity) SetExecGroup(group string) {
+	e.execGroup = group
+}
+
+// Get the execution group of entity
+func (e *Entity) GetExecGroup() string {
+	if e.execGroup != DEFAULT_EXEC_GROUP {
+		return e.execGroup
+	}
+	return e.typeDesc.execGroup
+}
+
+func (g *execGroup) charge(d time.Duration, now time.Time) {
+	if now.Sub(g.windowStart) >= _ENTITY_CPU_BUDGET_WINDOW {
+		g.windowStart = now
+		g.used = 0
+		g.exceeded = false
+	}
+
+	g.used += d
+	execGroupTimeMetric.With(g.name).Add(d.Seconds())
+	if g.slice > 0 && !g.exceeded && g.used >= g.sliceTime() {
+		g.exceeded = true
+		execGroupExceededMetric.With(g.name).Inc()
+		gwlog.Warn("execution group %s used up its time slice: used %s of %s, timers are deferred", g.name, g.used, g.sliceTime())
+	}
+}
+
+func (g *execGroup) sliceTime() time.Duration {
+	return time.Duration(g.slice * float64(_ENTITY_CPU_BUDGET_WINDOW))
+}
+
+func (g *execGroup) isOverSlice() bool {
+	if g.slice <= 0 || time.Since(g.windowStart) >= _ENTITY_CPU_BUDGET_WINDOW {
+		return false
+	}
+	return g.used >= g.sliceTime()
+}
+
+func (e *Entity) chargeExecGroup(d time.Duration, now time.Time) {
+	if g := execGroups[e.GetExecGroup()]; g != nil {
+		g.charge(d, now)
+	}
+}
+
+func (e *Entity) isExecGroupOverSlice() bool {
+	g := execGroups[e.GetExecGroup()]
+	return g != nil && g.isOverSlice()
+}
@@ -0,0 +1,140 @@
+package entity
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Incremental freeze captures attributes of entities continuously, so that freezing large games for hot reload only
+// handles entities changed since the last capture.
+//
+// When enabled, attributes of entities changed since the last capture are copied every FREEZE_SNAPSHOT_INTERVAL and
+// packed in the background. Freeze uses the packed attributes of entities not changed since captured, and only copies
+// attributes of changed entities. Entities are also captured when saved periodically. Timers, positions and clients
+// are cheap and always taken when freezing.
+
+type entityFreezeSnapshot struct {
+	version   uint64 // increased when attributes are changed
+	captured  uint64 // version of attrsData, 0 if not captured yet
+	capturing bool   // attributes are being packed in the background
+	attrsData []byte
+}
+
+type freezeCapture struct {
+	snapshot *entityFreezeSnapshot
+	version  uint64
+	attrs    map[string]interface{}
+	data     []byte
+	err      error
+}
+
+var (
+	incrementalFreezeEnabled bool
+)
+
+// Enable incremental freeze, called by engine if incremental_freeze is configured for the game
+func EnableIncrementalFreeze() {
+	incrementalFreezeEnabled = true
+}
+
+func (e *Entity) markFreezeDirty() {
+	if e.freezeSnapshot != nil {
+		e.freezeSnapshot.version += 1
+	}
+}
+
+// Get attributes captured by incremental freeze, nil if attributes are changed since captured
+func (e *Entity) getCapturedAttrs() json.RawMessage {
+	s := e.freezeSnapshot
+	if s == nil || s.captured != s.version {
+		return nil
+	}
+	return s.attrsData
+}
+
+// Capture attributes of entities changed since the last capture, called by engine periodically
+func CaptureFreezeSnapshots() {
+	if !incrementalFreezeEnabled {
+		return
+	}
+
+	var captures []*freezeCapture
+	for _, e := range entityManager.entities {
+		if c := e.newFreezeCapture(); c != nil {
+			captures = append(captures, c)
+		}
+	}
+	packFreezeCaptures(captures)
+}
+
+// Capture attributes of the entity saved periodically, skipped if attributes are not changed since last capture
+func (e *Entity) captureOnSave() {
+	if !incrementalFreezeEnabled {
+		return
+	}
+	if c := e.newFreezeCapture(); c != nil {
+		packFreezeCaptures([]*freezeCapture{c})
+	}
+}
+
+func (e *Entity) newFreezeCapture() *freezeCapture {
+	s := e.freezeSnapshot
+	if s == nil {
+		s = &entityFreezeSnapshot{version: 1}
+		e.freezeSnapshot = s
+	}
+	if s.capturing || s.captured == s.version {
+		return nil
+	}
+
+	s.capturing = true
+	return &freezeCapture{snapshot: s, version: s.version, attrs: e.Attrs.ToMap()}
+}
+
+// Pack attributes in the background, snapshots are updated in the game routine
+func packFreezeCaptures(captures []*freezeCapture) {
+	if len(captures) == 0 {
+		return
+	}
+
+	go func() {
+		for _, c := range captures {
+			c.data, c.err = json.Marshal(c.attrs)
+		}
+		post.Post(func() {
+			for _, c := range captures {
+				c.snapshot.capturing = false
+				if c.err != nil {
+					gwlog.TraceError("incremental freeze: pack attributes failed: %s", c.err)
+					continue
+				}
+				c.snapshot.captured, c.snapshot.attrsData = c.version, c.data
+			}
+		})
+	}()
+}
+
+// Unpack attributes captured by incremental freeze into Attrs of entities
+func unpackCapturedAttrs(freeze *FreezeData) error {
+	for eid, info := range freeze.Entities {
+		if err := unpackFreezedAttrs(eid, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unpackFreezedAttrs(eid EntityID, info *entityFreezeData) error {
+	if len(info.AttrsData) == 0 {
+		return nil
+	}
+	info.Attrs = nil
+	if err := json.Unmarshal(info.AttrsData, &info.Attrs); err != nil {
+		return errors.Wrapf(err, "unpack attributes of %s<%s> failed", info.Type, eid)
+	}
+	return nil
+}
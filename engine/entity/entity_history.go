@@ -0,0 +1,390 @@
+package entity
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Entity history records attribute mutations and RPC invocations of a single entity in a ring buffer, so that the
+// state of the entity at any recorded point can be reconstructed for debugging state corruptions.
+//
+// History is opt-in per entity and kept on the game where it is enabled, it is dropped when the entity is destroyed
+// or migrates to other game. When the ring buffer is full, the oldest records are folded into the base attributes,
+// so states can be reconstructed at any point after the oldest record in the buffer.
+//
+// History can also be managed through the pprof HTTP server of game:
+//
+//	POST   /debug/entityhistory?eid=ID&capacity=N    enable history of the entity
+//	DELETE /debug/entityhistory?eid=ID               disable history of the entity
+//	GET    /debug/entityhistory?eid=ID&from=SEQ      list records since SEQ
+//	GET    /debug/entityhistory?eid=ID&seq=SEQ       reconstruct attributes after record SEQ
+
+const (
+	ENTITY_HISTORY_ATTR = "attr" // kind of records of attribute mutations
+	ENTITY_HISTORY_RPC  = "rpc"  // kind of records of RPC invocations
+
+	// ops of attribute mutations
+	ENTITY_HISTORY_OP_MAP_SET     = "map_set"
+	ENTITY_HISTORY_OP_MAP_DEL     = "map_del"
+	ENTITY_HISTORY_OP_LIST_SET    = "list_set"
+	ENTITY_HISTORY_OP_LIST_POP    = "list_pop"
+	ENTITY_HISTORY_OP_LIST_APPEND = "list_append"
+
+	_ENTITY_HISTORY_ADMIN_TIMEOUT = time.Second * 10
+)
+
+// Record of attribute mutation or RPC invocation of entity
+type EntityHistoryRecord struct {
+	Seq  uint64
+	Time time.Time
+	Kind string // ENTITY_HISTORY_ATTR or ENTITY_HISTORY_RPC
+
+	// attribute mutation
+	Op    string        `json:",omitempty"`
+	Path  []interface{} `json:",omitempty"` // path of the changed MapAttr or ListAttr from root attributes
+	Key   interface{}   `json:",omitempty"` // key of map, or index of list
+	Value interface{}   `json:",omitempty"`
+
+	// RPC invocation
+	Method   string        `json:",omitempty"`
+	Caller   string        `json:",omitempty"` // local, server or client
+	ClientID ClientID      `json:",omitempty"`
+	Args     []interface{} `json:",omitempty"`
+}
+
+type entityHistory struct {
+	base    map[string]interface{} // attributes before the oldest record in buffer
+	baseSeq uint64                 // seq of the last record folded into base
+	records []EntityHistoryRecord
+	next    int
+	full    bool
+	seq     uint64
+}
+
+// Enable recording history of the entity with capacity of records, default capacity is used if capacity <= 0
+//
+// History is recorded from the current attributes, records are cleared if history is already enabled
+func (e *Entity) EnableHistory(capacity int) {
+	if capacity <= 0 {
+		capacity = consts.ENTITY_HISTORY_DEFAULT_CAPACITY
+	}
+
+	e.history = &entityHistory{
+		base:    e.Attrs.ToMap(),
+		records: make([]EntityHistoryRecord, capacity),
+	}
+	gwlog.Info("%s: history enabled, capacity = %d", e, capacity)
+}
+
+// Disable recording history of the entity, all records are dropped
+func (e *Entity) DisableHistory() {
+	if e.history != nil {
+		e.history = nil
+		gwlog.Info("%s: history disabled", e)
+	}
+}
+
+// Check if history of the entity is being recorded
+func (e *Entity) IsHistoryEnabled() bool {
+	return e.history != nil
+}
+
+// Get at most limit records with Seq >= fromSeq, oldest first, all records are returned if limit <= 0
+func (e *Entity) GetHistory(fromSeq uint64, limit int) []EntityHistoryRecord {
+	if e.history == nil {
+		return nil
+	}
+
+	var records []EntityHistoryRecord
+	e.history.forEach(func(rec *EntityHistoryRecord) bool {
+		if rec.Seq >= fromSeq {
+			records = append(records, *rec)
+		}
+		return limit <= 0 || len(records) < limit
+	})
+	return records
+}
+
+// Reconstruct attributes of the entity right after the record of seq, or attributes when history is enabled if seq is 0
+func (e *Entity) GetAttrsAtHistory(seq uint64) (map[string]interface{}, error) {
+	h := e.history
+	if h == nil {
+		return nil, errors.Errorf("%s: history is not enabled", e)
+	}
+	if seq < h.baseSeq {
+		return nil, errors.Errorf("%s: history before %d is discarded", e, h.baseSeq)
+	}
+	if seq > h.seq {
+		return nil, errors.Errorf("%s: history %d is not recorded yet, latest is %d", e, seq, h.seq)
+	}
+
+	attrs := deepCopyAttrValue(h.base).(map[string]interface{})
+	var err error
+	h.forEach(func(rec *EntityHistoryRecord) bool {
+		if rec.Seq > seq {
+			return false
+		}
+		if rec.Kind == ENTITY_HISTORY_ATTR {
+			err = applyAttrHistory(attrs, rec)
+		}
+		return err == nil
+	})
+	return attrs, err
+}
+
+func (h *entityHistory) forEach(f func(rec *EntityHistoryRecord) bool) {
+	if h.full {
+		for i := h.next; i < len(h.records); i++ {
+			if !f(&h.records[i]) {
+				return
+			}
+		}
+	}
+	for i := 0; i < h.next; i++ {
+		if !f(&h.records[i]) {
+			return
+		}
+	}
+}
+
+func (h *entityHistory) add(rec EntityHistoryRecord) {
+	if h.full {
+		// fold the oldest record into base before overwritten
+		oldest := &h.records[h.next]
+		if oldest.Kind == ENTITY_HISTORY_ATTR {
+			if err := applyAttrHistory(h.base, oldest); err != nil {
+				gwlog.Error("entity history: fold record %d failed: %s", oldest.Seq, err)
+			}
+		}
+		h.baseSeq = oldest.Seq
+	}
+
+	h.seq += 1
+	rec.Seq = h.seq
+	rec.Time = time.Now()
+	h.records[h.next] = rec
+
+	h.next += 1
+	if h.next == len(h.records) {
+		h.next = 0
+		h.full = true
+	}
+}
+
+func (h *entityHistory) recordAttr(op string, path []interface{}, key interface{}, val interface{}) {
+	rootPath := make([]interface{}, len(path)) // path from owner is in order of leaf to root
+	for i, k := range path {
+		rootPath[len(path)-1-i] = k
+	}
+
+	h.add(EntityHistoryRecord{
+		Kind:  ENTITY_HISTORY_ATTR,
+		Op:    op,
+		Path:  rootPath,
+		Key:   key,
+		Value: deepCopyAttrValue(val),
+	})
+}
+
+func (h *entityHistory) recordRpc(method string, caller string, clientid ClientID, in []reflect.Value) {
+	args := make([]interface{}, len(in))
+	for i, arg := range in {
+		args[i] = arg.Interface()
+	}
+
+	h.add(EntityHistoryRecord{
+		Kind:     ENTITY_HISTORY_RPC,
+		Method:   method,
+		Caller:   caller,
+		ClientID: clientid,
+		Args:     args,
+	})
+}
+
+// Apply the attribute mutation to plain attributes
+func applyAttrHistory(attrs map[string]interface{}, rec *EntityHistoryRecord) error {
+	_, err := applyAttrHistoryAt(attrs, rec.Path, rec)
+	return err
+}
+
+// Apply the mutation to the container at path, returns the new container, which is only changed for lists
+func applyAttrHistoryAt(container interface{}, path []interface{}, rec *EntityHistoryRecord) (interface{}, error) {
+	if len(path) == 0 {
+		return applyAttrHistoryOp(container, rec)
+	}
+
+	switch c := container.(type) {
+	case map[string]interface{}:
+		key, ok := path[0].(string)
+		if !ok {
+			return nil, errors.Errorf("record %d: invalid map key %v", rec.Seq, path[0])
+		}
+		child, err := applyAttrHistoryAt(c[key], path[1:], rec)
+		if err != nil {
+			return nil, err
+		}
+		c[key] = child
+		return c, nil
+	case []interface{}:
+		index, ok := path[0].(int)
+		if !ok || index < 0 || index >= len(c) {
+			return nil, errors.Errorf("record %d: invalid list index %v", rec.Seq, path[0])
+		}
+		child, err := applyAttrHistoryAt(c[index], path[1:], rec)
+		if err != nil {
+			return nil, err
+		}
+		c[index] = child
+		return c, nil
+	default:
+		return nil, errors.Errorf("record %d: path %v not found", rec.Seq, rec.Path)
+	}
+}
+
+func applyAttrHistoryOp(container interface{}, rec *EntityHistoryRecord) (interface{}, error) {
+	if m, ok := container.(map[string]interface{}); ok {
+		key, _ := rec.Key.(string)
+		if rec.Op == ENTITY_HISTORY_OP_MAP_SET {
+			m[key] = deepCopyAttrValue(rec.Value)
+			return m, nil
+		} else if rec.Op == ENTITY_HISTORY_OP_MAP_DEL {
+			delete(m, key)
+			return m, nil
+		}
+	} else if l, ok := container.([]interface{}); ok {
+		if rec.Op == ENTITY_HISTORY_OP_LIST_SET {
+			if index, ok := rec.Key.(int); ok && index >= 0 && index < len(l) {
+				l[index] = deepCopyAttrValue(rec.Value)
+				return l, nil
+			}
+		} else if rec.Op == ENTITY_HISTORY_OP_LIST_POP && len(l) > 0 {
+			return l[:len(l)-1], nil
+		} else if rec.Op == ENTITY_HISTORY_OP_LIST_APPEND {
+			return append(l, deepCopyAttrValue(rec.Value)), nil
+		}
+	}
+	return nil, errors.Errorf("record %d: can not apply %s %v to %v", rec.Seq, rec.Op, rec.Key, rec.Path)
+}
+
+// Copy plain attribute values, so that records are not changed by states reconstructed from them
+func deepCopyAttrValue(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = deepCopyAttrValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = deepCopyAttrValue(item)
+		}
+		return l
+	default:
+		return val
+	}
+}
+
+func init() {
+	http.HandleFunc("/debug/entityhistory", serveEntityHistory)
+}
+
+type entityHistoryAdminResult struct {
+	status int
+	value  interface{}
+	err    error
+}
+
+func serveEntityHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	eid := EntityID(query.Get("eid"))
+	if eid.IsNil() {
+		http.Error(w, "eid is required", http.StatusBadRequest)
+		return
+	}
+
+	var nums [3]int // capacity, from, seq
+	for i, name := range []string{"capacity", "from", "seq"} {
+		if s := query.Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid "+name+": "+s, http.StatusBadRequest)
+				return
+			}
+			nums[i] = n
+		}
+	}
+	_, hasSeq := query["seq"]
+
+	resultChan := make(chan entityHistoryAdminResult, 1)
+	// entities can only be accessed in the game routine
+	post.Post(func() {
+		resultChan <- handleEntityHistoryRequest(r.Method, eid, nums[0], uint64(nums[1]), hasSeq, uint64(nums[2]))
+	})
+
+	var res entityHistoryAdminResult
+	select {
+	case res = <-resultChan:
+	case <-time.After(_ENTITY_HISTORY_ADMIN_TIMEOUT):
+		http.Error(w, "timeout", http.StatusGatewayTimeout)
+		return
+	}
+
+	if res.status != http.StatusOK {
+		msg := http.StatusText(res.status)
+		if res.err != nil {
+			msg = res.err.Error()
+		}
+		http.Error(w, msg, res.status)
+		return
+	}
+
+	data, err := json.Marshal(res.value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+func handleEntityHistoryRequest(method string, eid EntityID, capacity int, fromSeq uint64, hasSeq bool, seq uint64) entityHistoryAdminResult {
+	e := entityManager.get(eid)
+	if e == nil {
+		return entityHistoryAdminResult{status: http.StatusNotFound}
+	}
+
+	switch method {
+	case http.MethodPost:
+		e.EnableHistory(capacity)
+		return entityHistoryAdminResult{status: http.StatusOK}
+	case http.MethodDelete:
+		e.DisableHistory()
+		return entityHistoryAdminResult{status: http.StatusOK}
+	case http.MethodGet:
+		if !e.IsHistoryEnabled() {
+			return entityHistoryAdminResult{status: http.StatusConflict, err: errors.Errorf("%s: history is not enabled", e)}
+		}
+		if !hasSeq {
+			return entityHistoryAdminResult{status: http.StatusOK, value: e.GetHistory(fromSeq, 0)}
+		}
+
+		attrs, err := e.GetAttrsAtHistory(seq)
+		if err != nil {
+			return entityHistoryAdminResult{status: http.StatusBadRequest, err: err}
+		}
+		return entityHistoryAdminResult{status: http.StatusOK, value: map[string]interface{}{"Seq": seq, "Attrs": attrs}}
+	default:
+		return entityHistoryAdminResult{status: http.StatusMethodNotAllowed}
+	}
+}
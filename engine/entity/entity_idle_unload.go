@@ -0,0 +1,73 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Idle unload saves and destroys loaded entities which are no longer used, so that long-running games do not
+// accumulate idle entities, e.g. accounts of players logged out long ago or guilds without online members.
+//
+// A persistent entity of type with idle timeout is idle if it has no client, and no RPC is called and no timer is
+// fired for the timeout. Idle entities are checked periodically, they are notified by OnUnload of IUnloadHandler and
+// then destroyed, which saves them. Entities with timers are not unloaded since timers are lost when destroyed, neither
+// are spaces, entities providing services and entities entering spaces.
+
+// Optional interface for entities to handle unloading by idle timeout
+type IUnloadHandler interface {
+	OnUnload() // Called just before the idle entity is saved and destroyed
+}
+
+// Unload entities of this type after they are idle for the timeout, 0 to disable (default)
+func (desc *EntityTypeDesc) SetIdleTimeout(timeout time.Duration) {
+	desc.idleTimeout = timeout
+}
+
+// Mark the entity as active, for checking idle timeout
+func (e *Entity) markActive() {
+	if e.typeDesc.idleTimeout > 0 {
+		e.lastActiveTime = time.Now()
+	}
+}
+
+func (e *Entity) isIdle(now time.Time) bool {
+	timeout := e.typeDesc.idleTimeout
+	if timeout <= 0 || e.destroyed || now.Sub(e.lastActiveTime) < timeout {
+		return false
+	}
+	return e.I.IsPersistent() && !e.IsSpaceEntity() && e.client == nil && len(e.timers) == 0 &&
+		!e.isEnteringSpace() && !entityManager.isServiceProvider(e.ID)
+}
+
+// Unload idle entities of types with idle timeout, called by engine periodically
+func UnloadIdleEntities() {
+	now := time.Now()
+	var idleEntities []*Entity
+	for typeName, desc := range registeredEntityTypes {
+		if desc.idleTimeout <= 0 {
+			continue
+		}
+		for eid := range entityManager.typeIndex[typeName] {
+			if e := entityManager.get(eid); e != nil && e.isIdle(now) {
+				idleEntities = append(idleEntities, e)
+			}
+		}
+	}
+
+	for _, e := range idleEntities {
+		if e.destroyed { // destroyed by OnUnload of other entities
+			continue
+		}
+
+		gwlog.Info("%s is idle for %s, unloading ...", e, now.Sub(e.lastActiveTime))
+		if handler, ok := e.I.(IUnloadHandler); ok {
+			gwutils.RunPanicless(handler.OnUnload)
+		}
+		if !e.destroyed {
+			idleUnloadsMetric.With(e.TypeName).Inc()
+			e.Destroy()
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/metrics"
+)
+
+// Metrics of entities and RPCs on this game, exported on /metrics
+//
+// Only RPC methods declared by entity types are recorded, so label values are bounded by the entity types and can
+// not be flooded by clients calling unknown methods.
+
+const (
+	_RPC_CALLER_LOCAL  = "local"  // called by entities on the same game
+	_RPC_CALLER_SERVER = "server" // called by entities on other games
+	_RPC_CALLER_CLIENT = "client"
+)
+
+var (
+	entityCountMetric = metrics.NewGaugeVec("goworld_entities",
+		"Number of entities on this game by type", "type")
+	rpcCallsMetric = metrics.NewCounterVec("goworld_rpc_calls_total",
+		"Number of RPC calls handled by entities by type, method and caller", "type", "method", "caller")
+	rpcDurationMetric = metrics.NewHistogramVec("goworld_rpc_duration_seconds",
+		"Execution time of RPC methods by type and method", nil, "type", "method")
+	rpcResultLatencyMetric = metrics.NewHistogramVec("goworld_rpc_result_latency_seconds",
+		"Round trip time of calls with results by method, including timeouts", nil, "method")
+	rpcTimeoutsMetric = metrics.NewCounterVec("goworld_rpc_timeouts_total",
+		"Number of calls with results which are not replied before timeout by method", "method")
+	cpuBudgetExceededMetric = metrics.NewCounterVec("goworld_entity_cpu_budget_exceeded_total",
+		"Number of times entities exceeded CPU budget in a second by type", "type")
+	deferredTimersMetric = metrics.NewCounterVec("goworld_entity_deferred_timers_total",
+		"Number of timers deferred by CPU budget or execution group by type", "type")
+	execGroupTimeMetric = metrics.NewCounterVec("goworld_exec_group_seconds_total",
+		"Execution time of entities by execution group", "group")
+	execGroupExceededMetric = metrics.NewCounterVec("goworld_exec_group_slice_exceeded_total",
+		"Number of times execution groups used up time slices in a second by group", "group")
+	idleUnloadsMetric = metrics.NewCounterVec("goworld_entity_idle_unloads_total",
+		"Number of entities unloaded by idle timeout by type", "type")
+)
+
+func recordEntityCreated(typeName string) {
+	entityCountMetric.With(typeName).Inc()
+}
+
+func recordEntityDestroyed(typeName string) {
+	entityCountMetric.With(typeName).Dec()
+}
+
+// Record the RPC call which started at startTime, should be deferred before calling the method
+func recordRpcCall(typeName string, methodName string, caller string, startTime time.Time) {
+	rpcCallsMetric.With(typeName, methodName, caller).Inc()
+	rpcDurationMetric.With(typeName, methodName).ObserveDuration(time.Since(startTime))
+}
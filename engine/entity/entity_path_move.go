@@ -0,0 +1,106 @@
+package entity
+
+import (
+	"math"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Path movement moves entities along paths on the server, e.g. monsters chasing players on the navmesh
+//
+// Positions and yaws are updated every _PATH_MOVE_INTERVAL and synced to clients like SetPosition. The movement stops
+// when the entity arrives at the end of the path, leaves the space, or SetPosition is called on it.
+
+const (
+	_PATH_MOVE_INTERVAL = time.Millisecond * 100
+)
+
+type entityPathMove struct {
+	path     []Position // remaining corners of the path
+	speed    Coord
+	space    *Space
+	lastTick time.Time
+	timer    *timer.Timer
+	onArrive func()
+}
+
+// Move along the path with the speed per second, onArrive is called when the end of path is reached, it can be nil
+func (e *Entity) MoveAlongPath(path []Position, speed Coord, onArrive func()) {
+	e.StopMoving()
+	if len(path) == 0 || speed <= 0 || e.Space == nil || e.Space.IsNil() {
+		return
+	}
+
+	move := &entityPathMove{
+		path:     append([]Position{}, path...),
+		speed:    speed,
+		space:    e.Space,
+		lastTick: time.Now(),
+		onArrive: onArrive,
+	}
+	move.timer = e.addRawTimer(_PATH_MOVE_INTERVAL, e.tickPathMove)
+	e.pathMove = move
+}
+
+// Find the path to the position on the navmesh of space and move along it, see MoveAlongPath
+func (e *Entity) NavigateTo(pos Position, speed Coord, onArrive func()) error {
+	path, err := e.Space.FindPath(e.aoi.pos, pos)
+	if err != nil {
+		return err
+	}
+	e.MoveAlongPath(path, speed, onArrive)
+	return nil
+}
+
+// Stop moving along path
+func (e *Entity) StopMoving() {
+	if e.pathMove == nil {
+		return
+	}
+	e.cancelRawTimer(e.pathMove.timer)
+	e.pathMove = nil
+}
+
+// Check if the entity is moving along path
+func (e *Entity) IsMoving() bool {
+	return e.pathMove != nil
+}
+
+func (e *Entity) tickPathMove() {
+	move := e.pathMove
+	if e.Space != move.space || e.isEnteringSpace() {
+		e.StopMoving()
+		return
+	}
+
+	now := time.Now()
+	step := move.speed * Coord(now.Sub(move.lastTick).Seconds())
+	move.lastTick = now
+
+	pos, yaw := e.aoi.pos, e.yaw
+	for step > 0 && len(move.path) > 0 {
+		target := move.path[0]
+		if dx, dz := target.X-pos.X, target.Z-pos.Z; dx != 0 || dz != 0 {
+			yaw = Yaw(math.Atan2(float64(dx), float64(dz)))
+		}
+
+		if d := pos.DistanceTo(target); d <= step {
+			pos, step = target, step-d
+			move.path = move.path[1:]
+		} else {
+			t := step / d
+			pos = Position{pos.X + (target.X-pos.X)*t, pos.Y + (target.Y-pos.Y)*t, pos.Z + (target.Z-pos.Z)*t}
+			step = 0
+		}
+	}
+
+	e.setPositionYaw(pos, yaw, false)
+	if len(move.path) == 0 && e.pathMove == move {
+		e.StopMoving()
+		if move.onArrive != nil {
+			gwutils.RunPanicless(move.onArrive)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package entity
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Entity profiler samples the entity type and method which is currently executing in the game routine, so that
+// main loop time can be attributed to game content rather than Go functions.
+//
+// Profiles are written in the folded stack format which can be rendered by flamegraph.pl directly:
+//
+//	Avatar;EnterSpace;SpaceService;OnPlayerEntered 12
+//
+// Profiles can also be taken through the pprof HTTP server of game:
+//
+//	GET /debug/entityprof?seconds=30
+
+const (
+	_ENTITY_PROFILE_DEFAULT_SECONDS = 30
+	_ENTITY_PROFILE_MAX_SECONDS     = 600
+)
+
+var (
+	// Error of starting profiler which is already running
+	ErrEntityProfilerRunning = errors.New("entity profiler is already running")
+
+	entityProfiler = &entityProfilerState{}
+)
+
+type entityProfilerState struct {
+	sync.Mutex
+	running bool
+	frame   string // folded stack of currently executing entity methods, empty if no entity method is executing
+	samples map[string]int
+	total   int // total number of samples, including samples without entity methods executing
+	start   time.Time
+	stop    chan struct{}
+}
+
+// Profile of entity methods, which is the number of samples of each folded stack
+type EntityProfile struct {
+	Samples  map[string]int
+	Total    int // total number of samples, including samples when no entity method is executing
+	Interval time.Duration
+	Duration time.Duration
+}
+
+func init() {
+	http.HandleFunc("/debug/entityprof", serveEntityProfile)
+}
+
+// Start the entity profiler, returns ErrEntityProfilerRunning if it is already running
+func StartEntityProfiler() error {
+	p := entityProfiler
+	p.Lock()
+	defer p.Unlock()
+
+	if p.running {
+		return ErrEntityProfilerRunning
+	}
+
+	p.running = true
+	p.frame = ""
+	p.samples = map[string]int{}
+	p.total = 0
+	p.start = time.Now()
+	p.stop = make(chan struct{})
+	go p.sampleRoutine(p.stop)
+	gwlog.Info("Entity profiler started, sample interval = %s", consts.ENTITY_PROFILER_SAMPLE_INTERVAL)
+	return nil
+}
+
+// Stop the entity profiler and returns the profile, returns nil if the profiler is not running
+func StopEntityProfiler() *EntityProfile {
+	p := entityProfiler
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.running {
+		return nil
+	}
+
+	close(p.stop)
+	profile := &EntityProfile{
+		Samples:  p.samples,
+		Total:    p.total,
+		Interval: consts.ENTITY_PROFILER_SAMPLE_INTERVAL,
+		Duration: time.Since(p.start),
+	}
+	p.running = false
+	p.frame = ""
+	p.samples = nil
+	p.stop = nil
+	gwlog.Info("Entity profiler stopped, %d samples in %s", profile.Total, profile.Duration)
+	return profile
+}
+
+// Check if the entity profiler is running
+func IsEntityProfilerRunning() bool {
+	p := entityProfiler
+	p.Lock()
+	running := p.running
+	p.Unlock()
+	return running
+}
+
+// Write the profile in folded stack format, stacks with more samples are written first
+func (profile *EntityProfile) WriteFolded(w io.Writer) error {
+	stacks := make([]string, 0, len(profile.Samples))
+	for stack := range profile.Samples {
+		stacks = append(stacks, stack)
+	}
+	sort.Slice(stacks, func(i, j int) bool {
+		ci, cj := profile.Samples[stacks[i]], profile.Samples[stacks[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return stacks[i] < stacks[j]
+	})
+
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%s %d\n", stack, profile.Samples[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *entityProfilerState) sampleRoutine(stop chan struct{}) {
+	ticker := time.NewTicker(consts.ENTITY_PROFILER_SAMPLE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.Lock()
+			if p.running {
+				p.total += 1
+				if p.frame != "" {
+					p.samples[p.frame] += 1
+				}
+			}
+			p.Unlock()
+		}
+	}
+}
+
+// enter the profile frame of entity method, returns the parent frame which should be restored by leaveProfFrame
+func (e *Entity) enterProfFrame(methodName string) (parent string, entered bool) {
+	p := entityProfiler
+	p.Lock()
+	if !p.running {
+		p.Unlock()
+		return "", false
+	}
+
+	parent = p.frame
+	if parent == "" {
+		p.frame = e.TypeName + ";" + methodName
+	} else {
+		p.frame = parent + ";" + e.TypeName + ";" + methodName
+	}
+	p.Unlock()
+	return parent, true
+}
+
+func leaveProfFrame(parent string, entered bool) {
+	if !entered {
+		return
+	}
+
+	p := entityProfiler
+	p.Lock()
+	p.frame = parent
+	p.Unlock()
+}
+
+func serveEntityProfile(w http.ResponseWriter, r *http.Request) {
+	seconds := _ENTITY_PROFILE_DEFAULT_SECONDS
+	if s := r.URL.Query().Get("seconds"); s != "" {
+		var err error
+		if seconds, err = strconv.Atoi(s); err != nil || seconds <= 0 || seconds > _ENTITY_PROFILE_MAX_SECONDS {
+			http.Error(w, "invalid seconds: "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := StartEntityProfiler(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	time.Sleep(time.Duration(seconds) * time.Second)
+	profile := StopEntityProfiler()
+	if profile == nil {
+		http.Error(w, "entity profiler is stopped by others", http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	profile.WriteFolded(w)
+}
@@ -0,0 +1,21 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/engine/gwrand"
+)
+
+// Get the random number stream of entity with the name, e.g. "loot", the stream is created with logged seed
+//
+// Streams are not migrated or persistent, new streams with new seeds are created after migration or loading
+func (e *Entity) Rand(name string) *gwrand.Stream {
+	if s, ok := e.randStreams[name]; ok {
+		return s
+	}
+
+	if e.randStreams == nil {
+		e.randStreams = map[string]*gwrand.Stream{}
+	}
+	s := gwrand.NewStream(e.String() + "/" + name)
+	e.randStreams[name] = s
+	return s
+}
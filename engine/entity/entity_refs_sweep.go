@@ -0,0 +1,82 @@
+package entity
+
+import (
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// References of destroyed entities are removed from engine-side maps when entities are destroyed, and the
+// periodical sweep removes the references left by lost notifications, so that long-running games do not
+// accumulate dead EntityIDs. Services of remote entities are swept by the dispatcher, which knows all entities.
+
+// Numbers of stale references removed by sweeps
+type EntityRefsSweepStats struct {
+	Sweeps        uint64 // number of sweeps
+	OwnerOfClient uint64 // clients owned by destroyed entities, or entities no longer own the client
+	ServiceGames  uint64 // games of entities which provide no service
+	ProviderLists uint64 // cached provider lists of undeclared services
+}
+
+var entityRefsSweepStats EntityRefsSweepStats
+
+// Get the numbers of stale references removed by sweeps since game started
+func GetEntityRefsSweepStats() EntityRefsSweepStats {
+	return entityRefsSweepStats
+}
+
+// Sweep stale references of destroyed entities, called by engine periodically
+func SweepEntityReferences() {
+	em := entityManager
+	var stats EntityRefsSweepStats
+	stats.Sweeps = 1
+
+	for clientid, eid := range em.ownerOfClient {
+		owner := em.get(eid)
+		if owner == nil || owner.client == nil || owner.client.clientid != clientid {
+			delete(em.ownerOfClient, clientid)
+			stats.OwnerOfClient += 1
+		}
+	}
+
+	for eid := range em.serviceGames {
+		if !em.isServiceProvider(eid) {
+			delete(em.serviceGames, eid)
+			stats.ServiceGames += 1
+		}
+	}
+
+	for serviceName := range em.serviceProviderLists {
+		if len(em.registeredServices[serviceName]) == 0 {
+			delete(em.serviceProviderLists, serviceName)
+			stats.ProviderLists += 1
+		}
+	}
+
+	entityRefsSweepStats.Sweeps += stats.Sweeps
+	entityRefsSweepStats.OwnerOfClient += stats.OwnerOfClient
+	entityRefsSweepStats.ServiceGames += stats.ServiceGames
+	entityRefsSweepStats.ProviderLists += stats.ProviderLists
+
+	if stats.OwnerOfClient+stats.ServiceGames+stats.ProviderLists > 0 {
+		gwlog.Warn("SweepEntityReferences: stale references removed: ownerOfClient=%d, serviceGames=%d, providerLists=%d",
+			stats.OwnerOfClient, stats.ServiceGames, stats.ProviderLists)
+	}
+}
+
+func (em *EntityManager) isServiceProvider(eid EntityID) bool {
+	for _, eids := range em.registeredServices {
+		if eids.Contains(eid) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove references of the destroyed local entity, the dispatcher also undeclares its services for other games
+func (em *EntityManager) onEntityDestroyed(eid EntityID) {
+	for serviceName, eids := range em.registeredServices {
+		if eids.Contains(eid) {
+			em.onUndeclareService(serviceName, eid)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package entity
+
+import (
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Warm standby games with streaming replication of critical entities
+//
+// A game configured with standby_of is a standby of the primary game, and is never chosen for creating or loading
+// entities anywhere. Entities of types enabled by EntityTypeDesc.SetReplicated are replicated from the primary game
+// to its standby games: attributes, client and position of changed entities are sent every GAME_REPLICATION_INTERVAL
+// through dispatchers, and standby games keep the latest replicas without creating entities.
+//
+// When the primary game is disconnected and not reconnected in STANDBY_GAME_TAKEOVER_DELAY, dispatchers tell the
+// standby game to take over: replicas are created as entities in the nil space with their clients, calls to them are
+// routed to the standby game, and OnTakenOver is called if entities implement ITakeoverHandler. Changes in the last
+// replication interval, timers and entities of other types on the primary game are lost.
+
+type entityReplicaState struct {
+	dirty bool // attributes or client changed since last replication
+	pos   Position
+	yaw   Yaw
+}
+
+// Replica of entity sent to standby games
+type entityReplicaData struct {
+	Attrs  map[string]interface{}
+	Client *clientData
+	Pos    Position
+	Yaw    Yaw
+}
+
+type gameEntityReplica struct {
+	typeName string
+	data     []byte // packed entityReplicaData
+}
+
+// Optional interface for entities to handle being taken over by standby game
+type ITakeoverHandler interface {
+	OnTakenOver() // Called when the entity is created from replica after the primary game failed
+}
+
+var (
+	replicationEnabled bool
+	replicatedEntities = EntitySet{}
+	gameReplicas       = map[uint16]map[EntityID]*gameEntityReplica{} // replicas received from primary games
+)
+
+// Replicate entities of this type to standby games of the primary game
+//
+// Should be used for critical entities only, since all attributes of changed entities are replicated
+func (desc *EntityTypeDesc) SetReplicated() *EntityTypeDesc {
+	desc.replicated = true
+	return desc
+}
+
+// Enable replication of entities to standby games, called by engine if this game has standby games
+func EnableReplication() {
+	replicationEnabled = true
+}
+
+func (e *Entity) startReplication() {
+	if !replicationEnabled || !e.typeDesc.replicated {
+		return
+	}
+	e.replica = &entityReplicaState{dirty: true}
+	replicatedEntities.Add(e)
+}
+
+func (e *Entity) stopReplication() {
+	if e.replica == nil {
+		return
+	}
+	e.replica = nil
+	replicatedEntities.Del(e)
+	dispatcher_client.GetDispatcherClientForEntity(e.ID).SendReplicateGameEntity(e.ID, e.TypeName, nil)
+}
+
+func (e *Entity) markReplicaDirty() {
+	if e.replica != nil {
+		e.replica.dirty = true
+	}
+}
+
+// Replicate entities changed since last replication to standby games, called by engine periodically
+func FlushReplication() {
+	for e := range replicatedEntities {
+		r := e.replica
+		if !r.dirty && r.pos == e.aoi.pos && r.yaw == e.yaw {
+			continue
+		}
+		r.dirty, r.pos, r.yaw = false, e.aoi.pos, e.yaw
+
+		replica := &entityReplicaData{
+			Attrs: e.putCalendarSubscriptions(e.putStorageRevision(e.I.GetMigrateData())),
+			Pos:   e.aoi.pos,
+			Yaw:   e.yaw,
+		}
+		if e.client != nil {
+			replica.Client = &clientData{ClientID: e.client.clientid, GateID: e.client.gateid}
+		}
+		data, err := netutil.MSG_PACKER.PackMsg(replica, nil)
+		if err != nil {
+			gwlog.TraceError("%s: pack replica failed: %s", e, err)
+			continue
+		}
+		dispatcher_client.GetDispatcherClientForEntity(e.ID).SendReplicateGameEntity(e.ID, e.TypeName, data)
+	}
+}
+
+// Called by engine when the replica of entity is received from the primary game
+func OnReplicateGameEntity(primary uint16, eid EntityID, typeName string, data []byte) {
+	replicas := gameReplicas[primary]
+	if len(data) == 0 {
+		delete(replicas, eid)
+		return
+	}
+
+	if replicas == nil {
+		replicas = map[EntityID]*gameEntityReplica{}
+		gameReplicas[primary] = replicas
+	}
+	replicas[eid] = &gameEntityReplica{typeName: typeName, data: append([]byte(nil), data...)} // data is in the packet
+}
+
+// Called by engine when the primary game failed, entities are created from replicas of the primary game
+func OnTakeoverGame(primary uint16) {
+	replicas := gameReplicas[primary]
+	delete(gameReplicas, primary)
+	gwlog.Warn("Game %d failed, taking over %d replicated entities ...", primary, len(replicas))
+
+	for eid, replica := range replicas {
+		if entityManager.get(eid) != nil {
+			continue
+		}
+		if _, ok := registeredEntityTypes[replica.typeName]; !ok {
+			gwlog.TraceError("OnTakeoverGame: unknown entity type of replica %s<%s>", replica.typeName, eid)
+			continue
+		}
+
+		var data entityReplicaData
+		if err := netutil.MSG_PACKER.UnpackMsg(replica.data, &data); err != nil {
+			gwlog.TraceError("OnTakeoverGame: unpack replica of %s<%s> failed: %s", replica.typeName, eid, err)
+			continue
+		}
+		var client *GameClient
+		if data.Client != nil {
+			client = MakeGameClient(data.Client.ClientID, data.Client.GateID)
+		}
+		createEntity(replica.typeName, nilSpace, data.Pos, eid, data.Attrs, nil, client, ccTakeover)
+		if e := entityManager.get(eid); e != nil {
+			e.yaw = data.Yaw
+		}
+	}
+}
@@ -0,0 +1,432 @@
+package entity
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/yuin/gopher-lua"
+)
+
+// Entity scripts implement RPC methods and behaviors (e.g. AI) of entity types in Lua, so designers can modify them
+// without recompiling or restarting games.
+//
+// The script of an entity type returns a table of functions, which are called with the entity as the first argument:
+//
+//	local Monster = {}
+//	function Monster.TakeDamage(self, damage)
+//		self:Set("hp", self:Get("hp") - damage)
+//	end
+//	function Monster.Think(self)
+//		local x, y, z = self:GetPosition()
+//		self:SetPosition(x + 1, y, z)
+//	end
+//	return Monster
+//
+// Functions are called as RPC methods if the entity type has no Go method of the name, with the same rules of
+// _Client and _AllClient suffixes, so they can also be called by timers of AddCallback and AddTimer. Go code calls
+// script functions by Entity.CallScript, e.g. in OnCreated to start the AI timer.
+//
+// Scripts are reloaded when the files are modified, the script is kept if the new one fails to load. All scripts run
+// in one Lua state in the game goroutine, so globals of scripts are shared and should be avoided.
+
+const (
+	_SCRIPT_ENTITY_METATABLE = "goworld.Entity"
+)
+
+type entityScript struct {
+	path    string
+	module  *lua.LTable
+	rpcs    map[string]scriptRpc // functions callable as RPC by RPC names
+	modTime time.Time
+}
+
+type scriptRpc struct {
+	function string
+	flags    uint
+}
+
+var (
+	scriptState   *lua.LState
+	entityScripts []*entityScript
+)
+
+// Set the Lua script of the entity type, which is watched and reloaded when modified
+//
+// Should be called on all games before running
+func (desc *EntityTypeDesc) SetScript(path string) {
+	if desc.script != nil {
+		gwlog.Panicf("SetScript: script of the entity type is already set to %s", desc.script.path)
+	}
+	if scriptState == nil {
+		initScriptState()
+	}
+
+	script := &entityScript{path: path}
+	if err := script.load(); err != nil {
+		gwlog.Panicf("SetScript: %s", err)
+	}
+	desc.script = script
+	entityScripts = append(entityScripts, script)
+}
+
+// Reload scripts of all entity types immediately, returns the error of the first script failed to load
+func ReloadScripts() error {
+	var firstErr error
+	for _, script := range entityScripts {
+		if err := script.load(); err != nil {
+			gwlog.Error("Reload entity script failed, current script is kept: %s", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func initScriptState() {
+	scriptState = lua.NewState()
+	mt := scriptState.NewTypeMetatable(_SCRIPT_ENTITY_METATABLE)
+	scriptState.SetField(mt, "__index", scriptState.SetFuncs(scriptState.NewTable(), scriptEntityMethods))
+	scriptState.SetField(mt, "__tostring", scriptState.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(checkScriptEntity(L).String()))
+		return 1
+	}))
+
+	timer.AddTimer(consts.ENTITY_SCRIPT_WATCH_INTERVAL, reloadModifiedScripts)
+}
+
+func reloadModifiedScripts() {
+	for _, script := range entityScripts {
+		if modTime := scriptModTime(script.path); !modTime.IsZero() && !modTime.Equal(script.modTime) {
+			gwlog.Info("Entity script %s is modified, reloading ...", script.path)
+			if err := script.load(); err != nil {
+				gwlog.Error("Reload entity script failed, current script is kept: %s", err)
+				script.modTime = modTime // do not retry until modified again
+			}
+		}
+	}
+}
+
+// Get the modification time of script file, zero if the file can not be accessed, e.g. when it is being replaced
+func scriptModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (script *entityScript) load() error {
+	modTime := scriptModTime(script.path)
+	L := scriptState
+	fn, err := L.LoadFile(script.path)
+	if err != nil {
+		return errors.Wrapf(err, "load script %s failed", script.path)
+	}
+	if err := L.CallByParam(lua.P{Fn: fn, NRet: 1, Protect: true}); err != nil {
+		return errors.Wrapf(err, "run script %s failed", script.path)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	module, ok := ret.(*lua.LTable)
+	if !ok {
+		return errors.Errorf("script %s should return a table of functions, but returns %s", script.path, ret.Type())
+	}
+
+	rpcs := map[string]scriptRpc{}
+	module.ForEach(func(k lua.LValue, v lua.LValue) {
+		if name, ok := k.(lua.LString); ok && v.Type() == lua.LTFunction {
+			rpcName, flags := parseRpcMethodName(string(name))
+			rpcs[rpcName] = scriptRpc{function: string(name), flags: flags}
+		}
+	})
+
+	script.module = module
+	script.rpcs = rpcs
+	script.modTime = modTime
+	gwlog.Info("Entity script %s loaded: %d functions", script.path, len(rpcs))
+	return nil
+}
+
+// Call the function of the script of entity type, the entity is passed as the first argument
+func (e *Entity) CallScript(function string, args ...interface{}) error {
+	script := e.typeDesc.script
+	if script == nil {
+		return errors.Errorf("%s has no script", e)
+	}
+	fn := script.module.RawGetString(function)
+	if fn.Type() != lua.LTFunction {
+		return errors.Errorf("function %s is not found in script %s", function, script.path)
+	}
+
+	luaArgs := make([]lua.LValue, len(args)+1)
+	luaArgs[0] = e.scriptSelf()
+	for i, arg := range args {
+		luaArgs[i+1] = toLuaValue(scriptState, arg)
+	}
+	return scriptState.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, luaArgs...)
+}
+
+func (e *Entity) getScriptRpc(methodName string) (scriptRpc, bool) {
+	if e.typeDesc.script == nil {
+		return scriptRpc{}, false
+	}
+	rpc, ok := e.typeDesc.script.rpcs[methodName]
+	return rpc, ok
+}
+
+// Call the script function as RPC from server
+func (e *Entity) invokeScriptFromLocal(methodName string, rpc scriptRpc, args []interface{}) {
+	defer recordRpcCall(e.TypeName, methodName, _RPC_CALLER_LOCAL, time.Now())
+	if err := e.CallScript(rpc.function, args...); err != nil {
+		gwlog.Panicf("%s.onCallFromLocal: script method %s failed: %s", e, methodName, err)
+	}
+}
+
+// Call the script function as RPC with packed arguments
+func (e *Entity) invokeScriptFromRemote(methodName string, rpc scriptRpc, args [][]byte, clientid ClientID) error {
+	caller := _RPC_CALLER_SERVER
+	if clientid == "" {
+		if rpc.flags&RF_SERVER == 0 {
+			return errors.Errorf("script method %s can not be called from Server", methodName)
+		}
+	} else {
+		caller = _RPC_CALLER_CLIENT
+		isFromOwnClient := clientid == e.getClientID()
+		if rpc.flags&RF_OWN_CLIENT == 0 && isFromOwnClient {
+			return errors.Errorf("script method %s can not be called from OwnClient", methodName)
+		} else if rpc.flags&RF_OTHER_CLIENT == 0 && !isFromOwnClient {
+			return errors.Errorf("script method %s can not be called from OtherClient", methodName)
+		}
+	}
+	defer recordRpcCall(e.TypeName, methodName, caller, time.Now())
+
+	unpacked := make([]interface{}, len(args))
+	for i, arg := range args {
+		if err := netutil.MSG_PACKER.UnpackMsg(arg, &unpacked[i]); err != nil {
+			return errors.Wrapf(err, "unpack argument %d of script method %s failed", i+1, methodName)
+		}
+	}
+	return e.CallScript(rpc.function, unpacked...)
+}
+
+func (e *Entity) scriptSelf() *lua.LUserData {
+	ud := scriptState.NewUserData()
+	ud.Value = e
+	scriptState.SetMetatable(ud, scriptState.GetTypeMetatable(_SCRIPT_ENTITY_METATABLE))
+	return ud
+}
+
+func checkScriptEntity(L *lua.LState) *Entity {
+	ud := L.CheckUserData(1)
+	if e, ok := ud.Value.(*Entity); ok {
+		return e
+	}
+	L.ArgError(1, "entity expected")
+	return nil
+}
+
+// arguments from index start to the top of stack
+func scriptArgs(L *lua.LState, start int) []interface{} {
+	var args []interface{}
+	for i := start; i <= L.GetTop(); i++ {
+		args = append(args, fromLuaValue(L.Get(i)))
+	}
+	return args
+}
+
+func scriptDuration(L *lua.LState, n int) time.Duration {
+	return time.Duration(float64(L.CheckNumber(n)) * float64(time.Second))
+}
+
+// Methods of entities in scripts, durations are in seconds
+var scriptEntityMethods = map[string]lua.LGFunction{
+	"ID": func(L *lua.LState) int {
+		L.Push(lua.LString(checkScriptEntity(L).ID))
+		return 1
+	},
+	"TypeName": func(L *lua.LState) int {
+		L.Push(lua.LString(checkScriptEntity(L).TypeName))
+		return 1
+	},
+	"IsDestroyed": func(L *lua.LState) int {
+		L.Push(lua.LBool(checkScriptEntity(L).IsDestroyed()))
+		return 1
+	},
+	"Destroy": func(L *lua.LState) int {
+		checkScriptEntity(L).Destroy()
+		return 0
+	},
+	"Get": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		L.Push(toLuaValue(L, e.Attrs.Get(L.CheckString(2))))
+		return 1
+	},
+	"Set": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		key := L.CheckString(2)
+		switch val := fromLuaValue(L.Get(3)).(type) {
+		case nil:
+			e.Attrs.Del(key)
+		case map[string]interface{}:
+			ma := NewMapAttr()
+			ma.AssignMap(val)
+			e.Attrs.Set(key, ma)
+		case []interface{}:
+			la := NewListAttr()
+			la.AssignList(val)
+			e.Attrs.Set(key, la)
+		default:
+			e.Attrs.Set(key, val)
+		}
+		return 0
+	},
+	"GetPosition": func(L *lua.LState) int {
+		pos := checkScriptEntity(L).GetPosition()
+		L.Push(lua.LNumber(pos.X))
+		L.Push(lua.LNumber(pos.Y))
+		L.Push(lua.LNumber(pos.Z))
+		return 3
+	},
+	"SetPosition": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		e.SetPosition(Position{Coord(L.CheckNumber(2)), Coord(L.CheckNumber(3)), Coord(L.CheckNumber(4))})
+		return 0
+	},
+	"Call": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		e.Call(EntityID(L.CheckString(2)), L.CheckString(3), scriptArgs(L, 4)...)
+		return 0
+	},
+	"CallService": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		e.CallService(L.CheckString(2), L.CheckString(3), scriptArgs(L, 4)...)
+		return 0
+	},
+	"CallClient": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		e.CallClient(L.CheckString(2), scriptArgs(L, 3)...)
+		return 0
+	},
+	"AddCallback": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		L.Push(lua.LNumber(e.AddCallback(scriptDuration(L, 2), L.CheckString(3), scriptArgs(L, 4)...)))
+		return 1
+	},
+	"AddTimer": func(L *lua.LState) int {
+		e := checkScriptEntity(L)
+		L.Push(lua.LNumber(e.AddTimer(scriptDuration(L, 2), L.CheckString(3), scriptArgs(L, 4)...)))
+		return 1
+	},
+	"CancelTimer": func(L *lua.LState) int {
+		checkScriptEntity(L).CancelTimer(EntityTimerID(L.CheckInt(2)))
+		return 0
+	},
+	"Log": func(L *lua.LState) int {
+		gwlog.Info("%s: %s", checkScriptEntity(L), L.CheckString(2))
+		return 0
+	},
+}
+
+func toLuaValue(L *lua.LState, v interface{}) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case string:
+		return lua.LString(v)
+	case []byte:
+		return lua.LString(v)
+	case EntityID:
+		return lua.LString(v)
+	case int:
+		return lua.LNumber(v)
+	case int8:
+		return lua.LNumber(v)
+	case int16:
+		return lua.LNumber(v)
+	case int32:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case uint:
+		return lua.LNumber(v)
+	case uint8:
+		return lua.LNumber(v)
+	case uint16:
+		return lua.LNumber(v)
+	case uint32:
+		return lua.LNumber(v)
+	case uint64:
+		return lua.LNumber(v)
+	case float32:
+		return lua.LNumber(v)
+	case float64:
+		return lua.LNumber(v)
+	case *MapAttr:
+		return toLuaValue(L, v.ToMap())
+	case *ListAttr:
+		return toLuaValue(L, v.ToList())
+	case map[string]interface{}:
+		t := L.NewTable()
+		for k, item := range v {
+			t.RawSetString(k, toLuaValue(L, item))
+		}
+		return t
+	case map[interface{}]interface{}:
+		t := L.NewTable()
+		for k, item := range v {
+			t.RawSetString(fmt.Sprint(k), toLuaValue(L, item))
+		}
+		return t
+	case []interface{}:
+		t := L.NewTable()
+		for i, item := range v {
+			t.RawSetInt(i+1, toLuaValue(L, item))
+		}
+		return t
+	default:
+		return lua.LString(fmt.Sprint(v))
+	}
+}
+
+// Convert Lua values to attribute values, integers become int64, tables become lists if they are sequences
+func fromLuaValue(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LString:
+		return string(v)
+	case lua.LNumber:
+		f := float64(v)
+		if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return f
+	case *lua.LTable:
+		if n := v.Len(); n > 0 {
+			l := make([]interface{}, n)
+			for i := 0; i < n; i++ {
+				l[i] = fromLuaValue(v.RawGetInt(i + 1))
+			}
+			return l
+		}
+		m := map[string]interface{}{}
+		v.ForEach(func(k lua.LValue, item lua.LValue) {
+			m[k.String()] = fromLuaValue(item)
+		})
+		return m
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,71 @@
+package entity
+
+import (
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Tags mark local entities for fast lookups, e.g. all bosses or all entities of a guild, so that per-frame queries
+// need not iterate all entities
+//
+// Entity manager maintains the index from tags to entities, which is updated when tags are added or removed and when
+// entities are destroyed or migrated out. Tags are not persistent and not migrated with entities, so they should be
+// added again in OnMigrateIn or OnRestored if necessary.
+
+// Add tag to the entity
+func (e *Entity) AddTag(tag string) {
+	if e.destroyed {
+		gwlog.Error("%s.AddTag(%s): entity is destroyed", e, tag)
+		return
+	}
+	if e.tags == nil {
+		e.tags = StringSet{}
+	} else if e.tags.Contains(tag) {
+		return
+	}
+
+	e.tags.Add(tag)
+	entities := entityManager.tagIndex[tag]
+	if entities == nil {
+		entities = EntitySet{}
+		entityManager.tagIndex[tag] = entities
+	}
+	entities.Add(e)
+}
+
+// Remove tag from the entity
+func (e *Entity) RemoveTag(tag string) {
+	if !e.tags.Contains(tag) {
+		return
+	}
+
+	e.tags.Remove(tag)
+	entities := entityManager.tagIndex[tag]
+	entities.Del(e)
+	if len(entities) == 0 {
+		delete(entityManager.tagIndex, tag)
+	}
+}
+
+// Check if the entity has the tag
+func (e *Entity) HasTag(tag string) bool {
+	return e.tags.Contains(tag)
+}
+
+// Get tags of the entity (do not modify it!)
+func (e *Entity) GetTags() StringSet {
+	return e.tags
+}
+
+// Remove all tags of the entity, called when the entity is destroying or migrating out
+func (e *Entity) clearTags() {
+	for tag := range e.tags {
+		e.RemoveTag(tag)
+	}
+	e.tags = nil
+}
+
+// Get local entities with the tag as an EntitySet (do not modify it!)
+func GetEntitiesByTag(tag string) EntitySet {
+	return entityManager.tagIndex[tag]
+}
@@ -1,10 +1,13 @@
 package entity
 
 import (
+	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
 )
 
 type TestEntity struct {
@@ -27,3 +30,35 @@ func TestGenEntityID(t *testing.T) {
 func TestEntityManager(t *testing.T) {
 
 }
+
+func TestCalendarSubscriptionsData(t *testing.T) {
+	e := &Entity{calendarHandles: []calendarHandle{{1, "double_exp", "OnDoubleExp"}, {2, "", "OnAnyEvent"}}}
+	expected := []calendarHandle{{0, "double_exp", "OnDoubleExp"}, {0, "", "OnAnyEvent"}}
+
+	// migrate data
+	packed, err := netutil.MSG_PACKER.PackMsg(e.putCalendarSubscriptions(map[string]interface{}{"a": 1}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(packed, &data); err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions := popCalendarSubscriptions(data); !reflect.DeepEqual(subscriptions, expected) || len(data) != 1 {
+		t.Errorf("wrong subscriptions in migrate data: %v, data=%v", subscriptions, data)
+	}
+
+	// freeze data
+	frozen, _ := json.Marshal(&entityFreezeData{Calendar: e.dumpCalendarSubscriptions()})
+	var freezeData entityFreezeData
+	if err := json.Unmarshal(frozen, &freezeData); err != nil {
+		t.Fatal(err)
+	}
+	if subscriptions := popCalendarSubscriptions(map[string]interface{}{_CALENDAR_SUBSCRIPTIONS_KEY: freezeData.Calendar}); !reflect.DeepEqual(subscriptions, expected) {
+		t.Errorf("wrong subscriptions in freeze data: %v", subscriptions)
+	}
+
+	if (&Entity{}).putCalendarSubscriptions(nil) != nil {
+		t.Errorf("data should not be changed without subscriptions")
+	}
+}
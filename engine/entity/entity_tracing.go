@@ -0,0 +1,33 @@
+package entity
+
+import (
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+// Tracing of entity RPC chains
+//
+// Every RPC called from clients or other games is traced as a server span, which is the child of the caller's span
+// propagated with the call, or the root of a new trace. The span context is kept as current while the RPC is executing,
+// so that calls to other entities and services are propagated with it. Calls with results are traced as client spans
+// which end when results are replied, and callbacks of results continue the trace of the caller.
+
+// Start the server span of RPC and set it as current span context, should be deferred with endRpcSpan
+func (e *Entity) startRpcSpan(methodName string, caller string, parent tracing.SpanContext) (*tracing.Span, tracing.SpanContext) {
+	span := tracing.StartSpan(e.TypeName+"."+methodName, tracing.SPAN_KIND_SERVER, parent)
+	span.SetAttr("goworld.entity_id", string(e.ID))
+	span.SetAttr("goworld.caller", caller)
+	return span, tracing.SetCurrent(span.Context())
+}
+
+func endRpcSpan(span *tracing.Span, prev tracing.SpanContext) {
+	tracing.SetCurrent(prev)
+	span.End()
+}
+
+// Start the client span of call with results as child of current span context
+func startCallSpan(id EntityID, method string) *tracing.Span {
+	span := tracing.StartSpan("call "+method, tracing.SPAN_KIND_CLIENT, tracing.Current())
+	span.SetAttr("goworld.entity_id", string(id))
+	return span
+}
@@ -0,0 +1,275 @@
+package entity
+
+import (
+	"sort"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Entry queue throttles clients entering the world: at most capacity clients are admitted at the same time,
+// other clients are held in the queue with their positions pushed to them, and admitted in order as admitted
+// clients disconnect.
+//
+// Slots are bound to clients instead of entities, so an admitted Account can give its client to the Avatar
+// without releasing the slot.
+//
+// Queued and admitted clients and the capacity are kept in attributes of the service, so they are kept when the
+// game is freezed and restored, or the service migrates.
+
+const (
+	ENTRY_QUEUE_SERVICE_TYPE = "__entry_queue__"
+	ENTRY_QUEUE_SERVICE_NAME = "__entry_queue__"
+
+	// client methods called on the queued entity
+	ENTRY_QUEUE_CLIENT_POSITION_METHOD = "OnEntryQueuePosition" // (position int, total int)
+	ENTRY_QUEUE_CLIENT_ADMITTED_METHOD = "OnEntryQueueAdmitted" // ()
+
+	_ENTRY_QUEUE_CAPACITY_ATTR_KEY = "capacity"
+	_ENTRY_QUEUE_QUEUED_ATTR_KEY   = "queued"   // clientid -> {"eid": queued entity, "seq": order in queue}
+	_ENTRY_QUEUE_ADMITTED_ATTR_KEY = "admitted" // clientid -> entity to notify
+	_ENTRY_QUEUE_SEQ_ATTR_KEY      = "seq"      // seq of the next queued client
+)
+
+var (
+	entryQueueEnabled  bool
+	entryQueueCapacity int
+)
+
+// Optional interface for entities to handle entry queue progress
+type IEntryQueueHandler interface {
+	OnEntryQueuePositionChanged(position int, total int) // position starts from 1
+	OnEntryQueueAdmitted()                               // the client is admitted to enter the world
+}
+
+type entryQueueItem struct {
+	clientid ClientID
+	eid      EntityID // the queued entity to notify
+	seq      int64
+}
+
+// The entry queue service entity, created by CreateEntryQueueServiceAnywhere
+type EntryQueueService struct {
+	Entity
+
+	queue         []entryQueueItem // queued clients in order, rebuilt from attributes after restored or migrated
+	lastPositions map[ClientID]int // last pushed positions
+}
+
+// Register the entry queue service type with capacity of admitted clients
+//
+// Should be called on all games before running
+func RegisterEntryQueueService(capacity int) {
+	if capacity <= 0 {
+		gwlog.Panicf("RegisterEntryQueueService: invalid capacity: %d", capacity)
+	}
+	entryQueueEnabled = true
+	entryQueueCapacity = capacity
+	RegisterEntity(ENTRY_QUEUE_SERVICE_TYPE, &EntryQueueService{})
+}
+
+// Create the entry queue service on any game, should be called only once in the cluster
+func CreateEntryQueueServiceAnywhere() {
+	createEntityAnywhere(ENTRY_QUEUE_SERVICE_TYPE, nil)
+}
+
+// Change the capacity of admitted clients at runtime
+func SetEntryQueueCapacity(capacity int) {
+	serviceEid, err := entityManager.chooseServiceProvider(ENTRY_QUEUE_SERVICE_NAME, "")
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	callEntity(serviceEid, "SetCapacity", []interface{}{capacity})
+}
+
+// Check if the entry queue service is ready
+func IsEntryQueueServiceReady() bool {
+	return len(GetServiceProviders(ENTRY_QUEUE_SERVICE_NAME)) > 0
+}
+
+func (s *EntryQueueService) OnInit() {
+	s.lastPositions = map[ClientID]int{}
+}
+
+func (s *EntryQueueService) OnCreated() {
+	s.initAttrs()
+	gwlog.Info("Registering entry queue service, capacity %d ...", entryQueueCapacity)
+	s.DeclareService(ENTRY_QUEUE_SERVICE_NAME)
+	s.addRawTimer(consts.ENTRY_QUEUE_POSITION_PUSH_INTERVAL, s.pushPositions)
+}
+
+func (s *EntryQueueService) OnRestored() {
+	s.rebuildQueue()
+	s.addRawTimer(consts.ENTRY_QUEUE_POSITION_PUSH_INTERVAL, s.pushPositions)
+}
+
+func (s *EntryQueueService) OnMigrateIn() {
+	s.rebuildQueue()
+	s.addRawTimer(consts.ENTRY_QUEUE_POSITION_PUSH_INTERVAL, s.pushPositions)
+}
+
+func (s *EntryQueueService) initAttrs() {
+	s.Attrs.SetDefault(_ENTRY_QUEUE_CAPACITY_ATTR_KEY, entryQueueCapacity)
+	s.Attrs.SetDefault(_ENTRY_QUEUE_QUEUED_ATTR_KEY, NewMapAttr())
+	s.Attrs.SetDefault(_ENTRY_QUEUE_ADMITTED_ATTR_KEY, NewMapAttr())
+	s.Attrs.SetDefault(_ENTRY_QUEUE_SEQ_ATTR_KEY, int64(0))
+}
+
+// Rebuild the queue in order from queued clients in attributes
+func (s *EntryQueueService) rebuildQueue() {
+	s.initAttrs()
+	queued := s.Attrs.GetMapAttr(_ENTRY_QUEUE_QUEUED_ATTR_KEY)
+	s.queue = make([]entryQueueItem, 0, queued.Size())
+	for _, clientid := range queued.GetKeys() {
+		item := queued.GetMapAttr(clientid)
+		s.queue = append(s.queue, entryQueueItem{ClientID(clientid), EntityID(item.GetStr("eid")), item.GetInt64("seq")})
+	}
+	sort.Slice(s.queue, func(i, j int) bool {
+		return s.queue[i].seq < s.queue[j].seq
+	})
+	gwlog.Info("%s: %d clients queued, %d clients admitted", s, len(s.queue), s.Attrs.GetMapAttr(_ENTRY_QUEUE_ADMITTED_ATTR_KEY).Size())
+}
+
+// Enqueue the client of entity, the entity is notified immediately if there is free capacity
+func (s *EntryQueueService) Enqueue(eid EntityID, clientid ClientID) {
+	admitted := s.Attrs.GetMapAttr(_ENTRY_QUEUE_ADMITTED_ATTR_KEY)
+	if admitted.HasKey(string(clientid)) {
+		// already admitted, just notify the new entity
+		admitted.Set(string(clientid), string(eid))
+		s.Call(eid, "EntryQueueAdmittedFromService")
+		return
+	}
+
+	queued := s.Attrs.GetMapAttr(_ENTRY_QUEUE_QUEUED_ATTR_KEY)
+	if queued.HasKey(string(clientid)) {
+		queued.GetMapAttr(string(clientid)).Set("eid", string(eid))
+		for i := range s.queue {
+			if s.queue[i].clientid == clientid {
+				s.queue[i].eid = eid
+			}
+		}
+		delete(s.lastPositions, clientid) // push position to the new entity
+		return
+	}
+
+	seq := s.Attrs.GetInt64(_ENTRY_QUEUE_SEQ_ATTR_KEY)
+	s.Attrs.Set(_ENTRY_QUEUE_SEQ_ATTR_KEY, seq+1)
+	item := NewMapAttr()
+	item.Set("eid", string(eid))
+	item.Set("seq", seq)
+	queued.Set(string(clientid), item)
+	s.queue = append(s.queue, entryQueueItem{clientid, eid, seq})
+	s.admitWaiting()
+}
+
+// Release the slot or the queue position of the client
+func (s *EntryQueueService) Leave(clientid ClientID) {
+	admitted := s.Attrs.GetMapAttr(_ENTRY_QUEUE_ADMITTED_ATTR_KEY)
+	if admitted.HasKey(string(clientid)) {
+		admitted.Del(string(clientid))
+		s.admitWaiting()
+		return
+	}
+
+	queued := s.Attrs.GetMapAttr(_ENTRY_QUEUE_QUEUED_ATTR_KEY)
+	if queued.HasKey(string(clientid)) {
+		queued.Del(string(clientid))
+		delete(s.lastPositions, clientid)
+		for i := range s.queue {
+			if s.queue[i].clientid == clientid {
+				s.queue = append(s.queue[:i], s.queue[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Change the capacity of admitted clients
+func (s *EntryQueueService) SetCapacity(capacity int) {
+	if capacity <= 0 {
+		gwlog.Error("%s.SetCapacity: invalid capacity: %d", s, capacity)
+		return
+	}
+	gwlog.Info("%s: capacity changed from %d to %d", s, s.Attrs.GetInt(_ENTRY_QUEUE_CAPACITY_ATTR_KEY), capacity)
+	s.Attrs.Set(_ENTRY_QUEUE_CAPACITY_ATTR_KEY, capacity)
+	s.admitWaiting()
+}
+
+func (s *EntryQueueService) admitWaiting() {
+	capacity := s.Attrs.GetInt(_ENTRY_QUEUE_CAPACITY_ATTR_KEY)
+	queued := s.Attrs.GetMapAttr(_ENTRY_QUEUE_QUEUED_ATTR_KEY)
+	admitted := s.Attrs.GetMapAttr(_ENTRY_QUEUE_ADMITTED_ATTR_KEY)
+	n := 0
+	for n < len(s.queue) && admitted.Size() < capacity {
+		item := s.queue[n]
+		n += 1
+		queued.Del(string(item.clientid))
+		delete(s.lastPositions, item.clientid)
+		admitted.Set(string(item.clientid), string(item.eid))
+		s.Call(item.eid, "EntryQueueAdmittedFromService")
+	}
+	if n > 0 {
+		s.queue = s.queue[n:]
+	}
+}
+
+// push positions to queued entities whose positions are changed since last push
+func (s *EntryQueueService) pushPositions() {
+	total := len(s.queue)
+	for i, item := range s.queue {
+		position := i + 1
+		if s.lastPositions[item.clientid] == position {
+			continue
+		}
+		s.lastPositions[item.clientid] = position
+		s.Call(item.eid, "EntryQueuePositionFromService", position, total)
+	}
+}
+
+// Enter the entry queue with the client of entity
+//
+// The entity is notified by IEntryQueueHandler.OnEntryQueueAdmitted when admitted, and the client is notified by
+// client methods OnEntryQueuePosition and OnEntryQueueAdmitted
+func (e *Entity) EnterEntryQueue() {
+	if e.client == nil {
+		gwlog.Error("%s.EnterEntryQueue: entity has no client", e)
+		return
+	}
+	e.CallService(ENTRY_QUEUE_SERVICE_NAME, "Enqueue", e.ID, e.client.clientid)
+}
+
+// Called by entry queue service when the position of the client in queue changes
+func (e *Entity) EntryQueuePositionFromService(position int, total int) {
+	e.client.call(e.ID, ENTRY_QUEUE_CLIENT_POSITION_METHOD, position, total)
+
+	if handler, ok := e.I.(IEntryQueueHandler); ok {
+		gwutils.RunPanicless(func() {
+			handler.OnEntryQueuePositionChanged(position, total)
+		})
+	}
+}
+
+// Called by entry queue service when the client is admitted
+func (e *Entity) EntryQueueAdmittedFromService() {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debug("%s: client %s is admitted by entry queue", e, e.client)
+	}
+	e.client.call(e.ID, ENTRY_QUEUE_CLIENT_ADMITTED_METHOD)
+
+	if handler, ok := e.I.(IEntryQueueHandler); ok {
+		gwutils.RunPanicless(handler.OnEntryQueueAdmitted)
+	}
+}
+
+func releaseEntryQueueSlot(clientid ClientID) {
+	if !entryQueueEnabled || !IsEntryQueueServiceReady() {
+		return
+	}
+	serviceEid, err := entityManager.chooseServiceProvider(ENTRY_QUEUE_SERVICE_NAME, "")
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	callEntity(serviceEid, "Leave", []interface{}{clientid})
+}
@@ -0,0 +1,102 @@
+package entity
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/common"
+)
+
+// Errors of engine operations returned to game code by WithCallback and Try APIs, use type switch or the Is
+// functions to handle them. The Is functions also work for errors wrapped by github.com/pkg/errors.
+
+// The entity does not exist, or no entity provides the service
+type EntityNotFoundError struct {
+	EntityID    EntityID
+	ServiceName string
+}
+
+func (err *EntityNotFoundError) Error() string {
+	if err.ServiceName != "" {
+		return fmt.Sprintf("service not found: %s", err.ServiceName)
+	}
+	return fmt.Sprintf("entity not found: %s", err.EntityID)
+}
+
+// The space is destroyed before the operation is done
+type SpaceDestroyedError struct {
+	SpaceID EntityID
+}
+
+func (err *SpaceDestroyedError) Error() string {
+	return fmt.Sprintf("space destroyed: %s", err.SpaceID)
+}
+
+// The storage failed to load or save the entity
+type StorageUnavailableError struct {
+	Err error
+}
+
+func (err *StorageUnavailableError) Error() string {
+	return fmt.Sprintf("storage unavailable: %s", err.Err)
+}
+
+// The data of entity loaded from storage is corrupted, see storage read validation
+type EntityDataCorruptedError struct {
+	EntityID EntityID
+	Err      error
+}
+
+func (err *EntityDataCorruptedError) Error() string {
+	return fmt.Sprintf("entity data corrupted: %s", err.Err)
+}
+
+// The entity is entering another space
+type MigrationInProgressError struct {
+	EntityID EntityID
+	SpaceID  EntityID // the space entity is entering
+}
+
+func (err *MigrationInProgressError) Error() string {
+	return fmt.Sprintf("entity %s is entering space %s", err.EntityID, err.SpaceID)
+}
+
+// The entity is already loaded or loading on another game, see SetEntityAlreadyLoadedCallback
+type EntityAlreadyLoadedError struct {
+	EntityID EntityID
+	GameID   uint16 // the game entity is on
+}
+
+func (err *EntityAlreadyLoadedError) Error() string {
+	return fmt.Sprintf("entity %s is already loaded on game %d", err.EntityID, err.GameID)
+}
+
+func IsEntityNotFound(err error) bool {
+	_, ok := errors.Cause(err).(*EntityNotFoundError)
+	return ok
+}
+
+func IsSpaceDestroyed(err error) bool {
+	_, ok := errors.Cause(err).(*SpaceDestroyedError)
+	return ok
+}
+
+func IsStorageUnavailable(err error) bool {
+	_, ok := errors.Cause(err).(*StorageUnavailableError)
+	return ok
+}
+
+func IsEntityDataCorrupted(err error) bool {
+	_, ok := errors.Cause(err).(*EntityDataCorruptedError)
+	return ok
+}
+
+func IsMigrationInProgress(err error) bool {
+	_, ok := errors.Cause(err).(*MigrationInProgressError)
+	return ok
+}
+
+func IsEntityAlreadyLoaded(err error) bool {
+	_, ok := errors.Cause(err).(*EntityAlreadyLoadedError)
+	return ok
+}
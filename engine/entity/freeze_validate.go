@@ -0,0 +1,93 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/typeconv"
+)
+
+// Freeze data are validated by restore dry-run (-restore-dry-run) without creating any entity, so that a hot reload
+// with a game binary which can not restore the freeze data is aborted before running games are freezed: write a
+// snapshot of running games, validate it by the new binary, then freeze and restore games.
+
+// Validate freeze data for restoring, all problems found are returned
+//
+// Attributes of entities in freeze data are unpacked and migrated in place, so freeze data should not be restored
+// after validated.
+func ValidateFreezeData(freeze *FreezeData) []error {
+	if err := checkFreezeDataVersion(freeze); err != nil {
+		return []error{err} // entities can not be validated
+	}
+
+	var errs []error
+	spaces := EntityIDSet{}
+	nilSpaces := 0
+	for eid, info := range freeze.Entities {
+		if info.Type != SPACE_ENTITY_TYPE {
+			continue
+		}
+		spaces.Add(eid)
+		if err := unpackFreezedAttrs(eid, info); err != nil {
+			errs = append(errs, err)
+		} else if typeconv.Int(info.Attrs[SPACE_KIND_ATTR_KEY]) == 0 {
+			nilSpaces += 1
+		}
+	}
+	if nilSpaces != 1 {
+		errs = append(errs, errors.Errorf("there should be exactly one nil space, but found %d", nilSpaces))
+	}
+
+	typeNames := StringSet{}
+	for eid, info := range freeze.Entities {
+		desc := registeredEntityTypes[info.Type]
+		if desc == nil {
+			errs = append(errs, errors.Errorf("%s<%s>: entity type is not registered", info.Type, eid))
+			continue
+		}
+		if info.Type != SPACE_ENTITY_TYPE {
+			typeNames.Add(info.Type)
+			if !spaces.Contains(info.SpaceID) {
+				errs = append(errs, errors.Errorf("%s<%s>: space %s is not found", info.Type, eid, info.SpaceID))
+			}
+			if err := unpackFreezedAttrs(eid, info); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if err := migrateFreezedEntity(freeze, eid, info, desc); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validateFreezedTimers(eid, info, desc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if _, err := getRestoreOrder(typeNames); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Timers should be decodable, and timer methods should be defined by Go or script RPCs
+func validateFreezedTimers(eid EntityID, info *entityFreezeData, desc *EntityTypeDesc) error {
+	if len(info.TimerData) == 0 {
+		return nil
+	}
+
+	var timers []*entityTimerInfo
+	if err := timersPacker.UnpackMsg(info.TimerData, &timers); err != nil {
+		return errors.Wrapf(err, "%s<%s>: unpack timers failed", info.Type, eid)
+	}
+	for _, t := range timers {
+		if desc.rpcDescs[t.Method] != nil {
+			continue
+		}
+		if desc.script != nil {
+			if _, ok := desc.script.rpcs[t.Method]; ok {
+				continue
+			}
+		}
+		return errors.Errorf("%s<%s>: timer method %s is not defined", info.Type, eid, t.Method)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package entity
+
+import (
+	"sort"
+
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Freeze data are versioned, so that a newer game binary can restore freeze data produced by the previous version.
+//
+// FreezeData has the version of its format, and versions of entity types which define freeze migrations. When
+// restoring freeze data of an older version of a type, migrations of later versions are invoked in order on
+// attributes of each entity of the type:
+//
+//	desc := goworld.RegisterEntity("Avatar", &Avatar{})
+//	desc.DefineFreezeMigration(1, func(attrs map[string]interface{}) error {
+//		attrs["gold"] = attrs["money"] // money is renamed to gold in version 1
+//		delete(attrs, "money")
+//		return nil
+//	})
+//
+// Unknown fields of freeze data are ignored, and entities of types not registered any more are dropped.
+
+const (
+	FREEZE_DATA_VERSION = 1 // version of the format of FreezeData, data of newer versions can not be restored
+)
+
+type freezeMigration struct {
+	version int
+	migrate func(attrs map[string]interface{}) error
+}
+
+// Define the migration of attributes of freezed entities from version-1 to version of this type
+//
+// The version of the type is the latest version of its migrations, and is 0 if no migration is defined.
+func (desc *EntityTypeDesc) DefineFreezeMigration(version int, migrate func(attrs map[string]interface{}) error) {
+	if version <= 0 {
+		gwlog.Panicf("%s: invalid freeze migration version %d", desc.entityType, version)
+	}
+	for _, m := range desc.freezeMigrators {
+		if m.version == version {
+			gwlog.Panicf("%s: freeze migration of version %d is already defined", desc.entityType, version)
+		}
+	}
+
+	desc.freezeMigrators = append(desc.freezeMigrators, freezeMigration{version, migrate})
+	sort.Slice(desc.freezeMigrators, func(i, j int) bool {
+		return desc.freezeMigrators[i].version < desc.freezeMigrators[j].version
+	})
+}
+
+func (desc *EntityTypeDesc) getFreezeVersion() int {
+	if len(desc.freezeMigrators) == 0 {
+		return 0
+	}
+	return desc.freezeMigrators[len(desc.freezeMigrators)-1].version
+}
+
+// Get versions of entity types which define freeze migrations
+func getFreezeTypeVersions() map[string]int {
+	versions := map[string]int{}
+	for typeName, desc := range registeredEntityTypes {
+		if version := desc.getFreezeVersion(); version > 0 {
+			versions[typeName] = version
+		}
+	}
+	return versions
+}
+
+// Check the version of freeze data, drop entities of unknown types and migrate attributes of entities of old versions
+func migrateFreezeData(freeze *FreezeData) error {
+	if err := checkFreezeDataVersion(freeze); err != nil {
+		return err
+	}
+
+	for eid, info := range freeze.Entities {
+		desc := registeredEntityTypes[info.Type]
+		if desc == nil {
+			gwlog.Warn("Restore: entity type %s is not registered, %s<%s> is dropped", info.Type, info.Type, eid)
+			delete(freeze.Entities, eid)
+			continue
+		}
+		if err := migrateFreezedEntity(freeze, eid, info, desc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkFreezeDataVersion(freeze *FreezeData) error {
+	if freeze.Version > FREEZE_DATA_VERSION {
+		return errors.Errorf("freeze data version %d is newer than %d supported by this game", freeze.Version, FREEZE_DATA_VERSION)
+	}
+	return nil
+}
+
+func migrateFreezedEntity(freeze *FreezeData, eid EntityID, info *entityFreezeData, desc *EntityTypeDesc) error {
+	version := freeze.TypeVersions[info.Type]
+	if version > desc.getFreezeVersion() {
+		return errors.Errorf("freeze data version %d of %s is newer than %d supported by this game", version, info.Type, desc.getFreezeVersion())
+	}
+	for _, m := range desc.freezeMigrators {
+		if m.version <= version {
+			continue
+		}
+		if info.Attrs == nil {
+			info.Attrs = map[string]interface{}{}
+		}
+		if err := m.migrate(info.Attrs); err != nil {
+			return errors.Wrapf(err, "migrate %s<%s> to freeze version %d failed", info.Type, eid, m.version)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package entity
+
+import (
+	"sort"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Get the stats of this game for reporting to dispatchers, only the most crowded spaces are included
+func GetLocalGameStats() *proto.GameStats {
+	stats := &proto.GameStats{
+		Entities: len(entityManager.entities),
+		Clients:  len(entityManager.ownerOfClient),
+		Spaces:   make([]proto.SpaceStats, 0, len(spaceManager.spaces)),
+	}
+
+	for _, space := range spaceManager.spaces {
+		if space.IsNil() {
+			continue
+		}
+		stats.Spaces = append(stats.Spaces, proto.SpaceStats{
+			ID:       space.ID,
+			Kind:     space.Kind,
+			Entities: space.GetEntityCount(),
+		})
+	}
+
+	stats.SpaceCount = len(stats.Spaces) // the nil space is not counted
+	sort.Slice(stats.Spaces, func(i, j int) bool {
+		return stats.Spaces[i].Entities > stats.Spaces[j].Entities
+	})
+	if len(stats.Spaces) > consts.GAME_STATS_MAX_SPACES {
+		stats.Spaces = stats.Spaces[:consts.GAME_STATS_MAX_SPACES]
+	}
+	return stats
+}
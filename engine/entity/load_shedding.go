@@ -0,0 +1,132 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Load shedding degrades the game gracefully when it is overloaded, instead of letting delays cascade into timeouts.
+//
+// Game scripts configure tiers of increasing severity with SetLoadSheddingTiers. The game checks the main loop tick
+// time and packet queue length periodically, and activates the most severe tier whose thresholds are exceeded.
+// Policies of the active tier are applied until load drops, and tiers are deactivated one by one when the load is
+// below thresholds of the active tier.
+
+// Load shedding tier with thresholds and policies applied when the tier is active
+type LoadSheddingTier struct {
+	Name string
+	// The tier is active if the average busy time of main loop per tick exceeds TickTime, or the packet queue length
+	// exceeds QueueLen. Zero thresholds are ignored.
+	TickTime time.Duration
+	QueueLen int
+
+	SyncInterval           int  // sync positions to clients every SyncInterval flushes
+	PauseLowPriorityTimers bool // skip repeated timers of entities with low priority
+	RejectClientRPCs       bool // reject client RPCs which are not critical
+}
+
+type loadSheddingState struct {
+	tiers      []LoadSheddingTier
+	activeTier *LoadSheddingTier // nil if no tier is active
+	activeIdx  int               // index of active tier + 1
+	syncSkips  int
+
+	rejectedClientRPCs uint64
+}
+
+var (
+	loadShedding = loadSheddingState{}
+)
+
+// Set load shedding tiers in order of increasing severity
+func SetLoadSheddingTiers(tiers []LoadSheddingTier) {
+	loadShedding.tiers = append([]LoadSheddingTier(nil), tiers...)
+	loadShedding.activeTier = nil
+	loadShedding.activeIdx = 0
+}
+
+// Get the name of active load shedding tier, returns empty string if no tier is active
+func GetLoadSheddingTier() string {
+	if loadShedding.activeTier == nil {
+		return ""
+	}
+	return loadShedding.activeTier.Name
+}
+
+// Get the number of client RPCs rejected by load shedding
+func GetLoadSheddingRejectedClientRPCs() uint64 {
+	return loadShedding.rejectedClientRPCs
+}
+
+// Mark entities of this type as low priority, repeated timers of which can be paused by load shedding
+func (desc *EntityTypeDesc) SetLowPriority() {
+	desc.lowPriority = true
+}
+
+// Mark client RPCs of this type as critical, so that they are never rejected by load shedding
+func (desc *EntityTypeDesc) DefineCriticalRPCs(methods ...string) {
+	for _, method := range methods {
+		desc.criticalRPCs.Add(method)
+	}
+}
+
+func (tier *LoadSheddingTier) isExceeded(tickTime time.Duration, queueLen int) bool {
+	return (tier.TickTime > 0 && tickTime >= tier.TickTime) || (tier.QueueLen > 0 && queueLen >= tier.QueueLen)
+}
+
+// Update the active load shedding tier with load measured by game
+func UpdateLoadShedding(tickTime time.Duration, queueLen int) {
+	ls := &loadShedding
+	idx := 0
+	for i := len(ls.tiers) - 1; i >= 0; i-- {
+		if ls.tiers[i].isExceeded(tickTime, queueLen) {
+			idx = i + 1
+			break
+		}
+	}
+
+	if idx < ls.activeIdx {
+		idx = ls.activeIdx - 1 // recover one tier at a time
+	}
+	if idx == ls.activeIdx {
+		return
+	}
+
+	oldTier := GetLoadSheddingTier()
+	ls.activeIdx = idx
+	if idx == 0 {
+		ls.activeTier = nil
+		gwlog.Info("Load shedding: tier %s deactivated, tick time = %s, queue length = %d", oldTier, tickTime, queueLen)
+	} else {
+		ls.activeTier = &ls.tiers[idx-1]
+		gwlog.Warn("Load shedding: tier %s -> %s, tick time = %s, queue length = %d", oldTier, ls.activeTier.Name, tickTime, queueLen)
+	}
+}
+
+// Returns if position syncing should be skipped in this flush
+func (ls *loadSheddingState) shouldSkipSync() bool {
+	if ls.activeTier == nil || ls.activeTier.SyncInterval <= 1 {
+		return false
+	}
+
+	ls.syncSkips += 1
+	if ls.syncSkips >= ls.activeTier.SyncInterval {
+		ls.syncSkips = 0
+		return false
+	}
+	return true
+}
+
+func (ls *loadSheddingState) shouldPauseTimer(e *Entity) bool {
+	return ls.activeTier != nil && ls.activeTier.PauseLowPriorityTimers && e.typeDesc.lowPriority
+}
+
+func (ls *loadSheddingState) shouldRejectClientRPC(e *Entity, method string) bool {
+	if ls.activeTier == nil || !ls.activeTier.RejectClientRPCs || e.typeDesc.criticalRPCs.Contains(method) {
+		return false
+	}
+
+	ls.rejectedClientRPCs += 1
+	return true
+}
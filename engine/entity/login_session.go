@@ -0,0 +1,69 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Callback of RegisterLoginSession, ok is false if the login is rejected by the duplicate-login policy
+//
+// err is not nil if the login is not registered, e.g. the dispatcher does not reply in consts.REGISTER_LOGIN_TIMEOUT
+type RegisterLoginCallback func(ok bool, err error)
+
+type pendingRegisterLogin struct {
+	callback     RegisterLoginCallback
+	timeoutTimer *timer.Timer
+}
+
+var (
+	lastRegisterLoginReqID uint32
+	pendingRegisterLogins  = map[uint32]*pendingRegisterLogin{}
+)
+
+// Register the client of entity as a login session of the login key (e.g. account name)
+//
+// The dispatcher applies the duplicate-login policy across all gates and games:
+// other sessions with the same login key might be kicked, or this login might be rejected
+func (e *Entity) RegisterLoginSession(loginKey string, callback RegisterLoginCallback) {
+	if e.client == nil {
+		gwlog.Error("%s.RegisterLoginSession: client is nil", e)
+		if callback != nil {
+			post.Post(func() {
+				callback(false, errors.Errorf("%s has no client", e))
+			})
+		}
+		return
+	}
+
+	lastRegisterLoginReqID += 1
+	reqid := lastRegisterLoginReqID
+	if callback != nil {
+		pending := &pendingRegisterLogin{callback: callback}
+		pending.timeoutTimer = timer.AddCallback(consts.REGISTER_LOGIN_TIMEOUT, func() {
+			if pendingRegisterLogins[reqid] != pending {
+				return
+			}
+			delete(pendingRegisterLogins, reqid)
+			gwlog.Warn("%s.RegisterLoginSession: register login %s timeout", e, loginKey)
+			callback(false, errors.Errorf("register login %s timeout", loginKey))
+		})
+		pendingRegisterLogins[reqid] = pending
+	}
+	dispatcher_client.GetDispatcherClientForSend().SendRegisterLogin(reqid, loginKey, e.client.clientid, e.client.gateid)
+}
+
+// Called by engine when dispatcher replies the register login request
+func OnRegisterLoginAck(reqid uint32, ok bool) {
+	pending := pendingRegisterLogins[reqid]
+	if pending == nil {
+		return // timeout already
+	}
+
+	delete(pendingRegisterLogins, reqid)
+	pending.timeoutTimer.Cancel()
+	pending.callback(ok, nil)
+}
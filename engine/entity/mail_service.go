@@ -0,0 +1,319 @@
+package entity
+
+import (
+	"encoding/json"
+	"time"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+// Mail service keeps mailboxes of entities in entity storage, so mails can be sent to entities which are offline,
+// e.g. rewards of events or gifts from friends.
+//
+// Entities implementing IMailReceiver get their mailboxes when they are created or loaded, and new mails while they
+// are online. Mails stay in the mailbox until they are claimed or expired, and attachments should be granted in
+// OnMailClaimed which is called only once for each mail. Senders requesting read receipts receive receipt mails when
+// the mails are read.
+//
+// All operations of mailboxes are serialized by the service. Mails are saved in JSON, so numbers in attachments
+// become float64 after loaded.
+
+const (
+	MAIL_SERVICE_TYPE = "__mail__"
+	MAIL_SERVICE_NAME = "__mail__"
+
+	MAILBOX_STORAGE_TYPE = "__mailbox__" // type name of mailboxes in entity storage, keyed by IDs of receivers
+	_MAILBOX_MAILS_KEY   = "mails"
+)
+
+// ID of mails, generated by SendMail
+type MailID string
+
+type Mail struct {
+	ID          MailID
+	Sender      EntityID // empty for system mails
+	Title       string
+	Content     string
+	Attachments map[string]interface{} `json:",omitempty"`
+	SendTime    time.Time
+	ExpireTime  time.Time // the mail is removed after expire time, never expires if zero
+	ReadReceipt bool      // the sender receives a receipt mail when the mail is read
+	ReceiptOf   MailID    `json:",omitempty"` // the mail is the read receipt of the mail sent by this entity
+	Read        bool
+}
+
+func (mail *Mail) isExpired(now time.Time) bool {
+	return !mail.ExpireTime.IsZero() && now.After(mail.ExpireTime)
+}
+
+// Interface for entities to receive mails from the mail service
+type IMailReceiver interface {
+	OnMailboxLoaded(mails []*Mail) // all mails in the mailbox, called when the entity is created or loaded
+	OnMailReceived(mail *Mail)     // new mail received while the entity is online
+	OnMailClaimed(mail *Mail)      // the mail is removed from the mailbox, attachments should be granted
+}
+
+// The offline mail service entity, created by CreateMailServiceAnywhere
+type OfflineMailService struct {
+	Entity
+
+	mailboxes map[EntityID]*mailbox // mailboxes of online receivers, and mailboxes being operated
+}
+
+type mailbox struct {
+	mails   []*Mail
+	loaded  bool
+	online  bool
+	pending []func(mb *mailbox) // operations waiting for the mailbox to be loaded
+}
+
+// Register the mail service type
+//
+// Should be called on all games before running
+func RegisterMailService() {
+	RegisterEntity(MAIL_SERVICE_TYPE, &OfflineMailService{})
+}
+
+// Create the mail service on any game, should be called only once in the cluster
+//
+// Mails sent to online entities when the service is being recreated are received when the entities are loaded again.
+func CreateMailServiceAnywhere() {
+	createEntityAnywhere(MAIL_SERVICE_TYPE, nil)
+}
+
+// Check if the mail service is ready
+func IsMailServiceReady() bool {
+	return len(GetServiceProviders(MAIL_SERVICE_NAME)) > 0
+}
+
+// Send the mail to the mailbox of target entity, the ID and send time of mail are set
+func SendMail(target EntityID, mail *Mail) MailID {
+	mail.ID = MailID(uuid.GenUUID())
+	mail.SendTime = time.Now()
+	mail.Read = false
+	callMailService("Send", target, mail)
+	return mail.ID
+}
+
+// Mark the mail as read, the sender receives a receipt mail if requested
+func (e *Entity) ReadMail(id MailID) {
+	callMailService("Read", e.ID, id)
+}
+
+// Remove the mail from the mailbox, OnMailClaimed is called if the mail is not claimed yet
+func (e *Entity) ClaimMail(id MailID) {
+	callMailService("Claim", e.ID, id)
+}
+
+func callMailService(method string, args ...interface{}) {
+	serviceEid, err := entityManager.chooseServiceProvider(MAIL_SERVICE_NAME, "")
+	if err != nil {
+		gwlog.Error("call mail service %s%v failed: %s", method, args, err)
+		return
+	}
+	callEntity(serviceEid, method, args)
+}
+
+// Fetch the mailbox when the mail receiver is created or loaded
+func (e *Entity) onMailReceiverCreated() {
+	if _, ok := e.I.(IMailReceiver); ok && IsMailServiceReady() {
+		callMailService("Fetch", e.ID)
+	}
+}
+
+func (e *Entity) onMailReceiverDestroyed() {
+	if _, ok := e.I.(IMailReceiver); ok && IsMailServiceReady() {
+		callMailService("Offline", e.ID)
+	}
+}
+
+// Called by mail service when the mailbox is fetched
+func (e *Entity) MailboxLoadedFromService(mails []*Mail) {
+	if receiver, ok := e.I.(IMailReceiver); ok {
+		gwutils.RunPanicless(func() {
+			receiver.OnMailboxLoaded(mails)
+		})
+	}
+}
+
+// Called by mail service when a new mail is received
+func (e *Entity) MailReceivedFromService(mail *Mail) {
+	if receiver, ok := e.I.(IMailReceiver); ok {
+		gwutils.RunPanicless(func() {
+			receiver.OnMailReceived(mail)
+		})
+	}
+}
+
+// Called by mail service when the mail is claimed
+func (e *Entity) MailClaimedFromService(mail *Mail) {
+	if receiver, ok := e.I.(IMailReceiver); ok {
+		gwutils.RunPanicless(func() {
+			receiver.OnMailClaimed(mail)
+		})
+	}
+}
+
+func (s *OfflineMailService) OnInit() {
+	s.mailboxes = map[EntityID]*mailbox{}
+}
+
+func (s *OfflineMailService) OnCreated() {
+	gwlog.Info("Registering mail service ...")
+	s.DeclareService(MAIL_SERVICE_NAME)
+}
+
+// Put the mail in the mailbox of target
+func (s *OfflineMailService) Send(target EntityID, mail *Mail) {
+	s.withMailbox(target, func(mb *mailbox) {
+		mb.mails = append(mb.mails, mail)
+		s.saveMailbox(target, mb)
+		if mb.online {
+			s.Call(target, "MailReceivedFromService", mail)
+		}
+	})
+}
+
+// Send all mails to the receiver which is online now
+func (s *OfflineMailService) Fetch(target EntityID) {
+	s.withMailbox(target, func(mb *mailbox) {
+		mb.online = true
+		if s.removeExpiredMails(mb) {
+			s.saveMailbox(target, mb)
+		}
+		s.Call(target, "MailboxLoadedFromService", mb.mails)
+	})
+}
+
+// The receiver is offline, new mails are kept in the mailbox only
+func (s *OfflineMailService) Offline(target EntityID) {
+	if _, ok := s.mailboxes[target]; !ok {
+		return
+	}
+	s.withMailbox(target, func(mb *mailbox) {
+		mb.online = false
+	})
+}
+
+// Mark the mail as read, and send the receipt to sender if requested
+func (s *OfflineMailService) Read(target EntityID, id MailID) {
+	s.withMailbox(target, func(mb *mailbox) {
+		for _, mail := range mb.mails {
+			if mail.ID != id || mail.Read {
+				continue
+			}
+			mail.Read = true
+			s.saveMailbox(target, mb)
+			if mail.ReadReceipt && mail.Sender != "" {
+				receipt := &Mail{
+					ID:        MailID(uuid.GenUUID()),
+					Sender:    target,
+					Title:     mail.Title,
+					SendTime:  time.Now(),
+					ReceiptOf: mail.ID,
+				}
+				s.Send(mail.Sender, receipt)
+			}
+			return
+		}
+	})
+}
+
+// Remove the mail from the mailbox, the receiver is notified after the mailbox is saved
+func (s *OfflineMailService) Claim(target EntityID, id MailID) {
+	s.withMailbox(target, func(mb *mailbox) {
+		for i, mail := range mb.mails {
+			if mail.ID != id {
+				continue
+			}
+			mb.mails = append(mb.mails[:i], mb.mails[i+1:]...)
+			s.saveMailbox(target, mb)
+			s.Call(target, "MailClaimedFromService", mail)
+			return
+		}
+		gwlog.Warn("%s: mail %s of %s is not found, maybe claimed or expired", s, id, target)
+	})
+}
+
+// Run the operation on the mailbox of target, the mailbox is loaded first if not loaded
+func (s *OfflineMailService) withMailbox(target EntityID, op func(mb *mailbox)) {
+	mb := s.mailboxes[target]
+	if mb == nil {
+		mb = &mailbox{}
+		s.mailboxes[target] = mb
+		s.loadMailbox(target, mb)
+	}
+
+	if !mb.loaded {
+		mb.pending = append(mb.pending, op)
+		return
+	}
+	op(mb)
+	s.releaseMailbox(target, mb)
+}
+
+// Mailboxes of offline receivers are released after operations, since they are saved already
+func (s *OfflineMailService) releaseMailbox(target EntityID, mb *mailbox) {
+	if !mb.online && s.mailboxes[target] == mb {
+		delete(s.mailboxes, target)
+	}
+}
+
+func (s *OfflineMailService) loadMailbox(target EntityID, mb *mailbox) {
+	storage.Load(MAILBOX_STORAGE_TYPE, target, func(data interface{}, err error) {
+		if s.IsDestroyed() {
+			return
+		}
+		if err != nil {
+			gwlog.Error("%s: load mailbox of %s failed: %s, retry later", s, target, err)
+			s.addRawCallback(consts.MAILBOX_LOAD_RETRY_INTERVAL, func() {
+				s.loadMailbox(target, mb)
+			})
+			return
+		}
+
+		if data != nil {
+			packed, _ := data.(map[string]interface{})[_MAILBOX_MAILS_KEY].(string)
+			if err := json.Unmarshal([]byte(packed), &mb.mails); err != nil {
+				gwlog.TraceError("%s: mailbox of %s is invalid: %s", s, target, err)
+			}
+		}
+
+		mb.loaded = true
+		pending := mb.pending
+		mb.pending = nil
+		for _, op := range pending {
+			op(mb)
+		}
+		s.releaseMailbox(target, mb)
+	})
+}
+
+func (s *OfflineMailService) saveMailbox(target EntityID, mb *mailbox) {
+	s.removeExpiredMails(mb)
+	packed, err := json.Marshal(mb.mails)
+	if err != nil {
+		gwlog.TraceError("%s: pack mailbox of %s failed: %s", s, target, err)
+		return
+	}
+	storage.Save(MAILBOX_STORAGE_TYPE, target, map[string]interface{}{_MAILBOX_MAILS_KEY: string(packed)}, nil)
+}
+
+// Remove expired mails, returns true if any mail is removed
+func (s *OfflineMailService) removeExpiredMails(mb *mailbox) bool {
+	now := time.Now()
+	mails := mb.mails[:0]
+	for _, mail := range mb.mails {
+		if !mail.isExpired(now) {
+			mails = append(mails, mail)
+		}
+	}
+	removed := len(mails) < len(mb.mails)
+	mb.mails = mails
+	return removed
+}
@@ -0,0 +1,90 @@
+package entity
+
+import (
+	"encoding/json"
+
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+)
+
+const (
+	MAINTENANCE_MODE_KVDB_KEY = "__maintenance_mode__"
+)
+
+var (
+	maintenanceModeWatched = false
+)
+
+// Cluster-wide maintenance mode, saved in KVDB
+//
+// When maintenance mode is enabled, logins registered by RegisterLoginSession are rejected unless the
+// login key is in allowlist, and the rejected clients are kicked with the maintenance message. Gates with client
+// auth also reject connections of users not in allowlist, so accounts should be used as login keys.
+//
+// The mode is applied to dispatchers (which push it to gates) whenever the KVDB key is changed, including changes
+// made by other games and by editing KVDB directly.
+type MaintenanceMode struct {
+	Enabled   bool
+	Message   string
+	Allowlist []string
+}
+
+// Enable or disable maintenance mode of the whole cluster
+//
+// Online clients that are not in allowlist are kicked when maintenance mode is enabled
+func SetMaintenanceMode(mode MaintenanceMode) {
+	data, err := json.Marshal(mode)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+
+	kvdb.Put(MAINTENANCE_MODE_KVDB_KEY, string(data), func(err error) {
+		if err != nil {
+			gwlog.TraceError("SetMaintenanceMode: save to kvdb failed: %s", err)
+		}
+	})
+	sendMaintenanceMode(mode)
+}
+
+// Load maintenance mode from KVDB and apply it to dispatchers, called by engine when game is ready
+//
+// The KVDB key is watched after loaded, so that changes are applied without restarting games
+func LoadMaintenanceMode() {
+	kvdb.Get(MAINTENANCE_MODE_KVDB_KEY, func(val string, err error) {
+		if err != nil {
+			gwlog.TraceError("LoadMaintenanceMode: load from kvdb failed: %s", err)
+			return
+		}
+
+		applyMaintenanceMode(val)
+	})
+
+	if !maintenanceModeWatched {
+		maintenanceModeWatched = true
+		kvdb.Watch(MAINTENANCE_MODE_KVDB_KEY, func(key string, val string) {
+			if key == MAINTENANCE_MODE_KVDB_KEY {
+				applyMaintenanceMode(val)
+			}
+		})
+	}
+}
+
+func applyMaintenanceMode(val string) {
+	var mode MaintenanceMode
+	if val != "" {
+		if err := json.Unmarshal([]byte(val), &mode); err != nil {
+			gwlog.TraceError("LoadMaintenanceMode: invalid maintenance mode %s: %s", val, err)
+			return
+		}
+	}
+	sendMaintenanceMode(mode)
+}
+
+func sendMaintenanceMode(mode MaintenanceMode) {
+	gwlog.Info("Maintenance mode: enabled=%v, message=%s, allowlist=%v", mode.Enabled, mode.Message, mode.Allowlist)
+	// logins are registered to any dispatcher, so all dispatchers should know the mode
+	for _, dispatcherClient := range dispatcher_client.GetAllDispatcherClientsForSend() {
+		dispatcherClient.SendSetMaintenanceMode(mode.Enabled, mode.Message, mode.Allowlist)
+	}
+}
@@ -0,0 +1,289 @@
+package entity
+
+import (
+	"sort"
+	"time"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Matchmaking service collects tickets of entities on all games in queues, forms matches by matchers of queues,
+// creates a battle space of the queue's space kind on any game for each match, and notifies matched entities with
+// the space ID by IMatchmakingHandler.OnMatched.
+//
+// Each entity can wait in one queue at a time. Entities should cancel matchmaking when they are destroyed.
+
+const (
+	MATCHMAKING_SERVICE_TYPE = "__matchmaking__"
+	MATCHMAKING_SERVICE_NAME = "__matchmaking__"
+)
+
+// Ticket of the entity waiting in matchmaking queue
+type MatchTicket struct {
+	EntityID    EntityID
+	Rating      float64
+	Criteria    map[string]string // e.g. region and mode, which matchers may require to be the same
+	EnqueueTime time.Time         // set by the service
+}
+
+// Matcher forms matches from tickets in the queue, which are ordered by enqueue time
+//
+// Each match is a group of tickets playing together, tickets not in any match keep waiting. Matchers are called by
+// the service periodically, and should be registered on all games.
+type Matcher func(tickets []*MatchTicket) [][]*MatchTicket
+
+type matchmakingQueue struct {
+	spaceKind int
+	matcher   Matcher
+}
+
+// Optional interface for entities to handle matchmaking results
+type IMatchmakingHandler interface {
+	OnMatched(queue string, spaceID EntityID, matched []EntityID) // the battle space is created, entities should enter it
+	OnMatchmakingFailed(queue string, reason string)              // the ticket is removed from the queue
+}
+
+var (
+	matchmakingQueues = map[string]*matchmakingQueue{}
+)
+
+// The matchmaking service entity, created by CreateMatchmakingServiceAnywhere
+type MatchmakingService struct {
+	Entity
+
+	tickets       map[string][]*MatchTicket // waiting tickets of each queue, ordered by enqueue time
+	queueOfEntity map[EntityID]string
+}
+
+// Register the matchmaking service type
+//
+// Should be called on all games before running
+func RegisterMatchmakingService() {
+	RegisterEntity(MATCHMAKING_SERVICE_TYPE, &MatchmakingService{})
+}
+
+// Create the matchmaking service on any game, should be called only once in the cluster
+func CreateMatchmakingServiceAnywhere() {
+	createEntityAnywhere(MATCHMAKING_SERVICE_TYPE, nil)
+}
+
+// Register the matchmaking queue, a space of the kind is created for each match formed by the matcher
+//
+// Should be called on all games before running
+func RegisterMatchmakingQueue(queue string, spaceKind int, matcher Matcher) {
+	if spaceKind == 0 {
+		gwlog.Panicf("RegisterMatchmakingQueue: nil space can not be battle space")
+	}
+	matchmakingQueues[queue] = &matchmakingQueue{spaceKind: spaceKind, matcher: matcher}
+}
+
+// Check if the matchmaking service is ready
+func IsMatchmakingServiceReady() bool {
+	return len(GetServiceProviders(MATCHMAKING_SERVICE_NAME)) > 0
+}
+
+// Matcher forming matches of teamSize tickets with the same criteria, whose ratings differ at most maxRatingDiff
+func RatingMatcher(teamSize int, maxRatingDiff float64) Matcher {
+	return func(tickets []*MatchTicket) [][]*MatchTicket {
+		sorted := append([]*MatchTicket(nil), tickets...)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Rating < sorted[j].Rating
+		})
+
+		var matches [][]*MatchTicket
+		used := make([]bool, len(sorted))
+		for i, first := range sorted {
+			if used[i] {
+				continue
+			}
+			indexes := []int{i}
+			for j := i + 1; j < len(sorted) && len(indexes) < teamSize; j++ {
+				if sorted[j].Rating-first.Rating > maxRatingDiff {
+					break
+				}
+				if !used[j] && sameCriteria(first.Criteria, sorted[j].Criteria) {
+					indexes = append(indexes, j)
+				}
+			}
+			if len(indexes) < teamSize {
+				continue
+			}
+
+			match := make([]*MatchTicket, len(indexes))
+			for k, j := range indexes {
+				used[j] = true
+				match[k] = sorted[j]
+			}
+			matches = append(matches, match)
+		}
+		return matches
+	}
+}
+
+func sameCriteria(c1, c2 map[string]string) bool {
+	if len(c1) != len(c2) {
+		return false
+	}
+	for k, v := range c1 {
+		if v2, ok := c2[k]; !ok || v2 != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Enqueue the entity in the matchmaking queue, the previous ticket of the entity is replaced
+func (e *Entity) EnqueueMatchmaking(queue string, rating float64, criteria map[string]string) {
+	ticket := &MatchTicket{
+		EntityID: e.ID,
+		Rating:   rating,
+		Criteria: criteria,
+	}
+	e.CallService(MATCHMAKING_SERVICE_NAME, "Enqueue", queue, ticket)
+}
+
+// Remove the ticket of the entity from the matchmaking queue
+func (e *Entity) CancelMatchmaking() {
+	e.CallService(MATCHMAKING_SERVICE_NAME, "Cancel", e.ID)
+}
+
+// Called by matchmaking service when the entity is matched
+func (e *Entity) MatchedFromService(queue string, spaceID EntityID, matched []EntityID) {
+	if handler, ok := e.I.(IMatchmakingHandler); ok {
+		gwutils.RunPanicless(func() {
+			handler.OnMatched(queue, spaceID, matched)
+		})
+	}
+}
+
+// Called by matchmaking service when the ticket of the entity is removed without match
+func (e *Entity) MatchmakingFailedFromService(queue string, reason string) {
+	gwlog.Warn("%s: matchmaking in queue %s failed: %s", e, queue, reason)
+	if handler, ok := e.I.(IMatchmakingHandler); ok {
+		gwutils.RunPanicless(func() {
+			handler.OnMatchmakingFailed(queue, reason)
+		})
+	}
+}
+
+func (s *MatchmakingService) OnInit() {
+	s.tickets = map[string][]*MatchTicket{}
+	s.queueOfEntity = map[EntityID]string{}
+}
+
+func (s *MatchmakingService) OnCreated() {
+	gwlog.Info("Registering matchmaking service ...")
+	s.DeclareService(MATCHMAKING_SERVICE_NAME)
+	s.addRawTimer(consts.MATCHMAKING_INTERVAL, s.formMatches)
+}
+
+// Put the ticket in the queue
+func (s *MatchmakingService) Enqueue(queue string, ticket *MatchTicket) {
+	if _, ok := matchmakingQueues[queue]; !ok {
+		s.Call(ticket.EntityID, "MatchmakingFailedFromService", queue, "unknown queue")
+		return
+	}
+
+	s.removeTicket(ticket.EntityID)
+	ticket.EnqueueTime = time.Now()
+	s.tickets[queue] = append(s.tickets[queue], ticket)
+	s.queueOfEntity[ticket.EntityID] = queue
+}
+
+// Remove the ticket of entity from its queue
+func (s *MatchmakingService) Cancel(eid EntityID) {
+	s.removeTicket(eid)
+}
+
+func (s *MatchmakingService) removeTicket(eid EntityID) {
+	queue, ok := s.queueOfEntity[eid]
+	if !ok {
+		return
+	}
+	delete(s.queueOfEntity, eid)
+
+	tickets := s.tickets[queue]
+	for i, t := range tickets {
+		if t.EntityID == eid {
+			s.tickets[queue] = append(tickets[:i], tickets[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *MatchmakingService) formMatches() {
+	now := time.Now()
+	for queue, tickets := range s.tickets {
+		// remove tickets waiting too long
+		var expired []*MatchTicket
+		for _, t := range tickets {
+			if now.Sub(t.EnqueueTime) >= consts.MATCHMAKING_TICKET_TIMEOUT {
+				expired = append(expired, t)
+			}
+		}
+		for _, t := range expired {
+			s.removeTicket(t.EntityID)
+			s.Call(t.EntityID, "MatchmakingFailedFromService", queue, "timeout")
+		}
+		tickets = s.tickets[queue]
+		if len(tickets) == 0 {
+			continue
+		}
+
+		mq := matchmakingQueues[queue]
+		var matches [][]*MatchTicket
+		gwutils.RunPanicless(func() {
+			matches = mq.matcher(append([]*MatchTicket(nil), tickets...))
+		})
+		for _, match := range matches {
+			if s.takeMatch(queue, match) {
+				s.startMatch(queue, mq.spaceKind, match)
+			}
+		}
+	}
+}
+
+// Remove tickets of the match from the queue, returns false if the match is invalid
+func (s *MatchmakingService) takeMatch(queue string, match []*MatchTicket) bool {
+	if len(match) == 0 {
+		return false
+	}
+	seen := map[EntityID]bool{}
+	for _, t := range match {
+		if s.queueOfEntity[t.EntityID] != queue || seen[t.EntityID] {
+			gwlog.Error("%s: matcher of queue %s returned invalid match containing %s", s, queue, t.EntityID)
+			return false
+		}
+		seen[t.EntityID] = true
+	}
+
+	for _, t := range match {
+		s.removeTicket(t.EntityID)
+	}
+	return true
+}
+
+func (s *MatchmakingService) startMatch(queue string, spaceKind int, match []*MatchTicket) {
+	matched := make([]EntityID, len(match))
+	for i, t := range match {
+		matched[i] = t.EntityID
+	}
+
+	CreateEntityAnywhereWithCallback(SPACE_ENTITY_TYPE, map[string]interface{}{
+		SPACE_KIND_ATTR_KEY: spaceKind,
+	}, func(spaceID EntityID, err error) {
+		for _, eid := range matched {
+			if err != nil {
+				s.Call(eid, "MatchmakingFailedFromService", queue, "create battle space failed: "+err.Error())
+			} else {
+				s.Call(eid, "MatchedFromService", queue, spaceID, matched)
+			}
+		}
+		if err == nil {
+			gwlog.Info("%s: match of queue %s started in space %s: %v", s, queue, spaceID, matched)
+		}
+	})
+}
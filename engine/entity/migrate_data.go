@@ -0,0 +1,277 @@
+package entity
+
+import (
+	"math/rand"
+	"reflect"
+
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Migrate data of entities migrating between two games is cached by both games, so that only the diff of
+// migrate data is sent when the entity migrates between these two games again.
+//
+// Each migrate data is identified by a random token, and diff is sent along with the token of its base. If the
+// base is not cached by the target game, the target game requests the full migrate data from the source game
+// and holds calls to the entity until the entity is created.
+
+type migrateDataCacheItem struct {
+	token uint32
+	data  []byte // packed full migrate data
+}
+
+type pendingMigrateIn struct {
+	create       func(migrateData map[string]interface{})
+	heldCalls    []func()
+	timeoutTimer *timer.Timer
+}
+
+var (
+	migrateDataCache      = map[EntityID]map[uint16]*migrateDataCacheItem{} // cached migrate data of entity exchanged with each game
+	migrateDataCacheItems = 0
+	pendingMigrateIns     = map[EntityID]*pendingMigrateIn{}
+)
+
+// pack the migrate data for migrating to the target game, returns the diff if the target game caches the base
+func packMigrateData(eid EntityID, targetGame uint16, isLocal bool, migrateData map[string]interface{}) (token uint32, baseToken uint32, payload []byte) {
+	full, err := netutil.MSG_PACKER.PackMsg(migrateData, nil)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+
+	if isLocal { // migrating in the same game, no need to cache
+		return 0, 0, full
+	}
+
+	token = genMigrateDataToken()
+	base := getMigrateDataCache(eid, targetGame)
+	setMigrateDataCache(eid, targetGame, token, full)
+	if base == nil {
+		return token, 0, full
+	}
+
+	var baseData, curData map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(base.data, &baseData); err != nil {
+		return token, 0, full
+	}
+	if err := netutil.MSG_PACKER.UnpackMsg(full, &curData); err != nil {
+		return token, 0, full
+	}
+
+	diff, err := netutil.MSG_PACKER.PackMsg(diffMigrateData(baseData, curData), nil)
+	if err != nil || len(diff) >= len(full) {
+		return token, 0, full
+	}
+
+	if consts.DEBUG_MIGRATE {
+		gwlog.Debug("packMigrateData: entity %s migrating to game %d with diff: %d/%d bytes", eid, targetGame, len(diff), len(full))
+	}
+	return token, base.token, diff
+}
+
+// unpack the migrate data from the source game, returns false if the base of diff is not cached
+func unpackMigrateData(eid EntityID, sourceGame uint16, token uint32, baseToken uint32, payload []byte) (map[string]interface{}, bool) {
+	full := payload
+	if baseToken != 0 {
+		base := getMigrateDataCache(eid, sourceGame)
+		if base == nil || base.token != baseToken {
+			return nil, false
+		}
+
+		var baseData, diff map[string]interface{}
+		if err := netutil.MSG_PACKER.UnpackMsg(base.data, &baseData); err != nil {
+			gwlog.Panic(errors.Wrap(err, "unpack cached migrate data failed"))
+		}
+		if err := netutil.MSG_PACKER.UnpackMsg(payload, &diff); err != nil {
+			gwlog.Panic(errors.Wrap(err, "unpack migrate data diff failed"))
+		}
+
+		var err error
+		if full, err = netutil.MSG_PACKER.PackMsg(applyMigrateDataDiff(baseData, diff), nil); err != nil {
+			gwlog.Panic(err)
+		}
+	}
+
+	if token != 0 {
+		setMigrateDataCache(eid, sourceGame, token, full)
+	}
+
+	var migrateData map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(full, &migrateData); err != nil {
+		gwlog.Panic(errors.Wrap(err, "unpack migrate data failed"))
+	}
+	return migrateData, true
+}
+
+// hold the migrating entity until the full migrate data is received from source game
+func holdMigrateIn(eid EntityID, sourceGame uint16, create func(migrateData map[string]interface{})) {
+	gwlog.Warn("Migrate data of entity %s from game %d is not cached, requesting full migrate data ...", eid, sourceGame)
+
+	pending := &pendingMigrateIn{create: create}
+	pending.timeoutTimer = timer.AddCallback(consts.DISPATCHER_MIGRATE_TIMEOUT, func() {
+		if pendingMigrateIns[eid] != pending {
+			return
+		}
+		delete(pendingMigrateIns, eid)
+		gwlog.TraceError("Migrate data of entity %s from game %d timeout, %d calls dropped", eid, sourceGame, len(pending.heldCalls))
+	})
+	pendingMigrateIns[eid] = pending
+	dispatcher_client.GetDispatcherClientForEntity(eid).SendRequestMigrateData(sourceGame, eid)
+}
+
+// hold the call if the entity is waiting for full migrate data, returns false if the call is not held
+func holdCallToMigratingIn(eid EntityID, call func()) bool {
+	pending := pendingMigrateIns[eid]
+	if pending == nil {
+		return holdCallToArrivingGroup(eid, call)
+	}
+	pending.heldCalls = append(pending.heldCalls, call)
+	return true
+}
+
+// Called by engine when the target game requests the full migrate data
+func OnRequestMigrateData(eid EntityID, targetGame uint16) {
+	var token uint32
+	var data []byte
+	if item := getMigrateDataCache(eid, targetGame); item != nil {
+		token, data = item.token, item.data
+	} else {
+		gwlog.TraceError("OnRequestMigrateData: migrate data of entity %s to game %d is not cached", eid, targetGame)
+	}
+	dispatcher_client.GetDispatcherClientForEntity(eid).SendMigrateData(targetGame, eid, token, data)
+}
+
+// Called by engine when the full migrate data is received from the source game
+func OnMigrateData(eid EntityID, token uint32, payload []byte) {
+	pending := pendingMigrateIns[eid]
+	if pending == nil {
+		return // timeout already
+	}
+	delete(pendingMigrateIns, eid)
+	pending.timeoutTimer.Cancel()
+
+	if token == 0 {
+		gwlog.TraceError("OnMigrateData: migrate data of entity %s is lost, %d calls dropped", eid, len(pending.heldCalls))
+		return
+	}
+
+	var migrateData map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(payload, &migrateData); err != nil {
+		gwlog.TraceError("OnMigrateData: unpack migrate data of entity %s failed: %s", eid, err)
+		return
+	}
+
+	pending.create(migrateData)
+	for _, call := range pending.heldCalls {
+		call()
+	}
+}
+
+func genMigrateDataToken() uint32 {
+	for {
+		if token := rand.Uint32(); token != 0 { // token 0 means not cached
+			return token
+		}
+	}
+}
+
+func getMigrateDataCache(eid EntityID, gameid uint16) *migrateDataCacheItem {
+	return migrateDataCache[eid][gameid]
+}
+
+func setMigrateDataCache(eid EntityID, gameid uint16, token uint32, data []byte) {
+	items := migrateDataCache[eid]
+	if items == nil {
+		for evictEid, evictItems := range migrateDataCache { // evict random entities if cache is full
+			if migrateDataCacheItems < consts.MIGRATE_DATA_CACHE_SIZE {
+				break
+			}
+			migrateDataCacheItems -= len(evictItems)
+			delete(migrateDataCache, evictEid)
+		}
+
+		items = map[uint16]*migrateDataCacheItem{}
+		migrateDataCache[eid] = items
+	}
+
+	if _, ok := items[gameid]; !ok {
+		migrateDataCacheItems += 1
+	}
+	items[gameid] = &migrateDataCacheItem{token: token, data: data}
+}
+
+func forgetMigrateData(eid EntityID) {
+	migrateDataCacheItems -= len(migrateDataCache[eid])
+	delete(migrateDataCache, eid)
+}
+
+// diff of map is a map with optional fields: "s" for changed values, "d" for deleted keys, "m" for diffs of sub maps
+func diffMigrateData(base, cur map[string]interface{}) map[string]interface{} {
+	set := map[string]interface{}{}
+	sub := map[string]interface{}{}
+	var del []string
+
+	for key, val := range cur {
+		baseVal, ok := base[key]
+		if !ok {
+			set[key] = val
+			continue
+		}
+
+		baseMap, isBaseMap := baseVal.(map[string]interface{})
+		curMap, isCurMap := val.(map[string]interface{})
+		if isBaseMap && isCurMap {
+			if d := diffMigrateData(baseMap, curMap); len(d) > 0 {
+				sub[key] = d
+			}
+		} else if !reflect.DeepEqual(baseVal, val) {
+			set[key] = val
+		}
+	}
+
+	for key := range base {
+		if _, ok := cur[key]; !ok {
+			del = append(del, key)
+		}
+	}
+
+	diff := map[string]interface{}{}
+	if len(set) > 0 {
+		diff["s"] = set
+	}
+	if len(del) > 0 {
+		diff["d"] = del
+	}
+	if len(sub) > 0 {
+		diff["m"] = sub
+	}
+	return diff
+}
+
+func applyMigrateDataDiff(base, diff map[string]interface{}) map[string]interface{} {
+	if set, ok := diff["s"].(map[string]interface{}); ok {
+		for key, val := range set {
+			base[key] = val
+		}
+	}
+	if del, ok := diff["d"].([]interface{}); ok {
+		for _, key := range del {
+			delete(base, key.(string))
+		}
+	}
+	if sub, ok := diff["m"].(map[string]interface{}); ok {
+		for key, d := range sub {
+			baseMap, _ := base[key].(map[string]interface{})
+			if baseMap == nil {
+				gwlog.Panicf("apply migrate data diff failed: %s is not a map", key)
+			}
+			base[key] = applyMigrateDataDiff(baseMap, d.(map[string]interface{}))
+		}
+	}
+	return base
+}
@@ -0,0 +1,246 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Entities of a group (e.g. a party and their pets) migrate to the target game in two phases:
+//
+// 1. Dispatchers of all entities are requested to block calls to these entities. If any dispatcher refuses (e.g. the
+//    entity is not on this game or is being migrated), entities which are blocked are unblocked and no entity leaves.
+// 2. All entities are sent to the target game in the same tick. The target game creates them after all of them
+//    arrive and holds calls to them meanwhile, calls queued by dispatchers are sent to each entity in order.
+
+// Optional interface for entities to handle group migrations which are canceled
+type IMigrateGroupHandler interface {
+	OnMigrateGroupFailed(targetGame uint16) // Called on each entity of the group, which stays on this game
+}
+
+type migrateGroup struct {
+	id           uint32
+	targetGame   uint16
+	entities     []*Entity
+	requestTime  int64
+	pendingAcks  int
+	failed       bool
+	blockedEids  []EntityID // entities blocked by dispatchers, which should be unblocked if canceled
+	timeoutTimer *timer.Timer
+}
+
+type arrivingGroupKey struct {
+	sourceGame uint16
+	groupID    uint32
+}
+
+type arrivingGroup struct {
+	key          arrivingGroupKey
+	size         int
+	eids         []EntityID
+	creates      []func() // creations of entities whose migrate data are received
+	heldCalls    map[EntityID][]func()
+	done         bool
+	timeoutTimer *timer.Timer
+}
+
+var (
+	migrateGroups         = map[uint32]*migrateGroup{}
+	lastMigrateGroupID    uint32
+	arrivingGroups        = map[arrivingGroupKey]*arrivingGroup{}
+	arrivingGroupOfEntity = map[EntityID]*arrivingGroup{}
+)
+
+// Migrate the entities to the target game atomically, so that either all of them arrive or none do
+//
+// Entities arrive in the nil space of target game, and can enter spaces of that game then. Entities implementing
+// IMigrateGroupHandler are notified if the migration is canceled.
+func MigrateGroupTo(gameID uint16, entities []*Entity) error {
+	if len(entities) == 0 {
+		return nil
+	}
+
+	eids := EntityIDSet{}
+	for _, e := range entities {
+		if e.IsDestroyed() {
+			return &EntityNotFoundError{EntityID: e.ID}
+		}
+		if e.IsSpaceEntity() {
+			return errors.Errorf("space %s can not migrate", e.ID)
+		}
+		if e.isEnteringSpace() {
+			return &MigrationInProgressError{EntityID: e.ID, SpaceID: e.enteringSpaceRequest.SpaceID}
+		}
+		if eids.Contains(e.ID) {
+			return errors.Errorf("duplicate entity %s in migrate group", e.ID)
+		}
+		eids.Add(e.ID)
+	}
+
+	lastMigrateGroupID += 1
+	group := &migrateGroup{
+		id:          lastMigrateGroupID,
+		targetGame:  gameID,
+		entities:    entities,
+		requestTime: time.Now().UnixNano(),
+	}
+
+	eidsOfDispatcher := map[uint16][]EntityID{}
+	for _, e := range entities {
+		e.enteringSpaceRequest.SpaceID = "" // entering the nil space of target game, other migrations are refused meanwhile
+		e.enteringSpaceRequest.EnterPos = e.GetPosition()
+		e.enteringSpaceRequest.RequestTime = group.requestTime
+
+		dispid := dispatcher_client.GetDispatcherIDOfEntity(e.ID)
+		eidsOfDispatcher[dispid] = append(eidsOfDispatcher[dispid], e.ID)
+	}
+
+	group.pendingAcks = len(eidsOfDispatcher)
+	group.timeoutTimer = timer.AddCallback(consts.DISPATCHER_MIGRATE_TIMEOUT, func() {
+		if migrateGroups[group.id] != group {
+			return
+		}
+		delete(migrateGroups, group.id)
+		gwlog.Error("Migrate group %d to game %d timeout", group.id, group.targetGame)
+		group.cancel()
+	})
+	migrateGroups[group.id] = group
+
+	for _, groupEids := range eidsOfDispatcher {
+		dispatcher_client.GetDispatcherClientForEntity(groupEids[0]).SendMigrateGroupRequest(group.id, gameID, groupEids)
+	}
+	return nil
+}
+
+// Called by engine when the dispatcher acknowledges the migrate group request
+func OnMigrateGroupRequestAck(groupID uint32, eids []EntityID, ok bool) {
+	group := migrateGroups[groupID]
+	if group == nil {
+		if ok { // the group is timeout already
+			for _, eid := range eids {
+				dispatcher_client.GetDispatcherClientForEntity(eid).SendCancelMigrate(eid)
+			}
+		}
+		return
+	}
+
+	group.pendingAcks -= 1
+	if ok {
+		group.blockedEids = append(group.blockedEids, eids...)
+	} else {
+		gwlog.Warn("Migrate group %d to game %d is refused by dispatcher: %v", groupID, group.targetGame, eids)
+		group.failed = true
+	}
+	if group.pendingAcks > 0 {
+		return
+	}
+
+	delete(migrateGroups, groupID)
+	group.timeoutTimer.Cancel()
+
+	for _, e := range group.entities {
+		if e.IsDestroyed() || e.enteringSpaceRequest.RequestTime != group.requestTime {
+			gwlog.Warn("Migrate group %d to game %d: %s is destroyed or migrated", groupID, group.targetGame, e)
+			group.failed = true
+		}
+	}
+	if group.failed {
+		group.cancel()
+		return
+	}
+
+	groupSize := uint16(len(group.entities))
+	for _, e := range group.entities {
+		pos := e.enteringSpaceRequest.EnterPos
+		e.realMigrateTo("", pos, group.targetGame, group.id, groupSize)
+	}
+}
+
+func (group *migrateGroup) cancel() {
+	for _, eid := range group.blockedEids {
+		dispatcher_client.GetDispatcherClientForEntity(eid).SendCancelMigrate(eid)
+	}
+
+	for _, e := range group.entities {
+		if e.IsDestroyed() || e.enteringSpaceRequest.RequestTime != group.requestTime {
+			continue
+		}
+		e.clearEnteringSpaceRequest()
+		if handler, ok := e.I.(IMigrateGroupHandler); ok {
+			gwutils.RunPanicless(func() {
+				handler.OnMigrateGroupFailed(group.targetGame)
+			})
+		}
+	}
+}
+
+// hold the entity migrating in a group until all entities of the group are received, returns the create function
+// which creates all entities of the group after the last one is received
+func holdGroupMigrateIn(eid EntityID, sourceGame uint16, groupID uint32, groupSize uint16, create func(migrateData map[string]interface{})) func(migrateData map[string]interface{}) {
+	key := arrivingGroupKey{sourceGame, groupID}
+	group := arrivingGroups[key]
+	if group == nil {
+		group = &arrivingGroup{
+			key:       key,
+			size:      int(groupSize),
+			heldCalls: map[EntityID][]func(){},
+		}
+		group.timeoutTimer = timer.AddCallback(consts.DISPATCHER_MIGRATE_TIMEOUT, func() {
+			if group.done {
+				return
+			}
+			gwlog.TraceError("Migrate group %d from game %d timeout, %d/%d entities arrived", groupID, sourceGame, len(group.creates), group.size)
+			group.finish()
+		})
+		arrivingGroups[key] = group
+	}
+	group.eids = append(group.eids, eid)
+	arrivingGroupOfEntity[eid] = group
+
+	return func(migrateData map[string]interface{}) {
+		if group.done { // the group is timeout, create the entity immediately
+			create(migrateData)
+			return
+		}
+		group.creates = append(group.creates, func() {
+			create(migrateData)
+		})
+		if len(group.creates) == group.size {
+			group.timeoutTimer.Cancel()
+			group.finish()
+		}
+	}
+}
+
+func (group *arrivingGroup) finish() {
+	group.done = true
+	delete(arrivingGroups, group.key)
+	for _, eid := range group.eids {
+		delete(arrivingGroupOfEntity, eid)
+	}
+
+	for _, create := range group.creates {
+		create()
+	}
+	for _, eid := range group.eids {
+		for _, call := range group.heldCalls[eid] {
+			call()
+		}
+	}
+}
+
+// hold the call if the entity is waiting for other entities of its group, returns false if the call is not held
+func holdCallToArrivingGroup(eid EntityID, call func()) bool {
+	group := arrivingGroupOfEntity[eid]
+	if group == nil {
+		return false
+	}
+	group.heldCalls[eid] = append(group.heldCalls[eid], call)
+	return true
+}
@@ -0,0 +1,211 @@
+package entity
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+// Persistent timers are saved in KVDB when added, and fired by the persistent timer service as entity RPCs, so they
+// survive crashes and restarts of the games adding them, e.g. expiring mails in 7 days.
+//
+// Timers are keyed by fire times in KVDB, and the service only loads timers due soon. Each timer is removed from
+// KVDB before its call is sent, so it fires at most once even if the service is recreated on another game, and
+// timers missed when the service is down fire as soon as the service is created again.
+
+const (
+	PERSISTENT_TIMER_SERVICE_TYPE = "__persistent_timer__"
+	PERSISTENT_TIMER_SERVICE_NAME = "__persistent_timer__"
+
+	_PERSISTENT_TIMER_KVDB_KEY_PREFIX = "__ptimer__/"
+)
+
+// ID of persistent timers, returned by AddPersistentTimer
+type PersistentTimerID string
+
+type persistentTimer struct {
+	FireTime time.Time
+	Target   EntityID
+	TypeName string // type to load the target if it is not loaded, or empty if the target is not persistent
+	Method   string
+	Args     []interface{}
+}
+
+// The persistent timer service entity, created by CreatePersistentTimerServiceAnywhere
+type PersistentTimerService struct {
+	Entity
+
+	scheduled map[PersistentTimerID]*timer.Timer // scheduled timers, nil if the timer is firing
+}
+
+// Register the persistent timer service type
+//
+// Should be called on all games before running
+func RegisterPersistentTimerService() {
+	RegisterEntity(PERSISTENT_TIMER_SERVICE_TYPE, &PersistentTimerService{})
+}
+
+// Create the persistent timer service on any game, should be called only once in the cluster
+//
+// Create the service again if the game of the service is down, pending timers are loaded from KVDB
+func CreatePersistentTimerServiceAnywhere() {
+	createEntityAnywhere(PERSISTENT_TIMER_SERVICE_TYPE, nil)
+}
+
+// Check if the persistent timer service is ready
+func IsPersistentTimerServiceReady() bool {
+	return len(GetServiceProviders(PERSISTENT_TIMER_SERVICE_NAME)) > 0
+}
+
+// Add a persistent timer calling the method of the target entity at fire time
+//
+// If typeName is not empty, the target is loaded anywhere before the call if it is not loaded, which is required for
+// persistent entities that might be offline at fire time. Args are packed by MessagePack.
+func AddPersistentTimer(fireTime time.Time, target EntityID, typeName string, method string, args ...interface{}) PersistentTimerID {
+	pt := persistentTimer{
+		FireTime: fireTime,
+		Target:   target,
+		TypeName: typeName,
+		Method:   method,
+		Args:     args,
+	}
+	data, err := timersPacker.PackMsg(&pt, nil)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+
+	id := PersistentTimerID(fmt.Sprintf("%020d/%s", fireTime.UnixNano(), uuid.GenUUID()))
+	kvdb.Put(persistentTimerKey(id), string(data), func(err error) {
+		if err != nil {
+			gwlog.TraceError("AddPersistentTimer %s: save to kvdb failed: %s", id, err)
+			return
+		}
+		if time.Until(fireTime) < consts.PERSISTENT_TIMER_LOAD_AHEAD {
+			callPersistentTimerService("Schedule", id) // not loaded by the service in time
+		}
+	})
+	return id
+}
+
+// Cancel the persistent timer, the timer might still fire if it is already firing
+func CancelPersistentTimer(id PersistentTimerID) {
+	kvdb.Put(persistentTimerKey(id), "", func(err error) {
+		if err != nil {
+			gwlog.TraceError("CancelPersistentTimer %s: save to kvdb failed: %s", id, err)
+			return
+		}
+		callPersistentTimerService("Cancel", id)
+	})
+}
+
+func persistentTimerKey(id PersistentTimerID) string {
+	return _PERSISTENT_TIMER_KVDB_KEY_PREFIX + string(id)
+}
+
+func callPersistentTimerService(method string, args ...interface{}) {
+	serviceEid, err := entityManager.chooseServiceProvider(PERSISTENT_TIMER_SERVICE_NAME, "")
+	if err != nil {
+		gwlog.Warn("call persistent timer service %s failed: %s, timers are loaded when the service is ready", method, err)
+		return
+	}
+	callEntity(serviceEid, method, args)
+}
+
+func (s *PersistentTimerService) OnInit() {
+	s.scheduled = map[PersistentTimerID]*timer.Timer{}
+}
+
+func (s *PersistentTimerService) OnCreated() {
+	gwlog.Info("Registering persistent timer service ...")
+	s.DeclareService(PERSISTENT_TIMER_SERVICE_NAME)
+	s.loadDueTimers()
+	s.addRawTimer(consts.PERSISTENT_TIMER_LOAD_INTERVAL, s.loadDueTimers)
+}
+
+// Schedule the timer due soon, called when the timer is added
+func (s *PersistentTimerService) Schedule(id PersistentTimerID) {
+	kvdb.Get(persistentTimerKey(id), func(val string, err error) {
+		if err != nil {
+			gwlog.TraceError("%s: load timer %s failed: %s", s, id, err)
+			return
+		}
+		s.scheduleTimer(id, val)
+	})
+}
+
+// Unschedule the cancelled timer
+func (s *PersistentTimerService) Cancel(id PersistentTimerID) {
+	if t := s.scheduled[id]; t != nil {
+		s.cancelRawTimer(t)
+		delete(s.scheduled, id)
+	}
+}
+
+func (s *PersistentTimerService) loadDueTimers() {
+	endKey := persistentTimerKey(PersistentTimerID(fmt.Sprintf("%020d", time.Now().Add(consts.PERSISTENT_TIMER_LOAD_AHEAD).UnixNano())))
+	kvdb.GetRange(_PERSISTENT_TIMER_KVDB_KEY_PREFIX, endKey, func(items []kvdb_types.KVItem, err error) {
+		if err != nil {
+			gwlog.TraceError("%s: load timers failed: %s", s, err)
+			return
+		}
+		for _, item := range items {
+			s.scheduleTimer(PersistentTimerID(strings.TrimPrefix(item.Key, _PERSISTENT_TIMER_KVDB_KEY_PREFIX)), item.Val)
+		}
+	})
+}
+
+func (s *PersistentTimerService) scheduleTimer(id PersistentTimerID, val string) {
+	if s.IsDestroyed() || val == "" { // fired or cancelled
+		return
+	}
+	if _, ok := s.scheduled[id]; ok {
+		return
+	}
+
+	var pt persistentTimer
+	if err := timersPacker.UnpackMsg([]byte(val), &pt); err != nil {
+		gwlog.TraceError("%s: invalid timer %s: %s", s, id, err)
+		return
+	}
+	s.scheduled[id] = s.addRawCallback(time.Until(pt.FireTime), func() {
+		s.fire(id)
+	})
+}
+
+func (s *PersistentTimerService) fire(id PersistentTimerID) {
+	s.scheduled[id] = nil
+	key := persistentTimerKey(id)
+	kvdb.Get(key, func(val string, err error) {
+		if err != nil || val == "" { // retried by the next load if failed
+			delete(s.scheduled, id)
+			return
+		}
+
+		kvdb.Put(key, "", func(err error) {
+			delete(s.scheduled, id)
+			if err != nil {
+				gwlog.TraceError("%s: remove timer %s failed: %s", s, id, err)
+				return
+			}
+
+			var pt persistentTimer
+			if err := timersPacker.UnpackMsg([]byte(val), &pt); err != nil {
+				gwlog.TraceError("%s: invalid timer %s: %s", s, id, err)
+				return
+			}
+			gwlog.Debug("%s: timer %s fired: %s.%s%v", s, id, pt.Target, pt.Method, pt.Args)
+			if pt.TypeName != "" {
+				loadEntityAnywhere(pt.TypeName, pt.Target)
+			}
+			callEntity(pt.Target, pt.Method, pt.Args)
+		})
+	})
+}
@@ -0,0 +1,35 @@
+package entity
+
+import (
+	"github.com/xiaonanln/typeconv"
+)
+
+// Placement constraints restrict the games where entities are created or loaded anywhere, matching the labels of
+// games in config, e.g. "region=eu,tier!=free". See common.MatchPlacement for the syntax.
+
+var (
+	spaceKindPlacements = map[int]string{}
+)
+
+// Set the placement constraint of games for creating and loading entities of this type anywhere
+func (desc *EntityTypeDesc) SetPlacement(placement string) {
+	desc.placement = placement
+}
+
+// Set the placement constraint of games for creating spaces of the kind anywhere
+func SetSpaceKindPlacement(kind int, placement string) {
+	spaceKindPlacements[kind] = placement
+}
+
+func getPlacement(typeName string, data map[string]interface{}) string {
+	if typeName == SPACE_ENTITY_TYPE && data != nil {
+		if placement, ok := spaceKindPlacements[int(typeconv.Int(data[SPACE_KIND_ATTR_KEY]))]; ok {
+			return placement
+		}
+	}
+
+	if desc, ok := registeredEntityTypes[typeName]; ok {
+		return desc.placement
+	}
+	return ""
+}
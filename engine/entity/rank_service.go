@@ -0,0 +1,196 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/rank"
+	"github.com/xiaonanln/goworld/engine/rank/types"
+)
+
+// Rank service keeps leaderboards of the cluster, e.g. ranks of player levels or guild scores. Members of boards are
+// ranked by scores in descending order.
+//
+// Boards are kept in Redis sorted sets if [rank] is configured, otherwise they are kept in memory of the service and
+// lost when the game of the service quits or freezes. Scores can be updated anywhere, and entities query ranks by
+// callbacks, which are dropped if the entities migrate before the replies.
+
+const (
+	RANK_SERVICE_TYPE = "__rank__"
+	RANK_SERVICE_NAME = "__rank__"
+)
+
+// Callback of rank queries, rank is -1 if the member is not ranked, neighbors are ordered by rank and include the member
+type RankCallback func(rank int, score float64, neighbors []rank_types.RankItem, err error)
+
+// Callback of rank page queries, total is the count of members in the board
+type RankPageCallback func(items []rank_types.RankItem, total int, err error)
+
+type pendingRankRequest struct {
+	callback     func(items []rank_types.RankItem, total int, err error)
+	timeoutTimer *timer.Timer
+}
+
+var (
+	lastRankReqID       uint32
+	pendingRankRequests = map[uint32]*pendingRankRequest{}
+)
+
+// The rank service entity, created by CreateRankServiceAnywhere
+type RankService struct {
+	Entity
+}
+
+// Register the rank service type
+//
+// Should be called on all games before running
+func RegisterRankService() {
+	RegisterEntity(RANK_SERVICE_TYPE, &RankService{})
+}
+
+// Create the rank service on any game, should be called only once in the cluster
+func CreateRankServiceAnywhere() {
+	createEntityAnywhere(RANK_SERVICE_TYPE, nil)
+}
+
+// Check if the rank service is ready
+func IsRankServiceReady() bool {
+	return len(GetServiceProviders(RANK_SERVICE_NAME)) > 0
+}
+
+// Set the score of member in the board
+func UpdateRankScore(board string, member string, score float64) {
+	callRankService("Update", board, member, score)
+}
+
+// Increase the score of member in the board by delta, members not ranked are treated as 0
+func IncrRankScore(board string, member string, delta float64) {
+	callRankService("Incr", board, member, delta)
+}
+
+// Remove the member from the board
+func RemoveRankMember(board string, member string) {
+	callRankService("Remove", board, member)
+}
+
+func callRankService(method string, args ...interface{}) {
+	serviceEid, err := entityManager.chooseServiceProvider(RANK_SERVICE_NAME, "")
+	if err != nil {
+		gwlog.Error("call rank service %s%v failed: %s", method, args, err)
+		return
+	}
+	callEntity(serviceEid, method, args)
+}
+
+// Query the rank and score of member in the board, and n members ranked before and after the member
+func (e *Entity) QueryRank(board string, member string, n int, callback RankCallback) {
+	reqid := e.addRankRequest(func(items []rank_types.RankItem, total int, err error) {
+		for _, item := range items {
+			if item.Member == member {
+				callback(item.Rank, item.Score, items, err)
+				return
+			}
+		}
+		callback(-1, 0, nil, err)
+	})
+	callRankService("GetRank", board, member, n, e.ID, reqid)
+}
+
+// Query the page of the board, e.g. page 0 of size 10 are the top 10 members
+func (e *Entity) QueryRankPage(board string, page int, pageSize int, callback RankPageCallback) {
+	reqid := e.addRankRequest(callback)
+	callRankService("GetRange", board, page*pageSize, (page+1)*pageSize, e.ID, reqid)
+}
+
+func (e *Entity) addRankRequest(callback func(items []rank_types.RankItem, total int, err error)) uint32 {
+	lastRankReqID += 1
+	reqid := lastRankReqID
+
+	pending := &pendingRankRequest{callback: callback}
+	pending.timeoutTimer = timer.AddCallback(consts.RANK_REQUEST_TIMEOUT, func() {
+		if pendingRankRequests[reqid] != pending {
+			return
+		}
+		delete(pendingRankRequests, reqid)
+		gwutils.RunPanicless(func() {
+			callback(nil, 0, errors.Errorf("rank request %d timeout", reqid))
+		})
+	})
+	pendingRankRequests[reqid] = pending
+	return reqid
+}
+
+// Called by rank service with results of queries
+func (e *Entity) RankResultFromService(reqid uint32, items []rank_types.RankItem, total int, errmsg string) {
+	pending := pendingRankRequests[reqid]
+	if pending == nil {
+		gwlog.Warn("%s: rank request %d is timeout or lost", e, reqid)
+		return
+	}
+	delete(pendingRankRequests, reqid)
+	pending.timeoutTimer.Cancel()
+
+	var err error
+	if errmsg != "" {
+		err = errors.New(errmsg)
+	}
+	gwutils.RunPanicless(func() {
+		pending.callback(items, total, err)
+	})
+}
+
+func (s *RankService) OnCreated() {
+	gwlog.Info("Registering rank service ...")
+	s.DeclareService(RANK_SERVICE_NAME)
+}
+
+// Set the score of member in the board
+func (s *RankService) Update(board string, member string, score float64) {
+	rank.SetScore(board, member, score, s.checkError("update", board, member))
+}
+
+// Increase the score of member in the board
+func (s *RankService) Incr(board string, member string, delta float64) {
+	rank.IncrScore(board, member, delta, func(score float64, err error) {
+		s.checkError("incr", board, member)(err)
+	})
+}
+
+// Remove the member from the board
+func (s *RankService) Remove(board string, member string) {
+	rank.Remove(board, member, s.checkError("remove", board, member))
+}
+
+func (s *RankService) checkError(op string, board string, member string) rank.RankCallback {
+	return func(err error) {
+		if err != nil {
+			gwlog.Error("%s: %s %s of board %s failed: %s", s, op, member, board, err)
+		}
+	}
+}
+
+// Reply the member and its neighbors in the board to requester
+func (s *RankService) GetRank(board string, member string, n int, requester EntityID, reqid uint32) {
+	rank.GetNeighbors(board, member, n, s.replyRankResult(requester, reqid))
+}
+
+// Reply items of ranks in [start, stop) of the board to requester
+func (s *RankService) GetRange(board string, start int, stop int, requester EntityID, reqid uint32) {
+	rank.GetRange(board, start, stop, s.replyRankResult(requester, reqid))
+}
+
+func (s *RankService) replyRankResult(requester EntityID, reqid uint32) rank.RankRangeCallback {
+	return func(items []rank_types.RankItem, count int, err error) {
+		if s.IsDestroyed() {
+			return
+		}
+		errmsg := ""
+		if err != nil {
+			errmsg = err.Error()
+		}
+		s.Call(requester, "RankResultFromService", reqid, items, count, errmsg)
+	}
+}
@@ -0,0 +1,68 @@
+package entity
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/common"
+)
+
+// Entities are restored from freeze data in order: the nil space, other spaces, then other entity types. Entity
+// types declare restore dependencies (e.g. Guild depends on GuildManager, Avatar depends on Guild), so that all
+// entities of dependency types are restored before OnRestored is called on entities of the depending type.
+
+// Declare the entity types which should be restored before this type
+func (desc *EntityTypeDesc) DeclareRestoreDependencies(typeNames ...string) {
+	desc.restoreDeps = append(desc.restoreDeps, typeNames...)
+}
+
+// Order non-space entity types for restoring, so that every type is after its dependencies
+//
+// Dependencies without entities to restore are ignored, and types without dependencies are ordered by name.
+func getRestoreOrder(typeNames StringSet) ([]string, error) {
+	sortedTypeNames := typeNames.ToList()
+	sort.Strings(sortedTypeNames)
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := map[string]int{}
+	var order []string
+	var path []string
+
+	var visit func(typeName string) error
+	visit = func(typeName string) error {
+		if states[typeName] == visited {
+			return nil
+		} else if states[typeName] == visiting {
+			return errors.Errorf("restore dependency cycle: %s -> %s", strings.Join(path, " -> "), typeName)
+		}
+
+		states[typeName] = visiting
+		path = append(path, typeName)
+		if desc := registeredEntityTypes[typeName]; desc != nil {
+			for _, dep := range desc.restoreDeps {
+				if dep == typeName || !typeNames.Contains(dep) {
+					continue
+				}
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		states[typeName] = visited
+		order = append(order, typeName)
+		return nil
+	}
+
+	for _, typeName := range sortedTypeNames {
+		if err := visit(typeName); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
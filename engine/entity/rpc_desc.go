@@ -16,14 +16,13 @@ type RpcDesc struct {
 	Flags      uint
 	MethodType reflect.Type
 	NumArgs    int
+	component  int // index of component receiving the call, -1 for methods of entity
 }
 
 type RpcDescMap map[string]*RpcDesc
 
-func (rdm RpcDescMap) visit(method reflect.Method) {
-	methodName := method.Name
-	var flag uint
-	var rpcName string
+// Get the RPC name and flags of method by the suffix of method name
+func parseRpcMethodName(methodName string) (rpcName string, flag uint) {
 	if strings.HasSuffix(methodName, "_Client") {
 		flag |= (RF_SERVER + RF_OWN_CLIENT)
 		rpcName = methodName[:len(methodName)-7]
@@ -35,6 +34,11 @@ func (rdm RpcDescMap) visit(method reflect.Method) {
 		flag |= RF_SERVER
 		rpcName = methodName
 	}
+	return
+}
+
+func (rdm RpcDescMap) visit(method reflect.Method) {
+	rpcName, flag := parseRpcMethodName(method.Name)
 
 	methodType := method.Type
 	rdm[rpcName] = &RpcDesc{
@@ -42,5 +46,16 @@ func (rdm RpcDescMap) visit(method reflect.Method) {
 		Flags:      flag,
 		MethodType: methodType,
 		NumArgs:    methodType.NumIn() - 1, // do not count the receiver
+		component:  -1,
+	}
+}
+
+// Visit method of component, the RPC name is prefixed by the component name
+func (rdm RpcDescMap) visitComponent(componentName string, component int, method reflect.Method) {
+	methods := RpcDescMap{}
+	methods.visit(method)
+	for rpcName, rpcDesc := range methods {
+		rpcDesc.component = component
+		rdm[componentName+"."+rpcName] = rpcDesc
 	}
 }
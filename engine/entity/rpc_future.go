@@ -0,0 +1,236 @@
+package entity
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+// Calls with results invoke methods on remote entities and route return values back to the caller game.
+//
+// If the last return value of the method is an error, it is returned as the error of the call instead of results.
+
+var (
+	// Error of calls which are not replied before timeout
+	ErrRpcTimeout = errors.New("rpc call timeout")
+
+	defaultRpcTimeout = consts.RPC_CALL_DEFAULT_TIMEOUT
+	lastRpcReqID      uint32
+	pendingRpcFutures = map[uint32]*RpcFuture{}
+	errorType         = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// Packed return values of the called method
+type RpcResults [][]byte
+
+// Get the number of return values
+func (r RpcResults) Len() int {
+	return len(r)
+}
+
+// Decode return values to pointers one by one
+func (r RpcResults) Decode(ptrs ...interface{}) error {
+	if len(ptrs) > len(r) {
+		return errors.Errorf("decode %d results, but only %d returned", len(ptrs), len(r))
+	}
+	for i, ptr := range ptrs {
+		if err := netutil.MSG_PACKER.UnpackMsg(r[i], ptr); err != nil {
+			return errors.Wrapf(err, "decode result %d", i)
+		}
+	}
+	return nil
+}
+
+// Callback of RpcFuture, err is not nil if the call fails or timeout
+type RpcCallback func(results RpcResults, err error)
+
+// Future of call with results
+type RpcFuture struct {
+	Method string
+
+	startTime    time.Time
+	span         *tracing.Span       // client span of the call
+	trace        tracing.SpanContext // span context of the caller, which is continued by callbacks
+	done         bool
+	results      RpcResults
+	err          error
+	callbacks    []RpcCallback
+	timeoutTimer *timer.Timer
+}
+
+// Add callback which is called when the call is done, or immediately if it is already done
+func (f *RpcFuture) Then(cb RpcCallback) *RpcFuture {
+	if f.done {
+		f.runCallback(cb)
+	} else {
+		f.callbacks = append(f.callbacks, cb)
+	}
+	return f
+}
+
+// Check if the call is done
+func (f *RpcFuture) IsDone() bool {
+	return f.done
+}
+
+// Get the results of done call
+func (f *RpcFuture) Results() RpcResults {
+	return f.results
+}
+
+// Get the error of done call
+func (f *RpcFuture) Err() error {
+	return f.err
+}
+
+func (f *RpcFuture) resolve(results RpcResults, err error) {
+	if f.done {
+		return
+	}
+
+	f.done = true
+	f.results, f.err = results, err
+	rpcResultLatencyMetric.With(f.Method).ObserveDuration(time.Since(f.startTime))
+	if err != nil {
+		f.span.SetError(err.Error())
+	}
+	f.span.End()
+	if f.timeoutTimer != nil {
+		f.timeoutTimer.Cancel()
+		f.timeoutTimer = nil
+	}
+
+	callbacks := f.callbacks
+	f.callbacks = nil
+	for _, cb := range callbacks {
+		f.runCallback(cb)
+	}
+}
+
+func (f *RpcFuture) runCallback(cb RpcCallback) {
+	prev := tracing.SetCurrent(f.trace)
+	defer tracing.SetCurrent(prev)
+	gwutils.RunPanicless(func() {
+		cb(f.results, f.err)
+	})
+}
+
+// Set the default timeout of calls with results
+func SetDefaultRpcTimeout(timeout time.Duration) {
+	defaultRpcTimeout = timeout
+}
+
+// Call the method of entity and get the return values with default timeout
+func CallWithResult(id EntityID, method string, args ...interface{}) *RpcFuture {
+	return CallWithResultTimeout(id, method, defaultRpcTimeout, args...)
+}
+
+// Call the method of entity and get the return values, the future fails with ErrRpcTimeout after timeout
+func CallWithResultTimeout(id EntityID, method string, timeout time.Duration, args ...interface{}) *RpcFuture {
+	lastRpcReqID += 1
+	reqid := lastRpcReqID
+
+	f := &RpcFuture{Method: method, startTime: time.Now(), span: startCallSpan(id, method), trace: tracing.Current()}
+	f.timeoutTimer = timer.AddCallback(timeout, func() {
+		if pendingRpcFutures[reqid] != f {
+			return
+		}
+		delete(pendingRpcFutures, reqid)
+		f.timeoutTimer = nil
+		gwlog.Warn("CallWithResult: %s.%s timeout", id, method)
+		rpcTimeoutsMetric.With(method).Inc()
+		f.resolve(nil, ErrRpcTimeout)
+	})
+	pendingRpcFutures[reqid] = f
+
+	sendCallWithResult(id, reqid, timeout, method, args, f.span.Context())
+	return f
+}
+
+func sendCallWithResult(id EntityID, reqid uint32, timeout time.Duration, method string, args []interface{}, trace tracing.SpanContext) {
+	if holdingCallsForSavePoint != 0 {
+		holdCall(func() {
+			sendCallWithResult(id, reqid, timeout, method, args, trace)
+		})
+		return
+	}
+	dispatcher_client.GetDispatcherClientForEntity(id).SendCallEntityMethodWithResult(id, reqid, timeout, method, args, trace.Marshal())
+}
+
+// Call the method of entity and get the return values with default timeout
+func (e *Entity) CallWithResult(id EntityID, method string, args ...interface{}) *RpcFuture {
+	return CallWithResult(id, method, args...)
+}
+
+// Called by engine when other game calls the entity method with results
+func OnCallWithResult(id EntityID, method string, args [][]byte, reqid uint32, callerGameID uint16, trace tracing.SpanContext) {
+	var results []interface{}
+	var err error
+
+	e := entityManager.get(id)
+	if e == nil && holdCallToMigratingIn(id, func() { OnCallWithResult(id, method, args, reqid, callerGameID, trace) }) {
+		return
+	} else if e == nil {
+		err = errors.Errorf("entity %s not found", id)
+	} else {
+		results, err = e.onCallWithResultFromRemote(method, args, trace)
+	}
+
+	errmsg := ""
+	if err != nil {
+		errmsg = err.Error()
+	}
+	dispatcher_client.GetDispatcherClientForEntity(id).SendCallEntityMethodResult(callerGameID, reqid, errmsg, results) // back through the dispatcher of call
+}
+
+// Called by engine when results of call is routed back
+func OnCallResult(reqid uint32, errmsg string, results [][]byte) {
+	f := pendingRpcFutures[reqid]
+	if f == nil {
+		return // timeout already
+	}
+
+	delete(pendingRpcFutures, reqid)
+	if errmsg != "" {
+		f.resolve(nil, errors.New(errmsg))
+	} else {
+		f.resolve(RpcResults(results), nil)
+	}
+}
+
+func (e *Entity) onCallWithResultFromRemote(methodName string, args [][]byte, trace tracing.SpanContext) (results []interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			gwlog.TraceError("%s.%s paniced: %s", e, methodName, r)
+			results, err = nil, errors.New(fmt.Sprint(r))
+		}
+	}()
+
+	out, err := e.invokeFromRemote(methodName, args, "", trace)
+	if err != nil {
+		return nil, err
+	}
+
+	if n := len(out); n > 0 && out[n-1].Type() == errorType {
+		if !out[n-1].IsNil() {
+			return nil, out[n-1].Interface().(error)
+		}
+		out = out[:n-1]
+	}
+
+	results = make([]interface{}, len(out))
+	for i, v := range out {
+		results[i] = v.Interface()
+	}
+	return results, nil
+}
@@ -0,0 +1,216 @@
+package entity
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+// Calls from clients of protobuf encoding
+//
+// Arguments of calls from protobuf clients are one typed message of the RPC method generated by `gwtool gen-proto`,
+// in which fields are arguments in order, numbered from 1. Protobuf types of fields are decided by Go types of arguments
+// as ProtobufArgType, and arguments of other types are goworld.Value. Missing fields are zero values of arguments.
+
+const (
+	PROTOBUF_VALUE_TYPE = "Value" // goworld.Value for arguments of non-scalar types
+)
+
+var protobufScalarTypes = map[string]string{ // go type -> protobuf type
+	"bool":    "bool",
+	"int":     "int64",
+	"int8":    "int32",
+	"int16":   "int32",
+	"int32":   "int32",
+	"int64":   "int64",
+	"uint":    "uint64",
+	"uint8":   "uint32",
+	"byte":    "uint32",
+	"uint16":  "uint32",
+	"uint32":  "uint32",
+	"uint64":  "uint64",
+	"float32": "float",
+	"float64": "double",
+	"string":  "string",
+	"[]byte":  "bytes",
+	"[]uint8": "bytes",
+}
+
+// Get the protobuf type of RPC argument by go type, e.g. "int32" or "[]string", used by calls and `gwtool gen-proto`
+//
+// Slices of scalar types are repeated fields, and all other types are PROTOBUF_VALUE_TYPE.
+func ProtobufArgType(goType string) (protoType string, repeated bool) {
+	if t, ok := protobufScalarTypes[goType]; ok {
+		return t, false
+	}
+	if strings.HasPrefix(goType, "[]") {
+		if t, ok := protobufScalarTypes[goType[2:]]; ok {
+			return t, true
+		}
+	}
+	return PROTOBUF_VALUE_TYPE, false
+}
+
+// Called by engine when the call from protobuf client is received
+func OnCallFromProtobufClient(id EntityID, method string, data []byte, clientID ClientID) {
+	e := entityManager.get(id)
+	if e == nil {
+		data := append([]byte(nil), data...) // data is in the packet
+		if holdCallToMigratingIn(id, func() { OnCallFromProtobufClient(id, method, data, clientID) }) {
+			return
+		}
+		// entity not found, may destroyed before call
+		gwlog.Error("Entity %s is not found while calling %s from protobuf client", id, method)
+		return
+	}
+
+	rpcDesc := e.typeDesc.rpcDescs[method]
+	if rpcDesc == nil {
+		gwlog.Error("%s.OnCallFromProtobufClient: method %s is not a valid RPC", e, method)
+		return
+	}
+
+	args, err := decodeProtobufArgs(rpcDesc, data)
+	if err != nil {
+		gwlog.Error("%s.OnCallFromProtobufClient: decode arguments of %s failed: %s", e, method, err)
+		return
+	}
+	OnCall(id, method, args, clientID, tracing.SpanContext{})
+}
+
+// Decode arguments from the protobuf message, and pack them by netutil.MSG_PACKER
+func decodeProtobufArgs(rpcDesc *RpcDesc, data []byte) ([][]byte, error) {
+	values := make([]interface{}, rpcDesc.NumArgs) // decoded values of goworld.Value arguments
+	typed := make([]reflect.Value, rpcDesc.NumArgs)
+	for i := range typed {
+		argType := rpcDesc.MethodType.In(i + 1)
+		if protoType, _ := ProtobufArgType(argType.String()); protoType != PROTOBUF_VALUE_TYPE {
+			typed[i] = reflect.New(argType).Elem()
+		} else {
+			values[i] = reflect.Zero(argType).Interface()
+		}
+	}
+
+	for len(data) > 0 {
+		field, n, err := netutil.ReadProtobufField(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if field.Num < 1 || field.Num > rpcDesc.NumArgs {
+			continue // unknown fields are ignored
+		}
+
+		i := field.Num - 1
+		if !typed[i].IsValid() {
+			if field.WireType != netutil.PROTOBUF_WIRE_BYTES {
+				return nil, errors.Errorf("argument %d: wrong wire type %d of Value", field.Num, field.WireType)
+			}
+			err = netutil.ProtobufMsgPacker{}.UnpackMsg(field.Bytes, &values[i])
+		} else if typed[i].Kind() == reflect.Slice && typed[i].Type().Elem().Kind() != reflect.Uint8 {
+			err = appendProtobufRepeated(typed[i], field)
+		} else {
+			err = setProtobufScalar(typed[i], field)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "argument %d", field.Num)
+		}
+	}
+
+	args := make([][]byte, rpcDesc.NumArgs)
+	for i := range args {
+		v := values[i]
+		if typed[i].IsValid() {
+			v = typed[i].Interface()
+		}
+
+		var err error
+		if args[i], err = netutil.MSG_PACKER.PackMsg(v, nil); err != nil {
+			return nil, err
+		}
+	}
+	return args, nil
+}
+
+func protobufWireTypeOf(kind reflect.Kind) int {
+	switch kind {
+	case reflect.Float32:
+		return netutil.PROTOBUF_WIRE_FIXED32
+	case reflect.Float64:
+		return netutil.PROTOBUF_WIRE_FIXED64
+	case reflect.String, reflect.Slice:
+		return netutil.PROTOBUF_WIRE_BYTES
+	default:
+		return netutil.PROTOBUF_WIRE_VARINT
+	}
+}
+
+func setProtobufScalar(v reflect.Value, field netutil.ProtobufField) error {
+	if field.WireType != protobufWireTypeOf(v.Kind()) {
+		return errors.Errorf("wrong wire type %d for %s", field.WireType, v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(field.Value != 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(field.Value))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(field.Value)
+	case reflect.Float32:
+		v.SetFloat(float64(math.Float32frombits(uint32(field.Value))))
+	case reflect.Float64:
+		v.SetFloat(math.Float64frombits(field.Value))
+	case reflect.String:
+		v.SetString(string(field.Bytes))
+	case reflect.Slice:
+		v.SetBytes(append([]byte(nil), field.Bytes...))
+	}
+	return nil
+}
+
+// Append elements of repeated field to the slice, numeric elements can be packed or not
+func appendProtobufRepeated(v reflect.Value, field netutil.ProtobufField) error {
+	elemType := v.Type().Elem()
+	wireType := protobufWireTypeOf(elemType.Kind())
+	if field.WireType == wireType || wireType == netutil.PROTOBUF_WIRE_BYTES {
+		elem := reflect.New(elemType).Elem()
+		if err := setProtobufScalar(elem, field); err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, elem))
+		return nil
+	} else if field.WireType != netutil.PROTOBUF_WIRE_BYTES {
+		return errors.Errorf("wrong wire type %d for %s", field.WireType, v.Type())
+	}
+
+	packed := field.Bytes
+	for len(packed) > 0 {
+		elemField := netutil.ProtobufField{Num: field.Num, WireType: wireType}
+		if wireType == netutil.PROTOBUF_WIRE_VARINT {
+			value, n := binary.Uvarint(packed)
+			if n <= 0 {
+				return errors.Errorf("truncated packed field")
+			}
+			elemField.Value, packed = value, packed[n:]
+		} else if wireType == netutil.PROTOBUF_WIRE_FIXED32 && len(packed) >= 4 {
+			elemField.Value, packed = uint64(binary.LittleEndian.Uint32(packed)), packed[4:]
+		} else if wireType == netutil.PROTOBUF_WIRE_FIXED64 && len(packed) >= 8 {
+			elemField.Value, packed = binary.LittleEndian.Uint64(packed), packed[8:]
+		} else {
+			return errors.Errorf("truncated packed field")
+		}
+
+		elem := reflect.New(elemType).Elem()
+		setProtobufScalar(elem, elemField)
+		v.Set(reflect.Append(v, elem))
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Action taken when calls from a client exceed the rate limit of the RPC method
+type RpcRateLimitAction int
+
+const (
+	RPC_RATE_LIMIT_DROP       RpcRateLimitAction = iota // drop calls exceeding the limit
+	RPC_RATE_LIMIT_DISCONNECT                           // drop the call and kick the client
+	RPC_RATE_LIMIT_BAN                                  // drop the call, kick the client and ban its IP at gate temporarily
+)
+
+// Method name for limiting calls of all RPC methods of the entity type from each client
+const RPC_RATE_LIMIT_ALL_METHODS = "*"
+
+type rpcRateLimit struct {
+	maxCalls int
+	per      time.Duration
+	action   RpcRateLimitAction
+}
+
+type rpcRateKey struct {
+	clientid common.ClientID
+	method   string
+}
+
+type rpcRateTracker struct {
+	windowStart time.Time
+	calls       int
+}
+
+// Optional interface for entities to handle clients exceeding RPC rate limits
+type IRpcRateLimitHandler interface {
+	OnRpcRateLimited(method string, clientid common.ClientID, action RpcRateLimitAction) // Called before the action is taken
+}
+
+var (
+	rpcRateLimitCallback func(e *Entity, method string, clientid common.ClientID, action RpcRateLimitAction)
+)
+
+// Declare the rate limit of calls of the RPC method from each client, e.g. at most 5 Say calls per second
+//
+// Use RPC_RATE_LIMIT_ALL_METHODS to limit calls of all RPC methods. Kicking and banning are only available for
+// clients owned by entities of the same game, calls from other clients are dropped.
+func (desc *EntityTypeDesc) DefineRpcRateLimit(method string, maxCalls int, per time.Duration, action RpcRateLimitAction) {
+	if desc.rpcRateLimits == nil {
+		desc.rpcRateLimits = map[string]rpcRateLimit{}
+	}
+	desc.rpcRateLimits[method] = rpcRateLimit{maxCalls: maxCalls, per: per, action: action}
+}
+
+// Set the global callback for clients exceeding RPC rate limits of all entities, useful for metrics & anti-cheat logs
+func SetRpcRateLimitCallback(cb func(e *Entity, method string, clientid common.ClientID, action RpcRateLimitAction)) {
+	rpcRateLimitCallback = cb
+}
+
+// Check rate limits of the call from client, returns false if the call should be dropped
+func (e *Entity) checkRpcRate(method string, clientid common.ClientID) bool {
+	if e.typeDesc.rpcRateLimits == nil {
+		return true
+	}
+
+	ok := e.checkRpcRateOfMethod(method, method, clientid)
+	if ok {
+		ok = e.checkRpcRateOfMethod(RPC_RATE_LIMIT_ALL_METHODS, method, clientid)
+	}
+	return ok
+}
+
+func (e *Entity) checkRpcRateOfMethod(limitMethod string, method string, clientid common.ClientID) bool {
+	limit, ok := e.typeDesc.rpcRateLimits[limitMethod]
+	if !ok {
+		return true
+	}
+
+	if e.rpcRateTrackers == nil {
+		e.rpcRateTrackers = map[rpcRateKey]*rpcRateTracker{}
+	}
+	key := rpcRateKey{clientid, limitMethod}
+	tracker := e.rpcRateTrackers[key]
+	now := time.Now()
+	if tracker == nil || now.Sub(tracker.windowStart) >= limit.per {
+		tracker = &rpcRateTracker{windowStart: now}
+		e.rpcRateTrackers[key] = tracker
+	}
+
+	tracker.calls += 1
+	if tracker.calls <= limit.maxCalls {
+		return true
+	}
+
+	if tracker.calls == limit.maxCalls+1 { // report at most once per window
+		e.onRpcRateLimited(method, clientid, limit)
+	}
+	return false
+}
+
+func (e *Entity) onRpcRateLimited(method string, clientid common.ClientID, limit rpcRateLimit) {
+	gwlog.Warn("%s: client %s called %s more than %d times in %s, action=%d", e, clientid, method, limit.maxCalls, limit.per, limit.action)
+	if rpcRateLimitCallback != nil {
+		gwutils.RunPanicless(func() {
+			rpcRateLimitCallback(e, method, clientid, limit.action)
+		})
+	}
+	if handler, ok := e.I.(IRpcRateLimitHandler); ok {
+		gwutils.RunPanicless(func() {
+			handler.OnRpcRateLimited(method, clientid, limit.action)
+		})
+	}
+
+	if limit.action == RPC_RATE_LIMIT_DROP {
+		return
+	}
+
+	owner := entityManager.get(entityManager.ownerOfClient[clientid])
+	if owner == nil || owner.client == nil || owner.client.clientid != clientid {
+		return
+	}
+	if limit.action == RPC_RATE_LIMIT_DISCONNECT {
+		owner.KickClient(proto.KICK_REASON_RATE_LIMITED, "too many calls of "+method)
+	} else if limit.action == RPC_RATE_LIMIT_BAN {
+		owner.client.Ban(consts.RPC_RATE_LIMIT_BAN_DURATION, "too many calls of "+method)
+	}
+}
@@ -0,0 +1,157 @@
+package entity
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sort"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Service strategies choose one of the service providers when calling services.
+//
+// The strategy can be set for each service by SetServiceStrategy, or selected when calling by
+// CallServiceWithStrategy. Services use RandomServiceStrategy by default.
+
+var (
+	// Choose service provider randomly
+	RandomServiceStrategy ServiceStrategy = randomServiceStrategy{}
+	// Choose service providers one by one
+	RoundRobinServiceStrategy ServiceStrategy = &roundRobinServiceStrategy{next: map[string]int{}}
+	// Choose the service provider on the game with the least load reported
+	LeastLoadedServiceStrategy ServiceStrategy = leastLoadedServiceStrategy{}
+	// Choose the same service provider for the same caller as long as providers are not changed
+	ConsistentHashServiceStrategy ServiceStrategy = consistentHashServiceStrategy{}
+
+	serviceStrategies = map[string]ServiceStrategy{}
+	gameLoads         = map[uint16]uint32{}
+)
+
+// Strategy of choosing service provider
+type ServiceStrategy interface {
+	// Choose one of the providers of the service for the caller entity, providers are sorted and never empty
+	//
+	// caller is empty if the service is not called by an entity
+	ChooseProvider(serviceName string, providers []EntityID, caller EntityID) EntityID
+}
+
+// Set the strategy of choosing provider when calling the service
+func SetServiceStrategy(serviceName string, strategy ServiceStrategy) {
+	if strategy == nil {
+		delete(serviceStrategies, serviceName)
+	} else {
+		serviceStrategies[serviceName] = strategy
+	}
+}
+
+// Get the strategy of choosing provider when calling the service
+func GetServiceStrategy(serviceName string) ServiceStrategy {
+	if strategy, ok := serviceStrategies[serviceName]; ok {
+		return strategy
+	}
+	return RandomServiceStrategy
+}
+
+// Get the game of the service provider
+func GetServiceProviderGame(eid EntityID) (uint16, bool) {
+	gameid, ok := entityManager.serviceGames[eid]
+	return gameid, ok
+}
+
+// Get the last reported load of the game, which is the number of entities on the game
+func GetGameLoad(gameid uint16) uint32 {
+	return gameLoads[gameid]
+}
+
+// Called by engine when the load of game is reported
+func OnGameLoadReport(gameid uint16, load uint32) {
+	gameLoads[gameid] = load
+}
+
+// Get the load of this game for reporting
+func GetLocalGameLoad() uint32 {
+	return uint32(len(entityManager.entities))
+}
+
+// Call the service with the specified strategy of choosing provider
+func (e *Entity) CallServiceWithStrategy(strategy ServiceStrategy, serviceName string, method string, args ...interface{}) {
+	serviceEid, err := entityManager.chooseServiceProviderWithStrategy(serviceName, e.ID, strategy)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	callEntity(serviceEid, method, args)
+}
+
+func (em *EntityManager) getServiceProviderList(serviceName string) []EntityID {
+	if providers, ok := em.serviceProviderLists[serviceName]; ok {
+		return providers
+	}
+
+	providers := em.registeredServices[serviceName].ToList()
+	sort.Slice(providers, func(i, j int) bool {
+		return providers[i] < providers[j]
+	})
+	em.serviceProviderLists[serviceName] = providers
+	return providers
+}
+
+type randomServiceStrategy struct{}
+
+func (randomServiceStrategy) ChooseProvider(serviceName string, providers []EntityID, caller EntityID) EntityID {
+	return providers[rand.Intn(len(providers))]
+}
+
+type roundRobinServiceStrategy struct {
+	next map[string]int
+}
+
+func (s *roundRobinServiceStrategy) ChooseProvider(serviceName string, providers []EntityID, caller EntityID) EntityID {
+	i := s.next[serviceName] % len(providers)
+	s.next[serviceName] = i + 1
+	return providers[i]
+}
+
+type leastLoadedServiceStrategy struct{}
+
+func (leastLoadedServiceStrategy) ChooseProvider(serviceName string, providers []EntityID, caller EntityID) EntityID {
+	var candidates []EntityID
+	var minLoad uint32
+	for _, eid := range providers {
+		var load uint32 // load of unknown game is considered to be 0
+		if gameid, ok := entityManager.serviceGames[eid]; ok {
+			load = gameLoads[gameid]
+		}
+
+		if candidates == nil || load < minLoad {
+			candidates = append(candidates[:0], eid)
+			minLoad = load
+		} else if load == minLoad {
+			candidates = append(candidates, eid)
+		}
+	}
+
+	// loads are reported periodically, so choose randomly among providers with the same load
+	return candidates[rand.Intn(len(candidates))]
+}
+
+type consistentHashServiceStrategy struct{}
+
+func (consistentHashServiceStrategy) ChooseProvider(serviceName string, providers []EntityID, caller EntityID) EntityID {
+	if caller == "" {
+		return RandomServiceStrategy.ChooseProvider(serviceName, providers, caller)
+	}
+
+	// rendezvous hashing: only callers of the removed provider are remapped when providers are changed
+	var chosen EntityID
+	var maxWeight uint64
+	for i, eid := range providers {
+		h := fnv.New64a()
+		h.Write([]byte(caller))
+		h.Write([]byte(eid))
+		if weight := h.Sum64(); i == 0 || weight > maxWeight {
+			chosen, maxWeight = eid, weight
+		}
+	}
+	return chosen
+}
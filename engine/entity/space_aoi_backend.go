@@ -0,0 +1,114 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// AOI backends of running spaces can be switched at runtime, e.g. from brute force to grid when a space is crowded.
+//
+// Switching rebuilds the AOI calculator with current positions of all entities, and neighbors are adjusted by the
+// new calculator, so interest sets are kept unchanged (all backends calculate the same nearby entities) and clients are not
+// affected. Spaces of a kind can switch backends automatically by entity count thresholds.
+
+// The AOI backend used by spaces with at least MinEntities entities
+type AOIBackendThreshold struct {
+	MinEntities int
+	Backend     string
+}
+
+var (
+	spaceKindAOIBackendThresholds = map[int][]AOIBackendThreshold{} // space kind -> thresholds in increasing order
+)
+
+// Set AOI backend thresholds of the space kind in order of increasing MinEntities, nil to disable auto switching
+//
+// Spaces switch to the backend of a higher threshold as soon as the entity count reaches it, and switch back when
+// the entity count drops below 3/4 of the current threshold, to avoid switching back and forth.
+func SetSpaceKindAOIBackendThresholds(kind int, thresholds []AOIBackendThreshold) {
+	if kind == 0 {
+		gwlog.Panicf("SetSpaceKindAOIBackendThresholds: nil space has no AOI")
+	}
+
+	for i, threshold := range thresholds {
+		if _, err := newAOICalculator(threshold.Backend); err != nil {
+			gwlog.Panic(err)
+		}
+		if i > 0 && threshold.MinEntities <= thresholds[i-1].MinEntities {
+			gwlog.Panicf("SetSpaceKindAOIBackendThresholds: thresholds should be in increasing order: %v", thresholds)
+		}
+	}
+
+	if len(thresholds) == 0 {
+		delete(spaceKindAOIBackendThresholds, kind)
+	} else {
+		spaceKindAOIBackendThresholds[kind] = append([]AOIBackendThreshold(nil), thresholds...)
+	}
+}
+
+// Get the AOI backend of space
+func (space *Space) GetAOIBackend() string {
+	return space.aoiBackend
+}
+
+// Switch the AOI backend of space, interest sets of entities are rebuilt transparently
+func (space *Space) SetAOIBackend(backend string) error {
+	if space.IsNil() {
+		return errors.Errorf("%s: nil space has no AOI", space)
+	}
+	if backend == space.aoiBackend {
+		return nil
+	}
+
+	aoiCalc, err := newAOICalculator(backend)
+	if err != nil {
+		return err
+	}
+
+	for e := range space.entities {
+		space.aoiCalc.Leave(&e.aoi)
+		aoiCalc.Enter(&e.aoi, e.aoi.pos)
+	}
+
+	oldBackend := space.aoiBackend
+	space.aoiCalc = aoiCalc
+	space.aoiBackend = backend
+
+	for e := range space.entities {
+		space.adjustAOI(e, true)
+	}
+
+	gwlog.Info("%s: AOI backend switched %s -> %s, entity count = %d", space, oldBackend, backend, len(space.entities))
+	return nil
+}
+
+// Switch AOI backend by thresholds of the space kind after entity count is changed
+func (space *Space) checkAOIBackendThresholds() {
+	thresholds := spaceKindAOIBackendThresholds[space.Kind]
+	if len(thresholds) == 0 {
+		return
+	}
+
+	count := len(space.entities)
+	current := -1
+	target := -1
+	for i, threshold := range thresholds {
+		if threshold.Backend == space.aoiBackend {
+			current = i
+		}
+		if count >= threshold.MinEntities {
+			target = i
+		}
+	}
+
+	if target < current && count*4 >= thresholds[current].MinEntities*3 {
+		return // not low enough for switching back
+	}
+	if target < 0 || target == current {
+		return
+	}
+
+	if err := space.SetAOIBackend(thresholds[target].Backend); err != nil {
+		gwlog.Error("%s: switch AOI backend failed: %s", space, err)
+	}
+}
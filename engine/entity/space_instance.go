@@ -0,0 +1,153 @@
+package entity
+
+import (
+	"sort"
+	"time"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Space kinds with population caps are instanced: when all spaces of the kind are full, an overflow instance of
+// the same kind is created and players entering the kind are routed there.
+//
+// Instances are chosen among spaces on the local game, so each game maintains its own instances of the kind.
+
+var (
+	spaceKindMaxPlayers = map[int]int{} // space kind -> max player count per space
+)
+
+type spaceInstanceInfo struct {
+	reservations map[EntityID]time.Time // players chose this space and are still entering -> choose time
+}
+
+// Set the max player count of each space of the kind, 0 means no limit
+func SetSpaceKindMaxPlayers(kind int, maxPlayers int) {
+	if kind == 0 {
+		gwlog.Panicf("SetSpaceKindMaxPlayers: nil space can not be instanced")
+	}
+	if maxPlayers < 0 {
+		gwlog.Panicf("SetSpaceKindMaxPlayers: invalid max players: %d", maxPlayers)
+	}
+
+	if maxPlayers == 0 {
+		delete(spaceKindMaxPlayers, kind)
+	} else {
+		spaceKindMaxPlayers[kind] = maxPlayers
+	}
+}
+
+// Get the max player count of each space of the kind, 0 means no limit
+func GetSpaceKindMaxPlayers(kind int) int {
+	return spaceKindMaxPlayers[kind]
+}
+
+// Get all local spaces of the kind, ordered by space ID
+func GetSpaceInstances(kind int) []*Space {
+	var spaces []*Space
+	for _, space := range spaceManager.spaces {
+		if space.Kind == kind && !space.IsDestroyed() {
+			spaces = append(spaces, space)
+		}
+	}
+	sort.Slice(spaces, func(i, j int) bool {
+		return spaces[i].ID < spaces[j].ID
+	})
+	return spaces
+}
+
+// Get other local spaces of the same kind, ordered by space ID
+func (space *Space) GetSiblingInstances() []*Space {
+	instances := GetSpaceInstances(space.Kind)
+	siblings := instances[:0]
+	for _, instance := range instances {
+		if instance != space {
+			siblings = append(siblings, instance)
+		}
+	}
+	return siblings
+}
+
+// Get the number of players (entities with clients) in space, including players still entering the space
+func (space *Space) GetPlayerCount() int {
+	count := 0
+	for e := range space.entities {
+		if e.client != nil {
+			count += 1
+		}
+	}
+
+	if space.instance != nil {
+		now := time.Now()
+		for eid, t := range space.instance.reservations {
+			if now.Sub(t) > consts.ENTER_SPACE_REQUEST_TIMEOUT {
+				delete(space.instance.reservations, eid) // entering failed
+			} else {
+				count += 1
+			}
+		}
+	}
+	return count
+}
+
+// Check if the space reaches the max player count of its kind
+func (space *Space) IsFull() bool {
+	maxPlayers := spaceKindMaxPlayers[space.Kind]
+	return maxPlayers > 0 && space.GetPlayerCount() >= maxPlayers
+}
+
+// Enter a space of the kind which is not full, an overflow instance is created locally if all spaces of the kind are full
+func (e *Entity) EnterSpaceOfKind(kind int, pos Position) {
+	if e.isEnteringSpace() {
+		gwlog.Error("%s is entering space %s, can not enter space of kind %d", e, e.enteringSpaceRequest.SpaceID, kind)
+		return
+	}
+
+	space := chooseSpaceInstance(kind, e.Space)
+	if space == nil {
+		space = spaceManager.getSpace(CreateSpaceLocally(kind))
+		if consts.DEBUG_SPACES {
+			gwlog.Debug("%s.EnterSpaceOfKind: all spaces of kind %d are full, created instance %s", e, kind, space)
+		}
+	}
+
+	space.reservePlayer(e.ID)
+	e.EnterSpace(space.ID, pos)
+}
+
+// choose the most crowded space of the kind which is not full, so that players are not spread over instances
+func chooseSpaceInstance(kind int, exclude *Space) *Space {
+	var best *Space
+	bestCount := 0
+	maxPlayers := spaceKindMaxPlayers[kind]
+
+	for _, space := range GetSpaceInstances(kind) {
+		if space == exclude || space.IsReplaying() {
+			continue
+		}
+		count := space.GetPlayerCount()
+		if maxPlayers > 0 && count >= maxPlayers {
+			continue
+		}
+		if best == nil || count > bestCount {
+			best, bestCount = space, count
+		}
+	}
+	return best
+}
+
+func (space *Space) reservePlayer(eid EntityID) {
+	if space.instance == nil {
+		space.instance = &spaceInstanceInfo{
+			reservations: map[EntityID]time.Time{},
+		}
+	}
+	space.instance.reservations[eid] = time.Now()
+}
+
+func (space *Space) onPlayerEntered(entity *Entity) {
+	if space.instance != nil {
+		delete(space.instance.reservations, entity.ID)
+	}
+}
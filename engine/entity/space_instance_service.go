@@ -0,0 +1,306 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	timer "github.com/xiaonanln/goTimer"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// Space instance service creates, pools and recycles space instances by kind for requesters, e.g. dungeon runs.
+//
+// Each request acquires an instance exclusively: an idle instance of the kind in the pool is reused, otherwise a new
+// instance is created on any game if the kind has not reached its max instances. Spaces report their player counts
+// to the service, and instances without players for TTL are recycled to the pool, or destroyed if the pool is full.
+
+const (
+	SPACE_INSTANCE_SERVICE_TYPE = "__space_instance__"
+	SPACE_INSTANCE_SERVICE_NAME = "__space_instance__"
+
+	SPACE_INSTANCE_ATTR_KEY = "_SI" // set on spaces managed by the space instance service
+)
+
+// Policy of instances of a space kind managed by the space instance service
+type SpaceInstancePolicy struct {
+	MaxInstances int           // max instances of the kind in the cluster, including pooled ones, 0 means no limit
+	PoolSize     int           // max idle instances of the kind kept for reuse
+	TTL          time.Duration // instances are recycled after the last player leaves for TTL
+}
+
+// Optional interface for spaces to reset states when recycled to the pool, e.g. respawning monsters
+type ISpaceInstanceRecycleHandler interface {
+	OnSpaceInstanceRecycled()
+}
+
+// Callback of space instance requests, err is not nil if the request fails
+type SpaceInstanceCallback func(spaceID EntityID, err error)
+
+type pendingSpaceInstanceRequest struct {
+	callback     SpaceInstanceCallback
+	timeoutTimer *timer.Timer
+}
+
+var (
+	spaceInstancePolicies        = map[int]SpaceInstancePolicy{}
+	lastSpaceInstanceReqID       uint32
+	pendingSpaceInstanceRequests = map[uint32]*pendingSpaceInstanceRequest{}
+)
+
+type spaceInstanceState struct {
+	kind       int
+	inUse      bool
+	players    int
+	emptySince time.Time // when the last player left, or the instance is acquired
+}
+
+// The space instance service entity, created by CreateSpaceInstanceServiceAnywhere
+type SpaceInstanceService struct {
+	Entity
+
+	instances map[EntityID]*spaceInstanceState
+	pools     map[int][]EntityID // idle instances of each kind
+	creating  map[int]int        // number of instances of each kind being created
+}
+
+// Register the space instance service type
+//
+// Should be called on all games before running
+func RegisterSpaceInstanceService() {
+	RegisterEntity(SPACE_INSTANCE_SERVICE_TYPE, &SpaceInstanceService{})
+}
+
+// Create the space instance service on any game, should be called only once in the cluster
+func CreateSpaceInstanceServiceAnywhere() {
+	createEntityAnywhere(SPACE_INSTANCE_SERVICE_TYPE, nil)
+}
+
+// Set the policy of instances of the space kind, only kinds with policies can be requested
+//
+// Should be called on all games before running
+func SetSpaceInstancePolicy(kind int, policy SpaceInstancePolicy) {
+	if kind == 0 {
+		gwlog.Panicf("SetSpaceInstancePolicy: nil space can not be instanced")
+	}
+	if policy.MaxInstances < 0 || policy.PoolSize < 0 || policy.TTL < 0 {
+		gwlog.Panicf("SetSpaceInstancePolicy: invalid policy of kind %d: %+v", kind, policy)
+	}
+	spaceInstancePolicies[kind] = policy
+}
+
+// Check if the space instance service is ready
+func IsSpaceInstanceServiceReady() bool {
+	return len(GetServiceProviders(SPACE_INSTANCE_SERVICE_NAME)) > 0
+}
+
+func callSpaceInstanceService(method string, args ...interface{}) {
+	serviceEid, err := entityManager.chooseServiceProvider(SPACE_INSTANCE_SERVICE_NAME, "")
+	if err != nil {
+		gwlog.Error("call space instance service %s failed: %s", method, err)
+		return
+	}
+	callEntity(serviceEid, method, args)
+}
+
+func (s *SpaceInstanceService) OnInit() {
+	s.instances = map[EntityID]*spaceInstanceState{}
+	s.pools = map[int][]EntityID{}
+	s.creating = map[int]int{}
+}
+
+func (s *SpaceInstanceService) OnCreated() {
+	gwlog.Info("Registering space instance service ...")
+	s.DeclareService(SPACE_INSTANCE_SERVICE_NAME)
+	s.addRawTimer(consts.SPACE_INSTANCE_CHECK_INTERVAL, s.recycleIdleInstances)
+}
+
+// Acquire an instance of the kind for the request of requester entity
+func (s *SpaceInstanceService) Acquire(kind int, requester EntityID, reqid uint32) {
+	policy, ok := spaceInstancePolicies[kind]
+	if !ok {
+		s.Call(requester, "SpaceInstanceFromService", reqid, EntityID(""), "no policy of space kind")
+		return
+	}
+
+	if pool := s.pools[kind]; len(pool) > 0 {
+		spaceID := pool[len(pool)-1]
+		s.pools[kind] = pool[:len(pool)-1]
+		s.useInstance(s.instances[spaceID])
+		s.Call(requester, "SpaceInstanceFromService", reqid, spaceID, "")
+		return
+	}
+
+	if policy.MaxInstances > 0 && s.countInstances(kind)+s.creating[kind] >= policy.MaxInstances {
+		s.Call(requester, "SpaceInstanceFromService", reqid, EntityID(""), "too many instances of space kind")
+		return
+	}
+
+	s.creating[kind] += 1
+	CreateEntityAnywhereWithCallback(SPACE_ENTITY_TYPE, map[string]interface{}{
+		SPACE_KIND_ATTR_KEY:     kind,
+		SPACE_INSTANCE_ATTR_KEY: 1,
+	}, func(spaceID EntityID, err error) {
+		if s.IsDestroyed() {
+			return
+		}
+		s.creating[kind] -= 1
+		if err != nil {
+			s.Call(requester, "SpaceInstanceFromService", reqid, EntityID(""), err.Error())
+			return
+		}
+
+		state := &spaceInstanceState{kind: kind}
+		s.instances[spaceID] = state
+		s.useInstance(state)
+		gwlog.Info("%s: created instance %s of space kind %d", s, spaceID, kind)
+		s.Call(requester, "SpaceInstanceFromService", reqid, spaceID, "")
+	})
+}
+
+// Release the instance immediately instead of waiting for TTL after the last player leaves
+func (s *SpaceInstanceService) Release(spaceID EntityID) {
+	if state := s.instances[spaceID]; state != nil && state.inUse {
+		s.recycleInstance(spaceID, state)
+	}
+}
+
+// Called by spaces when player counts change
+func (s *SpaceInstanceService) SpaceInstancePlayers(spaceID EntityID, players int) {
+	state := s.instances[spaceID]
+	if state == nil {
+		return
+	}
+	if players == 0 && state.players > 0 {
+		state.emptySince = time.Now()
+	}
+	state.players = players
+}
+
+// Called by spaces when destroyed
+func (s *SpaceInstanceService) SpaceInstanceDestroyed(spaceID EntityID) {
+	state := s.instances[spaceID]
+	if state == nil {
+		return
+	}
+	delete(s.instances, spaceID)
+
+	pool := s.pools[state.kind]
+	for i, id := range pool {
+		if id == spaceID {
+			s.pools[state.kind] = append(pool[:i], pool[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *SpaceInstanceService) useInstance(state *spaceInstanceState) {
+	state.inUse = true
+	state.emptySince = time.Now() // recycled if no player enters in TTL
+}
+
+func (s *SpaceInstanceService) countInstances(kind int) int {
+	count := 0
+	for _, state := range s.instances {
+		if state.kind == kind {
+			count += 1
+		}
+	}
+	return count
+}
+
+func (s *SpaceInstanceService) recycleIdleInstances() {
+	now := time.Now()
+	for spaceID, state := range s.instances {
+		if state.inUse && state.players == 0 && now.Sub(state.emptySince) >= spaceInstancePolicies[state.kind].TTL {
+			s.recycleInstance(spaceID, state)
+		}
+	}
+}
+
+func (s *SpaceInstanceService) recycleInstance(spaceID EntityID, state *spaceInstanceState) {
+	state.inUse = false
+	if len(s.pools[state.kind]) < spaceInstancePolicies[state.kind].PoolSize {
+		s.pools[state.kind] = append(s.pools[state.kind], spaceID)
+		s.Call(spaceID, "RecycleFromSpaceInstanceService")
+	} else {
+		delete(s.instances, spaceID)
+		s.Call(spaceID, "DestroyFromSpaceInstanceService")
+	}
+}
+
+// Request an instance of the space kind from the space instance service
+//
+// The callback is called on this game with the ID of the instance, which is exclusive to this request until it is
+// recycled after all players leave. The callback is dropped if the entity migrates before the reply.
+func (e *Entity) RequestSpaceInstance(kind int, callback SpaceInstanceCallback) {
+	lastSpaceInstanceReqID += 1
+	reqid := lastSpaceInstanceReqID
+
+	pending := &pendingSpaceInstanceRequest{callback: callback}
+	pending.timeoutTimer = timer.AddCallback(consts.SPACE_INSTANCE_REQUEST_TIMEOUT, func() {
+		if pendingSpaceInstanceRequests[reqid] != pending {
+			return
+		}
+		delete(pendingSpaceInstanceRequests, reqid)
+		gwutils.RunPanicless(func() {
+			callback("", errors.Errorf("request instance of space kind %d timeout", kind))
+		})
+	})
+	pendingSpaceInstanceRequests[reqid] = pending
+	e.CallService(SPACE_INSTANCE_SERVICE_NAME, "Acquire", kind, e.ID, reqid)
+}
+
+// Release the space instance acquired by RequestSpaceInstance immediately
+func ReleaseSpaceInstance(spaceID EntityID) {
+	callSpaceInstanceService("Release", spaceID)
+}
+
+// Called by space instance service when the instance is acquired or failed
+func (e *Entity) SpaceInstanceFromService(reqid uint32, spaceID EntityID, errmsg string) {
+	pending := pendingSpaceInstanceRequests[reqid]
+	if pending == nil {
+		gwlog.Warn("%s: space instance request %d is timeout or lost, instance %s is recycled later", e, reqid, spaceID)
+		return
+	}
+	delete(pendingSpaceInstanceRequests, reqid)
+	pending.timeoutTimer.Cancel()
+
+	var err error
+	if errmsg != "" {
+		err = errors.New(errmsg)
+	}
+	gwutils.RunPanicless(func() {
+		pending.callback(spaceID, err)
+	})
+}
+
+func (space *Space) isServiceInstance() bool {
+	return space.GetInt(SPACE_INSTANCE_ATTR_KEY) != 0
+}
+
+// report the player count to space instance service if changed
+func (space *Space) reportInstancePlayers() {
+	players := space.GetPlayerCount()
+	if players == space.reportedPlayers {
+		return
+	}
+	space.reportedPlayers = players
+	callSpaceInstanceService("SpaceInstancePlayers", space.ID, players)
+}
+
+// Called by space instance service when the instance is recycled to the pool
+func (space *Space) RecycleFromSpaceInstanceService() {
+	gwlog.Info("%s: recycled by space instance service", space)
+	if handler, ok := space.I.(ISpaceInstanceRecycleHandler); ok {
+		gwutils.RunPanicless(handler.OnSpaceInstanceRecycled)
+	}
+}
+
+// Called by space instance service when the instance is not needed any more
+func (space *Space) DestroyFromSpaceInstanceService() {
+	gwlog.Info("%s: destroyed by space instance service", space)
+	space.Destroy()
+}
@@ -0,0 +1,64 @@
+package entity
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/nav"
+)
+
+// Navmeshes of space kinds for server-authoritative movement, all spaces of the kind share the navmesh
+//
+// Navmesh coordinates are the same as space positions, see package nav for supported formats.
+
+var (
+	spaceKindNavMeshes = map[int]*nav.NavMesh{}
+)
+
+// Load the navmesh of space kind from file in Recast/Detour binary formats
+func LoadSpaceKindNavMesh(kind int, path string) error {
+	mesh, err := nav.Load(path)
+	if err != nil {
+		return err
+	}
+	SetSpaceKindNavMesh(kind, mesh)
+	gwlog.Info("Loaded navmesh of space kind %d from %s: %d polygons", kind, path, mesh.PolyCount())
+	return nil
+}
+
+// Set the navmesh of space kind, nil to remove
+func SetSpaceKindNavMesh(kind int, mesh *nav.NavMesh) {
+	if kind == 0 {
+		gwlog.Panicf("SetSpaceKindNavMesh: nil space can not have navmesh")
+	}
+
+	if mesh == nil {
+		delete(spaceKindNavMeshes, kind)
+	} else {
+		spaceKindNavMeshes[kind] = mesh
+	}
+}
+
+// Get the navmesh of space, returns nil if the space kind has no navmesh
+func (space *Space) GetNavMesh() *nav.NavMesh {
+	return spaceKindNavMeshes[space.Kind]
+}
+
+// Find the path from one position to another on the navmesh of space, both ends are snapped to the navmesh
+func (space *Space) FindPath(from Position, to Position) ([]Position, error) {
+	mesh := space.GetNavMesh()
+	if mesh == nil {
+		return nil, errors.Errorf("%s has no navmesh", space)
+	}
+
+	points, err := mesh.FindPath(nav.Point{X: float32(from.X), Y: float32(from.Y), Z: float32(from.Z)},
+		nav.Point{X: float32(to.X), Y: float32(to.Y), Z: float32(to.Z)})
+	if err != nil {
+		return nil, err
+	}
+
+	path := make([]Position, len(points))
+	for i, p := range points {
+		path[i] = Position{Coord(p.X), Coord(p.Y), Coord(p.Z)}
+	}
+	return path, nil
+}
@@ -0,0 +1,90 @@
+package entity
+
+import (
+	"math"
+	"sort"
+)
+
+// Spatial queries of entities in space, e.g. targets of skills and aggro checks
+//
+// Queries use the spatial index of AOI backend, so the cost depends on entities around the query area rather than all
+// entities in space. Like AOI, distances are measured on the X-Z plane and Y is ignored.
+
+// Get entities in space within radius of pos on the X-Z plane, typeName can be empty for entities of any type
+func (space *Space) EntitiesInRange(pos Position, radius Coord, typeName string) EntitySet {
+	entities := EntitySet{}
+	if space.IsNil() {
+		return entities
+	}
+
+	space.aoiCalc.VisitRect(pos.X-radius, pos.X+radius, pos.Z-radius, pos.Z+radius, func(aoi *AOI) {
+		e := aoi.getEntity()
+		if typeName != "" && e.TypeName != typeName {
+			return
+		}
+		dx, dz := aoi.pos.X-pos.X, aoi.pos.Z-pos.Z
+		if dx*dx+dz*dz <= radius*radius {
+			entities.Add(e)
+		}
+	})
+	return entities
+}
+
+// Get entities in space within radius of the segment from start to end on the X-Z plane, in order of distance from
+// start along the segment, typeName can be empty for entities of any type
+//
+// e.g. Raycast(pos, end, 0.5, "Monster") returns monsters in the way of a bullet with radius of 0.5
+func (space *Space) Raycast(start Position, end Position, radius Coord, typeName string) []*Entity {
+	if space.IsNil() {
+		return nil
+	}
+
+	type raycastHit struct {
+		entity *Entity
+		t      Coord // projection on segment, from 0 to 1
+	}
+
+	dx, dz := end.X-start.X, end.Z-start.Z
+	lenSquare := dx*dx + dz*dz
+	var hits []raycastHit
+
+	minX, maxX := start.X, end.X
+	if minX > maxX {
+		minX, maxX = maxX, minX
+	}
+	minZ, maxZ := start.Z, end.Z
+	if minZ > maxZ {
+		minZ, maxZ = maxZ, minZ
+	}
+
+	space.aoiCalc.VisitRect(minX-radius, maxX+radius, minZ-radius, maxZ+radius, func(aoi *AOI) {
+		e := aoi.getEntity()
+		if typeName != "" && e.TypeName != typeName {
+			return
+		}
+
+		// the nearest point on segment
+		var t Coord
+		if lenSquare > 0 {
+			t = ((aoi.pos.X-start.X)*dx + (aoi.pos.Z-start.Z)*dz) / lenSquare
+			t = Coord(math.Max(0, math.Min(1, float64(t))))
+		}
+		px, pz := aoi.pos.X-(start.X+t*dx), aoi.pos.Z-(start.Z+t*dz)
+		if px*px+pz*pz <= radius*radius {
+			hits = append(hits, raycastHit{e, t})
+		}
+	})
+
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].t != hits[j].t {
+			return hits[i].t < hits[j].t
+		}
+		return hits[i].entity.ID < hits[j].entity.ID
+	})
+
+	entities := make([]*Entity, len(hits))
+	for i, hit := range hits {
+		entities[i] = hit.entity
+	}
+	return entities
+}
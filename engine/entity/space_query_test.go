@@ -0,0 +1,110 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+type testQueryEntity struct {
+	id       common.EntityID
+	typeName string
+	pos      Position
+}
+
+func newTestQuerySpace(t *testing.T, backend string, entities []testQueryEntity) *Space {
+	space := newTestAOISpace(t, backend)
+	for i, qe := range entities {
+		e := testAOIEnter(space, i, qe.pos, DEFAULT_AOI_DISTANCE)
+		e.ID = qe.id
+		e.TypeName = qe.typeName
+	}
+	return space
+}
+
+func TestSpace_EntitiesInRange(t *testing.T) {
+	entities := []testQueryEntity{
+		{"a", "Monster", Position{0, 50, 0}}, // Y is ignored
+		{"b", "Monster", Position{3, 0, 4}},
+		{"c", "Player", Position{-3, 0, -4}},
+		{"d", "Monster", Position{5, 0, 5}}, // in the bounding rect of radius 7, but not in range
+		{"e", "Monster", Position{6, 0, 8}},
+	}
+
+	tests := []struct {
+		name     string
+		pos      Position
+		radius   Coord
+		typeName string
+		expected []common.EntityID
+	}{
+		{"on boundary", Position{0, 0, 0}, 5, "", []common.EntityID{"a", "b", "c"}},
+		{"inside boundary", Position{0, 0, 0}, 4.9, "", []common.EntityID{"a"}},
+		{"zero radius", Position{0, 0, 0}, 0, "", []common.EntityID{"a"}},
+		{"type", Position{0, 0, 0}, 5, "Monster", []common.EntityID{"a", "b"}},
+		{"rect corner", Position{0, 0, 0}, 7, "", []common.EntityID{"a", "b", "c"}},
+		{"beyond rect corner", Position{0, 0, 0}, 7.1, "", []common.EntityID{"a", "b", "c", "d"}},
+		{"off center", Position{10, 0, 10}, 5, "", []common.EntityID{"e"}},
+		{"far away", Position{100, 0, 100}, 5, "", []common.EntityID{}},
+	}
+
+	for _, backend := range testAOIBackends {
+		space := newTestQuerySpace(t, backend, entities)
+		for _, test := range tests {
+			result := sortedEntityIDs(space.EntitiesInRange(test.pos, test.radius, test.typeName))
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("%s: %s: EntitiesInRange returns %v, should be %v", backend, test.name, result, test.expected)
+			}
+		}
+	}
+
+	if len((&Space{}).EntitiesInRange(Position{}, 100, "")) != 0 {
+		t.Errorf("nil space should have no entities in range")
+	}
+}
+
+func TestSpace_Raycast(t *testing.T) {
+	entities := []testQueryEntity{
+		{"m1", "Monster", Position{0, 0, 5}},   // on the ray
+		{"m2", "Monster", Position{1, 0, -5}},  // grazes the ray of radius 1
+		{"m3", "Monster", Position{1.5, 0, 0}}, // beside the ray
+		{"m4", "Monster", Position{0, 0, 12}},  // beyond the end
+		{"m5", "Monster", Position{0, 0, -11}}, // grazes the start
+		{"p", "Player", Position{0, 0, 0}},
+	}
+
+	tests := []struct {
+		name       string
+		start, end Position
+		radius     Coord
+		typeName   string
+		expected   []common.EntityID
+	}{
+		{"pass through and graze", Position{0, 0, -10}, Position{0, 0, 10}, 1, "", []common.EntityID{"m5", "m2", "p", "m1"}},
+		{"narrow", Position{0, 0, -10}, Position{0, 0, 10}, 0.5, "", []common.EntityID{"p", "m1"}},
+		{"reversed", Position{0, 0, 10}, Position{0, 0, -10}, 1, "", []common.EntityID{"m1", "p", "m2", "m5"}},
+		{"type", Position{0, 0, -10}, Position{0, 0, 10}, 1, "Monster", []common.EntityID{"m5", "m2", "m1"}},
+		{"end on entity", Position{0, 0, -10}, Position{0, 0, 12}, 1, "", []common.EntityID{"m5", "m2", "p", "m1", "m4"}},
+		{"miss", Position{5, 0, -10}, Position{5, 0, 10}, 1, "", []common.EntityID{}},
+		{"diagonal", Position{-10, 0, -10}, Position{10, 0, 10}, 1, "", []common.EntityID{"p"}},
+		{"zero length", Position{1.5, 0, 0}, Position{1.5, 0, 0}, 1.5, "", []common.EntityID{"m3", "p"}},
+	}
+
+	for _, backend := range testAOIBackends {
+		space := newTestQuerySpace(t, backend, entities)
+		for _, test := range tests {
+			result := []common.EntityID{}
+			for _, e := range space.Raycast(test.start, test.end, test.radius, test.typeName) {
+				result = append(result, e.ID)
+			}
+			if !reflect.DeepEqual(result, test.expected) {
+				t.Errorf("%s: %s: Raycast returns %v, should be %v", backend, test.name, result, test.expected)
+			}
+		}
+	}
+
+	if (&Space{}).Raycast(Position{}, Position{0, 0, 10}, 1, "") != nil {
+		t.Errorf("nil space should have no entities in the way")
+	}
+}
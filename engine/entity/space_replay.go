@@ -0,0 +1,269 @@
+package entity
+
+import (
+	"math/rand"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/tracing"
+)
+
+// Replayable spaces record all inputs (client RPCs, client position syncs, RNG seed and ticks)
+// so that a match can be re-simulated on server for dispute resolution and cheat investigation.
+//
+// Client inputs to entities in replayable spaces are not executed immediately, they are queued and
+// executed at the beginning of the next space tick, so that the execution order is fully determined
+// by the record.
+
+var (
+	replayableSpaceKinds = map[int]time.Duration{} // space kind -> tick interval
+	pendingReplayRecord  *ReplayRecord             // record to replay for the space being created
+	replayPacker         = netutil.MessagePackMsgPacker{}
+)
+
+// Recorded entity entering the space from outside (migrate in or enter from local space)
+type ReplayEnter struct {
+	Tick     uint64
+	EntityID EntityID
+	TypeName string
+	Pos      Position
+	Data     map[string]interface{}
+}
+
+// Recorded client input, Method is empty for position syncs
+type ReplayInput struct {
+	Tick     uint64
+	EntityID EntityID
+	Method   string
+	Args     [][]byte
+	Pos      Position
+	Yaw      Yaw
+	clientid ClientID
+}
+
+// All recorded data of a replayable space
+type ReplayRecord struct {
+	SpaceID      EntityID
+	Kind         int
+	Seed         int64
+	TickInterval time.Duration
+	LastTick     uint64
+	Enters       []*ReplayEnter
+	Inputs       []*ReplayInput
+}
+
+type spaceReplay struct {
+	record    *ReplayRecord
+	replaying bool
+	finished  bool
+	tick      uint64
+	rand      *rand.Rand
+	tickTimer *timer.Timer
+
+	pendingInputs []*ReplayInput        // live mode: client inputs waiting for next tick
+	nextEnter     int                   // replay mode: index of next enter to apply
+	nextInput     int                   // replay mode: index of next input to apply
+	idMap         map[EntityID]EntityID // replay mode: recorded entity ID -> replayed entity ID
+}
+
+// Register the space kind as replayable, spaces of this kind are ticked every tickInterval
+func RegisterReplayableSpaceKind(kind int, tickInterval time.Duration) {
+	if kind == 0 {
+		gwlog.Panicf("RegisterReplayableSpaceKind: nil space can not be replayable")
+	}
+	if tickInterval < time.Millisecond*10 { // minimal interval for repeat timer
+		tickInterval = time.Millisecond * 10
+	}
+	replayableSpaceKinds[kind] = tickInterval
+}
+
+// Create a space locally to re-simulate the record
+//
+// Entities entered in the recorded space are re-created locally with new IDs and no clients
+func CreateReplaySpaceLocally(record *ReplayRecord) EntityID {
+	pendingReplayRecord = record
+	defer func() {
+		pendingReplayRecord = nil
+	}()
+	return CreateSpaceLocally(record.Kind)
+}
+
+// Pack the replay record to bytes for saving
+func PackReplayRecord(record *ReplayRecord) ([]byte, error) {
+	return replayPacker.PackMsg(record, nil)
+}
+
+// Unpack the replay record from bytes
+func UnpackReplayRecord(data []byte) (*ReplayRecord, error) {
+	var record ReplayRecord
+	if err := replayPacker.UnpackMsg(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+func (space *Space) initReplay() {
+	if pendingReplayRecord != nil {
+		record := pendingReplayRecord
+		space.replay = &spaceReplay{
+			record:    record,
+			replaying: true,
+			rand:      rand.New(rand.NewSource(record.Seed)),
+			idMap:     map[EntityID]EntityID{record.SpaceID: space.ID},
+		}
+		space.replay.tickTimer = space.addRawTimer(record.TickInterval, space.onReplayTick)
+		gwlog.Info("%s: replaying record of space %s: %d ticks, %d enters, %d inputs", space, record.SpaceID, record.LastTick, len(record.Enters), len(record.Inputs))
+		return
+	}
+
+	tickInterval, ok := replayableSpaceKinds[space.Kind]
+	if !ok {
+		return
+	}
+
+	seed := time.Now().UnixNano()
+	space.replay = &spaceReplay{
+		record: &ReplayRecord{
+			SpaceID:      space.ID,
+			Kind:         space.Kind,
+			Seed:         seed,
+			TickInterval: tickInterval,
+		},
+		rand: rand.New(rand.NewSource(seed)),
+	}
+	space.replay.tickTimer = space.addRawTimer(tickInterval, space.onReplayTick)
+}
+
+// Check if the space records inputs for replay
+func (space *Space) IsReplayable() bool {
+	return space.replay != nil
+}
+
+// Check if the space is re-simulating a record
+func (space *Space) IsReplaying() bool {
+	return space.replay != nil && space.replay.replaying
+}
+
+// Check if the space has finished re-simulating the record
+func (space *Space) IsReplayFinished() bool {
+	return space.replay != nil && space.replay.finished
+}
+
+// Get the current tick of replayable space
+func (space *Space) GetReplayTick() uint64 {
+	if space.replay == nil {
+		return 0
+	}
+	return space.replay.tick
+}
+
+// Get the deterministic random number generator of replayable space
+//
+// All randomness in replayable spaces should come from this generator
+func (space *Space) Rand() *rand.Rand {
+	if space.replay == nil {
+		gwlog.Panicf("%s.Rand: space is not replayable", space)
+	}
+	return space.replay.rand
+}
+
+// Get the record of replayable space
+func (space *Space) GetReplayRecord() *ReplayRecord {
+	if space.replay == nil {
+		return nil
+	}
+	return space.replay.record
+}
+
+func (space *Space) isRecordingReplay() bool {
+	return space != nil && space.replay != nil && !space.replay.replaying
+}
+
+func (space *Space) recordReplayEnter(entity *Entity, pos Position) {
+	if !space.isRecordingReplay() {
+		return
+	}
+
+	space.replay.record.Enters = append(space.replay.record.Enters, &ReplayEnter{
+		Tick:     space.replay.tick,
+		EntityID: entity.ID,
+		TypeName: entity.TypeName,
+		Pos:      pos,
+		Data:     entity.I.GetMigrateData(),
+	})
+}
+
+func (space *Space) queueReplayInput(input *ReplayInput) {
+	space.replay.pendingInputs = append(space.replay.pendingInputs, input)
+}
+
+func (space *Space) onReplayTick() {
+	replay := space.replay
+	replay.tick += 1
+
+	if replay.replaying {
+		space.applyReplayRecord()
+	} else {
+		inputs := replay.pendingInputs
+		replay.pendingInputs = nil
+		for _, input := range inputs {
+			input.Tick = replay.tick
+			replay.record.Inputs = append(replay.record.Inputs, input)
+			space.applyReplayInput(input.EntityID, input, input.clientid)
+		}
+		replay.record.LastTick = replay.tick
+	}
+
+	if rs, ok := space.I.(IReplayableSpace); ok {
+		gwutils.RunPanicless(func() {
+			rs.OnReplayTick(replay.tick)
+		})
+	}
+}
+
+func (space *Space) applyReplayRecord() {
+	replay := space.replay
+	record := replay.record
+
+	if replay.tick > record.LastTick {
+		replay.finished = true
+		space.cancelRawTimer(replay.tickTimer)
+		gwlog.Info("%s: replay finished at tick %d", space, record.LastTick)
+		return
+	}
+
+	// entities entered during the last tick interval
+	for ; replay.nextEnter < len(record.Enters) && record.Enters[replay.nextEnter].Tick < replay.tick; replay.nextEnter++ {
+		enter := record.Enters[replay.nextEnter]
+		replay.idMap[enter.EntityID] = createEntity(enter.TypeName, space, enter.Pos, "", enter.Data, nil, nil, ccMigrate)
+	}
+
+	for ; replay.nextInput < len(record.Inputs) && record.Inputs[replay.nextInput].Tick <= replay.tick; replay.nextInput++ {
+		input := record.Inputs[replay.nextInput]
+		eid, ok := replay.idMap[input.EntityID]
+		if !ok {
+			gwlog.Warn("%s: replay input to unknown entity %s at tick %d", space, input.EntityID, input.Tick)
+			continue
+		}
+		// client RPCs are also server RPCs, so replay them as called from server
+		space.applyReplayInput(eid, input, "")
+	}
+}
+
+func (space *Space) applyReplayInput(eid EntityID, input *ReplayInput, clientid ClientID) {
+	e := entityManager.get(eid)
+	if e == nil || e.Space != space {
+		// entity destroyed or left space before tick
+		return
+	}
+
+	if input.Method == "" {
+		e.setPositionYaw(input.Pos, input.Yaw, true)
+	} else {
+		e.onCallFromRemote(input.Method, input.Args, clientid, tracing.SpanContext{})
+	}
+}
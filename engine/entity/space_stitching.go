@@ -0,0 +1,346 @@
+package entity
+
+import (
+	"reflect"
+	"time"
+
+	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Space stitching links spaces edge-to-edge to build a seamless world, the spaces can be on different games
+//
+// Stitched spaces share the world coordinates, and each space covers a region of the world. Entities moving out of the
+// region of their space migrate to the stitched space whose region contains the new position. Entities within the
+// ghost distance of a neighbor region are ghosted to the neighbor space periodically, so that players near the border
+// see entities on the other side.
+//
+// Ghosts are read-only copies which only live in AOI: they are not managed by entity manager, have no timers or
+// clients, and must not be destroyed or moved by game logic. Use IsGhost to tell ghosts from real entities when
+// iterating neighbors or query results, and calls to ghost IDs are routed to the real entities. Positions and yaws of
+// ghosts are kept in sync, but client attrs are snapshots taken when entities are ghosted to the neighbor.
+
+const (
+	_STITCH_GHOST_SYNC_INTERVAL = time.Millisecond * 100
+	_STITCH_GHOST_TIMEOUT       = time.Second * 5 // ghosts are removed if the neighbor stops syncing, e.g. game crashed
+)
+
+var (
+	ghostEntities = EntitySet{} // ghosts of all local spaces, whose positions are synced to clients
+)
+
+// Region of space in world coordinates on the X-Z plane, MinX and MinZ are inclusive while MaxX and MaxZ are exclusive
+type SpaceRegion struct {
+	MinX, MaxX Coord
+	MinZ, MaxZ Coord
+}
+
+// Check if the position is in the region
+func (r SpaceRegion) Contains(pos Position) bool {
+	return pos.X >= r.MinX && pos.X < r.MaxX && pos.Z >= r.MinZ && pos.Z < r.MaxZ
+}
+
+func (r SpaceRegion) expand(d Coord) SpaceRegion {
+	return SpaceRegion{r.MinX - d, r.MaxX + d, r.MinZ - d, r.MaxZ + d}
+}
+
+// Ghost of entity synced to stitched spaces
+type StitchGhost struct {
+	ID         EntityID
+	TypeName   string
+	Pos        Position
+	Yaw        Yaw
+	ClientData []byte // packed all-client attrs, only sent when the entity is ghosted to the neighbor
+}
+
+type spaceStitching struct {
+	region        SpaceRegion
+	ghostDistance Coord
+	neighbors     map[EntityID]*stitchedNeighbor
+}
+
+type stitchedNeighbor struct {
+	region     SpaceRegion
+	sentGhosts EntityIDSet          // entities ghosted to the neighbor in the last sync
+	ghosts     map[EntityID]*Entity // ghosts of entities in the neighbor
+	lastSync   time.Time            // last time ghosts are synced from the neighbor
+}
+
+// Set the region of space in world coordinates, which is required before stitching spaces
+func (space *Space) SetStitchRegion(region SpaceRegion) {
+	if space.IsNil() {
+		gwlog.Panicf("%s.SetStitchRegion: nil space can not be stitched", space)
+	}
+
+	st := space.stitching
+	if st == nil {
+		st = &spaceStitching{
+			ghostDistance: DEFAULT_AOI_DISTANCE,
+			neighbors:     map[EntityID]*stitchedNeighbor{},
+		}
+		space.stitching = st
+		space.addRawTimer(_STITCH_GHOST_SYNC_INTERVAL, space.syncStitchGhosts)
+	}
+
+	st.region = region
+	for neighborID := range st.neighbors {
+		space.Call(neighborID, "StitchedBySpace", space.ID, region)
+	}
+}
+
+// Get the region of space, returns false if the region is not set
+func (space *Space) GetStitchRegion() (SpaceRegion, bool) {
+	if space.stitching == nil {
+		return SpaceRegion{}, false
+	}
+	return space.stitching.region, true
+}
+
+// Set the distance from neighbor regions within which entities are ghosted to neighbors, DEFAULT_AOI_DISTANCE by
+// default, should be no less than AOI distances of players
+func (space *Space) SetStitchGhostDistance(dist Coord) {
+	if space.stitching == nil {
+		gwlog.Panicf("%s.SetStitchGhostDistance: stitch region is not set", space)
+	}
+	if dist < 0 {
+		gwlog.Panicf("%s.SetStitchGhostDistance: invalid distance: %v", space, dist)
+	}
+	space.stitching.ghostDistance = dist
+}
+
+// Stitch the space with neighbor space, both spaces should have regions set
+//
+// The neighbor records this space and replies with its region, stitching takes effect after the reply.
+func (space *Space) StitchSpace(neighborID EntityID) {
+	if space.stitching == nil {
+		gwlog.Panicf("%s.StitchSpace: stitch region is not set", space)
+	}
+	if neighborID == space.ID {
+		gwlog.Panicf("%s.StitchSpace: can not stitch with itself", space)
+	}
+	space.Call(neighborID, "StitchedBySpace", space.ID, space.stitching.region)
+}
+
+// Unstitch the space from neighbor space, ghosts of both sides are removed
+func (space *Space) UnstitchSpace(neighborID EntityID) {
+	if space.stitching == nil || space.stitching.neighbors[neighborID] == nil {
+		return
+	}
+	space.removeStitchedNeighbor(neighborID)
+	space.Call(neighborID, "UnstitchedBySpace", space.ID)
+}
+
+// Get IDs of spaces stitched with this space
+func (space *Space) GetStitchedSpaces() []EntityID {
+	if space.stitching == nil {
+		return nil
+	}
+	ids := make([]EntityID, 0, len(space.stitching.neighbors))
+	for neighborID := range space.stitching.neighbors {
+		ids = append(ids, neighborID)
+	}
+	return ids
+}
+
+// Check if the entity is a ghost of entity in stitched space
+func (e *Entity) IsGhost() bool {
+	return e.isGhost
+}
+
+// Called by neighbor space when stitching or when the neighbor region changes
+func (space *Space) StitchedBySpace(neighborID EntityID, region SpaceRegion) {
+	st := space.stitching
+	if st == nil {
+		gwlog.Error("%s: stitched by space %s, but stitch region is not set", space, neighborID)
+		return
+	}
+
+	nb := st.neighbors[neighborID]
+	if nb == nil {
+		nb = &stitchedNeighbor{
+			sentGhosts: EntityIDSet{},
+			ghosts:     map[EntityID]*Entity{},
+			lastSync:   time.Now(),
+		}
+		st.neighbors[neighborID] = nb
+		space.Call(neighborID, "StitchedBySpace", space.ID, st.region)
+		gwlog.Info("%s: stitched with space %s", space, neighborID)
+	}
+	nb.region = region
+}
+
+// Called by neighbor space when unstitching or destroying
+func (space *Space) UnstitchedBySpace(neighborID EntityID) {
+	if space.stitching == nil || space.stitching.neighbors[neighborID] == nil {
+		return
+	}
+	space.removeStitchedNeighbor(neighborID)
+}
+
+// Called by neighbor space periodically with all entities near the border
+func (space *Space) SyncStitchGhosts(neighborID EntityID, ghosts []StitchGhost) {
+	if space.stitching == nil {
+		return
+	}
+	nb := space.stitching.neighbors[neighborID]
+	if nb == nil {
+		return // not stitched
+	}
+
+	nb.lastSync = time.Now()
+	synced := EntityIDSet{}
+	for i := range ghosts {
+		g := &ghosts[i]
+		synced.Add(g.ID)
+		if ghost := nb.ghosts[g.ID]; ghost != nil {
+			space.moveGhost(ghost, g.Pos, g.Yaw)
+		} else if ghost := space.enterGhost(g); ghost != nil {
+			nb.ghosts[g.ID] = ghost
+		}
+	}
+
+	for eid, ghost := range nb.ghosts {
+		if !synced.Contains(eid) {
+			delete(nb.ghosts, eid)
+			space.leaveGhost(ghost)
+		}
+	}
+}
+
+func (space *Space) removeStitchedNeighbor(neighborID EntityID) {
+	nb := space.stitching.neighbors[neighborID]
+	delete(space.stitching.neighbors, neighborID)
+	for _, ghost := range nb.ghosts {
+		space.leaveGhost(ghost)
+	}
+	gwlog.Info("%s: unstitched from space %s", space, neighborID)
+}
+
+// Unstitch from all neighbors, called when the space is destroying
+func (space *Space) destroyStitching() {
+	if space.stitching == nil {
+		return
+	}
+	for neighborID := range space.stitching.neighbors {
+		space.UnstitchSpace(neighborID)
+	}
+}
+
+// Sync entities near the border to each neighbor, called periodically
+func (space *Space) syncStitchGhosts() {
+	st := space.stitching
+	now := time.Now()
+	for neighborID, nb := range st.neighbors {
+		if len(nb.ghosts) > 0 && now.Sub(nb.lastSync) > _STITCH_GHOST_TIMEOUT {
+			gwlog.Warn("%s: ghosts of space %s are not synced for %s, removed", space, neighborID, now.Sub(nb.lastSync))
+			for eid, ghost := range nb.ghosts {
+				delete(nb.ghosts, eid)
+				space.leaveGhost(ghost)
+			}
+		}
+
+		band := nb.region.expand(st.ghostDistance)
+		var ghosts []StitchGhost
+		sent := EntityIDSet{}
+		space.aoiCalc.VisitRect(band.MinX, band.MaxX, band.MinZ, band.MaxZ, func(aoi *AOI) {
+			e := aoi.getEntity()
+			if e.isGhost {
+				return
+			}
+
+			g := StitchGhost{ID: e.ID, TypeName: e.TypeName, Pos: aoi.pos, Yaw: e.yaw}
+			if !nb.sentGhosts.Contains(e.ID) {
+				g.ClientData = e.getPackedAllClientData()
+			}
+			ghosts = append(ghosts, g)
+			sent.Add(e.ID)
+		})
+
+		if len(ghosts) > 0 || len(nb.sentGhosts) > 0 { // empty list removes ghosts sent before
+			space.Call(neighborID, "SyncStitchGhosts", space.ID, ghosts)
+		}
+		nb.sentGhosts = sent
+	}
+}
+
+// Migrate the entity to the neighbor space if it moves out of the region of this space
+func (space *Space) checkStitchBorder(entity *Entity, pos Position) {
+	st := space.stitching
+	if st.region.Contains(pos) || entity.isEnteringSpace() {
+		return
+	}
+
+	for neighborID, nb := range st.neighbors {
+		if nb.region.Contains(pos) {
+			entity.EnterSpace(neighborID, pos)
+			return
+		}
+	}
+}
+
+// Create the ghost in AOI of space, returns nil if the ghost can not be created
+func (space *Space) enterGhost(g *StitchGhost) *Entity {
+	if g.ClientData == nil {
+		return nil // client data is not synced yet
+	}
+	if e := entityManager.get(g.ID); e != nil && e.Space == space {
+		return nil // the real entity is already migrated here
+	}
+	desc := registeredEntityTypes[g.TypeName]
+	if desc == nil {
+		gwlog.Error("%s: ghost %s<%s> ignored: unknown entity type", space, g.TypeName, g.ID)
+		return nil
+	}
+
+	instance := reflect.New(desc.entityType)
+	ghost := reflect.Indirect(instance).FieldByName("Entity").Addr().Interface().(*Entity)
+	ghost.ID = g.ID
+	ghost.IV = instance
+	ghost.I = instance.Interface().(IEntity)
+	ghost.TypeName = g.TypeName
+	ghost.typeDesc = desc
+	ghost.Attrs = NewMapAttr() // not owned, changes are not synced
+	ghost.isGhost = true
+	ghost.yaw = g.Yaw
+	ghost.allClientDataCache = g.ClientData
+	initAOI(&ghost.aoi)
+
+	ghost.Space = space
+	space.aoiCalc.Enter(&ghost.aoi, g.Pos)
+	space.adjustAOI(ghost, true)
+	ghostEntities.Add(ghost)
+	return ghost
+}
+
+func (space *Space) moveGhost(ghost *Entity, pos Position, yaw Yaw) {
+	if pos == ghost.aoi.pos && yaw == ghost.yaw {
+		return
+	}
+
+	ghost.yaw = yaw
+	if pos != ghost.aoi.pos {
+		space.aoiCalc.Move(&ghost.aoi, pos)
+		space.adjustAOI(ghost, true)
+	}
+	ghost.syncInfoFlag |= sifSyncNeighborClients
+}
+
+func (space *Space) leaveGhost(ghost *Entity) {
+	space.removeFromAOI(ghost)
+	ghostEntities.Del(ghost)
+	ghost.Space = nilSpace
+	ghost.destroyed = true
+}
+
+// Remove the ghost of entity before the real entity enters the space
+func (space *Space) removeGhostOf(eid EntityID) {
+	if space.stitching == nil {
+		return
+	}
+	for _, nb := range space.stitching.neighbors {
+		if ghost := nb.ghosts[eid]; ghost != nil {
+			delete(nb.ghosts, eid)
+			space.leaveGhost(ghost)
+		}
+	}
+}
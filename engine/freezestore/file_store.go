@@ -0,0 +1,46 @@
+package freezestore
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+type fileStore struct {
+	dir string
+}
+
+func openFileStore(u *url.URL) (*fileStore, error) {
+	dir := u.Path
+	if dir == "" {
+		dir = u.Opaque // relative directory like file:freeze
+	}
+	if dir == "" {
+		return nil, errors.Errorf("invalid freeze target %s: directory is not set", u)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir}, nil
+}
+
+// Write the file atomically, so the last freeze data is kept if the game fails during writing
+func (s *fileStore) Write(name string, data []byte) error {
+	path := filepath.Join(s.dir, name)
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func (s *fileStore) Read(name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.dir, name))
+}
+
+func (s *fileStore) String() string {
+	return "file:" + s.dir
+}
@@ -0,0 +1,55 @@
+package freezestore
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Freeze data of games are written to and read from the freeze target configured by freeze_target of games:
+//
+//	(not set)                            game<id>_freezed.dat in the working directory
+//	file:///data/goworld                 game<id>_freezed.dat in the directory
+//	redis://:password@127.0.0.1:6379/0   key __freeze__/game<id>_freezed.dat in the Redis database
+//	s3://bucket/prefix?region=us-east-1  object prefix/game<id>_freezed.dat in the S3 bucket
+//
+// S3-compatible storages (e.g. MinIO) are used by setting endpoint in the query, like
+// s3://bucket/prefix?region=us-east-1&endpoint=http://127.0.0.1:9000, and credentials of S3 are read from environment
+// variables or shared credentials files. With Redis or S3, a game can be freezed on one machine and restored by
+// -restore on another machine with the same freeze target.
+
+// Store of freeze data
+type Store interface {
+	Write(name string, data []byte) error
+	Read(name string) ([]byte, error)
+	String() string
+}
+
+// Open the store of the freeze target
+func Open(target string) (Store, error) {
+	if target == "" {
+		return &fileStore{dir: "."}, nil
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid freeze target %s", target)
+	}
+
+	switch u.Scheme {
+	case "file":
+		return openFileStore(u)
+	case "redis":
+		return openRedisStore(u)
+	case "s3":
+		return openS3Store(u)
+	default:
+		return nil, errors.Errorf("invalid freeze target %s: unknown scheme %s", target, u.Scheme)
+	}
+}
+
+// Get the name of freeze data of the game
+func FreezeName(gameid uint16) string {
+	return fmt.Sprintf("game%d_freezed.dat", gameid)
+}
@@ -0,0 +1,42 @@
+package freezestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := Open("file://" + filepath.Join(dir, "freeze"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := FreezeName(1)
+	if _, err := store.Read(name); err == nil {
+		t.Fatalf("read freeze data not written should fail")
+	}
+	if err := store.Write(name, []byte("data1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Write(name, []byte("data2")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := store.Read(name); err != nil || string(data) != "data2" {
+		t.Fatalf("read freeze data: %q %v", data, err)
+	}
+}
+
+func TestOpenInvalidTarget(t *testing.T) {
+	for _, target := range []string{"ftp://host/dir", "redis:///0", "redis://127.0.0.1:6379/x", "s3:///prefix"} {
+		if _, err := Open(target); err == nil {
+			t.Errorf("open %s should fail", target)
+		}
+	}
+}
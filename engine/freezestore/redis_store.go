@@ -0,0 +1,76 @@
+package freezestore
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+)
+
+const (
+	_REDIS_KEY_PREFIX = "__freeze__/"
+)
+
+type redisStore struct {
+	host     string
+	password string
+	db       int
+}
+
+func openRedisStore(u *url.URL) (*redisStore, error) {
+	if u.Host == "" {
+		return nil, errors.Errorf("invalid freeze target %s: host is not set", u)
+	}
+
+	s := &redisStore{host: u.Host}
+	if u.User != nil {
+		s.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		var err error
+		if s.db, err = strconv.Atoi(db); err != nil {
+			return nil, errors.Errorf("invalid freeze target %s: invalid db %s", u, db)
+		}
+	}
+	return s, nil
+}
+
+// Connect for each write or read, since freeze data are rarely written
+func (s *redisStore) dial() (redis.Conn, error) {
+	options := []redis.DialOption{redis.DialDatabase(s.db)}
+	if s.password != "" {
+		options = append(options, redis.DialPassword(s.password))
+	}
+	return redis.Dial("tcp", s.host, options...)
+}
+
+func (s *redisStore) Write(name string, data []byte) error {
+	conn, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Do("SET", _REDIS_KEY_PREFIX+name, data)
+	return err
+}
+
+func (s *redisStore) Read(name string) ([]byte, error) {
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", _REDIS_KEY_PREFIX+name))
+	if err == redis.ErrNil {
+		return nil, errors.Errorf("freeze data %s not found in redis %s", name, s.host)
+	}
+	return data, err
+}
+
+func (s *redisStore) String() string {
+	return "redis://" + s.host + "/" + strconv.Itoa(s.db)
+}
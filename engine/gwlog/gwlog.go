@@ -9,10 +9,19 @@ import (
 
 	"strings"
 
+	"sync"
+	"sync/atomic"
+
 	sublog "github.com/Sirupsen/logrus"
 	"github.com/pkg/errors"
 )
 
+// Logs are structured: each log has global fields of the process (e.g. gameid) and fields of the logger (e.g. module
+// and entityID), and are written as text or JSON (for ELK, Loki, etc.) by SetFormat.
+//
+// Loggers of modules are got by Module, and levels of modules can be set at runtime by SetModuleLevel to silence
+// noisy modules individually. Modules without levels use the global level, so do Debug, Info, Warn and Error.
+
 var (
 	DEBUG Level = Level(sublog.DebugLevel)
 	INFO  Level = Level(sublog.InfoLevel)
@@ -21,39 +30,218 @@ var (
 	PANIC Level = Level(sublog.PanicLevel)
 	FATAL Level = Level(sublog.FatalLevel)
 
-	Debug = sublog.Debugf
-	Info  = sublog.Infof
-	Warn  = sublog.Warnf
-	Error = sublog.Errorf
-
 	outputWriter io.Writer
+
+	globalLevel      = uint32(DEBUG)
+	moduleLevels     = map[string]Level{}
+	moduleLevelsLock sync.RWMutex
+
+	globalFields     atomic.Value // sublog.Fields, replaced when changed
+	globalFieldsLock sync.Mutex
+
+	modules       = map[string]*Logger{}
+	modulesLock   sync.Mutex
+	defaultLogger = &Logger{}
 )
 
 type Level uint8
 
+// Fields of logs
+type Fields map[string]interface{}
+
+// Logger writes logs with fields, loggers are immutable and can be used in any goroutine
+type Logger struct {
+	module string
+	fields sublog.Fields
+}
+
 func init() {
 	outputWriter = os.Stderr
 	sublog.SetOutput(outputWriter)
 
-	sublog.SetLevel(sublog.DebugLevel)
+	sublog.SetLevel(sublog.DebugLevel) // levels are checked by loggers
+	globalFields.Store(sublog.Fields{})
 }
 
 func ParseLevel(lvl string) (sublog.Level, error) {
 	return sublog.ParseLevel(lvl)
 }
 
+// Set the global level of logs of modules without levels
 func SetLevel(lv Level) {
-	sublog.SetLevel(sublog.Level(lv))
+	atomic.StoreUint32(&globalLevel, uint32(lv))
 }
 
-func TraceError(format string, args ...interface{}) {
+// Set the level of logs of the module, which overrides the global level
+func SetModuleLevel(module string, lv Level) {
+	moduleLevelsLock.Lock()
+	moduleLevels[module] = lv
+	moduleLevelsLock.Unlock()
+}
+
+// Set levels of all modules, levels of other modules are removed
+func SetModuleLevels(levels map[string]Level) {
+	moduleLevelsLock.Lock()
+	moduleLevels = make(map[string]Level, len(levels))
+	for module, lv := range levels {
+		moduleLevels[module] = lv
+	}
+	moduleLevelsLock.Unlock()
+}
+
+// Parse levels of modules like "aoi=warn,kvdb=error"
+func ParseModuleLevels(s string) (map[string]Level, error) {
+	levels := map[string]Level{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.Errorf("invalid module level: %s", item)
+		}
+		lv, err := sublog.ParseLevel(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.TrimSpace(kv[0])] = Level(lv)
+	}
+	return levels, nil
+}
+
+// Set the output format of logs: text or json
+func SetFormat(format string) {
+	if strings.ToLower(format) == "json" {
+		sublog.SetFormatter(&sublog.JSONFormatter{})
+	} else {
+		if format != "" && strings.ToLower(format) != "text" {
+			Error("SetFormat: unknown format: %s", format)
+		}
+		sublog.SetFormatter(&sublog.TextFormatter{})
+	}
+}
+
+// Set the field of all logs of the process, e.g. gameid
+func SetGlobalField(key string, value interface{}) {
+	globalFieldsLock.Lock()
+	defer globalFieldsLock.Unlock()
+
+	oldFields := globalFields.Load().(sublog.Fields)
+	fields := make(sublog.Fields, len(oldFields)+1)
+	for k, v := range oldFields {
+		fields[k] = v
+	}
+	fields[key] = value
+	globalFields.Store(fields)
+}
+
+// Get the logger of the module
+func Module(module string) *Logger {
+	modulesLock.Lock()
+	defer modulesLock.Unlock()
+
+	l := modules[module]
+	if l == nil {
+		l = &Logger{module: module, fields: sublog.Fields{"module": module}}
+		modules[module] = l
+	}
+	return l
+}
+
+// Returns a logger of the same module with the field added
+func (l *Logger) WithField(key string, value interface{}) *Logger {
+	return l.WithFields(Fields{key: value})
+}
+
+// Returns a logger of the same module with the fields added
+func (l *Logger) WithFields(fields Fields) *Logger {
+	newFields := make(sublog.Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		newFields[k] = v
+	}
+	for k, v := range fields {
+		newFields[k] = v
+	}
+	return &Logger{module: l.module, fields: newFields}
+}
+
+// Check if logs of the level are written by the logger
+func (l *Logger) IsEnabled(lv Level) bool {
+	moduleLevelsLock.RLock()
+	moduleLevel, ok := moduleLevels[l.module]
+	moduleLevelsLock.RUnlock()
+	if !ok {
+		moduleLevel = Level(atomic.LoadUint32(&globalLevel))
+	}
+	return lv <= moduleLevel
+}
+
+func (l *Logger) entry() *sublog.Entry {
+	entry := sublog.WithFields(globalFields.Load().(sublog.Fields))
+	if len(l.fields) > 0 {
+		entry = entry.WithFields(l.fields)
+	}
+	return entry
+}
+
+func (l *Logger) Debug(format string, args ...interface{}) {
+	if l.IsEnabled(DEBUG) {
+		l.entry().Debugf(format, args...)
+	}
+}
+
+func (l *Logger) Info(format string, args ...interface{}) {
+	if l.IsEnabled(INFO) {
+		l.entry().Infof(format, args...)
+	}
+}
+
+func (l *Logger) Warn(format string, args ...interface{}) {
+	if l.IsEnabled(WARN) {
+		l.entry().Warnf(format, args...)
+	}
+}
+
+func (l *Logger) Error(format string, args ...interface{}) {
+	if l.IsEnabled(ERROR) {
+		l.entry().Errorf(format, args...)
+	}
+}
+
+func (l *Logger) TraceError(format string, args ...interface{}) {
 	outputWriter.Write(debug.Stack())
-	Error(format, args...)
+	l.Error(format, args...)
 }
 
-func Fatal(format string, args ...interface{}) {
+func (l *Logger) Fatal(format string, args ...interface{}) {
 	debug.PrintStack()
-	sublog.Fatalf(format, args...)
+	l.entry().Fatalf(format, args...)
+}
+
+func Debug(format string, args ...interface{}) {
+	defaultLogger.Debug(format, args...)
+}
+
+func Info(format string, args ...interface{}) {
+	defaultLogger.Info(format, args...)
+}
+
+func Warn(format string, args ...interface{}) {
+	defaultLogger.Warn(format, args...)
+}
+
+func Error(format string, args ...interface{}) {
+	defaultLogger.Error(format, args...)
+}
+
+func TraceError(format string, args ...interface{}) {
+	defaultLogger.TraceError(format, args...)
+}
+
+func Fatal(format string, args ...interface{}) {
+	defaultLogger.Fatal(format, args...)
 }
 
 func Panic(v interface{}) {
@@ -0,0 +1,54 @@
+package gwlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestModuleLevels(t *testing.T) {
+	levels, err := ParseModuleLevels("aoi=warn, kvdb = error")
+	if err != nil || len(levels) != 2 || levels["aoi"] != WARN || levels["kvdb"] != ERROR {
+		t.Fatalf("parse module levels: %v, %v", levels, err)
+	}
+	if _, err := ParseModuleLevels("aoi"); err == nil {
+		t.Errorf("module level without level should be invalid")
+	}
+
+	SetLevel(INFO)
+	SetModuleLevels(levels)
+	defer SetModuleLevels(nil)
+	defer SetLevel(DEBUG)
+
+	if Module("aoi").IsEnabled(INFO) || !Module("aoi").IsEnabled(WARN) {
+		t.Errorf("aoi should log warnings only")
+	}
+	if !Module("other").IsEnabled(INFO) || Module("other").IsEnabled(DEBUG) {
+		t.Errorf("modules without levels should use the global level")
+	}
+	SetModuleLevel("other", DEBUG)
+	if !Module("other").WithField("entityID", "E1").IsEnabled(DEBUG) {
+		t.Errorf("level of module should be changed at runtime")
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	out := GetOutput()
+	SetOutput(&buf)
+	SetFormat("json")
+	defer SetOutput(out)
+	defer SetFormat("text")
+
+	SetGlobalField("gameid", 1)
+	Module("test").WithField("entityID", "E1").Info("hello %s", "world")
+
+	var log map[string]interface{}
+	if err := json.Unmarshal([]byte(strings.TrimSpace(buf.String())), &log); err != nil {
+		t.Fatalf("log is not JSON: %s", buf.String())
+	}
+	if log["msg"] != "hello world" || log["module"] != "test" || log["entityID"] != "E1" || log["gameid"] != float64(1) {
+		t.Errorf("wrong fields of log: %v", log)
+	}
+}
@@ -0,0 +1,119 @@
+package gwrand
+
+import (
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Fair streams make rolls verifiable by players with seed commitment:
+//
+//	1. The server generates a secret server seed and publishes the commitment (SHA256 of server seed).
+//	2. Each roll is HMAC-SHA256(server seed, "clientSeed:nonce"), where client seed is chosen by the player.
+//	3. When the server seed is revealed by Rotate, players verify the commitment and all rolls with VerifyRoll.
+
+// Random number stream with seed commitment
+type FairStream struct {
+	name       string
+	serverSeed []byte
+	clientSeed string
+	nonce      uint64
+}
+
+// Create a fair stream with the client seed, the commitment of server seed can be published to players
+func NewFairStream(name string, clientSeed string) *FairStream {
+	s := &FairStream{name: name, clientSeed: clientSeed}
+	s.serverSeed = genServerSeed()
+	s.logCommitment()
+	return s
+}
+
+// Get the commitment of current server seed
+func (s *FairStream) Commitment() string {
+	return commitmentOf(s.serverSeed)
+}
+
+// Get the client seed
+func (s *FairStream) ClientSeed() string {
+	return s.clientSeed
+}
+
+// Get the nonce of next roll
+func (s *FairStream) Nonce() uint64 {
+	return s.nonce
+}
+
+// Change the client seed, nonce is reset
+func (s *FairStream) SetClientSeed(clientSeed string) {
+	s.clientSeed = clientSeed
+	s.nonce = 0
+	s.logCommitment()
+}
+
+// Reveal the current server seed and start using a new server seed, returns the revealed server seed
+func (s *FairStream) Rotate() (revealedServerSeed string) {
+	revealedServerSeed = hex.EncodeToString(s.serverSeed)
+	gwlog.Info("gwrand: fair stream %s reveals server seed %s after %d rolls", s.name, revealedServerSeed, s.nonce)
+
+	s.serverSeed = genServerSeed()
+	s.nonce = 0
+	s.logCommitment()
+	return
+}
+
+// Returns a float64 in [0.0, 1.0)
+func (s *FairStream) Float64() float64 {
+	r := roll(s.serverSeed, s.clientSeed, s.nonce)
+	s.nonce += 1
+	return r
+}
+
+// Returns a non-negative int in [0, n)
+func (s *FairStream) Intn(n int) int {
+	if n <= 0 {
+		gwlog.Panicf("gwrand: invalid argument to Intn: %d", n)
+	}
+	return int(s.Float64() * float64(n))
+}
+
+func (s *FairStream) logCommitment() {
+	gwlog.Info("gwrand: fair stream %s commitment %s, client seed %q", s.name, s.Commitment(), s.clientSeed)
+}
+
+// Verify the roll of fair stream with the revealed server seed and commitment
+func VerifyRoll(revealedServerSeed string, commitment string, clientSeed string, nonce uint64) (float64, error) {
+	serverSeed, err := hex.DecodeString(revealedServerSeed)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid server seed")
+	}
+	if !hmac.Equal([]byte(commitmentOf(serverSeed)), []byte(commitment)) {
+		return 0, errors.Errorf("server seed does not match commitment %s", commitment)
+	}
+	return roll(serverSeed, clientSeed, nonce), nil
+}
+
+func genServerSeed() []byte {
+	seed := make([]byte, 32)
+	if _, err := crand.Read(seed); err != nil {
+		gwlog.Panic(err)
+	}
+	return seed
+}
+
+func commitmentOf(serverSeed []byte) string {
+	sum := sha256.Sum256(serverSeed)
+	return hex.EncodeToString(sum[:])
+}
+
+func roll(serverSeed []byte, clientSeed string, nonce uint64) float64 {
+	mac := hmac.New(sha256.New, serverSeed)
+	fmt.Fprintf(mac, "%s:%d", clientSeed, nonce)
+	sum := mac.Sum(nil)
+	return float64(binary.BigEndian.Uint64(sum[:8])>>11) / (1 << 53) // use 53 bits for float64 mantissa
+}
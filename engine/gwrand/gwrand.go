@@ -0,0 +1,117 @@
+package gwrand
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Random number streams with logged seeds, so that rolls (loot, gacha, etc.) can be reproduced in audits by
+// replaying the stream with the logged seed and the number of draws.
+//
+// Streams are not goroutine-safe, just like entities.
+
+// Logger of stream seeds
+type SeedLogger func(name string, seed int64)
+
+var (
+	seedLogger SeedLogger = func(name string, seed int64) {
+		gwlog.Info("gwrand: stream %s seed %d", name, seed)
+	}
+)
+
+// Set the logger of stream seeds, e.g. writing seeds to audit logs
+func SetSeedLogger(logger SeedLogger) {
+	seedLogger = logger
+}
+
+// Random number stream
+type Stream struct {
+	name  string
+	seed  int64
+	draws uint64
+	rand  *rand.Rand
+}
+
+// Create a stream with random seed, the seed is logged
+func NewStream(name string) *Stream {
+	return NewStreamWithSeed(name, GenSeed())
+}
+
+// Create a stream with the seed, useful for reproducing rolls of logged seed
+func NewStreamWithSeed(name string, seed int64) *Stream {
+	if seedLogger != nil {
+		seedLogger(name, seed)
+	}
+	return &Stream{name: name, seed: seed, rand: rand.New(rand.NewSource(seed))}
+}
+
+// Generate a random seed with crypto/rand
+func GenSeed() int64 {
+	var buf [8]byte
+	if _, err := crand.Read(buf[:]); err != nil {
+		gwlog.Panic(err)
+	}
+	return int64(binary.LittleEndian.Uint64(buf[:]))
+}
+
+// Get the name of stream
+func (s *Stream) Name() string {
+	return s.name
+}
+
+// Get the seed of stream
+func (s *Stream) Seed() int64 {
+	return s.seed
+}
+
+// Get the number of draws from the stream, which locates the roll together with seed
+func (s *Stream) Draws() uint64 {
+	return s.draws
+}
+
+// Returns a non-negative int in [0, n)
+func (s *Stream) Intn(n int) int {
+	s.draws += 1
+	return s.rand.Intn(n)
+}
+
+// Returns a non-negative int64
+func (s *Stream) Int63() int64 {
+	s.draws += 1
+	return s.rand.Int63()
+}
+
+// Returns a float64 in [0.0, 1.0)
+func (s *Stream) Float64() float64 {
+	s.draws += 1
+	return s.rand.Float64()
+}
+
+// Returns a random permutation of [0, n)
+func (s *Stream) Perm(n int) []int {
+	s.draws += 1
+	return s.rand.Perm(n)
+}
+
+// Choose an index according to weights, returns -1 if weights are all zero
+func (s *Stream) Weighted(weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	if total <= 0 {
+		return -1
+	}
+
+	r := s.Intn(total)
+	for i, w := range weights {
+		if r < w {
+			return i
+		}
+		r -= w
+	}
+	return -1 // never goes here
+}
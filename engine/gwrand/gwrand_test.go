@@ -0,0 +1,52 @@
+package gwrand
+
+import (
+	"testing"
+)
+
+func TestStreamReproducible(t *testing.T) {
+	s1 := NewStream("test")
+	rolls := []int{s1.Intn(100), s1.Intn(100), s1.Intn(100)}
+
+	s2 := NewStreamWithSeed("test", s1.Seed())
+	for i, r := range rolls {
+		if v := s2.Intn(100); v != r {
+			t.Fatalf("roll %d not reproduced: %d != %d", i, v, r)
+		}
+	}
+	if s1.Draws() != 3 || s2.Draws() != 3 {
+		t.Errorf("wrong draws: %d, %d", s1.Draws(), s2.Draws())
+	}
+}
+
+func TestStreamWeighted(t *testing.T) {
+	s := NewStream("test")
+	for i := 0; i < 100; i++ {
+		if idx := s.Weighted([]int{0, 3, 0, 1}); idx != 1 && idx != 3 {
+			t.Fatalf("zero weight chosen: %d", idx)
+		}
+	}
+	if s.Weighted([]int{0, 0}) != -1 {
+		t.Errorf("should return -1 for zero weights")
+	}
+}
+
+func TestFairStreamVerify(t *testing.T) {
+	s := NewFairStream("test", "player-seed")
+	commitment := s.Commitment()
+	r0, r1 := s.Float64(), s.Float64()
+	revealed := s.Rotate()
+
+	if s.Commitment() == commitment || s.Nonce() != 0 {
+		t.Fatalf("server seed should be rotated")
+	}
+	if v, err := VerifyRoll(revealed, commitment, "player-seed", 0); err != nil || v != r0 {
+		t.Errorf("roll 0 not verified: %v, %v", v, err)
+	}
+	if v, err := VerifyRoll(revealed, commitment, "player-seed", 1); err != nil || v != r1 {
+		t.Errorf("roll 1 not verified: %v, %v", v, err)
+	}
+	if _, err := VerifyRoll(revealed, s.Commitment(), "player-seed", 0); err == nil {
+		t.Errorf("wrong commitment should not be verified")
+	}
+}
@@ -0,0 +1,95 @@
+package gwvar
+
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/admin"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Admin editing of variables, registered to the admin server by game, so requests are checked by admin_token:
+//
+//	GET  /gwvar                             list all variables
+//	GET  /gwvar?name=NAME                   get the variable
+//	POST /gwvar/set?name=NAME&value=JSON    set the variable to the JSON value
+//	POST /gwvar/delete?name=NAME            delete the variable
+
+const (
+	_ADMIN_REQUEST_TIMEOUT = time.Second * 10
+)
+
+type adminResult struct {
+	val interface{}
+	err error
+}
+
+// Register admin endpoints of variables
+func RegisterAdminHandlers() {
+	admin.Handle("/gwvar", adminGet)
+	admin.HandleAction("/gwvar/set", adminSet)
+	admin.HandleAction("/gwvar/delete", adminDelete)
+}
+
+func adminGet(query url.Values) (interface{}, error) {
+	name := query.Get("name")
+	return inGameRoutine(func(resultChan chan adminResult) {
+		if name == "" {
+			resultChan <- adminResult{GetAll(), nil}
+		} else if val, ok := Get(name); ok {
+			resultChan <- adminResult{val, nil}
+		} else {
+			resultChan <- adminResult{nil, admin.ErrNotFound}
+		}
+	})
+}
+
+func adminSet(query url.Values) (interface{}, error) {
+	name, value := query.Get("name"), query.Get("value")
+	if name == "" {
+		return nil, errors.New("variable name is required")
+	}
+	var val interface{}
+	if err := json.Unmarshal([]byte(value), &val); err != nil || val == nil {
+		return nil, errors.Errorf("invalid JSON value: %s", value)
+	}
+
+	return inGameRoutine(func(resultChan chan adminResult) {
+		gwlog.Info("gwvar: admin sets %s = %s", name, value)
+		Set(name, val, func(err error) {
+			resultChan <- adminResult{val, err}
+		})
+	})
+}
+
+func adminDelete(query url.Values) (interface{}, error) {
+	name := query.Get("name")
+	if name == "" {
+		return nil, errors.New("variable name is required")
+	}
+
+	return inGameRoutine(func(resultChan chan adminResult) {
+		gwlog.Info("gwvar: admin deletes %s", name)
+		Delete(name, func(err error) {
+			resultChan <- adminResult{nil, err}
+		})
+	})
+}
+
+// Variables can only be accessed in the game routine
+func inGameRoutine(handler func(resultChan chan adminResult)) (interface{}, error) {
+	resultChan := make(chan adminResult, 1)
+	post.Post(func() {
+		handler(resultChan)
+	})
+
+	select {
+	case res := <-resultChan:
+		return res.val, res.err
+	case <-time.After(_ADMIN_REQUEST_TIMEOUT):
+		return nil, errors.New("timeout")
+	}
+}
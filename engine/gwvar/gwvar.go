@@ -0,0 +1,221 @@
+package gwvar
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/xiaonanln/goworld/components/dispatcher/dispatcher_client"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/typeconv"
+)
+
+// Global variables (season number, server open date, global switches, etc.) are persisted in KVDB and cached
+// by all games, so reads are always local.
+//
+// Changes are saved to KVDB first and then broadcasted to all games through the dispatcher, and watchers on
+// each game are notified when values change.
+
+const (
+	_GWVAR_KVDB_KEY_PREFIX = "__gwvar__/"
+)
+
+var (
+	initialized = false
+	vars        = map[string]interface{}{}
+	watchers    = map[Handle]*watcher{}
+	nextHandle  = Handle(1)
+)
+
+// Callback for variable changes, value is nil if the variable is deleted
+type WatchCallback func(name string, value interface{})
+
+type Handle int // Return value of Watch, can be used to unwatch
+
+type watcher struct {
+	name string // watch all variables if empty
+	cb   WatchCallback
+}
+
+// Initialize gwvar module and load all variables from KVDB, called by engine when game is ready
+func Initialize() {
+	initialized = true
+	reload()
+}
+
+// Check if variables are loaded from KVDB
+func IsInitialized() bool {
+	return initialized
+}
+
+// Get the value of variable
+func Get(name string) (interface{}, bool) {
+	val, ok := vars[name]
+	return val, ok
+}
+
+// Get the value of variable as int, returns defaultVal if variable not exists
+func GetInt(name string, defaultVal int) int {
+	if val, ok := vars[name]; ok {
+		return int(typeconv.Int(val))
+	}
+	return defaultVal
+}
+
+// Get the value of variable as float64, returns defaultVal if variable not exists
+func GetFloat(name string, defaultVal float64) float64 {
+	if val, ok := vars[name]; ok {
+		return typeconv.Float(val)
+	}
+	return defaultVal
+}
+
+// Get the value of variable as string, returns defaultVal if variable not exists
+func GetStr(name string, defaultVal string) string {
+	if val, ok := vars[name]; ok {
+		return typeconv.String(val)
+	}
+	return defaultVal
+}
+
+// Get the value of variable as bool, returns defaultVal if variable not exists
+func GetBool(name string, defaultVal bool) bool {
+	if val, ok := vars[name]; ok {
+		return typeconv.Bool(val)
+	}
+	return defaultVal
+}
+
+// Get all variables
+func GetAll() map[string]interface{} {
+	all := make(map[string]interface{}, len(vars))
+	for name, val := range vars {
+		all[name] = val
+	}
+	return all
+}
+
+// Set the variable and save to KVDB, all games are notified after saved
+//
+// The value should be JSON serializable, numbers are read as float64 by Get
+func Set(name string, value interface{}, callback kvdb.KVDBPutCallback) {
+	if name == "" {
+		gwlog.Panicf("gwvar.Set: variable name is empty")
+	}
+	if value == nil {
+		gwlog.Panicf("gwvar.Set: value of %s is nil, use Delete to delete variable", name)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	save(name, string(data), callback)
+}
+
+// Delete the variable from KVDB, all games are notified after saved
+func Delete(name string, callback kvdb.KVDBPutCallback) {
+	save(name, "", callback) // empty value for deleted variable
+}
+
+// Watch changes of the specified variable, or all variables if name is empty
+func Watch(name string, cb WatchCallback) Handle {
+	h := nextHandle
+	nextHandle += 1
+	watchers[h] = &watcher{name: name, cb: cb}
+	return h
+}
+
+// Unwatch the watched handle
+func (h Handle) Unwatch() {
+	delete(watchers, h)
+}
+
+// Called by engine when other game changes the variable
+func OnNotifyChange(name string, data string) {
+	update(name, data)
+}
+
+func save(name string, data string, callback kvdb.KVDBPutCallback) {
+	kvdb.Put(_GWVAR_KVDB_KEY_PREFIX+name, data, func(err error) {
+		if err == nil {
+			update(name, data)
+			dispatcher_client.GetDispatcherClientForSend().SendNotifyGwvarChange(name, data)
+		} else {
+			gwlog.TraceError("gwvar: save %s failed: %s", name, err)
+		}
+
+		if callback != nil {
+			callback(err)
+		}
+	})
+}
+
+func reload() {
+	beginKey := _GWVAR_KVDB_KEY_PREFIX
+	endKey := beginKey[:len(beginKey)-1] + string(beginKey[len(beginKey)-1]+1)
+	kvdb.GetRange(beginKey, endKey, func(items []kvdb_types.KVItem, err error) {
+		if err != nil {
+			gwlog.TraceError("gwvar: load variables failed: %s", err)
+			return
+		}
+
+		loaded := map[string]bool{}
+		for _, item := range items {
+			name := strings.TrimPrefix(item.Key, _GWVAR_KVDB_KEY_PREFIX)
+			loaded[name] = true
+			update(name, item.Val)
+		}
+
+		for name := range vars {
+			if !loaded[name] {
+				update(name, "")
+			}
+		}
+		gwlog.Info("gwvar: %d variables loaded", len(vars))
+	})
+}
+
+func update(name string, data string) {
+	var val interface{}
+	if data != "" {
+		if err := json.Unmarshal([]byte(data), &val); err != nil {
+			gwlog.TraceError("gwvar: invalid value of %s: %s", name, err)
+			return
+		}
+	}
+
+	old, exists := vars[name]
+	if val == nil {
+		if !exists {
+			return
+		}
+		delete(vars, name)
+	} else {
+		if exists && isValueEqual(old, val) {
+			return
+		}
+		vars[name] = val
+	}
+
+	notifyWatchers(name, val)
+}
+
+func isValueEqual(a, b interface{}) bool {
+	adata, _ := json.Marshal(a)
+	bdata, _ := json.Marshal(b)
+	return string(adata) == string(bdata)
+}
+
+func notifyWatchers(name string, val interface{}) {
+	for _, w := range watchers {
+		if w.name == "" || w.name == name {
+			cb := w.cb
+			gwutils.RunPanicless(func() {
+				cb(name, val)
+			})
+		}
+	}
+}
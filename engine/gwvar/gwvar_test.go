@@ -0,0 +1,70 @@
+package gwvar
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/admin"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+func TestNotifyChange(t *testing.T) {
+	var changes []interface{}
+	h := Watch("season", func(name string, value interface{}) {
+		changes = append(changes, value)
+	})
+	defer h.Unwatch()
+
+	OnNotifyChange("season", "3")
+	OnNotifyChange("season", "3") // not changed
+	OnNotifyChange("other", "true")
+	if len(changes) != 1 || changes[0] != float64(3) {
+		t.Fatalf("wrong changes: %v", changes)
+	}
+	if val, ok := Get("season"); !ok || val != float64(3) {
+		t.Errorf("wrong value: %v", val)
+	}
+
+	OnNotifyChange("season", "")
+	if _, ok := Get("season"); ok {
+		t.Errorf("season should be deleted")
+	}
+	if len(changes) != 2 || changes[1] != nil {
+		t.Errorf("wrong changes: %v", changes)
+	}
+	if GetStr("season", "none") != "none" {
+		t.Errorf("should return default value")
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	OnNotifyChange("motd", `"hello"`)
+	defer OnNotifyChange("motd", "")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() { // run posted callbacks as the game routine
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+				post.Tick()
+			}
+		}
+	}()
+
+	if val, err := adminGet(url.Values{"name": {"motd"}}); err != nil || val != "hello" {
+		t.Errorf("wrong value: %v %v", val, err)
+	}
+	if _, err := adminGet(url.Values{"name": {"unknown"}}); err != admin.ErrNotFound {
+		t.Errorf("unknown variable should be not found: %v", err)
+	}
+	if _, err := adminSet(url.Values{"name": {"motd"}, "value": {"hello"}}); err == nil {
+		t.Errorf("value should be JSON")
+	}
+	if _, err := adminSet(url.Values{"value": {"1"}}); err == nil {
+		t.Errorf("name should be required")
+	}
+}
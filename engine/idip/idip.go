@@ -0,0 +1,276 @@
+package idip
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// IDIP adapter accepts GM operations from external operations platforms over HTTP+JSON, and translates them into
+// calls with results on player entities, so that publishers can integrate without bespoke bridge processes.
+//
+// The adapter is served by games with idip_port configured, requests should carry the token of idip_token:
+//
+//	POST /idip
+//	X-IDIP-Token: <idip_token>
+//
+//	{"RequestID": "op-20180101-0001", "Cmd": "ban", "PlayerID": "<EntityID>", "Operator": "gm001",
+//	 "Params": {"Seconds": 3600, "Reason": "cheating"}}
+//
+// and replied with:
+//
+//	{"RequestID": "op-20180101-0001", "Code": 0, "Msg": "", "Data": {...}, "Duplicate": false}
+//
+// Built-in commands are translated to methods of player entities, which should be implemented by the player type:
+//
+//	query_player       IDIPQueryPlayer(params map[string]interface{}) (map[string]interface{}, error)
+//	ban                IDIPBan(params map[string]interface{}) (map[string]interface{}, error)
+//	send_mail          IDIPSendMail(params map[string]interface{}) (map[string]interface{}, error)
+//	modify_currency    IDIPModifyCurrency(params map[string]interface{}) (map[string]interface{}, error)
+//
+// More commands can be registered by RegisterCommand. RequestID is also passed to methods in params.
+//
+// Requests are idempotent by RequestID: results are saved in KVDB and replied again with Duplicate=true for retried
+// requests, without calling the player again. Only timeout requests are not saved, since it is unknown whether the
+// player has handled it, so player methods should also check RequestID for operations which can not be repeated.
+//
+// Every request is written to the audit log with operator, params and result.
+
+const (
+	IDIP_CODE_OK             = 0
+	IDIP_CODE_BAD_REQUEST    = 1 // invalid request, unknown command or player ID
+	IDIP_CODE_UNAUTHORIZED   = 2 // wrong token
+	IDIP_CODE_FAILED         = 3 // the player method returns error, or player not found
+	IDIP_CODE_TIMEOUT        = 4 // the player method is not replied in time, it may or may not be handled
+	IDIP_CODE_IN_PROGRESS    = 5 // the request with the same RequestID is being handled
+	IDIP_CODE_INTERNAL_ERROR = 6 // e.g. KVDB failures
+
+	IDIP_CMD_QUERY_PLAYER    = "query_player"
+	IDIP_CMD_BAN             = "ban"
+	IDIP_CMD_SEND_MAIL       = "send_mail"
+	IDIP_CMD_MODIFY_CURRENCY = "modify_currency"
+
+	IDIP_TOKEN_HEADER = "X-IDIP-Token"
+
+	_IDIP_RESULT_KVDB_KEY_PREFIX = "__idip__:"
+	_IDIP_MAX_REQUEST_SIZE       = 1024 * 1024
+)
+
+// Request of IDIP operation
+type Request struct {
+	RequestID string
+	Cmd       string
+	PlayerID  common.EntityID
+	Operator  string // the operator of operations platform, for audit only
+	Params    map[string]interface{}
+}
+
+// Response of IDIP operation
+type Response struct {
+	RequestID string
+	Code      int
+	Msg       string
+	Data      map[string]interface{} `json:",omitempty"`
+	Duplicate bool                   // the request is already handled, and the saved result is replied
+}
+
+var (
+	commands = map[string]string{ // command -> method of player entity
+		IDIP_CMD_QUERY_PLAYER:    "IDIPQueryPlayer",
+		IDIP_CMD_BAN:             "IDIPBan",
+		IDIP_CMD_SEND_MAIL:       "IDIPSendMail",
+		IDIP_CMD_MODIFY_CURRENCY: "IDIPModifyCurrency",
+	}
+	playerType         string
+	inProgressRequests = map[string]bool{}
+)
+
+// Set the entity type of players, players are loaded anywhere if they are not online when requested
+//
+// If player type is not set, requests of players not loaded fail with IDIP_CODE_TIMEOUT
+func SetPlayerType(typeName string) {
+	playerType = typeName
+}
+
+// Register the command which is translated to the method of player entity, built-in commands can be overridden
+func RegisterCommand(cmd string, method string) {
+	commands[cmd] = method
+}
+
+// Serve IDIP requests on ip:port, requests are rejected if token is empty
+func Serve(ip string, port int, token string) {
+	if port == 0 {
+		gwlog.Info("IDIP adapter not enabled")
+		return
+	}
+	if token == "" {
+		gwlog.Fatal("idip_token should be set for IDIP adapter")
+	}
+
+	host := fmt.Sprintf("%s:%d", ip, port)
+	gwlog.Info("IDIP adapter listening on http://%s/idip ...", host)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/idip", func(w http.ResponseWriter, r *http.Request) {
+		serveIDIP(w, r, token)
+	})
+	go func() {
+		err := http.ListenAndServe(host, mux)
+		gwlog.Error("IDIP adapter quited: %s", err)
+	}()
+}
+
+func serveIDIP(w http.ResponseWriter, r *http.Request, token string) {
+	if r.Method != http.MethodPost {
+		writeResponse(w, http.StatusMethodNotAllowed, &Response{Code: IDIP_CODE_BAD_REQUEST, Msg: "POST only"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get(IDIP_TOKEN_HEADER)), []byte(token)) != 1 {
+		gwlog.Warn("idip: unauthorized request from %s", r.RemoteAddr)
+		writeResponse(w, http.StatusUnauthorized, &Response{Code: IDIP_CODE_UNAUTHORIZED, Msg: "unauthorized"})
+		return
+	}
+
+	var req Request
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, _IDIP_MAX_REQUEST_SIZE))
+	if err == nil {
+		err = json.Unmarshal(body, &req)
+	}
+	if err == nil {
+		err = validateRequest(&req)
+	}
+	if err != nil {
+		writeResponse(w, http.StatusBadRequest, &Response{RequestID: req.RequestID, Code: IDIP_CODE_BAD_REQUEST, Msg: err.Error()})
+		return
+	}
+
+	respChan := make(chan *Response, 1)
+	// entities can only be accessed in the game routine
+	post.Post(func() {
+		handleRequest(&req, func(resp *Response) {
+			respChan <- resp
+		})
+	})
+
+	select {
+	case resp := <-respChan:
+		writeResponse(w, http.StatusOK, resp)
+	case <-time.After(consts.IDIP_REQUEST_TIMEOUT):
+		writeResponse(w, http.StatusOK, &Response{RequestID: req.RequestID, Code: IDIP_CODE_TIMEOUT, Msg: "timeout"})
+	}
+}
+
+func validateRequest(req *Request) error {
+	if req.RequestID == "" {
+		return errors.New("RequestID is required")
+	}
+	if _, ok := commands[req.Cmd]; !ok {
+		return errors.Errorf("unknown command: %s", req.Cmd)
+	}
+	if len(req.PlayerID) != common.ENTITYID_LENGTH {
+		return errors.Errorf("invalid PlayerID: %s", req.PlayerID)
+	}
+	if req.Params == nil {
+		req.Params = map[string]interface{}{}
+	}
+	return nil
+}
+
+func writeResponse(w http.ResponseWriter, status int, resp *Response) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// Handle the request in game routine, done is called with the response
+func handleRequest(req *Request, done func(resp *Response)) {
+	if inProgressRequests[req.RequestID] {
+		done(&Response{RequestID: req.RequestID, Code: IDIP_CODE_IN_PROGRESS, Msg: "in progress"})
+		return
+	}
+
+	inProgressRequests[req.RequestID] = true
+	finish := func(resp *Response) {
+		delete(inProgressRequests, req.RequestID)
+		audit(req, resp)
+		done(resp)
+	}
+
+	resultKey := _IDIP_RESULT_KVDB_KEY_PREFIX + req.RequestID
+	kvdb.Get(resultKey, func(val string, err error) {
+		if err != nil {
+			finish(&Response{RequestID: req.RequestID, Code: IDIP_CODE_INTERNAL_ERROR, Msg: err.Error()})
+			return
+		}
+
+		if val != "" {
+			var resp Response
+			if err := json.Unmarshal([]byte(val), &resp); err != nil {
+				finish(&Response{RequestID: req.RequestID, Code: IDIP_CODE_INTERNAL_ERROR, Msg: err.Error()})
+				return
+			}
+			resp.Duplicate = true
+			finish(&resp)
+			return
+		}
+
+		callPlayer(req, func(resp *Response) {
+			if resp.Code == IDIP_CODE_TIMEOUT {
+				finish(resp)
+				return
+			}
+
+			data, _ := json.Marshal(resp)
+			kvdb.Put(resultKey, string(data), func(err error) {
+				if err != nil {
+					gwlog.Error("idip: save result of %s failed: %s", req.RequestID, err)
+				}
+				finish(resp)
+			})
+		})
+	})
+}
+
+func callPlayer(req *Request, done func(resp *Response)) {
+	if playerType != "" && entity.GetEntity(req.PlayerID) == nil {
+		entity.LoadEntityAnywhere(playerType, req.PlayerID) // calls are queued by dispatcher until the player is loaded
+	}
+
+	req.Params["RequestID"] = req.RequestID
+	method := commands[req.Cmd]
+	entity.CallWithResult(req.PlayerID, method, req.Params).Then(func(results entity.RpcResults, err error) {
+		resp := &Response{RequestID: req.RequestID}
+		if err == nil && results.Len() > 0 {
+			err = results.Decode(&resp.Data)
+		}
+
+		if err == entity.ErrRpcTimeout {
+			resp.Code, resp.Msg = IDIP_CODE_TIMEOUT, err.Error()
+		} else if err != nil {
+			resp.Code, resp.Msg = IDIP_CODE_FAILED, err.Error()
+		}
+		done(resp)
+	})
+}
+
+func audit(req *Request, resp *Response) {
+	params, _ := json.Marshal(req.Params)
+	gwlog.Info("idip: AUDIT request=%s cmd=%s player=%s operator=%s params=%s code=%d msg=%q duplicate=%v",
+		req.RequestID, req.Cmd, req.PlayerID, req.Operator, params, resp.Code, resp.Msg, resp.Duplicate)
+}
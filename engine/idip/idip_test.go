@@ -0,0 +1,52 @@
+package idip
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postIDIP(token string, body string) (*httptest.ResponseRecorder, *Response) {
+	r := httptest.NewRequest(http.MethodPost, "/idip", strings.NewReader(body))
+	r.Header.Set(IDIP_TOKEN_HEADER, token)
+	w := httptest.NewRecorder()
+	serveIDIP(w, r, "secret")
+
+	var resp Response
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	return w, &resp
+}
+
+func TestUnauthorized(t *testing.T) {
+	w, resp := postIDIP("wrong", `{"RequestID": "1", "Cmd": "ban", "PlayerID": "WV2BXr5TW2Eeb2NO"}`)
+	if w.Code != http.StatusUnauthorized || resp.Code != IDIP_CODE_UNAUTHORIZED {
+		t.Errorf("should be unauthorized: %d %+v", w.Code, resp)
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	for _, body := range []string{
+		`not json`,
+		`{"Cmd": "ban", "PlayerID": "WV2BXr5TW2Eeb2NO"}`,
+		`{"RequestID": "1", "Cmd": "unknown", "PlayerID": "WV2BXr5TW2Eeb2NO"}`,
+		`{"RequestID": "1", "Cmd": "ban", "PlayerID": "short"}`,
+	} {
+		w, resp := postIDIP("secret", body)
+		if w.Code != http.StatusBadRequest || resp.Code != IDIP_CODE_BAD_REQUEST {
+			t.Errorf("should be bad request: %s: %d %+v", body, w.Code, resp)
+		}
+	}
+}
+
+func TestRegisterCommand(t *testing.T) {
+	RegisterCommand("kick", "IDIPKick")
+	req := Request{RequestID: "1", Cmd: "kick", PlayerID: "WV2BXr5TW2Eeb2NO"}
+	if err := validateRequest(&req); err != nil {
+		t.Fatal(err)
+	}
+	if req.Params == nil {
+		t.Errorf("params should be initialized")
+	}
+}
@@ -0,0 +1,175 @@
+package kvdb_bolt
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/kvdb/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	OPEN_TIMEOUT = time.Second * 5 // the database file is locked by the process opening it
+)
+
+var (
+	kvBucket = []byte("__kv__")
+)
+
+// KVDB backed by bbolt, an embedded key-value database in pure Go
+//
+// The database file can only be opened by one process, so the KVDB only works for deployments with one game. Since
+// all changes are made by this process, changes are notified after they are committed.
+type boltKVDB struct {
+	db *bolt.DB
+
+	notifyLock sync.Mutex
+	notify     func(item KVItem)
+}
+
+func OpenBoltKVDB(path string) (KVDBEngine, error) {
+	gwlog.Debug("Opening bolt database %s ...", path)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: OPEN_TIMEOUT})
+	if err != nil {
+		return nil, errors.Wrapf(err, "bolt open %s failed", path)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(kvBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltKVDB{
+		db: db,
+	}, nil
+}
+
+func (kvdb *boltKVDB) Get(key string) (val string, err error) {
+	err = kvdb.db.View(func(tx *bolt.Tx) error {
+		val = string(tx.Bucket(kvBucket).Get([]byte(key)))
+		return nil
+	})
+	return
+}
+
+func (kvdb *boltKVDB) Put(key string, val string) error {
+	err := kvdb.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(kvBucket).Put([]byte(key), []byte(val))
+	})
+	if err == nil {
+		kvdb.notifyChanged(KVItem{key, val})
+	}
+	return err
+}
+
+// Transact checks and puts keys in one transaction, so puts of multiple keys are atomic
+func (kvdb *boltKVDB) Transact(checks []KVItem, puts []KVItem) (ok bool, err error) {
+	err = kvdb.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(kvBucket)
+		for _, item := range checks {
+			if string(bucket.Get([]byte(item.Key))) != item.Val {
+				return nil
+			}
+		}
+
+		for _, item := range puts {
+			if err := bucket.Put([]byte(item.Key), []byte(item.Val)); err != nil {
+				return err
+			}
+		}
+		ok = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if ok {
+		for _, item := range puts {
+			kvdb.notifyChanged(item)
+		}
+	}
+	return
+}
+
+func (kvdb *boltKVDB) Incr(key string, delta int64) (val int64, err error) {
+	err = kvdb.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(kvBucket)
+		if old := bucket.Get([]byte(key)); old != nil {
+			oldVal, err := strconv.ParseInt(string(old), 10, 64)
+			if err != nil {
+				return errors.Wrapf(err, "value of key %s is not integer", key)
+			}
+			val = oldVal
+		}
+		val += delta
+		return bucket.Put([]byte(key), []byte(strconv.FormatInt(val, 10)))
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	kvdb.notifyChanged(KVItem{key, strconv.FormatInt(val, 10)})
+	return
+}
+
+// SetNotifyCallback sets the callback called when keys are changed
+func (kvdb *boltKVDB) SetNotifyCallback(cb func(item KVItem)) {
+	kvdb.notifyLock.Lock()
+	kvdb.notify = cb
+	kvdb.notifyLock.Unlock()
+}
+
+func (kvdb *boltKVDB) notifyChanged(item KVItem) {
+	kvdb.notifyLock.Lock()
+	cb := kvdb.notify
+	kvdb.notifyLock.Unlock()
+	if cb != nil {
+		cb(item)
+	}
+}
+
+type boltKVDBIterator struct {
+	items []KVItem
+}
+
+func (it *boltKVDBIterator) Next() (KVItem, error) {
+	if len(it.items) == 0 {
+		return KVItem{}, io.EOF
+	}
+
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, nil
+}
+
+// Find items with keys in [beginKey, endKey), items are read in one transaction
+func (kvdb *boltKVDB) Find(beginKey string, endKey string) Iterator {
+	it := &boltKVDBIterator{}
+	kvdb.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(kvBucket).Cursor()
+		end := []byte(endKey)
+		for k, v := c.Seek([]byte(beginKey)); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			it.items = append(it.items, KVItem{string(k), string(v)})
+		}
+		return nil
+	})
+	return it
+}
+
+func (kvdb *boltKVDB) Close() {
+	kvdb.db.Close()
+}
+
+func (kvdb *boltKVDB) IsEOF(err error) bool {
+	return false
+}
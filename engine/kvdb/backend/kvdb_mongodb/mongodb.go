@@ -4,7 +4,9 @@ import (
 	"gopkg.in/mgo.v2"
 
 	"io"
+	"strconv"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	. "github.com/xiaonanln/goworld/engine/kvdb/types"
 	"gopkg.in/mgo.v2/bson"
@@ -13,6 +15,8 @@ import (
 const (
 	DEFAULT_DB_NAME = "goworld"
 	VAL_KEY         = "_"
+
+	_INCR_MAX_RETRIES = 100
 )
 
 type MongoKVDB struct {
@@ -61,6 +65,63 @@ func (kvdb *MongoKVDB) Get(key string) (val string, err error) {
 	return
 }
 
+// Transact of MongoDB KVDB only supports compare-and-set of a single key, since MongoDB updates are only atomic
+// on single documents
+func (kvdb *MongoKVDB) Transact(checks []KVItem, puts []KVItem) (bool, error) {
+	if len(puts) != 1 || len(checks) > 1 || (len(checks) == 1 && checks[0].Key != puts[0].Key) {
+		return false, errors.Errorf("mongodb kvdb only supports transactions of single key")
+	}
+
+	put := puts[0]
+	if len(checks) == 0 {
+		return true, kvdb.Put(put.Key, put.Val)
+	}
+
+	oldVal := checks[0].Val
+	err := kvdb.c.Update(bson.M{"_id": put.Key, VAL_KEY: oldVal}, bson.M{"$set": bson.M{VAL_KEY: put.Val}})
+	if err == nil {
+		return true, nil
+	} else if err != mgo.ErrNotFound {
+		return false, err
+	} else if oldVal != "" {
+		return false, nil
+	}
+
+	// keys not exist are also checked as empty
+	err = kvdb.c.Insert(bson.M{"_id": put.Key, VAL_KEY: put.Val})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Incr of MongoDB KVDB is implemented by compare-and-set since values are saved as strings, and retried if the
+// value is changed by others in the meantime
+func (kvdb *MongoKVDB) Incr(key string, delta int64) (int64, error) {
+	for i := 0; i < _INCR_MAX_RETRIES; i++ {
+		oldVal, err := kvdb.Get(key)
+		if err != nil {
+			return 0, err
+		}
+
+		var val int64
+		if oldVal != "" {
+			if val, err = strconv.ParseInt(oldVal, 10, 64); err != nil {
+				return 0, errors.Wrapf(err, "value of %s is not an integer", key)
+			}
+		}
+		val += delta
+
+		ok, err := kvdb.Transact([]KVItem{{key, oldVal}}, []KVItem{{key, strconv.FormatInt(val, 10)}})
+		if err != nil {
+			return 0, err
+		} else if ok {
+			return val, nil
+		}
+	}
+	return 0, errors.Errorf("incr %s failed: too many conflicts", key)
+}
+
 type MongoKVIterator struct {
 	it *mgo.Iter
 }
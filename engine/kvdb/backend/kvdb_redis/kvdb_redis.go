@@ -1,7 +1,11 @@
 package kvdb_redis
 
 import (
+	"bytes"
 	"io"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/google/btree"
@@ -17,6 +21,12 @@ const (
 type redisKVDB struct {
 	c       redis.Conn
 	keyTree *btree.BTree
+	host    string
+	dbindex int
+
+	subLock sync.Mutex
+	subConn redis.Conn // connection subscribing changes, nil if not subscribing
+	closed  bool
 }
 
 type keyTreeItem struct {
@@ -36,6 +46,8 @@ func OpenRedisKVDB(host string, dbindex int) (KVDBEngine, error) {
 	db := &redisKVDB{
 		c:       c,
 		keyTree: btree.New(2),
+		host:    host,
+		dbindex: dbindex,
 	}
 	if err := db.initialize(dbindex); err != nil {
 		panic(errors.Wrap(err, "redis kvdb initialize failed"))
@@ -91,13 +103,134 @@ func (db *redisKVDB) Get(key string) (val string, err error) {
 }
 
 func (db *redisKVDB) Put(key string, val string) error {
-	_, err := db.c.Do("SET", keyPrefix+key, val)
+	db.c.Send("MULTI")
+	db.c.Send("SET", keyPrefix+key, val)
+	db.c.Send("PUBLISH", db.notifyChannel(), notifyMessage(key, val))
+	_, err := db.c.Do("EXEC")
 	if err != nil {
 		db.keyTree.ReplaceOrInsert(keyTreeItem{key})
 	}
 	return err
 }
 
+// Transact applies puts only if values of keys in checks are not changed, using WATCH & MULTI of redis
+func (db *redisKVDB) Transact(checks []KVItem, puts []KVItem) (bool, error) {
+	if len(checks) > 0 {
+		keys := make([]interface{}, len(checks))
+		for i, item := range checks {
+			keys[i] = keyPrefix + item.Key
+		}
+		if _, err := db.c.Do("WATCH", keys...); err != nil {
+			return false, err
+		}
+
+		for _, item := range checks {
+			val, err := db.Get(item.Key)
+			if err == nil && val == item.Val {
+				continue
+			}
+			if _, err := db.c.Do("UNWATCH"); err != nil {
+				return false, err
+			}
+			return false, err
+		}
+	}
+
+	if err := db.c.Send("MULTI"); err != nil {
+		return false, err
+	}
+	for _, item := range puts {
+		if err := db.c.Send("SET", keyPrefix+item.Key, item.Val); err != nil {
+			return false, err
+		}
+		if err := db.c.Send("PUBLISH", db.notifyChannel(), notifyMessage(item.Key, item.Val)); err != nil {
+			return false, err
+		}
+	}
+	r, err := db.c.Do("EXEC")
+	if err != nil {
+		return false, err
+	}
+	if r == nil { // watched keys are changed by others
+		return false, nil
+	}
+
+	for _, item := range puts {
+		db.keyTree.ReplaceOrInsert(keyTreeItem{item.Key})
+	}
+	return true, nil
+}
+
+// Incr increases the value of key atomically using INCRBY of redis
+func (db *redisKVDB) Incr(key string, delta int64) (int64, error) {
+	val, err := redis.Int64(db.c.Do("INCRBY", keyPrefix+key, delta))
+	if err != nil {
+		return 0, err
+	}
+
+	db.keyTree.ReplaceOrInsert(keyTreeItem{key})
+	_, err = db.c.Do("PUBLISH", db.notifyChannel(), notifyMessage(key, strconv.FormatInt(val, 10)))
+	return val, err
+}
+
+// Changes are published to the channel of the db when keys are put, so that all games are notified
+func (db *redisKVDB) notifyChannel() string {
+	return keyPrefix + "NOTIFY_" + strconv.Itoa(db.dbindex)
+}
+
+func notifyMessage(key string, val string) string {
+	return key + "\x00" + val
+}
+
+// SetNotifyCallback subscribes changes of keys in another connection, changes are missed when reconnecting
+func (db *redisKVDB) SetNotifyCallback(cb func(item KVItem)) {
+	go db.subscribeRoutine(cb)
+}
+
+func (db *redisKVDB) subscribeRoutine(cb func(item KVItem)) {
+	for {
+		c, err := redis.Dial("tcp", db.host)
+		if err == nil {
+			db.subLock.Lock()
+			if db.closed {
+				db.subLock.Unlock()
+				c.Close()
+				return
+			}
+			db.subConn = c
+			db.subLock.Unlock()
+			db.receiveNotify(c, cb)
+		}
+
+		db.subLock.Lock()
+		closed := db.closed
+		db.subConn = nil
+		db.subLock.Unlock()
+		if closed {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+func (db *redisKVDB) receiveNotify(c redis.Conn, cb func(item KVItem)) {
+	defer c.Close()
+	psc := redis.PubSubConn{Conn: c}
+	if err := psc.Subscribe(db.notifyChannel()); err != nil {
+		return
+	}
+	for {
+		switch msg := psc.Receive().(type) {
+		case redis.Message:
+			if i := bytes.IndexByte(msg.Data, 0); i >= 0 {
+				cb(KVItem{Key: string(msg.Data[:i]), Val: string(msg.Data[i+1:])})
+			}
+		case error:
+			return
+		}
+	}
+}
+
 type redisKVDBIterator struct {
 	db       *redisKVDB
 	leftKeys []string
@@ -133,6 +266,13 @@ func (db *redisKVDB) Find(beginKey string, endKey string) Iterator {
 
 func (db *redisKVDB) Close() {
 	db.c.Close()
+
+	db.subLock.Lock()
+	db.closed = true
+	if db.subConn != nil {
+		db.subConn.Close()
+	}
+	db.subLock.Unlock()
 }
 
 func (db *redisKVDB) IsEOF(err error) bool {
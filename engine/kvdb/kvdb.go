@@ -8,8 +8,10 @@ import (
 	"strconv"
 
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
+	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_bolt"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_redis"
 	. "github.com/xiaonanln/goworld/engine/kvdb/types"
@@ -21,11 +23,15 @@ var (
 	kvdbEngine     KVDBEngine
 	kvdbOpQueue    *xnsyncutil.SyncQueue
 	kvdbTerminated *xnsyncutil.OneTimeCond
+
+	logger = gwlog.Module("kvdb")
 )
 
 type KVDBGetCallback func(val string, err error)
 type KVDBPutCallback func(err error)
 type KVDBGetRangeCallback func(items []KVItem, err error)
+type KVDBTransactCallback func(ok bool, err error) // ok is false if any check fails
+type KVDBIncrCallback func(val int64, err error)
 
 // Initialize the KVDB
 //
@@ -36,7 +42,7 @@ func Initialize() {
 		return
 	}
 
-	gwlog.Info("KVDB initializing, config:\n%s", config.DumpPretty(kvdbCfg))
+	logger.Info("KVDB initializing, config:\n%s", config.DumpPretty(kvdbCfg))
 	kvdbOpQueue = xnsyncutil.NewSyncQueue()
 	kvdbTerminated = xnsyncutil.NewOneTimeCond()
 
@@ -59,8 +65,13 @@ func assureKVDBEngineReady() (err error) {
 		if err == nil {
 			kvdbEngine, err = kvdb_redis.OpenRedisKVDB(kvdbCfg.Host, dbindex)
 		}
+	} else if kvdbCfg.Type == "bolt" {
+		kvdbEngine, err = kvdb_bolt.OpenBoltKVDB(kvdbCfg.Url)
 	} else {
-		gwlog.Fatal("KVDB type %s is not implemented", kvdbCfg.Type)
+		logger.Fatal("KVDB type %s is not implemented", kvdbCfg.Type)
+	}
+	if kvdbEngine != nil {
+		setupNotify()
 	}
 	return
 }
@@ -79,30 +90,81 @@ type putReq struct {
 type getRangeReq struct {
 	beginKey string
 	endKey   string
+	prefix   string // namespace prefix of keys, which is removed from keys of items
 	callback KVDBGetRangeCallback
 }
 
+type transactReq struct {
+	checks   []KVItem
+	puts     []KVItem
+	callback KVDBTransactCallback
+}
+
+type incrReq struct {
+	key      string
+	delta    int64
+	callback KVDBIncrCallback
+}
+
+// Keys are prefixed by the namespace of game transparently, so games of different namespaces never see keys of others
+
 func Get(key string, callback KVDBGetCallback) {
 	kvdbOpQueue.Push(&getReq{
-		key, callback,
+		common.GetLocalNamespace().KeyPrefix() + key, callback,
 	})
 	checkOperationQueueLen()
 }
 
 func Put(key string, val string, callback KVDBPutCallback) {
 	kvdbOpQueue.Push(&putReq{
-		key, val, callback,
+		common.GetLocalNamespace().KeyPrefix() + key, val, callback,
 	})
 	checkOperationQueueLen()
 }
 
 func GetRange(beginKey string, endKey string, callback KVDBGetRangeCallback) {
+	prefix := common.GetLocalNamespace().KeyPrefix()
 	kvdbOpQueue.Push(&getRangeReq{
-		beginKey, endKey, callback,
+		prefix + beginKey, prefix + endKey, prefix, callback,
+	})
+	checkOperationQueueLen()
+}
+
+// Compare-and-set the value of key to newVal if the current value is oldVal, keys not exist have empty values
+func CAS(key string, oldVal string, newVal string, callback KVDBTransactCallback) {
+	Transact([]KVItem{{key, oldVal}}, []KVItem{{key, newVal}}, callback)
+}
+
+// Put values of multiple keys atomically if the current values of all keys in checks equal to the expected values,
+// e.g. transfer currency between two entities without races across games
+//
+// Multi-key transactions are only supported by redis KVDB, mongodb KVDB only supports CAS of a single key.
+func Transact(checks []KVItem, puts []KVItem, callback KVDBTransactCallback) {
+	prefix := common.GetLocalNamespace().KeyPrefix()
+	kvdbOpQueue.Push(&transactReq{
+		prefixKeys(prefix, checks), prefixKeys(prefix, puts), callback,
 	})
 	checkOperationQueueLen()
 }
 
+// Increase the integer value of key by delta atomically, keys not exist are treated as 0
+//
+// The callback is called with the value after increment, useful for global counters and ID sequences across games
+func Incr(key string, delta int64, callback KVDBIncrCallback) {
+	kvdbOpQueue.Push(&incrReq{
+		common.GetLocalNamespace().KeyPrefix() + key, delta, callback,
+	})
+	checkOperationQueueLen()
+}
+
+func prefixKeys(prefix string, items []KVItem) []KVItem {
+	prefixed := make([]KVItem, len(items))
+	for i, item := range items {
+		prefixed[i] = KVItem{prefix + item.Key, item.Val}
+	}
+	return prefixed
+}
+
 func NextLargerKey(key string) string {
 	return key + "\x00" // the next string that is larger than key, but smaller than any other keys > key
 }
@@ -116,7 +178,7 @@ var recentWarnedQueueLen = 0
 func checkOperationQueueLen() {
 	qlen := kvdbOpQueue.Len()
 	if qlen > 100 && qlen%100 == 0 && recentWarnedQueueLen != qlen {
-		gwlog.Warn("KVDB operation queue length = %d", qlen)
+		logger.Warn("KVDB operation queue length = %d", qlen)
 		recentWarnedQueueLen = qlen
 	}
 }
@@ -125,7 +187,7 @@ func kvdbRoutine() {
 	for {
 		err := assureKVDBEngineReady()
 		if err != nil {
-			gwlog.Error("KVDB engine is not ready: %s", err)
+			logger.Error("KVDB engine is not ready: %s", err)
 			time.Sleep(time.Second)
 			continue
 		}
@@ -146,6 +208,12 @@ func kvdbRoutine() {
 		} else if getRangeReq, ok := req.(*getRangeReq); ok {
 			op = opmon.StartOperation("kvdb.getRange")
 			handleGetRangeReq(getRangeReq)
+		} else if transactReq, ok := req.(*transactReq); ok {
+			op = opmon.StartOperation("kvdb.transact")
+			handleTransactReq(transactReq)
+		} else if incrReq, ok := req.(*incrReq); ok {
+			op = opmon.StartOperation("kvdb.incr")
+			handleIncrReq(incrReq)
 		}
 		op.Finish(time.Millisecond * 100)
 	}
@@ -203,6 +271,7 @@ func handleGetRangeReq(getRangeReq *getRangeReq) {
 			return
 		}
 
+		item.Key = item.Key[len(getRangeReq.prefix):]
 		items = append(items, item)
 	}
 
@@ -212,3 +281,31 @@ func handleGetRangeReq(getRangeReq *getRangeReq) {
 		})
 	}
 }
+
+func handleTransactReq(transactReq *transactReq) {
+	ok, err := kvdbEngine.Transact(transactReq.checks, transactReq.puts)
+	if transactReq.callback != nil {
+		post.Post(func() {
+			transactReq.callback(ok, err)
+		})
+	}
+
+	if err != nil && kvdbEngine.IsEOF(err) {
+		kvdbEngine.Close()
+		kvdbEngine = nil
+	}
+}
+
+func handleIncrReq(incrReq *incrReq) {
+	val, err := kvdbEngine.Incr(incrReq.key, incrReq.delta)
+	if incrReq.callback != nil {
+		post.Post(func() {
+			incrReq.callback(val, err)
+		})
+	}
+
+	if err != nil && kvdbEngine.IsEOF(err) {
+		kvdbEngine.Close()
+		kvdbEngine = nil
+	}
+}
@@ -1,13 +1,16 @@
 package kvdb
 
 import (
+	"io/ioutil"
 	"math/rand"
+	"path/filepath"
 	"strconv"
 	"testing"
 
 	"fmt"
 	"io"
 
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_bolt"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_redis"
 	. "github.com/xiaonanln/goworld/engine/kvdb/types"
@@ -21,6 +24,10 @@ func TestRedisBackend_Set(t *testing.T) {
 	testKVDBBackend_Set(t, openTestRedisKVDB(t))
 }
 
+func TestBoltBackend_Set(t *testing.T) {
+	testKVDBBackend_Set(t, openTestBoltKVDB(t))
+}
+
 func testKVDBBackend_Set(t *testing.T, kvdb KVDBEngine) {
 	val, err := kvdb.Get("__key_not_exists__")
 	if err != nil || val != "" {
@@ -55,6 +62,10 @@ func TestRedisBackend_Find(t *testing.T) {
 	testBackend_Find(t, openTestRedisKVDB(t))
 }
 
+func TestBoltBackend_Find(t *testing.T) {
+	testBackend_Find(t, openTestBoltKVDB(t))
+}
+
 func testBackend_Find(t *testing.T, kvdb KVDBEngine) {
 	beginKey := strconv.Itoa(1000 + rand.Intn(2000-1000))
 	if len(beginKey) != 4 {
@@ -106,6 +117,84 @@ func testBackend_Find(t *testing.T, kvdb KVDBEngine) {
 	}
 }
 
+func TestMongoBackend_CAS(t *testing.T) {
+	testBackend_CAS(t, openTestMongoKVDB(t))
+}
+
+func TestRedisBackend_CAS(t *testing.T) {
+	testBackend_CAS(t, openTestRedisKVDB(t))
+}
+
+func TestBoltBackend_CAS(t *testing.T) {
+	testBackend_CAS(t, openTestBoltKVDB(t))
+}
+
+func testBackend_CAS(t *testing.T, kvdb KVDBEngine) {
+	key := "__cas_key_" + strconv.Itoa(rand.Int())
+	if ok, err := kvdb.Transact([]KVItem{{key, ""}}, []KVItem{{key, "1"}}); err != nil || !ok {
+		t.Fatalf("CAS of key not exists failed: ok=%v, err=%v", ok, err)
+	}
+	if ok, err := kvdb.Transact([]KVItem{{key, ""}}, []KVItem{{key, "2"}}); err != nil || ok {
+		t.Fatalf("CAS with wrong old value should fail: ok=%v, err=%v", ok, err)
+	}
+	if ok, err := kvdb.Transact([]KVItem{{key, "1"}}, []KVItem{{key, "2"}}); err != nil || !ok {
+		t.Fatalf("CAS failed: ok=%v, err=%v", ok, err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "2" {
+		t.Errorf("value should be 2 after CAS, but is %s, err=%v", val, err)
+	}
+}
+
+func TestMongoBackend_Incr(t *testing.T) {
+	testBackend_Incr(t, openTestMongoKVDB(t))
+}
+
+func TestRedisBackend_Incr(t *testing.T) {
+	testBackend_Incr(t, openTestRedisKVDB(t))
+}
+
+func TestBoltBackend_Incr(t *testing.T) {
+	testBackend_Incr(t, openTestBoltKVDB(t))
+}
+
+func testBackend_Incr(t *testing.T, kvdb KVDBEngine) {
+	key := "__incr_key_" + strconv.Itoa(rand.Int())
+	if val, err := kvdb.Incr(key, 5); err != nil || val != 5 {
+		t.Fatalf("incr key not exists: val=%d, err=%v", val, err)
+	}
+	if val, err := kvdb.Incr(key, -2); err != nil || val != 3 {
+		t.Fatalf("incr: val=%d, err=%v", val, err)
+	}
+	if val, err := kvdb.Get(key); err != nil || val != "3" {
+		t.Errorf("value should be 3 after incr, but is %s, err=%v", val, err)
+	}
+}
+
+func TestRedisBackend_Transact(t *testing.T) {
+	testBackend_Transact(t, openTestRedisKVDB(t))
+}
+
+func TestBoltBackend_Transact(t *testing.T) {
+	testBackend_Transact(t, openTestBoltKVDB(t))
+}
+
+func testBackend_Transact(t *testing.T, kvdb KVDBEngine) {
+	key1, key2 := "__transact_key1_"+strconv.Itoa(rand.Int()), "__transact_key2_"+strconv.Itoa(rand.Int())
+	kvdb.Put(key1, "100")
+
+	if ok, err := kvdb.Transact([]KVItem{{key1, "100"}, {key2, "1"}}, []KVItem{{key1, "90"}, {key2, "10"}}); err != nil || ok {
+		t.Fatalf("transaction with failed checks should fail: ok=%v, err=%v", ok, err)
+	}
+	if ok, err := kvdb.Transact([]KVItem{{key1, "100"}, {key2, ""}}, []KVItem{{key1, "90"}, {key2, "10"}}); err != nil || !ok {
+		t.Fatalf("transaction failed: ok=%v, err=%v", ok, err)
+	}
+	val1, _ := kvdb.Get(key1)
+	val2, _ := kvdb.Get(key2)
+	if val1 != "90" || val2 != "10" {
+		t.Errorf("values should be 90 & 10 after transaction, but are %s & %s", val1, val2)
+	}
+}
+
 func BenchmarkMongoBackend_GetSet(b *testing.B) {
 	benchmarkBackend_GetSet(b, openTestMongoKVDB(b))
 }
@@ -185,3 +274,15 @@ func openTestRedisKVDB(f _Fataler) KVDBEngine {
 	}
 	return kvdb
 }
+
+func openTestBoltKVDB(f _Fataler) KVDBEngine {
+	dir, err := ioutil.TempDir("", "kvdb_bolt")
+	if err != nil {
+		f.Fatal(err)
+	}
+	kvdb, err := kvdb_bolt.OpenBoltKVDB(filepath.Join(dir, "kv.db"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	return kvdb
+}
@@ -4,10 +4,19 @@ type KVDBEngine interface {
 	Get(key string) (val string, err error)
 	Put(key string, val string) (err error)
 	Find(beginKey string, endKey string) Iterator
+	Transact(checks []KVItem, puts []KVItem) (ok bool, err error) // puts are applied atomically only if all checks pass
+	Incr(key string, delta int64) (val int64, err error)          // values are saved as decimal strings
 	Close()
 	IsEOF(err error) bool
 }
 
+// Optional interface for KVDB engines which can push changes of keys made by all games
+//
+// The callback is called in other goroutines with keys and new values
+type Notifier interface {
+	SetNotifyCallback(cb func(item KVItem))
+}
+
 // Interface for iterators for KVDB
 //
 // Next should returns the next item with error=nil whenever has next item
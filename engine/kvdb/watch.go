@@ -0,0 +1,128 @@
+package kvdb
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	. "github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// Watchers are notified of changes of keys with prefixes made by all games (including this game), e.g. global event
+// flags and config toggles.
+//
+// Changes are pushed by KVDB engines implementing Notifier (redis), and polled from other engines (mongodb), in which
+// case only the latest value is notified if a key is changed for multiple times in a poll interval.
+
+const (
+	_WATCH_POLL_INTERVAL = time.Second * 5
+)
+
+var (
+	watchers         = map[WatchHandle]*watcher{}
+	nextWatchHandle  = WatchHandle(1)
+	watchPollStarted = false
+	notifySupported  int32 // set if the KVDB engine pushes changes
+)
+
+type KVDBWatchCallback func(key string, val string)
+
+type WatchHandle int // Return value of Watch, can be used to unwatch
+
+type watcher struct {
+	prefix   string
+	callback KVDBWatchCallback
+	vals     map[string]string // values of last poll, nil before the first poll
+}
+
+// Watch changes of keys with the prefix, the callback is called in the game routine with the new value
+func Watch(prefix string, callback KVDBWatchCallback) WatchHandle {
+	if prefix == "" {
+		gwlog.Panicf("kvdb.Watch: prefix is empty")
+	}
+
+	h := nextWatchHandle
+	nextWatchHandle += 1
+	w := &watcher{prefix: prefix, callback: callback}
+	watchers[h] = w
+
+	if atomic.LoadInt32(&notifySupported) == 0 {
+		w.poll() // load the values to compare with
+	}
+	if !watchPollStarted {
+		watchPollStarted = true
+		timer.AddTimer(_WATCH_POLL_INTERVAL, pollWatchers)
+	}
+	return h
+}
+
+// Unwatch the watched handle
+func (h WatchHandle) Unwatch() {
+	delete(watchers, h)
+}
+
+// Called by KVDB routine when the engine is opened
+func setupNotify() {
+	if notifier, ok := kvdbEngine.(Notifier); ok {
+		notifier.SetNotifyCallback(func(item KVItem) {
+			post.Post(func() {
+				onKeyChanged(item)
+			})
+		})
+		atomic.StoreInt32(&notifySupported, 1)
+	}
+}
+
+func onKeyChanged(item KVItem) {
+	prefix := common.GetLocalNamespace().KeyPrefix()
+	if !strings.HasPrefix(item.Key, prefix) {
+		return // key of other namespaces
+	}
+
+	key := item.Key[len(prefix):]
+	for _, w := range watchers {
+		if strings.HasPrefix(key, w.prefix) {
+			w.notify(key, item.Val)
+		}
+	}
+}
+
+func pollWatchers() {
+	if atomic.LoadInt32(&notifySupported) != 0 {
+		return
+	}
+
+	for _, w := range watchers {
+		w.poll()
+	}
+}
+
+func (w *watcher) poll() {
+	endKey := w.prefix[:len(w.prefix)-1] + string(w.prefix[len(w.prefix)-1]+1)
+	GetRange(w.prefix, endKey, func(items []KVItem, err error) {
+		if err != nil {
+			logger.TraceError("kvdb: poll watched keys of prefix %s failed: %s", w.prefix, err)
+			return
+		}
+
+		vals := make(map[string]string, len(items))
+		for _, item := range items {
+			vals[item.Key] = item.Val
+			if oldVal, ok := w.vals[item.Key]; w.vals != nil && (!ok || oldVal != item.Val) {
+				w.notify(item.Key, item.Val)
+			}
+		}
+		w.vals = vals
+	})
+}
+
+func (w *watcher) notify(key string, val string) {
+	gwutils.RunPanicless(func() {
+		w.callback(key, val)
+	})
+}
@@ -0,0 +1,403 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Metrics of engine modules (entity counts, RPC rates and latencies, dispatcher queues, storage operations, gate
+// connections, etc.) are exported in the Prometheus text format, which can be scraped by Prometheus directly:
+//
+//	GET /metrics
+//
+// /metrics is served by the pprof HTTP server of game, gate and dispatcher, and also by the metrics HTTP server
+// if metrics_port is configured. All metrics are safe to be updated in any goroutine.
+
+var (
+	// Default buckets of histograms for durations in seconds, from 100us to 10s
+	DefaultBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 5, 10}
+
+	registryLock sync.Mutex
+	registry     []collector
+	registered   = map[string]bool{}
+)
+
+type collector interface {
+	metricName() string
+	write(w *bufio.Writer)
+}
+
+func init() {
+	http.HandleFunc("/metrics", ServeHTTP)
+}
+
+func register(name string, c collector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if registered[name] {
+		gwlog.Panicf("metrics: metric %s is already registered", name)
+	}
+	registered[name] = true
+	registry = append(registry, c)
+}
+
+// Serve all metrics in the Prometheus text format
+func ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	WriteText(w)
+}
+
+// Write all metrics in the Prometheus text format
+func WriteText(w io.Writer) error {
+	registryLock.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryLock.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool {
+		return collectors[i].metricName() < collectors[j].metricName()
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, c := range collectors {
+		c.write(bw)
+	}
+	return bw.Flush()
+}
+
+// atomic float64 value
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		if atomic.CompareAndSwapUint64(&v.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (v *value) set(val float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(val))
+}
+
+func (v *value) get() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// Metric family with labels, children are created on first use of label values
+type family struct {
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+
+	lock     sync.RWMutex
+	children map[string]interface{} // joined label values -> child metric
+	newChild func() interface{}
+}
+
+func newFamily(name, help, typ string, labelNames []string, newChild func() interface{}) *family {
+	f := &family{
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		children:   map[string]interface{}{},
+		newChild:   newChild,
+	}
+	register(name, f)
+	return f
+}
+
+func (f *family) metricName() string {
+	return f.name
+}
+
+func (f *family) with(labelValues []string) interface{} {
+	if len(labelValues) != len(f.labelNames) {
+		gwlog.Panicf("metrics: %s has labels %v, but given values %v", f.name, f.labelNames, labelValues)
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	f.lock.RLock()
+	child := f.children[key]
+	f.lock.RUnlock()
+	if child != nil {
+		return child
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if child = f.children[key]; child == nil {
+		child = f.newChild()
+		f.children[key] = child
+	}
+	return child
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.lock.RLock()
+	keys := make([]string, 0, len(f.children))
+	for key := range f.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]interface{}, len(keys))
+	for i, key := range keys {
+		children[i] = f.children[key]
+	}
+	f.lock.RUnlock()
+
+	if len(keys) == 0 {
+		return // not used in this process
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.typ)
+	for i, key := range keys {
+		var labelValues []string
+		if len(f.labelNames) > 0 {
+			labelValues = strings.Split(key, "\xff")
+		}
+
+		switch child := children[i].(type) {
+		case *Counter:
+			writeSample(w, f.name, f.labelNames, labelValues, "", "", child.v.get())
+		case *Gauge:
+			writeSample(w, f.name, f.labelNames, labelValues, "", "", child.v.get())
+		case *Histogram:
+			child.write(w, f.name, f.labelNames, labelValues)
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labelNames []string, labelValues []string, extraLabel string, extraValue string, val float64) {
+	w.WriteString(name)
+	if len(labelNames) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", labelName, escapeLabelValue(labelValues[i]))
+		}
+		if extraLabel != "" {
+			if len(labelNames) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(val))
+	w.WriteByte('\n')
+}
+
+func formatFloat(val float64) string {
+	if math.IsInf(val, 1) {
+		return "+Inf"
+	} else if math.IsInf(val, -1) {
+		return "-Inf"
+	} else if math.IsNaN(val) {
+		return "NaN"
+	}
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer("\\", `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer("\\", `\\`, "\n", `\n`, "\"", `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabelValue(s string) string {
+	return labelValueEscaper.Replace(s)
+}
+
+// Counter only increases, e.g. number of calls
+type Counter struct {
+	v value
+}
+
+// Increase the counter by 1
+func (c *Counter) Inc() {
+	c.v.add(1)
+}
+
+// Increase the counter by delta, which should not be negative
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		gwlog.Panicf("metrics: counter can not decrease: %v", delta)
+	}
+	c.v.add(delta)
+}
+
+// Counters with labels
+type CounterVec struct {
+	f *family
+}
+
+// Create and register a counter without labels
+func NewCounter(name, help string) *Counter {
+	return NewCounterVec(name, help).With()
+}
+
+// Create and register counters with label names
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newFamily(name, help, "counter", labelNames, func() interface{} { return &Counter{} })}
+}
+
+// Get the counter of label values, which should be given in order of label names
+func (cv *CounterVec) With(labelValues ...string) *Counter {
+	return cv.f.with(labelValues).(*Counter)
+}
+
+// Gauge can go up and down, e.g. number of entities
+type Gauge struct {
+	v value
+}
+
+// Set the gauge to the value
+func (g *Gauge) Set(val float64) {
+	g.v.set(val)
+}
+
+// Increase the gauge by 1
+func (g *Gauge) Inc() {
+	g.v.add(1)
+}
+
+// Decrease the gauge by 1
+func (g *Gauge) Dec() {
+	g.v.add(-1)
+}
+
+// Add delta to the gauge
+func (g *Gauge) Add(delta float64) {
+	g.v.add(delta)
+}
+
+// Gauges with labels
+type GaugeVec struct {
+	f *family
+}
+
+// Create and register a gauge without labels
+func NewGauge(name, help string) *Gauge {
+	return NewGaugeVec(name, help).With()
+}
+
+// Create and register gauges with label names
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newFamily(name, help, "gauge", labelNames, func() interface{} { return &Gauge{} })}
+}
+
+// Get the gauge of label values, which should be given in order of label names
+func (gv *GaugeVec) With(labelValues ...string) *Gauge {
+	return gv.f.with(labelValues).(*Gauge)
+}
+
+// Gauge which is collected by calling the function when metrics are scraped, e.g. queue length
+//
+// The function is called in the HTTP goroutine, so it should only read values that are safe to be read concurrently
+type gaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// Create and register a gauge collected by the function
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(name, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (gf *gaugeFunc) metricName() string {
+	return gf.name
+}
+
+func (gf *gaugeFunc) write(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", gf.name, escapeHelp(gf.help), gf.name)
+	writeSample(w, gf.name, nil, nil, "", "", gf.fn())
+}
+
+// Histogram counts observed values in buckets, e.g. latencies
+type Histogram struct {
+	buckets []float64 // upper bounds in increasing order
+	counts  []uint64  // count of values in each bucket, the last one for +Inf
+	sum     value
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)+1),
+	}
+}
+
+// Observe the value
+func (h *Histogram) Observe(val float64) {
+	i := sort.SearchFloat64s(h.buckets, val) // the first bucket with upper bound >= val
+	atomic.AddUint64(&h.counts[i], 1)
+	h.sum.add(val)
+}
+
+// Observe the duration in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string, labelNames []string, labelValues []string) {
+	var cumulative uint64
+	for i, upperBound := range h.buckets {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		writeSample(w, name+"_bucket", labelNames, labelValues, "le", formatFloat(upperBound), float64(cumulative))
+	}
+	cumulative += atomic.LoadUint64(&h.counts[len(h.buckets)])
+	writeSample(w, name+"_bucket", labelNames, labelValues, "le", "+Inf", float64(cumulative))
+	writeSample(w, name+"_sum", labelNames, labelValues, "", "", h.sum.get())
+	writeSample(w, name+"_count", labelNames, labelValues, "", "", float64(cumulative))
+}
+
+// Histograms with labels
+type HistogramVec struct {
+	f *family
+}
+
+// Create and register a histogram without labels, DefaultBuckets is used if buckets is nil
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	return NewHistogramVec(name, help, buckets).With()
+}
+
+// Create and register histograms with label names, DefaultBuckets is used if buckets is nil
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		gwlog.Panicf("metrics: buckets of %s should be in increasing order: %v", name, buckets)
+	}
+	buckets = append([]float64(nil), buckets...)
+	return &HistogramVec{newFamily(name, help, "histogram", labelNames, func() interface{} { return newHistogram(buckets) })}
+}
+
+// Get the histogram of label values, which should be given in order of label names
+func (hv *HistogramVec) With(labelValues ...string) *Histogram {
+	return hv.f.with(labelValues).(*Histogram)
+}
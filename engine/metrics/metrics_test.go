@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	calls := NewCounterVec("test_calls_total", "Number of calls", "method")
+	calls.With("Login").Inc()
+	calls.With("Login").Add(2)
+	calls.With(`say "hi"`).Inc()
+
+	entities := NewGauge("test_entities", "Number of entities")
+	entities.Inc()
+	entities.Inc()
+	entities.Dec()
+
+	latency := NewHistogram("test_latency_seconds", "Latency", []float64{0.1, 1})
+	latency.ObserveDuration(time.Millisecond * 50)
+	latency.Observe(0.5)
+	latency.Observe(3)
+
+	NewGaugeFunc("test_queue_length", "Queue length", func() float64 { return 7 })
+	NewCounterVec("test_unused_total", "Not used", "method")
+
+	var buf bytes.Buffer
+	if err := WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	text := buf.String()
+
+	for _, line := range []string{
+		"# TYPE test_calls_total counter",
+		`test_calls_total{method="Login"} 3`,
+		`test_calls_total{method="say \"hi\""} 1`,
+		"# TYPE test_entities gauge",
+		"test_entities 1",
+		"# TYPE test_latency_seconds histogram",
+		`test_latency_seconds_bucket{le="0.1"} 1`,
+		`test_latency_seconds_bucket{le="1"} 2`,
+		`test_latency_seconds_bucket{le="+Inf"} 3`,
+		"test_latency_seconds_sum 3.55",
+		"test_latency_seconds_count 3",
+		"test_queue_length 7",
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("line not found: %s\n%s", line, text)
+		}
+	}
+	if strings.Contains(text, "test_unused_total") {
+		t.Errorf("unused metric should not be written:\n%s", text)
+	}
+}
+
+func TestLabelValuesMismatch(t *testing.T) {
+	calls := NewCounterVec("test_mismatch_total", "Mismatch", "type", "method")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("should panic")
+		}
+	}()
+	calls.With("Avatar")
+}
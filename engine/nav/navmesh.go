@@ -0,0 +1,528 @@
+package nav
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Navigation meshes in Recast/Detour binary formats:
+//
+//	navmesh set    multiple tiles saved by RecastDemo (magic "MSET"), with 32-bit or 64-bit tile refs
+//	tile data      one tile created by dtCreateNavMeshData (magic "DNAV")
+//
+// Only polygons are used for path finding, detail meshes and BV trees are skipped. Polygons with no flags are not
+// walkable, the same as the default filter of Detour, and off-mesh connections are not supported yet.
+
+const (
+	_NAVMESH_SET_MAGIC    = 'M'<<24 | 'S'<<16 | 'E'<<8 | 'T'
+	_NAVMESH_SET_VERSION  = 1
+	_NAVMESH_MAGIC        = 'D'<<24 | 'N'<<16 | 'A'<<8 | 'V'
+	_NAVMESH_VERSION      = 7
+	_NAVMESH_HEADER_SIZE  = 100
+	_NAVMESH_PARAMS_SIZE  = 28
+	_VERTS_PER_POLYGON    = 6
+	_POLY_SIZE            = 32
+	_DETAIL_MESH_SIZE     = 12
+	_BV_NODE_SIZE         = 16
+	_OFFMESH_CON_SIZE     = 36
+	_EXT_LINK             = 0x8000
+	_POLYTYPE_GROUND      = 0
+	_PORTAL_EPSILON       = 0.01
+	_DEFAULT_WALK_CLIMB   = 1
+	_QUERY_EXTENT_XZ      = 2 // half extents of searching the nearest polygon of points
+	_QUERY_EXTENT_Y       = 4
+	_GRID_POLYS_PER_CELL  = 4
+	_MAX_GRID_CELL_COUNTS = 1 << 20
+)
+
+// Point in navmesh coordinates, which are the same as space positions
+type Point struct {
+	X, Y, Z float32
+}
+
+// Navigation mesh of polygons
+type NavMesh struct {
+	verts []Point
+	polys []navPoly
+
+	bmin, bmax Point
+	cellSize   float32
+	cells      map[[2]int32][]int32 // grid cell -> polygons overlapping the cell
+}
+
+type navPoly struct {
+	verts      []int32 // indexes of mesh verts
+	links      []navLink
+	bmin, bmax Point
+	center     Point
+}
+
+// Link to neighbor polygon through the portal edge
+type navLink struct {
+	poly int32
+	a, b Point
+}
+
+// Load navmesh from file in Recast/Detour binary formats
+func Load(path string) (*NavMesh, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	mesh, err := Parse(data)
+	return mesh, errors.Wrapf(err, "load navmesh %s", path)
+}
+
+// Parse navmesh in Recast/Detour binary formats
+func Parse(data []byte) (*NavMesh, error) {
+	if len(data) < 4 {
+		return nil, errors.Errorf("navmesh too short: %d bytes", len(data))
+	}
+
+	builder := &meshBuilder{mesh: &NavMesh{}}
+	switch readInt32(data, 0) {
+	case _NAVMESH_MAGIC:
+		if err := builder.addTile(data); err != nil {
+			return nil, err
+		}
+	case _NAVMESH_SET_MAGIC:
+		if err := builder.addTileSet(data); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.Errorf("unknown navmesh magic: %x", data[:4])
+	}
+
+	builder.connectTiles()
+	builder.mesh.buildGrid()
+	return builder.mesh, nil
+}
+
+// Get the number of polygons of navmesh
+func (mesh *NavMesh) PolyCount() int {
+	return len(mesh.polys)
+}
+
+// Get the bounds of navmesh
+func (mesh *NavMesh) Bounds() (bmin Point, bmax Point) {
+	return mesh.bmin, mesh.bmax
+}
+
+type extEdge struct {
+	poly int32
+	a, b Point
+}
+
+type meshBuilder struct {
+	mesh     *NavMesh
+	extEdges map[int][]extEdge // direction -> edges on tile borders
+	climb    float32
+}
+
+func (builder *meshBuilder) addTileSet(data []byte) error {
+	if len(data) < 12+_NAVMESH_PARAMS_SIZE {
+		return errors.Errorf("navmesh set header too short")
+	}
+	if version := readInt32(data, 4); version != _NAVMESH_SET_VERSION {
+		return errors.Errorf("unsupported navmesh set version: %d", version)
+	}
+
+	numTiles := int(readInt32(data, 8))
+	offset := 12 + _NAVMESH_PARAMS_SIZE
+	for i := 0; i < numTiles; i++ {
+		// tile header is {tileRef, dataSize}, the tile ref is 32-bit, or 64-bit with the header aligned to 16 bytes
+		var dataSize int
+		if offset+12 <= len(data) && readInt32(data, offset+8) == _NAVMESH_MAGIC {
+			dataSize = int(readInt32(data, offset+4))
+			offset += 8
+		} else if offset+20 <= len(data) && readInt32(data, offset+16) == _NAVMESH_MAGIC {
+			dataSize = int(readInt32(data, offset+8))
+			offset += 16
+		} else {
+			return errors.Errorf("navmesh set truncated at tile %d", i)
+		}
+
+		if dataSize > len(data)-offset {
+			return errors.Errorf("navmesh set truncated at tile %d", i)
+		}
+		if err := builder.addTile(data[offset : offset+dataSize]); err != nil {
+			return errors.Wrapf(err, "tile %d", i)
+		}
+		offset += dataSize
+	}
+	return nil
+}
+
+func (builder *meshBuilder) addTile(data []byte) error {
+	if len(data) < _NAVMESH_HEADER_SIZE {
+		return errors.Errorf("navmesh tile header too short")
+	}
+	if magic := readInt32(data, 0); magic != _NAVMESH_MAGIC {
+		return errors.Errorf("wrong navmesh tile magic: %x", data[:4])
+	}
+	if version := readInt32(data, 4); version != _NAVMESH_VERSION {
+		return errors.Errorf("unsupported navmesh tile version: %d", version)
+	}
+
+	polyCount := int(readInt32(data, 24))
+	vertCount := int(readInt32(data, 28))
+	maxLinkCount := int(readInt32(data, 32))
+	detailMeshCount := int(readInt32(data, 36))
+	detailVertCount := int(readInt32(data, 40))
+	detailTriCount := int(readInt32(data, 44))
+	bvNodeCount := int(readInt32(data, 48))
+	offMeshConCount := int(readInt32(data, 52))
+	walkableClimb := readFloat32(data, 68)
+	if walkableClimb > builder.climb {
+		builder.climb = walkableClimb
+	}
+
+	// links are runtime data whose size depends on the size of poly refs, which is known by the rest size
+	size := _NAVMESH_HEADER_SIZE + 12*vertCount + _POLY_SIZE*polyCount + _DETAIL_MESH_SIZE*detailMeshCount +
+		12*detailVertCount + 4*detailTriCount + _BV_NODE_SIZE*bvNodeCount + _OFFMESH_CON_SIZE*offMeshConCount
+	if polyCount < 0 || vertCount < 0 || size > len(data) {
+		return errors.Errorf("navmesh tile truncated: %d bytes, at least %d bytes expected", len(data), size)
+	}
+	if maxLinkCount > 0 {
+		if linkSize := (len(data) - size) / maxLinkCount; linkSize != 12 && linkSize != 16 {
+			return errors.Errorf("navmesh tile truncated: wrong link size %d", linkSize)
+		}
+	}
+
+	mesh := builder.mesh
+	vertBase := int32(len(mesh.verts))
+	polyBase := int32(len(mesh.polys))
+	offset := _NAVMESH_HEADER_SIZE
+	for i := 0; i < vertCount; i++ {
+		mesh.verts = append(mesh.verts, readPoint(data, offset))
+		offset += 12
+	}
+
+	for i := 0; i < polyCount; i++ {
+		p := data[offset : offset+_POLY_SIZE]
+		offset += _POLY_SIZE
+
+		flags := binary.LittleEndian.Uint16(p[28:])
+		nv := int(p[30])
+		polyType := p[31] >> 6 // area in the lower 6 bits
+		var poly navPoly
+		if flags == 0 || polyType != _POLYTYPE_GROUND || nv < 3 || nv > _VERTS_PER_POLYGON {
+			mesh.polys = append(mesh.polys, poly) // not walkable, kept for indexes of neighbors
+			continue
+		}
+
+		for j := 0; j < nv; j++ {
+			v := int32(binary.LittleEndian.Uint16(p[4+2*j:]))
+			if int(v) >= vertCount {
+				return errors.Errorf("navmesh polygon %d has invalid vertex %d", i, v)
+			}
+			poly.verts = append(poly.verts, vertBase+v)
+		}
+		mesh.polys = append(mesh.polys, poly)
+
+		pi := polyBase + int32(i)
+		for j := 0; j < nv; j++ {
+			nei := binary.LittleEndian.Uint16(p[16+2*j:])
+			a, b := mesh.verts[poly.verts[j]], mesh.verts[poly.verts[(j+1)%nv]]
+			if nei&_EXT_LINK != 0 {
+				builder.addExtEdge(int(nei&0xff), extEdge{pi, a, b})
+			} else if nei != 0 && int(nei) <= polyCount {
+				mesh.polys[pi].links = append(mesh.polys[pi].links, navLink{polyBase + int32(nei) - 1, a, b})
+			}
+		}
+	}
+
+	for i := polyBase; i < int32(len(mesh.polys)); i++ {
+		mesh.polys[i].initBounds(mesh.verts)
+	}
+	return nil
+}
+
+func (builder *meshBuilder) addExtEdge(dir int, edge extEdge) {
+	if builder.extEdges == nil {
+		builder.extEdges = map[int][]extEdge{}
+	}
+	builder.extEdges[dir] = append(builder.extEdges[dir], edge)
+}
+
+// Link polygons of neighbor tiles through overlapping edges on tile borders
+func (builder *meshBuilder) connectTiles() {
+	climb := builder.climb
+	if climb <= 0 {
+		climb = _DEFAULT_WALK_CLIMB
+	}
+
+	polys := builder.mesh.polys
+	for _, dir := range []int{0, 2} { // +x and +z, matched with -x and -z edges of neighbor tiles
+		for _, e1 := range builder.extEdges[dir] {
+			for _, e2 := range builder.extEdges[dir+4] {
+				if e1.poly == e2.poly || polys[e2.poly].verts == nil {
+					continue
+				}
+				a, b, ok := overlapBorderEdges(e1, e2, dir == 0, climb)
+				if !ok {
+					continue
+				}
+				polys[e1.poly].links = append(polys[e1.poly].links, navLink{e2.poly, a, b})
+				polys[e2.poly].links = append(polys[e2.poly].links, navLink{e1.poly, a, b})
+			}
+		}
+	}
+	builder.extEdges = nil
+}
+
+// Get the overlapping segment of edges on the same tile border, alongZ is true for borders along the Z axis
+func overlapBorderEdges(e1 extEdge, e2 extEdge, alongZ bool, climb float32) (Point, Point, bool) {
+	coord := func(p Point) (float32, float32) { // coord on the border axis, coord along the border
+		if alongZ {
+			return p.X, p.Z
+		}
+		return p.Z, p.X
+	}
+
+	c1a, s1a := coord(e1.a)
+	c1b, s1b := coord(e1.b)
+	c2a, s2a := coord(e2.a)
+	c2b, s2b := coord(e2.b)
+	if abs(c1a-c1b) > _PORTAL_EPSILON || abs(c1a-c2a) > _PORTAL_EPSILON || abs(c2a-c2b) > _PORTAL_EPSILON {
+		return Point{}, Point{}, false // not on the same border
+	}
+
+	lo := max(min(s1a, s1b), min(s2a, s2b))
+	hi := min(max(s1a, s1b), max(s2a, s2b))
+	if hi-lo < _PORTAL_EPSILON {
+		return Point{}, Point{}, false
+	}
+
+	a, b := lerpOnEdge(e1.a, e1.b, s1a, s1b, lo), lerpOnEdge(e1.a, e1.b, s1a, s1b, hi)
+	a2, b2 := lerpOnEdge(e2.a, e2.b, s2a, s2b, lo), lerpOnEdge(e2.a, e2.b, s2a, s2b, hi)
+	if abs(a.Y-a2.Y) > climb || abs(b.Y-b2.Y) > climb {
+		return Point{}, Point{}, false // different layers
+	}
+	return a, b, true
+}
+
+func lerpOnEdge(a, b Point, sa, sb, s float32) Point {
+	if sa == sb {
+		return a
+	}
+	return lerp(a, b, (s-sa)/(sb-sa))
+}
+
+func (poly *navPoly) initBounds(verts []Point) {
+	if len(poly.verts) == 0 {
+		return
+	}
+	poly.bmin, poly.bmax = verts[poly.verts[0]], verts[poly.verts[0]]
+	var sum Point
+	for _, vi := range poly.verts {
+		v := verts[vi]
+		poly.bmin = Point{min(poly.bmin.X, v.X), min(poly.bmin.Y, v.Y), min(poly.bmin.Z, v.Z)}
+		poly.bmax = Point{max(poly.bmax.X, v.X), max(poly.bmax.Y, v.Y), max(poly.bmax.Z, v.Z)}
+		sum = Point{sum.X + v.X, sum.Y + v.Y, sum.Z + v.Z}
+	}
+	n := float32(len(poly.verts))
+	poly.center = Point{sum.X / n, sum.Y / n, sum.Z / n}
+}
+
+func (poly *navPoly) walkable() bool {
+	return len(poly.verts) > 0
+}
+
+// Build the grid of polygons for locating points
+func (mesh *NavMesh) buildGrid() {
+	mesh.cells = map[[2]int32][]int32{}
+	first := true
+	var area float64
+	walkables := 0
+	for i := range mesh.polys {
+		poly := &mesh.polys[i]
+		if !poly.walkable() {
+			continue
+		}
+		if first {
+			mesh.bmin, mesh.bmax, first = poly.bmin, poly.bmax, false
+		}
+		mesh.bmin = Point{min(mesh.bmin.X, poly.bmin.X), min(mesh.bmin.Y, poly.bmin.Y), min(mesh.bmin.Z, poly.bmin.Z)}
+		mesh.bmax = Point{max(mesh.bmax.X, poly.bmax.X), max(mesh.bmax.Y, poly.bmax.Y), max(mesh.bmax.Z, poly.bmax.Z)}
+		area += float64(poly.bmax.X-poly.bmin.X) * float64(poly.bmax.Z-poly.bmin.Z)
+		walkables += 1
+	}
+	if walkables == 0 {
+		return
+	}
+
+	// cells are about the size of a few polygons, and the cell count is limited for huge sparse meshes
+	mesh.cellSize = float32(math.Sqrt(area / float64(walkables) * _GRID_POLYS_PER_CELL))
+	sizeX, sizeZ := float64(mesh.bmax.X-mesh.bmin.X), float64(mesh.bmax.Z-mesh.bmin.Z)
+	if minCellSize := float32(math.Sqrt(sizeX * sizeZ / _MAX_GRID_CELL_COUNTS)); mesh.cellSize < minCellSize {
+		mesh.cellSize = minCellSize
+	}
+	if mesh.cellSize <= 0 {
+		mesh.cellSize = 1
+	}
+
+	for i := range mesh.polys {
+		poly := &mesh.polys[i]
+		if !poly.walkable() {
+			continue
+		}
+		mesh.visitCells(poly.bmin.X, poly.bmax.X, poly.bmin.Z, poly.bmax.Z, func(cell [2]int32) {
+			mesh.cells[cell] = append(mesh.cells[cell], int32(i))
+		})
+	}
+}
+
+func (mesh *NavMesh) visitCells(minX, maxX, minZ, maxZ float32, visit func(cell [2]int32)) {
+	x0, x1 := int32(math.Floor(float64(minX/mesh.cellSize))), int32(math.Floor(float64(maxX/mesh.cellSize)))
+	z0, z1 := int32(math.Floor(float64(minZ/mesh.cellSize))), int32(math.Floor(float64(maxZ/mesh.cellSize)))
+	for x := x0; x <= x1; x++ {
+		for z := z0; z <= z1; z++ {
+			visit([2]int32{x, z})
+		}
+	}
+}
+
+// Find the nearest polygon and the nearest point on it within query extents of the point, returns -1 if not found
+func (mesh *NavMesh) findNearestPoly(p Point) (int32, Point) {
+	best, bestPoint := int32(-1), p
+	bestDist := float32(math.MaxFloat32)
+	if mesh.cellSize <= 0 {
+		return best, bestPoint
+	}
+
+	visited := map[int32]struct{}{}
+	mesh.visitCells(p.X-_QUERY_EXTENT_XZ, p.X+_QUERY_EXTENT_XZ, p.Z-_QUERY_EXTENT_XZ, p.Z+_QUERY_EXTENT_XZ, func(cell [2]int32) {
+		for _, pi := range mesh.cells[cell] {
+			if _, ok := visited[pi]; ok {
+				continue
+			}
+			visited[pi] = struct{}{}
+
+			poly := &mesh.polys[pi]
+			if p.X < poly.bmin.X-_QUERY_EXTENT_XZ || p.X > poly.bmax.X+_QUERY_EXTENT_XZ ||
+				p.Z < poly.bmin.Z-_QUERY_EXTENT_XZ || p.Z > poly.bmax.Z+_QUERY_EXTENT_XZ ||
+				p.Y < poly.bmin.Y-_QUERY_EXTENT_Y || p.Y > poly.bmax.Y+_QUERY_EXTENT_Y {
+				continue
+			}
+
+			closest := mesh.closestPointOnPoly(poly, p)
+			dx, dz := closest.X-p.X, closest.Z-p.Z
+			if dx*dx+dz*dz > _QUERY_EXTENT_XZ*_QUERY_EXTENT_XZ {
+				continue
+			}
+			dy := closest.Y - p.Y
+			if d := dx*dx + dy*dy + dz*dz; d < bestDist || (d == bestDist && pi < best) {
+				best, bestPoint, bestDist = pi, closest, d
+			}
+		}
+	})
+	return best, bestPoint
+}
+
+// Get the closest point on polygon on the X-Z plane, with height on the polygon
+func (mesh *NavMesh) closestPointOnPoly(poly *navPoly, p Point) Point {
+	if h, ok := mesh.polyHeight(poly, p); ok {
+		return Point{p.X, h, p.Z}
+	}
+
+	// outside of polygon, the closest point is on edges
+	var closest Point
+	bestDist := float32(math.MaxFloat32)
+	n := len(poly.verts)
+	for j := 0; j < n; j++ {
+		a, b := mesh.verts[poly.verts[j]], mesh.verts[poly.verts[(j+1)%n]]
+		q := closestPointOnSegment2D(p, a, b)
+		dx, dz := q.X-p.X, q.Z-p.Z
+		if d := dx*dx + dz*dz; d < bestDist {
+			closest, bestDist = q, d
+		}
+	}
+	return closest
+}
+
+// Get the height of polygon at the point, returns false if the point in not in polygon on the X-Z plane
+func (mesh *NavMesh) polyHeight(poly *navPoly, p Point) (float32, bool) {
+	v0 := mesh.verts[poly.verts[0]]
+	for j := 2; j < len(poly.verts); j++ {
+		v1, v2 := mesh.verts[poly.verts[j-1]], mesh.verts[poly.verts[j]]
+		if h, ok := triangleHeight(p, v0, v1, v2); ok {
+			return h, true
+		}
+	}
+	return 0, false
+}
+
+func triangleHeight(p, a, b, c Point) (float32, bool) {
+	const eps = 1e-4
+	v0x, v0z := c.X-a.X, c.Z-a.Z
+	v1x, v1z := b.X-a.X, b.Z-a.Z
+	v2x, v2z := p.X-a.X, p.Z-a.Z
+
+	denom := v0x*v1z - v0z*v1x
+	if abs(denom) < 1e-12 {
+		return 0, false
+	}
+	u := (v1z*v2x - v1x*v2z) / denom
+	v := (v0x*v2z - v0z*v2x) / denom
+	if u >= -eps && v >= -eps && u+v <= 1+eps {
+		return a.Y + (c.Y-a.Y)*u + (b.Y-a.Y)*v, true
+	}
+	return 0, false
+}
+
+func closestPointOnSegment2D(p, a, b Point) Point {
+	dx, dz := b.X-a.X, b.Z-a.Z
+	l := dx*dx + dz*dz
+	var t float32
+	if l > 0 {
+		t = ((p.X-a.X)*dx + (p.Z-a.Z)*dz) / l
+		t = max(0, min(1, t))
+	}
+	return lerp(a, b, t)
+}
+
+func readInt32(data []byte, offset int) int32 {
+	return int32(binary.LittleEndian.Uint32(data[offset:]))
+}
+
+func readFloat32(data []byte, offset int) float32 {
+	return math.Float32frombits(binary.LittleEndian.Uint32(data[offset:]))
+}
+
+func readPoint(data []byte, offset int) Point {
+	return Point{readFloat32(data, offset), readFloat32(data, offset+4), readFloat32(data, offset+8)}
+}
+
+func (p Point) String() string {
+	return fmt.Sprintf("(%.1f, %.1f, %.1f)", p.X, p.Y, p.Z)
+}
+
+func lerp(a, b Point, t float32) Point {
+	return Point{a.X + (b.X-a.X)*t, a.Y + (b.Y-a.Y)*t, a.Z + (b.Z-a.Z)*t}
+}
+
+func abs(v float32) float32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func min(a, b float32) float32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b float32) float32 {
+	if a > b {
+		return a
+	}
+	return b
+}
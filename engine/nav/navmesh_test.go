@@ -0,0 +1,132 @@
+package nav
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+type testPoly struct {
+	verts []uint16
+	neis  []uint16
+}
+
+func encodeTile(verts []Point, polys []testPoly) []byte {
+	var data []byte
+	putInt := func(v uint32) {
+		data = binary.LittleEndian.AppendUint32(data, v)
+	}
+	putFloat := func(v float32) {
+		putInt(math.Float32bits(v))
+	}
+
+	header := []uint32{_NAVMESH_MAGIC, _NAVMESH_VERSION, 0, 0, 0, 0, uint32(len(polys)), uint32(len(verts)), 0, 0, 0, 0, 0, 0, 0}
+	for _, v := range header {
+		putInt(v)
+	}
+	for i := 0; i < 10; i++ {
+		putFloat(0.9) // walkable height, radius, climb, bmin, bmax and quant factor
+	}
+	for _, v := range verts {
+		putFloat(v.X)
+		putFloat(v.Y)
+		putFloat(v.Z)
+	}
+	for _, poly := range polys {
+		p := make([]byte, _POLY_SIZE)
+		for j := range poly.verts {
+			binary.LittleEndian.PutUint16(p[4+2*j:], poly.verts[j])
+			binary.LittleEndian.PutUint16(p[16+2*j:], poly.neis[j])
+		}
+		binary.LittleEndian.PutUint16(p[28:], 1) // flags
+		p[30] = byte(len(poly.verts))
+		data = append(data, p...)
+	}
+	return data
+}
+
+func encodeTileSet(tiles [][]byte, tileRef64 bool) []byte {
+	data := make([]byte, 12+_NAVMESH_PARAMS_SIZE)
+	binary.LittleEndian.PutUint32(data, _NAVMESH_SET_MAGIC)
+	binary.LittleEndian.PutUint32(data[4:], _NAVMESH_SET_VERSION)
+	binary.LittleEndian.PutUint32(data[8:], uint32(len(tiles)))
+	for i, tile := range tiles {
+		if tileRef64 {
+			data = binary.LittleEndian.AppendUint64(data, uint64(i+1)<<32)
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(tile)))
+			data = binary.LittleEndian.AppendUint32(data, 0)
+		} else {
+			data = binary.LittleEndian.AppendUint32(data, uint32(i+1))
+			data = binary.LittleEndian.AppendUint32(data, uint32(len(tile)))
+		}
+		data = append(data, tile...)
+	}
+	return data
+}
+
+func checkPath(t *testing.T, mesh *NavMesh, start, end Point, expected []Point) {
+	path, err := mesh.FindPath(start, end)
+	if err != nil {
+		t.Fatalf("FindPath %s -> %s failed: %s", start, end, err)
+	}
+	if len(path) != len(expected) {
+		t.Fatalf("FindPath %s -> %s: wrong path %v, expected %v", start, end, path, expected)
+	}
+	for i := range path {
+		if distance(path[i], expected[i]) > 0.001 {
+			t.Fatalf("FindPath %s -> %s: wrong path %v, expected %v", start, end, path, expected)
+		}
+	}
+}
+
+func TestFindPath(t *testing.T) {
+	// L-shaped mesh: A(0~10, 0~10), B(0~10, 10~20) and C(10~20, 10~20)
+	verts := []Point{{0, 0, 0}, {10, 0, 0}, {10, 0, 10}, {0, 0, 10}, {10, 0, 20}, {0, 0, 20}, {20, 0, 10}, {20, 0, 20}}
+	mesh, err := Parse(encodeTile(verts, []testPoly{
+		{[]uint16{0, 1, 2, 3}, []uint16{0, 0, 2, 0}},
+		{[]uint16{3, 2, 4, 5}, []uint16{1, 3, 0, 0}},
+		{[]uint16{2, 6, 7, 4}, []uint16{0, 0, 0, 2}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mesh.PolyCount() != 3 {
+		t.Fatalf("wrong poly count: %d", mesh.PolyCount())
+	}
+
+	checkPath(t, mesh, Point{2, 0, 2}, Point{8, 0, 8}, []Point{{2, 0, 2}, {8, 0, 8}})
+	checkPath(t, mesh, Point{2, 0, 2}, Point{18, 0, 12}, []Point{{2, 0, 2}, {10, 0, 10}, {18, 0, 12}})
+	checkPath(t, mesh, Point{18, 0, 12}, Point{2, 0, 2}, []Point{{18, 0, 12}, {10, 0, 10}, {2, 0, 2}})
+	checkPath(t, mesh, Point{5, 1, 5}, Point{15, 0, 21}, []Point{{5, 0, 5}, {15, 0, 20}}) // snapped to mesh
+
+	if _, err := mesh.FindPath(Point{2, 0, 2}, Point{18, 0, 2}); err != ErrPointNotOnMesh {
+		t.Fatalf("point out of mesh should fail: %v", err)
+	}
+}
+
+func TestFindPathAcrossTiles(t *testing.T) {
+	tile1 := encodeTile([]Point{{0, 0, 0}, {10, 0, 0}, {10, 0, 10}, {0, 0, 10}}, []testPoly{
+		{[]uint16{0, 1, 2, 3}, []uint16{0, _EXT_LINK | 0, 0, 0}},
+	})
+	tile2 := encodeTile([]Point{{10, 0, 0}, {20, 0, 0}, {20, 0, 10}, {10, 0, 10}}, []testPoly{
+		{[]uint16{0, 1, 2, 3}, []uint16{0, 0, 0, _EXT_LINK | 4}},
+	})
+	isolated := encodeTile([]Point{{30, 0, 0}, {40, 0, 0}, {40, 0, 10}, {30, 0, 10}}, []testPoly{
+		{[]uint16{0, 1, 2, 3}, []uint16{0, 0, 0, 0}},
+	})
+
+	for _, tileRef64 := range []bool{false, true} {
+		mesh, err := Parse(encodeTileSet([][]byte{tile1, tile2, isolated}, tileRef64))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkPath(t, mesh, Point{2, 0, 5}, Point{18, 0, 5}, []Point{{2, 0, 5}, {18, 0, 5}})
+		if _, err := mesh.FindPath(Point{2, 0, 5}, Point{35, 0, 5}); err != ErrNoPath {
+			t.Fatalf("isolated tile should not be reachable: %v", err)
+		}
+	}
+
+	if _, err := Parse(tile1[:len(tile1)-1]); err == nil {
+		t.Fatalf("truncated tile should fail")
+	}
+}
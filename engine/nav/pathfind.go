@@ -0,0 +1,195 @@
+package nav
+
+import (
+	"container/heap"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// Path finding on navmesh: A* search over polygons through portal midpoints, and the path is straightened by the
+// funnel algorithm on the X-Z plane. Heights of path corners are the heights of portal vertices.
+
+var (
+	ErrPointNotOnMesh = errors.New("point is not on navmesh")
+	ErrNoPath         = errors.New("no path on navmesh")
+)
+
+// Find the straight path from start to end, including both ends snapped to navmesh
+func (mesh *NavMesh) FindPath(start Point, end Point) ([]Point, error) {
+	startPoly, start := mesh.findNearestPoly(start)
+	endPoly, end := mesh.findNearestPoly(end)
+	if startPoly < 0 || endPoly < 0 {
+		return nil, ErrPointNotOnMesh
+	}
+
+	polys := mesh.findPolyPath(startPoly, start, endPoly, end)
+	if polys == nil {
+		return nil, ErrNoPath
+	}
+	return mesh.straightenPath(polys, start, end), nil
+}
+
+// Find the nearest point on navmesh within query extents, returns false if not found
+func (mesh *NavMesh) FindNearestPoint(p Point) (Point, bool) {
+	poly, nearest := mesh.findNearestPoly(p)
+	return nearest, poly >= 0
+}
+
+type searchNode struct {
+	poly   int32
+	pos    Point   // where the path enters the polygon
+	cost   float32 // from start to pos
+	total  float32 // cost with heuristic to end
+	parent *searchNode
+	index  int // index in open list, -1 if closed
+}
+
+type openList []*searchNode
+
+func (l openList) Len() int           { return len(l) }
+func (l openList) Less(i, j int) bool { return l[i].total < l[j].total }
+func (l openList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+	l[i].index, l[j].index = i, j
+}
+func (l *openList) Push(x interface{}) {
+	node := x.(*searchNode)
+	node.index = len(*l)
+	*l = append(*l, node)
+}
+func (l *openList) Pop() interface{} {
+	old := *l
+	node := old[len(old)-1]
+	node.index = -1
+	*l = old[:len(old)-1]
+	return node
+}
+
+// A* search of polygons from start to end, returns nil if end is not reachable
+func (mesh *NavMesh) findPolyPath(startPoly int32, start Point, endPoly int32, end Point) []int32 {
+	const heuristicScale = 0.999 // slightly underestimated to prefer shorter paths
+
+	nodes := map[int32]*searchNode{}
+	startNode := &searchNode{poly: startPoly, pos: start, total: distance(start, end) * heuristicScale}
+	nodes[startPoly] = startNode
+	open := &openList{}
+	heap.Push(open, startNode)
+
+	for open.Len() > 0 {
+		node := heap.Pop(open).(*searchNode)
+		if node.poly == endPoly {
+			var polys []int32
+			for ; node != nil; node = node.parent {
+				polys = append(polys, node.poly)
+			}
+			for i, j := 0, len(polys)-1; i < j; i, j = i+1, j-1 {
+				polys[i], polys[j] = polys[j], polys[i]
+			}
+			return polys
+		}
+
+		for _, link := range mesh.polys[node.poly].links {
+			if !mesh.polys[link.poly].walkable() {
+				continue
+			}
+
+			pos := lerp(link.a, link.b, 0.5)
+			cost := node.cost + distance(node.pos, pos)
+			if link.poly == endPoly {
+				cost += distance(pos, end)
+			}
+
+			next := nodes[link.poly]
+			if next == nil {
+				next = &searchNode{poly: link.poly, index: -1}
+				nodes[link.poly] = next
+			} else if cost >= next.cost {
+				continue
+			}
+
+			next.pos, next.cost, next.parent = pos, cost, node
+			next.total = cost + distance(pos, end)*heuristicScale
+			if next.index >= 0 {
+				heap.Fix(open, next.index)
+			} else {
+				heap.Push(open, next)
+			}
+		}
+	}
+	return nil
+}
+
+// Straighten the path through portals of polygons with the funnel algorithm
+func (mesh *NavMesh) straightenPath(polys []int32, start Point, end Point) []Point {
+	// portals are oriented so that left is counter-clockwise of right seen from the polygon before it
+	lefts, rights := []Point{start}, []Point{start}
+	for i := 0; i+1 < len(polys); i++ {
+		poly := &mesh.polys[polys[i]]
+		for _, link := range poly.links {
+			if link.poly != polys[i+1] {
+				continue
+			}
+			if cross(poly.center, link.a, link.b) > 0 {
+				lefts, rights = append(lefts, link.b), append(rights, link.a)
+			} else {
+				lefts, rights = append(lefts, link.a), append(rights, link.b)
+			}
+			break
+		}
+	}
+	lefts, rights = append(lefts, end), append(rights, end)
+
+	path := []Point{start}
+	apex, left, right := start, lefts[0], rights[0]
+	apexIndex, leftIndex, rightIndex := 0, 0, 0
+	for i := 1; i < len(lefts); i++ {
+		l, r := lefts[i], rights[i]
+
+		// tighten the right side of the funnel
+		if cross(apex, right, r) >= 0 {
+			if apex == right || cross(apex, left, r) < 0 {
+				right, rightIndex = r, i
+			} else {
+				// right crosses over left, left is the next corner
+				path = appendCorner(path, left)
+				apex, apexIndex = left, leftIndex
+				left, right, leftIndex, rightIndex = apex, apex, apexIndex, apexIndex
+				i = apexIndex
+				continue
+			}
+		}
+
+		// tighten the left side of the funnel
+		if cross(apex, left, l) <= 0 {
+			if apex == left || cross(apex, right, l) > 0 {
+				left, leftIndex = l, i
+			} else {
+				// left crosses over right, right is the next corner
+				path = appendCorner(path, right)
+				apex, apexIndex = right, rightIndex
+				left, right, leftIndex, rightIndex = apex, apex, apexIndex, apexIndex
+				i = apexIndex
+				continue
+			}
+		}
+	}
+	return appendCorner(path, end)
+}
+
+func appendCorner(path []Point, p Point) []Point {
+	if last := path[len(path)-1]; last == p {
+		return path
+	}
+	return append(path, p)
+}
+
+// Cross product of (a - o) and (b - o) on the X-Z plane, positive if b is counter-clockwise of a seen from o
+func cross(o, a, b Point) float32 {
+	return (a.X-o.X)*(b.Z-o.Z) - (a.Z-o.Z)*(b.X-o.X)
+}
+
+func distance(a, b Point) float32 {
+	dx, dy, dz := b.X-a.X, b.Y-a.Y, b.Z-a.Z
+	return float32(math.Sqrt(float64(dx*dx + dy*dy + dz*dz)))
+}
@@ -1,6 +1,7 @@
 package netutil
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/xiaonanln/goworld/engine/uuid"
@@ -72,6 +73,47 @@ func TestMessagePackMsgPacker_UnpackMsg(t *testing.T) {
 	}
 }
 
+func TestProtobufMsgPacker(t *testing.T) {
+	msg := map[string]interface{}{
+		"nil":    nil,
+		"bool":   true,
+		"int":    -123,
+		"uint":   uint32(456),
+		"float":  0.5,
+		"string": "abc",
+		"bytes":  []byte{1, 2, 3},
+		"list":   []interface{}{1, "abc", []interface{}{}},
+		"map":    map[string]interface{}{"d": int64(-1)},
+	}
+	buf, err := ProtobufMsgPacker{}.PackMsg(msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outmsg interface{}
+	if err := (ProtobufMsgPacker{}).UnpackMsg(buf, &outmsg); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"nil":    nil,
+		"bool":   true,
+		"int":    int64(-123),
+		"uint":   uint64(456),
+		"float":  0.5,
+		"string": "abc",
+		"bytes":  []byte{1, 2, 3},
+		"list":   []interface{}{int64(1), "abc", []interface{}{}},
+		"map":    map[string]interface{}{"d": int64(-1)},
+	}
+	if !reflect.DeepEqual(outmsg, expected) {
+		t.Errorf("unpacked %v, expected %v", outmsg, expected)
+	}
+
+	if err := (ProtobufMsgPacker{}).UnpackMsg(buf[:len(buf)-1], &outmsg); err == nil {
+		t.Errorf("truncated message should not be unpacked")
+	}
+}
+
 func BenchmarkMessagePackMsgPacker_PackMsg_Array_AllInOne(b *testing.B) {
 	packer := MessagePackMsgPacker{}
 	items := []testMsg{}
@@ -0,0 +1,334 @@
+package netutil
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// Wire types of protobuf fields
+const (
+	PROTOBUF_WIRE_VARINT  = 0
+	PROTOBUF_WIRE_FIXED64 = 1
+	PROTOBUF_WIRE_BYTES   = 2 // length delimited
+	PROTOBUF_WIRE_FIXED32 = 5
+)
+
+// Field numbers of goworld.Value
+const (
+	_PROTOBUF_VALUE_NULL = 1 + iota
+	_PROTOBUF_VALUE_BOOL
+	_PROTOBUF_VALUE_INT
+	_PROTOBUF_VALUE_UINT
+	_PROTOBUF_VALUE_DOUBLE
+	_PROTOBUF_VALUE_STRING
+	_PROTOBUF_VALUE_BYTES
+	_PROTOBUF_VALUE_LIST
+	_PROTOBUF_VALUE_MAP
+)
+
+var (
+	errProtobufTruncated = errors.New("protobuf: truncated message")
+
+	protobufValueWireTypes = map[int]int{
+		_PROTOBUF_VALUE_NULL:   PROTOBUF_WIRE_VARINT,
+		_PROTOBUF_VALUE_BOOL:   PROTOBUF_WIRE_VARINT,
+		_PROTOBUF_VALUE_INT:    PROTOBUF_WIRE_VARINT,
+		_PROTOBUF_VALUE_UINT:   PROTOBUF_WIRE_VARINT,
+		_PROTOBUF_VALUE_DOUBLE: PROTOBUF_WIRE_FIXED64,
+		_PROTOBUF_VALUE_STRING: PROTOBUF_WIRE_BYTES,
+		_PROTOBUF_VALUE_BYTES:  PROTOBUF_WIRE_BYTES,
+		_PROTOBUF_VALUE_LIST:   PROTOBUF_WIRE_BYTES,
+		_PROTOBUF_VALUE_MAP:    PROTOBUF_WIRE_BYTES,
+	}
+)
+
+// ProtobufMsgPacker packs messages as goworld.Value of protobuf, which is used for clients of protobuf encoding
+//
+//	message Value {
+//		oneof kind {
+//			bool null_value = 1;
+//			bool bool_value = 2;
+//			sint64 int_value = 3;
+//			uint64 uint_value = 4;
+//			double double_value = 5;
+//			string string_value = 6;
+//			bytes bytes_value = 7;
+//			ListValue list_value = 8;
+//			MapValue map_value = 9;
+//		}
+//	}
+//	message ListValue { repeated Value values = 1; }
+//	message MapValue { map<string, Value> fields = 1; }
+//
+// Messages can only be unpacked to *interface{}: integers are unpacked as int64 or uint64, floats as float64, lists as
+// []interface{} and maps as map[string]interface{}.
+type ProtobufMsgPacker struct{}
+
+// Field of protobuf message
+type ProtobufField struct {
+	Num      int
+	WireType int
+	Value    uint64 // value of varint, fixed32 and fixed64 fields
+	Bytes    []byte // value of length delimited fields, not copied
+}
+
+func (pp ProtobufMsgPacker) PackMsg(msg interface{}, buf []byte) ([]byte, error) {
+	return appendProtobufValue(buf, reflect.ValueOf(msg))
+}
+
+func (pp ProtobufMsgPacker) UnpackMsg(data []byte, msg interface{}) error {
+	pv, ok := msg.(*interface{})
+	if !ok {
+		return errors.Errorf("protobuf: can not unpack Value to %T", msg)
+	}
+
+	v, err := readProtobufValue(data)
+	if err != nil {
+		return err
+	}
+	*pv = v
+	return nil
+}
+
+// Append varint to buf
+func AppendProtobufVarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+// Append tag of field to buf
+func AppendProtobufTag(buf []byte, num int, wireType int) []byte {
+	return AppendProtobufVarint(buf, uint64(num)<<3|uint64(wireType))
+}
+
+// Append length delimited field to buf
+func AppendProtobufBytesField(buf []byte, num int, b []byte) []byte {
+	buf = AppendProtobufTag(buf, num, PROTOBUF_WIRE_BYTES)
+	buf = AppendProtobufVarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// Read the first field of data, returns the field and the number of bytes read
+func ReadProtobufField(data []byte) (field ProtobufField, n int, err error) {
+	tag, n := binary.Uvarint(data)
+	if n <= 0 {
+		return field, 0, errProtobufTruncated
+	}
+	field.Num, field.WireType = int(tag>>3), int(tag&7)
+
+	switch field.WireType {
+	case PROTOBUF_WIRE_VARINT:
+		v, m := binary.Uvarint(data[n:])
+		if m <= 0 {
+			return field, 0, errProtobufTruncated
+		}
+		field.Value = v
+		n += m
+	case PROTOBUF_WIRE_FIXED64:
+		if len(data) < n+8 {
+			return field, 0, errProtobufTruncated
+		}
+		field.Value = binary.LittleEndian.Uint64(data[n:])
+		n += 8
+	case PROTOBUF_WIRE_FIXED32:
+		if len(data) < n+4 {
+			return field, 0, errProtobufTruncated
+		}
+		field.Value = uint64(binary.LittleEndian.Uint32(data[n:]))
+		n += 4
+	case PROTOBUF_WIRE_BYTES:
+		l, m := binary.Uvarint(data[n:])
+		if m <= 0 || uint64(len(data)-n-m) < l {
+			return field, 0, errProtobufTruncated
+		}
+		n += m
+		field.Bytes = data[n : n+int(l)]
+		n += int(l)
+	default:
+		return field, 0, errors.Errorf("protobuf: unsupported wire type %d of field %d", field.WireType, field.Num)
+	}
+	return field, n, nil
+}
+
+func appendProtobufValue(buf []byte, v reflect.Value) ([]byte, error) {
+	for v.IsValid() && (v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr) {
+		if v.IsNil() {
+			v = reflect.Value{}
+		} else {
+			v = v.Elem()
+		}
+	}
+	if !v.IsValid() {
+		buf = AppendProtobufTag(buf, _PROTOBUF_VALUE_NULL, PROTOBUF_WIRE_VARINT)
+		return AppendProtobufVarint(buf, 1), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		buf = AppendProtobufTag(buf, _PROTOBUF_VALUE_BOOL, PROTOBUF_WIRE_VARINT)
+		if v.Bool() {
+			return AppendProtobufVarint(buf, 1), nil
+		}
+		return AppendProtobufVarint(buf, 0), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := v.Int()
+		buf = AppendProtobufTag(buf, _PROTOBUF_VALUE_INT, PROTOBUF_WIRE_VARINT)
+		return AppendProtobufVarint(buf, uint64(i<<1)^uint64(i>>63)), nil // zigzag of sint64
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		buf = AppendProtobufTag(buf, _PROTOBUF_VALUE_UINT, PROTOBUF_WIRE_VARINT)
+		return AppendProtobufVarint(buf, v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		buf = AppendProtobufTag(buf, _PROTOBUF_VALUE_DOUBLE, PROTOBUF_WIRE_FIXED64)
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v.Float()))
+		return append(buf, b[:]...), nil
+	case reflect.String:
+		return AppendProtobufBytesField(buf, _PROTOBUF_VALUE_STRING, []byte(v.String())), nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return AppendProtobufBytesField(buf, _PROTOBUF_VALUE_BYTES, v.Bytes()), nil
+		}
+
+		var list []byte
+		for i := 0; i < v.Len(); i++ {
+			elem, err := appendProtobufValue(nil, v.Index(i))
+			if err != nil {
+				return buf, err
+			}
+			list = AppendProtobufBytesField(list, 1, elem)
+		}
+		return AppendProtobufBytesField(buf, _PROTOBUF_VALUE_LIST, list), nil
+	case reflect.Map:
+		var fields []byte
+		for _, key := range v.MapKeys() {
+			k := key
+			if k.Kind() == reflect.Interface {
+				k = k.Elem()
+			}
+			if k.Kind() != reflect.String {
+				return buf, errors.Errorf("protobuf: map key %v is not string", key)
+			}
+
+			val, err := appendProtobufValue(nil, v.MapIndex(key))
+			if err != nil {
+				return buf, err
+			}
+			entry := AppendProtobufBytesField(nil, 1, []byte(k.String()))
+			entry = AppendProtobufBytesField(entry, 2, val)
+			fields = AppendProtobufBytesField(fields, 1, entry)
+		}
+		return AppendProtobufBytesField(buf, _PROTOBUF_VALUE_MAP, fields), nil
+	}
+	return buf, errors.Errorf("protobuf: can not pack %s as Value", v.Type())
+}
+
+func readProtobufValue(data []byte) (interface{}, error) {
+	var v interface{}
+	for len(data) > 0 {
+		field, n, err := ReadProtobufField(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+
+		wireType, ok := protobufValueWireTypes[field.Num]
+		if !ok {
+			continue // unknown fields are ignored
+		}
+		if field.WireType != wireType {
+			return nil, errors.Errorf("protobuf: wrong wire type %d of Value field %d", field.WireType, field.Num)
+		}
+
+		// the last field wins, as oneof of protobuf
+		switch field.Num {
+		case _PROTOBUF_VALUE_NULL:
+			v = nil
+		case _PROTOBUF_VALUE_BOOL:
+			v = field.Value != 0
+		case _PROTOBUF_VALUE_INT:
+			v = int64(field.Value>>1) ^ -int64(field.Value&1)
+		case _PROTOBUF_VALUE_UINT:
+			v = field.Value
+		case _PROTOBUF_VALUE_DOUBLE:
+			v = math.Float64frombits(field.Value)
+		case _PROTOBUF_VALUE_STRING:
+			v = string(field.Bytes)
+		case _PROTOBUF_VALUE_BYTES:
+			v = append([]byte(nil), field.Bytes...)
+		case _PROTOBUF_VALUE_LIST:
+			if v, err = readProtobufList(field.Bytes); err != nil {
+				return nil, err
+			}
+		case _PROTOBUF_VALUE_MAP:
+			if v, err = readProtobufMap(field.Bytes); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
+
+func readProtobufList(data []byte) ([]interface{}, error) {
+	list := []interface{}{}
+	for len(data) > 0 {
+		field, n, err := ReadProtobufField(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if field.Num != 1 || field.WireType != PROTOBUF_WIRE_BYTES {
+			continue
+		}
+
+		elem, err := readProtobufValue(field.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, elem)
+	}
+	return list, nil
+}
+
+func readProtobufMap(data []byte) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	for len(data) > 0 {
+		field, n, err := ReadProtobufField(data)
+		if err != nil {
+			return nil, err
+		}
+		data = data[n:]
+		if field.Num != 1 || field.WireType != PROTOBUF_WIRE_BYTES {
+			continue
+		}
+
+		// map entry: string key = 1; Value value = 2;
+		var key string
+		var val interface{}
+		entry := field.Bytes
+		for len(entry) > 0 {
+			f, n, err := ReadProtobufField(entry)
+			if err != nil {
+				return nil, err
+			}
+			entry = entry[n:]
+			if f.WireType != PROTOBUF_WIRE_BYTES {
+				continue
+			}
+			if f.Num == 1 {
+				key = string(f.Bytes)
+			} else if f.Num == 2 {
+				if val, err = readProtobufValue(f.Bytes); err != nil {
+					return nil, err
+				}
+			}
+		}
+		m[key] = val
+	}
+	return m, nil
+}
@@ -0,0 +1,70 @@
+package netutil
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xtaci/kcp-go"
+)
+
+// KCP is a reliable ARQ protocol over UDP with lower latency than TCP on lossy networks (e.g. mobile networks).
+// KCP sessions work in stream mode, so that they can be used as TCP connections by the packet layer.
+
+// Tuning options of KCP sessions, should be the same on both sides
+type KCPOptions struct {
+	MTU        int
+	SendWindow int // in packets
+	RecvWindow int // in packets
+	NoDelay    bool
+}
+
+func setupKCPSession(sess *kcp.UDPSession, opts KCPOptions) {
+	sess.SetStreamMode(true)
+	sess.SetWriteDelay(false)
+	sess.SetACKNoDelay(true)
+	if opts.MTU > 0 {
+		sess.SetMtu(opts.MTU)
+	}
+	sess.SetWindowSize(opts.SendWindow, opts.RecvWindow)
+	if opts.NoDelay {
+		sess.SetNoDelay(1, 10, 2, 1) // fast mode: 10ms internal update, fast resend, no congestion control
+	} else {
+		sess.SetNoDelay(0, 40, 0, 0)
+	}
+}
+
+// Serve KCP sessions until the listener fails, sessions are served by delegate as TCP connections in new goroutines
+func ServeKCP(listenAddr string, opts KCPOptions, delegate TCPServerDelegate) error {
+	ln, err := kcp.ListenWithOptions(listenAddr, nil, 0, 0)
+	if err != nil {
+		return errors.Wrap(err, "kcp listen failed")
+	}
+	defer ln.Close()
+	gwlog.Info("Listening on KCP: %s ...", listenAddr)
+
+	for {
+		sess, err := ln.AcceptKCP()
+		if err != nil {
+			if IsTemporaryNetError(err) {
+				continue
+			}
+			return err
+		}
+
+		setupKCPSession(sess, opts)
+		gwlog.Info("KCP session from: %s", sess.RemoteAddr())
+		go delegate.ServeTCPConnection(sess)
+	}
+}
+
+// Connect to KCP server with tuning options
+func ConnectKCP(host string, port int, opts KCPOptions) (net.Conn, error) {
+	sess, err := kcp.DialWithOptions(fmt.Sprintf("%s:%d", host, port), nil, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	setupKCPSession(sess, opts)
+	return sess, nil
+}
@@ -8,6 +8,8 @@ import (
 
 	"fmt"
 
+	"time"
+
 	"github.com/xiaonanln/goworld/engine/gwlog"
 )
 
@@ -33,17 +35,27 @@ func (ts *testEchoTcpServer) ServeTCPConnection(conn net.Conn) {
 	}
 }
 
+// Connect to the test server, which may be not listening yet
+func dialTestServer(t *testing.T, port int) net.Conn {
+	var err error
+	for i := 0; i < 100; i++ {
+		var conn net.Conn
+		if conn, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", port)); err == nil {
+			return conn
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("connect error: %s", err)
+	return nil
+}
+
 func TestRawConnection(t *testing.T) {
 	PORT := 4001
 	go func() {
 		ServeTCP(fmt.Sprintf("localhost:%d", PORT), &testEchoTcpServer{})
 	}()
 
-	_conn, err := net.Dial("tcp", "localhost:4001")
-	if err != nil {
-		t.Errorf("connect error: %s", err)
-	}
-	conn := NewRawConnection(_conn)
+	conn := NewRawConnection(dialTestServer(t, PORT))
 	var b byte
 	for b = 0; b < 255; b++ {
 		conn.Write([]byte{b})
@@ -62,12 +74,7 @@ func TestPacketConnection(t *testing.T) {
 		ServeTCP(fmt.Sprintf("localhost:%d", PORT), &testEchoTcpServer{})
 	}()
 
-	_conn, err := net.Dial("tcp", "localhost:4002")
-	if err != nil {
-		t.Errorf("connect error: %s", err)
-	}
-
-	conn := NewPacketConnection(NetConnection{_conn})
+	conn := NewPacketConnection(NetConnection{dialTestServer(t, PORT)}, false)
 
 	for i := 0; i < 100; i++ {
 		var PAYLOAD_LEN uint32 = uint32(rand.Intn(4096 + 1))
@@ -81,6 +88,7 @@ func TestPacketConnection(t *testing.T) {
 			t.Errorf("payload should be %d, but is %d", PAYLOAD_LEN, packet.GetPayloadLen())
 		}
 		conn.SendPacket(packet)
+		conn.Flush()
 		recvPacket, err := conn.RecvPacket()
 		if err != nil {
 			t.Error(err)
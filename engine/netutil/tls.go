@@ -0,0 +1,49 @@
+package netutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Create the TLS config for inter-process links
+//
+// The same certificate is used on both sides, and the peer certificate must be signed by CA if caFile is given.
+// For clients, serverName is used to verify the certificate of the server.
+func NewTLSConfig(certFile, keyFile, caFile string, isServer bool, serverName string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "load tls certificate failed")
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if caFile != "" {
+		caData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read tls ca failed")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, errors.Errorf("invalid tls ca file: %s", caFile)
+		}
+
+		if isServer {
+			cfg.ClientCAs = pool
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			cfg.RootCAs = pool
+		}
+	}
+
+	if !isServer {
+		cfg.ServerName = serverName
+	}
+	return cfg, nil
+}
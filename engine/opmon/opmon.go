@@ -5,12 +5,14 @@ import (
 	"time"
 
 	"sort"
+	"strings"
 
 	"fmt"
 	"os"
 
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
 )
 
 var (
@@ -21,6 +23,10 @@ var (
 	}
 
 	monitor = newMonitor()
+
+	// durations of all operations are also exported on /metrics, e.g. storage and kvdb operations
+	operationDurationMetric = metrics.NewHistogramVec("goworld_operation_duration_seconds",
+		"Duration of monitored operations by name, e.g. storage.save, kvdb.get", nil, "operation")
 )
 
 func init() {
@@ -40,31 +46,63 @@ type _OpInfo struct {
 	maxDuration   time.Duration
 }
 
+// Stats of operations since the process started
+type OperationStats struct {
+	Count       uint64
+	AvgDuration time.Duration
+	MaxDuration time.Duration
+}
+
 type Monitor struct {
 	sync.Mutex
-	opInfos map[string]*_OpInfo
+	opInfos      map[string]*_OpInfo // cleared when dumped
+	totalOpInfos map[string]*_OpInfo
 }
 
 func newMonitor() *Monitor {
 	m := &Monitor{
-		opInfos: map[string]*_OpInfo{},
+		opInfos:      map[string]*_OpInfo{},
+		totalOpInfos: map[string]*_OpInfo{},
 	}
 	return m
 }
 
 func (monitor *Monitor) record(opname string, duration time.Duration) {
 	monitor.Lock()
-	info := monitor.opInfos[opname]
+	recordOpInfo(monitor.opInfos, opname, duration)
+	recordOpInfo(monitor.totalOpInfos, opname, duration)
+	monitor.Unlock()
+}
+
+func recordOpInfo(opInfos map[string]*_OpInfo, opname string, duration time.Duration) {
+	info := opInfos[opname]
 	if info == nil {
 		info = &_OpInfo{}
-		monitor.opInfos[opname] = info
+		opInfos[opname] = info
 	}
 	info.count += 1
 	info.totalDuration += duration
 	if duration > info.maxDuration {
 		info.maxDuration = duration
 	}
-	monitor.Unlock()
+}
+
+// Get stats of operations whose names have the prefix, e.g. "storage."
+func GetStats(prefix string) map[string]OperationStats {
+	monitor.Lock()
+	defer monitor.Unlock()
+
+	stats := map[string]OperationStats{}
+	for opname, info := range monitor.totalOpInfos {
+		if strings.HasPrefix(opname, prefix) {
+			stats[opname] = OperationStats{
+				Count:       info.count,
+				AvgDuration: info.totalDuration / time.Duration(info.count),
+				MaxDuration: info.maxDuration,
+			}
+		}
+	}
+	return stats
 }
 
 func (monitor *Monitor) Dump() {
@@ -109,6 +147,7 @@ func StartOperation(operationName string) *Operation {
 func (op *Operation) Finish(warnThreshold time.Duration) {
 	takeTime := time.Now().Sub(op.startTime)
 	monitor.record(op.name, takeTime)
+	operationDurationMetric.With(op.name).ObserveDuration(takeTime)
 	if takeTime >= warnThreshold {
 		gwlog.Warn("opmon: operation %s takes %s > %s", op.name, takeTime, warnThreshold)
 	}
@@ -0,0 +1,119 @@
+package placement
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Placement policies choose the game for entities created or loaded anywhere (including boot entities of clients),
+// among the games matching the placement constraint. Dispatchers track the load of games reported by game stats:
+//
+//	least_loaded    the game with the least load, which is the CPU usage plus the ratio of entity count to the
+//	                maximum entity count among candidates (default)
+//	least_entities  the game with the least entities
+//	least_cpu       the game with the least CPU usage
+//	round_robin     games in turn regardless of load
+//
+// Other policies can be plugged in by RegisterPolicy for custom builds of dispatcher.
+
+// Load of the game tracked by dispatcher
+type GameLoad struct {
+	GameID   uint16
+	Entities int     // entities reported by the game, plus entities placed on it since the report
+	CPU      float64 // busy ratio of the game main loop, 0 ~ 1
+}
+
+// Policy chooses the game among candidates, which is never empty. Choose is not called concurrently.
+type Policy interface {
+	Choose(candidates []GameLoad) uint16
+}
+
+type PolicyFactory func() Policy
+
+var (
+	policyFactoriesLock sync.Mutex
+	policyFactories     = map[string]PolicyFactory{
+		"least_loaded":   func() Policy { return leastLoadedPolicy{} },
+		"least_entities": func() Policy { return leastEntitiesPolicy{} },
+		"least_cpu":      func() Policy { return leastCPUPolicy{} },
+		"round_robin":    func() Policy { return &roundRobinPolicy{} },
+	}
+)
+
+// Register placement policy which can be configured by placement_policy, should be called before the dispatcher starts
+func RegisterPolicy(name string, factory PolicyFactory) {
+	policyFactoriesLock.Lock()
+	policyFactories[name] = factory
+	policyFactoriesLock.Unlock()
+}
+
+// Create the placement policy by name
+func NewPolicy(name string) (Policy, error) {
+	policyFactoriesLock.Lock()
+	factory := policyFactories[name]
+	policyFactoriesLock.Unlock()
+	if factory == nil {
+		return nil, errors.Errorf("unknown placement policy: %s", name)
+	}
+	return factory(), nil
+}
+
+// choose the candidate of the least score, the first one wins if scores are equal
+func chooseLeast(candidates []GameLoad, score func(load *GameLoad) float64) uint16 {
+	best := 0
+	bestScore := score(&candidates[0])
+	for i := 1; i < len(candidates); i++ {
+		if s := score(&candidates[i]); s < bestScore {
+			best, bestScore = i, s
+		}
+	}
+	return candidates[best].GameID
+}
+
+type leastLoadedPolicy struct{}
+
+func (leastLoadedPolicy) Choose(candidates []GameLoad) uint16 {
+	maxEntities := 1
+	for _, load := range candidates {
+		if load.Entities > maxEntities {
+			maxEntities = load.Entities
+		}
+	}
+	return chooseLeast(candidates, func(load *GameLoad) float64 {
+		return load.CPU + float64(load.Entities)/float64(maxEntities)
+	})
+}
+
+type leastEntitiesPolicy struct{}
+
+func (leastEntitiesPolicy) Choose(candidates []GameLoad) uint16 {
+	return chooseLeast(candidates, func(load *GameLoad) float64 {
+		return float64(load.Entities)
+	})
+}
+
+type leastCPUPolicy struct{}
+
+func (leastCPUPolicy) Choose(candidates []GameLoad) uint16 {
+	return chooseLeast(candidates, func(load *GameLoad) float64 {
+		return load.CPU
+	})
+}
+
+type roundRobinPolicy struct {
+	lastGameID uint16
+}
+
+// choose the first candidate after the last chosen game
+func (p *roundRobinPolicy) Choose(candidates []GameLoad) uint16 {
+	chosen := candidates[0].GameID
+	for _, load := range candidates {
+		if load.GameID > p.lastGameID {
+			chosen = load.GameID
+			break
+		}
+	}
+	p.lastGameID = chosen
+	return chosen
+}
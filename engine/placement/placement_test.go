@@ -0,0 +1,53 @@
+package placement
+
+import "testing"
+
+func choose(t *testing.T, name string, candidates []GameLoad) uint16 {
+	p, err := NewPolicy(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Choose(candidates)
+}
+
+func TestLeastLoadedPolicy(t *testing.T) {
+	candidates := []GameLoad{{1, 100, 0.5}, {2, 200, 0.1}, {3, 50, 0.9}}
+	if gameid := choose(t, "least_loaded", candidates); gameid != 1 {
+		t.Errorf("least_loaded chose game %d, should be 1", gameid)
+	}
+	if gameid := choose(t, "least_entities", candidates); gameid != 3 {
+		t.Errorf("least_entities chose game %d, should be 3", gameid)
+	}
+	if gameid := choose(t, "least_cpu", candidates); gameid != 2 {
+		t.Errorf("least_cpu chose game %d, should be 2", gameid)
+	}
+}
+
+func TestRoundRobinPolicy(t *testing.T) {
+	p, err := NewPolicy("round_robin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidates := []GameLoad{{1, 0, 0}, {3, 0, 0}, {4, 0, 0}}
+	for _, expected := range []uint16{1, 3, 4, 1, 3} {
+		if gameid := p.Choose(candidates); gameid != expected {
+			t.Errorf("round_robin chose game %d, should be %d", gameid, expected)
+		}
+	}
+}
+
+type firstPolicy struct{}
+
+func (firstPolicy) Choose(candidates []GameLoad) uint16 {
+	return candidates[0].GameID
+}
+
+func TestRegisterPolicy(t *testing.T) {
+	if _, err := NewPolicy("first"); err == nil {
+		t.Errorf("unknown placement policy should fail")
+	}
+	RegisterPolicy("first", func() Policy { return firstPolicy{} })
+	if gameid := choose(t, "first", []GameLoad{{2, 0, 0}, {1, 0, 0}}); gameid != 2 {
+		t.Errorf("first chose game %d, should be 2", gameid)
+	}
+}
@@ -23,26 +23,84 @@ func NewGoWorldConnection(conn netutil.Connection, compressed bool) *GoWorldConn
 	}
 }
 
-func (gwc *GoWorldConnection) SendSetGameID(id uint16, isReconnect bool, isRestore bool) error {
+func (gwc *GoWorldConnection) SendSetGameID(id uint16, isReconnect bool, isRestore bool, secret string) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_SET_GAME_ID)
 	packet.AppendUint16(id)
 	packet.AppendBool(isReconnect)
 	packet.AppendBool(isRestore)
+	gwc.appendAuthToken(packet, secret, AUTH_ROLE_GAME, id)
 	err := gwc.SendPacket(packet)
 	packet.Release()
 	return err
 }
 
-func (gwc *GoWorldConnection) SendSetGateID(id uint16) error {
+func (gwc *GoWorldConnection) SendSetGateID(id uint16, secret string) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_SET_GATE_ID)
 	packet.AppendUint16(id)
+	gwc.appendAuthToken(packet, secret, AUTH_ROLE_GATE, id)
 	err := gwc.SendPacket(packet)
 	packet.Release()
 	return err
 }
 
+func (gwc *GoWorldConnection) SendSetStandbyDispatcher(secret string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_STANDBY_DISPATCHER)
+	gwc.appendAuthToken(packet, secret, AUTH_ROLE_STANDBY, 0)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Replicate the game of entity to standby dispatcher, gameid=0 if the entity is removed
+func (gwc *GoWorldConnection) SendReplicateEntityLocation(id EntityID, gameid uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REPLICATE_ENTITY_LOCATION)
+	packet.AppendEntityID(id)
+	packet.AppendUint16(gameid)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendReplicateService(id EntityID, serviceName string, declared bool) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REPLICATE_SERVICE)
+	packet.AppendEntityID(id)
+	packet.AppendVarStr(serviceName)
+	packet.AppendBool(declared)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Replicate the target game of client to standby dispatcher, gameid=0 if the client is disconnected
+func (gwc *GoWorldConnection) SendReplicateClientTarget(clientid ClientID, gameid uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REPLICATE_CLIENT_TARGET)
+	packet.AppendClientID(clientid)
+	packet.AppendUint16(gameid)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendReplicateHeartbeat() error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REPLICATE_HEARTBEAT)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) appendAuthToken(packet *netutil.Packet, secret string, role string, id uint16) {
+	timestamp := time.Now().Unix()
+	packet.AppendUint64(uint64(timestamp))
+	packet.AppendVarBytes(ComputeAuthToken(secret, role, id, timestamp))
+}
+
 func (gwc *GoWorldConnection) SendNotifyCreateEntity(id EntityID) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_NOTIFY_CREATE_ENTITY)
@@ -51,6 +109,16 @@ func (gwc *GoWorldConnection) SendNotifyCreateEntity(id EntityID) error {
 	packet.Release()
 	return err
 }
+func (gwc *GoWorldConnection) SendClaimEntity(reqid uint32, id EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLAIM_ENTITY)
+	packet.AppendUint32(reqid)
+	packet.AppendEntityID(id)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
 func (gwc *GoWorldConnection) SendNotifyDestroyEntity(id EntityID) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_NOTIFY_DESTROY_ENTITY)
@@ -78,9 +146,27 @@ func (gwc *GoWorldConnection) SendNotifyClientDisconnected(id ClientID) error {
 	return err
 }
 
-func (gwc *GoWorldConnection) SendCreateEntityAnywhere(typeName string, data map[string]interface{}) error {
+// Notify the owner entity that the client disconnected from oldGate is reattached on newGate in the reconnect window
+func (gwc *GoWorldConnection) SendNotifyClientResumed(id ClientID, oldGate uint16, newGate uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_CLIENT_RESUMED)
+	packet.AppendClientID(id)
+	packet.AppendUint16(oldGate)
+	packet.AppendUint16(newGate)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Send create entity anywhere request, reqid is 0 if the caller does not need to be acknowledged
+//
+// The dispatcher appends the caller gameid to the packet, so that the ack can be routed back
+// Send the create entity anywhere request, the entity is created on a game matching the placement constraint
+func (gwc *GoWorldConnection) SendCreateEntityAnywhere(typeName string, data map[string]interface{}, reqid uint32, placement string) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_CREATE_ENTITY_ANYWHERE)
+	packet.AppendVarStr(placement) // placement and reqid are read by dispatcher for choosing game
+	packet.AppendUint32(reqid)
 	packet.AppendVarStr(typeName)
 	packet.AppendData(data)
 	err := gwc.SendPacket(packet)
@@ -88,11 +174,27 @@ func (gwc *GoWorldConnection) SendCreateEntityAnywhere(typeName string, data map
 	return err
 }
 
-func (gwc *GoWorldConnection) SendLoadEntityAnywhere(typeName string, entityID EntityID) error {
+// Send the result of create entity anywhere request to the caller game, errmsg is empty if entity is created
+func (gwc *GoWorldConnection) SendCreateEntityAnywhereAck(gameid uint16, reqid uint32, entityID EntityID, errmsg string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CREATE_ENTITY_ANYWHERE_ACK)
+	packet.AppendUint16(gameid)
+	packet.AppendUint32(reqid)
+	packet.AppendVarStr(errmsg)
+	if errmsg == "" {
+		packet.AppendEntityID(entityID) // entity ID is sent only if entity is created
+	}
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendLoadEntityAnywhere(typeName string, entityID EntityID, placement string) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_LOAD_ENTITY_ANYWHERE)
 	packet.AppendEntityID(entityID)
 	packet.AppendVarStr(typeName)
+	packet.AppendVarStr(placement)
 	err := gwc.SendPacket(packet)
 	packet.Release()
 	return err
@@ -108,12 +210,99 @@ func (gwc *GoWorldConnection) SendDeclareService(id EntityID, serviceName string
 	return err
 }
 
-func (gwc *GoWorldConnection) SendCallEntityMethod(id EntityID, method string, args []interface{}) error {
+func (gwc *GoWorldConnection) SendReportGameLoad(load uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REPORT_GAME_LOAD)
+	packet.AppendUint32(load)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendReportGameStats(stats *GameStats) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REPORT_GAME_STATS)
+	packet.AppendData(stats)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendStartClusterSavePoint(reqid uint32, label string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_START_CLUSTER_SAVE_POINT)
+	packet.AppendUint32(reqid)
+	packet.AppendVarStr(label)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendClusterSavePointPrepareAck(saveid uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLUSTER_SAVE_POINT_PREPARE_ACK)
+	packet.AppendUint32(saveid)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendClusterSavePointCommitAck(saveid uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLUSTER_SAVE_POINT_COMMIT_ACK)
+	packet.AppendUint32(saveid)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendFinishClusterSavePoint(saveid uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_FINISH_CLUSTER_SAVE_POINT)
+	packet.AppendUint32(saveid)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Send call entity method request, trace is the marshaled span context of caller, or empty if not traced
+func (gwc *GoWorldConnection) SendCallEntityMethod(id EntityID, method string, args []interface{}, trace []byte) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_CALL_ENTITY_METHOD)
 	packet.AppendEntityID(id)
 	packet.AppendVarStr(method)
 	packet.AppendArgs(args)
+	packet.AppendVarBytes(trace)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Send call entity method request which should be replied with results
+//
+// The dispatcher appends the caller gameid to the packet, so that the results can be routed back
+func (gwc *GoWorldConnection) SendCallEntityMethodWithResult(id EntityID, reqid uint32, timeout time.Duration, method string, args []interface{}, trace []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_WITH_RESULT)
+	packet.AppendEntityID(id)
+	packet.AppendUint32(reqid)
+	packet.AppendUint32(uint32(timeout / time.Millisecond))
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	packet.AppendVarBytes(trace)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Send results of call entity method request to the caller game, errmsg is empty if the call succeeds
+func (gwc *GoWorldConnection) SendCallEntityMethodResult(gameid uint16, reqid uint32, errmsg string, results []interface{}) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_RESULT)
+	packet.AppendUint16(gameid)
+	packet.AppendUint32(reqid)
+	packet.AppendVarStr(errmsg)
+	packet.AppendArgs(results)
 	err := gwc.SendPacket(packet)
 	packet.Release()
 	return err
@@ -148,6 +337,26 @@ func (gwc *GoWorldConnection) SendCreateEntityOnClient(gid uint16, clientid Clie
 	return err
 }
 
+// Send create entity on client with client data already packed by netutil.MSG_PACKER
+func (gwc *GoWorldConnection) SendCreateEntityOnClientWithPackedData(gid uint16, clientid ClientID, typeName string, entityid EntityID,
+	isPlayer bool, packedClientData []byte, x, y, z float32, yaw float32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CREATE_ENTITY_ON_CLIENT)
+	packet.AppendUint16(gid)
+	packet.AppendClientID(clientid)
+	packet.AppendBool(isPlayer)
+	packet.AppendEntityID(entityid)
+	packet.AppendVarStr(typeName)
+	packet.AppendFloat32(x)
+	packet.AppendFloat32(y)
+	packet.AppendFloat32(z)
+	packet.AppendFloat32(yaw)
+	packet.AppendVarBytes(packedClientData)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
 func (gwc *GoWorldConnection) SendSyncPositionYawFromClient(entityID EntityID, x, y, z float32, yaw float32) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_SYNC_POSITION_YAW_FROM_CLIENT)
@@ -278,6 +487,110 @@ func (gwc *GoWorldConnection) SendCallEntityMethodOnClient(gid uint16, clientid
 	return
 }
 
+// Send the call to client which should be acknowledged by client, the gate resends it when client resumes session
+func (gwc *GoWorldConnection) SendCallEntityMethodOnClientAcked(gid uint16, clientid ClientID, entityID EntityID, method string, args []interface{}) (err error) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED)
+	packet.AppendUint16(gid)
+	packet.AppendClientID(clientid)
+	packet.AppendEntityID(entityID)
+	packet.AppendVarStr(method)
+	packet.AppendArgs(args)
+	err = gwc.SendPacket(packet)
+	packet.Release()
+	return
+}
+
+// Acknowledge all acknowledged messages received from the gate up to seq
+func (gwc *GoWorldConnection) SendAckClientMessage(seq uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_ACK_CLIENT_MESSAGE)
+	packet.AppendUint32(seq)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Negotiate the encoding of client packets at handshake, the gate echoes the accepted encoding
+func (gwc *GoWorldConnection) SendSetClientEncoding(encoding string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_ENCODING)
+	packet.AppendVarStr(encoding)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Send the token of client for authentication, the gate echoes with the user of the token if accepted
+func (gwc *GoWorldConnection) SendAuthClient(token string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_AUTH_CLIENT)
+	packet.AppendVarStr(token)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Resume the session of previous connection after reconnecting, unacknowledged messages after ackedSeq are resent
+func (gwc *GoWorldConnection) SendResumeClientSession(clientid ClientID, token string, ackedSeq uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RESUME_CLIENT_SESSION)
+	packet.AppendClientID(clientid)
+	packet.AppendVarStr(token)
+	packet.AppendUint32(ackedSeq)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendSetClientSession(gid uint16, clientid ClientID, token string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_SESSION)
+	packet.AppendUint16(gid)
+	packet.AppendClientID(clientid)
+	packet.AppendVarStr(token)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Answer the session resume request of client, kept is true if the client is reattached to its owner entity
+func (gwc *GoWorldConnection) SendResumeClientSessionAck(gid uint16, clientid ClientID, kept bool) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RESUME_CLIENT_SESSION_ACK)
+	packet.AppendUint16(gid)
+	packet.AppendClientID(clientid)
+	packet.AppendBool(kept)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Request the gate of the session to transfer the session to fromGid, for the client reconnected to another gate
+func (gwc *GoWorldConnection) SendTransferClientSession(gid uint16, fromGid uint16, clientid ClientID, token string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_TRANSFER_CLIENT_SESSION)
+	packet.AppendUint16(gid)
+	packet.AppendUint16(fromGid)
+	packet.AppendClientID(clientid)
+	packet.AppendVarStr(token)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Notify the client that the gate is draining, the client should reconnect to another gate before the gate shuts down
+func (gwc *GoWorldConnection) SendNotifyGateDraining(gid uint16, clientid ClientID, message string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_GATE_DRAINING)
+	packet.AppendUint16(gid)
+	packet.AppendClientID(clientid)
+	packet.AppendVarStr(message)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
 func (gwc *GoWorldConnection) SendSetClientFilterProp(gid uint16, clientid ClientID, key, val string) (err error) {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_SET_CLIENTPROXY_FILTER_PROP)
@@ -300,6 +613,31 @@ func (gwc *GoWorldConnection) SendClearClientFilterProp(gid uint16, clientid Cli
 	return
 }
 
+func (gwc *GoWorldConnection) SendKickClient(gid uint16, clientid ClientID, reason KickReason, message string) (err error) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_KICK_CLIENT)
+	packet.AppendUint16(gid)
+	packet.AppendClientID(clientid)
+	packet.AppendUint16(uint16(reason))
+	packet.AppendVarStr(message)
+	err = gwc.SendPacket(packet)
+	packet.Release()
+	return
+}
+
+// Kick the client and ban its IP at gate for the duration
+func (gwc *GoWorldConnection) SendBanClient(gid uint16, clientid ClientID, duration time.Duration, message string) (err error) {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_BAN_CLIENT)
+	packet.AppendUint16(gid)
+	packet.AppendClientID(clientid)
+	packet.AppendUint32(uint32(duration / time.Second))
+	packet.AppendVarStr(message)
+	err = gwc.SendPacket(packet)
+	packet.Release()
+	return
+}
+
 func (gwc *GoWorldConnection) SendCallFilterClientProxies(key string, val string, method string, args []interface{}) (err error) {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_CALL_FILTERED_CLIENTS)
@@ -322,8 +660,37 @@ func (gwc *GoWorldConnection) SendMigrateRequest(spaceID EntityID, entityID Enti
 	return err
 }
 
+// Cancel the migration of entity acknowledged by dispatcher, so that calls queued for the migration are sent to the entity
+func (gwc *GoWorldConnection) SendCancelMigrate(entityID EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CANCEL_MIGRATE)
+	packet.AppendEntityID(entityID)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Request dispatcher to block calls to entities of the group migrating to the target game
+//
+// The dispatcher appends whether all entities are on the caller game and the target game is connected
+func (gwc *GoWorldConnection) SendMigrateGroupRequest(groupID uint32, targetGame uint16, eids []EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_MIGRATE_GROUP_REQUEST)
+	packet.AppendUint32(groupID)
+	packet.AppendUint16(targetGame)
+	packet.AppendUint16(uint16(len(eids)))
+	for _, eid := range eids {
+		packet.AppendEntityID(eid)
+	}
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Send the entity to the target game, groupID and groupSize are 0 if the entity is not migrating in a group
 func (gwc *GoWorldConnection) SendRealMigrate(eid EntityID, targetGame uint16, targetSpace EntityID, x, y, z float32,
-	typeName string, migrateData map[string]interface{}, timerData []byte, clientid ClientID, clientsrv uint16) error {
+	typeName string, token uint32, baseToken uint32, migratePayload []byte, timerData []byte, clientid ClientID, clientsrv uint16,
+	groupID uint32, groupSize uint16) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_REAL_MIGRATE)
 	packet.AppendEntityID(eid)
@@ -342,14 +709,95 @@ func (gwc *GoWorldConnection) SendRealMigrate(eid EntityID, targetGame uint16, t
 	packet.AppendFloat32(y)
 	packet.AppendFloat32(z)
 	packet.AppendVarStr(typeName)
-	packet.AppendData(migrateData)
+	packet.AppendUint32(token)
+	packet.AppendUint32(baseToken) // migrate payload is the diff to base if base token is not 0
+	packet.AppendVarBytes(migratePayload)
 	packet.AppendVarBytes(timerData)
+	packet.AppendUint32(groupID)
+	packet.AppendUint16(groupSize)
 
 	err := gwc.SendPacket(packet)
 	packet.Release()
 	return err
 }
 
+func (gwc *GoWorldConnection) SendRequestMigrateData(sourceGame uint16, eid EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REQUEST_MIGRATE_DATA)
+	packet.AppendUint16(sourceGame)
+	packet.AppendEntityID(eid)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendMigrateData(targetGame uint16, eid EntityID, token uint32, migrateData []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_MIGRATE_DATA)
+	packet.AppendUint16(targetGame)
+	packet.AppendEntityID(eid)
+	packet.AppendUint32(token)
+	packet.AppendVarBytes(migrateData)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+// Send the replica of entity to standby games of this game, empty data means the replica is removed
+func (gwc *GoWorldConnection) SendReplicateGameEntity(eid EntityID, typeName string, data []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REPLICATE_GAME_ENTITY)
+	packet.AppendEntityID(eid)
+	packet.AppendVarStr(typeName)
+	packet.AppendVarBytes(data)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendRegisterLogin(reqid uint32, loginKey string, clientid ClientID, gateid uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REGISTER_LOGIN)
+	packet.AppendUint32(reqid)
+	packet.AppendVarStr(loginKey)
+	packet.AppendClientID(clientid)
+	packet.AppendUint16(gateid)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendRegisterLoginAck(reqid uint32, ok bool) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REGISTER_LOGIN_ACK)
+	packet.AppendUint32(reqid)
+	packet.AppendBool(ok)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendSetMaintenanceMode(enabled bool, message string, allowlist []string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_MAINTENANCE_MODE)
+	packet.AppendBool(enabled)
+	packet.AppendVarStr(message)
+	packet.AppendStringList(allowlist)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
+func (gwc *GoWorldConnection) SendNotifyGwvarChange(name string, data string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_GWVAR_CHANGE)
+	packet.AppendVarStr(name)
+	packet.AppendVarStr(data)
+	err := gwc.SendPacket(packet)
+	packet.Release()
+	return err
+}
+
 func (gwc *GoWorldConnection) SendStartFreezeGame(gameid uint16) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_START_FREEZE_GAME)
@@ -0,0 +1,41 @@
+package proto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+)
+
+const (
+	AUTH_ROLE_GAME    = "game"
+	AUTH_ROLE_GATE    = "gate"
+	AUTH_ROLE_STANDBY = "standby"
+)
+
+// Compute the token for game / gate to authenticate to dispatcher with the shared secret
+func ComputeAuthToken(secret string, role string, id uint16, timestamp int64) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	var buf [10]byte
+	binary.LittleEndian.PutUint16(buf[:2], id)
+	binary.LittleEndian.PutUint64(buf[2:], uint64(timestamp))
+	mac.Write([]byte(role))
+	mac.Write(buf[:])
+	return mac.Sum(nil)
+}
+
+// Verify the token sent by game / gate, always succeed if secret is not configured
+func VerifyAuthToken(secret string, role string, id uint16, timestamp int64, token []byte) bool {
+	if secret == "" {
+		return true
+	}
+
+	d := time.Since(time.Unix(timestamp, 0))
+	if d < -consts.DISPATCHER_AUTH_TIMESTAMP_TOLERANCE || d > consts.DISPATCHER_AUTH_TIMESTAMP_TOLERANCE {
+		return false
+	}
+
+	return hmac.Equal(token, ComputeAuthToken(secret, role, id, timestamp))
+}
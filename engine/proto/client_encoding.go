@@ -0,0 +1,115 @@
+package proto
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Encodings of packets between gate and clients
+//
+// Clients use msgpack by default. If client_encoding_handshake is enabled for the gate, clients must send
+// MT_SET_CLIENT_ENCODING as the first packet, and the gate echoes the accepted encoding before the client is connected
+// to the game. For clients of protobuf encoding:
+//
+//	to client      msgpack data in packets, e.g. attributes and arguments, are transcoded to goworld.Value by the gate
+//	from client    arguments of MT_CALL_ENTITY_METHOD_FROM_CLIENT are one typed message of the RPC as var bytes, which
+//	               is decoded by the game according to the RPC method
+//
+// Packet layouts are the same for both encodings otherwise. `gwtool gen-proto` generates the .proto of messages.
+const (
+	CLIENT_ENCODING_MSGPACK  = "msgpack"
+	CLIENT_ENCODING_PROTOBUF = "protobuf"
+)
+
+// Fields in client packets after message types, positive values are sizes of fixed length fields
+const (
+	_CLIENT_FIELD_VAR_BYTES = -1 - iota // var bytes copied as is, e.g. var strings
+	_CLIENT_FIELD_DATA                  // var bytes of msgpack data
+	_CLIENT_FIELD_ARGS                  // arguments of msgpack data
+)
+
+const (
+	_CLIENT_FIELD_GATE_CLIENT = 2 + common.CLIENTID_LENGTH // gid and clientid of packets redirected to client proxy
+)
+
+var (
+	clientPacketLayouts = map[MsgType_t][]int{
+		MT_CREATE_ENTITY_ON_CLIENT:            {_CLIENT_FIELD_GATE_CLIENT, 1 + common.ENTITYID_LENGTH, _CLIENT_FIELD_VAR_BYTES, 16, _CLIENT_FIELD_DATA},
+		MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT:   {_CLIENT_FIELD_GATE_CLIENT, common.ENTITYID_LENGTH, _CLIENT_FIELD_DATA, _CLIENT_FIELD_VAR_BYTES, _CLIENT_FIELD_DATA},
+		MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT:      {_CLIENT_FIELD_GATE_CLIENT, common.ENTITYID_LENGTH, _CLIENT_FIELD_DATA, _CLIENT_FIELD_VAR_BYTES},
+		MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT:  {_CLIENT_FIELD_GATE_CLIENT, common.ENTITYID_LENGTH, _CLIENT_FIELD_DATA, 4, _CLIENT_FIELD_DATA},
+		MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT:     {_CLIENT_FIELD_GATE_CLIENT, common.ENTITYID_LENGTH, _CLIENT_FIELD_DATA},
+		MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT:  {_CLIENT_FIELD_GATE_CLIENT, common.ENTITYID_LENGTH, _CLIENT_FIELD_DATA, _CLIENT_FIELD_DATA},
+		MT_CALL_ENTITY_METHOD_ON_CLIENT:       {_CLIENT_FIELD_GATE_CLIENT, common.ENTITYID_LENGTH, _CLIENT_FIELD_VAR_BYTES, _CLIENT_FIELD_ARGS},
+		MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED: {_CLIENT_FIELD_GATE_CLIENT, 4, common.ENTITYID_LENGTH, _CLIENT_FIELD_VAR_BYTES, _CLIENT_FIELD_ARGS},
+		MT_CALL_FILTERED_CLIENTS:              {_CLIENT_FIELD_VAR_BYTES, _CLIENT_FIELD_VAR_BYTES, _CLIENT_FIELD_VAR_BYTES, _CLIENT_FIELD_ARGS},
+	}
+
+	protobufPacker = netutil.ProtobufMsgPacker{}
+)
+
+// Check if the encoding of client packets is supported
+func IsValidClientEncoding(encoding string) bool {
+	return encoding == CLIENT_ENCODING_MSGPACK || encoding == CLIENT_ENCODING_PROTOBUF
+}
+
+// Transcode msgpack data in the packet to client as protobuf, returns nil if the packet does not contain msgpack data
+func TranscodeClientPacketToProtobuf(packet *netutil.Packet) (pkt *netutil.Packet, err error) {
+	payload := packet.Payload()
+	msgtype := MsgType_t(netutil.PACKET_ENDIAN.Uint16(payload))
+	layout, ok := clientPacketLayouts[msgtype]
+	if !ok {
+		return nil, nil
+	}
+
+	src := netutil.NewPacket() // read from the start of payload without changing the read cursor of packet
+	src.AppendBytes(payload)
+	defer src.Release()
+	pkt = netutil.NewPacket()
+	defer func() {
+		if e := recover(); e != nil { // packet reads panic on malformed packets
+			err = errors.Errorf("malformed packet %s: %v", MsgTypeToString(msgtype), e)
+		}
+		if err != nil {
+			pkt.Release()
+			pkt = nil
+		}
+	}()
+
+	pkt.AppendUint16(src.ReadUint16())
+	for _, field := range layout {
+		switch field {
+		case _CLIENT_FIELD_VAR_BYTES:
+			pkt.AppendVarBytes(src.ReadVarBytes())
+		case _CLIENT_FIELD_DATA:
+			if err = transcodeDataToProtobuf(pkt, src.ReadVarBytes()); err != nil {
+				return
+			}
+		case _CLIENT_FIELD_ARGS:
+			argCount := src.ReadUint16()
+			pkt.AppendUint16(argCount)
+			for i := uint16(0); i < argCount; i++ {
+				if err = transcodeDataToProtobuf(pkt, src.ReadVarBytes()); err != nil {
+					return
+				}
+			}
+		default:
+			pkt.AppendBytes(src.ReadBytes(uint32(field)))
+		}
+	}
+	return
+}
+
+func transcodeDataToProtobuf(pkt *netutil.Packet, data []byte) error {
+	var v interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(data, &v); err != nil {
+		return err
+	}
+	b, err := protobufPacker.PackMsg(v, nil)
+	if err != nil {
+		return err
+	}
+	pkt.AppendVarBytes(b)
+	return nil
+}
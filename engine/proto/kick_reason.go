@@ -0,0 +1,18 @@
+package proto
+
+// Reason codes sent to client when the server kicks the client
+type KickReason uint16
+
+const (
+	KICK_REASON_NONE KickReason = iota
+	KICK_REASON_BANNED
+	KICK_REASON_DUPLICATE_LOGIN
+	KICK_REASON_MAINTENANCE
+	KICK_REASON_SERVER_SHUTDOWN
+	KICK_REASON_GM           // kicked by game master
+	KICK_REASON_AUTH_FAILED  // the token of client is rejected by gate
+	KICK_REASON_RATE_LIMITED // too many calls from client
+)
+
+// Custom kick reason codes should start from here
+const KICK_REASON_CUSTOM_START KickReason = 1000
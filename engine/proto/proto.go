@@ -38,6 +38,55 @@ const (
 	// Message types for migrating
 	MT_MIGRATE_REQUEST
 	MT_REAL_MIGRATE
+
+	// Message types for login sessions
+	MT_REGISTER_LOGIN
+	MT_REGISTER_LOGIN_ACK
+	MT_SET_MAINTENANCE_MODE
+	MT_NOTIFY_GWVAR_CHANGE
+	MT_CREATE_ENTITY_ANYWHERE_ACK
+	MT_CALL_ENTITY_METHOD_WITH_RESULT
+	MT_CALL_ENTITY_METHOD_RESULT
+	MT_REPORT_GAME_LOAD
+	MT_START_CLUSTER_SAVE_POINT
+	MT_START_CLUSTER_SAVE_POINT_ACK
+	MT_CLUSTER_SAVE_POINT_PREPARE
+	MT_CLUSTER_SAVE_POINT_PREPARE_ACK
+	MT_CLUSTER_SAVE_POINT_COMMIT
+	MT_CLUSTER_SAVE_POINT_COMMIT_ACK
+	MT_FINISH_CLUSTER_SAVE_POINT
+	MT_REQUEST_MIGRATE_DATA
+	MT_MIGRATE_DATA
+	// Message types from clients to gate for acknowledged messages
+	MT_ACK_CLIENT_MESSAGE
+	MT_RESUME_CLIENT_SESSION
+	// Message types for replicating routing tables from primary dispatcher to standby dispatcher
+	MT_SET_STANDBY_DISPATCHER
+	MT_REPLICATE_ENTITY_LOCATION
+	MT_REPLICATE_SERVICE
+	MT_REPLICATE_CLIENT_TARGET
+	// Message types for reporting game stats to dispatchers for the cluster topology feed
+	MT_REPORT_GAME_STATS
+	// Message types for replicating entities from primary game to standby games and taking over the failed primary
+	MT_REPLICATE_GAME_ENTITY
+	MT_TAKEOVER_GAME
+	// Message types for clients of protobuf encoding
+	MT_SET_CLIENT_ENCODING                     // sent by client at handshake, and echoed by gate
+	MT_CALL_ENTITY_METHOD_FROM_PROTOBUF_CLIENT // sent by gate with arguments in protobuf message
+	// Message types for authenticating clients at gates
+	MT_AUTH_CLIENT // sent by client with the token as the first packet, and echoed by gate if accepted
+	// Message types for clients reconnecting in the reconnect window of gates
+	MT_NOTIFY_CLIENT_RESUMED // sent by gate when the client is reattached to its owner entity, maybe on another gate
+	// Message types for calls queued by dispatcher during migrations
+	MT_CANCEL_MIGRATE        // sent by game if the migration is not started after the migrate request is acknowledged
+	MT_NOTIFY_CALL_DROPPED   // sent by dispatcher to the caller game if the pending call queue of the entity overflows
+	MT_MIGRATE_GROUP_REQUEST // sent by game for blocking calls to a group of entities, and echoed by dispatcher
+	// Message types for protecting entities from being loaded on two games
+	MT_NOTIFY_CREATE_ENTITY_REJECTED // sent by dispatcher to the game creating the entity which is already on another game
+	MT_NOTIFY_ENTITY_ALREADY_LOADED  // sent by dispatcher to the game loading the entity which is already loaded or loading
+	MT_CLAIM_ENTITY                  // sent by game before loading the entity locally, and echoed by dispatcher with the game which the entity is on
+	// Message types for detecting failures of primary dispatcher by standby dispatcher
+	MT_REPLICATE_HEARTBEAT // sent by primary dispatcher to standby periodically
 )
 
 const ( // Message types that should be handled by GateService
@@ -65,6 +114,23 @@ const ( // Message types that should be handled by GateService
 	MT_CALL_FILTERED_CLIENTS
 	MT_SYNC_POSITION_YAW_ON_CLIENTS
 
+	// messages between gates routed by dispatcher, for clients resuming sessions on other gates
+	MT_TRANSFER_CLIENT_SESSION     // sent to the gate of the session by the gate of the new connection
+	MT_TRANSFER_CLIENT_SESSION_ACK // sent back with unacknowledged and buffered packets of the session
+	MT_FORWARD_CLIENT_PACKET       // packets to the client received by the old gate after the session is transferred
+
+	// message types for clients are appended, so that message types known by published clients keep their values
+	MT_REDIRECT_TO_GATEPROXY_EXT_MSG_TYPE_START // more messages that should be redirected to client proxy
+
+	MT_KICK_CLIENT
+	MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED // acknowledged by client and resent on session resume
+	MT_SET_CLIENT_SESSION                 // sent by gate, for resuming session after reconnecting
+	MT_NOTIFY_GATE_DRAINING               // sent by gate in draining mode, clients should reconnect to other gates
+	MT_RESUME_CLIENT_SESSION_ACK          // sent by gate, whether the client is reattached to its owner entity
+	MT_BAN_CLIENT                         // kick the client and ban its IP at gate for a duration
+
+	MT_REDIRECT_TO_GATEPROXY_EXT_MSG_TYPE_STOP
+
 	MT_GATE_SERVICE_MSG_TYPE_STOP
 )
 
@@ -83,6 +149,21 @@ type EntitySyncInfoToClient struct {
 	EntitySyncInfo
 }
 
+// Stats of game reported to dispatchers periodically
+type GameStats struct {
+	Entities   int          // number of entities on the game
+	Clients    int          // number of clients owned by entities on the game
+	SpaceCount int          // number of spaces on the game
+	CPU        float64      // busy ratio of the game main loop, 0 ~ 1
+	Spaces     []SpaceStats // the most crowded spaces, at most GAME_STATS_MAX_SPACES
+}
+
+type SpaceStats struct {
+	ID       common.EntityID
+	Kind     int
+	Entities int
+}
+
 func init() {
 	if unsafe.Sizeof(EntitySyncInfo{}) != SYNC_INFO_SIZE_PER_ENTITY {
 		gwlog.Fatal("Wrong type defintion for EntitySyncInfo: size is %d, but should be %d", unsafe.Sizeof(EntitySyncInfo{}), SYNC_INFO_SIZE_PER_ENTITY)
@@ -96,6 +177,12 @@ func init() {
 //
 //)
 
+// Returns if messages of the type should be redirected to client proxy by gate
+func IsRedirectToGateProxyMsgType(msgtype MsgType_t) bool {
+	return (msgtype >= MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP) ||
+		(msgtype >= MT_REDIRECT_TO_GATEPROXY_EXT_MSG_TYPE_START && msgtype <= MT_REDIRECT_TO_GATEPROXY_EXT_MSG_TYPE_STOP)
+}
+
 func MsgTypeToString(msgType MsgType_t) string {
 	return msgTypeToString[int(msgType)]
 }
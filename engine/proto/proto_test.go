@@ -2,51 +2,40 @@ package proto
 
 import (
 	"testing"
-
-	"github.com/xiaonanln/goworld/engine/uuid"
 )
 
-type testMsg struct {
-	ID        string
-	F1        float64
-	F2        int
-	ListField []interface{}
-	MapField  map[string]interface{}
-}
-
-func BenchmarkJSONMsgPacker(b *testing.B) {
-	benchmarkMsgPacker(b, &JSONMsgPacker{})
-}
-
-func BenchmarkMessagePackMsgPacker(b *testing.B) {
-	benchmarkMsgPacker(b, &MessagePackMsgPacker{})
-}
-
-func BenchmarkGobMsgPacker(b *testing.B) {
-	benchmarkMsgPacker(b, &GobMsgPacker{})
-}
-
-func benchmarkMsgPacker(b *testing.B, packer MsgPacker) {
-	b.Logf("Testing MsgPacker %T ...", packer)
-	msg := testMsg{
-		ID:        "abc",
-		F1:        0.123124234,
-		ListField: []interface{}{1, 2, 3, "abc", "def"},
-		MapField:  map[string]interface{}{},
-	}
-	for i := 0; i < 100; i++ {
-		msg.MapField[uuid.GenUUID()] = uuid.GenUUID()
+func TestClientMsgTypeValues(t *testing.T) {
+	// message types known by published clients must keep their values
+	for msgtype, val := range map[MsgType_t]MsgType_t{
+		MT_CREATE_ENTITY_ON_CLIENT:             1002,
+		MT_DESTROY_ENTITY_ON_CLIENT:            1003,
+		MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT:    1004,
+		MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT:       1005,
+		MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT:   1006,
+		MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT:      1007,
+		MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT:   1008,
+		MT_CALL_ENTITY_METHOD_ON_CLIENT:        1009,
+		MT_UPDATE_POSITION_ON_CLIENT:           1010,
+		MT_UPDATE_YAW_ON_CLIENT:                1011,
+		MT_SET_CLIENTPROXY_FILTER_PROP:         1012,
+		MT_CLEAR_CLIENTPROXY_FILTER_PROPS:      1013,
+		MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP: 1014,
+		MT_CALL_FILTERED_CLIENTS:               1015,
+		MT_SYNC_POSITION_YAW_ON_CLIENTS:        1016,
+	} {
+		if msgtype != val {
+			t.Errorf("message type %d should be %d", msgtype, val)
+		}
 	}
 
-	for i := 0; i < b.N; i++ {
-
-		buf := make([]byte, 0, 100)
-		buf, _ = packer.PackMsg(msg, buf)
-
-		var restoreMsg testMsg
-		_ = packer.UnpackMsg(buf, &restoreMsg)
-		if msg.ID != restoreMsg.ID {
-			b.Fail()
+	for _, msgtype := range []MsgType_t{MT_CREATE_ENTITY_ON_CLIENT, MT_CLEAR_CLIENTPROXY_FILTER_PROPS, MT_KICK_CLIENT, MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED, MT_BAN_CLIENT} {
+		if !IsRedirectToGateProxyMsgType(msgtype) {
+			t.Errorf("message type %d should be redirected to client proxy", msgtype)
+		}
+	}
+	for _, msgtype := range []MsgType_t{MT_CALL_FILTERED_CLIENTS, MT_SYNC_POSITION_YAW_ON_CLIENTS, MT_TRANSFER_CLIENT_SESSION, MT_FORWARD_CLIENT_PACKET} {
+		if IsRedirectToGateProxyMsgType(msgtype) {
+			t.Errorf("message type %d should not be redirected to client proxy", msgtype)
 		}
 	}
 }
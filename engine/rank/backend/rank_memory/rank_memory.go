@@ -0,0 +1,141 @@
+package rank_memory
+
+import (
+	"sort"
+
+	. "github.com/xiaonanln/goworld/engine/rank/types"
+)
+
+type memoryRankEngine struct {
+	boards map[string]*memoryBoard
+}
+
+// members sorted by rank, and scores of members
+type memoryBoard struct {
+	members []string
+	scores  map[string]float64
+}
+
+// Open the rank engine keeping ranks in memory, which is not thread-safe, and ranks are lost when the process quits
+func OpenMemoryRankEngine() RankEngine {
+	return &memoryRankEngine{
+		boards: map[string]*memoryBoard{},
+	}
+}
+
+// check if member1 is ranked before member2
+func rankedBefore(score1 float64, member1 string, score2 float64, member2 string) bool {
+	if score1 != score2 {
+		return score1 > score2
+	}
+	return member1 > member2
+}
+
+// search the index of the member with the score, or the index to insert it
+func (b *memoryBoard) search(member string, score float64) int {
+	return sort.Search(len(b.members), func(i int) bool {
+		m := b.members[i]
+		return !rankedBefore(b.scores[m], m, score, member)
+	})
+}
+
+func (b *memoryBoard) remove(member string) {
+	score, ok := b.scores[member]
+	if !ok {
+		return
+	}
+	i := b.search(member, score)
+	b.members = append(b.members[:i], b.members[i+1:]...)
+	delete(b.scores, member)
+}
+
+func (b *memoryBoard) insert(member string, score float64) {
+	i := b.search(member, score)
+	b.members = append(b.members, "")
+	copy(b.members[i+1:], b.members[i:])
+	b.members[i] = member
+	b.scores[member] = score
+}
+
+func (e *memoryRankEngine) board(board string, create bool) *memoryBoard {
+	b := e.boards[board]
+	if b == nil && create {
+		b = &memoryBoard{scores: map[string]float64{}}
+		e.boards[board] = b
+	}
+	return b
+}
+
+func (e *memoryRankEngine) SetScore(board string, member string, score float64) error {
+	b := e.board(board, true)
+	b.remove(member)
+	b.insert(member, score)
+	return nil
+}
+
+func (e *memoryRankEngine) IncrScore(board string, member string, delta float64) (float64, error) {
+	b := e.board(board, true)
+	score := b.scores[member] + delta
+	b.remove(member)
+	b.insert(member, score)
+	return score, nil
+}
+
+func (e *memoryRankEngine) Remove(board string, member string) error {
+	b := e.board(board, false)
+	if b == nil {
+		return nil
+	}
+	b.remove(member)
+	if len(b.members) == 0 {
+		delete(e.boards, board)
+	}
+	return nil
+}
+
+func (e *memoryRankEngine) Rank(board string, member string) (int, float64, error) {
+	b := e.board(board, false)
+	if b == nil {
+		return -1, 0, nil
+	}
+	score, ok := b.scores[member]
+	if !ok {
+		return -1, 0, nil
+	}
+	return b.search(member, score), score, nil
+}
+
+func (e *memoryRankEngine) Range(board string, start int, stop int) ([]RankItem, error) {
+	b := e.board(board, false)
+	if b == nil {
+		return nil, nil
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop > len(b.members) {
+		stop = len(b.members)
+	}
+
+	var items []RankItem
+	for rank := start; rank < stop; rank++ {
+		member := b.members[rank]
+		items = append(items, RankItem{Rank: rank, Member: member, Score: b.scores[member]})
+	}
+	return items, nil
+}
+
+func (e *memoryRankEngine) Count(board string) (int, error) {
+	b := e.board(board, false)
+	if b == nil {
+		return 0, nil
+	}
+	return len(b.members), nil
+}
+
+func (e *memoryRankEngine) Close() {
+}
+
+func (e *memoryRankEngine) IsEOF(err error) bool {
+	return false
+}
@@ -0,0 +1,100 @@
+package rank_redis
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+	. "github.com/xiaonanln/goworld/engine/rank/types"
+)
+
+const (
+	keyPrefix = "_RANK_"
+)
+
+// Rank engine keeping each board in a sorted set of Redis
+type redisRankEngine struct {
+	c redis.Conn
+}
+
+func OpenRedisRankEngine(host string, dbindex int) (RankEngine, error) {
+	c, err := redis.Dial("tcp", host)
+	if err != nil {
+		return nil, errors.Wrap(err, "redis dail failed")
+	}
+
+	if _, err := c.Do("SELECT", dbindex); err != nil {
+		c.Close()
+		return nil, errors.Wrap(err, "redis select db failed")
+	}
+
+	return &redisRankEngine{c: c}, nil
+}
+
+func (e *redisRankEngine) SetScore(board string, member string, score float64) error {
+	_, err := e.c.Do("ZADD", keyPrefix+board, score, member)
+	return err
+}
+
+func (e *redisRankEngine) IncrScore(board string, member string, delta float64) (float64, error) {
+	return redis.Float64(e.c.Do("ZINCRBY", keyPrefix+board, delta, member))
+}
+
+func (e *redisRankEngine) Remove(board string, member string) error {
+	_, err := e.c.Do("ZREM", keyPrefix+board, member)
+	return err
+}
+
+func (e *redisRankEngine) Rank(board string, member string) (int, float64, error) {
+	rank, err := redis.Int(e.c.Do("ZREVRANK", keyPrefix+board, member))
+	if err == redis.ErrNil {
+		return -1, 0, nil
+	} else if err != nil {
+		return -1, 0, err
+	}
+
+	score, err := redis.Float64(e.c.Do("ZSCORE", keyPrefix+board, member))
+	if err == redis.ErrNil { // removed between two commands, not possible since the service is the only writer
+		return -1, 0, nil
+	} else if err != nil {
+		return -1, 0, err
+	}
+	return rank, score, nil
+}
+
+func (e *redisRankEngine) Range(board string, start int, stop int) ([]RankItem, error) {
+	if start < 0 {
+		start = 0
+	}
+	if stop <= start {
+		return nil, nil
+	}
+
+	r, err := redis.Strings(e.c.Do("ZREVRANGE", keyPrefix+board, start, stop-1, "WITHSCORES"))
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]RankItem, 0, len(r)/2)
+	for i := 0; i+1 < len(r); i += 2 {
+		score, err := strconv.ParseFloat(r[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, RankItem{Rank: start + i/2, Member: r[i], Score: score})
+	}
+	return items, nil
+}
+
+func (e *redisRankEngine) Count(board string) (int, error) {
+	return redis.Int(e.c.Do("ZCARD", keyPrefix+board))
+}
+
+func (e *redisRankEngine) Close() {
+	e.c.Close()
+}
+
+func (e *redisRankEngine) IsEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF
+}
@@ -0,0 +1,231 @@
+package rank
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/rank/backend/rank_memory"
+	"github.com/xiaonanln/goworld/engine/rank/backend/rank_redis"
+	. "github.com/xiaonanln/goworld/engine/rank/types"
+)
+
+// Package rank runs operations of rank boards in a separate goroutine, on Redis sorted sets if configured, or in
+// memory otherwise. Rank boards are used by the rank service, which should be the only writer of boards.
+//
+// The module is initialized by the first operation, and callbacks are posted to the game goroutine.
+
+var (
+	rankEngine     RankEngine
+	rankOpQueue    *xnsyncutil.SyncQueue
+	rankTerminated *xnsyncutil.OneTimeCond
+
+	logger = gwlog.Module("rank")
+)
+
+type RankCallback func(err error)
+type RankScoreCallback func(score float64, err error)
+type RankQueryCallback func(rank int, score float64, err error) // rank is -1 if the member is not ranked
+type RankRangeCallback func(items []RankItem, count int, err error)
+
+type rankReq struct {
+	op  string // name of operation for monitoring
+	run func() error
+}
+
+func initialize() {
+	if rankOpQueue != nil {
+		return
+	}
+
+	logger.Info("rank initializing, config:\n%s", config.DumpPretty(config.GetRank()))
+	rankOpQueue = xnsyncutil.NewSyncQueue()
+	rankTerminated = xnsyncutil.NewOneTimeCond()
+	go rankRoutine(rankOpQueue, rankTerminated)
+}
+
+func assureRankEngineReady() (err error) {
+	if rankEngine != nil { // connection is valid
+		return
+	}
+
+	rankCfg := config.GetRank()
+	if rankCfg.Type == "redis" {
+		var dbindex int
+		dbindex, err = strconv.Atoi(rankCfg.DB)
+		if err == nil {
+			rankEngine, err = rank_redis.OpenRedisRankEngine(rankCfg.Host, dbindex)
+		}
+	} else if rankCfg.Type == "" {
+		rankEngine = rank_memory.OpenMemoryRankEngine()
+	} else {
+		logger.Fatal("rank type %s is not implemented", rankCfg.Type)
+	}
+	return
+}
+
+// Boards are prefixed by the namespace of game transparently, as keys of KVDB
+func boardKey(board string) string {
+	return common.GetLocalNamespace().KeyPrefix() + board
+}
+
+func pushReq(op string, run func() error) {
+	initialize()
+	rankOpQueue.Push(&rankReq{op, run})
+	checkOperationQueueLen()
+}
+
+// Set the score of member in the board
+func SetScore(board string, member string, score float64, callback RankCallback) {
+	board = boardKey(board)
+	pushReq("rank.setScore", func() error {
+		err := rankEngine.SetScore(board, member, score)
+		if callback != nil {
+			post.Post(func() {
+				callback(err)
+			})
+		}
+		return err
+	})
+}
+
+// Increase the score of member in the board by delta, the callback is called with the score after increment
+func IncrScore(board string, member string, delta float64, callback RankScoreCallback) {
+	board = boardKey(board)
+	pushReq("rank.incrScore", func() error {
+		score, err := rankEngine.IncrScore(board, member, delta)
+		if callback != nil {
+			post.Post(func() {
+				callback(score, err)
+			})
+		}
+		return err
+	})
+}
+
+// Remove the member from the board
+func Remove(board string, member string, callback RankCallback) {
+	board = boardKey(board)
+	pushReq("rank.remove", func() error {
+		err := rankEngine.Remove(board, member)
+		if callback != nil {
+			post.Post(func() {
+				callback(err)
+			})
+		}
+		return err
+	})
+}
+
+// Get the rank and score of member in the board, 0 is the top
+func GetRank(board string, member string, callback RankQueryCallback) {
+	board = boardKey(board)
+	pushReq("rank.getRank", func() error {
+		rank, score, err := rankEngine.Rank(board, member)
+		post.Post(func() {
+			callback(rank, score, err)
+		})
+		return err
+	})
+}
+
+// Get items of ranks in [start, stop) of the board, and the count of members in the board
+func GetRange(board string, start int, stop int, callback RankRangeCallback) {
+	board = boardKey(board)
+	pushReq("rank.getRange", func() error {
+		items, count, err := getRange(board, start, stop)
+		post.Post(func() {
+			callback(items, count, err)
+		})
+		return err
+	})
+}
+
+// Get items of n members ranked before and after the member, and the member itself
+//
+// Items are empty if the member is not ranked.
+func GetNeighbors(board string, member string, n int, callback RankRangeCallback) {
+	board = boardKey(board)
+	pushReq("rank.getNeighbors", func() error {
+		var items []RankItem
+		var count int
+		rank, _, err := rankEngine.Rank(board, member)
+		if err == nil && rank >= 0 {
+			items, count, err = getRange(board, rank-n, rank+n+1)
+		}
+		post.Post(func() {
+			callback(items, count, err)
+		})
+		return err
+	})
+}
+
+func getRange(board string, start int, stop int) ([]RankItem, int, error) {
+	items, err := rankEngine.Range(board, start, stop)
+	if err != nil {
+		return nil, 0, err
+	}
+	count, err := rankEngine.Count(board)
+	return items, count, err
+}
+
+// Close the rank module after all operations are finished, it is initialized again by the next operation
+//
+// Should be followed by WaitTerminated before any other operation.
+func Close() {
+	if rankOpQueue == nil {
+		return
+	}
+	rankOpQueue.Close()
+	rankOpQueue = nil
+}
+
+// Wait until the rank module is closed
+func WaitTerminated() {
+	if rankTerminated != nil {
+		rankTerminated.Wait()
+	}
+}
+
+var recentWarnedQueueLen = 0
+
+func checkOperationQueueLen() {
+	qlen := rankOpQueue.Len()
+	if qlen > 100 && qlen%100 == 0 && recentWarnedQueueLen != qlen {
+		logger.Warn("rank operation queue length = %d", qlen)
+		recentWarnedQueueLen = qlen
+	}
+}
+
+func rankRoutine(opQueue *xnsyncutil.SyncQueue, terminated *xnsyncutil.OneTimeCond) {
+	for {
+		err := assureRankEngineReady()
+		if err != nil {
+			logger.Error("rank engine is not ready: %s", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		req := opQueue.Pop()
+		if req == nil { // queue is closed, returning nil
+			break
+		}
+
+		rankReq := req.(*rankReq)
+		op := opmon.StartOperation(rankReq.op)
+		err = rankReq.run()
+		op.Finish(time.Millisecond * 100)
+
+		if err != nil && rankEngine.IsEOF(err) {
+			rankEngine.Close()
+			rankEngine = nil
+		}
+	}
+
+	terminated.Signal()
+}
@@ -0,0 +1,70 @@
+package rank
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/rank/backend/rank_memory"
+	. "github.com/xiaonanln/goworld/engine/rank/types"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	testRankBackend(t, rank_memory.OpenMemoryRankEngine())
+}
+
+func testRankBackend(t *testing.T, engine RankEngine) {
+	board := "__test_board__"
+	for i := 0; i < 10; i++ {
+		if err := engine.Remove(board, "m"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := engine.SetScore(board, "m"+strconv.Itoa(i), float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// m9 is the top, and m5 has the same score as m8 after increment, ranked after m8 by name
+	if score, err := engine.IncrScore(board, "m5", 3); err != nil || score != 8 {
+		t.Fatalf("incr score: %v %v", score, err)
+	}
+
+	if count, err := engine.Count(board); err != nil || count != 10 {
+		t.Fatalf("count: %v %v", count, err)
+	}
+	if rank, score, err := engine.Rank(board, "m9"); err != nil || rank != 0 || score != 9 {
+		t.Fatalf("rank of m9: %v %v %v", rank, score, err)
+	}
+	if rank, _, err := engine.Rank(board, "m5"); err != nil || rank != 2 {
+		t.Fatalf("rank of m5: %v %v", rank, err)
+	}
+	if rank, _, err := engine.Rank(board, "not_exists"); err != nil || rank != -1 {
+		t.Fatalf("rank of member not exists: %v %v", rank, err)
+	}
+
+	items, err := engine.Range(board, 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []RankItem{{Rank: 0, Member: "m9", Score: 9}, {Rank: 1, Member: "m8", Score: 8}, {Rank: 2, Member: "m5", Score: 8}, {Rank: 3, Member: "m7", Score: 7}}
+	if len(items) != len(expected) {
+		t.Fatalf("range: %v", items)
+	}
+	for i, item := range items {
+		if item != expected[i] {
+			t.Fatalf("range: %v, expected %v", items, expected)
+		}
+	}
+
+	if items, err := engine.Range(board, 8, 20); err != nil || len(items) != 2 || items[1] != (RankItem{Rank: 9, Member: "m0", Score: 0}) {
+		t.Fatalf("range of the last page: %v %v", items, err)
+	}
+
+	if err := engine.Remove(board, "m9"); err != nil {
+		t.Fatal(err)
+	}
+	if rank, _, err := engine.Rank(board, "m8"); err != nil || rank != 0 {
+		t.Fatalf("rank of m8 after m9 removed: %v %v", rank, err)
+	}
+}
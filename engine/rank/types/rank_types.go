@@ -0,0 +1,20 @@
+package rank_types
+
+// Engine of rank boards, members of each board are ranked by scores in descending order, members with the same
+// score are ranked by names in descending order, as ZREVRANGE of Redis
+type RankEngine interface {
+	SetScore(board string, member string, score float64) (err error)
+	IncrScore(board string, member string, delta float64) (score float64, err error) // members not exist are treated as 0
+	Remove(board string, member string) (err error)
+	Rank(board string, member string) (rank int, score float64, err error) // rank is -1 if member not exists, 0 is the top
+	Range(board string, start int, stop int) (items []RankItem, err error) // items of ranks in [start, stop)
+	Count(board string) (count int, err error)
+	Close()
+	IsEOF(err error) bool
+}
+
+type RankItem struct {
+	Rank   int
+	Member string
+	Score  float64
+}
@@ -0,0 +1,118 @@
+package entity_storage_bolt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	OPEN_TIMEOUT = time.Second * 5 // the database file is locked by the process opening it
+)
+
+// Entity storage backed by bbolt, an embedded key-value database in pure Go
+//
+// All entities are stored in one database file, with a bucket for each type. The database file can only be opened
+// by one process, so the storage only works for deployments with one game.
+type boltEntityStorage struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string) (EntityStorage, error) {
+	gwlog.Debug("Opening bolt database %s ...", path)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: OPEN_TIMEOUT})
+	if err != nil {
+		return nil, errors.Wrapf(err, "bolt open %s failed", path)
+	}
+
+	return &boltEntityStorage{
+		db: db,
+	}, nil
+}
+
+func putData(tx *bolt.Tx, typeName string, entityID common.EntityID, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(typeName))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(entityID), b)
+}
+
+func (es *boltEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return es.db.Update(func(tx *bolt.Tx) error {
+		return putData(tx, typeName, entityID, data)
+	})
+}
+
+// Write records in one transaction, so the database file is synced once
+func (es *boltEntityStorage) WriteBatch(records []EntityRecord) error {
+	return es.db.Update(func(tx *bolt.Tx) error {
+		for _, r := range records {
+			if err := putData(tx, r.TypeName, r.EntityID, r.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (es *boltEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	var data map[string]interface{}
+	err := es.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(typeName))
+		if bucket == nil {
+			return nil
+		}
+		b := bucket.Get([]byte(entityID))
+		if b == nil {
+			return nil // entity not exists
+		}
+		return json.Unmarshal(b, &data)
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *boltEntityStorage) Exists(typeName string, entityID common.EntityID) (exists bool, err error) {
+	err = es.db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(typeName)); bucket != nil {
+			exists = bucket.Get([]byte(entityID)) != nil
+		}
+		return nil
+	})
+	return
+}
+
+func (es *boltEntityStorage) List(typeName string) (entityIDs []common.EntityID, err error) {
+	err = es.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(typeName))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			entityIDs = append(entityIDs, common.EntityID(k))
+			return nil
+		})
+	})
+	return
+}
+
+func (es *boltEntityStorage) Close() {
+	es.db.Close()
+}
+
+func (es *boltEntityStorage) IsEOF(err error) bool {
+	return false
+}
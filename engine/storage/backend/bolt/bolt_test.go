@@ -0,0 +1,65 @@
+package entity_storage_bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+func TestBoltEntityStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := OpenBolt(filepath.Join(dir, "goworld.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	entityID := common.GenEntityID()
+	if data, err := es.Read("Avatar", entityID); data != nil || err != nil {
+		t.Errorf("should be nil: %v, %v", data, err)
+	}
+	if exists, err := es.Exists("Avatar", entityID); exists || err != nil {
+		t.Errorf("entity should not exist: %v", err)
+	}
+
+	if err := es.Write("Avatar", entityID, map[string]interface{}{"a": 1, "b": "2"}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := es.Read("Avatar", entityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := data.(map[string]interface{}); m["a"].(float64) != 1 || m["b"].(string) != "2" {
+		t.Errorf("read wrong data: %v", data)
+	}
+	if exists, err := es.Exists("Avatar", entityID); !exists || err != nil {
+		t.Errorf("entity should exist: %v", err)
+	}
+
+	otherID := common.GenEntityID()
+	err = es.WriteBatch([]EntityRecord{
+		{TypeName: "Avatar", EntityID: otherID, Data: map[string]interface{}{"a": 3}},
+		{TypeName: "Monster", EntityID: otherID, Data: map[string]interface{}{"a": 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := es.Read("Monster", otherID); data.(map[string]interface{})["a"].(float64) != 4 {
+		t.Errorf("read wrong data of batch: %v", data)
+	}
+	if avatarIDs, err := es.List("Avatar"); err != nil || len(avatarIDs) != 2 {
+		t.Errorf("wrong Avatar IDs: %v %v", avatarIDs, err)
+	}
+	if ids, err := es.List("NotExists"); err != nil || len(ids) != 0 {
+		t.Errorf("type not exists should list nothing: %v %v", ids, err)
+	}
+}
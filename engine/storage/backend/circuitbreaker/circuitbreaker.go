@@ -0,0 +1,577 @@
+package entity_storage_circuitbreaker
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Circuit breaker storage protects the storage routine from slow or broken backend storages.
+//
+// Every operation on the backend is limited by the timeout. A timed out operation is abandoned with its backend
+// connection, which is closed when the operation returns, and a new connection is opened for next operations. At most
+// _MAX_ABANDONED_OPERATIONS operations are left running, so goroutines are not piled up by a hung backend. A timed out
+// write might still be applied by the backend after newer writes of the same entities, so when it returns, the newest
+// data written since is put in the retry queue and written again, unless newer data is already queued.
+//
+// After consecutive failures (timeouts, broken connections or unavailable backends) the breaker opens, and all
+// operations fail fast with ErrCircuitOpen during the cooldown. Then one operation is tried: the breaker closes if it
+// succeeds, or opens again.
+//
+// Writes by WriteAsync and WriteBatchAsync failed are kept in a bounded retry queue and retried in the background, callbacks are called
+// after data is written. Reads, exists checks and queries see queued data, and WriteAsync blocks if the queue is full.
+// Partial writes of the backend are not used, entities are always fully saved.
+
+const (
+	_MAX_ABANDONED_OPERATIONS = 4
+	_RETRY_INTERVAL           = time.Second
+)
+
+var (
+	ErrTimeout     = errors.New("storage operation timeout")
+	ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+	errTooManyAbandoned = errors.New("too many storage operations timed out and still running")
+
+	// errors of backends not reachable, the backend is reopened and the failure is counted
+	unavailableErrors = []string{
+		"no reachable servers", // mgo
+		"connection refused",
+		"connection reset",
+		"broken pipe",
+	}
+)
+
+// Options of circuit breaker storage
+type Options struct {
+	Timeout        time.Duration // timeout of each operation, no timeout if 0
+	Failures       int           // consecutive failures to open the breaker
+	Cooldown       time.Duration // operations fail fast for the cooldown after the breaker opens
+	RetryQueueSize int           // max number of entities in the retry queue of writes
+}
+
+// Function for opening the backend storage, called again if the backend connection is broken or abandoned
+type OpenFunc func() (EntityStorage, error)
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+type entityKey struct {
+	typeName string
+	entityID common.EntityID
+}
+
+type queuedWrite struct {
+	data      interface{}
+	version   int // increased when data is replaced by newer writes
+	callbacks []func()
+}
+
+// Abandoned writes of the entity still running
+type abandonedWrite struct {
+	running int
+	latest  interface{} // the newest data written since the writes are abandoned
+	written bool
+}
+
+type circuitBreakerEntityStorage struct {
+	open OpenFunc
+	opts Options
+
+	backendLock sync.Mutex // operations are serialized, backend is not goroutine-safe
+	backend     EntityStorage
+	abandoned   int32 // number of abandoned operations still running
+	state       breakerState
+	failures    int // consecutive failures
+	openUntil   time.Time
+
+	queueLock       sync.Mutex
+	queueCond       *sync.Cond // signaled when queued writes are written
+	queue           map[entityKey]*queuedWrite
+	abandonedWrites map[entityKey]*abandonedWrite // also protected by queueLock
+
+	closing chan struct{}
+	closed  sync.WaitGroup
+}
+
+// Open the backend storage and wrap it with timeouts, circuit breaker and retry queue of writes
+func Open(open OpenFunc, opts Options) (EntityStorage, error) {
+	backend, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	es := &circuitBreakerEntityStorage{
+		open:    open,
+		opts:    opts,
+		backend: backend,
+		queue:   map[entityKey]*queuedWrite{},
+		closing: make(chan struct{}),
+
+		abandonedWrites: map[entityKey]*abandonedWrite{},
+	}
+	es.queueCond = sync.NewCond(&es.queueLock)
+	es.closed.Add(1)
+	go es.retryRoutine()
+	return es, nil
+}
+
+// Call the function with backend if the breaker allows
+func (es *circuitBreakerEntityStorage) call(f func(backend EntityStorage) error) error {
+	return es.callWrite(nil, f)
+}
+
+// Call the function writing the records with backend if the breaker allows
+func (es *circuitBreakerEntityStorage) callWrite(records []EntityRecord, f func(backend EntityStorage) error) error {
+	es.backendLock.Lock()
+	defer es.backendLock.Unlock()
+
+	if !es.allow() {
+		return ErrCircuitOpen
+	}
+	err, failed := es.callBackend(records, f)
+	es.report(failed)
+	return err
+}
+
+// Check if operations are allowed by the breaker, the first operation after cooldown is the trial
+func (es *circuitBreakerEntityStorage) allow() bool {
+	if es.state != stateOpen {
+		return true
+	}
+	if time.Now().Before(es.openUntil) {
+		return false
+	}
+	es.state = stateHalfOpen
+	gwlog.Info("circuit breaker storage: cooldown finished, trying backend ...")
+	return true
+}
+
+func (es *circuitBreakerEntityStorage) report(failed bool) {
+	if !failed {
+		if es.state != stateClosed {
+			gwlog.Info("circuit breaker storage: backend recovered, breaker is closed")
+		}
+		es.state = stateClosed
+		es.failures = 0
+		return
+	}
+
+	es.failures += 1
+	if es.state == stateHalfOpen || (es.state == stateClosed && es.failures >= es.opts.Failures) {
+		es.state = stateOpen
+		es.openUntil = time.Now().Add(es.opts.Cooldown)
+		gwlog.Error("circuit breaker storage: %d consecutive failures, breaker is open for %s", es.failures, es.opts.Cooldown)
+	}
+}
+
+// Call the function with timeout, failed is true if the backend timed out or is broken
+func (es *circuitBreakerEntityStorage) callBackend(records []EntityRecord, f func(backend EntityStorage) error) (err error, failed bool) {
+	if es.backend == nil {
+		if atomic.LoadInt32(&es.abandoned) >= _MAX_ABANDONED_OPERATIONS {
+			return errTooManyAbandoned, true
+		}
+		backend, err := es.open()
+		if err != nil {
+			return err, true
+		}
+		es.backend = backend
+	}
+
+	backend := es.backend
+	if es.opts.Timeout <= 0 {
+		err = f(backend)
+		return err, es.checkBroken(backend, err)
+	}
+
+	es.noteWrites(records)
+	done := make(chan error, 1)
+	go func() {
+		done <- f(backend)
+	}()
+
+	timer := time.NewTimer(es.opts.Timeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+		return err, es.checkBroken(backend, err)
+	case <-timer.C:
+		// abandon the backend, which is closed when the operation returns
+		es.backend = nil
+		atomic.AddInt32(&es.abandoned, 1)
+		es.abandonWrites(records)
+		go func() {
+			<-done
+			backend.Close()
+			atomic.AddInt32(&es.abandoned, -1)
+			es.finishAbandonedWrites(records)
+		}()
+		return ErrTimeout, true
+	}
+}
+
+// Check if the backend is broken or unavailable, the backend is reopened for next operations
+func (es *circuitBreakerEntityStorage) checkBroken(backend EntityStorage, err error) bool {
+	if err == nil || (!backend.IsEOF(err) && !isUnavailable(err)) {
+		return false
+	}
+	backend.Close()
+	es.backend = nil
+	return true
+}
+
+func isUnavailable(err error) bool {
+	if _, ok := errors.Cause(err).(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range unavailableErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Record the data of entities being written, if abandoned writes of the entities are still running
+func (es *circuitBreakerEntityStorage) noteWrites(records []EntityRecord) {
+	es.queueLock.Lock()
+	defer es.queueLock.Unlock()
+	if len(es.abandonedWrites) == 0 {
+		return
+	}
+
+	for _, r := range records {
+		if aw := es.abandonedWrites[entityKey{r.TypeName, r.EntityID}]; aw != nil {
+			aw.latest, aw.written = r.Data, true
+		}
+	}
+}
+
+func (es *circuitBreakerEntityStorage) abandonWrites(records []EntityRecord) {
+	es.queueLock.Lock()
+	defer es.queueLock.Unlock()
+
+	for _, r := range records {
+		key := entityKey{r.TypeName, r.EntityID}
+		aw := es.abandonedWrites[key]
+		if aw == nil {
+			aw = &abandonedWrite{}
+			es.abandonedWrites[key] = aw
+		}
+		aw.running += 1
+	}
+}
+
+// Write the newest data again if abandoned writes are finished after newer writes, which might be overwritten
+func (es *circuitBreakerEntityStorage) finishAbandonedWrites(records []EntityRecord) {
+	var rewrites []EntityRecord
+	es.queueLock.Lock()
+	for _, r := range records {
+		key := entityKey{r.TypeName, r.EntityID}
+		aw := es.abandonedWrites[key]
+		aw.running -= 1
+		if aw.running > 0 {
+			continue
+		}
+
+		delete(es.abandonedWrites, key)
+		if _, queued := es.queue[key]; aw.written && !queued {
+			rewrites = append(rewrites, EntityRecord{TypeName: r.TypeName, EntityID: r.EntityID, Data: aw.latest})
+		}
+	}
+	es.queueLock.Unlock()
+
+	for _, r := range rewrites {
+		gwlog.Warn("circuit breaker storage: timed out write of %s<%s> finished after newer writes, writing newest data again", r.TypeName, r.EntityID)
+		es.enqueue(entityKey{r.TypeName, r.EntityID}, r.Data, nil)
+	}
+}
+
+func (es *circuitBreakerEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	records := []EntityRecord{{TypeName: typeName, EntityID: entityID, Data: data}}
+	return es.callWrite(records, func(backend EntityStorage) error {
+		return backend.Write(typeName, entityID, data)
+	})
+}
+
+func (es *circuitBreakerEntityStorage) WriteAsync(typeName string, entityID common.EntityID, data interface{}, callback func()) {
+	key := entityKey{typeName, entityID}
+	if es.requeue(key, data, callback) {
+		return // older data is not written yet, newer data is written in order
+	}
+
+	if err := es.Write(typeName, entityID, data); err == nil {
+		if callback != nil {
+			callback()
+		}
+		return
+	}
+
+	es.enqueue(key, data, callback)
+}
+
+func (es *circuitBreakerEntityStorage) WriteBatch(records []EntityRecord) error {
+	return es.callWrite(records, func(backend EntityStorage) error {
+		return backend.WriteBatch(records)
+	})
+}
+
+// Write records in one batch, records of queued entities and records of the batch failed are put in the retry queue
+func (es *circuitBreakerEntityStorage) WriteBatchAsync(records []EntityRecord, callback func()) {
+	if len(records) == 0 {
+		if callback != nil {
+			callback()
+		}
+		return
+	}
+
+	callback = CallbackAfter(len(records), callback)
+	batch := make([]EntityRecord, 0, len(records))
+	for _, r := range records {
+		if !es.requeue(entityKey{r.TypeName, r.EntityID}, r.Data, callback) {
+			batch = append(batch, r)
+		}
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := es.WriteBatch(batch); err == nil {
+		if callback != nil {
+			for range batch {
+				callback()
+			}
+		}
+		return
+	}
+
+	for _, r := range batch {
+		es.enqueue(entityKey{r.TypeName, r.EntityID}, r.Data, callback)
+	}
+}
+
+// Replace data of the entity in the retry queue, returns false if the entity is not queued
+func (es *circuitBreakerEntityStorage) requeue(key entityKey, data interface{}, callback func()) bool {
+	es.queueLock.Lock()
+	defer es.queueLock.Unlock()
+
+	qw := es.queue[key]
+	if qw == nil {
+		return false
+	}
+	qw.data = data
+	qw.version += 1
+	if callback != nil {
+		qw.callbacks = append(qw.callbacks, callback)
+	}
+	return true
+}
+
+// Put the failed write in the retry queue, blocks if the queue is full
+func (es *circuitBreakerEntityStorage) enqueue(key entityKey, data interface{}, callback func()) {
+	if es.requeue(key, data, callback) {
+		return
+	}
+
+	es.queueLock.Lock()
+	for len(es.queue) >= es.opts.RetryQueueSize {
+		es.queueCond.Wait()
+	}
+	qw := &queuedWrite{data: data}
+	if callback != nil {
+		qw.callbacks = append(qw.callbacks, callback)
+	}
+	es.queue[key] = qw
+	es.queueLock.Unlock()
+}
+
+func (es *circuitBreakerEntityStorage) getQueued(typeName string, entityID common.EntityID) (interface{}, bool) {
+	es.queueLock.Lock()
+	defer es.queueLock.Unlock()
+	if qw, ok := es.queue[entityKey{typeName, entityID}]; ok {
+		return qw.data, true
+	}
+	return nil, false
+}
+
+func (es *circuitBreakerEntityStorage) retryRoutine() {
+	defer es.closed.Done()
+
+	ticker := time.NewTicker(_RETRY_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			es.retryQueuedWrites()
+		case <-es.closing:
+			for !es.retryQueuedWrites() {
+				gwlog.Error("circuit breaker storage: queued writes not written on close, retrying ...")
+				time.Sleep(_RETRY_INTERVAL)
+			}
+			return
+		}
+	}
+}
+
+// Write queued data in order, returns true if the retry queue is empty
+func (es *circuitBreakerEntityStorage) retryQueuedWrites() bool {
+	es.queueLock.Lock()
+	keys := make([]entityKey, 0, len(es.queue))
+	for key := range es.queue {
+		keys = append(keys, key)
+	}
+	es.queueLock.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].typeName != keys[j].typeName {
+			return keys[i].typeName < keys[j].typeName
+		}
+		return keys[i].entityID < keys[j].entityID
+	})
+
+	for _, key := range keys {
+		es.queueLock.Lock()
+		qw := es.queue[key]
+		data, version := qw.data, qw.version
+		es.queueLock.Unlock()
+
+		if err := es.Write(key.typeName, key.entityID, data); err != nil {
+			return false // retried later
+		}
+
+		es.queueLock.Lock()
+		if qw.version != version {
+			es.queueLock.Unlock()
+			continue // replaced by newer data during writing
+		}
+		delete(es.queue, key)
+		es.queueCond.Broadcast()
+		es.queueLock.Unlock()
+
+		for _, cb := range qw.callbacks {
+			cb()
+		}
+	}
+
+	es.queueLock.Lock()
+	defer es.queueLock.Unlock()
+	return len(es.queue) == 0
+}
+
+func (es *circuitBreakerEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	if data, ok := es.getQueued(typeName, entityID); ok {
+		return data, nil
+	}
+
+	var data interface{}
+	err := es.call(func(backend EntityStorage) (err error) {
+		data, err = backend.Read(typeName, entityID)
+		return
+	})
+	return data, err
+}
+
+func (es *circuitBreakerEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	if _, ok := es.getQueued(typeName, entityID); ok {
+		return true, nil
+	}
+
+	var exists bool
+	err := es.call(func(backend EntityStorage) (err error) {
+		exists, err = backend.Exists(typeName, entityID)
+		return
+	})
+	return exists, err
+}
+
+func (es *circuitBreakerEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	var eids []common.EntityID
+	err := es.call(func(backend EntityStorage) (err error) {
+		eids, err = backend.List(typeName)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listed := common.StringSet{}
+	for _, eid := range eids {
+		listed.Add(string(eid))
+	}
+
+	es.queueLock.Lock()
+	for key := range es.queue {
+		if key.typeName == typeName && !listed.Contains(string(key.entityID)) {
+			eids = append(eids, key.entityID)
+		}
+	}
+	es.queueLock.Unlock()
+	return eids, nil
+}
+
+func (es *circuitBreakerEntityStorage) Query(typeName string, filter map[string]interface{}, limit int) ([]common.EntityID, error) {
+	es.queueLock.Lock()
+	queued := map[common.EntityID]interface{}{}
+	for key, qw := range es.queue {
+		if key.typeName == typeName {
+			queued[key.entityID] = qw.data
+		}
+	}
+	es.queueLock.Unlock()
+
+	backendLimit := limit
+	if limit > 0 {
+		backendLimit += len(queued)
+	}
+	var eids []common.EntityID
+	err := es.call(func(backend EntityStorage) (err error) {
+		if queryBackend, ok := backend.(QueryEntityStorage); ok {
+			eids, err = queryBackend.Query(typeName, filter, backendLimit)
+		} else {
+			eids, err = QueryByScan(backend, typeName, filter, backendLimit)
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	return MergeQueryResult(eids, queued, filter, limit), nil
+}
+
+func (es *circuitBreakerEntityStorage) EnsureIndex(typeName string, attr string) error {
+	return es.call(func(backend EntityStorage) error {
+		if queryBackend, ok := backend.(QueryEntityStorage); ok {
+			return queryBackend.EnsureIndex(typeName, attr)
+		}
+		return nil
+	})
+}
+
+func (es *circuitBreakerEntityStorage) Close() {
+	close(es.closing)
+	es.closed.Wait()
+
+	es.backendLock.Lock()
+	if es.backend != nil {
+		es.backend.Close()
+		es.backend = nil
+	}
+	es.backendLock.Unlock()
+}
+
+func (es *circuitBreakerEntityStorage) IsEOF(err error) bool {
+	return false // backend is reopened by circuit breaker storage itself
+}
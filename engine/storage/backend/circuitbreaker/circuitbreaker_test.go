@@ -0,0 +1,187 @@
+package entity_storage_circuitbreaker
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// memory storage which can be made slow or broken
+type flakyEntityStorage struct {
+	sync.Mutex
+	data   map[common.EntityID]interface{}
+	delay  time.Duration
+	broken bool
+	err    error // returned if broken, io.EOF if nil
+}
+
+func (es *flakyEntityStorage) do(f func()) error {
+	es.Lock()
+	delay, broken, err := es.delay, es.broken, es.err
+	es.Unlock()
+
+	time.Sleep(delay)
+	if broken && err != nil {
+		return err
+	} else if broken {
+		return io.EOF
+	}
+	es.Lock()
+	f()
+	es.Unlock()
+	return nil
+}
+
+func (es *flakyEntityStorage) set(delay time.Duration, broken bool) {
+	es.Lock()
+	es.delay, es.broken = delay, broken
+	es.Unlock()
+}
+
+func (es *flakyEntityStorage) List(typeName string) (eids []common.EntityID, err error) {
+	err = es.do(func() {
+		for eid := range es.data {
+			eids = append(eids, eid)
+		}
+	})
+	return
+}
+
+func (es *flakyEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return es.do(func() {
+		es.data[entityID] = data
+	})
+}
+
+func (es *flakyEntityStorage) WriteBatch(records []EntityRecord) error {
+	return es.do(func() {
+		for _, r := range records {
+			es.data[r.EntityID] = r.Data
+		}
+	})
+}
+
+func (es *flakyEntityStorage) Read(typeName string, entityID common.EntityID) (data interface{}, err error) {
+	err = es.do(func() {
+		data = es.data[entityID]
+	})
+	return
+}
+
+func (es *flakyEntityStorage) Exists(typeName string, entityID common.EntityID) (exists bool, err error) {
+	err = es.do(func() {
+		_, exists = es.data[entityID]
+	})
+	return
+}
+
+func (es *flakyEntityStorage) Close() {}
+
+func (es *flakyEntityStorage) IsEOF(err error) bool {
+	return err == io.EOF
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	backend := &flakyEntityStorage{data: map[common.EntityID]interface{}{}}
+	es, err := Open(func() (EntityStorage, error) { return backend, nil }, Options{
+		Timeout:        time.Millisecond * 50,
+		Failures:       2,
+		Cooldown:       time.Millisecond * 200,
+		RetryQueueSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	eid := common.GenEntityID()
+	backend.set(time.Millisecond*200, false)
+	if _, err := es.Read("Avatar", eid); err != ErrTimeout {
+		t.Fatalf("read should time out: %v", err)
+	}
+	backend.set(0, true)
+	if _, err := es.Read("Avatar", eid); err != io.EOF {
+		t.Fatalf("read should fail: %v", err)
+	}
+	if _, err := es.Read("Avatar", eid); err != ErrCircuitOpen {
+		t.Fatalf("breaker should be open: %v", err)
+	}
+
+	// writes are queued when breaker is open, and written after backend recovered
+	written := make(chan struct{})
+	es.(AsyncWriteEntityStorage).WriteAsync("Avatar", eid, 1, func() { close(written) })
+	if data, err := es.Read("Avatar", eid); err != nil || data != 1 {
+		t.Fatalf("should read queued data: %v %v", data, err)
+	}
+
+	backend.set(0, false)
+	select {
+	case <-written:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("queued write is not written after backend recovered")
+	}
+	if data, err := backend.Read("Avatar", eid); err != nil || data != 1 {
+		t.Fatalf("wrong data written: %v %v", data, err)
+	}
+}
+
+func TestCircuitBreakerUnavailable(t *testing.T) {
+	backend := &flakyEntityStorage{data: map[common.EntityID]interface{}{}, broken: true, err: errors.New("no reachable servers")}
+	es, err := Open(func() (EntityStorage, error) { return backend, nil }, Options{
+		Timeout:        time.Millisecond * 50,
+		Failures:       2,
+		Cooldown:       time.Second,
+		RetryQueueSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	eid := common.GenEntityID()
+	for i := 0; i < 2; i++ {
+		if _, err := es.Read("Avatar", eid); err != backend.err {
+			t.Fatalf("read should fail: %v", err)
+		}
+	}
+	if _, err := es.Read("Avatar", eid); err != ErrCircuitOpen {
+		t.Fatalf("breaker should be open after backend is unavailable: %v", err)
+	}
+}
+
+func TestCircuitBreakerLateWrite(t *testing.T) {
+	backend := &flakyEntityStorage{data: map[common.EntityID]interface{}{}}
+	es, err := Open(func() (EntityStorage, error) { return backend, nil }, Options{
+		Timeout:        time.Millisecond * 50,
+		Failures:       2,
+		Cooldown:       time.Millisecond * 200,
+		RetryQueueSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	// the timed out write is applied after the newer data is written by retrying
+	eid := common.GenEntityID()
+	backend.set(_RETRY_INTERVAL*3/2, false)
+	es.(AsyncWriteEntityStorage).WriteAsync("Avatar", eid, 1, nil)
+	backend.set(0, false)
+	written := make(chan struct{})
+	es.(AsyncWriteEntityStorage).WriteAsync("Avatar", eid, 2, func() { close(written) })
+
+	select {
+	case <-written:
+	case <-time.After(time.Second * 3):
+		t.Fatalf("queued write is not written")
+	}
+	time.Sleep(_RETRY_INTERVAL * 2)
+	if data, err := backend.Read("Avatar", eid); err != nil || data != 2 {
+		t.Fatalf("timed out write should not overwrite newer data: %v %v", data, err)
+	}
+}
@@ -43,6 +43,10 @@ func (es *FileSystemEntityStorage) Write(typeName string, entityID common.Entity
 	return ioutil.WriteFile(stringSaveFile, dataBytes, 0644)
 }
 
+func (es *FileSystemEntityStorage) WriteBatch(records []EntityRecord) error {
+	return WriteEach(es, records)
+}
+
 func (es *FileSystemEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
 	stringSaveFile := es.getFilePath(typeName, entityID)
 	dataBytes, err := ioutil.ReadFile(stringSaveFile)
@@ -56,6 +56,53 @@ func (es *MongoDBEntityStorge) Write(typeName string, entityID common.EntityID,
 	return err
 }
 
+// Write records of each type by one bulk upsert
+func (es *MongoDBEntityStorge) WriteBatch(records []EntityRecord) error {
+	var typeNames []string
+	bulks := map[string]*mgo.Bulk{}
+	for _, r := range records {
+		bulk := bulks[r.TypeName]
+		if bulk == nil {
+			bulk = es.getCollection(r.TypeName).Bulk()
+			bulks[r.TypeName] = bulk
+			typeNames = append(typeNames, r.TypeName)
+		}
+		bulk.Upsert(bson.M{"_id": r.EntityID}, bson.M{"data": r.Data})
+	}
+
+	for _, typeName := range typeNames {
+		if _, err := bulks[typeName].Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write changed attributes to fields of data, other attributes are not overwritten
+func (es *MongoDBEntityStorge) WritePartial(typeName string, entityID common.EntityID, patch EntityDataPatch) error {
+	update := bson.M{}
+	if len(patch.Set) > 0 {
+		set := bson.M{}
+		for key, val := range patch.Set {
+			set["data."+key] = val
+		}
+		update["$set"] = set
+	}
+	if len(patch.Unset) > 0 {
+		unset := bson.M{}
+		for _, key := range patch.Unset {
+			unset["data."+key] = ""
+		}
+		update["$unset"] = unset
+	}
+	if len(update) == 0 {
+		return nil
+	}
+
+	col := es.getCollection(typeName)
+	return col.UpdateId(entityID, update)
+}
+
 func (es *MongoDBEntityStorge) Read(typeName string, entityID common.EntityID) (interface{}, error) {
 	col := es.getCollection(typeName)
 	q := col.FindId(entityID)
@@ -67,6 +114,39 @@ func (es *MongoDBEntityStorge) Read(typeName string, entityID common.EntityID) (
 	return es.convertM2Map(doc["data"].(bson.M)), nil
 }
 
+// Read the revision field of data only
+func (es *MongoDBEntityStorge) ReadRevision(typeName string, entityID common.EntityID) (int64, error) {
+	var doc struct {
+		Data struct {
+			Revision int64 `bson:"__revision"`
+		} `bson:"data"`
+	}
+	err := es.getCollection(typeName).FindId(entityID).Select(bson.M{"data." + REVISION_KEY: 1}).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	return doc.Data.Revision, err
+}
+
+// Check the stored revision and write data in one update
+func (es *MongoDBEntityStorge) WriteIfRevision(typeName string, entityID common.EntityID, data interface{}, prevRevision int64) (bool, error) {
+	col := es.getCollection(typeName)
+	if prevRevision == 0 {
+		// upsert data without revision, inserting fails by duplicate key if the entity exists with revision
+		_, err := col.Upsert(bson.M{"_id": entityID, "data." + REVISION_KEY: bson.M{"$in": []interface{}{nil, 0}}}, bson.M{"data": data})
+		if mgo.IsDup(err) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	err := col.Update(bson.M{"_id": entityID, "data." + REVISION_KEY: prevRevision}, bson.M{"data": data})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (es *MongoDBEntityStorge) convertM2Map(m bson.M) map[string]interface{} {
 	ma := map[string]interface{}(m)
 	for k, v := range ma {
@@ -96,6 +176,33 @@ func (es *MongoDBEntityStorge) List(typeName string) ([]common.EntityID, error)
 	return entityIDs, nil
 }
 
+// Find entities by fields of data, the filter is passed to MongoDB
+func (es *MongoDBEntityStorge) Query(typeName string, filter map[string]interface{}, limit int) ([]common.EntityID, error) {
+	selector := bson.M{}
+	for path, val := range filter {
+		selector["data."+path] = val
+	}
+
+	query := es.getCollection(typeName).Find(selector).Select(bson.M{"_id": 1})
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var docs []bson.M
+	if err := query.All(&docs); err != nil {
+		return nil, err
+	}
+
+	entityIDs := make([]common.EntityID, len(docs))
+	for i, doc := range docs {
+		entityIDs[i] = common.EntityID(doc["_id"].(string))
+	}
+	return entityIDs, nil
+}
+
+func (es *MongoDBEntityStorge) EnsureIndex(typeName string, attr string) error {
+	return es.getCollection(typeName).EnsureIndexKey("data." + attr)
+}
+
 func (es *MongoDBEntityStorge) Exists(typeName string, entityID common.EntityID) (bool, error) {
 	col := es.getCollection(typeName)
 	query := col.FindId(entityID)
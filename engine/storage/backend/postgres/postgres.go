@@ -0,0 +1,367 @@
+package entity_storage_postgres
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	_ "github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	MAX_OPEN_CONNS = 10 // max connections in the pool
+	MAX_IDLE_CONNS = 2
+)
+
+// Entity storage backed by PostgreSQL
+//
+// Entities of each type are stored in table of the type name with JSONB data, the table is created when the type
+// is used for the first time.
+type postgresEntityStorage struct {
+	db *sql.DB
+
+	stmtsLock sync.Mutex
+	stmts     map[string]*typeStmts // prepared statements of each type
+}
+
+type typeStmts struct {
+	write             *sql.Stmt
+	writePartial      *sql.Stmt
+	writeIfRevision   *sql.Stmt // write if the stored revision is $3
+	writeIfNoRevision *sql.Stmt // write if the entity does not exist or has no revision
+	read              *sql.Stmt
+	readRevision      *sql.Stmt
+	exists            *sql.Stmt
+	list              *sql.Stmt
+}
+
+func OpenPostgres(url string) (EntityStorage, error) {
+	gwlog.Debug("Connecting PostgreSQL ...")
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, errors.Wrap(err, "postgres open failed")
+	}
+
+	db.SetMaxOpenConns(MAX_OPEN_CONNS)
+	db.SetMaxIdleConns(MAX_IDLE_CONNS)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "postgres connect failed")
+	}
+
+	return &postgresEntityStorage{
+		db:    db,
+		stmts: map[string]*typeStmts{},
+	}, nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// get prepared statements of the type, the table is created if not exists
+func (es *postgresEntityStorage) getStmts(typeName string) (*typeStmts, error) {
+	es.stmtsLock.Lock()
+	defer es.stmtsLock.Unlock()
+
+	if stmts, ok := es.stmts[typeName]; ok {
+		return stmts, nil
+	}
+
+	table := quoteIdentifier(typeName)
+	if _, err := es.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, data JSONB NOT NULL)", table)); err != nil {
+		return nil, errors.Wrapf(err, "create table %s failed", table)
+	}
+
+	stmts := &typeStmts{}
+	var err error
+	if stmts.write, err = es.db.Prepare(fmt.Sprintf("INSERT INTO %s (id, data) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data", table)); err != nil {
+		return nil, err
+	}
+	// merge set attributes and remove unset attributes (as JSON array of keys)
+	if stmts.writePartial, err = es.db.Prepare(fmt.Sprintf("UPDATE %s SET data = (data || $2::jsonb) - ARRAY(SELECT jsonb_array_elements_text($3::jsonb)) WHERE id = $1", table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.writeIfRevision, err = es.db.Prepare(fmt.Sprintf("UPDATE %s SET data = $2 WHERE id = $1 AND COALESCE((data->>'%s')::bigint, 0) = $3", table, REVISION_KEY)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.writeIfNoRevision, err = es.db.Prepare(fmt.Sprintf("INSERT INTO %s (id, data) VALUES ($1, $2) ON CONFLICT (id) DO UPDATE SET data = EXCLUDED.data WHERE COALESCE((%s.data->>'%s')::bigint, 0) = 0", table, table, REVISION_KEY)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.read, err = es.db.Prepare(fmt.Sprintf("SELECT data FROM %s WHERE id = $1", table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.readRevision, err = es.db.Prepare(fmt.Sprintf("SELECT COALESCE((data->>'%s')::bigint, 0) FROM %s WHERE id = $1", REVISION_KEY, table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.exists, err = es.db.Prepare(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE id = $1)", table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.list, err = es.db.Prepare(fmt.Sprintf("SELECT id FROM %s", table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+
+	es.stmts[typeName] = stmts
+	return stmts, nil
+}
+
+func (stmts *typeStmts) close() {
+	for _, stmt := range []*sql.Stmt{stmts.write, stmts.writePartial, stmts.writeIfRevision, stmts.writeIfNoRevision, stmts.read, stmts.readRevision, stmts.exists, stmts.list} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func (es *postgresEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmts.write.Exec(string(entityID), string(b))
+	return err
+}
+
+// Write records in one transaction
+func (es *postgresEntityStorage) WriteBatch(records []EntityRecord) error {
+	tx, err := es.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		stmts, err := es.getStmts(r.TypeName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		b, err := json.Marshal(r.Data)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Stmt(stmts.write).Exec(string(r.EntityID), string(b)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (es *postgresEntityStorage) WritePartial(typeName string, entityID common.EntityID, patch EntityDataPatch) error {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return err
+	}
+
+	set := patch.Set
+	if set == nil {
+		set = map[string]interface{}{}
+	}
+	setJson, err := json.Marshal(set)
+	if err != nil {
+		return err
+	}
+
+	unset := patch.Unset
+	if unset == nil {
+		unset = []string{}
+	}
+	unsetJson, err := json.Marshal(unset)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmts.writePartial.Exec(string(entityID), string(setJson), string(unsetJson))
+	return err
+}
+
+// Check the stored revision and write data in one statement
+func (es *postgresEntityStorage) WriteIfRevision(typeName string, entityID common.EntityID, data interface{}, prevRevision int64) (bool, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return false, err
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return false, err
+	}
+
+	var res sql.Result
+	if prevRevision == 0 {
+		res, err = stmts.writeIfNoRevision.Exec(string(entityID), string(b))
+	} else {
+		res, err = stmts.writeIfRevision.Exec(string(entityID), string(b), prevRevision)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (es *postgresEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	err = stmts.read.QueryRow(string(entityID)).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, nil // entity not exists
+	} else if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Read the revision field of data only
+func (es *postgresEntityStorage) ReadRevision(typeName string, entityID common.EntityID) (int64, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return 0, err
+	}
+
+	var revision int64
+	err = stmts.readRevision.QueryRow(string(entityID)).Scan(&revision)
+	if err == sql.ErrNoRows {
+		return 0, nil // entity not exists
+	}
+	return revision, err
+}
+
+func (es *postgresEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = stmts.exists.QueryRow(string(entityID)).Scan(&exists)
+	return exists, err
+}
+
+func (es *postgresEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmts.list.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entityIDs []common.EntityID
+	for rows.Next() {
+		var eid string
+		if err := rows.Scan(&eid); err != nil {
+			return nil, err
+		}
+		entityIDs = append(entityIDs, common.EntityID(eid))
+	}
+	return entityIDs, rows.Err()
+}
+
+// Find entities by containment of JSONB data, attribute paths in the filter are converted to nested objects
+func (es *postgresEntityStorage) Query(typeName string, filter map[string]interface{}, limit int) ([]common.EntityID, error) {
+	if _, err := es.getStmts(typeName); err != nil { // create table if not exists
+		return nil, err
+	}
+
+	contained := map[string]interface{}{}
+	for path, val := range filter {
+		keys := strings.Split(path, ".")
+		m := contained
+		for _, key := range keys[:len(keys)-1] {
+			sub, ok := m[key].(map[string]interface{})
+			if !ok {
+				sub = map[string]interface{}{}
+				m[key] = sub
+			}
+			m = sub
+		}
+		m[keys[len(keys)-1]] = val
+	}
+	b, err := json.Marshal(contained)
+	if err != nil {
+		return nil, err
+	}
+
+	sqlQuery := fmt.Sprintf("SELECT id FROM %s WHERE data @> $1::jsonb", quoteIdentifier(typeName))
+	if limit > 0 {
+		sqlQuery += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := es.db.Query(sqlQuery, string(b))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entityIDs []common.EntityID
+	for rows.Next() {
+		var eid string
+		if err := rows.Scan(&eid); err != nil {
+			return nil, err
+		}
+		entityIDs = append(entityIDs, common.EntityID(eid))
+	}
+	return entityIDs, rows.Err()
+}
+
+// Containment queries of all attributes use the GIN index of data, which is created for the first attribute
+func (es *postgresEntityStorage) EnsureIndex(typeName string, attr string) error {
+	if _, err := es.getStmts(typeName); err != nil {
+		return err
+	}
+
+	_, err := es.db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s USING GIN (data jsonb_path_ops)",
+		quoteIdentifier(typeName+"_data_idx"), quoteIdentifier(typeName)))
+	return err
+}
+
+func (es *postgresEntityStorage) Close() {
+	es.stmtsLock.Lock()
+	for _, stmts := range es.stmts {
+		stmts.close()
+	}
+	es.stmts = map[string]*typeStmts{}
+	es.stmtsLock.Unlock()
+
+	es.db.Close()
+}
+
+func (es *postgresEntityStorage) IsEOF(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || err == driver.ErrBadConn
+}
@@ -0,0 +1,63 @@
+package entity_storage_postgres
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+func TestPostgresEntityStorage(t *testing.T) {
+	es, err := OpenPostgres("postgres://postgres@localhost/goworld?sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	entityID := common.GenEntityID()
+	gwlog.Info("TESTING ENTITYID: %s", entityID)
+	data, err := es.Read("Avatar", entityID)
+	if data != nil || err != nil {
+		t.Errorf("should be nil: %v, %v", data, err)
+	}
+
+	testData := map[string]interface{}{
+		"a": 1,
+		"b": "2",
+		"c": true,
+		"d": 1.11,
+	}
+	if err := es.Write("Avatar", entityID, testData); err != nil {
+		t.Error(err)
+	}
+
+	verifyData, err := es.Read("Avatar", entityID)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if verifyData.(map[string]interface{})["a"].(float64) != 1 {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if verifyData.(map[string]interface{})["b"].(string) != "2" {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if verifyData.(map[string]interface{})["c"].(bool) != true {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if verifyData.(map[string]interface{})["d"].(float64) != 1.11 {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+
+	if exists, err := es.Exists("Avatar", entityID); !exists || err != nil {
+		t.Errorf("entity should exist: %v", err)
+	}
+
+	avatarIDs, err := es.List("Avatar")
+	if err != nil {
+		t.Error(err)
+	}
+	if len(avatarIDs) == 0 {
+		t.Errorf("Avatar IDs is empty!")
+	}
+}
@@ -45,31 +45,44 @@ func packData(data interface{}) (b []byte, err error) {
 }
 
 func (es *redisEntityStorage) List(typeName string) ([]common.EntityID, error) {
-	keyMatch := typeName + "$*"
-	r, err := redis.Values(es.c.Do("SCAN", "0", "MATCH", keyMatch, "COUNT", 10000))
+	keys, err := scanKeys(es.c, typeName+"$*")
 	if err != nil {
 		return nil, err
 	}
-	var eids []common.EntityID
-	prefixLen := len(typeName) + 1
+	return keysToEntityIDs(typeName, keys), nil
+}
+
+// scan all keys matching the pattern in the redis server
+func scanKeys(c redis.Conn, keyMatch string) ([]string, error) {
+	var allKeys []string
+	cursor := interface{}("0")
 	for {
-		nextCursor := r[0]
-		keys, err := redis.Strings(r[1], nil)
+		r, err := redis.Values(c.Do("SCAN", cursor, "MATCH", keyMatch, "COUNT", 1000))
 		if err != nil {
 			return nil, err
 		}
 
-		for _, key := range keys {
-			println("key", key)
-			eids = append(eids, common.EntityID(key[prefixLen:]))
+		keys, err := redis.Strings(r[1], nil)
+		if err != nil {
+			return nil, err
 		}
+		allKeys = append(allKeys, keys...)
 
-		if isZeroCursor(nextCursor) {
+		cursor = r[0]
+		if isZeroCursor(cursor) {
 			break
 		}
-		r, err = redis.Values(es.c.Do("SCAN", nextCursor, "MATCH", keyMatch, "COUNT", 10000))
 	}
-	return eids, nil
+	return allKeys, nil
+}
+
+func keysToEntityIDs(typeName string, keys []string) []common.EntityID {
+	prefixLen := len(typeName) + 1
+	eids := make([]common.EntityID, 0, len(keys))
+	for _, key := range keys {
+		eids = append(eids, common.EntityID(key[prefixLen:]))
+	}
+	return eids
 }
 
 func isZeroCursor(c interface{}) bool {
@@ -86,11 +99,41 @@ func (es *redisEntityStorage) Write(typeName string, entityID common.EntityID, d
 	return err
 }
 
+// Write records by pipelined SET commands
+func (es *redisEntityStorage) WriteBatch(records []EntityRecord) error {
+	for _, r := range records {
+		b, err := packData(r.Data)
+		if err != nil {
+			return err
+		}
+		if err := es.c.Send("SET", entityKey(r.TypeName, r.EntityID), b); err != nil {
+			return err
+		}
+	}
+
+	if err := es.c.Flush(); err != nil {
+		return err
+	}
+	var lastErr error
+	for range records {
+		if _, err := es.c.Receive(); err != nil {
+			lastErr = err // receive all replies to keep the connection usable
+		}
+	}
+	return lastErr
+}
+
 func (es *redisEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
-	b, err := redis.Bytes(es.c.Do("GET", entityKey(typeName, entityID)))
-	if err != nil {
+	return unpackData(redis.Bytes(es.c.Do("GET", entityKey(typeName, entityID))))
+}
+
+func unpackData(b []byte, err error) (interface{}, error) {
+	if err == redis.ErrNil {
+		return nil, nil // entity not exists
+	} else if err != nil {
 		return nil, err
 	}
+
 	var data map[string]interface{}
 	if err = dataPacker.UnpackMsg(b, &data); err != nil {
 		return nil, err
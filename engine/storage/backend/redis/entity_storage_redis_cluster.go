@@ -0,0 +1,348 @@
+package entity_storage_redis
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	redisClusterSlots      = 16384
+	redisClusterMaxRetries = 5
+)
+
+// Entity storage backed by Redis Cluster
+//
+// Each key is sent to the master node serving the slot of key. The slot map is loaded by CLUSTER SLOTS and
+// refreshed when MOVED is replied, ASK redirection is followed without refreshing the slot map.
+type redisClusterEntityStorage struct {
+	startNodes []string
+	conns      map[string]redis.Conn // address -> connection
+	slots      [redisClusterSlots]string
+}
+
+func OpenRedisCluster(startNodes []string) (EntityStorage, error) {
+	if len(startNodes) == 0 {
+		return nil, errors.Errorf("redis cluster start nodes not specified")
+	}
+
+	es := &redisClusterEntityStorage{
+		startNodes: startNodes,
+		conns:      map[string]redis.Conn{},
+	}
+
+	if err := es.refreshSlots(); err != nil {
+		es.Close()
+		return nil, err
+	}
+	return es, nil
+}
+
+func (es *redisClusterEntityStorage) getConn(addr string) (redis.Conn, error) {
+	if c, ok := es.conns[addr]; ok {
+		return c, nil
+	}
+
+	c, err := redis.Dial("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "redis dail %s failed", addr)
+	}
+	es.conns[addr] = c
+	return c, nil
+}
+
+func (es *redisClusterEntityStorage) closeConn(addr string) {
+	if c, ok := es.conns[addr]; ok {
+		c.Close()
+		delete(es.conns, addr)
+	}
+}
+
+// load the slot map from any of known nodes
+func (es *redisClusterEntityStorage) refreshSlots() error {
+	var lastErr error
+	for _, addr := range es.knownNodes() {
+		c, err := es.getConn(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		r, err := redis.Values(c.Do("CLUSTER", "SLOTS"))
+		if err != nil {
+			es.closeConn(addr)
+			lastErr = errors.Wrapf(err, "redis cluster slots from %s failed", addr)
+			continue
+		}
+
+		if err := es.parseSlots(r); err != nil {
+			lastErr = err
+			continue
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// each slot range is replied as [start, end, [master ip, master port, ...], replicas ...]
+func (es *redisClusterEntityStorage) parseSlots(r []interface{}) error {
+	var slots [redisClusterSlots]string
+	for _, item := range r {
+		slotRange, err := redis.Values(item, nil)
+		if err != nil || len(slotRange) < 3 {
+			return errors.Errorf("invalid cluster slots reply: %v", item)
+		}
+		start, err1 := redis.Int(slotRange[0], nil)
+		end, err2 := redis.Int(slotRange[1], nil)
+		master, err3 := redis.Values(slotRange[2], nil)
+		if err1 != nil || err2 != nil || err3 != nil || len(master) < 2 || start < 0 || end >= redisClusterSlots {
+			return errors.Errorf("invalid cluster slots reply: %v", item)
+		}
+		ip, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+
+		addr := fmt.Sprintf("%s:%d", ip, port)
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+	}
+	es.slots = slots
+	return nil
+}
+
+func (es *redisClusterEntityStorage) knownNodes() []string {
+	nodes := append([]string{}, es.startNodes...)
+	for addr := range es.conns {
+		nodes = append(nodes, addr)
+	}
+	return nodes
+}
+
+// get addresses of all master nodes
+func (es *redisClusterEntityStorage) masterNodes() []string {
+	var nodes []string
+	seen := map[string]bool{}
+	for _, addr := range es.slots {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			nodes = append(nodes, addr)
+		}
+	}
+	return nodes
+}
+
+// execute the command on the node serving the key, following MOVED and ASK redirections
+func (es *redisClusterEntityStorage) do(key string, cmd string, args ...interface{}) (interface{}, error) {
+	addr := es.slots[keySlot(key)]
+	asking := false
+	var lastErr error
+	for i := 0; i < redisClusterMaxRetries; i++ {
+		if addr == "" {
+			if err := es.refreshSlots(); err != nil {
+				return nil, err
+			}
+			addr = es.slots[keySlot(key)]
+			if addr == "" {
+				return nil, errors.Errorf("slot of key %s is not served", key)
+			}
+		}
+
+		c, err := es.getConn(addr)
+		if err != nil {
+			lastErr = err
+			addr = ""
+			continue
+		}
+
+		if asking {
+			if _, err := c.Do("ASKING"); err != nil {
+				return nil, err
+			}
+			asking = false
+		}
+
+		reply, err := c.Do(cmd, args...)
+		redisErr, isRedisErr := err.(redis.Error)
+		if err != nil && !isRedisErr {
+			es.closeConn(addr) // connection broken
+			lastErr = err
+			addr = ""
+			continue
+		}
+
+		if !isRedisErr {
+			return reply, nil
+		}
+
+		// redirections are replied as "MOVED <slot> <addr>" or "ASK <slot> <addr>"
+		fields := strings.Fields(string(redisErr))
+		if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+			return reply, err
+		}
+
+		if fields[0] == "MOVED" {
+			slot, _ := strconv.Atoi(fields[1])
+			gwlog.Info("Redis cluster: slot %d moved to %s", slot, fields[2])
+			if err := es.refreshSlots(); err != nil && slot >= 0 && slot < redisClusterSlots {
+				es.slots[slot] = fields[2]
+			}
+		} else {
+			asking = true
+		}
+		addr = fields[2]
+		lastErr = err
+	}
+	return nil, errors.Wrapf(lastErr, "redis cluster %s %s failed", cmd, key)
+}
+
+func (es *redisClusterEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	var keys []string
+	for _, addr := range es.masterNodes() {
+		c, err := es.getConn(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeKeys, err := scanKeys(c, typeName+"$*")
+		if err != nil {
+			es.closeConn(addr)
+			return nil, errors.Wrapf(err, "redis scan %s failed", addr)
+		}
+		keys = append(keys, nodeKeys...)
+	}
+	return keysToEntityIDs(typeName, keys), nil
+}
+
+func (es *redisClusterEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	b, err := packData(data)
+	if err != nil {
+		return err
+	}
+
+	key := entityKey(typeName, entityID)
+	_, err = es.do(key, "SET", key, b)
+	return err
+}
+
+// Write records by pipelined SET commands to each master node
+//
+// Records failed in pipelines (e.g. slots are moved or connections are broken) are written one by one, so that
+// redirections are followed.
+func (es *redisClusterEntityStorage) WriteBatch(records []EntityRecord) error {
+	var addrs []string
+	nodeKeys := map[string][]string{}
+	values := map[string][]byte{}
+	for _, r := range records {
+		b, err := packData(r.Data)
+		if err != nil {
+			return err
+		}
+		key := entityKey(r.TypeName, r.EntityID)
+		addr := es.slots[keySlot(key)]
+		if _, ok := nodeKeys[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+		nodeKeys[addr] = append(nodeKeys[addr], key)
+		values[key] = b
+	}
+
+	var failedKeys []string
+	for _, addr := range addrs {
+		keys := nodeKeys[addr]
+		if addr == "" {
+			failedKeys = append(failedKeys, keys...)
+			continue
+		}
+		failedKeys = append(failedKeys, es.pipelineSet(addr, keys, values)...)
+	}
+
+	for _, key := range failedKeys {
+		if _, err := es.do(key, "SET", key, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set keys on the node by pipeline, returns failed keys
+func (es *redisClusterEntityStorage) pipelineSet(addr string, keys []string, values map[string][]byte) (failedKeys []string) {
+	c, err := es.getConn(addr)
+	if err != nil {
+		return keys
+	}
+
+	for _, key := range keys {
+		if err := c.Send("SET", key, values[key]); err != nil {
+			es.closeConn(addr)
+			return keys
+		}
+	}
+	if err := c.Flush(); err != nil {
+		es.closeConn(addr)
+		return keys
+	}
+
+	for i, key := range keys {
+		_, err := c.Receive()
+		if _, isRedisErr := err.(redis.Error); err != nil && !isRedisErr {
+			es.closeConn(addr) // connection broken
+			return append(failedKeys, keys[i:]...)
+		}
+		if err != nil {
+			failedKeys = append(failedKeys, key)
+		}
+	}
+	return
+}
+
+func (es *redisClusterEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	key := entityKey(typeName, entityID)
+	return unpackData(redis.Bytes(es.do(key, "GET", key)))
+}
+
+func (es *redisClusterEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	key := entityKey(typeName, entityID)
+	return redis.Bool(es.do(key, "EXISTS", key))
+}
+
+func (es *redisClusterEntityStorage) Close() {
+	for addr := range es.conns {
+		es.closeConn(addr)
+	}
+}
+
+func (es *redisClusterEntityStorage) IsEOF(err error) bool {
+	return false // connections are redialed when broken
+}
+
+// get the slot of key, only the hash tag {...} is hashed if the key contains one
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % redisClusterSlots)
+}
+
+// CRC16-CCITT (XMODEM) used by cluster key slot
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -65,3 +65,15 @@ func TestRedisEntityStorage(t *testing.T) {
 	}
 
 }
+
+func TestRedisClusterKeySlot(t *testing.T) {
+	if slot := keySlot("123456789"); slot != 0x31C3 {
+		t.Errorf("wrong slot: %d", slot)
+	}
+	if keySlot("{user1000}.following") != keySlot("{user1000}.followers") {
+		t.Errorf("hash tag is not used")
+	}
+	if keySlot("foo{}{bar}") != int(crc16("foo{}{bar}")%redisClusterSlots) {
+		t.Errorf("empty hash tag should not be used")
+	}
+}
@@ -0,0 +1,243 @@
+package entity_storage_s3
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Entity storage backed by S3 or S3-compatible object storages (e.g. MinIO), for rarely loaded entities
+//
+// Data of each entity is stored as a JSON object <prefix>/<type>/<entity ID in base64>.json in the bucket. Recently
+// read and written data is kept in a LRU cache of cacheSize entities, so entities loaded again soon after saved are
+// not read from the bucket. The storage is opened by URL like s3://bucket/prefix?region=us-east-1, and S3-compatible
+// storages are used by setting endpoint in the query, like ?region=us-east-1&endpoint=http://127.0.0.1:9000.
+// Credentials are read from environment variables or shared credentials files.
+type s3EntityStorage struct {
+	client *s3.S3
+	bucket string
+	prefix string
+
+	cacheLock sync.Mutex
+	cache     *lruCache
+}
+
+func OpenS3(s3url string, cacheSize int) (EntityStorage, error) {
+	u, err := url.Parse(s3url)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid s3 url %s", s3url)
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return nil, errors.Errorf("invalid s3 url %s: should be s3://bucket/prefix", s3url)
+	}
+
+	query := u.Query()
+	cfg := &aws.Config{}
+	if region := query.Get("region"); region != "" {
+		cfg.Region = aws.String(region)
+	}
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		cfg.Endpoint = aws.String(endpoint)
+		cfg.S3ForcePathStyle = aws.Bool(true) // S3-compatible storages usually do not support virtual hosted buckets
+	}
+
+	gwlog.Debug("Opening S3 bucket %s ...", u.Host)
+	sess, err := session.NewSession(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3EntityStorage{
+		client: s3.New(sess),
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+		cache:  newLRUCache(cacheSize),
+	}, nil
+}
+
+func (es *s3EntityStorage) typePrefix(typeName string) string {
+	return path.Join(es.prefix, typeName) + "/"
+}
+
+func (es *s3EntityStorage) objectKey(typeName string, entityID common.EntityID) string {
+	return es.typePrefix(typeName) + base64.URLEncoding.EncodeToString([]byte(entityID)) + ".json"
+}
+
+func isNotFound(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound")
+}
+
+func (es *s3EntityStorage) getCached(key string) ([]byte, bool) {
+	es.cacheLock.Lock()
+	defer es.cacheLock.Unlock()
+	return es.cache.Get(key)
+}
+
+func (es *s3EntityStorage) setCached(key string, b []byte) {
+	es.cacheLock.Lock()
+	es.cache.Set(key, b)
+	es.cacheLock.Unlock()
+}
+
+func (es *s3EntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	key := es.objectKey(typeName, entityID)
+	_, err = es.client.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(es.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return err
+	}
+	es.setCached(key, b)
+	return nil
+}
+
+// Objects can only be put one by one
+func (es *s3EntityStorage) WriteBatch(records []EntityRecord) error {
+	return WriteEach(es, records)
+}
+
+func (es *s3EntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	key := es.objectKey(typeName, entityID)
+	b, ok := es.getCached(key)
+	if !ok {
+		out, err := es.client.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(es.bucket),
+			Key:    aws.String(key),
+		})
+		if isNotFound(err) {
+			return nil, nil // entity not exists
+		} else if err != nil {
+			return nil, err
+		}
+
+		b, err = ioutil.ReadAll(out.Body)
+		out.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		es.setCached(key, b)
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *s3EntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	key := es.objectKey(typeName, entityID)
+	if _, ok := es.getCached(key); ok {
+		return true, nil
+	}
+
+	_, err := es.client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(es.bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (es *s3EntityStorage) List(typeName string) ([]common.EntityID, error) {
+	prefix := es.typePrefix(typeName)
+	var entityIDs []common.EntityID
+	var decodeErr error
+	err := es.client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(es.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.StringValue(obj.Key), prefix), ".json")
+			idbytes, err := base64.URLEncoding.DecodeString(name)
+			if err != nil {
+				decodeErr = errors.Wrapf(err, "invalid object key %s", aws.StringValue(obj.Key))
+				return false
+			}
+			entityIDs = append(entityIDs, common.EntityID(idbytes))
+		}
+		return true
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	return entityIDs, err
+}
+
+func (es *s3EntityStorage) Close() {
+	// need to do nothing
+}
+
+func (es *s3EntityStorage) IsEOF(err error) bool {
+	return false
+}
+
+// LRU cache of object data
+type lruCache struct {
+	size  int
+	items map[string]*list.Element
+	order *list.List // most recently used at the front
+}
+
+type lruItem struct {
+	key string
+	val []byte
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{size: size, items: map[string]*list.Element{}, order: list.New()}
+}
+
+func (c *lruCache) Get(key string) ([]byte, bool) {
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruItem).val, true
+}
+
+func (c *lruCache) Set(key string, val []byte) {
+	if c.size <= 0 {
+		return
+	}
+
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*lruItem).val = val
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{key: key, val: val})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem).key)
+	}
+}
@@ -0,0 +1,34 @@
+package entity_storage_s3
+
+import "testing"
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	if val, ok := c.Get("a"); !ok || string(val) != "1" {
+		t.Fatalf("a should be cached: %s", val)
+	}
+
+	c.Set("c", []byte("3")) // b is the least recently used
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("b should be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("a should be cached")
+	}
+
+	c.Set("c", []byte("4"))
+	if val, ok := c.Get("c"); !ok || string(val) != "4" {
+		t.Errorf("c should be replaced: %s", val)
+	}
+	if len(c.items) != 2 || c.order.Len() != 2 {
+		t.Errorf("wrong cache size: %d %d", len(c.items), c.order.Len())
+	}
+
+	disabled := newLRUCache(0)
+	disabled.Set("a", []byte("1"))
+	if _, ok := disabled.Get("a"); ok {
+		t.Errorf("nothing should be cached if size is 0")
+	}
+}
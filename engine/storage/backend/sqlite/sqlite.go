@@ -0,0 +1,225 @@
+package entity_storage_sqlite
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+const (
+	BUSY_TIMEOUT = 5000 // milliseconds to wait for locks of the database file held by other processes
+)
+
+// Entity storage backed by SQLite, for development and tests on single machine without database servers
+//
+// All entities are stored in one database file, which is opened in WAL mode. Entities of each type are stored in
+// table of the type name with JSON data, the table is created when the type is used for the first time. The database
+// is in memory if the path is ":memory:".
+type sqliteEntityStorage struct {
+	db *sql.DB
+
+	stmtsLock sync.Mutex
+	stmts     map[string]*typeStmts // prepared statements of each type
+}
+
+type typeStmts struct {
+	write  *sql.Stmt
+	read   *sql.Stmt
+	exists *sql.Stmt
+	list   *sql.Stmt
+}
+
+func OpenSQLite(path string) (EntityStorage, error) {
+	gwlog.Debug("Opening SQLite %s ...", path)
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, errors.Wrap(err, "sqlite open failed")
+	}
+
+	// writes are serialized by SQLite, and each connection has its own database in memory
+	db.SetMaxOpenConns(1)
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA synchronous=NORMAL", fmt.Sprintf("PRAGMA busy_timeout=%d", BUSY_TIMEOUT)} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, errors.Wrapf(err, "sqlite %s failed", pragma)
+		}
+	}
+
+	return &sqliteEntityStorage{
+		db:    db,
+		stmts: map[string]*typeStmts{},
+	}, nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// get prepared statements of the type, the table is created if not exists
+func (es *sqliteEntityStorage) getStmts(typeName string) (*typeStmts, error) {
+	es.stmtsLock.Lock()
+	defer es.stmtsLock.Unlock()
+
+	if stmts, ok := es.stmts[typeName]; ok {
+		return stmts, nil
+	}
+
+	table := quoteIdentifier(typeName)
+	if _, err := es.db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, data TEXT NOT NULL)", table)); err != nil {
+		return nil, errors.Wrapf(err, "create table %s failed", table)
+	}
+
+	stmts := &typeStmts{}
+	var err error
+	if stmts.write, err = es.db.Prepare(fmt.Sprintf("INSERT OR REPLACE INTO %s (id, data) VALUES (?, ?)", table)); err != nil {
+		return nil, err
+	}
+	if stmts.read, err = es.db.Prepare(fmt.Sprintf("SELECT data FROM %s WHERE id = ?", table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.exists, err = es.db.Prepare(fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE id = ?)", table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+	if stmts.list, err = es.db.Prepare(fmt.Sprintf("SELECT id FROM %s", table)); err != nil {
+		stmts.close()
+		return nil, err
+	}
+
+	es.stmts[typeName] = stmts
+	return stmts, nil
+}
+
+func (stmts *typeStmts) close() {
+	for _, stmt := range []*sql.Stmt{stmts.write, stmts.read, stmts.exists, stmts.list} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+func (es *sqliteEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmts.write.Exec(string(entityID), string(b))
+	return err
+}
+
+// Write records in one transaction, which is much faster than separated writes in SQLite
+func (es *sqliteEntityStorage) WriteBatch(records []EntityRecord) error {
+	// statements are prepared before the transaction, which holds the only connection
+	stmts := make([]*typeStmts, len(records))
+	for i, r := range records {
+		var err error
+		if stmts[i], err = es.getStmts(r.TypeName); err != nil {
+			return err
+		}
+	}
+
+	tx, err := es.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for i, r := range records {
+		b, err := json.Marshal(r.Data)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if _, err := tx.Stmt(stmts[i].write).Exec(string(r.EntityID), string(b)); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (es *sqliteEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var b []byte
+	err = stmts.read.QueryRow(string(entityID)).Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, nil // entity not exists
+	} else if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	if err = json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (es *sqliteEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return false, err
+	}
+
+	var exists bool
+	err = stmts.exists.QueryRow(string(entityID)).Scan(&exists)
+	return exists, err
+}
+
+func (es *sqliteEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	stmts, err := es.getStmts(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmts.list.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entityIDs []common.EntityID
+	for rows.Next() {
+		var eid string
+		if err := rows.Scan(&eid); err != nil {
+			return nil, err
+		}
+		entityIDs = append(entityIDs, common.EntityID(eid))
+	}
+	return entityIDs, rows.Err()
+}
+
+func (es *sqliteEntityStorage) Close() {
+	es.stmtsLock.Lock()
+	for _, stmts := range es.stmts {
+		stmts.close()
+	}
+	es.stmts = map[string]*typeStmts{}
+	es.stmtsLock.Unlock()
+
+	es.db.Close()
+}
+
+func (es *sqliteEntityStorage) IsEOF(err error) bool {
+	return err == driver.ErrBadConn
+}
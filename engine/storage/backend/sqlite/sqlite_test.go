@@ -0,0 +1,93 @@
+package entity_storage_sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+func TestSQLiteEntityStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	es, err := OpenSQLite(filepath.Join(dir, "goworld.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	entityID := common.GenEntityID()
+	data, err := es.Read("Avatar", entityID)
+	if data != nil || err != nil {
+		t.Errorf("should be nil: %v, %v", data, err)
+	}
+
+	testData := map[string]interface{}{
+		"a": 1,
+		"b": "2",
+		"c": true,
+		"d": 1.11,
+	}
+	if err := es.Write("Avatar", entityID, testData); err != nil {
+		t.Error(err)
+	}
+
+	verifyData, err := es.Read("Avatar", entityID)
+	if err != nil {
+		t.Error(err)
+	}
+	m := verifyData.(map[string]interface{})
+	if m["a"].(float64) != 1 || m["b"].(string) != "2" || m["c"].(bool) != true || m["d"].(float64) != 1.11 {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+
+	if exists, err := es.Exists("Avatar", entityID); !exists || err != nil {
+		t.Errorf("entity should exist: %v", err)
+	}
+	if exists, err := es.Exists("Avatar", common.GenEntityID()); exists || err != nil {
+		t.Errorf("entity should not exist: %v", err)
+	}
+
+	otherID := common.GenEntityID()
+	err = es.WriteBatch([]EntityRecord{
+		{TypeName: "Avatar", EntityID: entityID, Data: map[string]interface{}{"a": 2}},
+		{TypeName: "Avatar", EntityID: otherID, Data: map[string]interface{}{"a": 3}},
+		{TypeName: "Monster", EntityID: otherID, Data: map[string]interface{}{"a": 4}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := es.Read("Avatar", entityID); data.(map[string]interface{})["a"].(float64) != 2 {
+		t.Errorf("data should be overwritten by batch: %v", data)
+	}
+	if data, _ := es.Read("Monster", otherID); data.(map[string]interface{})["a"].(float64) != 4 {
+		t.Errorf("read wrong data of batch: %v", data)
+	}
+
+	if avatarIDs, err := es.List("Avatar"); err != nil || len(avatarIDs) != 2 {
+		t.Errorf("wrong Avatar IDs: %v %v", avatarIDs, err)
+	}
+}
+
+func TestSQLiteInMemory(t *testing.T) {
+	es, err := OpenSQLite(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	entityID := common.GenEntityID()
+	if err := es.Write("Avatar", entityID, map[string]interface{}{"name": "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := es.Read("Avatar", entityID); err != nil || data.(map[string]interface{})["name"] != "test" {
+		t.Errorf("read wrong data: %v %v", data, err)
+	}
+}
@@ -0,0 +1,337 @@
+package entity_storage_writebehind
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Write-behind storage caches written entity data in memory and flushes to the backend storage asynchronously.
+// Writes of the same entity in one flush window are coalesced, so that only the latest data is written.
+//
+// Reads, exists checks and listing see the cached data. Callbacks of WriteAsync are called after the data (or a
+// newer data of the same entity) is written to the backend, so that save callbacks still mean durable writes.
+// Cached data is flushed by batch writes of the backend. Remaining data is flushed on Close.
+
+const (
+	_FLUSH_BATCH_SIZE = 100 // max number of entities written to backend in one batch
+)
+
+type entityKey struct {
+	typeName string
+	entityID common.EntityID
+}
+
+type pendingWrite struct {
+	data      interface{}
+	callbacks []func()
+}
+
+// Function for opening the backend storage, called again if the backend connection is broken
+type OpenFunc func() (EntityStorage, error)
+
+type writeBehindEntityStorage struct {
+	open   OpenFunc
+	window time.Duration
+
+	backendLock sync.Mutex // backend is not goroutine-safe
+	backend     EntityStorage
+
+	pendingLock sync.Mutex
+	pending     map[entityKey]*pendingWrite
+
+	closing chan struct{}
+	closed  sync.WaitGroup
+}
+
+// Open the backend storage and wrap it with write-behind cache, data is flushed every window
+func Open(open OpenFunc, window time.Duration) (EntityStorage, error) {
+	backend, err := open()
+	if err != nil {
+		return nil, err
+	}
+
+	es := &writeBehindEntityStorage{
+		open:    open,
+		window:  window,
+		backend: backend,
+		pending: map[entityKey]*pendingWrite{},
+		closing: make(chan struct{}),
+	}
+	es.closed.Add(1)
+	go es.flushRoutine()
+	return es, nil
+}
+
+func (es *writeBehindEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	es.WriteAsync(typeName, entityID, data, nil)
+	return nil
+}
+
+func (es *writeBehindEntityStorage) WriteAsync(typeName string, entityID common.EntityID, data interface{}, callback func()) {
+	key := entityKey{typeName, entityID}
+	es.pendingLock.Lock()
+	pw := es.pending[key]
+	if pw == nil {
+		pw = &pendingWrite{}
+		es.pending[key] = pw
+	}
+	pw.data = data // newer data replaces the older one
+	if callback != nil {
+		pw.callbacks = append(pw.callbacks, callback)
+	}
+	es.pendingLock.Unlock()
+}
+
+func (es *writeBehindEntityStorage) WriteBatch(records []EntityRecord) error {
+	es.WriteBatchAsync(records, nil)
+	return nil
+}
+
+func (es *writeBehindEntityStorage) WriteBatchAsync(records []EntityRecord, callback func()) {
+	if len(records) == 0 {
+		if callback != nil {
+			callback()
+		}
+		return
+	}
+
+	callback = CallbackAfter(len(records), callback)
+	for _, r := range records {
+		es.WriteAsync(r.TypeName, r.EntityID, r.Data, callback)
+	}
+}
+
+func (es *writeBehindEntityStorage) getPending(typeName string, entityID common.EntityID) (interface{}, bool) {
+	es.pendingLock.Lock()
+	defer es.pendingLock.Unlock()
+	if pw, ok := es.pending[entityKey{typeName, entityID}]; ok {
+		return pw.data, true
+	}
+	return nil, false
+}
+
+func (es *writeBehindEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	if data, ok := es.getPending(typeName, entityID); ok {
+		return data, nil
+	}
+
+	var data interface{}
+	err := es.withBackend(func(backend EntityStorage) (err error) {
+		data, err = backend.Read(typeName, entityID)
+		return
+	})
+	return data, err
+}
+
+func (es *writeBehindEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	if _, ok := es.getPending(typeName, entityID); ok {
+		return true, nil
+	}
+
+	var exists bool
+	err := es.withBackend(func(backend EntityStorage) (err error) {
+		exists, err = backend.Exists(typeName, entityID)
+		return
+	})
+	return exists, err
+}
+
+func (es *writeBehindEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	var eids []common.EntityID
+	err := es.withBackend(func(backend EntityStorage) (err error) {
+		eids, err = backend.List(typeName)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	listed := common.StringSet{}
+	for _, eid := range eids {
+		listed.Add(string(eid))
+	}
+
+	es.pendingLock.Lock()
+	for key := range es.pending {
+		if key.typeName == typeName && !listed.Contains(string(key.entityID)) {
+			eids = append(eids, key.entityID)
+		}
+	}
+	es.pendingLock.Unlock()
+	return eids, nil
+}
+
+// Query the backend, and correct the result by cached data of entities of the type
+func (es *writeBehindEntityStorage) Query(typeName string, filter map[string]interface{}, limit int) ([]common.EntityID, error) {
+	es.pendingLock.Lock()
+	pending := map[common.EntityID]interface{}{}
+	for key, pw := range es.pending {
+		if key.typeName == typeName {
+			pending[key.entityID] = pw.data
+		}
+	}
+	es.pendingLock.Unlock()
+
+	backendLimit := limit
+	if limit > 0 {
+		backendLimit += len(pending) // pending entities found by backend might be removed
+	}
+	var eids []common.EntityID
+	err := es.withBackend(func(backend EntityStorage) (err error) {
+		if queryBackend, ok := backend.(QueryEntityStorage); ok {
+			eids, err = queryBackend.Query(typeName, filter, backendLimit)
+		} else {
+			eids, err = QueryByScan(backend, typeName, filter, backendLimit)
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return MergeQueryResult(eids, pending, filter, limit), nil
+}
+
+func (es *writeBehindEntityStorage) EnsureIndex(typeName string, attr string) error {
+	return es.withBackend(func(backend EntityStorage) error {
+		if queryBackend, ok := backend.(QueryEntityStorage); ok {
+			return queryBackend.EnsureIndex(typeName, attr)
+		}
+		return nil
+	})
+}
+
+func (es *writeBehindEntityStorage) Close() {
+	close(es.closing)
+	es.closed.Wait()
+
+	es.backendLock.Lock()
+	if es.backend != nil {
+		es.backend.Close()
+		es.backend = nil
+	}
+	es.backendLock.Unlock()
+}
+
+func (es *writeBehindEntityStorage) IsEOF(err error) bool {
+	return false // backend is reopened by write-behind storage itself
+}
+
+// call the function with backend, the backend is reopened if connection is broken
+func (es *writeBehindEntityStorage) withBackend(f func(backend EntityStorage) error) error {
+	es.backendLock.Lock()
+	defer es.backendLock.Unlock()
+
+	if es.backend == nil {
+		backend, err := es.open()
+		if err != nil {
+			return err
+		}
+		es.backend = backend
+	}
+
+	err := f(es.backend)
+	if err != nil && es.backend.IsEOF(err) {
+		es.backend.Close()
+		es.backend = nil
+	}
+	return err
+}
+
+func (es *writeBehindEntityStorage) flushRoutine() {
+	defer es.closed.Done()
+
+	ticker := time.NewTicker(es.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			es.flush(false)
+		case <-es.closing:
+			es.flush(true)
+			return
+		}
+	}
+}
+
+// write all pending data to backend, retry until all written if closing
+func (es *writeBehindEntityStorage) flush(closing bool) {
+	for {
+		es.pendingLock.Lock()
+		batch := es.pending
+		es.pending = map[entityKey]*pendingWrite{}
+		es.pendingLock.Unlock()
+
+		if len(batch) == 0 {
+			return
+		}
+
+		failed := es.writeBatch(batch)
+		if failed == 0 || !closing {
+			return
+		}
+		gwlog.Error("write-behind storage: %d entities not written on close, retrying ...", failed)
+		time.Sleep(time.Second)
+	}
+}
+
+// write a batch of pending data in order by batch writes of backend, failed writes are put back unless replaced by
+// newer writes
+func (es *writeBehindEntityStorage) writeBatch(batch map[entityKey]*pendingWrite) (failed int) {
+	keys := make([]entityKey, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].typeName != keys[j].typeName {
+			return keys[i].typeName < keys[j].typeName
+		}
+		return keys[i].entityID < keys[j].entityID
+	})
+
+	for start := 0; start < len(keys); start += _FLUSH_BATCH_SIZE {
+		end := start + _FLUSH_BATCH_SIZE
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		records := make([]EntityRecord, 0, end-start)
+		for _, key := range keys[start:end] {
+			records = append(records, EntityRecord{TypeName: key.typeName, EntityID: key.entityID, Data: batch[key].data})
+		}
+		err := es.withBackend(func(backend EntityStorage) error {
+			return backend.WriteBatch(records)
+		})
+
+		if err != nil {
+			gwlog.Error("write-behind storage: write %d entities failed: %s", len(records), err)
+			failed += len(records)
+			for _, key := range keys[start:end] {
+				es.putBack(key, batch[key])
+			}
+			continue
+		}
+
+		for _, key := range keys[start:end] {
+			for _, cb := range batch[key].callbacks {
+				cb()
+			}
+		}
+	}
+	return
+}
+
+func (es *writeBehindEntityStorage) putBack(key entityKey, pw *pendingWrite) {
+	es.pendingLock.Lock()
+	if newer, ok := es.pending[key]; ok {
+		newer.callbacks = append(pw.callbacks, newer.callbacks...)
+	} else {
+		es.pending[key] = pw
+	}
+	es.pendingLock.Unlock()
+}
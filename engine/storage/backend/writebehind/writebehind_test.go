@@ -0,0 +1,147 @@
+package entity_storage_writebehind
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+type memEntityStorage struct {
+	sync.Mutex
+	data    map[common.EntityID]interface{}
+	writes  int
+	batches int
+}
+
+func (es *memEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	es.Lock()
+	defer es.Unlock()
+	var eids []common.EntityID
+	for eid := range es.data {
+		eids = append(eids, eid)
+	}
+	return eids, nil
+}
+
+func (es *memEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	es.Lock()
+	es.data[entityID] = data
+	es.writes += 1
+	es.Unlock()
+	return nil
+}
+
+func (es *memEntityStorage) WriteBatch(records []EntityRecord) error {
+	es.Lock()
+	es.batches += 1
+	es.Unlock()
+	return WriteEach(es, records)
+}
+
+func (es *memEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	es.Lock()
+	defer es.Unlock()
+	return es.data[entityID], nil
+}
+
+func (es *memEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	es.Lock()
+	defer es.Unlock()
+	_, ok := es.data[entityID]
+	return ok, nil
+}
+
+func (es *memEntityStorage) Close() {}
+
+func (es *memEntityStorage) IsEOF(err error) bool {
+	return false
+}
+
+func TestWriteBehindCoalesce(t *testing.T) {
+	backend := &memEntityStorage{data: map[common.EntityID]interface{}{}}
+	es, err := Open(func() (EntityStorage, error) { return backend, nil }, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eid := common.GenEntityID()
+	callbacks := 0
+	for i := 0; i < 10; i++ {
+		es.(AsyncWriteEntityStorage).WriteAsync("Avatar", eid, i, func() { callbacks += 1 })
+	}
+
+	if data, _ := es.Read("Avatar", eid); data != 9 {
+		t.Errorf("should read cached data: %v", data)
+	}
+	if eids, _ := es.List("Avatar"); len(eids) != 1 {
+		t.Errorf("should list cached entity: %v", eids)
+	}
+	if backend.writes != 0 {
+		t.Errorf("should not write before flush")
+	}
+
+	es.Close()
+	if backend.writes != 1 || backend.data[eid] != 9 {
+		t.Errorf("writes not coalesced: writes=%d, data=%v", backend.writes, backend.data[eid])
+	}
+	if callbacks != 10 {
+		t.Errorf("callbacks not called: %d", callbacks)
+	}
+}
+
+func TestWriteBehindBatch(t *testing.T) {
+	backend := &memEntityStorage{data: map[common.EntityID]interface{}{}}
+	es, err := Open(func() (EntityStorage, error) { return backend, nil }, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var records []EntityRecord
+	for i := 0; i < _FLUSH_BATCH_SIZE+1; i++ {
+		records = append(records, EntityRecord{TypeName: "Avatar", EntityID: common.GenEntityID(), Data: i})
+	}
+	callbacks := 0
+	es.(AsyncWriteEntityStorage).WriteBatchAsync(records, func() { callbacks += 1 })
+
+	es.Close()
+	if backend.writes != len(records) || backend.batches != 2 {
+		t.Errorf("wrong batch writes: writes=%d, batches=%d", backend.writes, backend.batches)
+	}
+	if callbacks != 1 {
+		t.Errorf("callback should be called once after all records are written: %d", callbacks)
+	}
+}
+
+func TestWriteBehindQuery(t *testing.T) {
+	alice, bob, carol := common.GenEntityID(), common.GenEntityID(), common.GenEntityID()
+	backend := &memEntityStorage{data: map[common.EntityID]interface{}{
+		alice: map[string]interface{}{"name": "alice"},
+		bob:   map[string]interface{}{"name": "bob"},
+	}}
+	es, err := Open(func() (EntityStorage, error) { return backend, nil }, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	// alice is renamed and carol is created in cache, backend is queried by scan
+	es.Write("Avatar", alice, map[string]interface{}{"name": "alex"})
+	es.Write("Avatar", carol, map[string]interface{}{"name": "bob"})
+
+	queryStorage := es.(QueryEntityStorage)
+	if eids, err := queryStorage.Query("Avatar", map[string]interface{}{"name": "alice"}, 0); err != nil || len(eids) != 0 {
+		t.Errorf("renamed entity should not be found: %v %v", eids, err)
+	}
+	if eids, err := queryStorage.Query("Avatar", map[string]interface{}{"name": "alex"}, 0); err != nil || len(eids) != 1 || eids[0] != alice {
+		t.Errorf("cached entity should be found: %v %v", eids, err)
+	}
+	if eids, err := queryStorage.Query("Avatar", map[string]interface{}{"name": "bob"}, 0); err != nil || len(eids) != 2 {
+		t.Errorf("entities in backend and cache should be found: %v %v", eids, err)
+	}
+	if eids, err := queryStorage.Query("Avatar", map[string]interface{}{"name": "bob"}, 1); err != nil || len(eids) != 1 {
+		t.Errorf("entities found should be limited: %v %v", eids, err)
+	}
+}
@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Entities of types routed to other storages are stored in those storages, and entities of other types are stored in
+// the main storage configured by [storage]. Storages of types are configured by [storage_<name>] sections with types,
+// e.g. Account in PostgreSQL and BattleRecord in S3, and entities of cold types (e.g. archived characters and old
+// guilds, which are rarely loaded) are stored in S3 of cold_url. Backup and quarantine copies of entities are stored
+// in the storage of the type. Partial writes are not supported if types are routed.
+type routedEntityStorage struct {
+	main     EntityStorage
+	routes   map[string]EntityStorage // storage of each routed type
+	storages []EntityStorage          // all storages, the main storage is the first
+}
+
+func newRoutedEntityStorage(main EntityStorage, routes map[string]EntityStorage) *routedEntityStorage {
+	es := &routedEntityStorage{
+		main:     main,
+		routes:   routes,
+		storages: []EntityStorage{main},
+	}
+	for _, storage := range routes {
+		if !es.hasStorage(storage) {
+			es.storages = append(es.storages, storage)
+		}
+	}
+	return es
+}
+
+func (es *routedEntityStorage) hasStorage(storage EntityStorage) bool {
+	for _, s := range es.storages {
+		if s == storage {
+			return true
+		}
+	}
+	return false
+}
+
+// Get the storage of entities of the type
+func (es *routedEntityStorage) route(typeName string) EntityStorage {
+	typeName = strings.TrimSuffix(typeName, _BACKUP_TYPE_SUFFIX)
+	typeName = strings.TrimSuffix(typeName, _QUARANTINE_TYPE_SUFFIX)
+	if storage, ok := es.routes[typeName]; ok {
+		return storage
+	}
+	return es.main
+}
+
+func (es *routedEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	return es.route(typeName).List(typeName)
+}
+
+func (es *routedEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	return es.route(typeName).Write(typeName, entityID, data)
+}
+
+// Records are written in separated batches of storages
+func (es *routedEntityStorage) WriteBatch(records []EntityRecord) error {
+	batches := make([][]EntityRecord, len(es.storages))
+	for _, r := range records {
+		storage := es.route(r.TypeName)
+		for i, s := range es.storages {
+			if s == storage {
+				batches[i] = append(batches[i], r)
+				break
+			}
+		}
+	}
+
+	for i, batch := range batches {
+		if len(batch) == 0 {
+			continue
+		}
+		if err := es.storages[i].WriteBatch(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (es *routedEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	return es.route(typeName).Read(typeName, entityID)
+}
+
+func (es *routedEntityStorage) ReadRevision(typeName string, entityID common.EntityID) (int64, error) {
+	return readRevision(es.route(typeName), typeName, entityID)
+}
+
+func (es *routedEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	return es.route(typeName).Exists(typeName, entityID)
+}
+
+// Entities are queried by reading all entities of the type if the storage of the type does not support queries
+func (es *routedEntityStorage) Query(typeName string, filter map[string]interface{}, limit int) ([]common.EntityID, error) {
+	backend := es.route(typeName)
+	if queryBackend, ok := backend.(QueryEntityStorage); ok {
+		return queryBackend.Query(typeName, filter, limit)
+	}
+	return QueryByScan(backend, typeName, filter, limit)
+}
+
+func (es *routedEntityStorage) EnsureIndex(typeName string, attr string) error {
+	if queryBackend, ok := es.route(typeName).(QueryEntityStorage); ok {
+		return queryBackend.EnsureIndex(typeName, attr)
+	}
+	return nil
+}
+
+func (es *routedEntityStorage) Close() {
+	for _, storage := range es.storages {
+		storage.Close()
+	}
+}
+
+// All storages are reopened if the connection of any storage is broken
+func (es *routedEntityStorage) IsEOF(err error) bool {
+	for _, storage := range es.storages {
+		if storage.IsEOF(err) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+func TestRoutedEntityStorage(t *testing.T) {
+	mainDir, _ := ioutil.TempDir("", "main_storage")
+	coldDir, _ := ioutil.TempDir("", "cold_storage")
+	defer os.RemoveAll(mainDir)
+	defer os.RemoveAll(coldDir)
+	mainStorage, _ := entity_storage_filesystem.OpenDirectory(mainDir)
+	coldStorage, _ := entity_storage_filesystem.OpenDirectory(coldDir)
+	es := newRoutedEntityStorage(mainStorage, map[string]EntityStorage{"ArchivedAvatar": coldStorage, "OldGuild": coldStorage})
+	if len(es.storages) != 2 {
+		t.Fatalf("storages should not be duplicated: %v", es.storages)
+	}
+
+	avatarID, archivedID := common.GenEntityID(), common.GenEntityID()
+	err := es.WriteBatch([]EntityRecord{
+		{TypeName: "Avatar", EntityID: avatarID, Data: map[string]interface{}{"a": 1}},
+		{TypeName: "ArchivedAvatar", EntityID: archivedID, Data: map[string]interface{}{"a": 2}},
+		{TypeName: "ArchivedAvatar" + _BACKUP_TYPE_SUFFIX, EntityID: archivedID, Data: map[string]interface{}{"a": 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exists, _ := mainStorage.Exists("Avatar", avatarID); !exists {
+		t.Errorf("Avatar should be stored in main storage")
+	}
+	if exists, _ := coldStorage.Exists("ArchivedAvatar", archivedID); !exists {
+		t.Errorf("ArchivedAvatar should be stored in cold storage")
+	}
+	if exists, _ := coldStorage.Exists("ArchivedAvatar"+_BACKUP_TYPE_SUFFIX, archivedID); !exists {
+		t.Errorf("backup of ArchivedAvatar should be stored in cold storage")
+	}
+	if exists, _ := mainStorage.Exists("ArchivedAvatar", archivedID); exists {
+		t.Errorf("ArchivedAvatar should not be stored in main storage")
+	}
+
+	if data, err := es.Read("ArchivedAvatar", archivedID); err != nil || data.(map[string]interface{})["a"].(float64) != 2 {
+		t.Errorf("read wrong data of ArchivedAvatar: %v %v", data, err)
+	}
+	if eids, err := es.Query("ArchivedAvatar", map[string]interface{}{"a": 2}, 0); err != nil || len(eids) != 1 {
+		t.Errorf("ArchivedAvatar should be found: %v %v", eids, err)
+	}
+}
@@ -1,22 +1,32 @@
 package storage
 
 import (
+	"sync"
 	"time"
 
 	"os"
 
 	"strconv"
+	"strings"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/metrics"
 	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/storage/backend/bolt"
+	"github.com/xiaonanln/goworld/engine/storage/backend/circuitbreaker"
 	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
 	"github.com/xiaonanln/goworld/engine/storage/backend/mongodb"
+	"github.com/xiaonanln/goworld/engine/storage/backend/postgres"
 	"github.com/xiaonanln/goworld/engine/storage/backend/redis"
+	"github.com/xiaonanln/goworld/engine/storage/backend/s3"
+	"github.com/xiaonanln/goworld/engine/storage/backend/sqlite"
+	"github.com/xiaonanln/goworld/engine/storage/backend/writebehind"
 	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
 )
 
@@ -24,6 +34,13 @@ var (
 	storageEngine            EntityStorage
 	operationQueue           = xnsyncutil.NewSyncQueue()
 	storageRoutineTerminated = xnsyncutil.NewOneTimeCond()
+	partialWriteSupported    bool
+	querySupported           bool
+
+	pendingSavesLock sync.Mutex
+	pendingSaves     []saveRequest // saves in the current tick, written in batch
+
+	logger = gwlog.Module("storage")
 )
 
 type saveRequest struct {
@@ -33,6 +50,17 @@ type saveRequest struct {
 	Callback SaveCallbackFunc
 }
 
+type saveBatchRequest struct {
+	Saves []saveRequest
+}
+
+type savePartialRequest struct {
+	TypeName string
+	EntityID common.EntityID
+	Patch    EntityDataPatch
+	Callback SaveCallbackFunc
+}
+
 type loadRequest struct {
 	TypeName string
 	EntityID common.EntityID
@@ -50,45 +78,126 @@ type listEntityIDsRequest struct {
 	Callback ListCallbackFunc
 }
 
-type SaveCallbackFunc func()
+type queryRequest struct {
+	TypeName string
+	Filter   map[string]interface{}
+	Limit    int
+	Callback ListCallbackFunc
+}
+
+type ensureIndexRequest struct {
+	TypeName string
+	Attr     string
+}
+
+// Callback of saves, err is a RevisionConflictError if the save is dropped by revision check
+type SaveCallbackFunc func(err error)
 type LoadCallbackFunc func(data interface{}, err error)
 type ExistsCallbackFunc func(exists bool, err error)
 type ListCallbackFunc func([]common.EntityID, error)
 
+// Save entity data, saves in the same tick are written to the storage engine in batch
 func Save(typeName string, entityID common.EntityID, data interface{}, callback SaveCallbackFunc) {
-	operationQueue.Push(saveRequest{
+	pendingSavesLock.Lock()
+	pendingSaves = append(pendingSaves, saveRequest{
 		TypeName: typeName,
 		EntityID: entityID,
 		Data:     data,
 		Callback: callback,
 	})
+	n := len(pendingSaves)
+	pendingSavesLock.Unlock()
+
+	if n >= consts.STORAGE_SAVE_BATCH_SIZE {
+		flushPendingSaves()
+	} else if n == 1 {
+		post.Post(flushPendingSaves) // flushed after other things are done in this tick
+	}
+}
+
+// Push pending saves as one batch to the operation queue
+func flushPendingSaves() {
+	pendingSavesLock.Lock()
+	saves := pendingSaves
+	pendingSaves = nil
+	pendingSavesLock.Unlock()
+
+	if len(saves) == 0 {
+		return
+	}
+	operationQueue.Push(saveBatchRequest{Saves: saves})
 	checkOperationQueueLen()
 }
 
+// Push the operation after pending saves, so that operations are handled in order
+func pushOperation(op interface{}) {
+	flushPendingSaves()
+	operationQueue.Push(op)
+	checkOperationQueueLen()
+}
+
+// Save changed attributes of entity which is already saved, only works if IsPartialWriteSupported
+func SavePartial(typeName string, entityID common.EntityID, patch EntityDataPatch, callback SaveCallbackFunc) {
+	pushOperation(savePartialRequest{
+		TypeName: typeName,
+		EntityID: entityID,
+		Patch:    patch,
+		Callback: callback,
+	})
+}
+
+// Returns if the storage engine can write changed attributes of entities
+func IsPartialWriteSupported() bool {
+	return partialWriteSupported
+}
+
 func Load(typeName string, entityID common.EntityID, callback LoadCallbackFunc) {
-	operationQueue.Push(loadRequest{
+	pushOperation(loadRequest{
 		TypeName: typeName,
 		EntityID: entityID,
 		Callback: callback,
 	})
-	checkOperationQueueLen()
 }
 
 func Exists(typeName string, entityID common.EntityID, callback ExistsCallbackFunc) {
-	operationQueue.Push(existsRequest{
+	pushOperation(existsRequest{
 		TypeName: typeName,
 		EntityID: entityID,
 		Callback: callback,
 	})
-	checkOperationQueueLen()
 }
 
 func ListEntityIDs(typeName string, callback ListCallbackFunc) {
-	operationQueue.Push(listEntityIDsRequest{
+	pushOperation(listEntityIDsRequest{
 		TypeName: typeName,
 		Callback: callback,
 	})
-	checkOperationQueueLen()
+}
+
+// Find IDs of entities with attributes equal to values in the filter, at most limit entities are found if limit > 0
+//
+// Attributes in the filter are paths like "name" or "profile.level". If the storage engine does not support queries
+// (see IsQuerySupported), all entities of the type are read to find matched entities, which is slow for large types.
+func Query(typeName string, filter map[string]interface{}, limit int, callback ListCallbackFunc) {
+	pushOperation(queryRequest{
+		TypeName: typeName,
+		Filter:   filter,
+		Limit:    limit,
+		Callback: callback,
+	})
+}
+
+// Create index of the attribute path for queries of the type, ignored if the storage engine does not support queries
+func EnsureIndex(typeName string, attr string) {
+	pushOperation(ensureIndexRequest{
+		TypeName: typeName,
+		Attr:     attr,
+	})
+}
+
+// Returns if the storage engine can find entities by attributes without reading all entities
+func IsQuerySupported() bool {
+	return querySupported
 }
 
 func GetQueueLen() int {
@@ -100,12 +209,13 @@ var recentWarnedQueueLen = 0
 func checkOperationQueueLen() {
 	qlen := operationQueue.Len()
 	if qlen > 100 && qlen%100 == 0 && recentWarnedQueueLen != qlen {
-		gwlog.Warn("Storage operation queue length = %d", qlen)
+		logger.Warn("Storage operation queue length = %d", qlen)
 		recentWarnedQueueLen = qlen
 	}
 }
 
 func Close() {
+	flushPendingSaves()
 	operationQueue.Close()
 }
 
@@ -116,8 +226,14 @@ func WaitTerminated() {
 func Initialize() {
 	err := assureStorageEngineReady()
 	if err != nil {
-		gwlog.Fatal("Storage engine is not ready: %s", err)
+		logger.Fatal("Storage engine is not ready: %s", err)
 	}
+	_, partialWriteSupported = storageEngine.(PartialWriteEntityStorage)
+	partialWriteSupported = partialWriteSupported && !IsRevisionCheckEnabled() // partial saves do not check revisions
+	_, querySupported = storageEngine.(QueryEntityStorage)
+	metrics.NewGaugeFunc("goworld_storage_queue_length", "Number of storage operations waiting in queue", func() float64 {
+		return float64(operationQueue.Len())
+	})
 	go storageRoutine()
 }
 
@@ -127,6 +243,75 @@ func assureStorageEngineReady() (err error) {
 	}
 
 	cfg := config.GetStorage()
+	open := openStorageEngine
+	if cfg.OpTimeout > 0 {
+		open = openCircuitBreakerStorage
+	}
+	if cfg.WriteBehindWindow > 0 {
+		storageEngine, err = entity_storage_writebehind.Open(open, cfg.WriteBehindWindow)
+	} else {
+		storageEngine, err = open()
+	}
+	return
+}
+
+// Wrap the storage engine with timeouts of operations, circuit breaker and retry queue of writes
+func openCircuitBreakerStorage() (EntityStorage, error) {
+	cfg := config.GetStorage()
+	return entity_storage_circuitbreaker.Open(openStorageEngine, entity_storage_circuitbreaker.Options{
+		Timeout:        cfg.OpTimeout,
+		Failures:       cfg.BreakerFailures,
+		Cooldown:       cfg.BreakerCooldown,
+		RetryQueueSize: cfg.RetryQueueSize,
+	})
+}
+
+// Open the main storage and storages of routed types
+func openStorageEngine() (EntityStorage, error) {
+	cfg := config.GetStorage()
+	mainStorage, err := openStorageByConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := map[string]EntityStorage{}
+	opened := []EntityStorage{mainStorage}
+	openRoute := func(typeCfg *config.StorageConfig, types []string) error {
+		storage, err := openStorageByConfig(typeCfg)
+		if err != nil {
+			return err
+		}
+		opened = append(opened, storage)
+		for _, typeName := range types {
+			routes[typeName] = storage
+		}
+		return nil
+	}
+
+	for name, typeCfg := range config.GetTypeStorages() {
+		if err = openRoute(typeCfg, typeCfg.Types); err != nil {
+			err = errors.Wrapf(err, "open storage %s failed", name)
+			break
+		}
+	}
+	if err == nil && len(cfg.ColdTypes) > 0 {
+		err = openRoute(&config.StorageConfig{Type: "s3", Url: cfg.ColdUrl, CacheSize: cfg.CacheSize}, cfg.ColdTypes)
+	}
+	if err != nil {
+		for _, storage := range opened {
+			storage.Close()
+		}
+		return nil, err
+	}
+
+	if len(routes) == 0 {
+		return mainStorage, nil
+	}
+	return newRoutedEntityStorage(mainStorage, routes), nil
+}
+
+// Open the storage engine of the type in config
+func openStorageByConfig(cfg *config.StorageConfig) (storageEngine EntityStorage, err error) {
 	if cfg.Type == "filesystem" {
 		storageEngine, err = entity_storage_filesystem.OpenDirectory(cfg.Directory)
 	} else if cfg.Type == "mongodb" {
@@ -136,6 +321,16 @@ func assureStorageEngineReady() (err error) {
 		if dbindex, err = strconv.Atoi(cfg.DB); err == nil {
 			storageEngine, err = entity_storage_redis.OpenRedis(cfg.Host, dbindex)
 		}
+	} else if cfg.Type == "postgres" {
+		storageEngine, err = entity_storage_postgres.OpenPostgres(cfg.Url)
+	} else if cfg.Type == "redis_cluster" {
+		storageEngine, err = entity_storage_redis.OpenRedisCluster(strings.Split(cfg.Host, ","))
+	} else if cfg.Type == "sqlite" {
+		storageEngine, err = entity_storage_sqlite.OpenSQLite(cfg.Url)
+	} else if cfg.Type == "bolt" {
+		storageEngine, err = entity_storage_bolt.OpenBolt(cfg.Url)
+	} else if cfg.Type == "s3" {
+		storageEngine, err = entity_storage_s3.OpenS3(cfg.Url, cfg.CacheSize)
 	} else {
 		gwlog.Panicf("unknown storage type: %s", cfg.Type)
 		if consts.DEBUG_MODE {
@@ -150,7 +345,7 @@ func storageRoutine() {
 	defer func() {
 		err := recover()
 		if err != nil {
-			gwlog.TraceError("storage routine paniced: %s, restarting ...", err)
+			logger.TraceError("storage routine paniced: %s, restarting ...", err)
 			go storageRoutine() // restart the storage routine
 		} else {
 			// normal quit
@@ -162,7 +357,7 @@ func storageRoutine() {
 	for {
 		err := assureStorageEngineReady()
 		if err != nil {
-			gwlog.Error("Storage engine is not ready: %s", err)
+			logger.Error("Storage engine is not ready: %s", err)
 			time.Sleep(time.Second)
 			continue
 		}
@@ -173,30 +368,65 @@ func storageRoutine() {
 		}
 
 		var monop *opmon.Operation
-		if saveReq, ok := op.(saveRequest); ok {
-			// handle save request
+		if batchReq, ok := op.(saveBatchRequest); ok {
+			// handle saves in the same tick
 			monop = opmon.StartOperation("storage.save")
-			for {
+			records := make([]EntityRecord, len(batchReq.Saves))
+			for i, saveReq := range batchReq.Saves {
 				if consts.DEBUG_SAVE_LOAD {
-					gwlog.Debug("storage: SAVING %s %s ...", saveReq.TypeName, saveReq.EntityID)
+					logger.Debug("storage: SAVING %s %s ...", saveReq.TypeName, saveReq.EntityID)
 				}
+				records[i] = EntityRecord{TypeName: saveReq.TypeName, EntityID: saveReq.EntityID, Data: sealEntityData(saveReq.Data)}
+			}
+			handled := make([]bool, len(records)) // records dropped or written by revision check
+			saveErrs := make([]error, len(records))
+			revisionChecked := !IsRevisionCheckEnabled()
+			for {
 				err := assureStorageEngineReady()
 				if err != nil {
-					gwlog.Error("Storage engine is not ready: %s", err)
+					logger.Error("Storage engine is not ready: %s", err)
 					time.Sleep(time.Second) // wait for 1 second to retry
 					continue
 				}
 
 				if storageEngine == nil {
-					gwlog.Fatal("storage engine is nil")
+					logger.Fatal("storage engine is nil")
 				}
 
-				err = storageEngine.Write(saveReq.TypeName, saveReq.EntityID, saveReq.Data)
+				if !revisionChecked {
+					unhandled, err := checkRevisions(storageEngine, records, handled, saveErrs)
+					if err != nil {
+						logger.Error("storage: check revisions of %d entities failed: %s", len(records), err)
+						if storageEngine.IsEOF(err) {
+							storageEngine.Close()
+							storageEngine = nil
+						}
+						continue // always retry if fail
+					}
+					records, revisionChecked = unhandled, true
+				}
+
+				if len(records) == 0 { // all saves are dropped or written by revision check
+					monop.Finish(time.Millisecond * 100)
+					if callback := saveBatchCallbackPoster(batchReq.Saves, saveErrs); callback != nil {
+						callback()
+					}
+					break
+				}
+
+				if asyncStorage, ok := storageEngine.(AsyncWriteEntityStorage); ok {
+					// the callback is called after data is written by the async storage
+					asyncStorage.WriteBatchAsync(records, saveBatchCallbackPoster(batchReq.Saves, saveErrs))
+					monop.Finish(time.Millisecond * 100)
+					break
+				}
+
+				err = storageEngine.WriteBatch(records)
 				if err != nil {
 					// save failed ?
-					gwlog.Error("storage: save failed: %s", err)
+					logger.Error("storage: save %d entities failed: %s", len(records), err)
 
-					if err != nil && storageEngine.IsEOF(err) {
+					if storageEngine.IsEOF(err) {
 						storageEngine.Close()
 						storageEngine = nil
 					}
@@ -204,22 +434,55 @@ func storageRoutine() {
 					continue // always retry if fail
 				} else {
 					monop.Finish(time.Millisecond * 100)
-					if saveReq.Callback != nil {
-						post.Post(func() {
-							saveReq.Callback()
-						})
+					if callback := saveBatchCallbackPoster(batchReq.Saves, saveErrs); callback != nil {
+						callback()
 					}
 					break
 				}
 			}
+		} else if savePartialReq, ok := op.(savePartialRequest); ok {
+			monop = opmon.StartOperation("storage.savePartial")
+			patch := sealEntityDataPatch(savePartialReq.Patch)
+			for !savePartialReq.Patch.IsEmpty() {
+				err := assureStorageEngineReady()
+				if err != nil {
+					logger.Error("Storage engine is not ready: %s", err)
+					time.Sleep(time.Second) // wait for 1 second to retry
+					continue
+				}
+
+				partialStorage, ok := storageEngine.(PartialWriteEntityStorage)
+				if !ok {
+					logger.Fatal("storage engine does not support partial write")
+				}
+
+				err = partialStorage.WritePartial(savePartialReq.TypeName, savePartialReq.EntityID, patch)
+				if err == nil {
+					break
+				}
+
+				logger.Error("storage: save partial failed: %s", err)
+				if storageEngine.IsEOF(err) {
+					storageEngine.Close()
+					storageEngine = nil
+				}
+				// always retry if fail
+			}
+
+			monop.Finish(time.Millisecond * 100)
+			if savePartialReq.Callback != nil {
+				post.Post(func() {
+					savePartialReq.Callback(nil)
+				})
+			}
 		} else if loadReq, ok := op.(loadRequest); ok {
 			// handle load request
-			gwlog.Debug("storage: LOADING %s %s ...", loadReq.TypeName, loadReq.EntityID)
+			logger.Debug("storage: LOADING %s %s ...", loadReq.TypeName, loadReq.EntityID)
 			monop = opmon.StartOperation("storage.load")
-			data, err := storageEngine.Read(loadReq.TypeName, loadReq.EntityID)
+			data, err := readEntityData(loadReq.TypeName, loadReq.EntityID)
 			if err != nil {
 				// save failed ?
-				gwlog.TraceError("storage: load %s %s failed: %s", loadReq.TypeName, loadReq.EntityID, err)
+				logger.TraceError("storage: load %s %s failed: %s", loadReq.TypeName, loadReq.EntityID, err)
 				data = nil
 			}
 
@@ -251,8 +514,9 @@ func storageRoutine() {
 			monop = opmon.StartOperation("storage.list")
 			eids, err := storageEngine.List(listReq.TypeName)
 			if err != nil {
-				gwlog.TraceError("ListEntityIDs %s failed: %s", listReq.TypeName, err)
+				logger.TraceError("ListEntityIDs %s failed: %s", listReq.TypeName, err)
 			}
+			eids = filterEntityIDsInNamespace(eids, common.GetLocalNamespace())
 			monop.Finish(time.Millisecond * 1000)
 			if listReq.Callback != nil {
 				post.Post(func() {
@@ -263,8 +527,75 @@ func storageRoutine() {
 				storageEngine.Close()
 				storageEngine = nil
 			}
+		} else if queryReq, ok := op.(queryRequest); ok {
+			monop = opmon.StartOperation("storage.query")
+			var eids []common.EntityID
+			var err error
+			if queryStorage, ok := storageEngine.(QueryEntityStorage); ok {
+				eids, err = queryStorage.Query(queryReq.TypeName, queryReq.Filter, queryReq.Limit)
+			} else {
+				logger.Warn("storage: storage engine does not support query, reading all %s entities ...", queryReq.TypeName)
+				eids, err = QueryByScan(storageEngine, queryReq.TypeName, queryReq.Filter, queryReq.Limit)
+			}
+			if err != nil {
+				logger.TraceError("Query %s %v failed: %s", queryReq.TypeName, queryReq.Filter, err)
+			}
+			eids = filterEntityIDsInNamespace(eids, common.GetLocalNamespace())
+			monop.Finish(time.Millisecond * 1000)
+			if queryReq.Callback != nil {
+				post.Post(func() {
+					queryReq.Callback(eids, err)
+				})
+			}
+			if err != nil && storageEngine.IsEOF(err) {
+				storageEngine.Close()
+				storageEngine = nil
+			}
+		} else if indexReq, ok := op.(ensureIndexRequest); ok {
+			if queryStorage, ok := storageEngine.(QueryEntityStorage); ok {
+				err := queryStorage.EnsureIndex(indexReq.TypeName, indexReq.Attr)
+				if err != nil {
+					logger.Error("storage: ensure index %s of %s failed: %s", indexReq.Attr, indexReq.TypeName, err)
+				}
+				if err != nil && storageEngine.IsEOF(err) {
+					storageEngine.Close()
+					storageEngine = nil
+				}
+			}
 		} else {
 			gwlog.Panicf("storage: unknown operation: %v", op)
 		}
 	}
 }
+
+// Filter entity IDs in the namespace, entities of other namespaces are stored together but never listed
+func filterEntityIDsInNamespace(eids []common.EntityID, ns common.Namespace) []common.EntityID {
+	filtered := eids[:0]
+	for _, eid := range eids {
+		if eid.Namespace() == ns {
+			filtered = append(filtered, eid)
+		}
+	}
+	return filtered
+}
+
+// Returns the function posting callbacks of saves with errors of saves, or nil if none of saves has callback
+func saveBatchCallbackPoster(saves []saveRequest, errs []error) func() {
+	var callbacks []post.PostCallback
+	for i, saveReq := range saves {
+		if saveReq.Callback != nil {
+			callback, err := saveReq.Callback, errs[i]
+			callbacks = append(callbacks, func() {
+				callback(err)
+			})
+		}
+	}
+	if len(callbacks) == 0 {
+		return nil
+	}
+	return func() {
+		for _, callback := range callbacks {
+			post.Post(callback)
+		}
+	}
+}
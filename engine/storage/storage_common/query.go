@@ -0,0 +1,104 @@
+package storage_common
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// Find entities by reading all entities of the type, for storages which do not support queries
+func QueryByScan(es EntityStorage, typeName string, filter map[string]interface{}, limit int) ([]common.EntityID, error) {
+	eids, err := es.List(typeName)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []common.EntityID
+	for _, eid := range eids {
+		data, err := es.Read(typeName, eid)
+		if err != nil {
+			return nil, err
+		}
+		if !MatchFilter(data, filter) {
+			continue
+		}
+		found = append(found, eid)
+		if limit > 0 && len(found) >= limit {
+			break
+		}
+	}
+	return found, nil
+}
+
+// Correct entities found in backend storage by cached data of entities not written to the backend yet
+//
+// The backend should be queried with limit increased by the number of cached entities, since cached entities found
+// might be removed.
+func MergeQueryResult(eids []common.EntityID, cached map[common.EntityID]interface{}, filter map[string]interface{}, limit int) []common.EntityID {
+	found := eids[:0]
+	merged := common.StringSet{}
+	for _, eid := range eids {
+		if data, ok := cached[eid]; ok && !MatchFilter(data, filter) {
+			continue
+		}
+		found = append(found, eid)
+		merged.Add(string(eid))
+	}
+	for eid, data := range cached {
+		if !merged.Contains(string(eid)) && MatchFilter(data, filter) {
+			found = append(found, eid)
+		}
+	}
+	if limit > 0 && len(found) > limit {
+		found = found[:limit]
+	}
+	return found
+}
+
+// Check if the entity data matches all attributes of the filter
+func MatchFilter(data interface{}, filter map[string]interface{}) bool {
+	for path, expected := range filter {
+		val, ok := getAttrByPath(data, path)
+		if !ok || !equalAttrValues(val, expected) {
+			return false
+		}
+	}
+	return true
+}
+
+// Get the attribute by path of keys joined by dots
+func getAttrByPath(data interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if data, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return data, true
+}
+
+// Numbers are compared by values, since data read from storages might be decoded into other number types
+func equalAttrValues(a, b interface{}) bool {
+	if af, ok := toFloat64(a); ok {
+		bf, ok := toFloat64(b)
+		return ok && af == bf
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+	return 0, false
+}
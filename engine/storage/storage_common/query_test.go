@@ -0,0 +1,29 @@
+package storage_common
+
+import "testing"
+
+func TestMatchFilter(t *testing.T) {
+	data := map[string]interface{}{
+		"name":    "alice",
+		"level":   float64(10), // decoded from JSON
+		"profile": map[string]interface{}{"guild": "knights"},
+	}
+
+	for _, c := range []struct {
+		filter  map[string]interface{}
+		matched bool
+	}{
+		{map[string]interface{}{"name": "alice"}, true},
+		{map[string]interface{}{"name": "alice", "level": 10}, true},
+		{map[string]interface{}{"profile.guild": "knights"}, true},
+		{map[string]interface{}{}, true},
+		{map[string]interface{}{"name": "bob"}, false},
+		{map[string]interface{}{"level": "10"}, false},
+		{map[string]interface{}{"name.first": "alice"}, false},
+		{map[string]interface{}{"profile.rank": 1}, false},
+	} {
+		if MatchFilter(data, c.filter) != c.matched {
+			t.Errorf("MatchFilter(%v) should be %v", c.filter, c.matched)
+		}
+	}
+}
@@ -1,12 +1,104 @@
 package storage_common
 
-import "github.com/xiaonanln/goworld/engine/common"
+import (
+	"sync/atomic"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
 
 type EntityStorage interface {
 	List(typeName string) ([]common.EntityID, error)
 	Write(typeName string, entityID common.EntityID, data interface{}) error
+	WriteBatch(records []EntityRecord) error // write data of entities in one round trip if the backend supports
 	Read(typeName string, entityID common.EntityID) (interface{}, error)
 	Exists(typeName string, entityID common.EntityID) (bool, error)
 	Close()
 	IsEOF(err error) bool
 }
+
+// Data of entity to write in batch
+type EntityRecord struct {
+	TypeName string
+	EntityID common.EntityID
+	Data     interface{}
+}
+
+// Write records one by one, for backends which can not write in batch
+func WriteEach(es EntityStorage, records []EntityRecord) error {
+	for _, r := range records {
+		if err := es.Write(r.TypeName, r.EntityID, r.Data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Optional interface of entity storages which write asynchronously, the callback is called after data is written
+type AsyncWriteEntityStorage interface {
+	EntityStorage
+	WriteAsync(typeName string, entityID common.EntityID, data interface{}, callback func())
+	WriteBatchAsync(records []EntityRecord, callback func()) // the callback is called after all records are written
+}
+
+// Returns the callback to be called n times, the original callback is called at the last time
+//
+// The returned callback can be called in any goroutine. Returns nil if the callback is nil.
+func CallbackAfter(n int, callback func()) func() {
+	if callback == nil {
+		return nil
+	}
+	remaining := int32(n)
+	return func() {
+		if atomic.AddInt32(&remaining, -1) == 0 {
+			callback()
+		}
+	}
+}
+
+// Changes of top-level attributes in entity data
+type EntityDataPatch struct {
+	Set   map[string]interface{} // attributes set to new values
+	Unset []string               // attributes deleted
+}
+
+func (patch *EntityDataPatch) IsEmpty() bool {
+	return len(patch.Set) == 0 && len(patch.Unset) == 0
+}
+
+// Optional interface of entity storages which can write changed attributes of existing entity data
+type PartialWriteEntityStorage interface {
+	EntityStorage
+	WritePartial(typeName string, entityID common.EntityID, patch EntityDataPatch) error
+}
+
+// Optional interface of entity storages which can find entities by attributes, instead of reading all entities
+//
+// The filter maps attribute paths (like "name" or "profile.level") to values, and entities with all attributes equal
+// to the values are found. At most limit entities are found if limit > 0.
+type QueryEntityStorage interface {
+	EntityStorage
+	Query(typeName string, filter map[string]interface{}, limit int) ([]common.EntityID, error)
+	EnsureIndex(typeName string, attr string) error // create index of the attribute path for queries if not exists
+}
+
+const (
+	REVISION_KEY = "__revision" // reserved key of revision in entity data
+)
+
+// Optional interface of entity storages which can read the revision of entity data without reading all the data
+//
+// The revision is 0 if the entity does not exist or is written without revision.
+type RevisionEntityStorage interface {
+	EntityStorage
+	ReadRevision(typeName string, entityID common.EntityID) (int64, error)
+}
+
+// Optional interface of entity storages which can check the revision of entity data when writing, so that the check
+// and the write are atomic
+//
+// Data is written only if the stored revision is prevRevision, i.e. the entity does not exist or is written without
+// revision if prevRevision is 0. ok is false if the stored revision is different and data is not written.
+type RevisionWriteEntityStorage interface {
+	RevisionEntityStorage
+	WriteIfRevision(typeName string, entityID common.EntityID, data interface{}, prevRevision int64) (ok bool, err error)
+}
@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Read validation protects live entities from stale or partial data returned by storage engines
+//
+// If read validation is enabled, entity data is written with the checksum and length of its canonical encoding under
+// INTEGRITY_KEY, and data read from storage is validated before it is passed to entities. Corrupted data is read again
+// for read_retries times, since replicas might return stale data temporarily, and then handled by the policy:
+//
+//	retry         fail the load
+//	backup        load the last-known-good backup copy, which is written when validated data is loaded
+//	quarantine    copy the corrupted data to quarantine for investigation, and load the backup copy
+//
+// Backup and quarantine copies are stored as entities of type name with suffixes. Data without integrity info is not
+// validated, e.g. data written before validation is enabled, or by partial saves which do not have all the data to
+// checksum, until the next full save.
+
+const (
+	INTEGRITY_KEY = "__integrity" // reserved key of integrity info in entity data
+
+	_BACKUP_TYPE_SUFFIX     = "__backup"
+	_QUARANTINE_TYPE_SUFFIX = "__quarantine"
+	_READ_RETRY_INTERVAL    = time.Millisecond * 100
+)
+
+var (
+	corruptedReadsMetric = metrics.NewCounterVec("goworld_storage_corrupted_reads_total",
+		"Number of entity loads which read corrupted data after retries by type and result", "type", "result")
+)
+
+// The data read from storage fails validation
+type CorruptedDataError struct {
+	TypeName string
+	EntityID common.EntityID
+	Reason   string
+}
+
+func (err *CorruptedDataError) Error() string {
+	return fmt.Sprintf("corrupted data of %s<%s>: %s", err.TypeName, err.EntityID, err.Reason)
+}
+
+func IsCorruptedData(err error) bool {
+	_, ok := errors.Cause(err).(*CorruptedDataError)
+	return ok
+}
+
+func isReadValidationEnabled() bool {
+	return config.GetStorage().ReadValidation != config.STORAGE_READ_VALIDATION_OFF
+}
+
+// Add integrity info to a copy of entity data for writing
+func sealEntityData(data interface{}) interface{} {
+	m, ok := data.(map[string]interface{})
+	if !ok || !isReadValidationEnabled() {
+		return data
+	}
+
+	integrity, err := getIntegrity(m)
+	if err != nil {
+		logger.Warn("storage: can not checksum entity data: %s", err)
+		return data
+	}
+
+	sealed := make(map[string]interface{}, len(m)+1)
+	for k, v := range m {
+		sealed[k] = v
+	}
+	sealed[INTEGRITY_KEY] = integrity
+	return sealed
+}
+
+// Remove integrity info of data changed by the patch, since the patch does not have all the data to checksum
+func sealEntityDataPatch(patch EntityDataPatch) EntityDataPatch {
+	if !isReadValidationEnabled() {
+		return patch
+	}
+	patch.Unset = append(patch.Unset[:len(patch.Unset):len(patch.Unset)], INTEGRITY_KEY)
+	return patch
+}
+
+// Get integrity info of entity data: checksum and length of the canonical encoding
+func getIntegrity(m map[string]interface{}) (string, error) {
+	b, err := json.Marshal(canonicalize(m))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x:%d", crc32.ChecksumIEEE(b), len(b)), nil
+}
+
+// Convert data to the canonical form which survives all storage engines: numbers are converted to float64 (with
+// float32 precision for fractions) and maps of all types are converted to map[string]interface{}, while JSON encoding
+// sorts map keys
+func canonicalize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if b, ok := v.([]byte); ok {
+		return b
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) {
+			return float64(float32(f)) // float32 might be stored as float64 or in shortest form
+		}
+		return f
+	case reflect.Map:
+		m := make(map[string]interface{}, rv.Len())
+		for _, key := range rv.MapKeys() {
+			m[fmt.Sprint(key.Interface())] = canonicalize(rv.MapIndex(key).Interface())
+		}
+		return m
+	case reflect.Slice, reflect.Array:
+		l := make([]interface{}, rv.Len())
+		for i := range l {
+			l[i] = canonicalize(rv.Index(i).Interface())
+		}
+		return l
+	}
+	return v
+}
+
+// Validate data read from storage, returns data without integrity info
+func validateEntityData(typeName string, entityID common.EntityID, data interface{}) (map[string]interface{}, error) {
+	rv := reflect.ValueOf(data)
+	if data == nil || rv.Kind() != reflect.Map {
+		return nil, &CorruptedDataError{typeName, entityID, fmt.Sprintf("data is %T, not a map", data)}
+	}
+
+	m := make(map[string]interface{}, rv.Len())
+	var integrity interface{}
+	sealed := false
+	for _, key := range rv.MapKeys() {
+		if k := fmt.Sprint(key.Interface()); k == INTEGRITY_KEY {
+			integrity, sealed = rv.MapIndex(key).Interface(), true
+		} else {
+			m[k] = rv.MapIndex(key).Interface()
+		}
+	}
+	if !sealed {
+		return m, nil // written without integrity info
+	}
+
+	expected, _ := integrity.(string)
+	actual, err := getIntegrity(m)
+	if err != nil {
+		return nil, &CorruptedDataError{typeName, entityID, err.Error()}
+	}
+	if actual != expected {
+		return nil, &CorruptedDataError{typeName, entityID, fmt.Sprintf("integrity mismatch: expected %s, got %s", expected, actual)}
+	}
+	return m, nil
+}
+
+func isSealed(data interface{}) bool {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = m[INTEGRITY_KEY]
+	return ok
+}
+
+// Remove integrity info from data written when read validation was enabled
+func stripIntegrity(data interface{}) interface{} {
+	if !isSealed(data) {
+		return data
+	}
+	m := data.(map[string]interface{})
+	stripped := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if k != INTEGRITY_KEY {
+			stripped[k] = v
+		}
+	}
+	return stripped
+}
+
+// Read entity data and handle corrupted data by the read validation policy
+func readEntityData(typeName string, entityID common.EntityID) (interface{}, error) {
+	data, err := storageEngine.Read(typeName, entityID)
+	if err != nil || data == nil {
+		return data, err
+	}
+	if !isReadValidationEnabled() {
+		return stripIntegrity(data), nil
+	}
+
+	policy := config.GetStorage().ReadValidation
+	for retry := 0; ; retry++ {
+		m, verr := validateEntityData(typeName, entityID, data)
+		if verr == nil {
+			if policy != config.STORAGE_READ_VALIDATION_RETRY && isSealed(data) {
+				if err := storageEngine.Write(typeName+_BACKUP_TYPE_SUFFIX, entityID, data); err != nil {
+					logger.Error("storage: write backup of %s<%s> failed: %s", typeName, entityID, err)
+				}
+			}
+			return m, nil
+		}
+
+		if retry >= config.GetStorage().ReadRetries {
+			err = verr
+			break
+		}
+		logger.Warn("storage: %s, retrying ...", verr)
+		time.Sleep(_READ_RETRY_INTERVAL)
+		if data, err = storageEngine.Read(typeName, entityID); err != nil || data == nil {
+			return data, err
+		}
+	}
+
+	logger.Error("storage: %s, policy is %s", err, policy)
+	if policy == config.STORAGE_READ_VALIDATION_QUARANTINE {
+		if qerr := storageEngine.Write(typeName+_QUARANTINE_TYPE_SUFFIX, entityID, data); qerr != nil {
+			logger.Error("storage: quarantine %s<%s> failed: %s", typeName, entityID, qerr)
+		}
+	}
+
+	if policy != config.STORAGE_READ_VALIDATION_RETRY {
+		backup, berr := storageEngine.Read(typeName+_BACKUP_TYPE_SUFFIX, entityID)
+		if berr == nil && isSealed(backup) {
+			if m, verr := validateEntityData(typeName, entityID, backup); verr == nil {
+				logger.Warn("storage: %s<%s> is loaded from backup", typeName, entityID)
+				corruptedReadsMetric.With(typeName, "backup").Inc()
+				return m, nil
+			}
+		}
+		logger.Error("storage: no valid backup of %s<%s>", typeName, entityID)
+	}
+
+	corruptedReadsMetric.With(typeName, "failed").Inc()
+	return nil, err
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestValidateEntityData(t *testing.T) {
+	data := map[string]interface{}{
+		"name":  "test",
+		"level": 10,
+		"exp":   float32(0.1),
+		"items": []interface{}{1, "a", map[string]interface{}{"id": int64(3)}},
+		"bag":   map[string]interface{}{"gold": uint32(100)},
+	}
+	integrity, err := getIntegrity(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[INTEGRITY_KEY] = integrity
+
+	// integrity survives JSON storage engines which read numbers as float64
+	b, _ := json.Marshal(data)
+	var read map[string]interface{}
+	json.Unmarshal(b, &read)
+
+	eid := common.GenEntityID()
+	m, err := validateEntityData("Avatar", eid, read)
+	if err != nil {
+		t.Fatalf("validate failed: %s", err)
+	}
+	if _, ok := m[INTEGRITY_KEY]; ok || m["name"] != "test" {
+		t.Fatalf("wrong data: %v", m)
+	}
+
+	delete(read, "bag") // partial data
+	if _, err := validateEntityData("Avatar", eid, read); !IsCorruptedData(err) {
+		t.Fatalf("partial data should be corrupted: %v", err)
+	}
+
+	if _, err := validateEntityData("Avatar", eid, "truncated"); !IsCorruptedData(err) {
+		t.Fatalf("non-map data should be corrupted: %v", err)
+	}
+
+	if _, err := validateEntityData("Avatar", eid, map[string]interface{}{"a": 1}); err != nil {
+		t.Fatalf("data without integrity should not be validated: %s", err)
+	}
+}
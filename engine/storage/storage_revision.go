@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/metrics"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// Revision check catches games saving the same entity, e.g. when two games load the same entity by accident
+//
+// If revision check is enabled, entities save data with revision under REVISION_KEY, which is increased by every save
+// and starts from the revision of the loaded data. The revision of the stored data must be the previous revision, or
+// the save is dropped and the save callback is called with a RevisionConflictError, then the entity destroys itself
+// without saving since its data is stale. Data without revision is not checked, e.g. data saved by storage.Save
+// directly. Entities are always fully saved if revision check is enabled, since partial saves do not check revisions.
+//
+// Storage engines implementing RevisionWriteEntityStorage check the revision and write data atomically, i.e. mongodb and
+// postgres if they are not wrapped for type routes, op_timeout or write_behind_window. Other storage engines read the
+// revision before writing without locking the stored data, so saves of two games at the same moment might not be
+// detected, but the next save of the game with the stale entity fails.
+
+var (
+	revisionConflictsMetric = metrics.NewCounterVec("goworld_storage_revision_conflicts_total",
+		"Number of saves dropped because of revision conflicts by type", "type")
+)
+
+// The revision of the stored data is not the previous revision of the saving data
+type RevisionConflictError struct {
+	TypeName string
+	EntityID common.EntityID
+	Expected int64 // the previous revision of the saving data
+	Stored   int64
+}
+
+func (err *RevisionConflictError) Error() string {
+	return fmt.Sprintf("revision conflict of %s<%s>: expected revision %d, stored revision %d", err.TypeName, err.EntityID, err.Expected, err.Stored)
+}
+
+func IsRevisionConflict(err error) bool {
+	_, ok := errors.Cause(err).(*RevisionConflictError)
+	return ok
+}
+
+// Returns if entities save data with revisions
+func IsRevisionCheckEnabled() bool {
+	return config.GetStorage().CheckRevision
+}
+
+// Get the revision of entity data, returns 0 if data has no revision
+func GetRevision(data interface{}) int64 {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+
+	rv := reflect.ValueOf(m[REVISION_KEY])
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint())
+	case reflect.Float32, reflect.Float64:
+		return int64(rv.Float()) // numbers are read as float64 by JSON storage engines
+	}
+	return 0
+}
+
+// Remove the revision from entity data, returns the revision
+func PopRevision(data map[string]interface{}) int64 {
+	revision := GetRevision(data)
+	delete(data, REVISION_KEY)
+	return revision
+}
+
+// Read the revision of stored entity data
+func readRevision(es EntityStorage, typeName string, entityID common.EntityID) (int64, error) {
+	if revisionStorage, ok := es.(RevisionEntityStorage); ok {
+		return revisionStorage.ReadRevision(typeName, entityID)
+	}
+
+	data, err := es.Read(typeName, entityID)
+	return GetRevision(data), err
+}
+
+// Returns if the saving data has revision
+func hasRevision(data interface{}) bool {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = m[REVISION_KEY]
+	return ok
+}
+
+// Check revisions of records which are not handled yet, returns records to be written as usual
+//
+// Records are handled if they conflict with stored data, whose errors are set in errs, or if they are written by the
+// storage engine with revision check atomically. Records of the same entity in the batch are checked in order with the
+// revision of the previous record. If the check fails, it can be done again with the same handled and errs.
+func checkRevisions(es EntityStorage, records []EntityRecord, handled []bool, errs []error) ([]EntityRecord, error) {
+	type entityKey struct {
+		typeName string
+		entityID common.EntityID
+	}
+	batchRevisions := map[entityKey]int64{}
+	revisionWriter, atomic := es.(RevisionWriteEntityStorage)
+
+	for i, r := range records {
+		if handled[i] || !hasRevision(r.Data) {
+			continue
+		}
+
+		key := entityKey{r.TypeName, r.EntityID}
+		prevRevision := GetRevision(r.Data) - 1
+		var written bool
+		var stored int64
+		var err error
+		if atomic {
+			written, err = revisionWriter.WriteIfRevision(r.TypeName, r.EntityID, r.Data, prevRevision)
+			if err == nil && !written {
+				stored, err = readRevision(es, r.TypeName, r.EntityID) // for the conflict error only
+			}
+		} else if revision, ok := batchRevisions[key]; ok {
+			stored = revision
+		} else {
+			stored, err = readRevision(es, r.TypeName, r.EntityID)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if written {
+			handled[i] = true
+		} else if atomic || stored != prevRevision {
+			err = &RevisionConflictError{r.TypeName, r.EntityID, prevRevision, stored}
+			logger.Error("storage: %s, save is dropped", err)
+			revisionConflictsMetric.With(r.TypeName).Inc()
+			handled[i], errs[i] = true, err
+			continue
+		}
+		batchRevisions[key] = prevRevision + 1
+	}
+
+	var unhandled []EntityRecord
+	for i, r := range records {
+		if !handled[i] {
+			unhandled = append(unhandled, r)
+		}
+	}
+	return unhandled, nil
+}
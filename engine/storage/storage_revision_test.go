@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	. "github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+func TestCheckRevisions(t *testing.T) {
+	for _, atomic := range []bool{false, true} {
+		dir, _ := ioutil.TempDir("", "revision_storage")
+		defer os.RemoveAll(dir)
+		var es EntityStorage
+		es, _ = entity_storage_filesystem.OpenDirectory(dir)
+		if atomic {
+			es = revisionWriteStorage{es}
+		}
+
+		eid, staleID, newID := common.GenEntityID(), common.GenEntityID(), common.GenEntityID()
+		es.Write("Avatar", eid, map[string]interface{}{"a": 1, REVISION_KEY: 1})
+		es.Write("Avatar", staleID, map[string]interface{}{"a": 1, REVISION_KEY: 5})
+
+		records := []EntityRecord{
+			{TypeName: "Avatar", EntityID: eid, Data: map[string]interface{}{"a": 2, REVISION_KEY: int64(2)}},
+			{TypeName: "Avatar", EntityID: eid, Data: map[string]interface{}{"a": 3, REVISION_KEY: int64(3)}}, // saved twice in the batch
+			{TypeName: "Avatar", EntityID: staleID, Data: map[string]interface{}{"a": 2, REVISION_KEY: int64(3)}},
+			{TypeName: "Avatar", EntityID: newID, Data: map[string]interface{}{"a": 1, REVISION_KEY: int64(1)}},
+			{TypeName: "Mail", EntityID: staleID, Data: map[string]interface{}{"a": 1}}, // saved without revision
+		}
+		handled, errs := make([]bool, len(records)), make([]error, len(records))
+		unhandled, err := checkRevisions(es, records, handled, errs)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, err := range errs {
+			if i != 2 && err != nil {
+				t.Errorf("atomic=%v: only the stale save should fail: %v", atomic, err)
+			}
+		}
+		if conflict, ok := errs[2].(*RevisionConflictError); !IsRevisionConflict(errs[2]) || !ok || conflict.Expected != 2 || conflict.Stored != 5 {
+			t.Errorf("atomic=%v: wrong conflict: %v", atomic, errs[2])
+		}
+
+		if atomic {
+			// records with revisions are written by the storage engine
+			if len(unhandled) != 1 || unhandled[0].TypeName != "Mail" {
+				t.Fatalf("only the record without revision should be written as usual: %v", unhandled)
+			}
+			if revision, _ := readRevision(es, "Avatar", eid); revision != 3 {
+				t.Errorf("records should be written in order, revision is %d", revision)
+			}
+		} else if len(unhandled) != 4 {
+			t.Fatalf("only the stale save should be removed: %v", unhandled)
+		}
+
+		// checking again skips handled records
+		if unhandled, err = checkRevisions(es, records, handled, errs); atomic && len(unhandled) != 1 {
+			t.Errorf("written records should not be checked again: %v, %v", unhandled, err)
+		}
+	}
+}
+
+// Storage writing with revision check, like storage engines implementing RevisionWriteEntityStorage
+type revisionWriteStorage struct {
+	EntityStorage
+}
+
+func (es revisionWriteStorage) ReadRevision(typeName string, entityID common.EntityID) (int64, error) {
+	return readRevision(es.EntityStorage, typeName, entityID)
+}
+
+func (es revisionWriteStorage) WriteIfRevision(typeName string, entityID common.EntityID, data interface{}, prevRevision int64) (bool, error) {
+	if revision, err := es.ReadRevision(typeName, entityID); err != nil || revision != prevRevision {
+		return false, err
+	}
+	return true, es.Write(typeName, entityID, data)
+}
+
+func TestGetRevision(t *testing.T) {
+	for _, revision := range []interface{}{3, int64(3), uint32(3), float64(3)} {
+		if r := GetRevision(map[string]interface{}{REVISION_KEY: revision}); r != 3 {
+			t.Errorf("revision of %T should be 3, got %d", revision, r)
+		}
+	}
+
+	data := map[string]interface{}{"a": 1, REVISION_KEY: 7}
+	if PopRevision(data) != 7 || len(data) != 1 {
+		t.Errorf("revision is not popped: %v", data)
+	}
+	if GetRevision("data") != 0 || GetRevision(data) != 0 {
+		t.Errorf("revision of data without revision should be 0")
+	}
+}
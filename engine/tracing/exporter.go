@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_EXPORT_QUEUE_SIZE      = 4096
+	_EXPORT_BATCH_SIZE      = 512
+	_EXPORT_INTERVAL        = time.Second
+	_EXPORT_REQUEST_TIMEOUT = 5 * time.Second
+
+	_STATUS_CODE_ERROR = 2
+)
+
+var (
+	exportQueue = make(chan *otlpSpan, _EXPORT_QUEUE_SIZE)
+)
+
+// Spans in OTLP JSON encoding, see https://github.com/open-telemetry/opentelemetry-proto
+
+type otlpExportRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope   `json:"scope"`
+	Spans []*otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string            `json:"key"`
+	Value map[string]string `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func newOtlpKeyValue(key, val string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: map[string]string{"stringValue": val}}
+}
+
+func newOtlpSpan(s *Span, endTime time.Time) *otlpSpan {
+	os := &otlpSpan{
+		TraceID:           hex.EncodeToString(s.ctx.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.ctx.SpanID[:]),
+		Name:              s.name,
+		Kind:              s.kind,
+		StartTimeUnixNano: strconv.FormatInt(s.startTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(endTime.UnixNano(), 10),
+	}
+	if s.parent != (SpanID{}) {
+		os.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+
+	keys := make([]string, 0, len(s.attrs))
+	for key := range s.attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		os.Attributes = append(os.Attributes, newOtlpKeyValue(key, s.attrs[key]))
+	}
+
+	if s.errmsg != "" {
+		os.Status = &otlpStatus{Code: _STATUS_CODE_ERROR, Message: s.errmsg}
+	}
+	return os
+}
+
+func encodeSpans(serviceName string, spans []*otlpSpan) ([]byte, error) {
+	return json.Marshal(&otlpExportRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource: otlpResource{Attributes: []otlpKeyValue{newOtlpKeyValue("service.name", serviceName)}},
+			ScopeSpans: []otlpScopeSpans{{
+				Scope: otlpScope{Name: "goworld"},
+				Spans: spans,
+			}},
+		}},
+	})
+}
+
+// Queue the span for exporting, spans are dropped if the exporter can not catch up
+func exportSpan(s *Span, endTime time.Time) {
+	select {
+	case exportQueue <- newOtlpSpan(s, endTime):
+	default:
+		gwlog.Warn("tracing: export queue is full, span %s dropped", s.name)
+	}
+}
+
+func exportRoutine(endpoint string, serviceName string) {
+	client := &http.Client{Timeout: _EXPORT_REQUEST_TIMEOUT}
+	ticker := time.NewTicker(_EXPORT_INTERVAL)
+	batch := make([]*otlpSpan, 0, _EXPORT_BATCH_SIZE)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		data, err := encodeSpans(serviceName, batch)
+		batch = batch[:0]
+		if err != nil {
+			gwlog.Error("tracing: encode spans failed: %s", err)
+			return
+		}
+
+		resp, err := client.Post(endpoint, "application/json", bytes.NewReader(data))
+		if err != nil {
+			gwlog.Warn("tracing: export spans to %s failed: %s", endpoint, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			gwlog.Warn("tracing: export spans to %s failed: %s", endpoint, resp.Status)
+		}
+	}
+
+	for {
+		select {
+		case s := <-exportQueue:
+			batch = append(batch, s)
+			if len(batch) >= _EXPORT_BATCH_SIZE {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package tracing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Distributed tracing of entity RPC chains
+//
+// A trace is started when an entity RPC is called by client or other games without trace context, and the span
+// context is propagated through calls to other entities and services, so that a client request fanning out across
+// games can be viewed as one trace. Spans are exported to an OpenTelemetry collector (or Jaeger / Tempo directly)
+// with OTLP/HTTP JSON.
+//
+// Spans are created and ended in the game routine, and the span context of the executing RPC is kept as current
+// span context, which is propagated to calls sent during the RPC.
+
+const (
+	SPAN_KIND_INTERNAL = 1
+	SPAN_KIND_SERVER   = 2 // handling RPC call
+	SPAN_KIND_CLIENT   = 3 // calling RPC and waiting for results
+
+	_SPAN_CONTEXT_SIZE = 16 + 8 + 1
+)
+
+var (
+	enabled     bool
+	sampleRatio float64
+	current     SpanContext // span context of the executing RPC in game routine
+
+	randLock sync.Mutex
+	idRand   = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+// Context of span which is propagated across games
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// Check if the span context is valid, span contexts are invalid if not traced
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Marshal the span context for propagation, empty if the span context is not valid
+func (sc SpanContext) Marshal() []byte {
+	if !sc.IsValid() {
+		return nil
+	}
+
+	data := make([]byte, _SPAN_CONTEXT_SIZE)
+	copy(data, sc.TraceID[:])
+	copy(data[16:], sc.SpanID[:])
+	if sc.Sampled {
+		data[24] = 1
+	}
+	return data
+}
+
+// Parse the span context marshaled by Marshal, returns invalid span context if data is invalid
+func ParseSpanContext(data []byte) (sc SpanContext) {
+	if len(data) != _SPAN_CONTEXT_SIZE {
+		return
+	}
+
+	copy(sc.TraceID[:], data)
+	copy(sc.SpanID[:], data[16:])
+	sc.Sampled = data[24] != 0
+	return
+}
+
+func (sc SpanContext) String() string {
+	return hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:])
+}
+
+// Span of an operation in trace, nil spans are valid spans which are not recorded
+type Span struct {
+	ctx       SpanContext
+	parent    SpanID
+	name      string
+	kind      int
+	startTime time.Time
+	attrs     map[string]string
+	errmsg    string
+}
+
+// Start a span as child of parent, or start a new trace by sample ratio if parent is not valid
+//
+// returns nil if tracing is not enabled
+func StartSpan(name string, kind int, parent SpanContext) *Span {
+	if !enabled {
+		return nil
+	}
+
+	s := &Span{
+		name:      name,
+		kind:      kind,
+		startTime: time.Now(),
+	}
+	if parent.IsValid() {
+		s.ctx.TraceID = parent.TraceID
+		s.ctx.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		randLock.Lock()
+		idRand.Read(s.ctx.TraceID[:])
+		s.ctx.Sampled = idRand.Float64() < sampleRatio
+		randLock.Unlock()
+	}
+	s.ctx.SpanID = newSpanID()
+	return s
+}
+
+func newSpanID() (id SpanID) {
+	randLock.Lock()
+	binary.LittleEndian.PutUint64(id[:], idRand.Uint64()|1) // never be zero
+	randLock.Unlock()
+	return
+}
+
+// Get the span context for propagation, invalid if span is nil
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.ctx
+}
+
+// Set the attribute of span
+func (s *Span) SetAttr(key string, val string) {
+	if s == nil || !s.ctx.Sampled {
+		return
+	}
+	if s.attrs == nil {
+		s.attrs = map[string]string{}
+	}
+	s.attrs[key] = val
+}
+
+// Mark the span as failed with error message
+func (s *Span) SetError(errmsg string) {
+	if s == nil {
+		return
+	}
+	s.errmsg = errmsg
+}
+
+// End the span, which is exported if sampled
+func (s *Span) End() {
+	if s == nil || !s.ctx.Sampled {
+		return
+	}
+	exportSpan(s, time.Now())
+}
+
+// Get the current span context of game routine
+func Current() SpanContext {
+	return current
+}
+
+// Set the current span context of game routine, returns the previous one for restoring
+func SetCurrent(sc SpanContext) SpanContext {
+	prev := current
+	current = sc
+	return prev
+}
+
+// Enable tracing and export spans to the OTLP/HTTP endpoint, e.g. http://127.0.0.1:4318/v1/traces
+//
+// New traces are sampled by ratio in [0, 1], traces propagated from other games follow their sampling decisions
+func Setup(endpoint string, serviceName string, ratio float64) {
+	if endpoint == "" {
+		gwlog.Info("tracing not enabled")
+		return
+	}
+
+	enabled = true
+	sampleRatio = ratio
+	gwlog.Info("tracing enabled: exporting spans of %s to %s, sample ratio = %v", serviceName, endpoint, ratio)
+	go exportRoutine(endpoint, serviceName)
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSpanContextMarshal(t *testing.T) {
+	if data := (SpanContext{}).Marshal(); len(data) != 0 {
+		t.Fatalf("invalid span context should be marshaled to empty, but got %v", data)
+	}
+	if sc := ParseSpanContext(nil); sc.IsValid() {
+		t.Fatalf("empty data should be parsed to invalid span context")
+	}
+
+	sc := SpanContext{Sampled: true}
+	sc.TraceID[0], sc.TraceID[15] = 1, 2
+	sc.SpanID[7] = 3
+	if parsed := ParseSpanContext(sc.Marshal()); parsed != sc {
+		t.Fatalf("span context %v is parsed as %v", sc, parsed)
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	if s := StartSpan("disabled", SPAN_KIND_SERVER, SpanContext{}); s != nil {
+		t.Fatalf("span should be nil when tracing is not enabled")
+	}
+	var s *Span
+	s.SetAttr("key", "val")
+	s.End()
+
+	enabled, sampleRatio = true, 1
+	defer func() { enabled, sampleRatio = false, 0 }()
+
+	root := StartSpan("root", SPAN_KIND_SERVER, SpanContext{})
+	if !root.Context().IsValid() || !root.Context().Sampled {
+		t.Fatalf("root span should be valid and sampled: %v", root.Context())
+	}
+	child := StartSpan("child", SPAN_KIND_CLIENT, root.Context())
+	if child.Context().TraceID != root.Context().TraceID || child.parent != root.Context().SpanID {
+		t.Fatalf("child span %v should be in trace of root %v", child.Context(), root.Context())
+	}
+	if child.Context().SpanID == root.Context().SpanID {
+		t.Fatalf("child span should have new span ID")
+	}
+
+	sampleRatio = 0
+	if s := StartSpan("unsampled", SPAN_KIND_SERVER, SpanContext{}); s.Context().Sampled {
+		t.Fatalf("span should not be sampled with ratio 0")
+	}
+	if s := StartSpan("child", SPAN_KIND_SERVER, root.Context()); !s.Context().Sampled {
+		t.Fatalf("child span should follow sampling decision of parent")
+	}
+}
+
+func TestEncodeSpans(t *testing.T) {
+	enabled, sampleRatio = true, 1
+	defer func() { enabled, sampleRatio = false, 0 }()
+
+	root := StartSpan("Avatar.Login", SPAN_KIND_SERVER, SpanContext{})
+	child := StartSpan("Account.Check", SPAN_KIND_CLIENT, root.Context())
+	child.SetAttr("goworld.entity_id", "eid")
+	child.SetError("timeout")
+
+	now := time.Now()
+	data, err := encodeSpans("game1", []*otlpSpan{newOtlpSpan(root, now), newOtlpSpan(child, now)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req otlpExportRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 || spans[0].ParentSpanID != "" || spans[1].ParentSpanID != spans[0].SpanID || spans[0].TraceID != spans[1].TraceID {
+		t.Fatalf("wrong spans: %s", data)
+	}
+	if len(spans[0].TraceID) != 32 || len(spans[0].SpanID) != 16 {
+		t.Fatalf("trace ID and span ID should be hex encoded: %s", data)
+	}
+	if spans[1].Status == nil || spans[1].Status.Code != _STATUS_CODE_ERROR || len(spans[1].Attributes) != 1 {
+		t.Fatalf("wrong status or attributes: %s", data)
+	}
+}
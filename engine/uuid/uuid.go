@@ -8,13 +8,15 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"sync/atomic"
 	"time"
 )
 
 const (
-	UUID_LENGTH = 16
-	encodeUUID  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_."
+	UUID_LENGTH      = 16
+	NAMESPACE_MARKER = "." // the first char of namespaced UUIDs
+	encodeUUID       = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_."
 )
 
 var (
@@ -70,3 +72,31 @@ func readMachineId() []byte {
 	copy(id, hw.Sum(nil))
 	return id
 }
+
+// Generate UUID in the namespace, which is ns in [1, 63] encoded in the second char after the '.' marker
+//
+// UUIDs generated by GenUUID never start with '.' before year 2106, so namespaced UUIDs can be told from them. The
+// remaining 84 bits are the timestamp (32 bits), machine (16 bits), pid (12 bits) and counter (24 bits).
+func GenNamespacedUUID(ns byte) string {
+	var b = make([]byte, 11)
+	binary.BigEndian.PutUint32(b[:], uint32(time.Now().Unix()))
+	b[4] = machineId[0]
+	b[5] = machineId[1]
+	pid := os.Getpid() & 0xfff
+	i := atomic.AddUint32(&objectIdCounter, 1) & 0xffffff
+	b[6] = byte(pid >> 4)
+	b[7] = byte(pid<<4) | byte(i>>20)
+	b[8] = byte(i >> 12)
+	b[9] = byte(i >> 4)
+	b[10] = byte(i << 4) // the last 4 bits are not encoded
+
+	return NAMESPACE_MARKER + encodeUUID[ns:ns+1] + UUIDEncoding.EncodeToString(b)[:UUID_LENGTH-2]
+}
+
+// Get the namespace of UUID, returns 0 if the UUID is not namespaced
+func NamespaceOf(uuid string) byte {
+	if len(uuid) != UUID_LENGTH || uuid[:1] != NAMESPACE_MARKER {
+		return 0
+	}
+	return byte(strings.IndexByte(encodeUUID, uuid[1]))
+}
@@ -17,3 +17,20 @@ func BenchmarkGenUUID(b *testing.B) {
 		GenUUID()
 	}
 }
+
+func TestGenNamespacedUUID(t *testing.T) {
+	if ns := NamespaceOf(GenUUID()); ns != 0 {
+		t.Fatalf("UUID should not be namespaced, but got namespace %d", ns)
+	}
+
+	seen := map[string]bool{}
+	for ns := byte(1); ns < 64; ns++ {
+		for i := 0; i < 100; i++ {
+			uuid := GenNamespacedUUID(ns)
+			if len(uuid) != UUID_LENGTH || NamespaceOf(uuid) != ns || seen[uuid] {
+				t.Fatalf("wrong UUID %s in namespace %d", uuid, ns)
+			}
+			seen[uuid] = true
+		}
+	}
+}
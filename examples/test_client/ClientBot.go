@@ -64,7 +64,16 @@ func (bot *ClientBot) run() {
 	var netconn net.Conn
 	var err error
 	for { // retry for ever
-		netconn, err = netutil.ConnectTCP(serverAddr, cfg.Port)
+		if useKCP {
+			netconn, err = netutil.ConnectKCP(serverAddr, cfg.KCPPort, netutil.KCPOptions{
+				MTU:        cfg.KCPMTU,
+				SendWindow: cfg.KCPSendWindow,
+				RecvWindow: cfg.KCPRecvWindow,
+				NoDelay:    cfg.KCPNoDelay,
+			})
+		} else {
+			netconn, err = netutil.ConnectTCP(serverAddr, cfg.Port)
+		}
 		if err != nil {
 			gwlog.Error("Connect failed: %s", err)
 			time.Sleep(time.Second * time.Duration(1+rand.Intn(10)))
@@ -73,8 +82,10 @@ func (bot *ClientBot) run() {
 		// connected , ok
 		break
 	}
-	netconn.(*net.TCPConn).SetWriteBuffer(64 * 1024)
-	netconn.(*net.TCPConn).SetReadBuffer(64 * 1024)
+	if tcpConn, ok := netconn.(*net.TCPConn); ok {
+		tcpConn.SetWriteBuffer(64 * 1024)
+		tcpConn.SetReadBuffer(64 * 1024)
+	}
 	gwlog.Info("connected: %s", netconn.RemoteAddr())
 
 	var conn netutil.Connection = netutil.NetConnection{netconn}
@@ -226,6 +237,21 @@ func (bot *ClientBot) handlePacket(msgtype proto.MsgType_t, packet *netutil.Pack
 			gwlog.Debug("Call entity %s.%s(%v)", entityID, method, args)
 		}
 		bot.callEntityMethod(entityID, method, args)
+	} else if msgtype == proto.MT_CALL_ENTITY_METHOD_ON_CLIENT_ACKED {
+		seq := packet.ReadUint32()
+		entityID := packet.ReadEntityID()
+		method := packet.ReadVarStr()
+		args := packet.ReadArgs()
+		if !quiet {
+			gwlog.Debug("Call entity %s.%s(%v) acked: seq=%d", entityID, method, args, seq)
+		}
+		bot.callEntityMethod(entityID, method, args)
+		bot.conn.SendAckClientMessage(seq)
+	} else if msgtype == proto.MT_SET_CLIENT_SESSION {
+		_ = packet.ReadVarStr() // session token, bots never resume sessions
+	} else if msgtype == proto.MT_NOTIFY_GATE_DRAINING {
+		message := packet.ReadVarStr()
+		gwlog.Warn("%s: gate is draining: %s", bot, message)
 	} else if msgtype == proto.MT_CALL_FILTERED_CLIENTS {
 		_ = packet.ReadVarStr() // ignore key
 		_ = packet.ReadVarStr() // ignore val
@@ -247,6 +273,10 @@ func (bot *ClientBot) handlePacket(msgtype proto.MsgType_t, packet *netutil.Pack
 		entityID := packet.ReadEntityID()
 		yaw := entity.Yaw(packet.ReadFloat32())
 		bot.updateEntityYaw(entityID, yaw)
+	} else if msgtype == proto.MT_KICK_CLIENT {
+		reason := proto.KickReason(packet.ReadUint16())
+		message := packet.ReadVarStr()
+		gwlog.Warn("%s: kicked by server: reason=%d, message=%s", bot, reason, message)
 	} else if msgtype == proto.MT_SYNC_POSITION_YAW_ON_CLIENTS {
 		for packet.HasUnreadPayload() {
 			entityID := packet.ReadEntityID()
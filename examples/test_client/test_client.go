@@ -17,6 +17,7 @@ var (
 	quiet      bool
 	configFile string
 	serverAddr string
+	useKCP     bool
 	N          int
 )
 
@@ -25,6 +26,7 @@ func parseArgs() {
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
 	flag.IntVar(&N, "N", 1000, "Number of clients")
 	flag.StringVar(&serverAddr, "server", "localhost", "replace server address")
+	flag.BoolVar(&useKCP, "kcp", false, "connect to the KCP port of gate")
 	flag.Parse()
 }
 
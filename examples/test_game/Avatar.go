@@ -125,10 +125,6 @@ func (a *Avatar) OnEnterSpace() {
 	}
 }
 
-func (a *Avatar) GetSpaceID(callerID common.EntityID) {
-	a.Call(callerID, "OnGetAvatarSpaceID", a.ID, a.Space.ID)
-}
-
 func (a *Avatar) OnDestroy() {
 	a.CallService("OnlineService", "CheckOut", a.ID)
 }
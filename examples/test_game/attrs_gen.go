@@ -0,0 +1,99 @@
+// Code generated by gwtool gen-attrs. DO NOT EDIT.
+
+package main
+
+import "github.com/xiaonanln/goworld/engine/entity"
+
+// Typed attribute accessors of entity type Avatar
+
+func (a *Avatar) GetExp() int {
+	return a.Attrs.GetInt("exp")
+}
+
+func (a *Avatar) SetExp(v int) {
+	a.Attrs.Set("exp", v)
+}
+
+func (a *Avatar) GetLastMailID() int {
+	return a.Attrs.GetInt("lastMailID")
+}
+
+func (a *Avatar) SetLastMailID(v int) {
+	a.Attrs.Set("lastMailID", v)
+}
+
+func (a *Avatar) GetLevel() int {
+	return a.Attrs.GetInt("level")
+}
+
+func (a *Avatar) SetLevel(v int) {
+	a.Attrs.Set("level", v)
+}
+
+func (a *Avatar) SetMails(v *entity.MapAttr) {
+	a.Attrs.Set("mails", v)
+}
+
+func (a *Avatar) GetName() string {
+	return a.Attrs.GetStr("name")
+}
+
+func (a *Avatar) SetName(v string) {
+	a.Attrs.Set("name", v)
+}
+
+func (a *Avatar) GetProf() int {
+	return a.Attrs.GetInt("prof")
+}
+
+func (a *Avatar) SetProf(v int) {
+	a.Attrs.Set("prof", v)
+}
+
+func (a *Avatar) GetSpaceKind() int {
+	return a.Attrs.GetInt("spaceKind")
+}
+
+func (a *Avatar) SetSpaceKind(v int) {
+	a.Attrs.Set("spaceKind", v)
+}
+
+func (a *Avatar) GetTestListField() *entity.ListAttr {
+	return a.Attrs.GetListAttr("testListField")
+}
+
+func (a *Avatar) SetTestListField(v *entity.ListAttr) {
+	a.Attrs.Set("testListField", v)
+}
+
+func (a *Avatar) GetTestListFieldAt(index int) int {
+	return a.Attrs.GetListAttr("testListField").GetInt(index)
+}
+
+func (a *Avatar) SetTestListFieldAt(index int, v int) {
+	a.Attrs.GetListAttr("testListField").Set(index, v)
+}
+
+func (a *Avatar) AppendTestListField(v int) {
+	a.Attrs.GetListAttr("testListField").Append(v)
+}
+
+// Typed attribute accessors of entity type MailService
+
+func (m *MailService) GetLastMailID() int {
+	return m.Attrs.GetInt("lastMailID")
+}
+
+func (m *MailService) SetLastMailID(v int) {
+	m.Attrs.Set("lastMailID", v)
+}
+
+// Typed attribute accessors of entity type Monster
+
+func (m *Monster) GetName() string {
+	return m.Attrs.GetStr("name")
+}
+
+func (m *Monster) SetName(v string) {
+	m.Attrs.Set("name", v)
+}
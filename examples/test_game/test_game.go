@@ -1,3 +1,5 @@
+//go:generate gwtool gen-attrs
+
 package main
 
 import (
@@ -30,27 +32,27 @@ func main() {
 	goworld.RegisterSpace(&MySpace{}) // Register the space type
 
 	// Register each entity types
-	goworld.RegisterEntity("Account", &Account{})
+	goworld.RegisterEntity("Account", &Account{}).SetAvatarType("Avatar")
 	goworld.RegisterEntity("OnlineService", &OnlineService{})
 	goworld.RegisterEntity("SpaceService", &SpaceService{})
 	goworld.RegisterEntity("MailService", &MailService{}).DefineAttrs(map[string][]string{
-		"lastMailID": {"Persistent"},
+		"lastMailID": {"Persistent", "int"},
 	})
 
 	// Register Monster type and define attributes
 	goworld.RegisterEntity("Monster", &Monster{}).DefineAttrs(map[string][]string{
-		"name": {"AllClients"},
+		"name": {"AllClients", "string"},
 	})
 	// Register Avatar type and define attributes
 	goworld.RegisterEntity("Avatar", &Avatar{}).DefineAttrs(map[string][]string{
-		"name":          {"AllClients", "Persistent"},
-		"level":         {"AllClients", "Persistent"},
-		"prof":          {"AllClients", "Persistent"},
-		"exp":           {"Client", "Persistent"},
-		"spaceKind":     {"Persistent"},
-		"lastMailID":    {"Persistent"},
-		"mails":         {"Client", "Persistent"},
-		"testListField": {"AllClients"},
+		"name":          {"AllClients", "Persistent", "string"},
+		"level":         {"AllClients", "Persistent", "int"},
+		"prof":          {"AllClients", "Persistent", "int"},
+		"exp":           {"Client", "Persistent", "int"},
+		"spaceKind":     {"Persistent", "int"},
+		"lastMailID":    {"Persistent", "int"},
+		"mails":         {"Client", "Persistent", "MapAttr"},
+		"testListField": {"AllClients", "ListAttr<int>"},
 	})
 
 	// Run the game server
@@ -1,10 +1,19 @@
 package goworld
 
 import (
+	"time"
+
 	"github.com/xiaonanln/goworld/components/game"
+	"github.com/xiaonanln/goworld/engine/analytics"
+	"github.com/xiaonanln/goworld/engine/calendar"
 	. "github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/datatable"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwrand"
+	"github.com/xiaonanln/goworld/engine/gwvar"
+	"github.com/xiaonanln/goworld/engine/idip"
 	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/storage"
 )
@@ -42,6 +51,194 @@ func CreateSpaceLocally(kind int) EntityID {
 	return entity.CreateSpaceLocally(kind)
 }
 
+// Set the max player count of each space of the kind, 0 means no limit
+//
+// Entity.EnterSpaceOfKind creates overflow instances of the kind when all spaces of the kind are full
+func SetSpaceKindMaxPlayers(kind int, maxPlayers int) {
+	entity.SetSpaceKindMaxPlayers(kind, maxPlayers)
+}
+
+// Set the placement constraint of games for creating spaces of the kind anywhere, e.g. "region=eu"
+func SetSpaceKindPlacement(kind int, placement string) {
+	entity.SetSpaceKindPlacement(kind, placement)
+}
+
+// Load the navmesh of space kind from file in Recast/Detour binary formats
+//
+// Space.FindPath and Entity.NavigateTo find paths on the navmesh of space kind
+func LoadSpaceKindNavMesh(kind int, path string) error {
+	return entity.LoadSpaceKindNavMesh(kind, path)
+}
+
+// Get all spaces of the kind in the local game server
+func GetSpaceInstances(kind int) []*entity.Space {
+	return entity.GetSpaceInstances(kind)
+}
+
+// Register the space kind as replayable
+//
+// All inputs of spaces of this kind are recorded and executed at deterministic ticks
+func RegisterReplayableSpaceKind(kind int, tickInterval time.Duration) {
+	entity.RegisterReplayableSpaceKind(kind, tickInterval)
+}
+
+// Create a space in the local game server to re-simulate the replay record
+//
+// returns the space EntityID
+func CreateReplaySpaceLocally(record *entity.ReplayRecord) EntityID {
+	return entity.CreateReplaySpaceLocally(record)
+}
+
+// Register the entry queue service which admits at most capacity clients at the same time
+//
+// Should be called on all game servers, and other clients entering the queue wait in order
+func RegisterEntryQueueService(capacity int) {
+	entity.RegisterEntryQueueService(capacity)
+}
+
+// Create the entry queue service in any game server, should be called only once in the cluster
+func CreateEntryQueueServiceAnywhere() {
+	entity.CreateEntryQueueServiceAnywhere()
+}
+
+// Change the capacity of the entry queue service
+func SetEntryQueueCapacity(capacity int) {
+	entity.SetEntryQueueCapacity(capacity)
+}
+
+// Register the space instance service which creates, pools and recycles space instances by kind, e.g. dungeons
+//
+// Should be called on all game servers
+func RegisterSpaceInstanceService() {
+	entity.RegisterSpaceInstanceService()
+}
+
+// Create the space instance service in any game server, should be called only once in the cluster
+func CreateSpaceInstanceServiceAnywhere() {
+	entity.CreateSpaceInstanceServiceAnywhere()
+}
+
+// Set the policy of space instances of the kind, including max instances, pool size and TTL after players leave
+//
+// Should be called on all game servers, entities request instances by Entity.RequestSpaceInstance
+func SetSpaceInstancePolicy(kind int, policy entity.SpaceInstancePolicy) {
+	entity.SetSpaceInstancePolicy(kind, policy)
+}
+
+// Release the space instance immediately instead of waiting for TTL after players leave
+func ReleaseSpaceInstance(spaceID EntityID) {
+	entity.ReleaseSpaceInstance(spaceID)
+}
+
+// Register the persistent timer service which fires timers saved in KVDB as entity RPCs
+//
+// Should be called on all game servers
+func RegisterPersistentTimerService() {
+	entity.RegisterPersistentTimerService()
+}
+
+// Create the persistent timer service in any game server, should be called only once in the cluster
+func CreatePersistentTimerServiceAnywhere() {
+	entity.CreatePersistentTimerServiceAnywhere()
+}
+
+// Add a timer calling the method of the target entity at fire time, which survives crashes and restarts of games
+//
+// If typeName is not empty, the target entity is loaded before the call if it is not loaded
+func AddPersistentTimer(fireTime time.Time, target EntityID, typeName string, method string, args ...interface{}) entity.PersistentTimerID {
+	return entity.AddPersistentTimer(fireTime, target, typeName, method, args...)
+}
+
+// Cancel the persistent timer
+func CancelPersistentTimer(id entity.PersistentTimerID) {
+	entity.CancelPersistentTimer(id)
+}
+
+// Register the chat service which routes world, channel and private chat messages to clients
+//
+// Should be called on all game servers
+func RegisterChatService() {
+	entity.RegisterChatService()
+}
+
+// Create the chat service in any game server, should be called only once in the cluster
+func CreateChatServiceAnywhere() {
+	entity.CreateChatServiceAnywhere()
+}
+
+// Set the filter of chat messages, e.g. a profanity filter, should be called on all game servers
+func SetChatFilter(filter entity.ChatFilter) {
+	entity.SetChatFilter(filter)
+}
+
+// Limit each sender to send at most maxMessages chat messages per duration, should be called on all game servers
+func SetChatRateLimit(maxMessages int, per time.Duration) {
+	entity.SetChatRateLimit(maxMessages, per)
+}
+
+// Register the mail service which keeps mailboxes of entities in storage for offline delivery
+//
+// Should be called on all game servers
+func RegisterMailService() {
+	entity.RegisterMailService()
+}
+
+// Create the mail service in any game server, should be called only once in the cluster
+func CreateMailServiceAnywhere() {
+	entity.CreateMailServiceAnywhere()
+}
+
+// Send the mail to the target entity, which receives the mail when it is online or loaded next time
+func SendMail(target EntityID, mail *entity.Mail) entity.MailID {
+	return entity.SendMail(target, mail)
+}
+
+// Register the matchmaking service which forms matches of entities and creates battle spaces
+//
+// Should be called on all game servers
+func RegisterMatchmakingService() {
+	entity.RegisterMatchmakingService()
+}
+
+// Create the matchmaking service in any game server, should be called only once in the cluster
+func CreateMatchmakingServiceAnywhere() {
+	entity.CreateMatchmakingServiceAnywhere()
+}
+
+// Register the matchmaking queue, a space of the kind is created for each match formed by the matcher
+//
+// Should be called on all game servers
+func RegisterMatchmakingQueue(queue string, spaceKind int, matcher entity.Matcher) {
+	entity.RegisterMatchmakingQueue(queue, spaceKind, matcher)
+}
+
+// Register the rank service which keeps leaderboards in Redis sorted sets, or in memory if rank is not configured
+//
+// Should be called on all game servers
+func RegisterRankService() {
+	entity.RegisterRankService()
+}
+
+// Create the rank service in any game server, should be called only once in the cluster
+func CreateRankServiceAnywhere() {
+	entity.CreateRankServiceAnywhere()
+}
+
+// Set the score of member in the board of the rank service
+func UpdateRankScore(board string, member string, score float64) {
+	entity.UpdateRankScore(board, member, score)
+}
+
+// Increase the score of member in the board of the rank service by delta
+func IncrRankScore(board string, member string, delta float64) {
+	entity.IncrRankScore(board, member, delta)
+}
+
+// Reload Lua scripts of all entity types immediately, scripts are also reloaded when the files are modified
+func ReloadScripts() error {
+	return entity.ReloadScripts()
+}
+
 // Create a entity on the local server
 //
 // returns EntityID
@@ -54,16 +251,54 @@ func CreateEntityAnywhere(typeName string) {
 	entity.CreateEntityAnywhere(typeName)
 }
 
+// Create a entity on any server, the callback is called with the EntityID of created entity or the error
+func CreateEntityAnywhereWithCallback(typeName string, data map[string]interface{}, callback entity.CreateEntityCallback) {
+	entity.CreateEntityAnywhereWithCallback(typeName, data, callback)
+}
+
+// Create a entity on any server matching the placement constraint, e.g. "region=eu,tier=premium"
+func CreateEntityAnywhereWithPlacement(typeName string, data map[string]interface{}, placement string, callback entity.CreateEntityCallback) {
+	entity.CreateEntityAnywhereWithPlacement(typeName, data, placement, callback)
+}
+
 // Load the specified entity from entity storage
+//
+// Loading the entity which is already loaded or loading is ignored, and the entity is never loaded on two games.
+// The game where the entity is already on is passed to the callback set by entity.SetEntityAlreadyLoadedCallback.
 func LoadEntityAnywhere(typeName string, entityID EntityID) {
 	entity.LoadEntityAnywhere(typeName, entityID)
 }
 
+// Load the specified entity from entity storage on this game, the callback is called with the error if failed
+func LoadEntityLocallyWithCallback(typeName string, entityID EntityID, callback entity.CreateEntityCallback) {
+	entity.LoadEntityLocallyWithCallback(typeName, entityID, callback)
+}
+
+// Migrate the entities (e.g. a party and their pets) to the target game atomically, either all of them arrive or none do
+func MigrateGroupTo(gameID uint16, entities []*entity.Entity) error {
+	return entity.MigrateGroupTo(gameID, entities)
+}
+
 // Get the set of EntityIDs that provides the specified service
 func GetServiceProviders(serviceName string) entity.EntityIDSet {
 	return entity.GetServiceProviders(serviceName)
 }
 
+// Start a cluster save point which saves entities of all games at a mutually consistent point
+//
+// Cross-game entity calls are held until all entities are saved and the marker is recorded in KVDB
+func StartClusterSavePoint(label string, callback entity.ClusterSavePointCallback) {
+	entity.StartClusterSavePoint(label, callback)
+}
+
+// Set the strategy of choosing provider when calling the service
+//
+// Builtin strategies: entity.RandomServiceStrategy (default), entity.RoundRobinServiceStrategy,
+// entity.LeastLoadedServiceStrategy and entity.ConsistentHashServiceStrategy
+func SetServiceStrategy(serviceName string, strategy entity.ServiceStrategy) {
+	entity.SetServiceStrategy(serviceName, strategy)
+}
+
 // Get all saved entity ids in storage, may take long time and block the main routine
 //
 // returns result in callback
@@ -71,6 +306,21 @@ func ListEntityIDs(typeName string, callback storage.ListCallbackFunc) {
 	storage.ListEntityIDs(typeName, callback)
 }
 
+// Find IDs of saved entities with attributes equal to values in the filter, e.g. find accounts by name
+//
+// Attributes in the filter are paths like "name" or "profile.level", at most limit entities are found if limit > 0.
+// Storages without query support (see storage.IsQuerySupported) read all entities of the type to find them.
+//
+// returns result in callback
+func QueryEntityIDs(typeName string, filter map[string]interface{}, limit int, callback storage.ListCallbackFunc) {
+	storage.Query(typeName, filter, limit, callback)
+}
+
+// Create index of the attribute path in entity storage for QueryEntityIDs, should be called on all game servers
+func EnsureStorageIndex(typeName string, attr string) {
+	storage.EnsureIndex(typeName, attr)
+}
+
 // Check if entityID exists in entity storage
 //
 // returns result in callback
@@ -83,6 +333,120 @@ func GetEntity(id EntityID) *entity.Entity {
 	return entity.GetEntity(id)
 }
 
+// Get the recent client-originated RPCs of the entity, if client audit is enabled for the entity type
+func GetClientAuditTrail(id EntityID) []entity.ClientAuditRecord {
+	return entity.GetClientAuditTrail(id)
+}
+
+// Enable or disable the cluster-wide maintenance mode
+//
+// Only logins in allowlist are allowed during maintenance, see Entity.RegisterLoginSession
+func SetMaintenanceMode(enabled bool, message string, allowlist []string) {
+	entity.SetMaintenanceMode(entity.MaintenanceMode{Enabled: enabled, Message: message, Allowlist: allowlist})
+}
+
+// Set the entity type of players for IDIP requests, offline players are loaded when requested
+func SetIDIPPlayerType(typeName string) {
+	idip.SetPlayerType(typeName)
+}
+
+// Register the IDIP command which is translated to the method of player entity
+func RegisterIDIPCommand(cmd string, method string) {
+	idip.RegisterCommand(cmd, method)
+}
+
+// Add or replace the scheduled event of the whole cluster
+func AddCalendarEvent(ev calendar.Event, callback kvdb.KVDBPutCallback) {
+	calendar.AddEvent(ev, callback)
+}
+
+// Remove the scheduled event of the whole cluster
+func RemoveCalendarEvent(name string, callback kvdb.KVDBPutCallback) {
+	calendar.RemoveEvent(name, callback)
+}
+
+// Get the global variable of the whole cluster
+func GetGlobalVar(name string) (interface{}, bool) {
+	return gwvar.Get(name)
+}
+
+// Set the global variable of the whole cluster, all games are notified after saved
+func SetGlobalVar(name string, value interface{}, callback kvdb.KVDBPutCallback) {
+	gwvar.Set(name, value, callback)
+}
+
+// Watch changes of the global variable, or all global variables if name is empty
+func WatchGlobalVar(name string, cb gwvar.WatchCallback) gwvar.Handle {
+	return gwvar.Watch(name, cb)
+}
+
+// Register the data table loaded from CSV, Excel or JSON file into structs of the type of rowPrototype, indexed by
+// the field keyField
+//
+// Should be called on all game servers before running
+func RegisterDataTable(name string, path string, rowPrototype interface{}, keyField string) *datatable.Table {
+	return datatable.Register(name, path, rowPrototype, keyField)
+}
+
+// Get the registered data table, returns nil if not found
+func GetDataTable(name string) *datatable.Table {
+	return datatable.GetTable(name)
+}
+
+// Reload the data table on all game servers
+func ReloadDataTable(name string) {
+	datatable.Reload(name)
+}
+
+// Call the method of entity and get the return values through the returned future
+func CallWithResult(id EntityID, method string, args ...interface{}) *entity.RpcFuture {
+	return entity.CallWithResult(id, method, args...)
+}
+
+// Call the method of entity with timeout and get the return values through the returned future
+func CallWithResultTimeout(id EntityID, method string, timeout time.Duration, args ...interface{}) *entity.RpcFuture {
+	return entity.CallWithResultTimeout(id, method, timeout, args...)
+}
+
+// Create a random number stream with logged seed, rolls can be reproduced with the seed in audits
+func NewRandStream(name string) *gwrand.Stream {
+	return gwrand.NewStream(name)
+}
+
+// Start the entity profiler which samples currently executing entity methods
+func StartEntityProfiler() error {
+	return entity.StartEntityProfiler()
+}
+
+// Stop the entity profiler and returns the profile, which can be written in folded stack format for flame graphs
+func StopEntityProfiler() *entity.EntityProfile {
+	return entity.StopEntityProfiler()
+}
+
+// Set load shedding tiers in order of increasing severity, policies of the most severe tier exceeded are applied
+func SetLoadSheddingTiers(tiers []entity.LoadSheddingTier) {
+	entity.SetLoadSheddingTiers(tiers)
+}
+
+// Get the name of active load shedding tier, returns empty string if no tier is active
+func GetLoadSheddingTier() string {
+	return entity.GetLoadSheddingTier()
+}
+
+// Set the CPU budget per second of all entity types, timers of entities exceeding the budget are deferred
+//
+// Entity types can override the budget by EntityTypeDesc.SetCPUBudget, 0 for no budget (default)
+func SetEntityCPUBudget(budget time.Duration) {
+	entity.SetEntityCPUBudget(budget)
+}
+
+// Set the time slice of execution group as the max fraction of main loop time, 0 for unlimited
+//
+// Entities are assigned to execution groups by EntityTypeDesc.SetExecGroup or Entity.SetExecGroup
+func SetExecGroupSlice(group string, slice float64) {
+	entity.SetExecGroupSlice(group, slice)
+}
+
 // Get the local server ID
 //
 // server ID is a uint16 number starts from 1, which should be different for each servers
@@ -113,11 +477,26 @@ func Entities() entity.EntityMap {
 	return entity.Entities()
 }
 
+// Get IDs of local entities of the type as an EntityIDSet (do not modify it!)
+func GetEntitiesByType(typeName string) entity.EntityIDSet {
+	return entity.GetEntitiesByType(typeName)
+}
+
+// Get local entities with the tag as an EntitySet (do not modify it!)
+func GetEntitiesByTag(tag string) entity.EntitySet {
+	return entity.GetEntitiesByTag(tag)
+}
+
 // Post a callback to be executed
 func Post(callback post.PostCallback) {
 	post.Post(callback)
 }
 
+// Register analytics sink type, e.g. a Kafka producer, which can be configured by analytics_sink
+func RegisterAnalyticsSink(sinkType string, factory analytics.SinkFactory) {
+	analytics.RegisterSink(sinkType, factory)
+}
+
 // Get from KVDB
 func GetKVDB(key string, callback kvdb.KVDBGetCallback) {
 	kvdb.Get(key, callback)
@@ -127,3 +506,23 @@ func GetKVDB(key string, callback kvdb.KVDBGetCallback) {
 func PutKVDB(key string, val string, callback kvdb.KVDBPutCallback) {
 	kvdb.Put(key, val, callback)
 }
+
+// Increase the integer value of KVDB key by delta atomically, the callback is called with the new value
+func IncrKVDB(key string, delta int64, callback kvdb.KVDBIncrCallback) {
+	kvdb.Incr(key, delta, callback)
+}
+
+// Watch changes of KVDB keys with the prefix made by all games, returns the handle for unwatching
+func WatchKVDB(prefix string, callback kvdb.KVDBWatchCallback) kvdb.WatchHandle {
+	return kvdb.Watch(prefix, callback)
+}
+
+// Compare-and-set in KVDB, the value of key is set to newVal only if the current value is oldVal
+func CASKVDB(key string, oldVal string, newVal string, callback kvdb.KVDBTransactCallback) {
+	kvdb.CAS(key, oldVal, newVal, callback)
+}
+
+// Put multiple keys in KVDB atomically only if the current values of keys in checks equal to the expected values
+func TransactKVDB(checks []kvdb_types.KVItem, puts []kvdb_types.KVItem, callback kvdb.KVDBTransactCallback) {
+	kvdb.Transact(checks, puts, callback)
+}